@@ -0,0 +1,4 @@
+// Package reaiv1 holds the protoc-generated code of the reai.v1.ReAI service.
+package reaiv1
+
+//go:generate protoc -I ../.. --go_out=../.. --go_opt=paths=source_relative --go-grpc_out=../.. --go-grpc_opt=paths=source_relative reai/v1/reai.proto
@@ -0,0 +1,296 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             (unknown)
+// source: reai/v1/reai.proto
+
+// ReAI gRPC service. Mirrors the OpenAI-compatible HTTP API:
+// /v1/models, /v1/completions and /v1/chat/completions.
+//
+// Authenticate with the usual API key in the "authorization: Bearer <key>"
+// metadata entry. Errors map to standard gRPC status codes.
+
+package reaiv1
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	ReAI_ListModels_FullMethodName     = "/reai.v1.ReAI/ListModels"
+	ReAI_Complete_FullMethodName       = "/reai.v1.ReAI/Complete"
+	ReAI_StreamComplete_FullMethodName = "/reai.v1.ReAI/StreamComplete"
+	ReAI_Chat_FullMethodName           = "/reai.v1.ReAI/Chat"
+	ReAI_StreamChat_FullMethodName     = "/reai.v1.ReAI/StreamChat"
+)
+
+// ReAIClient is the client API for ReAI service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type ReAIClient interface {
+	// ListModels returns the models available to the server
+	ListModels(ctx context.Context, in *ListModelsRequest, opts ...grpc.CallOption) (*ListModelsResponse, error)
+	// Complete returns a code completion
+	Complete(ctx context.Context, in *CompletionRequest, opts ...grpc.CallOption) (*CompletionResponse, error)
+	// StreamComplete streams a code completion as it is generated
+	StreamComplete(ctx context.Context, in *CompletionRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[CompletionChunk], error)
+	// Chat returns a chat completion
+	Chat(ctx context.Context, in *ChatRequest, opts ...grpc.CallOption) (*ChatResponse, error)
+	// StreamChat streams a chat completion as it is generated
+	StreamChat(ctx context.Context, in *ChatRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[ChatChunk], error)
+}
+
+type reAIClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewReAIClient(cc grpc.ClientConnInterface) ReAIClient {
+	return &reAIClient{cc}
+}
+
+func (c *reAIClient) ListModels(ctx context.Context, in *ListModelsRequest, opts ...grpc.CallOption) (*ListModelsResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListModelsResponse)
+	err := c.cc.Invoke(ctx, ReAI_ListModels_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *reAIClient) Complete(ctx context.Context, in *CompletionRequest, opts ...grpc.CallOption) (*CompletionResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(CompletionResponse)
+	err := c.cc.Invoke(ctx, ReAI_Complete_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *reAIClient) StreamComplete(ctx context.Context, in *CompletionRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[CompletionChunk], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &ReAI_ServiceDesc.Streams[0], ReAI_StreamComplete_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[CompletionRequest, CompletionChunk]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type ReAI_StreamCompleteClient = grpc.ServerStreamingClient[CompletionChunk]
+
+func (c *reAIClient) Chat(ctx context.Context, in *ChatRequest, opts ...grpc.CallOption) (*ChatResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ChatResponse)
+	err := c.cc.Invoke(ctx, ReAI_Chat_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *reAIClient) StreamChat(ctx context.Context, in *ChatRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[ChatChunk], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &ReAI_ServiceDesc.Streams[1], ReAI_StreamChat_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[ChatRequest, ChatChunk]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type ReAI_StreamChatClient = grpc.ServerStreamingClient[ChatChunk]
+
+// ReAIServer is the server API for ReAI service.
+// All implementations must embed UnimplementedReAIServer
+// for forward compatibility.
+type ReAIServer interface {
+	// ListModels returns the models available to the server
+	ListModels(context.Context, *ListModelsRequest) (*ListModelsResponse, error)
+	// Complete returns a code completion
+	Complete(context.Context, *CompletionRequest) (*CompletionResponse, error)
+	// StreamComplete streams a code completion as it is generated
+	StreamComplete(*CompletionRequest, grpc.ServerStreamingServer[CompletionChunk]) error
+	// Chat returns a chat completion
+	Chat(context.Context, *ChatRequest) (*ChatResponse, error)
+	// StreamChat streams a chat completion as it is generated
+	StreamChat(*ChatRequest, grpc.ServerStreamingServer[ChatChunk]) error
+	mustEmbedUnimplementedReAIServer()
+}
+
+// UnimplementedReAIServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedReAIServer struct{}
+
+func (UnimplementedReAIServer) ListModels(context.Context, *ListModelsRequest) (*ListModelsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListModels not implemented")
+}
+func (UnimplementedReAIServer) Complete(context.Context, *CompletionRequest) (*CompletionResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Complete not implemented")
+}
+func (UnimplementedReAIServer) StreamComplete(*CompletionRequest, grpc.ServerStreamingServer[CompletionChunk]) error {
+	return status.Errorf(codes.Unimplemented, "method StreamComplete not implemented")
+}
+func (UnimplementedReAIServer) Chat(context.Context, *ChatRequest) (*ChatResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Chat not implemented")
+}
+func (UnimplementedReAIServer) StreamChat(*ChatRequest, grpc.ServerStreamingServer[ChatChunk]) error {
+	return status.Errorf(codes.Unimplemented, "method StreamChat not implemented")
+}
+func (UnimplementedReAIServer) mustEmbedUnimplementedReAIServer() {}
+func (UnimplementedReAIServer) testEmbeddedByValue()              {}
+
+// UnsafeReAIServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to ReAIServer will
+// result in compilation errors.
+type UnsafeReAIServer interface {
+	mustEmbedUnimplementedReAIServer()
+}
+
+func RegisterReAIServer(s grpc.ServiceRegistrar, srv ReAIServer) {
+	// If the following call pancis, it indicates UnimplementedReAIServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&ReAI_ServiceDesc, srv)
+}
+
+func _ReAI_ListModels_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListModelsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ReAIServer).ListModels(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ReAI_ListModels_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ReAIServer).ListModels(ctx, req.(*ListModelsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ReAI_Complete_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CompletionRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ReAIServer).Complete(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ReAI_Complete_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ReAIServer).Complete(ctx, req.(*CompletionRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ReAI_StreamComplete_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(CompletionRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(ReAIServer).StreamComplete(m, &grpc.GenericServerStream[CompletionRequest, CompletionChunk]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type ReAI_StreamCompleteServer = grpc.ServerStreamingServer[CompletionChunk]
+
+func _ReAI_Chat_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ChatRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ReAIServer).Chat(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ReAI_Chat_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ReAIServer).Chat(ctx, req.(*ChatRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ReAI_StreamChat_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(ChatRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(ReAIServer).StreamChat(m, &grpc.GenericServerStream[ChatRequest, ChatChunk]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type ReAI_StreamChatServer = grpc.ServerStreamingServer[ChatChunk]
+
+// ReAI_ServiceDesc is the grpc.ServiceDesc for ReAI service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var ReAI_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "reai.v1.ReAI",
+	HandlerType: (*ReAIServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "ListModels",
+			Handler:    _ReAI_ListModels_Handler,
+		},
+		{
+			MethodName: "Complete",
+			Handler:    _ReAI_Complete_Handler,
+		},
+		{
+			MethodName: "Chat",
+			Handler:    _ReAI_Chat_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "StreamComplete",
+			Handler:       _ReAI_StreamComplete_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "StreamChat",
+			Handler:       _ReAI_StreamChat_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "reai/v1/reai.proto",
+}
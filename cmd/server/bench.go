@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/devstroop/reai/internal/bench"
+)
+
+// runBench sends load to a server, or to one built in process from the
+// environment with -embedded, and exits non-zero when a threshold is missed
+func runBench(args []string) {
+	flags := flag.NewFlagSet("bench", flag.ExitOnError)
+	url := flags.String("url", "http://localhost:8080", "Base URL of the server to load")
+	key := flags.String("key", os.Getenv("REAI_API_KEY"), "API key (defaults to $REAI_API_KEY)")
+	embedded := flags.Bool("embedded", false, "Load a server built in process from the environment instead of -url")
+	endpoint := flags.String("endpoint", bench.EndpointChat, "Endpoint: chat or completions")
+	model := flags.String("model", "", "Model of the requests (the server default when empty)")
+	prompt := flags.String("prompt", "Count from 1 to 20", "Prompt of the requests")
+	maxTokens := flags.Int("max-tokens", 0, "max_tokens of the requests (unset when 0)")
+	stream := flags.Bool("stream", true, "Stream the responses and measure the time to first token")
+	concurrency := flags.Int("concurrency", 8, "Requests in flight at once")
+	requests := flags.Int("requests", 0, "Stop after this many requests (0 for no limit)")
+	duration := flags.Duration("duration", 30*time.Second, "Stop after this long (0 for no limit)")
+	rate := flags.Float64("rate", 0, "Requests started per second (0 for as fast as the concurrency allows)")
+	timeout := flags.Duration("timeout", 2*time.Minute, "Time limit of each request")
+	format := flags.String("format", "text", "Report format: text or json")
+	maxErrorRate := flags.Float64("max-error-rate", 1, "Fail when the share of failed requests is above this")
+	maxP95 := flags.Duration("max-p95", 0, "Fail when the p95 latency is above this (0 to skip)")
+	maxTTFTP95 := flags.Duration("max-ttft-p95", 0, "Fail when the p95 time to first token is above this (0 to skip)")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: reai bench [flags]")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() > 0 || (*format != "text" && *format != "json") ||
+		(*endpoint != bench.EndpointChat && *endpoint != bench.EndpointCompletions) ||
+		(*requests <= 0 && *duration <= 0) {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	runner := &bench.Runner{
+		Client:      &http.Client{Transport: &http.Transport{MaxIdleConnsPerHost: *concurrency}},
+		BaseURL:     *url,
+		APIKey:      *key,
+		Endpoint:    *endpoint,
+		Model:       *model,
+		Prompt:      *prompt,
+		MaxTokens:   *maxTokens,
+		Stream:      *stream,
+		Concurrency: *concurrency,
+		Requests:    *requests,
+		Duration:    *duration,
+		Rate:        *rate,
+		Timeout:     *timeout,
+	}
+	if *embedded {
+		// A real listener rather than handlerTransport, so streamed chunks
+		// arrive as they are written
+		server := embeddedServer(ctx)
+		listener := httptest.NewServer(server.Router())
+		defer func() {
+			listener.Close()
+			flushCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			server.Close(flushCtx)
+		}()
+		runner.BaseURL = listener.URL
+	}
+
+	report := runner.Run(ctx)
+	if *format == "json" {
+		report.WriteJSON(os.Stdout)
+	} else {
+		report.WriteText(os.Stdout)
+	}
+
+	var missed []string
+	if report.Requests == 0 {
+		missed = append(missed, "no requests completed")
+	}
+	if report.ErrorRate > *maxErrorRate {
+		missed = append(missed, fmt.Sprintf("error rate %.2f%% is above %.2f%%", 100*report.ErrorRate, 100**maxErrorRate))
+	}
+	if *maxP95 > 0 && report.Latency.P95 > *maxP95 {
+		missed = append(missed, fmt.Sprintf("p95 latency %s is above %s", report.Latency.P95.Round(time.Millisecond), *maxP95))
+	}
+	if *maxTTFTP95 > 0 && report.TTFT != nil && report.TTFT.P95 > *maxTTFTP95 {
+		missed = append(missed, fmt.Sprintf("p95 time to first token %s is above %s", report.TTFT.P95.Round(time.Millisecond), *maxTTFTP95))
+	}
+	for _, m := range missed {
+		fmt.Fprintln(os.Stderr, "threshold missed:", m)
+	}
+	if len(missed) > 0 {
+		stop()
+		os.Exit(1)
+	}
+}
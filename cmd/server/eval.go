@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/devstroop/reai/internal/api"
+	"github.com/devstroop/reai/internal/config"
+	"github.com/devstroop/reai/internal/copilot"
+	"github.com/devstroop/reai/internal/eval"
+	"github.com/devstroop/reai/internal/logging"
+)
+
+// runEval runs evaluation suites against a server, or against one built in
+// process from the environment with -embedded, and exits non-zero when a
+// case fails
+func runEval(args []string) {
+	flags := flag.NewFlagSet("eval", flag.ExitOnError)
+	url := flags.String("url", "http://localhost:8080", "Base URL of the server to evaluate")
+	key := flags.String("key", os.Getenv("REAI_API_KEY"), "API key (defaults to $REAI_API_KEY)")
+	embedded := flags.Bool("embedded", false, "Evaluate a server built in process from the environment instead of -url")
+	concurrency := flags.Int("concurrency", 4, "Cases run at once")
+	timeout := flags.Duration("timeout", 2*time.Minute, "Time limit of each case")
+	format := flags.String("format", "text", "Report format: text or json")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: reai eval [flags] suite.yaml...")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() == 0 || (*format != "text" && *format != "json") {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	var suites []*eval.Suite
+	for _, path := range flags.Args() {
+		suite, err := eval.Load(path)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+		suites = append(suites, suite)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	runner := &eval.Runner{Client: http.DefaultClient, BaseURL: *url, APIKey: *key, Concurrency: *concurrency, Timeout: *timeout}
+	if *embedded {
+		server := embeddedServer(ctx)
+		defer func() {
+			flushCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			server.Close(flushCtx)
+		}()
+		runner.Client = &http.Client{Transport: handlerTransport{server.Router()}}
+		runner.BaseURL = "http://reai.embedded"
+	}
+
+	failed := false
+	for _, suite := range suites {
+		report := runner.Run(ctx, suite)
+		if *format == "json" {
+			report.WriteJSON(os.Stdout)
+		} else {
+			report.WriteText(os.Stdout)
+		}
+		failed = failed || report.Failed > 0
+	}
+	if failed {
+		stop()
+		os.Exit(1)
+	}
+}
+
+// embeddedServer builds the API server the way the serve command does, with
+// logs on stderr so they stay apart from the report
+func embeddedServer(ctx context.Context) *api.Server {
+	cfg := config.LoadFromEnv()
+	logLevel := slog.LevelWarn
+	if cfg.LogLevel == "debug" {
+		logLevel = slog.LevelDebug
+	}
+	handler := slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelDebug, ReplaceAttr: logging.Redact(cfg.LogSensitive)})
+	slog.SetDefault(slog.New(logging.NewHandler(handler, logLevel)))
+
+	copilotClient, err := copilot.NewClient(cfg)
+	if err != nil {
+		slog.Error("Failed to create Copilot client", "error", err)
+		os.Exit(2)
+	}
+	if !cfg.Offline() {
+		if err := copilotClient.GetSessionToken(ctx); err != nil {
+			slog.Warn("Failed to get initial session token", "error", err)
+		}
+	}
+	server, err := api.NewServer(cfg, copilotClient)
+	if err != nil {
+		slog.Error("Failed to create API server", "error", err)
+		os.Exit(2)
+	}
+	return server
+}
+
+// handlerTransport serves requests with a handler in process
+type handlerTransport struct {
+	handler http.Handler
+}
+
+func (t handlerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	recorder := httptest.NewRecorder()
+	t.handler.ServeHTTP(recorder, req)
+	return recorder.Result(), nil
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/devstroop/reai/internal/api"
+	"github.com/devstroop/reai/internal/config"
+)
+
+// startGRPCServer starts the optional gRPC listener. It returns nil when
+// GRPC_PORT is not set.
+func startGRPCServer(cfg *config.Config, server *api.Server) (*http.Server, error) {
+	if cfg.GRPCPort == 0 {
+		return nil, nil
+	}
+	if cfg.GRPCTLSCert == "" || cfg.GRPCTLSKey == "" {
+		return nil, fmt.Errorf("GRPC_PORT requires GRPC_TLS_CERT and GRPC_TLS_KEY")
+	}
+
+	// No read/write timeouts: streaming calls last as long as the completion
+	grpcServer := &http.Server{
+		Addr:              fmt.Sprintf(":%d", cfg.GRPCPort),
+		Handler:           server.GRPCHandler(),
+		ReadHeaderTimeout: 15 * time.Second,
+		IdleTimeout:       60 * time.Second,
+	}
+
+	go func() {
+		slog.Info("🔌 gRPC server running", "address", grpcServer.Addr, "service", "reai.v1.ReAI")
+		if err := grpcServer.ListenAndServeTLS(cfg.GRPCTLSCert, cfg.GRPCTLSKey); err != nil && err != http.ErrServerClosed {
+			slog.Error("gRPC server failed", "error", err)
+		}
+	}()
+	return grpcServer, nil
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/devstroop/reai/internal/api"
+	"github.com/devstroop/reai/internal/config"
+)
+
+// startInternalServer starts the listener of the health, metrics, admin and
+// debug endpoints. It returns nil when INTERNAL_ADDR is not set and they are
+// served on the public listener.
+func startInternalServer(cfg *config.Config, server *api.Server) (*http.Server, error) {
+	if cfg.InternalAddr == "" {
+		return nil, nil
+	}
+	// Fail at startup rather than in the background when the address is taken
+	listener, err := net.Listen("tcp", cfg.InternalAddr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on INTERNAL_ADDR: %w", err)
+	}
+
+	internalServer := &http.Server{
+		Handler:      server.InternalRouter(),
+		ReadTimeout:  15 * time.Second,
+		WriteTimeout: 15 * time.Second,
+		IdleTimeout:  60 * time.Second,
+	}
+
+	go func() {
+		slog.Info("🔒 Internal server running", "address", listener.Addr().String(), "endpoints", "/health, /metrics, /admin, /debug")
+		if err := internalServer.Serve(listener); err != nil && err != http.ErrServerClosed {
+			slog.Error("Internal server failed", "error", err)
+		}
+	}()
+	return internalServer, nil
+}
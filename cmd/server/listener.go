@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"strconv"
+
+	"github.com/devstroop/reai/internal/config"
+)
+
+// systemdListenFDsStart is the first file descriptor passed by systemd socket activation
+const systemdListenFDsStart = 3
+
+// newListener creates the listener the HTTP server accepts connections on.
+// Precedence: systemd socket activation, then LISTEN_SOCKET, then TCP on PORT.
+func newListener(cfg *config.Config) (net.Listener, string, error) {
+	if ln, err := systemdListener(); err != nil {
+		return nil, "", fmt.Errorf("systemd socket activation failed: %w", err)
+	} else if ln != nil {
+		return ln, "systemd:" + ln.Addr().String(), nil
+	}
+
+	if cfg.ListenSocket != "" {
+		ln, err := unixListener(cfg.ListenSocket)
+		if err != nil {
+			return nil, "", err
+		}
+		return ln, "unix://" + cfg.ListenSocket, nil
+	}
+
+	// Development mode relaxes auth, so it is only reachable locally
+	host := "0.0.0.0"
+	if cfg.Dev {
+		host = "127.0.0.1"
+	}
+	ln, err := net.Listen("tcp", fmt.Sprintf("%s:%d", host, cfg.Port))
+	if err != nil {
+		return nil, "", err
+	}
+	return ln, fmt.Sprintf("http://%s:%d", host, cfg.Port), nil
+}
+
+// unixListener listens on a unix domain socket, removing a stale socket file left by a previous run
+func unixListener(path string) (net.Listener, error) {
+	if info, err := os.Lstat(path); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("refusing to replace non-socket file %s", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, fmt.Errorf("failed to remove stale socket %s: %w", path, err)
+		}
+	}
+
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on unix socket %s: %w", path, err)
+	}
+
+	// Owner and group only - local IDE integrations run as the same user
+	if err := os.Chmod(path, 0660); err != nil {
+		slog.Warn("Failed to set unix socket permissions", "path", path, "error", err)
+	}
+
+	return ln, nil
+}
+
+// systemdListener returns the first socket passed via systemd socket activation,
+// or nil if the process was not socket-activated
+func systemdListener() (net.Listener, error) {
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+
+	fds, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || fds < 1 {
+		return nil, nil
+	}
+
+	// Don't leak activation state into child processes
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	if fds > 1 {
+		slog.Warn("Multiple sockets passed by systemd, using the first one", "count", fds)
+	}
+
+	file := os.NewFile(uintptr(systemdListenFDsStart), "systemd-socket")
+	defer file.Close()
+
+	ln, err := net.FileListener(file)
+	if err != nil {
+		return nil, err
+	}
+	return ln, nil
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/devstroop/reai/internal/config"
+	"github.com/devstroop/reai/internal/copilot"
+	"github.com/devstroop/reai/internal/logging"
+	"github.com/devstroop/reai/internal/lsp"
+)
+
+// runLSP speaks the Language Server Protocol on stdin and stdout, completing
+// with the Copilot client. Logs go to stderr, which editors keep apart.
+func runLSP(args []string) {
+	flags := flag.NewFlagSet("lsp", flag.ExitOnError)
+	flags.Bool("stdio", true, "Use stdin and stdout (the only transport, accepted for editors that pass it)")
+	flags.Parse(args)
+
+	// stdout carries the protocol; anything else printed there, like the
+	// device flow prompt, goes to stderr instead
+	out := os.Stdout
+	os.Stdout = os.Stderr
+
+	cfg := config.LoadFromEnv()
+	logLevel := slog.LevelInfo
+	if cfg.LogLevel == "debug" {
+		logLevel = slog.LevelDebug
+	}
+	handler := slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelDebug, ReplaceAttr: logging.Redact(cfg.LogSensitive)})
+	slog.SetDefault(slog.New(logging.NewHandler(handler, logLevel)))
+
+	copilotClient, err := copilot.NewClient(cfg)
+	if err != nil {
+		slog.Error("Failed to create Copilot client", "error", err)
+		os.Exit(1)
+	}
+	if err := copilotClient.GetSessionToken(context.Background()); err != nil {
+		// Completions retry, so a later request may still succeed
+		slog.Warn("Failed to get initial session token", "error", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	go copilotClient.StartTokenRefresh(ctx)
+
+	slog.Info("🧩 ReAI language server running on stdio")
+	if err := lsp.NewServer(copilotClient).Serve(ctx, os.Stdin, out); err != nil {
+		slog.Error("Language server stopped", "error", err)
+		stop()
+		os.Exit(1)
+	}
+}
@@ -2,6 +2,8 @@ package main
 
 import (
 	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"log/slog"
 	"net/http"
@@ -13,27 +15,64 @@ import (
 	"github.com/devstroop/reai/internal/api"
 	"github.com/devstroop/reai/internal/config"
 	"github.com/devstroop/reai/internal/copilot"
+	"github.com/devstroop/reai/internal/logging"
 )
 
 func main() {
+	// Accept "reai serve [flags]" as well as plain flags
+	args := os.Args[1:]
+	if len(args) > 0 && args[0] == "lsp" {
+		runLSP(args[1:])
+		return
+	}
+	if len(args) > 0 && args[0] == "eval" {
+		runEval(args[1:])
+		return
+	}
+	if len(args) > 0 && args[0] == "bench" {
+		runBench(args[1:])
+		return
+	}
+	if len(args) > 0 && args[0] == "serve" {
+		args = args[1:]
+	}
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	dev := flags.Bool("dev", false, "Development mode: mock completions, relaxed auth on localhost, verbose text logs, config file reloading")
+	flags.Parse(args)
+
 	// Initialize configuration
 	cfg := config.LoadFromEnv()
+	if *dev {
+		cfg.EnableDev()
+	}
 
 	// Initialize structured logging
 	logLevel := slog.LevelInfo
-	if cfg.LogLevel == "debug" {
+	if cfg.LogLevel == "debug" || cfg.Dev {
 		logLevel = slog.LevelDebug
 	}
 	
-	logger := slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{
-		Level: logLevel,
-	}))
-	slog.SetDefault(logger)
+	// Tokens are always masked, prompts and responses unless LOG_SENSITIVE is
+	// set. The level is applied on top so X-ReAI-Debug can lift it per request.
+	logOptions := &slog.HandlerOptions{Level: slog.LevelDebug, ReplaceAttr: logging.Redact(cfg.LogSensitive)}
+	var handler slog.Handler
+	if cfg.Dev {
+		handler = slog.NewTextHandler(os.Stderr, logOptions)
+	} else {
+		handler = slog.NewJSONHandler(os.Stdout, logOptions)
+	}
+	slog.SetDefault(slog.New(logging.NewHandler(handler, logLevel)))
+	if cfg.Dev {
+		slog.Warn("🧪 Development mode - API keys are optional and the server only listens on localhost")
+	}
 
 	slog.Info("🚀 Starting ReAI - OpenAI Compatible API Server")
 	slog.Info("📦 GitHub Copilot backend with OpenAI-style endpoints")
 	slog.Info("🔧 Based on reverse-engineered Copilot API")
 	slog.Info("📊 Configuration", "port", cfg.Port, "data_dir", cfg.DataDir)
+	if cfg.ReadOnly {
+		slog.Info("🔏 Read-only mode - no files are written, usage and stored keys last until the process exits")
+	}
 
 	// Initialize Copilot client
 	copilotClient, err := copilot.NewClient(cfg)
@@ -42,21 +81,49 @@ func main() {
 		os.Exit(1)
 	}
 
-	// Try to get session token (will trigger setup if needed)
-	if err := copilotClient.GetSessionToken(context.Background()); err != nil {
-		slog.Warn("Failed to get initial session token", "error", err)
-		fmt.Println("⚠️  Authentication may be required on first API call")
-	}
+	// Replayed and mock responses need no GitHub session
+	if !cfg.Offline() {
+		// Try to get session token (will trigger setup if needed)
+		err := copilotClient.GetSessionToken(context.Background())
+		if err != nil {
+			slog.Warn("Failed to get initial session token", "error", err)
+			fmt.Println("⚠️  Authentication may be required on first API call")
+		}
 
-	// Start background token refresh
-	go copilotClient.StartTokenRefresh(context.Background())
+		// GitHub answering at all is enough to tell whether access is allowed
+		var httpErr *copilot.HTTPError
+		if cfg.PolicyCheck && (err == nil || errors.As(err, &httpErr)) {
+			checkPolicy(copilotClient)
+		}
+
+		// Start background token refresh
+		go copilotClient.StartTokenRefresh(context.Background())
+
+		// Bring the editor versions up to date before the first request
+		updateCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		if err := copilotClient.UpdateEditors(updateCtx); err != nil {
+			slog.Warn("Editor versions not updated - using the built-in ones", "error", err)
+		}
+		cancel()
+		go copilotClient.RunEditorUpdates(context.Background())
+	}
 
 	// Create API server
-	server := api.NewServer(copilotClient)
+	server, err := api.NewServer(cfg, copilotClient)
+	if err != nil {
+		slog.Error("Failed to create API server", "error", err)
+		os.Exit(1)
+	}
 	
+	// Setup listener (TCP, unix socket or systemd socket activation)
+	listener, address, err := newListener(cfg)
+	if err != nil {
+		slog.Error("Failed to create listener", "error", err)
+		os.Exit(1)
+	}
+
 	// Setup HTTP server
 	httpServer := &http.Server{
-		Addr:         fmt.Sprintf(":%d", cfg.Port),
 		Handler:      server.Router(),
 		ReadTimeout:  15 * time.Second,
 		WriteTimeout: 15 * time.Second,
@@ -66,33 +133,119 @@ func main() {
 	// Start server in goroutine
 	go func() {
 		slog.Info("✅ ReAI server initialized")
-		slog.Info("🌐 Server running", "address", fmt.Sprintf("http://0.0.0.0:%d", cfg.Port))
+		slog.Info("🌐 Server running", "address", address)
 		slog.Info("📊 Available endpoints:")
-		slog.Info("   GET  /health              	- Health check")
+		if cfg.InternalAddr == "" {
+			slog.Info("   GET  /health              	- Health check")
+			slog.Info("   GET  /metrics             	- Prometheus metrics")
+		}
 		slog.Info("   GET  /v1/models           	- List available models")
 		slog.Info("   POST /v1/completions      	- Code completions")
 		slog.Info("   POST /v1/chat/completions 	- Chat/Q&A")
 
-		if err := httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		if err := httpServer.Serve(listener); err != nil && err != http.ErrServerClosed {
 			slog.Error("Server failed to start", "error", err)
 			os.Exit(1)
 		}
 	}()
 
+	// Background work stops when shutdown begins
+	background, stopBackground := context.WithCancel(context.Background())
+
+	if cfg.Dev {
+		go server.WatchConfigFiles(background, time.Second)
+	}
+
+	// Start synthetic probes (no-op without PROBES_FILE)
+	go server.RunProbes(background)
+	// Start the batch runner (no-op without BATCHES_ENABLED)
+	batchesStopped := make(chan struct{})
+	go func() {
+		server.RunBatches(background)
+		close(batchesStopped)
+	}()
+	// Keep upstream connections warm for slow models (no-op without WARM_MODELS)
+	go server.RunWarmPool(background)
+
+	grpcServer, err := startGRPCServer(cfg, server)
+	if err != nil {
+		slog.Error("Failed to start gRPC server", "error", err)
+		os.Exit(1)
+	}
+
+	internalServer, err := startInternalServer(cfg, server)
+	if err != nil {
+		slog.Error("Failed to start internal server", "error", err)
+		os.Exit(1)
+	}
+
 	// Wait for interrupt signal to gracefully shutdown the server
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	<-quit
 
-	slog.Info("Shutdown signal received, stopping server...")
+	slog.Info("Shutdown signal received, draining requests...", "timeout", cfg.ShutdownTimeout)
 
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
 	defer cancel()
 
+	// Turn new requests away and let the running ones, streams included,
+	// finish; the batch runner stops after the request it is sending
+	stopBackground()
+	forced := false
+	if err := server.Drain(ctx); err != nil {
+		slog.Error("Requests cut off by the shutdown timeout", "error", err)
+		forced = true
+	}
+	select {
+	case <-batchesStopped:
+	case <-ctx.Done():
+		slog.Warn("Batch request cut off by the shutdown timeout, it runs again after a restart")
+	}
+
+	if grpcServer != nil {
+		if err := grpcServer.Shutdown(ctx); err != nil {
+			slog.Error("gRPC server forced to shutdown", "error", err)
+		}
+	}
+
+	// Health and metrics stay up until the API listener is gone
+	if internalServer != nil {
+		defer internalServer.Close()
+	}
+
 	if err := httpServer.Shutdown(ctx); err != nil {
 		slog.Error("Server forced to shutdown", "error", err)
-		os.Exit(1)
+		httpServer.Close()
+		forced = true
 	}
 
+	// Usage accounting, sinks and the audit log are flushed even when
+	// requests were cut off
+	flushCtx, cancelFlush := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancelFlush()
+	server.Close(flushCtx)
+
+	if forced {
+		os.Exit(1)
+	}
 	slog.Info("Server stopped gracefully")
 }
+
+// checkPolicy stops the server when the account's policy disables Copilot
+// completions or chat, instead of failing every request later
+func checkPolicy(client *copilot.Client) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	policy, err := client.CheckPolicy(ctx)
+	if err != nil {
+		slog.Warn("Copilot policy check skipped", "error", err)
+		return
+	}
+	if err := policy.Validate(); err != nil {
+		slog.Error("❌ Copilot access disabled by policy", "error", err, "sku", policy.SKU, "organizations", policy.Organizations)
+		fmt.Println("Set POLICY_CHECK=false to start anyway")
+		os.Exit(1)
+	}
+	slog.Info("Copilot policy checked", "sku", policy.SKU, "chat", policy.ChatEnabled, "public_code_filter", policy.PublicCodeFilter)
+}
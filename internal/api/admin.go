@@ -0,0 +1,425 @@
+package api
+
+import (
+	"crypto/subtle"
+	_ "embed"
+	"encoding/csv"
+	"encoding/json"
+	stderrors "errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/devstroop/reai/internal/keys"
+	"github.com/devstroop/reai/internal/queue"
+	"github.com/devstroop/reai/internal/quota"
+	"github.com/devstroop/reai/internal/usage"
+	"github.com/devstroop/reai/internal/webhook"
+	"github.com/devstroop/reai/pkg/errors"
+)
+
+// authPage is the page of /admin/auth/ui
+//
+//go:embed assets/auth.html
+var authPage []byte
+
+// authWatchHeartbeat keeps idle /admin/auth event streams open
+const authWatchHeartbeat = 15 * time.Second
+
+// adminMiddleware requires the admin token. Admin endpoints are disabled
+// entirely when no ADMIN_TOKEN is configured, except for local callers in
+// development mode.
+func (s *Server) adminMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.isAdmin(r, requestAPIKey(r)) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if s.config.AdminToken == "" {
+			http.NotFound(w, r)
+			return
+		}
+		slog.Warn("Rejected admin request", "path", r.URL.Path, "remote_addr", r.RemoteAddr)
+		errors.WriteErrorResponse(w, errors.NewAuthenticationError("invalid admin token"))
+	})
+}
+
+// isAdmin reports whether token is the admin token. Without an admin token,
+// loopback callers are admins in development mode.
+func (s *Server) isAdmin(r *http.Request, token string) bool {
+	if s.config.AdminToken == "" {
+		return s.config.Dev && isLoopback(r)
+	}
+	return subtle.ConstantTimeCompare([]byte(token), []byte(s.config.AdminToken)) == 1
+}
+
+// maintenanceRequest toggles manual maintenance mode
+type maintenanceRequest struct {
+	Enabled bool   `json:"enabled"`
+	Message string `json:"message,omitempty"`
+	// Until or Duration bound the maintenance; both empty means until disabled
+	Until    *time.Time `json:"until,omitempty"`
+	Duration string     `json:"duration,omitempty"`
+}
+
+// handleAdminMaintenance reports (GET) and toggles (POST, DELETE) maintenance mode
+func (s *Server) handleAdminMaintenance(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		var req maintenanceRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			errors.WriteErrorResponse(w, errors.NewValidationError("Invalid JSON format"))
+			return
+		}
+
+		if !req.Enabled {
+			s.maintenance.Disable()
+			slog.Info("Maintenance mode disabled by admin")
+			break
+		}
+
+		var until time.Time
+		switch {
+		case req.Until != nil:
+			until = *req.Until
+		case req.Duration != "":
+			d, err := time.ParseDuration(req.Duration)
+			if err != nil || d <= 0 {
+				errors.WriteErrorResponse(w, errors.NewValidationError("invalid duration"))
+				return
+			}
+			until = time.Now().Add(d)
+		}
+		s.maintenance.Enable(req.Message, until)
+		slog.Info("Maintenance mode enabled by admin", "until", until, "message", req.Message)
+	case http.MethodDelete:
+		s.maintenance.Disable()
+		slog.Info("Maintenance mode disabled by admin")
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.maintenance.Status(time.Now()))
+}
+
+// handleAdminAuth reports (GET) or starts (POST) GitHub device flow
+// authentication. A POST while a flow is waiting for the user returns that
+// flow instead of starting a competing one. A GET accepting
+// text/event-stream follows the status as it changes.
+func (s *Server) handleAdminAuth(w http.ResponseWriter, r *http.Request) {
+	status := s.copilotClient.DeviceFlowStatus()
+	switch r.Method {
+	case http.MethodGet:
+		if strings.Contains(r.Header.Get("Accept"), "text/event-stream") {
+			s.watchDeviceFlow(w, r)
+			return
+		}
+	case http.MethodPost:
+		var err error
+		if status, err = s.copilotClient.StartDeviceFlow(r.Context()); err != nil {
+			slog.Error("Failed to start device flow", "error", err)
+			errors.WriteErrorResponse(w, errors.NewCopilotAPIError(err.Error()))
+			return
+		}
+		slog.Info("Device flow authentication requested by admin", "state", status.State)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(status)
+		return
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
+
+// watchDeviceFlow sends the device flow status as an event, and again every
+// time it changes, until the client goes away
+func (s *Server) watchDeviceFlow(w http.ResponseWriter, r *http.Request) {
+	flusher, _ := w.(http.Flusher)
+	stream := &sseWriter{w: w, flusher: flusher, heartbeat: authWatchHeartbeat}
+	stream.Open(r.Context())
+	defer stream.Fail(errClientGone)
+
+	for {
+		status, changed := s.copilotClient.WatchDeviceFlow()
+		if err := stream.Send(status); err != nil {
+			return
+		}
+		select {
+		case <-changed:
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// handleAdminAuthPage serves a page that starts device flow authentication
+// and shows the user code as it arrives, so a running server can be
+// re-authenticated from a browser. The page itself holds nothing secret; it
+// asks for the admin token to call /admin/auth.
+func (s *Server) handleAdminAuthPage(w http.ResponseWriter, r *http.Request) {
+	if s.config.AdminToken == "" && !(s.config.Dev && isLoopback(r)) {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Security-Policy", "default-src 'self'; script-src 'unsafe-inline'; style-src 'unsafe-inline'")
+	w.Write(authPage)
+}
+
+// handleAdminQuota reports the Copilot account's consumption this month and
+// whether it is likely to run out before the quota resets
+func (s *Server) handleAdminQuota(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.copilotClient.ConsumptionStatus())
+}
+
+// handleAdminPolicy reports the policy flags of the Copilot account, fetched
+// from GitHub on every call
+func (s *Server) handleAdminPolicy(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	policy, err := s.copilotClient.CheckPolicy(r.Context())
+	if err != nil {
+		slog.Warn("Copilot policy check failed", "error", err)
+		errors.WriteErrorResponse(w, errors.NewCopilotAPIError("Unable to fetch the account policy: "+err.Error()))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(policy)
+}
+
+// keyUsage is the day and month usage of one API key in GET /admin/usage
+type keyUsage struct {
+	KeyID  string        `json:"key_id"`
+	Name   string        `json:"name,omitempty"`
+	Limits *quota.Limits `json:"limits"`
+	Usage  quota.Usage   `json:"usage"`
+	// Exceeded lists the quotas used up right now
+	Exceeded []string `json:"exceeded,omitempty"`
+}
+
+// handleAdminUsage reports the rolling day and month usage of every API key
+// against its quotas
+func (s *Server) handleAdminUsage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	now := time.Now()
+	data := []keyUsage{}
+	for _, key := range s.keys.Load().All() {
+		if key.Decoy {
+			continue
+		}
+		entry := keyUsage{KeyID: key.ID, Name: key.Name, Limits: key.Limits, Usage: s.quota.Usage(key.ID, now)}
+		if key.Limits != nil {
+			status := s.quota.Status(key.ID, *key.Limits, now)
+			for name, headroom := range map[string]*quota.Headroom{
+				"day_requests": status.DayRequests, "day_tokens": status.DayTokens,
+				"month_requests": status.MonthRequests, "month_tokens": status.MonthTokens,
+			} {
+				if headroom != nil && headroom.Remaining == 0 {
+					entry.Exceeded = append(entry.Exceeded, name)
+				}
+			}
+			sort.Strings(entry.Exceeded)
+		}
+		data = append(data, entry)
+	}
+
+	response := map[string]interface{}{"object": "list", "data": data}
+	if currency := s.pricing.Currency(); currency != "" {
+		response["currency"] = currency
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// handleAdminUsageExport returns the daily usage history per key and model
+// from the from to the to day (UTC, both included, last 30 days by default)
+// as JSON or, with format=csv, as CSV. key_id narrows it to one key.
+func (s *Server) handleAdminUsageExport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	to := time.Now().UTC().Truncate(24 * time.Hour)
+	from := to.AddDate(0, 0, -29)
+	for name, day := range map[string]*time.Time{"from": &from, "to": &to} {
+		value := query.Get(name)
+		if value == "" {
+			continue
+		}
+		parsed, err := time.Parse(usage.DayFormat, value)
+		if err != nil {
+			errors.WriteErrorResponse(w, errors.NewValidationError(name+" must be a day like 2025-01-31"))
+			return
+		}
+		*day = parsed
+	}
+	if from.After(to) {
+		errors.WriteErrorResponse(w, errors.NewValidationError("from must not be after to"))
+		return
+	}
+	format := query.Get("format")
+	if format != "" && format != "json" && format != "csv" {
+		errors.WriteErrorResponse(w, errors.NewValidationError("format must be json or csv"))
+		return
+	}
+
+	records, err := s.usage.Query(from.Format(usage.DayFormat), to.Format(usage.DayFormat), query.Get("key_id"))
+	if err != nil {
+		errors.WriteErrorResponse(w, errors.NewInternalError(err.Error()))
+		return
+	}
+	currency := s.pricing.Currency()
+
+	if format == "csv" {
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="reai-usage-%s-%s.csv"`, from.Format(usage.DayFormat), to.Format(usage.DayFormat)))
+		out := csv.NewWriter(w)
+		out.Write([]string{"day", "key_id", "model", "requests", "errors", "prompt_tokens", "completion_tokens", "cost", "currency"})
+		for _, record := range records {
+			out.Write([]string{
+				record.Day, record.KeyID, record.Model,
+				strconv.Itoa(record.Requests), strconv.Itoa(record.Errors),
+				strconv.Itoa(record.PromptTokens), strconv.Itoa(record.CompletionTokens),
+				strconv.FormatFloat(record.Cost, 'f', -1, 64), currency,
+			})
+		}
+		out.Flush()
+		return
+	}
+
+	response := map[string]interface{}{
+		"object": "list",
+		"from":   from.Format(usage.DayFormat),
+		"to":     to.Format(usage.DayFormat),
+		"data":   records,
+	}
+	if currency != "" {
+		response["currency"] = currency
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// keyInfo describes an API key in GET /admin/keys without its secret
+type keyInfo struct {
+	ID       string         `json:"id"`
+	Name     string         `json:"name,omitempty"`
+	Limits   *quota.Limits  `json:"limits,omitempty"`
+	Priority queue.Priority `json:"priority"`
+	// MaxTokens and MaxDuration are the guardrails of the key
+	MaxTokens   int    `json:"max_tokens,omitempty"`
+	MaxDuration string `json:"max_duration,omitempty"`
+	Decoy       bool   `json:"decoy,omitempty"`
+	// Created is set on keys created through the admin API, the only ones
+	// it can delete
+	Created *time.Time `json:"created,omitempty"`
+}
+
+// handleAdminKeys lists the API keys (GET) or creates one (POST). A created
+// key is stored in the database and its secret is only returned here.
+func (s *Server) handleAdminKeys(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		data := []keyInfo{}
+		for _, key := range s.keys.Load().All() {
+			data = append(data, keyInfo{ID: key.ID, Name: key.Name, Limits: key.Limits, Priority: key.Priority,
+				MaxTokens: key.MaxTokens, MaxDuration: key.MaxDuration, Decoy: key.Decoy, Created: key.Created})
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"object": "list", "data": data})
+	case http.MethodPost:
+		var req keys.Key
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			errors.WriteErrorResponse(w, errors.NewValidationError("Invalid JSON format"))
+			return
+		}
+		if req.Secret != "" || req.Created != nil {
+			errors.WriteErrorResponse(w, errors.NewValidationError("key and created are generated by the server"))
+			return
+		}
+		if _, exists := s.keys.Load().ByID(req.ID); req.ID != "" && exists {
+			errors.WriteErrorResponse(w, &errors.APIError{Type: "conflict", Message: "an API key with this id already exists", Code: http.StatusConflict})
+			return
+		}
+		if err := req.Validate(); err != nil {
+			errors.WriteErrorResponse(w, errors.NewValidationError(err.Error()))
+			return
+		}
+
+		key, err := keys.Create(s.db, req)
+		if err != nil {
+			errors.WriteErrorResponse(w, errors.NewInternalError(err.Error()))
+			return
+		}
+		if err := s.ReloadKeys(); err != nil {
+			errors.WriteErrorResponse(w, errors.NewInternalError(err.Error()))
+			return
+		}
+		slog.Info("API key created by admin", "key_id", key.ID)
+		s.webhooks.Publish(webhook.EventKeyCreated, key.ID, map[string]interface{}{"key_id": key.ID, "name": key.Name})
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(key)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleAdminKey deletes (DELETE) an API key created through the admin API.
+// Keys of API_KEYS and API_KEYS_FILE are removed there.
+func (s *Server) handleAdminKey(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id := strings.TrimPrefix(r.URL.Path, "/admin/keys/")
+	if err := keys.Delete(s.db, id); err != nil {
+		if stderrors.Is(err, keys.ErrNotFound) {
+			errors.WriteErrorResponse(w, &errors.APIError{Type: "not_found", Message: "no API key created through the admin API has this id", Code: http.StatusNotFound})
+			return
+		}
+		errors.WriteErrorResponse(w, errors.NewInternalError(err.Error()))
+		return
+	}
+	if err := s.ReloadKeys(); err != nil {
+		errors.WriteErrorResponse(w, errors.NewInternalError(err.Error()))
+		return
+	}
+	slog.Info("API key deleted by admin", "key_id", id)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"id": id, "deleted": true})
+}
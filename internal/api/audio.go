@@ -0,0 +1,89 @@
+package api
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+
+	"github.com/devstroop/reai/internal/config"
+	"github.com/devstroop/reai/pkg/errors"
+)
+
+// audioPrefix is where the OpenAI audio routes live
+const audioPrefix = "/v1/audio/"
+
+// audioOperations names the audio routes for error messages
+var audioOperations = map[string]string{
+	"transcriptions": "Audio transcription",
+	"translations":   "Audio translation",
+	"speech":         "Text to speech",
+}
+
+// newAudioProxy returns a reverse proxy sending audio requests to the
+// OpenAI compatible API at AUDIO_UPSTREAM_URL, nil when it is not set. The
+// caller's credentials are replaced with AUDIO_UPSTREAM_API_KEY.
+func newAudioProxy(cfg *config.Config) (*httputil.ReverseProxy, error) {
+	if cfg.AudioUpstreamURL == "" {
+		return nil, nil
+	}
+	target, err := url.Parse(cfg.AudioUpstreamURL)
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+		return nil, fmt.Errorf("AUDIO_UPSTREAM_URL %q must be an http(s) URL", cfg.AudioUpstreamURL)
+	}
+	apiKey := cfg.AudioUpstreamAPIKey
+
+	return &httputil.ReverseProxy{
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			pr.Out.URL.Scheme = target.Scheme
+			pr.Out.URL.Host = target.Host
+			pr.Out.URL.Path = strings.TrimSuffix(target.Path, "/") + "/audio/" + strings.TrimPrefix(pr.In.URL.Path, audioPrefix)
+			pr.Out.URL.RawPath = ""
+			pr.Out.Host = target.Host
+
+			for name := range pr.Out.Header {
+				if strings.HasPrefix(name, "X-Reai-") {
+					pr.Out.Header.Del(name)
+				}
+			}
+			pr.Out.Header.Del("Api-Key")
+			pr.Out.Header.Del("Cookie")
+			pr.Out.Header.Del("Authorization")
+			if apiKey != "" {
+				pr.Out.Header.Set("Authorization", "Bearer "+apiKey)
+			}
+		},
+		// Speech is streamed as it is generated
+		FlushInterval: -1,
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			if r.Context().Err() != nil {
+				return
+			}
+			slog.Warn("Audio upstream request failed", "path", r.URL.Path, "error", err)
+			errors.WriteErrorResponse(w, errors.NewProviderError(fmt.Sprintf("audio request failed: %s", err)))
+		},
+	}, nil
+}
+
+// handleAudio serves the OpenAI audio routes. Copilot has no audio models, so
+// requests are passed to AUDIO_UPSTREAM_URL, or refused with an error SDK
+// clients understand when it is not set.
+func (s *Server) handleAudio(w http.ResponseWriter, r *http.Request) {
+	operation, known := audioOperations[strings.TrimPrefix(r.URL.Path, audioPrefix)]
+	if !known {
+		errors.WriteErrorResponse(w, &errors.APIError{Type: "not_found", Message: "Resource not found", Code: http.StatusNotFound})
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if s.audioProxy == nil {
+		errors.WriteErrorResponse(w, errors.NewModelNotSupportedError(operation+" is not supported: Copilot has no audio models and no audio upstream is configured"))
+		return
+	}
+	s.audioProxy.ServeHTTP(w, r)
+}
@@ -0,0 +1,80 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/devstroop/reai/pkg/errors"
+)
+
+// azurePrefix is where the Azure OpenAI routes live:
+// /openai/deployments/{deployment}/chat/completions?api-version=...
+const azurePrefix = "/openai/deployments/"
+
+// parseAzureDeployments parses "deployment=model,..." pairs
+func parseAzureDeployments(spec string) (map[string]string, error) {
+	deployments := make(map[string]string)
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		deployment, model, ok := strings.Cut(entry, "=")
+		deployment, model = strings.TrimSpace(deployment), strings.TrimSpace(model)
+		if !ok || deployment == "" || model == "" {
+			return nil, fmt.Errorf("Azure deployment %q must be <deployment>=<model>", entry)
+		}
+		deployments[deployment] = model
+	}
+	return deployments, nil
+}
+
+type deploymentModelKey struct{}
+
+// withDeploymentModel attaches the model of an Azure deployment to ctx
+func withDeploymentModel(ctx context.Context, model string) context.Context {
+	return context.WithValue(ctx, deploymentModelKey{}, model)
+}
+
+// deploymentModel returns the model of the Azure deployment a request was
+// sent to, which takes the place of the model in the body
+func deploymentModel(ctx context.Context) (string, bool) {
+	model, ok := ctx.Value(deploymentModelKey{}).(string)
+	return model, ok
+}
+
+// handleAzure serves the Azure OpenAI route shape, so tooling that only
+// speaks Azure can use ReAI. The deployment picks the model: the one mapped
+// to it in AZURE_DEPLOYMENTS, or the deployment name itself when the map is
+// empty. The api-key header is accepted by the auth middleware.
+func (s *Server) handleAzure(w http.ResponseWriter, r *http.Request) {
+	deployment, operation, ok := strings.Cut(strings.TrimPrefix(r.URL.Path, azurePrefix), "/")
+	if !ok || deployment == "" {
+		errors.WriteErrorResponse(w, &errors.APIError{Type: "not_found", Message: "Resource not found", Code: http.StatusNotFound})
+		return
+	}
+	if r.URL.Query().Get("api-version") == "" {
+		errors.WriteErrorResponse(w, errors.NewValidationError("Missing api-version query parameter"))
+		return
+	}
+
+	model := deployment
+	if len(s.azureDeployments) > 0 {
+		if model, ok = s.azureDeployments[deployment]; !ok {
+			errors.WriteErrorResponse(w, &errors.APIError{Type: "not_found", Message: fmt.Sprintf("The API deployment %s does not exist", deployment), Code: http.StatusNotFound})
+			return
+		}
+	}
+	r = r.WithContext(withDeploymentModel(r.Context(), model))
+
+	switch operation {
+	case "chat/completions":
+		s.handleChatCompletions(w, r)
+	case "completions":
+		s.handleCompletions(w, r)
+	default:
+		errors.WriteErrorResponse(w, &errors.APIError{Type: "not_found", Message: "Resource not found", Code: http.StatusNotFound})
+	}
+}
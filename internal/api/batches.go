@@ -0,0 +1,236 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	stderrors "errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/devstroop/reai/internal/batch"
+	"github.com/devstroop/reai/internal/files"
+	"github.com/devstroop/reai/internal/keys"
+	"github.com/devstroop/reai/pkg/errors"
+)
+
+// CreateBatchRequest is the body of POST /v1/batches
+type CreateBatchRequest struct {
+	InputFileID      string            `json:"input_file_id"`
+	Endpoint         string            `json:"endpoint"`
+	CompletionWindow string            `json:"completion_window"`
+	Metadata         map[string]string `json:"metadata,omitempty"`
+}
+
+// RunBatches processes queued batches until ctx is done. It returns
+// immediately unless BATCHES_ENABLED is set.
+func (s *Server) RunBatches(ctx context.Context) {
+	if s.batchRunner == nil {
+		return
+	}
+	s.batchRunner.Run(ctx)
+}
+
+// handleBatches lists the caller's batches (GET) or creates one (POST)
+func (s *Server) handleBatches(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		s.listBatches(w, r)
+	case http.MethodPost:
+		s.createBatch(w, r)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) createBatch(w http.ResponseWriter, r *http.Request) {
+	var req CreateBatchRequest
+	if err := s.decodeJSON(r, &req); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	supported := false
+	for _, endpoint := range batch.Endpoints {
+		supported = supported || req.Endpoint == endpoint
+	}
+	switch {
+	case !supported:
+		errors.WriteErrorResponse(w, errors.NewValidationError("endpoint must be one of "+strings.Join(batch.Endpoints, ", ")))
+		return
+	case req.CompletionWindow != batch.CompletionWindow:
+		errors.WriteErrorResponse(w, errors.NewValidationError("completion_window must be \""+batch.CompletionWindow+"\""))
+		return
+	}
+
+	owner := conversationOwner(r)
+	file, err := s.files.Get(owner, req.InputFileID)
+	if err != nil {
+		writeFileError(w, err)
+		return
+	}
+	if file.Purpose != files.PurposeBatch {
+		errors.WriteErrorResponse(w, errors.NewValidationError("input file must have purpose \""+files.PurposeBatch+"\""))
+		return
+	}
+
+	b, err := s.batches.Create(owner, req.Endpoint, req.InputFileID, req.Metadata)
+	if err != nil {
+		writeError(w, errors.NewInternalError(err.Error()))
+		return
+	}
+	s.batchRunner.Wake()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(b)
+}
+
+// listBatches returns the caller's batches newest first, paginated with the
+// limit and after query parameters
+func (s *Server) listBatches(w http.ResponseWriter, r *http.Request) {
+	limit := 20
+	if value := r.URL.Query().Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 || parsed > 100 {
+			errors.WriteErrorResponse(w, errors.NewValidationError("limit must be between 1 and 100"))
+			return
+		}
+		limit = parsed
+	}
+
+	list := s.batches.List(conversationOwner(r))
+	if after := r.URL.Query().Get("after"); after != "" {
+		for i, b := range list {
+			if b.ID == after {
+				list = list[i+1:]
+				break
+			}
+		}
+	}
+	hasMore := len(list) > limit
+	if hasMore {
+		list = list[:limit]
+	}
+
+	response := map[string]interface{}{
+		"object":   "list",
+		"data":     list,
+		"has_more": hasMore,
+	}
+	if len(list) == 0 {
+		response["data"] = []*batch.Batch{}
+	} else {
+		response["first_id"] = list[0].ID
+		response["last_id"] = list[len(list)-1].ID
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// handleBatch returns a batch (GET) or, with a /cancel suffix, cancels it
+// (POST). Requests already sent finish; their results stay available.
+func (s *Server) handleBatch(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/v1/batches/")
+	id, cancel := strings.CutSuffix(id, "/cancel")
+	owner := conversationOwner(r)
+
+	var b *batch.Batch
+	var err error
+	switch {
+	case cancel && r.Method == http.MethodPost:
+		if b, err = s.batches.Cancel(owner, id); err == nil {
+			s.batchRunner.Wake()
+		}
+	case !cancel && r.Method == http.MethodGet:
+		b, err = s.batches.Get(owner, id)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err != nil {
+		if stderrors.Is(err, batch.ErrNotFound) {
+			errors.WriteErrorResponse(w, &errors.APIError{Type: "not_found", Message: err.Error(), Code: http.StatusNotFound})
+			return
+		}
+		writeError(w, errors.NewInternalError(err.Error()))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(b)
+}
+
+// executeBatchRequest runs one batch request through the regular handler for
+// endpoint, as the key that created the batch. Batch requests share the
+// request queue with interactive traffic and count against the daily and
+// monthly quotas of the key, but skip its per-minute limits; the batch rate
+// is set by BATCH_REQUESTS_PER_MINUTE instead. A request over quota gets a
+// quota_exceeded error as its result.
+func (s *Server) executeBatchRequest(ctx context.Context, owner, endpoint string, body json.RawMessage) (int, json.RawMessage) {
+	rec := newBatchRecorder()
+	if owner != "" {
+		key, ok := s.keys.Load().ByID(owner)
+		if !ok || key.Decoy {
+			errors.WriteErrorResponse(rec, errors.NewAuthenticationError("the API key that created this batch is no longer valid"))
+			return rec.result()
+		}
+		ctx = keys.WithKey(ctx, key)
+		if apiErr := s.checkBatchQuota(ctx); apiErr != nil {
+			errors.WriteErrorResponse(rec, apiErr)
+			return rec.result()
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		errors.WriteErrorResponse(rec, errors.NewInternalError(err.Error()))
+		return rec.result()
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	handler := s.handleCompletions
+	if endpoint == "/v1/chat/completions" {
+		handler = s.handleChatCompletions
+	}
+	s.queueMiddleware(http.HandlerFunc(handler)).ServeHTTP(rec, req)
+	return rec.result()
+}
+
+// batchRecorder is an in-memory http.ResponseWriter for batch requests
+type batchRecorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func newBatchRecorder() *batchRecorder {
+	return &batchRecorder{header: make(http.Header)}
+}
+
+func (b *batchRecorder) Header() http.Header { return b.header }
+
+func (b *batchRecorder) WriteHeader(code int) {
+	if b.status == 0 {
+		b.status = code
+	}
+}
+
+func (b *batchRecorder) Write(p []byte) (int, error) {
+	b.WriteHeader(http.StatusOK)
+	return b.body.Write(p)
+}
+
+// result returns the status and body, quoting bodies that are not JSON
+func (b *batchRecorder) result() (int, json.RawMessage) {
+	status := b.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	body := bytes.TrimSpace(b.body.Bytes())
+	if !json.Valid(body) {
+		body, _ = json.Marshal(string(body))
+	}
+	return status, body
+}
@@ -0,0 +1,61 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestBatchQuotas checks that batch requests count against the daily quota
+// of the key that created the batch but skip its per-minute limit
+func TestBatchQuotas(t *testing.T) {
+	keysFile := filepath.Join(t.TempDir(), "keys.json")
+	err := os.WriteFile(keysFile, []byte(`{"keys": [
+		{"id": "batcher", "key": "sk-batcher", "limits": {"requests_per_minute": 1, "requests_per_day": 3}}
+	]}`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := newMockServer(t, map[string]string{"API_KEYS_FILE": keysFile})
+
+	body := json.RawMessage(`{"model": "gpt-4o", "messages": [{"role": "user", "content": "hello"}]}`)
+	interactive := func() int {
+		req, _ := http.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(string(body)))
+		req.Header.Set("Authorization", "Bearer sk-batcher")
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		server.Router().ServeHTTP(rec, req)
+		return rec.Code
+	}
+	batch := func() (int, string) {
+		status, result := server.executeBatchRequest(context.Background(), "batcher", "/v1/chat/completions", body)
+		return status, string(result)
+	}
+
+	if status := interactive(); status != http.StatusOK {
+		t.Fatalf("interactive request: %d", status)
+	}
+	// The minute limit is used up, yet batch requests go on until the day quota is
+	for i := 0; i < 2; i++ {
+		if status, result := batch(); status != http.StatusOK {
+			t.Fatalf("batch request %d: %d %s", i, status, result)
+		}
+	}
+	status, result := batch()
+	if status != http.StatusTooManyRequests || !strings.Contains(result, "quota_exceeded") || !strings.Contains(result, "daily request quota") {
+		t.Errorf("batch request over quota: %d %s", status, result)
+	}
+	if status := interactive(); status != http.StatusTooManyRequests {
+		t.Errorf("interactive request over quota: %d", status)
+	}
+
+	// Batches of keys that are gone fail every request
+	if status, _ := server.executeBatchRequest(context.Background(), "revoked", "/v1/chat/completions", body); status != http.StatusUnauthorized {
+		t.Errorf("batch of an unknown key: %d", status)
+	}
+}
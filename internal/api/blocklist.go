@@ -0,0 +1,100 @@
+package api
+
+import (
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// maxBlockedIPs bounds the blocklist; blocking beyond it replaces the
+	// entry that expires soonest
+	maxBlockedIPs = 10000
+	// blocklistSweepInterval bounds how long expired entries linger
+	blocklistSweepInterval = 10 * time.Minute
+)
+
+// ipBlocklist temporarily blocks client IPs
+type ipBlocklist struct {
+	mutex sync.Mutex
+	until map[string]time.Time
+	swept time.Time
+	now   func() time.Time
+}
+
+func newIPBlocklist() *ipBlocklist {
+	return &ipBlocklist{until: make(map[string]time.Time), now: time.Now}
+}
+
+// Block blocks ip for the given duration
+func (b *ipBlocklist) Block(ip string, duration time.Duration) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	now := b.now()
+	if now.Sub(b.swept) >= blocklistSweepInterval {
+		b.sweep(now)
+	}
+	if _, ok := b.until[ip]; !ok && len(b.until) >= maxBlockedIPs {
+		b.sweep(now)
+		if len(b.until) >= maxBlockedIPs {
+			b.evictSoonest()
+		}
+	}
+	b.until[ip] = now.Add(duration)
+}
+
+// Blocked reports whether ip is currently blocked, forgetting expired entries
+func (b *ipBlocklist) Blocked(ip string) bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	until, ok := b.until[ip]
+	if !ok {
+		return false
+	}
+	if b.now().After(until) {
+		delete(b.until, ip)
+		return false
+	}
+	return true
+}
+
+// sweep forgets expired entries. The caller holds the mutex.
+func (b *ipBlocklist) sweep(now time.Time) {
+	b.swept = now
+	for ip, until := range b.until {
+		if now.After(until) {
+			delete(b.until, ip)
+		}
+	}
+}
+
+// evictSoonest forgets the entry that expires first. The caller holds the
+// mutex.
+func (b *ipBlocklist) evictSoonest() {
+	var soonest string
+	var soonestUntil time.Time
+	for ip, until := range b.until {
+		if soonest == "" || until.Before(soonestUntil) {
+			soonest, soonestUntil = ip, until
+		}
+	}
+	delete(b.until, soonest)
+}
+
+// clientIP returns the IP of the directly connected client
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// isLoopback reports whether the request came from this machine (including unix sockets)
+func isLoopback(r *http.Request) bool {
+	ip := net.ParseIP(clientIP(r))
+	return ip == nil || ip.IsLoopback()
+}
@@ -0,0 +1,61 @@
+package api
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestIPBlocklist(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	b := newIPBlocklist()
+	b.now = func() time.Time { return now }
+
+	b.Block("10.0.0.1", time.Minute)
+	if !b.Blocked("10.0.0.1") || b.Blocked("10.0.0.2") {
+		t.Fatal("block not applied")
+	}
+	now = now.Add(2 * time.Minute)
+	if b.Blocked("10.0.0.1") {
+		t.Error("expired block still applied")
+	}
+
+	// Expired entries nobody asks about again are swept on a later block
+	b.Block("10.0.0.3", time.Minute)
+	now = now.Add(blocklistSweepInterval)
+	b.Block("10.0.0.4", time.Hour)
+	if _, ok := b.until["10.0.0.3"]; ok || len(b.until) != 1 {
+		t.Errorf("entries after sweep: %v", b.until)
+	}
+}
+
+func TestIPBlocklistCap(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	b := newIPBlocklist()
+	b.now = func() time.Time { return now }
+
+	for i := 0; i < maxBlockedIPs; i++ {
+		b.Block(fmt.Sprintf("ip-%d", i), time.Hour+time.Duration(i)*time.Second)
+	}
+	// A full list evicts the block that ends soonest
+	b.Block("scanner", time.Hour)
+	if len(b.until) != maxBlockedIPs {
+		t.Errorf("%d entries, want %d", len(b.until), maxBlockedIPs)
+	}
+	if b.Blocked("ip-0") || !b.Blocked("ip-1") || !b.Blocked("scanner") {
+		t.Error("wrong entry evicted")
+	}
+
+	// Re-blocking a listed IP evicts nothing
+	b.Block("ip-1", time.Hour)
+	if !b.Blocked("ip-2") || len(b.until) != maxBlockedIPs {
+		t.Error("re-block evicted an entry")
+	}
+
+	// Expired entries make room before anything is evicted
+	now = now.Add(time.Hour + 10*time.Second)
+	b.Block("late", time.Hour)
+	if len(b.until) >= maxBlockedIPs || !b.Blocked("ip-100") || !b.Blocked("late") {
+		t.Errorf("%d entries after expiry", len(b.until))
+	}
+}
@@ -0,0 +1,103 @@
+package api
+
+import (
+	"encoding/json"
+	stderrors "errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/devstroop/reai/pkg/errors"
+)
+
+// limitBody caps the request body at MAX_REQUEST_BODY_BYTES. Requests that
+// announce a larger body are rejected before it is read.
+func (s *Server) limitBody(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		limit := s.config.MaxRequestBodyBytes
+		if limit <= 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if r.ContentLength > limit {
+			errors.WriteErrorResponse(w, bodyTooLargeError(limit))
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, limit)
+		next.ServeHTTP(w, r)
+	})
+}
+
+func bodyTooLargeError(limit int64) *errors.APIError {
+	return errors.NewRequestTooLargeError(fmt.Sprintf("Request body too large: the limit is %d bytes", limit))
+}
+
+// bodyReadError reports a request body that could not be read
+func bodyReadError(err error) *errors.APIError {
+	var tooLarge *http.MaxBytesError
+	if stderrors.As(err, &tooLarge) {
+		return bodyTooLargeError(tooLarge.Limit)
+	}
+	return errors.NewValidationError("Invalid JSON format")
+}
+
+// readJSON reads a JSON request body and checks its size and nesting before
+// anything is decoded
+func (s *Server) readJSON(r *http.Request) ([]byte, error) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, bodyReadError(err)
+	}
+	if err := s.checkJSONDepth(body); err != nil {
+		return nil, err
+	}
+	return body, nil
+}
+
+// checkJSONDepth rejects JSON nested deeper than MAX_JSON_DEPTH
+func (s *Server) checkJSONDepth(data []byte) error {
+	if max := s.config.MaxJSONDepth; max > 0 && jsonDepth(data) > max {
+		return errors.NewInvalidRequestError(fmt.Sprintf("JSON nested too deeply: the limit is %d levels", max))
+	}
+	return nil
+}
+
+// decodeJSON decodes a JSON request body into v, see readJSON
+func (s *Server) decodeJSON(r *http.Request, v interface{}) error {
+	body, err := s.readJSON(r)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(body, v); err != nil {
+		return errors.NewValidationError("Invalid JSON format")
+	}
+	return nil
+}
+
+// jsonDepth returns the deepest nesting of objects and arrays in data. It
+// doesn't validate the JSON; that is left to the decoder.
+func jsonDepth(data []byte) int {
+	depth, deepest := 0, 0
+	inString, escaped := false, false
+	for _, c := range data {
+		switch {
+		case inString:
+			switch {
+			case escaped:
+				escaped = false
+			case c == '\\':
+				escaped = true
+			case c == '"':
+				inString = false
+			}
+		case c == '"':
+			inString = true
+		case c == '{' || c == '[':
+			depth++
+			deepest = max(deepest, depth)
+		case c == '}' || c == ']':
+			depth--
+		}
+	}
+	return deepest
+}
@@ -0,0 +1,158 @@
+package api
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/devstroop/reai/internal/config"
+	"github.com/devstroop/reai/internal/metrics"
+)
+
+// Middleware wraps a handler, e.g. to log or rewrite requests
+type Middleware func(http.Handler) http.Handler
+
+// namedMiddleware is a middleware of the chain around every endpoint
+type namedMiddleware struct {
+	name string
+	wrap Middleware
+}
+
+var (
+	httpRequests        = metrics.NewCounterVec("reai_http_requests_total", "HTTP requests by method and status code", "method", "code")
+	httpRequestDuration = metrics.NewHistogram("reai_http_request_duration_seconds", "Time taken to answer HTTP requests, including streams", []float64{0.005, 0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60})
+)
+
+// Use adds a middleware to the chain around every endpoint, inside the
+// built-in ones unless MIDDLEWARE_ORDER places it. Call it before Router.
+func (s *Server) Use(name string, middleware Middleware) {
+	s.middlewares = append(s.middlewares, namedMiddleware{name: strings.ToLower(name), wrap: middleware})
+}
+
+// builtinMiddlewares are the built-in middlewares in their default order,
+// outermost first. Authentication, rate limiting and auditing depend on the
+// endpoint, so they are applied per route instead.
+func (s *Server) builtinMiddlewares() []namedMiddleware {
+	return []namedMiddleware{
+		{"logging", s.loggingMiddleware},
+		{"metrics", s.metricsMiddleware},
+		{"cors", s.corsMiddleware},
+		{"compression", s.compressionMiddleware},
+		{"deadline", s.deadlineMiddleware},
+	}
+}
+
+// routeMiddlewares are the middlewares applied per route, since they depend on
+// the endpoint, and the settings that switch them
+var routeMiddlewares = map[string]string{
+	"auth":       "API_KEYS",
+	"ratelimit":  "RATE_LIMIT and IP_RATE_LIMIT",
+	"rate_limit": "RATE_LIMIT and IP_RATE_LIMIT",
+	"audit":      "AUDIT_LOG",
+}
+
+// checkMiddlewareNames rejects MIDDLEWARE_ORDER and MIDDLEWARE_DISABLE lists
+// naming a per-route middleware, which the chain can neither place nor drop
+func checkMiddlewareNames(cfg *config.Config) error {
+	for _, setting := range []struct{ name, list string }{
+		{"MIDDLEWARE_ORDER", cfg.MiddlewareOrder},
+		{"MIDDLEWARE_DISABLE", cfg.MiddlewareDisable},
+	} {
+		for _, name := range splitNames(setting.list) {
+			if switches, ok := routeMiddlewares[name]; ok {
+				return fmt.Errorf("%s names %s, which runs per route and can't be ordered or disabled; it is configured with %s", setting.name, name, switches)
+			}
+		}
+	}
+	return nil
+}
+
+// middlewareChain returns the middlewares around every endpoint, outermost
+// first: MIDDLEWARE_ORDER when set, else the built-ins followed by the ones
+// added with Use, less MIDDLEWARE_DISABLE
+func (s *Server) middlewareChain() []namedMiddleware {
+	available := append(s.builtinMiddlewares(), s.middlewares...)
+	disabled := make(map[string]bool)
+	for _, name := range splitNames(s.config.MiddlewareDisable) {
+		disabled[name] = true
+	}
+
+	chain := available
+	if order := splitNames(s.config.MiddlewareOrder); len(order) > 0 {
+		byName := make(map[string]namedMiddleware, len(available))
+		for _, m := range available {
+			byName[m.name] = m
+		}
+		chain = nil
+		listed := make(map[string]bool)
+		for _, name := range order {
+			m, ok := byName[name]
+			if !ok {
+				slog.Warn("Unknown middleware in MIDDLEWARE_ORDER", "middleware", name)
+				continue
+			}
+			listed[name] = true
+			chain = append(chain, m)
+		}
+		for _, m := range available {
+			if !listed[m.name] && !disabled[m.name] {
+				slog.Warn("Middleware left out by MIDDLEWARE_ORDER", "middleware", m.name)
+			}
+		}
+	}
+
+	enabled := chain[:0:0]
+	for _, m := range chain {
+		if !disabled[m.name] {
+			enabled = append(enabled, m)
+		}
+	}
+	return enabled
+}
+
+// wrapMiddlewares applies the middleware chain around handler
+func (s *Server) wrapMiddlewares(handler http.Handler) http.Handler {
+	chain := s.middlewareChain()
+	names := make([]string, len(chain))
+	for i := len(chain) - 1; i >= 0; i-- {
+		handler = chain[i].wrap(handler)
+		names[i] = chain[i].name
+	}
+	slog.Debug("Middleware chain", "order", strings.Join(names, ","))
+	return handler
+}
+
+// metricsMiddleware counts requests by status and records their duration
+func (s *Server) metricsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		wrapped := &responseWriter{ResponseWriter: w, statusCode: http.StatusOK}
+		next.ServeHTTP(wrapped, r)
+		httpRequests.With(methodLabel(r.Method), strconv.Itoa(wrapped.statusCode)).Inc()
+		httpRequestDuration.Observe(time.Since(start).Seconds())
+	})
+}
+
+// methodLabel keeps the method label to the standard methods
+func methodLabel(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodOptions:
+		return method
+	}
+	return "OTHER"
+}
+
+// splitNames parses a comma separated list of middleware names
+func splitNames(list string) []string {
+	var names []string
+	for _, name := range strings.Split(list, ",") {
+		if name = strings.ToLower(strings.TrimSpace(name)); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
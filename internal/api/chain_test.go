@@ -0,0 +1,112 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/devstroop/reai/internal/config"
+	"github.com/devstroop/reai/internal/copilot"
+)
+
+// chainNames returns the names of the middleware chain, outermost first
+func chainNames(s *Server) string {
+	var names []string
+	for _, m := range s.middlewareChain() {
+		names = append(names, m.name)
+	}
+	return strings.Join(names, ",")
+}
+
+func TestMiddlewareOrder(t *testing.T) {
+	var served []string
+	record := func(name string) Middleware {
+		return func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				served = append(served, name)
+				next.ServeHTTP(w, r)
+			})
+		}
+	}
+
+	tests := []struct {
+		name  string
+		env   map[string]string
+		chain string
+		order string
+	}{
+		{
+			name:  "default",
+			chain: "logging,metrics,cors,compression,deadline,second,first",
+			order: "second,first",
+		},
+		{
+			name:  "ordered",
+			env:   map[string]string{"MIDDLEWARE_ORDER": "First, compression,logging,second"},
+			chain: "first,compression,logging,second",
+			order: "first,second",
+		},
+		{
+			name:  "disabled",
+			env:   map[string]string{"MIDDLEWARE_ORDER": "second,deadline,first,cors", "MIDDLEWARE_DISABLE": "cors,deadline"},
+			chain: "second,first",
+			order: "second,first",
+		},
+		{
+			name:  "unknown names skipped",
+			env:   map[string]string{"MIDDLEWARE_ORDER": "tracing,first,logging", "MIDDLEWARE_DISABLE": "second"},
+			chain: "first,logging",
+			order: "first",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newMockServer(t, tt.env)
+			server.Use("second", record("second"))
+			server.Use("First", record("first"))
+			if got := chainNames(server); got != tt.chain {
+				t.Errorf("chain %s, want %s", got, tt.chain)
+			}
+
+			served = nil
+			server.Router().ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/health/live", nil))
+			if got := strings.Join(served, ","); got != tt.order {
+				t.Errorf("served by %s, want %s", got, tt.order)
+			}
+		})
+	}
+}
+
+func TestRouteMiddlewaresRejected(t *testing.T) {
+	tests := map[string]string{
+		"MIDDLEWARE_ORDER":   "logging,auth,metrics",
+		"MIDDLEWARE_DISABLE": "cors,ratelimit",
+	}
+	for setting, list := range tests {
+		t.Setenv("DATA_DIR", t.TempDir())
+		t.Setenv("UPSTREAM_MODE", "mock")
+		t.Setenv("MIDDLEWARE_ORDER", "")
+		t.Setenv("MIDDLEWARE_DISABLE", "")
+		t.Setenv(setting, list)
+		cfg := config.LoadFromEnv()
+		client, err := copilot.NewClient(cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		server, err := NewServer(cfg, client)
+		if err == nil {
+			server.Close(context.Background())
+			t.Errorf("%s=%s accepted", setting, list)
+			continue
+		}
+		if !strings.Contains(err.Error(), setting) || !strings.Contains(err.Error(), "per route") {
+			t.Errorf("%s: unclear error %q", setting, err)
+		}
+	}
+
+	if err := checkMiddlewareNames(&config.Config{MiddlewareOrder: "logging,audit"}); err == nil || !strings.Contains(err.Error(), "AUDIT_LOG") {
+		t.Errorf("audit: %v", err)
+	}
+}
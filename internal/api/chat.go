@@ -0,0 +1,709 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/devstroop/reai/internal/copilot"
+	"github.com/devstroop/reai/pkg/errors"
+)
+
+// maxChatTopLogprobs is the largest top_logprobs value accepted by the chat API
+const maxChatTopLogprobs = 20
+
+// unknownLogprob is reported for tokens upstream sent without a log probability,
+// matching the value OpenAI uses for very unlikely tokens
+const unknownLogprob = -9999.0
+
+// toolNamePattern is what OpenAI accepts as a function name
+var toolNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// ChatMessage represents a chat message. In a tool loop, assistant messages
+// carry the tool calls the model made (their content may be null) and each
+// result comes back as a tool message naming the call it answers.
+type ChatMessage struct {
+	Role       string             `json:"role"`
+	Content    string             `json:"content"`
+	Name       string             `json:"name,omitempty"`
+	ToolCalls  []copilot.ToolCall `json:"tool_calls,omitempty"`
+	ToolCallID string             `json:"tool_call_id,omitempty"`
+	// ReasoningContent is what the model thought before answering, in
+	// responses to include_reasoning requests. It is not sent upstream.
+	ReasoningContent string `json:"reasoning_content,omitempty"`
+}
+
+// ChatCompletionRequest represents a chat completion request
+type ChatCompletionRequest struct {
+	Model         string         `json:"model,omitempty"`
+	Messages      []ChatMessage  `json:"messages"`
+	MaxTokens     int            `json:"max_tokens,omitempty"`
+	Temperature   *float64       `json:"temperature,omitempty"`
+	Stream        bool           `json:"stream,omitempty"`
+	Logprobs      bool           `json:"logprobs,omitempty"`
+	TopLogprobs   *int           `json:"top_logprobs,omitempty"`
+	StreamOptions *StreamOptions `json:"stream_options,omitempty"`
+	// ConversationID continues a stored conversation
+	ConversationID string `json:"conversation_id,omitempty"`
+	// PreviousResponseID continues the stored conversation from a response
+	PreviousResponseID string `json:"previous_response_id,omitempty"`
+	// Store starts a new stored conversation
+	Store bool `json:"store,omitempty"`
+	// Template names a stored prompt template rendered with Variables and
+	// put in front of Messages
+	Template  string                 `json:"template,omitempty"`
+	Variables map[string]interface{} `json:"variables,omitempty"`
+	// MaxCompletionTokens is the newer name of MaxTokens, which reasoning
+	// models require
+	MaxCompletionTokens int `json:"max_completion_tokens,omitempty"`
+	// ReasoningEffort sets how much reasoning models think before answering
+	ReasoningEffort string `json:"reasoning_effort,omitempty"`
+	// IncludeReasoning returns the reasoning of models that stream it in
+	// reasoning_content instead of dropping it
+	IncludeReasoning bool `json:"include_reasoning,omitempty"`
+	// Tools are the functions the model may call instead of answering.
+	// ToolChoice is "none", "auto", "required" or a function to call.
+	Tools             []copilot.Tool  `json:"tools,omitempty"`
+	ToolChoice        json.RawMessage `json:"tool_choice,omitempty"`
+	ParallelToolCalls *bool           `json:"parallel_tool_calls,omitempty"`
+	SamplingParameters
+}
+
+// ChatCompletionChoice represents a single chat completion choice
+type ChatCompletionChoice struct {
+	Index        int           `json:"index"`
+	Message      ChatMessage   `json:"message"`
+	Logprobs     *ChatLogprobs `json:"logprobs"`
+	FinishReason string        `json:"finish_reason"`
+}
+
+// ChatCompletionResponse represents a chat completion response
+type ChatCompletionResponse struct {
+	ID      string                 `json:"id"`
+	Object  string                 `json:"object"`
+	Created int64                  `json:"created"`
+	Model   string                 `json:"model"`
+	Choices []ChatCompletionChoice `json:"choices"`
+	Usage   *Usage                 `json:"usage,omitempty"`
+	// ConversationID is set when the response was added to a stored conversation
+	ConversationID string `json:"conversation_id,omitempty"`
+	// Extensions is the x_reai object, omitted when RESPONSE_EXTENSIONS is off
+	Extensions *Extensions `json:"x_reai,omitempty"`
+}
+
+// ChatMessageDelta is the incremental part of a streamed chat message
+type ChatMessageDelta struct {
+	Role             string              `json:"role,omitempty"`
+	Content          string              `json:"content,omitempty"`
+	ReasoningContent string              `json:"reasoning_content,omitempty"`
+	ToolCalls        []ChatToolCallDelta `json:"tool_calls,omitempty"`
+}
+
+// ChatToolCallDelta is a streamed piece of a tool call. Only the first piece
+// of a call has its ID, type and function name; the arguments are appended
+// piece by piece.
+type ChatToolCallDelta struct {
+	Index    int               `json:"index"`
+	ID       string            `json:"id,omitempty"`
+	Type     string            `json:"type,omitempty"`
+	Function ChatFunctionDelta `json:"function"`
+}
+
+// ChatFunctionDelta is the function part of a streamed tool call
+type ChatFunctionDelta struct {
+	Name      string `json:"name,omitempty"`
+	Arguments string `json:"arguments"`
+}
+
+// ChatCompletionChunkChoice represents a single choice of a streamed chunk
+type ChatCompletionChunkChoice struct {
+	Index        int              `json:"index"`
+	Delta        ChatMessageDelta `json:"delta"`
+	Logprobs     *ChatLogprobs    `json:"logprobs"`
+	FinishReason *string          `json:"finish_reason"`
+}
+
+// ChatCompletionChunk represents a streamed chat completion chunk
+type ChatCompletionChunk struct {
+	ID      string                      `json:"id"`
+	Object  string                      `json:"object"`
+	Created int64                       `json:"created"`
+	Model   string                      `json:"model"`
+	Choices []ChatCompletionChunkChoice `json:"choices"`
+	// ConversationID is set when the response is added to a stored conversation
+	ConversationID string `json:"conversation_id,omitempty"`
+	// Usage is only set on the usage chunk requested with stream_options
+	Usage *Usage `json:"usage,omitempty"`
+	// Extensions is only set on the final chunk
+	Extensions *Extensions `json:"x_reai,omitempty"`
+}
+
+// ChatLogprobs holds token log probabilities in the chat completions format
+type ChatLogprobs struct {
+	Content []ChatTokenLogprob `json:"content"`
+}
+
+// ChatTokenLogprob is the log probability of a single output token
+type ChatTokenLogprob struct {
+	Token       string           `json:"token"`
+	Logprob     float64          `json:"logprob"`
+	Bytes       []int            `json:"bytes"`
+	TopLogprobs []ChatTopLogprob `json:"top_logprobs"`
+}
+
+// ChatTopLogprob is one of the most likely alternatives for a token position
+type ChatTopLogprob struct {
+	Token   string  `json:"token"`
+	Logprob float64 `json:"logprob"`
+	Bytes   []int   `json:"bytes"`
+}
+
+// chatCompletion is a validated chat request ready to be sent upstream
+type chatCompletion struct {
+	upstream    *copilot.CompletionRequest
+	model       string
+	topLogprobs int
+	// includeUsage adds the usage chunk to a stream
+	includeUsage bool
+	// conversation is set when the request continues a stored conversation
+	conversation *conversationTurn
+	// trim is set when the history was shortened to fit the context window
+	trim *contextTrim
+}
+
+// handleChatCompletions handles chat completion requests
+func (s *Server) handleChatCompletions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req ChatCompletionRequest
+	if err := s.decodeRequest(r, chatRequestFields, &req); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	chat, err := s.prepareChatCompletion(r.Context(), &req)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	s.warnIgnoredParameters(w, chat.upstream)
+
+	if req.Stream {
+		s.streamChatCompletion(w, r, chat)
+		return
+	}
+
+	id := generateID()
+	ex := s.startExchange(w, r, id, chat.model, chat.upstream, false)
+	conversationID := applyConversationTurn(w, ex, chat.conversation)
+	applyContextTrim(w, ex, chat.trim)
+
+	ctx := r.Context()
+	completion, err := s.providers.GetCompletion(ctx, chat.upstream)
+	if err != nil {
+		ex.finish("", err)
+		writeError(w, err)
+		return
+	}
+	ex.served(chat.upstream.Backend)
+	s.filterCompletion(ex, completion)
+	ex.completion.Write(completion.Text)
+	ex.finish(completion.FinishReason, nil)
+
+	// Create OpenAI-compatible response
+	message := ChatMessage{
+		Role:      "assistant",
+		Content:   completion.Text,
+		ToolCalls: completion.ToolCalls,
+	}
+	if chat.conversation != nil {
+		s.saveConversationTurn(ctx, chat.conversation, chat.model, id, message)
+	}
+	if chat.upstream.IncludeReasoning {
+		message.ReasoningContent = completion.Reasoning
+	}
+	response := ChatCompletionResponse{
+		ID:      id,
+		Object:  "chat.completion",
+		Created: time.Now().Unix(),
+		Model:   chat.model,
+		Choices: []ChatCompletionChoice{
+			{
+				Index:        0,
+				Message:      message,
+				Logprobs:     toChatLogprobs(completion.Logprobs, chat.topLogprobs),
+				FinishReason: completion.FinishReason,
+			},
+		},
+		Usage:          ex.tokenUsage(),
+		ConversationID: conversationID,
+		Extensions:     ex.extensions(),
+	}
+	if err := s.checkStrict(response); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// prepareChatCompletion validates a chat request, applies the key parameters
+// and prompt filters and builds the upstream request
+func (s *Server) prepareChatCompletion(ctx context.Context, req *ChatCompletionRequest) (*chatCompletion, error) {
+	if err := s.applyPromptTemplate(req); err != nil {
+		return nil, err
+	}
+	if len(req.Messages) == 0 {
+		return nil, errors.NewValidationError("Messages are required")
+	}
+
+	if req.TopLogprobs != nil {
+		if !req.Logprobs {
+			return nil, errors.NewValidationError("top_logprobs requires logprobs to be true")
+		}
+		if *req.TopLogprobs < 0 || *req.TopLogprobs > maxChatTopLogprobs {
+			return nil, errors.NewValidationError("top_logprobs must be between 0 and 20")
+		}
+	}
+
+	if err := req.SamplingParameters.validate(); err != nil {
+		return nil, err
+	}
+	if err := req.validateReasoning(); err != nil {
+		return nil, err
+	}
+	if err := validateStreamOptions(req.StreamOptions, req.Stream); err != nil {
+		return nil, err
+	}
+	if err := req.validateTools(); err != nil {
+		return nil, err
+	}
+
+	if model, ok := deploymentModel(ctx); ok {
+		req.Model = model
+	}
+	applyChatKeyParameters(ctx, req)
+
+	turn, err := s.continueConversation(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateToolMessages(req.Messages); err != nil {
+		return nil, err
+	}
+
+	// Messages are filtered one by one, since providers other than Copilot
+	// receive them as they are. Tool call arguments are filtered too.
+	filtered := make([]ChatMessage, 0, len(req.Messages))
+	messages := make([]copilot.Message, 0, len(req.Messages))
+	for _, msg := range req.Messages {
+		content, err := s.filterPrompt(msg.Content)
+		if err != nil {
+			return nil, err
+		}
+		var calls []copilot.ToolCall
+		for _, call := range msg.ToolCalls {
+			if call.Function.Arguments, err = s.filterPrompt(call.Function.Arguments); err != nil {
+				return nil, err
+			}
+			calls = append(calls, call)
+		}
+		msg.Content, msg.ToolCalls = content, calls
+		filtered = append(filtered, msg)
+	}
+
+	assignment := s.assignExperiment(ctx, getDefaultOrString(req.Model, "gpt-4"))
+	model := assignment.Model
+	history, current := splitCurrentTurn(filtered, turn)
+	filtered, trim := s.trimContext(ctx, model, req.MaxTokens, history, current, turn != nil)
+	for _, msg := range filtered {
+		messages = append(messages, copilot.Message{Role: msg.Role, Content: msg.Content, Name: msg.Name, ToolCalls: msg.ToolCalls, ToolCallID: msg.ToolCallID})
+	}
+
+	chat := &chatCompletion{
+		upstream: &copilot.CompletionRequest{
+			Model:       model,
+			Messages:    messages,
+			Prompt:      chatPrompt(filtered),
+			Language:    "text",
+			MaxTokens:   req.MaxTokens,
+			Temperature: req.Temperature,
+			Stream:      req.Stream,
+		},
+		model:        model,
+		includeUsage: req.StreamOptions.includeUsage(),
+		conversation: turn,
+		trim:         trim,
+	}
+	if req.Logprobs {
+		if req.TopLogprobs != nil {
+			chat.topLogprobs = *req.TopLogprobs
+		}
+		chat.upstream.Logprobs = &chat.topLogprobs
+	}
+	req.SamplingParameters.apply(chat.upstream)
+	chat.upstream.ReasoningEffort = req.ReasoningEffort
+	chat.upstream.IncludeReasoning = req.IncludeReasoning
+	chat.upstream.Tools, chat.upstream.ToolChoice = req.Tools, req.ToolChoice
+	chat.upstream.ParallelToolCalls = req.ParallelToolCalls
+	chat.upstream.Experiment, chat.upstream.Variant = assignment.Experiment, assignment.Variant
+	chat.upstream.ShapeForModel()
+
+	if err := s.applyContextLimits(ctx, chat.model, chat.upstream); err != nil {
+		return nil, err
+	}
+	return chat, nil
+}
+
+// splitCurrentTurn splits messages into the history and the turn being asked
+// about: the new messages of a stored conversation, or everything from the
+// last user message on
+func splitCurrentTurn(messages []ChatMessage, turn *conversationTurn) ([]ChatMessage, []ChatMessage) {
+	start := len(messages) - 1
+	if turn != nil {
+		start = len(messages) - len(turn.messages)
+	} else {
+		for i := len(messages) - 1; i >= 0; i-- {
+			if messages[i].Role == "user" {
+				start = i
+				break
+			}
+		}
+	}
+	if start < 0 {
+		start = 0
+	}
+	return messages[:start], messages[start:]
+}
+
+// validateReasoning checks reasoning_effort and folds max_completion_tokens
+// into max_tokens
+func (req *ChatCompletionRequest) validateReasoning() error {
+	switch req.ReasoningEffort {
+	case "", "minimal", "low", "medium", "high":
+	default:
+		return errors.NewValidationError("reasoning_effort must be minimal, low, medium or high")
+	}
+	if req.MaxCompletionTokens < 0 {
+		return errors.NewValidationError("max_completion_tokens must not be negative")
+	}
+	if req.MaxCompletionTokens > 0 {
+		if req.MaxTokens > 0 && req.MaxTokens != req.MaxCompletionTokens {
+			return errors.NewValidationError("max_tokens and max_completion_tokens must not differ")
+		}
+		req.MaxTokens = req.MaxCompletionTokens
+	}
+	return nil
+}
+
+// validateTools checks the tools of a request and that tool_choice names one
+// of them
+func (req *ChatCompletionRequest) validateTools() error {
+	names := make(map[string]bool, len(req.Tools))
+	for i := range req.Tools {
+		tool := &req.Tools[i]
+		if tool.Type == "" {
+			tool.Type = "function"
+		}
+		switch {
+		case tool.Type != "function":
+			return errors.NewValidationError(fmt.Sprintf("tools[%d]: type must be function", i))
+		case !toolNamePattern.MatchString(tool.Function.Name):
+			return errors.NewValidationError(fmt.Sprintf("tools[%d]: function.name must be 1 to 64 letters, digits, underscores or dashes", i))
+		case names[tool.Function.Name]:
+			return errors.NewValidationError(fmt.Sprintf("tools[%d]: duplicate function name %s", i, tool.Function.Name))
+		case len(tool.Function.Parameters) > 0 && !isJSONObject(tool.Function.Parameters):
+			return errors.NewValidationError(fmt.Sprintf("tools[%d]: function.parameters must be a JSON schema object", i))
+		}
+		names[tool.Function.Name] = true
+	}
+
+	if len(req.ToolChoice) == 0 || string(req.ToolChoice) == "null" {
+		req.ToolChoice = nil
+		return nil
+	}
+	if len(req.Tools) == 0 {
+		return errors.NewValidationError("tool_choice requires tools")
+	}
+	var mode string
+	if json.Unmarshal(req.ToolChoice, &mode) == nil {
+		switch mode {
+		case "none", "auto", "required":
+			return nil
+		}
+		return errors.NewValidationError("tool_choice must be none, auto, required or a function")
+	}
+	var choice struct {
+		Type     string `json:"type"`
+		Function struct {
+			Name string `json:"name"`
+		} `json:"function"`
+	}
+	if err := json.Unmarshal(req.ToolChoice, &choice); err != nil || choice.Type != "function" {
+		return errors.NewValidationError("tool_choice must be none, auto, required or a function")
+	}
+	if !names[choice.Function.Name] {
+		return errors.NewValidationError(fmt.Sprintf("tool_choice names unknown function %q", choice.Function.Name))
+	}
+	return nil
+}
+
+// isJSONObject reports whether data is a JSON object
+func isJSONObject(data json.RawMessage) bool {
+	var object map[string]json.RawMessage
+	return json.Unmarshal(data, &object) == nil && object != nil
+}
+
+// chatPrompt converts chat messages to a simple prompt for providers that
+// only take text; Copilot gets the messages themselves. Earlier replies are
+// kept, and the tool calls of assistant messages and their results are
+// written out as lines.
+func chatPrompt(messages []ChatMessage) string {
+	var prompt string
+	tools := make(map[string]string)
+	for _, msg := range messages {
+		switch msg.Role {
+		case "system", "user":
+			prompt += msg.Content + "\n"
+		case "assistant":
+			if msg.Content != "" {
+				prompt += msg.Content + "\n"
+			}
+			for _, call := range msg.ToolCalls {
+				tools[call.ID] = call.Function.Name
+				prompt += "Tool call " + call.ID + ": " + call.Function.Name + "(" + call.Function.Arguments + ")\n"
+			}
+		case "tool":
+			name := msg.Name
+			if name == "" {
+				name = tools[msg.ToolCallID]
+			}
+			prompt += "Tool result " + msg.ToolCallID + " from " + name + ": " + msg.Content + "\n"
+		}
+	}
+	return prompt
+}
+
+// validateToolMessages checks the tool calls of a chat history the way
+// OpenAI does: only assistant messages make tool calls, and every call is
+// answered by a tool message naming its ID before the conversation moves on.
+// Parallel calls may be answered in any order.
+func validateToolMessages(messages []ChatMessage) error {
+	pending := make(map[string]bool)
+	for i, msg := range messages {
+		if msg.Role != "tool" && len(pending) > 0 {
+			return errors.NewValidationError(fmt.Sprintf("messages[%d]: the tool calls of the previous assistant message must each be answered by a tool message", i))
+		}
+		if len(msg.ToolCalls) > 0 && msg.Role != "assistant" {
+			return errors.NewValidationError(fmt.Sprintf("messages[%d]: only assistant messages may have tool_calls", i))
+		}
+		for j, call := range msg.ToolCalls {
+			switch {
+			case call.ID == "":
+				return errors.NewValidationError(fmt.Sprintf("messages[%d].tool_calls[%d]: id is required", i, j))
+			case call.Type != "" && call.Type != "function":
+				return errors.NewValidationError(fmt.Sprintf("messages[%d].tool_calls[%d]: type must be function", i, j))
+			case call.Function.Name == "":
+				return errors.NewValidationError(fmt.Sprintf("messages[%d].tool_calls[%d]: function.name is required", i, j))
+			case pending[call.ID]:
+				return errors.NewValidationError(fmt.Sprintf("messages[%d].tool_calls[%d]: duplicate id %s", i, j, call.ID))
+			}
+			pending[call.ID] = true
+		}
+		if msg.Role != "tool" {
+			if msg.ToolCallID != "" {
+				return errors.NewValidationError(fmt.Sprintf("messages[%d]: only tool messages may have tool_call_id", i))
+			}
+			continue
+		}
+		if msg.ToolCallID == "" {
+			return errors.NewValidationError(fmt.Sprintf("messages[%d]: tool messages require tool_call_id", i))
+		}
+		if !pending[msg.ToolCallID] {
+			return errors.NewValidationError(fmt.Sprintf("messages[%d]: tool_call_id %s does not answer a tool call of the preceding assistant message", i, msg.ToolCallID))
+		}
+		delete(pending, msg.ToolCallID)
+	}
+	if len(pending) > 0 {
+		return errors.NewValidationError("the tool calls of the last assistant message must each be answered by a tool message")
+	}
+	return nil
+}
+
+// streamChatCompletion streams a chat completion as server-sent events
+func (s *Server) streamChatCompletion(w http.ResponseWriter, r *http.Request, chat *chatCompletion) {
+	stream := s.newSSEWriter(w, r)
+	id := generateID()
+	ex := s.startExchange(w, r, id, chat.model, chat.upstream, true)
+	conversationID := applyConversationTurn(w, ex, chat.conversation)
+	applyContextTrim(w, ex, chat.trim)
+	stream.Open(r.Context())
+
+	// The reply is reassembled here for the conversation store, since the
+	// exchange transcript is bounded by the audit policy
+	var reply strings.Builder
+	var calls []copilot.ToolCall
+	var created int64
+	finishReason, err := s.streamChat(r.Context(), chat, ex, func(chunk ChatCompletionChunk) error {
+		chunk.ConversationID = conversationID
+		created = chunk.Created
+		for _, choice := range chunk.Choices {
+			reply.WriteString(choice.Delta.Content)
+			calls = copilot.AppendToolCalls(calls, fromChatToolCalls(choice.Delta.ToolCalls))
+		}
+		return stream.Send(chunk)
+	})
+	ex.finish(finishReason, err)
+	if err != nil {
+		stream.Fail(err)
+		return
+	}
+	if chat.conversation != nil {
+		s.saveConversationTurn(r.Context(), chat.conversation, chat.model, id, ChatMessage{Role: "assistant", Content: reply.String(), ToolCalls: calls})
+	}
+	if chat.includeUsage {
+		stream.Send(ChatCompletionChunk{
+			ID:      id,
+			Object:  "chat.completion.chunk",
+			Created: created,
+			Model:   chat.model,
+			Choices: []ChatCompletionChunkChoice{},
+			Usage:   ex.tokenUsage(),
+		})
+	}
+	stream.Done()
+}
+
+// streamChat runs a chat completion upstream and passes every chunk, ending
+// with the one carrying the finish reason, to send. The text is reassembled in
+// the exchange transcript. It returns the finish reason.
+func (s *Server) streamChat(ctx context.Context, chat *chatCompletion, ex *exchange, send func(ChatCompletionChunk) error) (string, error) {
+	created := time.Now().Unix()
+
+	chunk := func(delta ChatMessageDelta, logprobs *ChatLogprobs, finishReason *string) ChatCompletionChunk {
+		return ChatCompletionChunk{
+			ID:      ex.id,
+			Object:  "chat.completion.chunk",
+			Created: created,
+			Model:   chat.model,
+			Choices: []ChatCompletionChunkChoice{
+				{Index: 0, Delta: delta, Logprobs: logprobs, FinishReason: finishReason},
+			},
+		}
+	}
+
+	// The role is sent with the first delta
+	first := true
+	var think func(string) error
+	if chat.upstream.IncludeReasoning {
+		think = func(reasoning string) error {
+			delta := ChatMessageDelta{ReasoningContent: reasoning}
+			if first {
+				delta.Role = "assistant"
+				first = false
+			}
+			return send(chunk(delta, nil, nil))
+		}
+	}
+	finishReason, err := s.streamText(ctx, chat.upstream, ex, func(text string, logprobs *copilot.Logprobs) error {
+		delta := ChatMessageDelta{Content: text}
+		if first {
+			delta.Role = "assistant"
+			first = false
+		}
+		return send(chunk(delta, toChatLogprobs(logprobs, chat.topLogprobs), nil))
+	}, think, func(calls []copilot.ToolCallDelta) error {
+		delta := ChatMessageDelta{ToolCalls: toChatToolCalls(calls)}
+		if first {
+			delta.Role = "assistant"
+			first = false
+		}
+		return send(chunk(delta, nil, nil))
+	})
+	if err != nil {
+		return "", err
+	}
+
+	final := ChatMessageDelta{}
+	if first {
+		final.Role = "assistant"
+	}
+	last := chunk(final, nil, stringPtr(finishReason))
+	last.Extensions = ex.extensions()
+	if err := send(last); err != nil {
+		return "", err
+	}
+	return finishReason, nil
+}
+
+// toChatToolCalls converts streamed tool call pieces into the chat format
+func toChatToolCalls(calls []copilot.ToolCallDelta) []ChatToolCallDelta {
+	deltas := make([]ChatToolCallDelta, 0, len(calls))
+	for _, call := range calls {
+		delta := ChatToolCallDelta{Index: call.Index, ID: call.ID, Function: ChatFunctionDelta{Name: call.Name, Arguments: call.Arguments}}
+		if call.ID != "" {
+			delta.Type = "function"
+		}
+		deltas = append(deltas, delta)
+	}
+	return deltas
+}
+
+// fromChatToolCalls converts chat tool call pieces back for reassembly
+func fromChatToolCalls(deltas []ChatToolCallDelta) []copilot.ToolCallDelta {
+	var calls []copilot.ToolCallDelta
+	for _, delta := range deltas {
+		calls = append(calls, copilot.ToolCallDelta{Index: delta.Index, ID: delta.ID, Name: delta.Function.Name, Arguments: delta.Function.Arguments})
+	}
+	return calls
+}
+
+// toChatLogprobs converts completions-style logprobs into the chat format
+func toChatLogprobs(logprobs *copilot.Logprobs, topLogprobs int) *ChatLogprobs {
+	if logprobs == nil {
+		return nil
+	}
+
+	result := &ChatLogprobs{Content: make([]ChatTokenLogprob, 0, len(logprobs.Tokens))}
+	for i, token := range logprobs.Tokens {
+		entry := ChatTokenLogprob{
+			Token:       token,
+			Logprob:     unknownLogprob,
+			Bytes:       tokenBytes(token),
+			TopLogprobs: []ChatTopLogprob{},
+		}
+		if i < len(logprobs.TokenLogprobs) {
+			entry.Logprob = logprobs.TokenLogprobs[i]
+		}
+		if i < len(logprobs.TopLogprobs) {
+			for alt, lp := range logprobs.TopLogprobs[i] {
+				entry.TopLogprobs = append(entry.TopLogprobs, ChatTopLogprob{Token: alt, Logprob: lp, Bytes: tokenBytes(alt)})
+			}
+			sort.Slice(entry.TopLogprobs, func(a, b int) bool {
+				return entry.TopLogprobs[a].Logprob > entry.TopLogprobs[b].Logprob
+			})
+			if len(entry.TopLogprobs) > topLogprobs {
+				entry.TopLogprobs = entry.TopLogprobs[:topLogprobs]
+			}
+		}
+		result.Content = append(result.Content, entry)
+	}
+	return result
+}
+
+func tokenBytes(token string) []int {
+	bytes := make([]int, len(token))
+	for i := 0; i < len(token); i++ {
+		bytes[i] = int(token[i])
+	}
+	return bytes
+}
@@ -0,0 +1,328 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	stderrors "errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coder/websocket"
+	"github.com/coder/websocket/wsjson"
+	"github.com/devstroop/reai/internal/keys"
+	"github.com/devstroop/reai/pkg/errors"
+)
+
+// WebSocket chat message types
+const (
+	wsTypeRequest   = "chat.completion"
+	wsTypeCancel    = "cancel"
+	wsTypeChunk     = "chat.completion.chunk"
+	wsTypeDone      = "chat.completion.done"
+	wsTypeCancelled = "cancelled"
+	wsTypeError     = "error"
+)
+
+const (
+	// wsMaxInFlight bounds concurrent requests on one connection
+	wsMaxInFlight = 8
+	// wsPingInterval is how often the server pings; connections that do not
+	// answer before the next ping are closed
+	wsPingInterval = 30 * time.Second
+)
+
+// wsClientMessage is a frame sent by the client. Requests carry a client chosen
+// ID that tags every event of the response and is used to cancel it.
+type wsClientMessage struct {
+	Type    string                 `json:"type"`
+	ID      string                 `json:"id"`
+	Request *ChatCompletionRequest `json:"request,omitempty"`
+}
+
+// wsServerMessage is a frame sent by the server
+type wsServerMessage struct {
+	Type         string               `json:"type"`
+	ID           string               `json:"id,omitempty"`
+	Chunk        *ChatCompletionChunk `json:"chunk,omitempty"`
+	FinishReason string               `json:"finish_reason,omitempty"`
+	Usage        *Usage               `json:"usage,omitempty"`
+	Watermark    string               `json:"watermark,omitempty"`
+	Warning      string               `json:"warning,omitempty"`
+	Error        *errors.APIError     `json:"error,omitempty"`
+}
+
+// chatSession serves chat completions over one WebSocket connection
+type chatSession struct {
+	server *Server
+	conn   *websocket.Conn
+	// request is the upgrade request, carrying the authenticated key
+	request *http.Request
+
+	mutex    sync.Mutex
+	inflight map[string]context.CancelFunc
+	wg       sync.WaitGroup
+	// closing is set once the server drains; the connection closes when
+	// the requests in flight have finished
+	closing bool
+}
+
+// handleChatWebSocket upgrades to a WebSocket that carries chat completion
+// requests and their streamed responses
+func (s *Server) handleChatWebSocket(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet || !strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+		errors.WriteErrorResponse(w, errors.NewValidationError("a WebSocket upgrade is required"))
+		return
+	}
+	// API keys rather than cookies authenticate the socket, so cross-origin
+	// clients are as welcome as on the other /v1 routes
+	conn, err := websocket.Accept(hijackWriter{w}, r, &websocket.AcceptOptions{InsecureSkipVerify: true})
+	if err != nil {
+		slog.Debug("WebSocket upgrade failed", "error", err, "remote_addr", r.RemoteAddr)
+		return
+	}
+	conn.SetReadLimit(-1)
+	if limit := s.config.MaxRequestBodyBytes; limit > 0 {
+		conn.SetReadLimit(limit)
+	}
+	slog.Info("WebSocket chat session opened", "remote_addr", r.RemoteAddr)
+
+	session := &chatSession{
+		server:   s,
+		conn:     conn,
+		request:  r,
+		inflight: make(map[string]context.CancelFunc),
+	}
+	session.run()
+	slog.Info("WebSocket chat session closed", "remote_addr", r.RemoteAddr)
+}
+
+// run reads client messages until the connection closes
+func (cs *chatSession) run() {
+	ctx, cancel := context.WithCancel(cs.request.Context())
+	defer func() {
+		cancel()
+		cs.wg.Wait()
+		cs.conn.Close(websocket.StatusNormalClosure, "")
+	}()
+
+	go cs.ping(ctx)
+	go cs.closeOnDrain(ctx)
+
+	for {
+		_, data, err := cs.conn.Read(ctx)
+		if err != nil {
+			if websocket.CloseStatus(err) == -1 && !stderrors.Is(err, net.ErrClosed) {
+				slog.Debug("WebSocket read failed", "error", err)
+			}
+			return
+		}
+
+		if err := cs.server.checkJSONDepth(data); err != nil {
+			cs.send(wsServerMessage{Type: wsTypeError, Error: errors.WrapError(err)})
+			continue
+		}
+		var msg wsClientMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			cs.send(wsServerMessage{Type: wsTypeError, Error: errors.NewValidationError("Invalid JSON format")})
+			continue
+		}
+
+		switch msg.Type {
+		case wsTypeRequest:
+			cs.start(ctx, msg)
+		case wsTypeCancel:
+			cs.cancel(msg.ID)
+		default:
+			cs.send(wsServerMessage{Type: wsTypeError, ID: msg.ID, Error: errors.NewValidationError("unknown message type: " + msg.Type)})
+		}
+	}
+}
+
+// ping keeps the connection alive and detects dead peers
+func (cs *chatSession) ping(ctx context.Context) {
+	ticker := time.NewTicker(wsPingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			// The pong is read by run, which must keep reading meanwhile
+			pingCtx, cancel := context.WithTimeout(ctx, wsPingInterval)
+			err := cs.conn.Ping(pingCtx)
+			cancel()
+			if err != nil {
+				if ctx.Err() == nil {
+					slog.Info("WebSocket peer stopped responding", "remote_addr", cs.request.RemoteAddr, "error", err)
+					cs.conn.CloseNow()
+				}
+				return
+			}
+		}
+	}
+}
+
+// closeOnDrain closes the connection once the server drains and the requests
+// running on it have finished
+func (cs *chatSession) closeOnDrain(ctx context.Context) {
+	select {
+	case <-ctx.Done():
+		return
+	case <-cs.server.drain.started:
+	}
+	cs.mutex.Lock()
+	cs.closing = true
+	idle := len(cs.inflight) == 0
+	cs.mutex.Unlock()
+	if idle {
+		cs.conn.Close(websocket.StatusGoingAway, drainMessage)
+	}
+}
+
+// start validates a request and runs it in the background
+func (cs *chatSession) start(ctx context.Context, msg wsClientMessage) {
+	if msg.ID == "" || msg.Request == nil {
+		cs.send(wsServerMessage{Type: wsTypeError, ID: msg.ID, Error: errors.NewValidationError("id and request are required")})
+		return
+	}
+
+	cs.mutex.Lock()
+	if cs.closing {
+		cs.mutex.Unlock()
+		cs.send(wsServerMessage{Type: wsTypeError, ID: msg.ID, Error: errors.NewServiceUnavailableError(drainMessage)})
+		return
+	}
+	if _, exists := cs.inflight[msg.ID]; exists {
+		cs.mutex.Unlock()
+		cs.send(wsServerMessage{Type: wsTypeError, ID: msg.ID, Error: errors.NewValidationError("a request with this id is already running")})
+		return
+	}
+	if len(cs.inflight) >= wsMaxInFlight {
+		cs.mutex.Unlock()
+		cs.send(wsServerMessage{Type: wsTypeError, ID: msg.ID, Error: errors.NewRateLimitError("too many requests in flight on this connection")})
+		return
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	cs.inflight[msg.ID] = cancel
+	cs.mutex.Unlock()
+
+	cs.wg.Add(1)
+	go func() {
+		defer cs.wg.Done()
+		defer cs.finish(msg.ID)
+		cs.complete(ctx, msg.ID, msg.Request)
+	}()
+}
+
+// complete runs one chat completion, streaming its events to the client
+func (cs *chatSession) complete(ctx context.Context, requestID string, req *ChatCompletionRequest) {
+	// Every request over the socket streams
+	req.Stream = true
+	chat, err := cs.server.prepareChatCompletion(cs.request.Context(), req)
+	if err != nil {
+		cs.fail(requestID, err)
+		return
+	}
+
+	if _, apiErr := cs.server.checkQuota(cs.request.Context()); apiErr != nil {
+		cs.fail(requestID, apiErr)
+		return
+	}
+
+	release, err := cs.server.queue.Acquire(ctx, priorityOf(cs.request.Context()))
+	if err != nil {
+		if ctx.Err() == nil {
+			err = errors.NewRateLimitError(err.Error())
+		}
+		cs.fail(requestID, err)
+		return
+	}
+	defer release()
+	ctx, cancel := withKeyMaxDuration(ctx, keys.FromContext(cs.request.Context()))
+	defer cancel()
+
+	id := generateID()
+	ex := cs.server.startExchange(nil, cs.request, id, chat.model, chat.upstream, true)
+	applyContextTrim(nil, ex, chat.trim)
+	finishReason, err := cs.server.streamChat(ctx, chat, ex, func(chunk ChatCompletionChunk) error {
+		return cs.send(wsServerMessage{Type: wsTypeChunk, ID: requestID, Chunk: &chunk})
+	})
+	ex.finish(finishReason, err)
+	if err != nil {
+		cs.fail(requestID, err)
+		return
+	}
+
+	done := wsServerMessage{
+		Type:         wsTypeDone,
+		ID:           requestID,
+		FinishReason: finishReason,
+		Usage:        ex.tokenUsage(),
+		Watermark:    ex.record.signed(),
+	}
+	if len(ex.ignored) > 0 {
+		done.Warning = ignoredParametersWarning(ex.ignored)
+	}
+	cs.send(done)
+}
+
+// fail reports a request error, or its cancellation
+func (cs *chatSession) fail(requestID string, err error) {
+	switch {
+	case stderrors.Is(err, context.Canceled):
+		cs.send(wsServerMessage{Type: wsTypeCancelled, ID: requestID})
+	case stderrors.Is(err, context.DeadlineExceeded):
+		cs.send(wsServerMessage{Type: wsTypeError, ID: requestID, Error: errors.NewDeadlineExceededError("request expired before completing")})
+	default:
+		cs.send(wsServerMessage{Type: wsTypeError, ID: requestID, Error: errors.WrapError(err)})
+	}
+}
+
+// cancel stops a running request
+func (cs *chatSession) cancel(requestID string) {
+	cs.mutex.Lock()
+	cancel, ok := cs.inflight[requestID]
+	cs.mutex.Unlock()
+	if ok {
+		cancel()
+	}
+}
+
+func (cs *chatSession) finish(requestID string) {
+	cs.mutex.Lock()
+	if cancel, ok := cs.inflight[requestID]; ok {
+		cancel()
+		delete(cs.inflight, requestID)
+	}
+	closing := cs.closing && len(cs.inflight) == 0
+	cs.mutex.Unlock()
+	// The close handshake needs run to keep reading, so it must not hold the
+	// mutex run takes for new messages
+	if closing {
+		cs.conn.Close(websocket.StatusGoingAway, drainMessage)
+	}
+}
+
+func (cs *chatSession) send(msg wsServerMessage) error {
+	return wsjson.Write(context.Background(), cs.conn, msg)
+}
+
+// hijackWriter lets the WebSocket library take over the connection through
+// the middleware's response writer wrappers. The server's read and write
+// timeouts are lifted, since the connection is long lived.
+type hijackWriter struct {
+	http.ResponseWriter
+}
+
+func (w hijackWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := http.NewResponseController(w.ResponseWriter).Hijack()
+	if err == nil {
+		conn.SetDeadline(time.Time{})
+	}
+	return conn, rw, err
+}
@@ -0,0 +1,200 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coder/websocket"
+	"github.com/coder/websocket/wsjson"
+)
+
+// dialChat opens a chat WebSocket to the server
+func dialChat(t *testing.T, server *Server) *websocket.Conn {
+	t.Helper()
+	ts := httptest.NewServer(server.Router())
+	t.Cleanup(ts.Close)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, _, err := websocket.Dial(ctx, "ws"+strings.TrimPrefix(ts.URL, "http")+"/v1/chat/ws", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.CloseNow() })
+	return conn
+}
+
+// readSocketEvent reads the next server message from the socket
+func readSocketEvent(t *testing.T, conn *websocket.Conn) wsServerMessage {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	var msg wsServerMessage
+	if err := wsjson.Read(ctx, conn, &msg); err != nil {
+		t.Fatal(err)
+	}
+	return msg
+}
+
+func chatRequest(id string) map[string]interface{} {
+	return map[string]interface{}{
+		"type": wsTypeRequest,
+		"id":   id,
+		"request": map[string]interface{}{
+			"model":    "gpt-4o",
+			"messages": []map[string]string{{"role": "user", "content": "hello there"}},
+		},
+	}
+}
+
+func TestChatWebSocket(t *testing.T) {
+	conn := dialChat(t, newMockServer(t, nil))
+	ctx := context.Background()
+
+	if err := wsjson.Write(ctx, conn, chatRequest("req-1")); err != nil {
+		t.Fatal(err)
+	}
+	var content strings.Builder
+	for {
+		msg := readSocketEvent(t, conn)
+		if msg.ID != "req-1" {
+			t.Fatalf("event for %q", msg.ID)
+		}
+		if msg.Type == wsTypeDone {
+			if msg.FinishReason == "" || msg.Usage == nil {
+				t.Errorf("done %+v", msg)
+			}
+			break
+		}
+		if msg.Type != wsTypeChunk || msg.Chunk == nil {
+			t.Fatalf("got %+v", msg)
+		}
+		for _, choice := range msg.Chunk.Choices {
+			content.WriteString(choice.Delta.Content)
+		}
+	}
+	if content.Len() == 0 {
+		t.Error("no content streamed")
+	}
+
+	// Bad messages get errors and leave the connection open
+	tests := []struct {
+		message string
+		id      string
+	}{
+		{`{"type": "bogus", "id": "x"}`, "x"},
+		{`{"type": "chat.completion", "id": "y"}`, "y"},
+		{`not json`, ""},
+	}
+	for _, tt := range tests {
+		if err := conn.Write(ctx, websocket.MessageText, []byte(tt.message)); err != nil {
+			t.Fatal(err)
+		}
+		if msg := readSocketEvent(t, conn); msg.Type != wsTypeError || msg.ID != tt.id || msg.Error == nil {
+			t.Errorf("%s: got %+v", tt.message, msg)
+		}
+	}
+}
+
+func TestChatWebSocketCancel(t *testing.T) {
+	conn := dialChat(t, newMockServer(t, map[string]string{"MOCK_TOKEN_DELAY": "50ms"}))
+	ctx := context.Background()
+
+	if err := wsjson.Write(ctx, conn, chatRequest("req-1")); err != nil {
+		t.Fatal(err)
+	}
+	if msg := readSocketEvent(t, conn); msg.Type != wsTypeChunk {
+		t.Fatalf("got %+v", msg)
+	}
+	if err := wsjson.Write(ctx, conn, map[string]string{"type": wsTypeCancel, "id": "req-1"}); err != nil {
+		t.Fatal(err)
+	}
+	for {
+		msg := readSocketEvent(t, conn)
+		if msg.Type == wsTypeCancelled && msg.ID == "req-1" {
+			break
+		}
+		if msg.Type != wsTypeChunk {
+			t.Fatalf("got %+v, want cancelled", msg)
+		}
+	}
+}
+
+func TestChatWebSocketMessageLimit(t *testing.T) {
+	conn := dialChat(t, newMockServer(t, map[string]string{"MAX_REQUEST_BODY_BYTES": "256"}))
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// Oversized messages close the connection, even when fragmented
+	w, err := conn.Writer(ctx, websocket.MessageText)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range 4 {
+		w.Write([]byte(strings.Repeat("x", 100)))
+	}
+	w.Close()
+	if _, _, err := conn.Read(ctx); websocket.CloseStatus(err) != websocket.StatusMessageTooBig {
+		t.Errorf("got %v, want close status %d", err, websocket.StatusMessageTooBig)
+	}
+}
+
+func TestChatWebSocketRequiresUpgrade(t *testing.T) {
+	ts := httptest.NewServer(newMockServer(t, nil).Router())
+	t.Cleanup(ts.Close)
+	resp, err := http.Get(ts.URL + "/v1/chat/ws")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("status %d, want 400", resp.StatusCode)
+	}
+}
+
+func TestChatWebSocketDrain(t *testing.T) {
+	server := newMockServer(t, map[string]string{"MOCK_TOKEN_DELAY": "20ms"})
+	conn := dialChat(t, server)
+	ctx := context.Background()
+
+	if err := wsjson.Write(ctx, conn, chatRequest("req-1")); err != nil {
+		t.Fatal(err)
+	}
+	if msg := readSocketEvent(t, conn); msg.Type != wsTypeChunk {
+		t.Fatalf("got %+v", msg)
+	}
+	drained := make(chan error, 1)
+	go func() { drained <- server.Drain(ctx) }()
+	eventually(t, "the drain to start", server.Draining)
+
+	// New requests are refused, the running one finishes, then the socket closes
+	if err := wsjson.Write(ctx, conn, chatRequest("req-2")); err != nil {
+		t.Fatal(err)
+	}
+	var refused, done bool
+	for !done {
+		msg := readSocketEvent(t, conn)
+		switch {
+		case msg.ID == "req-2" && msg.Type == wsTypeError:
+			refused = true
+		case msg.ID == "req-1" && msg.Type == wsTypeDone:
+			done = true
+		case msg.Type != wsTypeChunk:
+			t.Fatalf("got %+v", msg)
+		}
+	}
+	if !refused {
+		t.Error("request accepted while draining")
+	}
+	readCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	if _, _, err := conn.Read(readCtx); websocket.CloseStatus(err) != websocket.StatusGoingAway {
+		t.Errorf("got %v, want close status %d", err, websocket.StatusGoingAway)
+	}
+	if err := <-drained; err != nil {
+		t.Error(err)
+	}
+}
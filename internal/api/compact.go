@@ -0,0 +1,149 @@
+package api
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/devstroop/reai/internal/conversations"
+	"github.com/devstroop/reai/internal/keys"
+	"github.com/devstroop/reai/pkg/errors"
+)
+
+// CompactRequest is the body of POST /v1/conversations/{id}/compact; every
+// field is optional
+type CompactRequest struct {
+	// Keep is the number of recent messages left as they are
+	// (CONTEXT_TRIM_WINDOW when unset)
+	Keep *int `json:"keep,omitempty"`
+	// Model writes the summary (CONTEXT_SUMMARY_MODEL when empty)
+	Model string `json:"model,omitempty"`
+}
+
+// CompactResponse reports a compaction
+type CompactResponse struct {
+	ID     string `json:"id"`
+	Object string `json:"object"`
+	// Summarized counts the messages replaced by the summary, 0 when the
+	// conversation was short enough already
+	Summarized int `json:"summarized"`
+	// Messages is the length of the conversation afterwards
+	Messages int    `json:"messages"`
+	Summary  string `json:"summary,omitempty"`
+}
+
+// handleConversationCompact replaces the older turns of a stored
+// conversation with a summary written by the backend. System messages and
+// the most recent messages are kept; a summary from an earlier compaction is
+// folded into the new one. Turns appended while the summary is written are
+// kept, but a conversation compacted or replaced meanwhile is a conflict.
+func (s *Server) handleConversationCompact(w http.ResponseWriter, r *http.Request, owner, id string) {
+	var req CompactRequest
+	if r.ContentLength != 0 {
+		if err := s.decodeJSON(r, &req); err != nil {
+			writeError(w, err)
+			return
+		}
+	}
+	keep := s.config.ContextTrimWindow
+	if req.Keep != nil {
+		if *req.Keep < 0 {
+			errors.WriteErrorResponse(w, errors.NewValidationError("keep must not be negative"))
+			return
+		}
+		keep = *req.Keep
+	}
+	model := getDefaultOrString(req.Model, s.config.ContextSummaryModel)
+
+	c, err := s.conversations.Get(owner, id)
+	if err != nil {
+		writeConversationError(w, err)
+		return
+	}
+	roles := make([]string, len(c.Messages))
+	for i, msg := range c.Messages {
+		roles[i] = msg.Role
+	}
+	old := c.Messages[:windowStart(roles, keep)]
+
+	// The summary takes the place of the first message it covers
+	var summarized []ChatMessage
+	var head []conversations.Message
+	at, turns := -1, 0
+	for _, msg := range old {
+		if msg.Role == "system" && !strings.HasPrefix(msg.Content, summaryPrefix) {
+			head = append(head, msg)
+			continue
+		}
+		if at < 0 {
+			at = len(head)
+			head = append(head, conversations.Message{Role: "system"})
+		}
+		summarized = append(summarized, fromStoredMessage(msg))
+		if msg.Role != "system" {
+			turns++
+		}
+	}
+
+	response := CompactResponse{ID: c.ID, Object: "conversation.compaction", Messages: len(c.Messages)}
+	// An earlier summary alone is not summarized again
+	if turns == 0 {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+		return
+	}
+
+	// Stored messages are kept as the client sent them
+	for i, msg := range summarized {
+		if summarized[i].Content, err = s.filterPrompt(msg.Content); err != nil {
+			writeError(w, err)
+			return
+		}
+		for j, call := range msg.ToolCalls {
+			if summarized[i].ToolCalls[j].Function.Arguments, err = s.filterPrompt(call.Function.Arguments); err != nil {
+				writeError(w, err)
+				return
+			}
+		}
+	}
+
+	ctx := r.Context()
+	if _, apiErr := s.checkQuota(ctx); apiErr != nil {
+		writeError(w, apiErr)
+		return
+	}
+	if apiErr := s.shed(priorityOf(ctx)); apiErr != nil {
+		writeError(w, apiErr)
+		return
+	}
+	release, err := s.queue.Acquire(ctx, priorityOf(ctx))
+	if err != nil {
+		if ctx.Err() == nil {
+			err = errors.NewRateLimitError(err.Error())
+		}
+		writeError(w, err)
+		return
+	}
+	defer release()
+	ctx, cancel := withKeyMaxDuration(ctx, keys.FromContext(ctx))
+	defer cancel()
+
+	summary, err := s.summarize(ctx, model, summarized)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	head[at].Content = summaryPrefix + summary
+	if err := s.conversations.Compact(owner, id, old, head); err != nil {
+		writeConversationError(w, err)
+		return
+	}
+	slog.Info("Compacted conversation", "conversation", id, "summarized", len(summarized), "model", model)
+
+	response.Summarized = len(summarized)
+	response.Messages = len(c.Messages) - len(old) + len(head)
+	response.Summary = summary
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,435 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	stderrors "errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/devstroop/reai/internal/copilot"
+	"github.com/devstroop/reai/internal/transform"
+	"github.com/devstroop/reai/pkg/errors"
+)
+
+// maxCompletionLogprobs is the largest logprobs value accepted by the completions API
+const maxCompletionLogprobs = 5
+
+// maxContextSnippets bounds the context_snippets of a completion request
+const maxContextSnippets = 20
+
+// repositoryPattern matches an "owner/name" repository
+var repositoryPattern = regexp.MustCompile(`^[A-Za-z0-9_.-]+/[A-Za-z0-9_.-]+$`)
+
+// defaultCompletionModel is reported for completions that don't name a model
+const defaultCompletionModel = copilot.CodexModel
+
+// CompletionRequest represents a completion request
+type CompletionRequest struct {
+	Model         string         `json:"model,omitempty"`
+	Prompt        string         `json:"prompt"`
+	Language      string         `json:"language,omitempty"`
+	MaxTokens     int            `json:"max_tokens,omitempty"`
+	Temperature   *float64       `json:"temperature,omitempty"`
+	Stream        bool           `json:"stream,omitempty"`
+	Logprobs      *int           `json:"logprobs,omitempty"`
+	StreamOptions *StreamOptions `json:"stream_options,omitempty"`
+	SamplingParameters
+
+	// Editor context forwarded to Copilot: the edited file, its repository
+	// ("owner/name") and excerpts of neighboring files
+	FilePath        string                   `json:"file_path,omitempty"`
+	Repository      string                   `json:"repository,omitempty"`
+	ContextSnippets []copilot.ContextSnippet `json:"context_snippets,omitempty"`
+}
+
+// StreamOptions are the OpenAI options of a streamed response
+type StreamOptions struct {
+	// IncludeUsage adds a chunk with the token usage and no choices before [DONE]
+	IncludeUsage bool `json:"include_usage,omitempty"`
+}
+
+// validateStreamOptions rejects stream_options on a request that doesn't
+// stream, as OpenAI does
+func validateStreamOptions(options *StreamOptions, stream bool) error {
+	if options != nil && !stream {
+		return errors.NewValidationError("stream_options is only allowed when stream is true")
+	}
+	return nil
+}
+
+// includeUsage reports whether the usage chunk was requested
+func (o *StreamOptions) includeUsage() bool {
+	return o != nil && o.IncludeUsage
+}
+
+// SamplingParameters are the standard OpenAI sampling parameters shared by
+// chat and completion requests
+type SamplingParameters struct {
+	TopP             *float64 `json:"top_p,omitempty"`
+	PresencePenalty  *float64 `json:"presence_penalty,omitempty"`
+	FrequencyPenalty *float64 `json:"frequency_penalty,omitempty"`
+	Seed             *int64   `json:"seed,omitempty"`
+	User             string   `json:"user,omitempty"`
+}
+
+// validate checks the parameters against the ranges OpenAI accepts
+func (p *SamplingParameters) validate() error {
+	if p.TopP != nil && (*p.TopP < 0 || *p.TopP > 1) {
+		return errors.NewValidationError("top_p must be between 0 and 1")
+	}
+	if p.PresencePenalty != nil && (*p.PresencePenalty < -2 || *p.PresencePenalty > 2) {
+		return errors.NewValidationError("presence_penalty must be between -2 and 2")
+	}
+	if p.FrequencyPenalty != nil && (*p.FrequencyPenalty < -2 || *p.FrequencyPenalty > 2) {
+		return errors.NewValidationError("frequency_penalty must be between -2 and 2")
+	}
+	return nil
+}
+
+// apply copies the parameters onto the upstream request
+func (p *SamplingParameters) apply(req *copilot.CompletionRequest) {
+	req.TopP = p.TopP
+	req.PresencePenalty = p.PresencePenalty
+	req.FrequencyPenalty = p.FrequencyPenalty
+	req.Seed = p.Seed
+	req.User = p.User
+}
+
+// warnIgnoredParameters tells the client which parameters were dropped because
+// the upstream does not support them
+func (s *Server) warnIgnoredParameters(w http.ResponseWriter, req *copilot.CompletionRequest) {
+	if ignored := s.providers.UnsupportedParameters(req); len(ignored) > 0 {
+		w.Header().Set("X-ReAI-Warning", ignoredParametersWarning(ignored))
+	}
+}
+
+// CompletionChoice represents a single completion choice
+type CompletionChoice struct {
+	Text         string            `json:"text"`
+	Index        int               `json:"index"`
+	FinishReason *string           `json:"finish_reason"`
+	Logprobs     *copilot.Logprobs `json:"logprobs"`
+}
+
+// CompletionResponse represents a completion response (or a streamed chunk of one)
+type CompletionResponse struct {
+	ID      string             `json:"id"`
+	Object  string             `json:"object"`
+	Created int64              `json:"created"`
+	Model   string             `json:"model"`
+	Choices []CompletionChoice `json:"choices"`
+	Usage   *Usage             `json:"usage,omitempty"`
+	// Extensions is only set on the full response or the final chunk
+	Extensions *Extensions `json:"x_reai,omitempty"`
+}
+
+// Usage represents token usage of a request
+type Usage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+// handleCompletions handles completion requests
+func (s *Server) handleCompletions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req CompletionRequest
+	if err := s.decodeRequest(r, completionRequestFields, &req); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	copilotReq, err := s.prepareCompletion(r.Context(), &req)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	s.warnIgnoredParameters(w, copilotReq)
+
+	if req.Stream {
+		s.streamCompletion(w, r, copilotReq, req.StreamOptions.includeUsage())
+		return
+	}
+
+	id := generateID()
+	ex := s.startExchange(w, r, id, copilotReq.Model, copilotReq, false)
+
+	ctx := r.Context()
+	completion, err := s.providers.GetCompletion(ctx, copilotReq)
+	if err != nil {
+		ex.finish("", err)
+		writeError(w, err)
+		return
+	}
+	ex.served(copilotReq.Backend)
+	s.filterCompletion(ex, completion)
+	ex.completion.Write(completion.Text)
+	ex.finish(completion.FinishReason, nil)
+
+	// Create OpenAI-compatible response
+	response := CompletionResponse{
+		ID:      id,
+		Object:  "text_completion",
+		Created: time.Now().Unix(),
+		Model:   copilotReq.Model,
+		Choices: []CompletionChoice{
+			{
+				Text:         completion.Text,
+				Index:        0,
+				FinishReason: stringPtr(completion.FinishReason),
+				Logprobs:     completion.Logprobs,
+			},
+		},
+		Usage:      ex.tokenUsage(),
+		Extensions: ex.extensions(),
+	}
+	if err := s.checkStrict(response); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// prepareCompletion validates a completion request, applies the key parameters
+// and prompt filters and builds the upstream request, checked against the
+// context limits of the model
+func (s *Server) prepareCompletion(ctx context.Context, req *CompletionRequest) (*copilot.CompletionRequest, error) {
+	if req.Prompt == "" {
+		return nil, errors.NewValidationError("Prompt is required")
+	}
+
+	if req.Logprobs != nil && (*req.Logprobs < 0 || *req.Logprobs > maxCompletionLogprobs) {
+		return nil, errors.NewValidationError("logprobs must be between 0 and 5")
+	}
+
+	if err := req.SamplingParameters.validate(); err != nil {
+		return nil, err
+	}
+	if err := validateStreamOptions(req.StreamOptions, req.Stream); err != nil {
+		return nil, err
+	}
+
+	promptContext, err := s.promptContext(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if model, ok := deploymentModel(ctx); ok {
+		req.Model = model
+	}
+	applyCompletionKeyParameters(ctx, req)
+
+	prompt, err := s.filterPrompt(req.Prompt)
+	if err != nil {
+		return nil, err
+	}
+
+	assignment := s.assignExperiment(ctx, getDefaultOrString(req.Model, defaultCompletionModel))
+	copilotReq := &copilot.CompletionRequest{
+		Model:       assignment.Model,
+		Prompt:      prompt,
+		Language:    req.Language,
+		MaxTokens:   req.MaxTokens,
+		Temperature: req.Temperature,
+		Stream:      req.Stream,
+		Logprobs:    req.Logprobs,
+		Context:     promptContext,
+		Experiment:  assignment.Experiment,
+		Variant:     assignment.Variant,
+	}
+	req.SamplingParameters.apply(copilotReq)
+
+	if err := s.applyContextLimits(ctx, copilotReq.Model, copilotReq); err != nil {
+		return nil, err
+	}
+	return copilotReq, nil
+}
+
+// promptContext validates the editor context of a completion request. Snippets
+// pass through the prompt filters like the prompt itself.
+func (s *Server) promptContext(req *CompletionRequest) (*copilot.PromptContext, error) {
+	if req.FilePath == "" && req.Repository == "" && len(req.ContextSnippets) == 0 {
+		return nil, nil
+	}
+	if req.Repository != "" && !repositoryPattern.MatchString(req.Repository) {
+		return nil, errors.NewValidationError("repository must be in the form owner/name")
+	}
+	if len(req.ContextSnippets) > maxContextSnippets {
+		return nil, errors.NewValidationError(fmt.Sprintf("context_snippets can hold at most %d snippets", maxContextSnippets))
+	}
+
+	pc := &copilot.PromptContext{FilePath: req.FilePath, Repository: req.Repository}
+	for i, snippet := range req.ContextSnippets {
+		if snippet.Content == "" {
+			return nil, errors.NewValidationError(fmt.Sprintf("context_snippets[%d].content is required", i))
+		}
+		content, err := s.filterPrompt(snippet.Content)
+		if err != nil {
+			return nil, err
+		}
+		pc.Snippets = append(pc.Snippets, copilot.ContextSnippet{FilePath: snippet.FilePath, Content: content})
+	}
+	return pc, nil
+}
+
+// streamCompletion streams a completion as server-sent events
+func (s *Server) streamCompletion(w http.ResponseWriter, r *http.Request, req *copilot.CompletionRequest, includeUsage bool) {
+	stream := s.newSSEWriter(w, r)
+	id := generateID()
+	created := time.Now().Unix()
+	ex := s.startExchange(w, r, id, req.Model, req, true)
+	stream.Open(r.Context())
+
+	chunk := func(text string, logprobs *copilot.Logprobs, finishReason *string) CompletionResponse {
+		return CompletionResponse{
+			ID:      id,
+			Object:  "text_completion",
+			Created: created,
+			Model:   req.Model,
+			Choices: []CompletionChoice{
+				{Text: text, Index: 0, FinishReason: finishReason, Logprobs: logprobs},
+			},
+		}
+	}
+
+	finishReason, err := s.streamText(r.Context(), req, ex, func(text string, logprobs *copilot.Logprobs) error {
+		return stream.Send(chunk(text, logprobs, nil))
+	}, nil, nil)
+	ex.finish(finishReason, err)
+	if err != nil {
+		stream.Fail(err)
+		return
+	}
+
+	final := chunk("", nil, stringPtr(finishReason))
+	final.Extensions = ex.extensions()
+	stream.Send(final)
+	if includeUsage {
+		usage := chunk("", nil, nil)
+		usage.Choices, usage.Usage = []CompletionChoice{}, ex.tokenUsage()
+		stream.Send(usage)
+	}
+	stream.Done()
+}
+
+// streamText runs a completion upstream and passes every piece of (filtered
+// and transformed) text to send as it arrives, reassembling it in the exchange transcript. It
+// returns the finish reason; after an error the transcript holds what was
+// streamed so far. Reasoning text goes to think and the pieces of tool calls
+// to call; either is dropped when nil.
+func (s *Server) streamText(ctx context.Context, req *copilot.CompletionRequest, ex *exchange, send func(text string, logprobs *copilot.Logprobs) error, think func(reasoning string) error, call func([]copilot.ToolCallDelta) error) (string, error) {
+	if think != nil {
+		sendReasoning := think
+		think = func(reasoning string) error {
+			if err := sendReasoning(reasoning); err != nil {
+				return err
+			}
+			ex.chunkSent()
+			return nil
+		}
+	}
+	sf := s.newCompletionStreamFilter()
+	rs := s.newReasoningStream(think)
+	finishReason := copilot.FinishReasonStop
+
+	err := s.providers.StreamCompletion(ctx, req, func(c copilot.CompletionChunk) error {
+		if c.FinishReason != "" {
+			finishReason = c.FinishReason
+		}
+		if c.Reasoning != "" && rs != nil {
+			ex.served(req.Backend)
+			if err := rs.Write(c.Reasoning); err != nil {
+				return err
+			}
+		}
+		if c.Text != "" || (len(c.ToolCalls) > 0 && call != nil) {
+			if err := rs.Flush(); err != nil {
+				return err
+			}
+		}
+		if len(c.ToolCalls) > 0 && call != nil {
+			ex.served(req.Backend)
+			if err := call(c.ToolCalls); err != nil {
+				return err
+			}
+			ex.chunkSent()
+		}
+		if sf != nil {
+			filtered, err := sf.Write(c.Text)
+			if err != nil {
+				return err
+			}
+			c.Text, c.Logprobs = filtered, nil
+		}
+		var stop error
+		if ex.output != nil {
+			transformed, err := ex.output.Chunk(c.Text)
+			if stderrors.Is(err, transform.ErrStop) {
+				// Sent below; returning ErrStop ends the upstream request
+				stop = err
+			} else if err != nil {
+				return outputHookError(err)
+			}
+			if transformed != c.Text {
+				c.Text, c.Logprobs = transformed, nil
+			}
+		}
+		if c.Text == "" && c.Logprobs == nil {
+			return stop
+		}
+		// The backend is known before anything is sent, so the header can
+		// still be set
+		ex.served(req.Backend)
+		ex.completion.Write(c.Text)
+		if err := send(c.Text, c.Logprobs); err != nil {
+			return err
+		}
+		ex.chunkSent()
+		return stop
+	})
+	flush := sf
+	if stderrors.Is(err, transform.ErrStop) {
+		// Text the filters held back comes after the stop
+		err, flush = nil, nil
+	}
+	if err == nil {
+		err = rs.Flush()
+	}
+	tail, blocked, err := finishFilteredStream(flush, err)
+	if err != nil {
+		return "", err
+	}
+	if !blocked && ex.output != nil {
+		if tail, err = ex.output.Apply(tail, finishReason); err != nil {
+			outputHookError(err)
+			tail, blocked = "", true
+		}
+		if ex.output.Stopped() {
+			finishReason = copilot.FinishReasonStop
+		}
+	}
+	ex.served(req.Backend)
+	if (sf != nil && sf.Redacted()) || rs.Redacted() {
+		ex.degrade(degradedContentRedacted)
+	}
+	if blocked {
+		finishReason = copilot.FinishReasonContentFilter
+		ex.degrade(degradedContentBlocked)
+	} else if tail != "" {
+		ex.completion.Write(tail)
+		if err := send(tail, nil); err != nil {
+			return "", err
+		}
+		ex.chunkSent()
+	}
+	return finishReason, nil
+}
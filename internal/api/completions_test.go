@@ -0,0 +1,60 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/devstroop/reai/internal/copilot"
+)
+
+func TestCompletionContextLimits(t *testing.T) {
+	codex := copilot.ModelInfo{ID: copilot.CodexModel, Capabilities: &copilot.ModelCapabilities{
+		Limits: &copilot.ModelLimits{MaxContextWindowTokens: 100, MaxOutputTokens: 40},
+	}}
+	post := func(t *testing.T, url string, body map[string]interface{}) (int, string) {
+		t.Helper()
+		data, _ := json.Marshal(body)
+		resp, err := http.Post(url+"/v1/completions", "application/json", bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		raw, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(raw)
+	}
+	codeEvents := []string{`{"choices":[{"index":0,"text":"x := 1","finish_reason":"stop"}]}`}
+
+	t.Run("clamped", func(t *testing.T) {
+		fake := &fakeCopilot{models: []copilot.ModelInfo{codex}}
+		ts := newCopilotServer(t, fake, nil)
+
+		// A prompt of about 400 tokens doesn't fit a window of 100
+		status, body := post(t, ts.URL, map[string]interface{}{"prompt": strings.Repeat("x", 1600)})
+		if status != http.StatusBadRequest || !strings.Contains(body, "context_length_exceeded") {
+			t.Errorf("long prompt: %d %s", status, body)
+		}
+
+		fake.reply(codeEvents...)
+		var response CompletionResponse
+		postJSON(t, ts.URL+"/v1/completions", map[string]interface{}{"prompt": "func main() {", "max_tokens": 500}, &response)
+		if got := fake.request(t, 0)["max_tokens"]; got != float64(40) {
+			t.Errorf("upstream max_tokens %v, want the output limit 40", got)
+		}
+		if response.Extensions == nil || !strings.Contains(strings.Join(response.Extensions.Degraded, ","), degradedMaxTokensClamped) {
+			t.Errorf("clamping not reported: %+v", response.Extensions)
+		}
+	})
+
+	t.Run("rejected", func(t *testing.T) {
+		fake := &fakeCopilot{models: []copilot.ModelInfo{codex}}
+		ts := newCopilotServer(t, fake, map[string]string{"CLAMP_MAX_TOKENS": "false"})
+		status, body := post(t, ts.URL, map[string]interface{}{"prompt": "func main() {", "max_tokens": 500})
+		if status != http.StatusBadRequest || !strings.Contains(body, "you requested 503 tokens") {
+			t.Errorf("max_tokens over the limit: %d %s", status, body)
+		}
+	})
+}
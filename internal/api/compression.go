@@ -0,0 +1,165 @@
+package api
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/devstroop/reai/pkg/errors"
+)
+
+// compressionMiddleware decompresses gzip and deflate request bodies and,
+// with RESPONSE_COMPRESSION, compresses responses for clients that accept it.
+// Event streams are left to the SSE writer (STREAM_COMPRESSION).
+func (s *Server) compressionMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := decompressBody(r); err != nil {
+			errors.WriteErrorResponse(w, err)
+			return
+		}
+
+		// WebSocket upgrades hijack the connection and have no body to compress
+		if !s.config.ResponseCompression || r.Header.Get("Upgrade") != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		accepted := r.Header.Get("Accept-Encoding")
+		encoding := ""
+		switch {
+		case acceptsEncoding(accepted, "gzip"):
+			encoding = "gzip"
+		case acceptsEncoding(accepted, "deflate"):
+			encoding = "deflate"
+		default:
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		cw := &compressWriter{ResponseWriter: w, encoding: encoding}
+		defer cw.close()
+		next.ServeHTTP(cw, r)
+	})
+}
+
+// decompressBody replaces a gzip or deflate encoded request body with the
+// decoded one. Size limits further down apply to the decoded body.
+func decompressBody(r *http.Request) *errors.APIError {
+	encoding := strings.ToLower(strings.TrimSpace(r.Header.Get("Content-Encoding")))
+	var body io.ReadCloser
+	var err error
+	switch encoding {
+	case "", "identity":
+		return nil
+	case "gzip", "x-gzip":
+		body, err = gzip.NewReader(r.Body)
+	case "deflate":
+		body, err = zlib.NewReader(r.Body)
+	default:
+		apiErr := errors.NewInvalidRequestError("Unsupported Content-Encoding: " + encoding + " (use gzip or deflate)")
+		apiErr.Code = http.StatusUnsupportedMediaType
+		return apiErr
+	}
+	if err != nil {
+		return errors.NewInvalidRequestError("Request body is not valid " + encoding + " data")
+	}
+
+	r.Body = body
+	r.Header.Del("Content-Encoding")
+	r.Header.Del("Content-Length")
+	r.ContentLength = -1
+	return nil
+}
+
+// compressWriter compresses a response once its headers show it is JSON or
+// text that isn't encoded already
+type compressWriter struct {
+	http.ResponseWriter
+	encoding string
+	decided  bool
+	// encoder is nil when the response is passed through as is
+	encoder interface {
+		io.WriteCloser
+		Flush() error
+	}
+}
+
+func (cw *compressWriter) WriteHeader(code int) {
+	if !cw.decided {
+		cw.decide(code)
+	}
+	cw.ResponseWriter.WriteHeader(code)
+}
+
+// decide picks compression or pass through for a response with status code
+func (cw *compressWriter) decide(code int) {
+	cw.decided = true
+	header := cw.Header()
+	if code < http.StatusOK || code == http.StatusNoContent || code == http.StatusPartialContent || code == http.StatusNotModified ||
+		header.Get("Content-Encoding") != "" || !compressible(header.Get("Content-Type")) {
+		return
+	}
+
+	header.Set("Content-Encoding", cw.encoding)
+	header.Add("Vary", "Accept-Encoding")
+	header.Del("Content-Length")
+	if cw.encoding == "gzip" {
+		cw.encoder = gzip.NewWriter(cw.ResponseWriter)
+	} else {
+		cw.encoder = zlib.NewWriter(cw.ResponseWriter)
+	}
+}
+
+func (cw *compressWriter) Write(p []byte) (int, error) {
+	if !cw.decided {
+		cw.WriteHeader(http.StatusOK)
+	}
+	if cw.encoder != nil {
+		return cw.encoder.Write(p)
+	}
+	return cw.ResponseWriter.Write(p)
+}
+
+// Flush sends what was compressed so far
+func (cw *compressWriter) Flush() {
+	if cw.encoder != nil {
+		cw.encoder.Flush()
+	}
+	if flusher, ok := cw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap exposes the underlying writer to http.ResponseController
+func (cw *compressWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+// close ends the compressed response
+func (cw *compressWriter) close() {
+	if cw.encoder != nil {
+		cw.encoder.Close()
+	}
+}
+
+// compressible reports whether a response of the content type is worth
+// compressing. Event streams and gRPC manage their own framing.
+func compressible(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	switch {
+	case mediaType == "text/event-stream":
+		return false
+	case strings.HasPrefix(mediaType, "text/"):
+		return true
+	}
+	switch mediaType {
+	case "application/json", "application/jsonl", "application/x-ndjson", "application/yaml":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,61 @@
+package api
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/devstroop/reai/internal/copilot"
+	"github.com/devstroop/reai/pkg/errors"
+)
+
+// applyContextLimits checks the request against the limits published for the
+// model. Prompts that don't fit are rejected with context_length_exceeded, and
+// max_tokens is clamped to what is left of the context window (or rejected when
+// CLAMP_MAX_TOKENS is off). Models without published limits are not checked.
+func (s *Server) applyContextLimits(ctx context.Context, model string, req *copilot.CompletionRequest) error {
+	limits := s.providers.GetModelLimits(ctx, model)
+	if limits == nil {
+		return nil
+	}
+
+	window := limits.MaxContextWindowTokens
+	promptTokens := estimateTokens(req.Prompt)
+
+	maxPrompt := limits.MaxPromptTokens
+	if maxPrompt == 0 || (window > 0 && window < maxPrompt) {
+		maxPrompt = window
+	}
+	if maxPrompt > 0 && promptTokens > maxPrompt {
+		return errors.NewContextLengthError(fmt.Sprintf(
+			"This model's maximum prompt length is %d tokens. However, your messages resulted in %d tokens. Please reduce the length of the messages.",
+			maxPrompt, promptTokens))
+	}
+
+	budget := limits.MaxOutputTokens
+	if window > 0 && (budget == 0 || window-promptTokens < budget) {
+		budget = window - promptTokens
+	}
+	if budget <= 0 {
+		return errors.NewContextLengthError(fmt.Sprintf(
+			"This model's maximum context length is %d tokens. However, your messages resulted in %d tokens, leaving no room for the completion. Please reduce the length of the messages.",
+			window, promptTokens))
+	}
+	if req.MaxTokens <= budget {
+		return nil
+	}
+
+	if !s.config.ClampMaxTokens {
+		if window > 0 && promptTokens+req.MaxTokens > window {
+			return errors.NewContextLengthError(fmt.Sprintf(
+				"This model's maximum context length is %d tokens. However, you requested %d tokens (%d in the messages, %d in the completion). Please reduce the length of the messages or completion.",
+				window, promptTokens+req.MaxTokens, promptTokens, req.MaxTokens))
+		}
+		return errors.NewContextLengthError(fmt.Sprintf(
+			"max_tokens is too large: %d. This model supports at most %d completion tokens.",
+			req.MaxTokens, budget))
+	}
+
+	req.RequestedMaxTokens = req.MaxTokens
+	req.MaxTokens = budget
+	return nil
+}
@@ -0,0 +1,287 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/devstroop/reai/internal/copilot"
+)
+
+// Strategies of CONTEXT_TRIM_STRATEGY
+const (
+	trimDropOldest    = "drop_oldest"
+	trimSlidingWindow = "sliding_window"
+	trimSummarize     = "summarize"
+)
+
+// contextTrimHeader names the strategy that shortened a chat history
+const contextTrimHeader = "X-ReAI-Context-Trimmed"
+
+// summaryMaxTokens bounds the summary written by the summarize strategy
+const summaryMaxTokens = 500
+
+const summaryInstructions = "Summarize the conversation below for the assistant that continues it. " +
+	"Keep facts, decisions, names, code identifiers and open questions. Reply with the summary only."
+
+// summaryPrefix introduces the summary standing in for the oldest turns
+const summaryPrefix = "Summary of the earlier conversation: "
+
+// validTrimStrategy reports whether strategy is a CONTEXT_TRIM_STRATEGY value
+func validTrimStrategy(strategy string) bool {
+	switch strategy {
+	case "", trimDropOldest, trimSlidingWindow, trimSummarize:
+		return true
+	}
+	return false
+}
+
+// contextTrim records how a chat history was shortened to fit
+type contextTrim struct {
+	strategy string
+	// dropped counts the messages left out
+	dropped int
+	// summarized counts the messages replaced by a summary
+	summarized int
+}
+
+// warning describes the trim for x_reai.warnings
+func (t *contextTrim) warning() string {
+	if t.summarized > 0 && t.dropped > 0 {
+		return fmt.Sprintf("%d messages were summarized and %d left out to fit the model context window", t.summarized, t.dropped)
+	}
+	if t.summarized > 0 {
+		return fmt.Sprintf("%d messages were summarized to fit the model context window", t.summarized)
+	}
+	return fmt.Sprintf("%d messages were left out to fit the model context window", t.dropped)
+}
+
+// applyContextTrim reports a shortened history on the exchange and in the
+// X-ReAI-Context-Trimmed header (w is nil on WebSocket sessions)
+func applyContextTrim(w http.ResponseWriter, ex *exchange, trim *contextTrim) {
+	if trim == nil {
+		return
+	}
+	ex.degrade(degradedContextTrimmed)
+	ex.warnings = append(ex.warnings, trim.warning())
+	if w != nil {
+		w.Header().Set(contextTrimHeader, trim.strategy)
+	}
+}
+
+// promptBudget returns the prompt tokens model takes while leaving room for
+// maxTokens (or its output limit), 0 when the model has no published limits
+func (s *Server) promptBudget(ctx context.Context, model string, maxTokens int) int {
+	limits := s.providers.GetModelLimits(ctx, model)
+	if limits == nil {
+		return 0
+	}
+
+	window := limits.MaxContextWindowTokens
+	budget := limits.MaxPromptTokens
+	if budget == 0 || (window > 0 && window < budget) {
+		budget = window
+	}
+	if maxTokens == 0 {
+		maxTokens = limits.MaxOutputTokens
+	}
+	if window > 0 && maxTokens > 0 && window-maxTokens > 0 && window-maxTokens < budget {
+		budget = window - maxTokens
+	}
+	return budget
+}
+
+// trimContext shortens a chat history that doesn't fit the model context
+// window with CONTEXT_TRIM_STRATEGY and returns it followed by current, the
+// turn being asked about, which is always kept whole. System messages are
+// kept too. Without a strategy only stored conversations are trimmed, oldest
+// turns first; other requests are left for the context limits to reject. The
+// returned trim is nil when nothing was left out.
+func (s *Server) trimContext(ctx context.Context, model string, maxTokens int, history, current []ChatMessage, conversation bool) ([]ChatMessage, *contextTrim) {
+	strategy := s.config.ContextTrimStrategy
+	if strategy == "" {
+		if !conversation {
+			return append(history, current...), nil
+		}
+		strategy = trimDropOldest
+	}
+	budget := s.promptBudget(ctx, model, maxTokens)
+	if budget <= 0 || fitsBudget(history, current, budget) {
+		return append(history, current...), nil
+	}
+
+	trim := &contextTrim{strategy: strategy}
+	switch strategy {
+	case trimSlidingWindow:
+		history, trim.dropped = slideWindow(history, s.config.ContextTrimWindow)
+	case trimSummarize:
+		summarized, n, err := s.summarizeOldest(ctx, history, current, budget)
+		if err != nil {
+			slog.Warn("Failed to summarize the oldest turns, leaving them out instead", "model", s.config.ContextSummaryModel, "error", err)
+			trim.strategy = trimDropOldest
+		} else {
+			history, trim.summarized = summarized, n
+		}
+	}
+	// Whatever still doesn't fit is left out, oldest first
+	history, dropped := dropOldest(history, current, budget)
+	trim.dropped += dropped
+	if trim.dropped == 0 && trim.summarized == 0 {
+		return append(history, current...), nil
+	}
+	return append(history, current...), trim
+}
+
+// fitsBudget reports whether history and current fit in budget tokens
+func fitsBudget(history, current []ChatMessage, budget int) bool {
+	return estimateTokens(chatPrompt(append(append([]ChatMessage{}, history...), current...))) <= budget
+}
+
+// dropOldest leaves the oldest turns of history out until it fits in front
+// of current. It returns the history and the number of messages dropped.
+func dropOldest(history, current []ChatMessage, budget int) ([]ChatMessage, int) {
+	dropped := 0
+	for !fitsBudget(history, current, budget) {
+		start, end := oldestTurn(history)
+		if start < 0 {
+			break
+		}
+		dropped += end - start
+		history = append(append([]ChatMessage{}, history[:start]...), history[end:]...)
+	}
+	return history, dropped
+}
+
+// oldestTurn returns the bounds of the oldest turn of history, -1 when only
+// system messages are left. A turn is a message and the replies and tool
+// results that follow it.
+func oldestTurn(history []ChatMessage) (int, int) {
+	start := -1
+	for i, msg := range history {
+		if msg.Role != "system" {
+			start = i
+			break
+		}
+	}
+	if start < 0 {
+		return -1, -1
+	}
+	end := start + 1
+	for end < len(history) && history[end].Role != "user" && history[end].Role != "system" {
+		end++
+	}
+	return start, end
+}
+
+// windowStart returns where the last window messages of history that aren't
+// system messages start, moved on to a user message so no reply or tool
+// result is cut off from what it answers
+func windowStart(roles []string, window int) int {
+	var others []int
+	for i, role := range roles {
+		if role != "system" {
+			others = append(others, i)
+		}
+	}
+	first := len(others) - window
+	if first < 0 {
+		first = 0
+	}
+	for first < len(others) && roles[others[first]] != "user" {
+		first++
+	}
+	if first < len(others) {
+		return others[first]
+	}
+	return len(roles)
+}
+
+// slideWindow keeps the system messages of history and its last window
+// other messages. It returns the history and the number of messages dropped.
+func slideWindow(history []ChatMessage, window int) ([]ChatMessage, int) {
+	roles := make([]string, len(history))
+	for i, msg := range history {
+		roles[i] = msg.Role
+	}
+	cut := windowStart(roles, window)
+
+	kept := make([]ChatMessage, 0, len(history))
+	for i, msg := range history {
+		if i >= cut || msg.Role == "system" {
+			kept = append(kept, msg)
+		}
+	}
+	return kept, len(history) - len(kept)
+}
+
+// summarizeOldest replaces the turns dropOldest would leave out with a
+// system message summarizing them, written by CONTEXT_SUMMARY_MODEL. It
+// returns the history and the number of messages summarized.
+func (s *Server) summarizeOldest(ctx context.Context, history, current []ChatMessage, budget int) ([]ChatMessage, int, error) {
+	_, n := dropOldest(history, current, budget)
+	if n == 0 {
+		return history, 0, nil
+	}
+
+	// dropOldest leaves out the first n messages that aren't system messages
+	var old []ChatMessage
+	kept := make([]ChatMessage, 0, len(history)-n+1)
+	at := -1
+	for _, msg := range history {
+		if msg.Role != "system" && len(old) < n {
+			if at < 0 {
+				at = len(kept)
+			}
+			old = append(old, msg)
+			continue
+		}
+		kept = append(kept, msg)
+	}
+
+	summary, err := s.summarize(ctx, s.config.ContextSummaryModel, old)
+	if err != nil {
+		return nil, 0, err
+	}
+	kept = append(kept[:at], append([]ChatMessage{{Role: "system", Content: summaryPrefix + summary}}, kept[at:]...)...)
+	return kept, n, nil
+}
+
+// summarize asks model for a summary of messages. The messages have been
+// through the prompt filters already.
+func (s *Server) summarize(ctx context.Context, model string, messages []ChatMessage) (string, error) {
+	var transcript strings.Builder
+	for _, msg := range messages {
+		if msg.Content != "" {
+			transcript.WriteString(msg.Role + ": " + msg.Content + "\n")
+		}
+		for _, call := range msg.ToolCalls {
+			transcript.WriteString(msg.Role + " called " + call.Function.Name + "(" + call.Function.Arguments + ")\n")
+		}
+	}
+	text := transcript.String()
+	// The most recent part is kept when the transcript is too long to send
+	if limit := s.config.MaxPromptLength - len(summaryInstructions) - 1; limit > 0 && len(text) > limit {
+		text = strings.ToValidUTF8(text[len(text)-limit:], "")
+	}
+
+	result, err := s.providers.GetCompletion(ctx, &copilot.CompletionRequest{
+		Model: model,
+		Messages: []copilot.Message{
+			{Role: "system", Content: summaryInstructions},
+			{Role: "user", Content: text},
+		},
+		Prompt:    summaryInstructions + "\n" + text,
+		Language:  "text",
+		MaxTokens: summaryMaxTokens,
+	})
+	if err != nil {
+		return "", err
+	}
+	summary := strings.TrimSpace(result.Text)
+	if summary == "" {
+		return "", fmt.Errorf("the summary is empty")
+	}
+	return summary, nil
+}
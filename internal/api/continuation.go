@@ -0,0 +1,155 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	stderrors "errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/devstroop/reai/internal/conversations"
+	"github.com/devstroop/reai/internal/keys"
+	"github.com/devstroop/reai/pkg/errors"
+)
+
+// conversationHeader carries the ID of the stored conversation a chat
+// completion was added to
+const conversationHeader = "X-ReAI-Conversation-ID"
+
+// conversationTurn is a chat request that continues a stored conversation
+type conversationTurn struct {
+	// id is the conversation the turn is appended to
+	id string
+	// base is stored ahead of the turn when it forks an earlier response
+	base []conversations.Message
+	// messages are the new messages sent by the client
+	messages []ChatMessage
+}
+
+// continueConversation resolves conversation_id, previous_response_id and
+// store. The stored history is put in front of the request messages; it is
+// trimmed to fit the model context window later, once filtered. It returns
+// nil when the request is stateless.
+func (s *Server) continueConversation(ctx context.Context, req *ChatCompletionRequest) (*conversationTurn, error) {
+	// In strict mode store keeps its OpenAI meaning and is ignored
+	store := req.Store && !s.config.StrictCompat
+	if req.ConversationID == "" && req.PreviousResponseID == "" && !store {
+		return nil, nil
+	}
+	if s.conversations == nil {
+		return nil, errors.NewValidationError("conversation_id, previous_response_id and store require CONVERSATIONS_ENABLED")
+	}
+	if req.ConversationID != "" && req.PreviousResponseID != "" {
+		return nil, errors.NewValidationError("conversation_id and previous_response_id are mutually exclusive")
+	}
+
+	owner := ownerFromContext(ctx)
+	turn := &conversationTurn{id: req.ConversationID}
+	var history []conversations.Message
+	switch {
+	case req.ConversationID != "":
+		c, err := s.conversations.Get(owner, req.ConversationID)
+		if err != nil {
+			return nil, conversationLookupError(err, "conversation "+req.ConversationID+" not found")
+		}
+		history = c.Messages
+	case req.PreviousResponseID != "":
+		c, index, err := s.conversations.FindResponse(owner, req.PreviousResponseID)
+		if err != nil {
+			return nil, conversationLookupError(err, "response "+req.PreviousResponseID+" not found")
+		}
+		history = c.Messages[:index+1]
+		turn.id = c.ID
+		if index < len(c.Messages)-1 {
+			// Continuing from an earlier response branches off a new conversation
+			turn.id = conversations.NewID()
+			for _, msg := range history {
+				msg.ResponseID = ""
+				turn.base = append(turn.base, msg)
+			}
+		}
+	default:
+		turn.id = conversations.NewID()
+	}
+
+	// System messages already in the history are not repeated
+	stored := make(map[string]bool)
+	var previous []ChatMessage
+	for _, msg := range history {
+		previous = append(previous, fromStoredMessage(msg))
+		if msg.Role == "system" {
+			stored[msg.Content] = true
+		}
+	}
+	for _, msg := range req.Messages {
+		if msg.Role != "system" || !stored[msg.Content] {
+			turn.messages = append(turn.messages, msg)
+		}
+	}
+
+	req.Messages = append(previous, turn.messages...)
+	return turn, nil
+}
+
+// saveConversationTurn stores the request messages and the assistant reply,
+// tool calls included. The reply keeps the response ID so it can be
+// continued with previous_response_id.
+func (s *Server) saveConversationTurn(ctx context.Context, turn *conversationTurn, model, responseID string, reply ChatMessage) {
+	messages := append([]conversations.Message{}, turn.base...)
+	for _, msg := range turn.messages {
+		messages = append(messages, toStoredMessage(msg))
+	}
+	stored := toStoredMessage(reply)
+	stored.ResponseID = responseID
+	messages = append(messages, stored)
+
+	if err := s.conversations.Append(ownerFromContext(ctx), turn.id, model, messages); err != nil {
+		slog.Warn("Failed to store conversation turn", "conversation", turn.id, "request_id", responseID, "error", err)
+	}
+}
+
+// applyConversationTurn returns the conversation ID for the response
+func applyConversationTurn(w http.ResponseWriter, ex *exchange, turn *conversationTurn) string {
+	if turn == nil {
+		return ""
+	}
+	w.Header().Set(conversationHeader, turn.id)
+	return turn.id
+}
+
+// ownerFromContext returns the API key ID of the request, or empty when
+// authentication is disabled
+func ownerFromContext(ctx context.Context) string {
+	if key := keys.FromContext(ctx); key != nil {
+		return key.ID
+	}
+	return ""
+}
+
+func conversationLookupError(err error, message string) error {
+	if stderrors.Is(err, conversations.ErrNotFound) {
+		return &errors.APIError{Type: "not_found", Message: message, Code: http.StatusNotFound}
+	}
+	return errors.NewInternalError(err.Error())
+}
+
+// toStoredMessage converts a request message for the conversation store
+func toStoredMessage(msg ChatMessage) conversations.Message {
+	stored := conversations.Message{Role: msg.Role, Content: msg.Content, Name: msg.Name, ToolCallID: msg.ToolCallID}
+	if len(msg.ToolCalls) > 0 {
+		stored.ToolCalls, _ = json.Marshal(msg.ToolCalls)
+	}
+	return stored
+}
+
+// fromStoredMessage converts a stored message back to a request message.
+// Tool calls that don't parse are dropped.
+func fromStoredMessage(stored conversations.Message) ChatMessage {
+	msg := ChatMessage{Role: stored.Role, Content: stored.Content, Name: stored.Name, ToolCallID: stored.ToolCallID}
+	if len(stored.ToolCalls) > 0 {
+		if err := json.Unmarshal(stored.ToolCalls, &msg.ToolCalls); err != nil {
+			msg.ToolCalls = nil
+		}
+	}
+	return msg
+}
@@ -0,0 +1,124 @@
+package api
+
+import (
+	"encoding/json"
+	stderrors "errors"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/devstroop/reai/internal/conversations"
+	"github.com/devstroop/reai/pkg/errors"
+)
+
+// maxImportSize bounds the body of a conversation import
+const maxImportSize = 256 << 20
+
+// conversationOwner returns the owner of conversations created by this request:
+// the API key ID, or empty when authentication is disabled
+func conversationOwner(r *http.Request) string {
+	return ownerFromContext(r.Context())
+}
+
+// handleConversations lists the caller's conversations
+func (s *Server) handleConversations(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	summaries := s.conversations.List(conversationOwner(r))
+	if summaries == nil {
+		summaries = []conversations.Summary{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"object": "list",
+		"data":   summaries,
+	})
+}
+
+// handleConversation returns (GET) or deletes (DELETE) a single conversation,
+// or compacts it (POST .../compact)
+func (s *Server) handleConversation(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/v1/conversations/")
+	owner := conversationOwner(r)
+	if id, compact := strings.CutSuffix(id, "/compact"); compact {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		s.handleConversationCompact(w, r, owner, id)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		conversation, err := s.conversations.Get(owner, id)
+		if err != nil {
+			writeConversationError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(conversation)
+	case http.MethodDelete:
+		if err := s.conversations.Delete(owner, id); err != nil {
+			writeConversationError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"id": id, "deleted": true})
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleConversationsExport streams the caller's conversations as JSONL
+func (s *Server) handleConversationsExport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Content-Disposition", `attachment; filename="conversations.jsonl"`)
+	count, err := s.conversations.Export(w, conversationOwner(r))
+	if err != nil {
+		slog.Error("Conversation export failed", "error", err)
+		return
+	}
+	slog.Info("Exported conversations", "count", count)
+}
+
+// handleConversationsImport imports JSONL conversations for the caller.
+// Existing conversations are only overwritten with ?replace=true.
+func (s *Server) handleConversationsImport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	replace := r.URL.Query().Get("replace") == "true"
+	result, err := s.conversations.Import(http.MaxBytesReader(w, r.Body, maxImportSize), conversationOwner(r), replace)
+	if err != nil {
+		errors.WriteErrorResponse(w, errors.NewValidationError(err.Error()))
+		return
+	}
+	slog.Info("Imported conversations", "imported", result.Imported, "skipped", result.Skipped, "invalid", len(result.Errors))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+func writeConversationError(w http.ResponseWriter, err error) {
+	if stderrors.Is(err, conversations.ErrNotFound) {
+		errors.WriteErrorResponse(w, &errors.APIError{Type: "not_found", Message: err.Error(), Code: http.StatusNotFound})
+		return
+	}
+	if stderrors.Is(err, conversations.ErrConflict) {
+		errors.WriteErrorResponse(w, &errors.APIError{Type: "conflict", Message: err.Error(), Code: http.StatusConflict})
+		return
+	}
+	writeError(w, errors.NewInternalError(err.Error()))
+}
@@ -0,0 +1,357 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/devstroop/reai/internal/copilot"
+)
+
+// fakeCopilot stands in for GitHub and Copilot: it issues session tokens,
+// lists models and answers chat and code completion requests with the next
+// canned event stream, keeping the request bodies
+type fakeCopilot struct {
+	mutex    sync.Mutex
+	requests []map[string]interface{}
+	// replies are the data of the events answering each completion request
+	replies [][]string
+	// models is the catalog, with the limits of each model
+	models []copilot.ModelInfo
+}
+
+func (f *fakeCopilot) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/copilot_internal/v2/token":
+		exp := time.Now().Add(time.Hour).Unix()
+		json.NewEncoder(w).Encode(map[string]interface{}{"token": fmt.Sprintf("tid=test;exp=%d:sig", exp), "expires_at": exp})
+	case "/models":
+		f.mutex.Lock()
+		defer f.mutex.Unlock()
+		json.NewEncoder(w).Encode(map[string]interface{}{"data": f.models})
+	case "/chat/completions", "/v1/engines/copilot-codex/completions":
+		var body map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		f.mutex.Lock()
+		f.requests = append(f.requests, body)
+		var events []string
+		if len(f.replies) > 0 {
+			events, f.replies = f.replies[0], f.replies[1:]
+		}
+		f.mutex.Unlock()
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		// Copilot opens with the prompt filter results, without choices
+		fmt.Fprint(w, "data: {\"choices\":[],\"prompt_filter_results\":[]}\n\n")
+		for _, event := range events {
+			fmt.Fprintf(w, "data: %s\n\n", event)
+		}
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// reply queues the answer to a completion request
+func (f *fakeCopilot) reply(events ...string) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.replies = append(f.replies, events)
+}
+
+// request returns the body of the nth completion request
+func (f *fakeCopilot) request(t *testing.T, n int) map[string]interface{} {
+	t.Helper()
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if n >= len(f.requests) {
+		t.Fatalf("%d completion requests, want more than %d", len(f.requests), n)
+	}
+	return f.requests[n]
+}
+
+// textEvents streams text as a chat reply
+func textEvents(text string) []string {
+	delta, _ := json.Marshal(text)
+	return []string{
+		`{"choices":[{"index":0,"delta":{"role":"assistant","content":` + string(delta) + `}}]}`,
+		`{"choices":[{"index":0,"delta":{},"finish_reason":"stop"}]}`,
+	}
+}
+
+// newCopilotServer starts a server whose Copilot upstream is fake
+func newCopilotServer(t *testing.T, fake *fakeCopilot, env map[string]string) *httptest.Server {
+	t.Helper()
+	upstream := httptest.NewServer(fake)
+	t.Cleanup(upstream.Close)
+
+	settings := map[string]string{
+		"UPSTREAM_MODE":       "",
+		"GITHUB_ACCESS_TOKEN": "gho_test",
+		"GITHUB_API_URL":      upstream.URL,
+		"COPILOT_API_URL":     upstream.URL,
+		"COPILOT_PROXY_URL":   upstream.URL,
+	}
+	for name, value := range env {
+		settings[name] = value
+	}
+	ts := httptest.NewServer(newMockServer(t, settings).Router())
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+// postJSON sends body to the server and decodes the JSON response into v
+func postJSON(t *testing.T, url string, body interface{}, v interface{}) http.Header {
+	t.Helper()
+	data, err := json.Marshal(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.Post(url, "application/json", bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	raw, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status %d: %s", resp.StatusCode, raw)
+	}
+	if err := json.Unmarshal(raw, v); err != nil {
+		t.Fatalf("%v: %s", err, raw)
+	}
+	return resp.Header
+}
+
+// upstreamMessages returns the role and content of the messages of a chat request
+func upstreamMessages(request map[string]interface{}) []string {
+	var messages []string
+	list, _ := request["messages"].([]interface{})
+	for _, m := range list {
+		msg, _ := m.(map[string]interface{})
+		content, _ := msg["content"].(string)
+		messages = append(messages, fmt.Sprintf("%s: %s", msg["role"], content))
+	}
+	return messages
+}
+
+func TestConversationContinuation(t *testing.T) {
+	fake := &fakeCopilot{}
+	ts := newCopilotServer(t, fake, map[string]string{"CONVERSATIONS_ENABLED": "true"})
+
+	fake.reply(textEvents("Nice to meet you, Ada.")...)
+	var first ChatCompletionResponse
+	postJSON(t, ts.URL+"/v1/chat/completions", map[string]interface{}{
+		"model":    "gpt-4o",
+		"store":    true,
+		"messages": []map[string]string{{"role": "user", "content": "My name is Ada."}},
+	}, &first)
+	if first.ConversationID == "" {
+		t.Fatal("no conversation ID")
+	}
+	if got := first.Choices[0].Message.Content; got != "Nice to meet you, Ada." {
+		t.Fatalf("first reply %q", got)
+	}
+
+	fake.reply(textEvents("Your name is Ada.")...)
+	var second ChatCompletionResponse
+	postJSON(t, ts.URL+"/v1/chat/completions", map[string]interface{}{
+		"model":           "gpt-4o",
+		"conversation_id": first.ConversationID,
+		"messages":        []map[string]string{{"role": "user", "content": "What is my name?"}},
+	}, &second)
+
+	want := []string{"user: My name is Ada.", "assistant: Nice to meet you, Ada.", "user: What is my name?"}
+	request := fake.request(t, 1)
+	if got := upstreamMessages(request); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("upstream messages\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+	if request["model"] != "gpt-4o" {
+		t.Errorf("upstream model %v", request["model"])
+	}
+
+	// Continuing from the first response sees the same history
+	fake.reply(textEvents("Ada.")...)
+	var branch ChatCompletionResponse
+	postJSON(t, ts.URL+"/v1/chat/completions", map[string]interface{}{
+		"model":                "gpt-4o",
+		"previous_response_id": first.ID,
+		"messages":             []map[string]string{{"role": "user", "content": "Say my name."}},
+	}, &branch)
+	want = []string{"user: My name is Ada.", "assistant: Nice to meet you, Ada.", "user: Say my name."}
+	if got := upstreamMessages(fake.request(t, 2)); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("upstream messages\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+// toolCallEvents streams a call of get_weather with its arguments split
+// across events
+func toolCallEvents() []string {
+	return []string{
+		`{"choices":[{"index":0,"delta":{"role":"assistant","content":null,"tool_calls":[{"index":0,"id":"call_1","type":"function","function":{"name":"get_weather","arguments":""}}]}}]}`,
+		`{"choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"function":{"arguments":"{\"city\":"}}]}}]}`,
+		`{"choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"function":{"arguments":"\"Paris\"}"}}]}}]}`,
+		`{"choices":[{"index":0,"delta":{},"finish_reason":"tool_calls"}]}`,
+	}
+}
+
+func TestToolCallRoundTrip(t *testing.T) {
+	fake := &fakeCopilot{}
+	ts := newCopilotServer(t, fake, nil)
+
+	tools := []map[string]interface{}{{
+		"type": "function",
+		"function": map[string]interface{}{
+			"name":       "get_weather",
+			"parameters": map[string]interface{}{"type": "object", "properties": map[string]interface{}{"city": map[string]string{"type": "string"}}},
+		},
+	}}
+	question := map[string]string{"role": "user", "content": "What is the weather in Paris?"}
+
+	fake.reply(toolCallEvents()...)
+	var first ChatCompletionResponse
+	postJSON(t, ts.URL+"/v1/chat/completions", map[string]interface{}{
+		"model":       "gpt-4o",
+		"messages":    []interface{}{question},
+		"tools":       tools,
+		"tool_choice": "auto",
+	}, &first)
+
+	request := fake.request(t, 0)
+	sent, _ := request["tools"].([]interface{})
+	if len(sent) != 1 || request["tool_choice"] != "auto" {
+		t.Fatalf("upstream tools %v, tool_choice %v", request["tools"], request["tool_choice"])
+	}
+	choice := first.Choices[0]
+	if choice.FinishReason != "tool_calls" {
+		t.Errorf("finish reason %q", choice.FinishReason)
+	}
+	calls := choice.Message.ToolCalls
+	if len(calls) != 1 || calls[0].ID != "call_1" || calls[0].Type != "function" ||
+		calls[0].Function.Name != "get_weather" || calls[0].Function.Arguments != `{"city":"Paris"}` {
+		t.Fatalf("tool calls %+v", calls)
+	}
+
+	// The call and its result go back as structured messages
+	fake.reply(textEvents("It is 18 degrees in Paris.")...)
+	var second ChatCompletionResponse
+	postJSON(t, ts.URL+"/v1/chat/completions", map[string]interface{}{
+		"model": "gpt-4o",
+		"messages": []interface{}{
+			question,
+			choice.Message,
+			map[string]string{"role": "tool", "tool_call_id": "call_1", "content": "18 degrees"},
+		},
+		"tools": tools,
+	}, &second)
+	if got := second.Choices[0].Message.Content; got != "It is 18 degrees in Paris." {
+		t.Errorf("second reply %q", got)
+	}
+
+	messages, _ := fake.request(t, 1)["messages"].([]interface{})
+	if len(messages) != 3 {
+		t.Fatalf("upstream messages %v", messages)
+	}
+	assistant, _ := messages[1].(map[string]interface{})
+	upstreamCalls, _ := assistant["tool_calls"].([]interface{})
+	if len(upstreamCalls) != 1 {
+		t.Fatalf("upstream assistant message %v", assistant)
+	}
+	call, _ := upstreamCalls[0].(map[string]interface{})
+	function, _ := call["function"].(map[string]interface{})
+	if call["id"] != "call_1" || function["name"] != "get_weather" || function["arguments"] != `{"city":"Paris"}` {
+		t.Errorf("upstream tool call %v", call)
+	}
+	result, _ := messages[2].(map[string]interface{})
+	if result["role"] != "tool" || result["tool_call_id"] != "call_1" || result["content"] != "18 degrees" {
+		t.Errorf("upstream tool result %v", result)
+	}
+}
+
+func TestStreamedToolCalls(t *testing.T) {
+	fake := &fakeCopilot{}
+	ts := newCopilotServer(t, fake, nil)
+
+	fake.reply(toolCallEvents()...)
+	body, _ := json.Marshal(map[string]interface{}{
+		"model":    "gpt-4o",
+		"stream":   true,
+		"messages": []map[string]string{{"role": "user", "content": "What is the weather in Paris?"}},
+		"tools":    []map[string]interface{}{{"type": "function", "function": map[string]string{"name": "get_weather"}}},
+	})
+	resp, err := http.Post(ts.URL+"/v1/chat/completions", "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var id, name, arguments, finishReason string
+	r := bufio.NewReader(resp.Body)
+	for {
+		data, err := readEvent(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if data == "[DONE]" {
+			break
+		}
+		var chunk ChatCompletionChunk
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			t.Fatalf("%v: %s", err, data)
+		}
+		for _, choice := range chunk.Choices {
+			for _, call := range choice.Delta.ToolCalls {
+				if call.Index != 0 {
+					t.Errorf("tool call index %d", call.Index)
+				}
+				id += call.ID
+				name += call.Function.Name
+				arguments += call.Function.Arguments
+			}
+			if choice.FinishReason != nil {
+				finishReason = *choice.FinishReason
+			}
+		}
+	}
+	if id != "call_1" || name != "get_weather" || arguments != `{"city":"Paris"}` || finishReason != "tool_calls" {
+		t.Errorf("streamed call %s %s(%s), finish reason %q", id, name, arguments, finishReason)
+	}
+}
+
+func TestInvalidTools(t *testing.T) {
+	tests := map[string]ChatCompletionRequest{
+		"type":           {Tools: []copilot.Tool{{Type: "retrieval", Function: copilot.FunctionDefinition{Name: "f"}}}},
+		"name":           {Tools: []copilot.Tool{{Function: copilot.FunctionDefinition{Name: "get weather"}}}},
+		"duplicate":      {Tools: []copilot.Tool{{Function: copilot.FunctionDefinition{Name: "f"}}, {Function: copilot.FunctionDefinition{Name: "f"}}}},
+		"parameters":     {Tools: []copilot.Tool{{Function: copilot.FunctionDefinition{Name: "f", Parameters: json.RawMessage(`[]`)}}}},
+		"choice no tool": {ToolChoice: json.RawMessage(`"auto"`)},
+		"choice mode":    {Tools: []copilot.Tool{{Function: copilot.FunctionDefinition{Name: "f"}}}, ToolChoice: json.RawMessage(`"always"`)},
+		"choice unknown": {Tools: []copilot.Tool{{Function: copilot.FunctionDefinition{Name: "f"}}}, ToolChoice: json.RawMessage(`{"type":"function","function":{"name":"g"}}`)},
+	}
+	for name, req := range tests {
+		if err := req.validateTools(); err == nil {
+			t.Errorf("%s: accepted", name)
+		}
+	}
+
+	req := ChatCompletionRequest{
+		Tools:      []copilot.Tool{{Function: copilot.FunctionDefinition{Name: "f", Parameters: json.RawMessage(`{"type":"object"}`)}}},
+		ToolChoice: json.RawMessage(`{"type":"function","function":{"name":"f"}}`),
+	}
+	if err := req.validateTools(); err != nil {
+		t.Fatal(err)
+	}
+	if req.Tools[0].Type != "function" {
+		t.Errorf("type %q, want function by default", req.Tools[0].Type)
+	}
+}
@@ -0,0 +1,110 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/devstroop/reai/pkg/errors"
+)
+
+// Client supplied deadline headers
+const (
+	// HeaderRequestDeadline is an absolute deadline (unix seconds or RFC3339)
+	HeaderRequestDeadline = "X-Request-Deadline"
+	// HeaderRequestMaxAge is the maximum age of the request (seconds or Go duration)
+	HeaderRequestMaxAge = "X-Request-Max-Age"
+	// HeaderRequestStart is when the client issued the request (unix seconds or RFC3339),
+	// used as the origin for max-age. Defaults to the time the request was received.
+	HeaderRequestStart = "X-Request-Start"
+)
+
+// deadlineMiddleware applies client supplied deadlines to the request context so the
+// remaining time propagates to upstream calls, and drops requests that are already late
+func (s *Server) deadlineMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		deadline, ok, err := parseRequestDeadline(r, time.Now())
+		if err != nil {
+			errors.WriteErrorResponse(w, errors.NewValidationError(err.Error()))
+			return
+		}
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if !time.Now().Before(deadline) {
+			slog.Debug("Dropping request past its deadline", "path", r.URL.Path, "deadline", deadline)
+			errors.WriteErrorResponse(w, errors.NewDeadlineExceededError("request expired before processing"))
+			return
+		}
+
+		ctx, cancel := context.WithDeadline(r.Context(), deadline)
+		defer cancel()
+
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// parseRequestDeadline returns the earliest deadline expressed by the request headers
+func parseRequestDeadline(r *http.Request, now time.Time) (time.Time, bool, error) {
+	var deadline time.Time
+	found := false
+
+	if value := r.Header.Get(HeaderRequestDeadline); value != "" {
+		t, err := parseTimestamp(value)
+		if err != nil {
+			return time.Time{}, false, fmt.Errorf("invalid %s header: %w", HeaderRequestDeadline, err)
+		}
+		deadline, found = t, true
+	}
+
+	if value := r.Header.Get(HeaderRequestMaxAge); value != "" {
+		maxAge, err := parseDuration(value)
+		if err != nil {
+			return time.Time{}, false, fmt.Errorf("invalid %s header: %w", HeaderRequestMaxAge, err)
+		}
+
+		start := now
+		if value := r.Header.Get(HeaderRequestStart); value != "" {
+			if start, err = parseTimestamp(value); err != nil {
+				return time.Time{}, false, fmt.Errorf("invalid %s header: %w", HeaderRequestStart, err)
+			}
+		}
+
+		if t := start.Add(maxAge); !found || t.Before(deadline) {
+			deadline, found = t, true
+		}
+	}
+
+	return deadline, found, nil
+}
+
+// parseTimestamp parses unix seconds (fractional allowed) or an RFC3339 timestamp
+func parseTimestamp(value string) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	if secs, err := strconv.ParseFloat(value, 64); err == nil {
+		return time.Unix(0, int64(secs*float64(time.Second))), nil
+	}
+	return time.Parse(time.RFC3339Nano, value)
+}
+
+// parseDuration parses seconds (fractional allowed) or a Go duration string
+func parseDuration(value string) (time.Duration, error) {
+	value = strings.TrimSpace(value)
+	if secs, err := strconv.ParseFloat(value, 64); err == nil {
+		if secs < 0 {
+			return 0, fmt.Errorf("negative duration")
+		}
+		return time.Duration(secs * float64(time.Second)), nil
+	}
+	d, err := time.ParseDuration(value)
+	if err == nil && d < 0 {
+		return 0, fmt.Errorf("negative duration")
+	}
+	return d, err
+}
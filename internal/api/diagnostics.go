@@ -0,0 +1,132 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"expvar"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"time"
+
+	"github.com/devstroop/reai/internal/copilot"
+)
+
+// processStart is when the process started serving, for the uptime
+var processStart = time.Now()
+
+// RuntimeStats is the snapshot served by /admin/runtime
+type RuntimeStats struct {
+	GoVersion     string  `json:"go_version"`
+	UptimeSeconds float64 `json:"uptime_seconds"`
+	NumCPU        int     `json:"num_cpu"`
+	GOMAXPROCS    int     `json:"gomaxprocs"`
+	Goroutines    int     `json:"goroutines"`
+
+	Heap HeapStats `json:"heap"`
+	GC   GCStats   `json:"gc"`
+
+	UpstreamConnections int `json:"upstream_connections_open"`
+	QueueDepth          int `json:"queue_depth"`
+	QueueInFlight       int `json:"queue_inflight"`
+	// ShedLevel is 0 without load shedding or while nothing is shed
+	ShedLevel int `json:"shed_level"`
+}
+
+// HeapStats describes the Go heap in bytes
+type HeapStats struct {
+	Alloc    uint64 `json:"alloc_bytes"`
+	InUse    uint64 `json:"inuse_bytes"`
+	Idle     uint64 `json:"idle_bytes"`
+	Released uint64 `json:"released_bytes"`
+	Sys      uint64 `json:"sys_bytes"`
+	Objects  uint64 `json:"objects"`
+}
+
+// GCStats describes the garbage collector
+type GCStats struct {
+	Cycles        uint32     `json:"cycles"`
+	Forced        uint32     `json:"forced_cycles"`
+	PauseTotalMS  float64    `json:"pause_total_ms"`
+	LastPauseMS   float64    `json:"last_pause_ms"`
+	Last          *time.Time `json:"last,omitempty"`
+	NextHeapBytes uint64     `json:"next_heap_bytes"`
+	CPUFraction   float64    `json:"cpu_fraction"`
+}
+
+// runtimeStats takes a snapshot of the process
+func (s *Server) runtimeStats() RuntimeStats {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+
+	stats := RuntimeStats{
+		GoVersion:     runtime.Version(),
+		UptimeSeconds: time.Since(processStart).Seconds(),
+		NumCPU:        runtime.NumCPU(),
+		GOMAXPROCS:    runtime.GOMAXPROCS(0),
+		Goroutines:    runtime.NumGoroutine(),
+		Heap: HeapStats{
+			Alloc:    mem.HeapAlloc,
+			InUse:    mem.HeapInuse,
+			Idle:     mem.HeapIdle,
+			Released: mem.HeapReleased,
+			Sys:      mem.HeapSys,
+			Objects:  mem.HeapObjects,
+		},
+		GC: GCStats{
+			Cycles:        mem.NumGC,
+			Forced:        mem.NumForcedGC,
+			PauseTotalMS:  float64(mem.PauseTotalNs) / 1e6,
+			NextHeapBytes: mem.NextGC,
+			CPUFraction:   mem.GCCPUFraction,
+		},
+		UpstreamConnections: copilot.OpenUpstreamConnections(),
+		QueueDepth:          s.queue.Depth(),
+		QueueInFlight:       s.queue.InFlight(),
+	}
+	if mem.NumGC > 0 {
+		last := time.Unix(0, int64(mem.LastGC)).UTC()
+		stats.GC.Last = &last
+		stats.GC.LastPauseMS = float64(mem.PauseNs[(mem.NumGC+255)%256]) / 1e6
+	}
+	if s.shedder != nil {
+		stats.ShedLevel = s.shedder.current(time.Now())
+	}
+	return stats
+}
+
+// handleAdminRuntime reports goroutines, heap, GC and connection counts
+func (s *Server) handleAdminRuntime(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(s.runtimeStats())
+}
+
+// debugHandler serves net/http/pprof under /admin/debug/pprof/ and expvar
+// under /admin/debug/vars. pprof expects its own /debug/pprof/ paths, so the
+// /admin prefix is stripped before it sees the request.
+func debugHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", longRunning(pprof.Profile))
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", longRunning(pprof.Trace))
+	mux.Handle("/debug/vars", expvar.Handler())
+	return http.StripPrefix("/admin", mux)
+}
+
+// longRunning lifts the server WriteTimeout for CPU profiles and traces,
+// which run for as many seconds as the caller asks
+func longRunning(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		http.NewResponseController(w).SetWriteDeadline(time.Time{})
+		// pprof refuses durations past the WriteTimeout of the server in the context
+		ctx := context.WithValue(r.Context(), http.ServerContextKey, &http.Server{})
+		next(w, r.WithContext(ctx))
+	}
+}
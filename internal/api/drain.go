@@ -0,0 +1,112 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+
+	"github.com/devstroop/reai/pkg/errors"
+)
+
+// drainMessage is returned to requests arriving after shutdown started
+const drainMessage = "ReAI is shutting down, please retry"
+
+// drainState counts the API requests in flight, streams and WebSocket
+// sessions included, so that shutdown can wait for them
+type drainState struct {
+	mutex    sync.Mutex
+	draining bool
+	inflight int
+	// started is closed when draining begins, idle once nothing is left in
+	// flight after that
+	started chan struct{}
+	idle    chan struct{}
+}
+
+func newDrainState() *drainState {
+	return &drainState{started: make(chan struct{}), idle: make(chan struct{})}
+}
+
+// begin counts a new request, unless draining already began
+func (d *drainState) begin() bool {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if d.draining {
+		return false
+	}
+	d.inflight++
+	return true
+}
+
+// end uncounts a request begun earlier
+func (d *drainState) end() {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.inflight--
+	if d.draining && d.inflight == 0 {
+		close(d.idle)
+	}
+}
+
+// start begins draining and returns the number of requests in flight
+func (d *drainState) start() int {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if !d.draining {
+		d.draining = true
+		close(d.started)
+		if d.inflight == 0 {
+			close(d.idle)
+		}
+	}
+	return d.inflight
+}
+
+func (d *drainState) active() bool {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return d.draining
+}
+
+func (d *drainState) remaining() int {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return d.inflight
+}
+
+// Drain stops accepting API requests and waits until the ones in flight,
+// streams and WebSocket sessions included, have finished or ctx is done.
+// New requests get 503 and /health/ready fails from the moment it is called.
+func (s *Server) Drain(ctx context.Context) error {
+	if inflight := s.drain.start(); inflight > 0 {
+		slog.Info("Draining in-flight requests", "requests", inflight)
+	}
+	select {
+	case <-s.drain.idle:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("%d requests still in flight: %w", s.drain.remaining(), ctx.Err())
+	}
+}
+
+// Draining reports whether Drain was called
+func (s *Server) Draining() bool {
+	return s.drain.active()
+}
+
+// drainMiddleware tracks API requests for Drain and turns new ones away once
+// shutdown started
+func (s *Server) drainMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !s.drain.begin() {
+			w.Header().Set("Connection", "close")
+			w.Header().Set("Retry-After", "1")
+			errors.WriteErrorResponse(w, errors.NewServiceUnavailableError(drainMessage))
+			return
+		}
+		defer s.drain.end()
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,211 @@
+package api
+
+import (
+	"context"
+	stderrors "errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/devstroop/reai/internal/audit"
+	"github.com/devstroop/reai/internal/copilot"
+	"github.com/devstroop/reai/internal/experiment"
+	"github.com/devstroop/reai/internal/keys"
+	"github.com/devstroop/reai/internal/metrics"
+	"github.com/devstroop/reai/internal/transform"
+	"github.com/devstroop/reai/internal/watermark"
+)
+
+// Completion outcomes recorded in reai_completions_total
+const (
+	outcomeCompleted        = "completed"
+	outcomeError            = "error"
+	outcomeDeadlineExceeded = "deadline_exceeded"
+	outcomeClientCancelled  = "client_cancelled"
+)
+
+// backendHeader names the backend provider that served a completion
+const backendHeader = "X-ReAI-Backend"
+
+var completionOutcomes = metrics.NewCounterVec("reai_completions_total", "Completions by outcome (completed, error, deadline_exceeded, client_cancelled)", "stream", "outcome")
+
+// exchange follows one completion from request to response and feeds the
+// usage record and audit log, whether it was buffered or streamed
+type exchange struct {
+	server  *Server
+	request *http.Request
+	// header receives the backend header; nil when there is no HTTP response
+	header   http.Header
+	id       string
+	model    string
+	backend  string
+	prompt   string
+	stream   bool
+	start    time.Time
+	record   *usageRecord
+	ignored  []string
+	degraded []string
+	warnings []string
+
+	// completion is the text returned to the client, bounded by the audit policy
+	completion *audit.Transcript
+	// output runs the output hooks over the completion (nil when none apply)
+	output *transform.Chain
+	// experiment is the A/B experiment variant of the request, nil when none
+	experiment *experiment.Assignment
+	// lastChunk is when content was last streamed, zero before the first
+	lastChunk time.Time
+}
+
+// startExchange begins tracking a completion of the upstream request. When w
+// is set the watermark header is added to the response.
+func (s *Server) startExchange(w http.ResponseWriter, r *http.Request, id, model string, upstream *copilot.CompletionRequest, stream bool) *exchange {
+	e := &exchange{
+		server:     s,
+		request:    r,
+		id:         id,
+		model:      model,
+		backend:    s.providers.Backend(model),
+		prompt:     upstream.Prompt,
+		stream:     stream,
+		start:      time.Now(),
+		record:     s.newUsageRecord(r, id),
+		completion: s.newTranscript(),
+		ignored:    s.providers.UnsupportedParameters(upstream),
+	}
+	e.output = s.startOutputHooks(e, len(upstream.Messages) > 0, upstream.Language)
+	s.warmModels.touch(model)
+	if len(e.ignored) > 0 {
+		e.degrade(degradedParametersIgnored)
+		e.warnings = append(e.warnings, ignoredParametersWarning(e.ignored))
+	}
+	if upstream.RequestedMaxTokens > 0 {
+		e.degrade(degradedMaxTokensClamped)
+		e.warnings = append(e.warnings, fmt.Sprintf("max_tokens lowered from %d to %d to fit the model context window", upstream.RequestedMaxTokens, upstream.MaxTokens))
+	}
+	if upstream.Experiment != "" {
+		e.experiment = &experiment.Assignment{Experiment: upstream.Experiment, Variant: upstream.Variant, Model: model}
+	}
+	if w != nil {
+		e.header = w.Header()
+		if e.record != nil {
+			w.Header().Set(watermark.Header, e.record.value)
+		}
+		if e.experiment != nil {
+			w.Header().Set(experimentHeader, e.experiment.Experiment+"/"+e.experiment.Variant)
+		}
+	}
+	return e
+}
+
+// served records the provider that served the completion, which differs
+// from the one the model routes to after a failover
+func (e *exchange) served(backend string) {
+	if backend == "" {
+		return
+	}
+	if backend != e.backend {
+		e.degrade(degradedFailover)
+		e.warnings = append(e.warnings, fmt.Sprintf("served by %s because %s is unavailable", backend, e.backend))
+		e.backend = backend
+	}
+	if e.header != nil {
+		e.header.Set(backendHeader, backend)
+	}
+}
+
+// degrade flags the response as differing from what the client asked for
+func (e *exchange) degrade(flag string) {
+	for _, existing := range e.degraded {
+		if existing == flag {
+			return
+		}
+	}
+	e.degraded = append(e.degraded, flag)
+}
+
+// finish records the outcome. After an error the transcript holds whatever
+// was streamed before the failure.
+func (e *exchange) finish(finishReason string, err error) {
+	transcript := e.completion
+	outcome := e.outcome(err)
+	completionOutcomes.With(strconv.FormatBool(e.stream), outcome).Inc()
+	e.observeExperiment(outcome)
+	if outcome == outcomeClientCancelled {
+		slog.Debug("Completion cancelled by client", "request_id", e.id, "path", e.request.URL.Path, "duration", time.Since(e.start))
+	}
+	if err == nil {
+		e.record.save(e.server.records, e.model, e.prompt, transcript, finishReason)
+	}
+	var cost float64
+	if estimate := e.costEstimate(); estimate != nil {
+		cost = estimate.Total
+	}
+	tokens := e.tokenUsage()
+	e.server.chargeTokens(e.request.Context(), tokens.TotalTokens, cost)
+	var keyID string
+	if key := keys.FromContext(e.request.Context()); key != nil {
+		keyID = key.ID
+	}
+	e.server.usage.Add(time.Now(), keyID, e.model, tokens.PromptTokens, tokens.CompletionTokens, cost, err != nil)
+	if e.server.audit == nil && e.server.sinks == nil {
+		return
+	}
+
+	record := audit.Record{
+		Time:                e.start.UTC(),
+		RequestID:           e.id,
+		RemoteAddr:          e.request.RemoteAddr,
+		Path:                e.request.URL.Path,
+		Model:               e.model,
+		Stream:              e.stream,
+		Status:              audit.StatusOK,
+		FinishReason:        finishReason,
+		DurationMS:          float64(time.Since(e.start).Microseconds()) / 1000,
+		Prompt:              e.prompt,
+		Completion:          transcript.String(),
+		CompletionTruncated: transcript.Truncated(),
+		CompletionSHA256:    transcript.Sum(),
+		Chunks:              transcript.Chunks(),
+		PromptTokens:        estimateTokens(e.prompt),
+		CompletionTokens:    estimateTokenCount(transcript.Len()),
+		Cost:                cost,
+		KeyID:               keyID,
+	}
+	if err != nil {
+		record.Status = audit.StatusError
+		record.Error = err.Error()
+		if outcome == outcomeClientCancelled {
+			record.Status = audit.StatusCancelled
+		}
+	}
+	e.server.audit.Log(record)
+	e.server.sinks.Publish(record)
+}
+
+// outcome classifies how the completion ended. A client that disconnected
+// shows up as a cancelled request context or a failed write to the response.
+func (e *exchange) outcome(err error) string {
+	switch {
+	case err == nil:
+		return outcomeCompleted
+	case stderrors.Is(err, context.Canceled) || e.request.Context().Err() == context.Canceled:
+		return outcomeClientCancelled
+	case stderrors.Is(err, context.DeadlineExceeded) || e.request.Context().Err() == context.DeadlineExceeded:
+		return outcomeDeadlineExceeded
+	}
+	return outcomeError
+}
+
+// tokenUsage returns the token usage of the completion
+func (e *exchange) tokenUsage() *Usage {
+	promptTokens := estimateTokens(e.prompt)
+	completionTokens := estimateTokenCount(e.completion.Len())
+	return &Usage{
+		PromptTokens:     promptTokens,
+		CompletionTokens: completionTokens,
+		TotalTokens:      promptTokens + completionTokens,
+	}
+}
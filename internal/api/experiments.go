@@ -0,0 +1,49 @@
+package api
+
+import (
+	"context"
+	"time"
+
+	"github.com/devstroop/reai/internal/experiment"
+	"github.com/devstroop/reai/internal/keys"
+	"github.com/devstroop/reai/internal/metrics"
+)
+
+// experimentHeader names the experiment and variant of a response as
+// "<experiment>/<variant>"
+const experimentHeader = "X-ReAI-Experiment"
+
+var (
+	experimentRequests = metrics.NewCounterVec("reai_experiment_requests_total", "Completions of A/B experiments by variant and outcome", "experiment", "variant", "outcome")
+	experimentDuration = metrics.NewHistogramVec("reai_experiment_duration_seconds", "Duration of completed A/B experiment completions by variant", nil, "experiment", "variant")
+	experimentTokens   = metrics.NewHistogramVec("reai_experiment_completion_tokens", "Estimated completion tokens of completed A/B experiment completions by variant",
+		[]float64{16, 32, 64, 128, 256, 512, 1024, 2048, 4096}, "experiment", "variant")
+)
+
+// assignExperiment assigns a request for model to a variant of its
+// experiment. Without one the assignment just keeps the model.
+func (s *Server) assignExperiment(ctx context.Context, model string) experiment.Assignment {
+	var subject string
+	if key := keys.FromContext(ctx); key != nil {
+		subject = key.ID
+	}
+	assignment, ok := s.experiments.Assign(model, subject)
+	if !ok {
+		return experiment.Assignment{Model: model}
+	}
+	return assignment
+}
+
+// observeExperiment records the outcome of an experiment completion
+func (e *exchange) observeExperiment(outcome string) {
+	if e.experiment == nil {
+		return
+	}
+	name, variant := e.experiment.Experiment, e.experiment.Variant
+	experimentRequests.With(name, variant, outcome).Inc()
+	if outcome != outcomeCompleted {
+		return
+	}
+	experimentDuration.With(name, variant).Observe(time.Since(e.start).Seconds())
+	experimentTokens.With(name, variant).Observe(float64(e.tokenUsage().CompletionTokens))
+}
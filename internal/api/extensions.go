@@ -0,0 +1,74 @@
+package api
+
+import (
+	"strings"
+
+	"github.com/devstroop/reai/internal/experiment"
+	"github.com/devstroop/reai/internal/pricing"
+)
+
+// Cache value reported in the x_reai object
+const cacheNone = "none"
+
+// Degradation flags reported in the x_reai object
+const (
+	// degradedParametersIgnored means request parameters the upstream does not support were dropped
+	degradedParametersIgnored = "parameters_ignored"
+	// degradedContentRedacted means a completion filter changed the text (logprobs are dropped with it)
+	degradedContentRedacted = "content_redacted"
+	// degradedContentBlocked means a completion filter withheld the text
+	degradedContentBlocked = "content_blocked"
+	// degradedMaxTokensClamped means max_tokens was lowered to fit the context window
+	degradedMaxTokensClamped = "max_tokens_clamped"
+	// degradedFailover means the failover provider served a request because Copilot failed
+	degradedFailover = "failover"
+	// degradedContextTrimmed means stored conversation history was left out to fit the context window
+	degradedContextTrimmed = "context_trimmed"
+)
+
+// Extensions is the x_reai object added to responses. It is the stable home
+// for ReAI specific details that have no place in the OpenAI schema.
+type Extensions struct {
+	Backend      string        `json:"backend"`
+	Cache        string        `json:"cache"`
+	Degraded     []string      `json:"degraded"`
+	Warnings     []string      `json:"warnings,omitempty"`
+	Watermark    string        `json:"watermark,omitempty"`
+	CostEstimate *CostEstimate `json:"cost_estimate,omitempty"`
+	// Experiment names the A/B experiment variant that served the request
+	Experiment *experiment.Assignment `json:"experiment,omitempty"`
+}
+
+// CostEstimate prices the estimated token usage of a response
+type CostEstimate = pricing.Estimate
+
+// extensions returns the x_reai object for the exchange, or nil when
+// extensions are turned off
+func (e *exchange) extensions() *Extensions {
+	cfg := e.server.config
+	if !cfg.ResponseExtensions || cfg.StrictCompat {
+		return nil
+	}
+
+	return &Extensions{
+		Backend:      e.backend,
+		Cache:        cacheNone,
+		Degraded:     append([]string{}, e.degraded...),
+		Warnings:     e.warnings,
+		Watermark:    e.record.signed(),
+		CostEstimate: e.costEstimate(),
+		Experiment:   e.experiment,
+	}
+}
+
+// costEstimate prices the estimated token usage of the exchange with the
+// price of its model, nil when it has none
+func (e *exchange) costEstimate() *CostEstimate {
+	usage := e.tokenUsage()
+	return e.server.pricing.Estimate(e.model, usage.PromptTokens, usage.CompletionTokens)
+}
+
+// ignoredParametersWarning describes parameters dropped before the request went upstream
+func ignoredParametersWarning(ignored []string) string {
+	return "ignored unsupported parameters: " + strings.Join(ignored, ", ")
+}
@@ -0,0 +1,169 @@
+package api
+
+import (
+	"encoding/json"
+	stderrors "errors"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/devstroop/reai/internal/files"
+	"github.com/devstroop/reai/pkg/errors"
+)
+
+// Page sizes of GET /v1/files, as in the OpenAI API
+const (
+	defaultFilesLimit = 10000
+	maxFilesLimit     = 10000
+)
+
+// handleFiles lists the caller's files (GET) or uploads a new one (POST)
+func (s *Server) handleFiles(w http.ResponseWriter, r *http.Request) {
+	owner := conversationOwner(r)
+
+	switch r.Method {
+	case http.MethodGet:
+		s.listFiles(w, r, owner)
+	case http.MethodPost:
+		s.uploadFile(w, r, owner)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// listFiles returns a page of the caller's files, filtered by purpose and
+// paged with the limit, after and order query parameters
+func (s *Server) listFiles(w http.ResponseWriter, r *http.Request, owner string) {
+	query := r.URL.Query()
+	opts := files.ListOptions{Purpose: query.Get("purpose"), After: query.Get("after"), Limit: defaultFilesLimit}
+	if value := query.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxFilesLimit {
+			errors.WriteErrorResponse(w, errors.NewValidationError("limit must be between 1 and "+strconv.Itoa(maxFilesLimit)))
+			return
+		}
+		opts.Limit = limit
+	}
+	switch query.Get("order") {
+	case "", "desc":
+	case "asc":
+		opts.Ascending = true
+	default:
+		errors.WriteErrorResponse(w, errors.NewValidationError("order must be asc or desc"))
+		return
+	}
+
+	list, hasMore := s.files.List(owner, opts)
+	response := map[string]interface{}{
+		"object":   "list",
+		"data":     list,
+		"has_more": hasMore,
+	}
+	if len(list) == 0 {
+		response["data"] = []files.File{}
+	} else {
+		response["first_id"] = list[0].ID
+		response["last_id"] = list[len(list)-1].ID
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// uploadFile stores a multipart upload with "file" and "purpose" fields
+func (s *Server) uploadFile(w http.ResponseWriter, r *http.Request, owner string) {
+	// Leave room for the multipart framing and the purpose field
+	r.Body = http.MaxBytesReader(w, r.Body, s.config.FilesMaxBytes+1<<20)
+	if err := r.ParseMultipartForm(32 << 20); err != nil {
+		errors.WriteErrorResponse(w, errors.NewValidationError("Invalid multipart form: "+err.Error()))
+		return
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	purpose := r.FormValue("purpose")
+	if !files.ValidUploadPurpose(purpose) {
+		errors.WriteErrorResponse(w, errors.NewValidationError("purpose must be one of "+strings.Join(files.UploadPurposes, ", ")))
+		return
+	}
+	upload, header, err := r.FormFile("file")
+	if err != nil {
+		errors.WriteErrorResponse(w, errors.NewValidationError("file is required"))
+		return
+	}
+	defer upload.Close()
+
+	file, err := s.files.Create(owner, header.Filename, purpose, upload)
+	if err != nil {
+		if stderrors.Is(err, files.ErrTooLarge) {
+			errors.WriteErrorResponse(w, errors.NewValidationError("file exceeds the "+strconv.FormatInt(s.config.FilesMaxBytes, 10)+" byte limit"))
+			return
+		}
+		writeError(w, errors.NewInternalError(err.Error()))
+		return
+	}
+	slog.Info("Stored file", "id", file.ID, "purpose", file.Purpose, "bytes", file.Bytes)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(file)
+}
+
+// handleFile returns (GET) or deletes (DELETE) a file, or with a /content
+// suffix downloads its content
+func (s *Server) handleFile(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/v1/files/")
+	id, content := strings.CutSuffix(id, "/content")
+	owner := conversationOwner(r)
+
+	switch {
+	case content && r.Method == http.MethodGet:
+		file, err := s.files.Get(owner, id)
+		if err != nil {
+			writeFileError(w, err)
+			return
+		}
+		data, err := s.files.Open(owner, id)
+		if err != nil {
+			writeFileError(w, err)
+			return
+		}
+		defer data.Close()
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": file.Filename}))
+		w.Header().Set("Content-Length", strconv.FormatInt(file.Bytes, 10))
+		io.Copy(w, data)
+	case content:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	case r.Method == http.MethodGet:
+		file, err := s.files.Get(owner, id)
+		if err != nil {
+			writeFileError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(file)
+	case r.Method == http.MethodDelete:
+		if s.batches != nil && s.batches.UsesFile(id) {
+			errors.WriteErrorResponse(w, &errors.APIError{Type: "conflict", Message: "file is the input of a batch that has not finished", Code: http.StatusConflict})
+			return
+		}
+		if err := s.files.Delete(owner, id); err != nil {
+			writeFileError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"id": id, "object": "file", "deleted": true})
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func writeFileError(w http.ResponseWriter, err error) {
+	if stderrors.Is(err, files.ErrNotFound) {
+		errors.WriteErrorResponse(w, &errors.APIError{Type: "not_found", Message: err.Error(), Code: http.StatusNotFound})
+		return
+	}
+	writeError(w, errors.NewInternalError(err.Error()))
+}
@@ -0,0 +1,110 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/devstroop/reai/internal/files"
+)
+
+// uploadFile posts content as a multipart upload and returns the response
+func uploadFile(t *testing.T, url, purpose, filename, content string) *http.Response {
+	t.Helper()
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	if purpose != "" {
+		form.WriteField("purpose", purpose)
+	}
+	part, _ := form.CreateFormFile("file", filename)
+	io.WriteString(part, content)
+	form.Close()
+
+	resp, err := http.Post(url+"/v1/files", form.FormDataContentType(), &body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func TestFilesAPI(t *testing.T) {
+	ts := httptest.NewServer(newMockServer(t, map[string]string{"FILES_ENABLED": "true", "FILES_MAX_BYTES": "64"}).Router())
+	defer ts.Close()
+
+	const content = `{"custom_id":"1"}` + "\n"
+	resp := uploadFile(t, ts.URL, files.PurposeBatch, "input.jsonl", content)
+	var file files.File
+	json.NewDecoder(resp.Body).Decode(&file)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || file.ID == "" || file.Bytes != int64(len(content)) || file.Filename != "input.jsonl" {
+		t.Fatalf("upload: %d %+v", resp.StatusCode, file)
+	}
+
+	resp, err := http.Get(ts.URL + "/v1/files/" + file.ID + "/content")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(data) != content || resp.Header.Get("Content-Disposition") != `attachment; filename=input.jsonl` {
+		t.Errorf("download %q, %s", data, resp.Header.Get("Content-Disposition"))
+	}
+
+	var list struct {
+		Data    []files.File `json:"data"`
+		HasMore bool         `json:"has_more"`
+	}
+	resp, err = http.Get(ts.URL + "/v1/files?purpose=batch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	json.NewDecoder(resp.Body).Decode(&list)
+	resp.Body.Close()
+	if len(list.Data) != 1 || list.Data[0].ID != file.ID || list.HasMore {
+		t.Errorf("list %+v", list)
+	}
+
+	// Bad uploads are rejected
+	for name, resp := range map[string]*http.Response{
+		"purpose":    uploadFile(t, ts.URL, files.PurposeBatchOutput, "out.jsonl", content),
+		"no purpose": uploadFile(t, ts.URL, "", "in.jsonl", content),
+		"too large":  uploadFile(t, ts.URL, files.PurposeBatch, "big.jsonl", string(bytes.Repeat([]byte("x"), 65))),
+	} {
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("%s: status %d", name, resp.StatusCode)
+		}
+	}
+	resp, err = http.Get(ts.URL + "/v1/files?limit=0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("limit=0: status %d", resp.StatusCode)
+	}
+
+	req, _ := http.NewRequest(http.MethodDelete, ts.URL+"/v1/files/"+file.ID, nil)
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("delete status %d", resp.StatusCode)
+	}
+	for _, path := range []string{"/v1/files/" + file.ID, "/v1/files/" + file.ID + "/content"} {
+		resp, err := http.Get(ts.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusNotFound {
+			t.Errorf("%s after delete: status %d", path, resp.StatusCode)
+		}
+	}
+}
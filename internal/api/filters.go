@@ -0,0 +1,109 @@
+package api
+
+import (
+	stderrors "errors"
+	"log/slog"
+
+	"github.com/devstroop/reai/internal/copilot"
+	"github.com/devstroop/reai/internal/filter"
+	"github.com/devstroop/reai/pkg/errors"
+)
+
+// filterPrompt runs the prompt filters before anything is sent upstream
+func (s *Server) filterPrompt(prompt string) (string, error) {
+	filtered, err := s.filters.Load().FilterPrompt(prompt)
+	if err != nil {
+		logFilterBlock(err)
+		return "", errors.NewContentFilterError(err.Error())
+	}
+	return filtered, nil
+}
+
+// filterCompletion runs the completion filters, then the output hooks, over
+// a buffered result. A blocked completion is emptied and reported with the
+// content_filter finish reason.
+func (s *Server) filterCompletion(ex *exchange, result *copilot.CompletionResult) {
+	if s.filters.Load().HasCompletionFilters() {
+		filtered, err := s.filters.Load().FilterCompletion(result.Text)
+		if err != nil {
+			logFilterBlock(err)
+			blockCompletion(ex, result)
+			return
+		}
+		if filtered != result.Text {
+			// Token logprobs no longer line up with redacted text
+			result.Text = filtered
+			result.Logprobs = nil
+			ex.degrade(degradedContentRedacted)
+		}
+		if result.Reasoning != "" {
+			reasoning, err := s.filters.Load().FilterCompletion(result.Reasoning)
+			if err != nil {
+				logFilterBlock(err)
+				blockCompletion(ex, result)
+				return
+			}
+			if reasoning != result.Reasoning {
+				result.Reasoning = reasoning
+				ex.degrade(degradedContentRedacted)
+			}
+		}
+	}
+
+	if ex.output != nil {
+		transformed, err := ex.output.Apply(result.Text, result.FinishReason)
+		if err != nil {
+			outputHookError(err)
+			blockCompletion(ex, result)
+			return
+		}
+		if transformed != result.Text {
+			result.Text = transformed
+			result.Logprobs = nil
+		}
+		if ex.output.Stopped() {
+			result.FinishReason = copilot.FinishReasonStop
+		}
+	}
+}
+
+// blockCompletion empties a buffered result withheld by a filter or hook
+func blockCompletion(ex *exchange, result *copilot.CompletionResult) {
+	result.Text = ""
+	result.Reasoning = ""
+	result.Logprobs = nil
+	result.FinishReason = copilot.FinishReasonContentFilter
+	ex.degrade(degradedContentBlocked)
+}
+
+// newCompletionStreamFilter returns a stream filter, or nil when completions are not filtered
+func (s *Server) newCompletionStreamFilter() *filter.StreamFilter {
+	if !s.filters.Load().HasCompletionFilters() {
+		return nil
+	}
+	return s.filters.Load().NewStreamFilter()
+}
+
+// finishFilteredStream handles the end of a streamed completion that ended with
+// err. It returns the held back text still to be sent and whether a filter
+// blocked the completion; other errors are passed through.
+func finishFilteredStream(sf *filter.StreamFilter, err error) (string, bool, error) {
+	if err == nil && sf != nil {
+		var tail string
+		if tail, err = sf.Flush(); err == nil {
+			return tail, false, nil
+		}
+	}
+	if stderrors.Is(err, filter.ErrBlocked) {
+		logFilterBlock(err)
+		return "", true, nil
+	}
+	return "", false, err
+}
+
+func logFilterBlock(err error) {
+	var blocked *filter.BlockedError
+	if stderrors.As(err, &blocked) {
+		slog.Warn("🚫 Content blocked by filter", "filter", blocked.Filter, "direction", blocked.Direction)
+	}
+}
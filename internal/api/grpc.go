@@ -0,0 +1,367 @@
+package api
+
+import (
+	"context"
+	stderrors "errors"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	reaiv1 "github.com/devstroop/reai/api/proto/reai/v1"
+	"github.com/devstroop/reai/internal/copilot"
+	"github.com/devstroop/reai/internal/keys"
+	"github.com/devstroop/reai/pkg/errors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// maxGRPCMessageSize bounds incoming gRPC messages
+const maxGRPCMessageSize = 4 << 20
+
+// grpcService implements the reai.v1.ReAI service of api/proto/reai/v1/reai.proto
+type grpcService struct {
+	reaiv1.UnimplementedReAIServer
+	server *Server
+}
+
+// grpcRequestKey is the context key of the HTTP request carrying a gRPC call
+type grpcRequestKey struct{}
+
+// GRPCHandler returns the handler for the gRPC listener. grpc-go serves the
+// calls over net/http's HTTP/2 server, so the service shares the API
+// middleware; gRPC clients map the HTTP errors of those middlewares to
+// status codes.
+func (s *Server) GRPCHandler() http.Handler {
+	grpcServer := grpc.NewServer(
+		grpc.MaxRecvMsgSize(maxGRPCMessageSize),
+		grpc.UnaryInterceptor(grpcUnaryErrors),
+		grpc.StreamInterceptor(grpcStreamErrors),
+	)
+	reaiv1.RegisterReAIServer(grpcServer, &grpcService{server: s})
+
+	// The exchange records the HTTP request of a call, as for the other APIs
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		grpcServer.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), grpcRequestKey{}, r)))
+	})
+	return s.loggingMiddleware(s.apiHandler(handler))
+}
+
+// grpcRequest returns the HTTP request of the call, with the call context
+func grpcRequest(ctx context.Context) *http.Request {
+	r, _ := ctx.Value(grpcRequestKey{}).(*http.Request)
+	return r.WithContext(ctx)
+}
+
+func (g *grpcService) ListModels(ctx context.Context, _ *reaiv1.ListModelsRequest) (*reaiv1.ListModelsResponse, error) {
+	models, err := g.server.providers.GetAvailableModels(ctx)
+	if err != nil {
+		slog.Error("Failed to fetch models", "error", err)
+		return nil, errors.NewInternalError("Unable to fetch models")
+	}
+
+	response := &reaiv1.ListModelsResponse{}
+	for _, model := range models {
+		response.Models = append(response.Models, encodeModel(model))
+	}
+	return response, nil
+}
+
+func (g *grpcService) Complete(ctx context.Context, in *reaiv1.CompletionRequest) (*reaiv1.CompletionResponse, error) {
+	return g.server.grpcComplete(ctx, in, nil)
+}
+
+func (g *grpcService) StreamComplete(in *reaiv1.CompletionRequest, stream grpc.ServerStreamingServer[reaiv1.CompletionChunk]) error {
+	_, err := g.server.grpcComplete(stream.Context(), in, stream)
+	return err
+}
+
+func (g *grpcService) Chat(ctx context.Context, in *reaiv1.ChatRequest) (*reaiv1.ChatResponse, error) {
+	return g.server.grpcChat(ctx, in, nil)
+}
+
+func (g *grpcService) StreamChat(in *reaiv1.ChatRequest, stream grpc.ServerStreamingServer[reaiv1.ChatChunk]) error {
+	_, err := g.server.grpcChat(stream.Context(), in, stream)
+	return err
+}
+
+// grpcComplete runs a code completion, streamed to stream when it is set
+func (s *Server) grpcComplete(ctx context.Context, in *reaiv1.CompletionRequest, stream grpc.ServerStreamingServer[reaiv1.CompletionChunk]) (*reaiv1.CompletionResponse, error) {
+	req := decodeCompletionRequest(in)
+	req.Stream = stream != nil
+
+	upstream, err := s.prepareCompletion(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	release, err := s.grpcAcquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	ctx, cancel := withKeyMaxDuration(ctx, keys.FromContext(ctx))
+	defer cancel()
+
+	id := generateID()
+	header := &grpcHeader{header: http.Header{}}
+	ex := s.startExchange(header, grpcRequest(ctx), id, upstream.Model, upstream, req.Stream)
+
+	if stream == nil {
+		completion, err := s.providers.GetCompletion(ctx, upstream)
+		if err != nil {
+			ex.finish("", err)
+			return nil, err
+		}
+		ex.served(upstream.Backend)
+		s.filterCompletion(ex, completion)
+		ex.completion.Write(completion.Text)
+		ex.finish(completion.FinishReason, nil)
+
+		header.send(ctx)
+		return &reaiv1.CompletionResponse{
+			Id:           id,
+			Text:         completion.Text,
+			FinishReason: completion.FinishReason,
+			Usage:        encodeUsage(ex.tokenUsage()),
+		}, nil
+	}
+
+	finishReason, err := s.streamText(ctx, upstream, ex, func(text string, _ *copilot.Logprobs) error {
+		header.send(ctx)
+		return stream.Send(&reaiv1.CompletionChunk{Id: id, Text: text})
+	}, nil, nil)
+	ex.finish(finishReason, err)
+	if err != nil {
+		return nil, err
+	}
+
+	header.send(ctx)
+	return nil, stream.Send(&reaiv1.CompletionChunk{
+		Id:           id,
+		FinishReason: finishReason,
+		Usage:        encodeUsage(ex.tokenUsage()),
+	})
+}
+
+// grpcChat runs a chat completion, streamed to stream when it is set
+func (s *Server) grpcChat(ctx context.Context, in *reaiv1.ChatRequest, stream grpc.ServerStreamingServer[reaiv1.ChatChunk]) (*reaiv1.ChatResponse, error) {
+	req := decodeChatRequest(in)
+	req.Stream = stream != nil
+
+	chat, err := s.prepareChatCompletion(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	release, err := s.grpcAcquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	ctx, cancel := withKeyMaxDuration(ctx, keys.FromContext(ctx))
+	defer cancel()
+
+	id := generateID()
+	header := &grpcHeader{header: http.Header{}}
+	ex := s.startExchange(header, grpcRequest(ctx), id, chat.model, chat.upstream, req.Stream)
+	applyContextTrim(header, ex, chat.trim)
+
+	if stream == nil {
+		completion, err := s.providers.GetCompletion(ctx, chat.upstream)
+		if err != nil {
+			ex.finish("", err)
+			return nil, err
+		}
+		ex.served(chat.upstream.Backend)
+		s.filterCompletion(ex, completion)
+		ex.completion.Write(completion.Text)
+		ex.finish(completion.FinishReason, nil)
+
+		header.send(ctx)
+		return &reaiv1.ChatResponse{
+			Id:           id,
+			Model:        chat.model,
+			Message:      &reaiv1.ChatMessage{Role: "assistant", Content: completion.Text},
+			FinishReason: completion.FinishReason,
+			Usage:        encodeUsage(ex.tokenUsage()),
+		}, nil
+	}
+
+	finishReason, err := s.streamChat(ctx, chat, ex, func(c ChatCompletionChunk) error {
+		choice := c.Choices[0]
+		if choice.FinishReason != nil {
+			// Sent below once the usage is known
+			return nil
+		}
+		header.send(ctx)
+		return stream.Send(&reaiv1.ChatChunk{
+			Id:      id,
+			Model:   chat.model,
+			Role:    choice.Delta.Role,
+			Content: choice.Delta.Content,
+		})
+	})
+	ex.finish(finishReason, err)
+	if err != nil {
+		return nil, err
+	}
+
+	header.send(ctx)
+	return nil, stream.Send(&reaiv1.ChatChunk{
+		Id:           id,
+		Model:        chat.model,
+		FinishReason: finishReason,
+		Usage:        encodeUsage(ex.tokenUsage()),
+	})
+}
+
+// grpcAcquire admits the call through the key limits and the request queue
+func (s *Server) grpcAcquire(ctx context.Context) (func(), error) {
+	if _, apiErr := s.checkQuota(ctx); apiErr != nil {
+		return nil, apiErr
+	}
+	if apiErr := s.shed(priorityOf(ctx)); apiErr != nil {
+		return nil, apiErr
+	}
+	release, err := s.queue.Acquire(ctx, priorityOf(ctx))
+	if err != nil && ctx.Err() == nil {
+		return nil, errors.NewRateLimitError(err.Error())
+	}
+	return release, err
+}
+
+// grpcHeader collects the response headers an exchange sets, which the call
+// sends as header metadata with its first message
+type grpcHeader struct {
+	header http.Header
+	sent   bool
+}
+
+func (h *grpcHeader) Header() http.Header { return h.header }
+
+func (h *grpcHeader) Write([]byte) (int, error) {
+	return 0, stderrors.New("gRPC calls have no response body")
+}
+
+func (h *grpcHeader) WriteHeader(int) {}
+
+// send sets the header metadata of the call before its first message
+func (h *grpcHeader) send(ctx context.Context) {
+	if h.sent {
+		return
+	}
+	h.sent = true
+	md := metadata.MD{}
+	for name, values := range h.header {
+		md.Append(name, values...)
+	}
+	grpc.SetHeader(ctx, md)
+}
+
+func decodeCompletionRequest(in *reaiv1.CompletionRequest) *CompletionRequest {
+	return &CompletionRequest{
+		Prompt:             in.GetPrompt(),
+		Language:           in.GetLanguage(),
+		MaxTokens:          int(in.GetMaxTokens()),
+		Temperature:        in.Temperature,
+		SamplingParameters: SamplingParameters{TopP: in.TopP, PresencePenalty: in.PresencePenalty, FrequencyPenalty: in.FrequencyPenalty},
+	}
+}
+
+func decodeChatRequest(in *reaiv1.ChatRequest) *ChatCompletionRequest {
+	req := &ChatCompletionRequest{
+		Model:              in.GetModel(),
+		MaxTokens:          int(in.GetMaxTokens()),
+		Temperature:        in.Temperature,
+		SamplingParameters: SamplingParameters{TopP: in.TopP, PresencePenalty: in.PresencePenalty, FrequencyPenalty: in.FrequencyPenalty},
+	}
+	for _, message := range in.GetMessages() {
+		req.Messages = append(req.Messages, ChatMessage{Role: message.GetRole(), Content: message.GetContent()})
+	}
+	return req
+}
+
+func encodeModel(model copilot.ModelInfo) *reaiv1.Model {
+	m := &reaiv1.Model{
+		Id:      model.ID,
+		Name:    model.Name,
+		Vendor:  model.Vendor,
+		OwnedBy: model.OwnedBy,
+		Preview: model.Preview,
+	}
+	if caps := model.Capabilities; caps != nil {
+		m.Family = caps.Family
+		if caps.Limits != nil {
+			m.MaxContextWindowTokens = int32(caps.Limits.MaxContextWindowTokens)
+			m.MaxOutputTokens = int32(caps.Limits.MaxOutputTokens)
+		}
+	}
+	return m
+}
+
+func encodeUsage(usage *Usage) *reaiv1.Usage {
+	return &reaiv1.Usage{
+		PromptTokens:     int32(usage.PromptTokens),
+		CompletionTokens: int32(usage.CompletionTokens),
+		TotalTokens:      int32(usage.TotalTokens),
+	}
+}
+
+// grpcUnaryErrors maps the errors of unary calls to gRPC statuses
+func grpcUnaryErrors(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	resp, err := handler(ctx, req)
+	return resp, grpcError(info.FullMethod, err)
+}
+
+// grpcStreamErrors maps the errors of streaming calls to gRPC statuses
+func grpcStreamErrors(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	return grpcError(info.FullMethod, handler(srv, stream))
+}
+
+// grpcError converts the error a call ended with to its status
+func grpcError(method string, err error) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+	code, message := grpcStatusFromError(err)
+	if code != codes.Canceled {
+		slog.Warn("gRPC call failed", "method", strings.TrimPrefix(method, "/reai.v1.ReAI/"), "code", code, "error", message)
+	}
+	return status.Error(code, message)
+}
+
+// grpcStatusFromError maps API errors to gRPC status codes
+func grpcStatusFromError(err error) (codes.Code, string) {
+	switch {
+	case stderrors.Is(err, context.Canceled):
+		return codes.Canceled, "call cancelled"
+	case stderrors.Is(err, context.DeadlineExceeded):
+		return codes.DeadlineExceeded, "deadline exceeded"
+	}
+
+	var apiErr *errors.APIError
+	if !stderrors.As(err, &apiErr) {
+		return codes.Internal, err.Error()
+	}
+	switch apiErr.Code {
+	case http.StatusBadRequest:
+		return codes.InvalidArgument, apiErr.Message
+	case http.StatusUnauthorized:
+		return codes.Unauthenticated, apiErr.Message
+	case http.StatusForbidden:
+		return codes.PermissionDenied, apiErr.Message
+	case http.StatusNotFound:
+		return codes.NotFound, apiErr.Message
+	case http.StatusTooManyRequests:
+		return codes.ResourceExhausted, apiErr.Message
+	case http.StatusBadGateway, http.StatusServiceUnavailable:
+		return codes.Unavailable, apiErr.Message
+	case http.StatusGatewayTimeout:
+		return codes.DeadlineExceeded, apiErr.Message
+	default:
+		return codes.Internal, apiErr.Message
+	}
+}
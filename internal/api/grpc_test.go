@@ -0,0 +1,223 @@
+package api
+
+import (
+	"context"
+	"crypto/x509"
+	"io"
+	"log/slog"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	reaiv1 "github.com/devstroop/reai/api/proto/reai/v1"
+	"github.com/devstroop/reai/internal/config"
+	"github.com/devstroop/reai/internal/copilot"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// newMockServer starts a server on the mock backend, configured by env on
+// top of the test defaults
+func newMockServer(t *testing.T, env map[string]string) *Server {
+	t.Helper()
+	previous := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	t.Cleanup(func() { slog.SetDefault(previous) })
+
+	t.Setenv("DATA_DIR", t.TempDir())
+	t.Setenv("UPSTREAM_MODE", "mock")
+	t.Setenv("MOCK_TOKEN_DELAY", "1ms")
+	t.Setenv("IP_RATE_LIMIT", "0")
+	for name, value := range env {
+		t.Setenv(name, value)
+	}
+	cfg := config.LoadFromEnv()
+	client, err := copilot.NewClient(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err := NewServer(cfg, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { server.Close(context.Background()) })
+	return server
+}
+
+// newGRPCClient serves the gRPC handler over TLS and returns a grpc-go
+// client of it
+func newGRPCClient(t *testing.T, server *Server) reaiv1.ReAIClient {
+	t.Helper()
+	ts := httptest.NewUnstartedServer(server.GRPCHandler())
+	ts.EnableHTTP2 = true
+	ts.StartTLS()
+	t.Cleanup(ts.Close)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ts.Certificate())
+	conn, err := grpc.NewClient(strings.TrimPrefix(ts.URL, "https://"), grpc.WithTransportCredentials(credentials.NewClientTLSFromCert(roots, "")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return reaiv1.NewReAIClient(conn)
+}
+
+// withKey adds the API key to the outgoing metadata
+func withKey(ctx context.Context) context.Context {
+	return metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer test-key")
+}
+
+func TestGRPCUnary(t *testing.T) {
+	client := newGRPCClient(t, newMockServer(t, map[string]string{"API_KEYS": "test-key"}))
+	ctx, cancel := context.WithTimeout(withKey(context.Background()), 10*time.Second)
+	defer cancel()
+
+	models, err := client.ListModels(ctx, &reaiv1.ListModelsRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(models.Models) == 0 || models.Models[0].Id == "" {
+		t.Fatalf("ListModels returned %v", models.Models)
+	}
+
+	var header metadata.MD
+	chat, err := client.Chat(ctx, &reaiv1.ChatRequest{
+		Messages: []*reaiv1.ChatMessage{{Role: "user", Content: "Say hello"}},
+	}, grpc.Header(&header))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if chat.Id == "" || chat.Message.GetRole() != "assistant" || chat.Message.GetContent() == "" {
+		t.Fatalf("Chat returned %v", chat)
+	}
+	if chat.FinishReason == "" || chat.Usage.GetTotalTokens() == 0 {
+		t.Fatalf("Chat returned finish reason %q and usage %v", chat.FinishReason, chat.Usage)
+	}
+	if len(header.Get(backendHeader)) == 0 {
+		t.Errorf("no %s header metadata in %v", backendHeader, header)
+	}
+
+	temperature := 0.2
+	completion, err := client.Complete(ctx, &reaiv1.CompletionRequest{Prompt: "func add(a, b int) int {", Language: "go", Temperature: &temperature})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if completion.Id == "" || completion.Text == "" || completion.Usage.GetTotalTokens() == 0 {
+		t.Fatalf("Complete returned %v", completion)
+	}
+}
+
+func TestGRPCServerStreaming(t *testing.T) {
+	client := newGRPCClient(t, newMockServer(t, map[string]string{"API_KEYS": "test-key"}))
+	ctx, cancel := context.WithTimeout(withKey(context.Background()), 10*time.Second)
+	defer cancel()
+
+	chat, err := client.StreamChat(ctx, &reaiv1.ChatRequest{
+		Messages: []*reaiv1.ChatMessage{{Role: "user", Content: "Count from 1 to 10"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var chunks []*reaiv1.ChatChunk
+	var content strings.Builder
+	for {
+		chunk, err := chat.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		chunks = append(chunks, chunk)
+		content.WriteString(chunk.Content)
+	}
+	if len(chunks) < 3 {
+		t.Fatalf("got %d chunks, want the content in several", len(chunks))
+	}
+	if chunks[0].Role != "assistant" {
+		t.Errorf("first chunk has role %q", chunks[0].Role)
+	}
+	last := chunks[len(chunks)-1]
+	if last.FinishReason == "" || last.Usage.GetTotalTokens() == 0 {
+		t.Errorf("last chunk has finish reason %q and usage %v", last.FinishReason, last.Usage)
+	}
+	if content.Len() == 0 {
+		t.Error("no content streamed")
+	}
+
+	completion, err := client.StreamComplete(ctx, &reaiv1.CompletionRequest{Prompt: "def add(a, b):", Language: "python"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var text strings.Builder
+	var finishReason string
+	for {
+		chunk, err := completion.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		text.WriteString(chunk.Text)
+		finishReason = chunk.FinishReason
+	}
+	if text.Len() == 0 || finishReason == "" {
+		t.Errorf("streamed %q with finish reason %q", text.String(), finishReason)
+	}
+}
+
+func TestGRPCErrorTrailers(t *testing.T) {
+	client := newGRPCClient(t, newMockServer(t, map[string]string{"API_KEYS": "test-key"}))
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	valid := &reaiv1.ChatRequest{Messages: []*reaiv1.ChatMessage{{Role: "user", Content: "hi"}}}
+
+	tests := []struct {
+		name string
+		call func() error
+		want codes.Code
+	}{
+		{"missing key", func() error {
+			_, err := client.Chat(ctx, valid)
+			return err
+		}, codes.Unauthenticated},
+		{"wrong key", func() error {
+			_, err := client.Chat(metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer nope"), valid)
+			return err
+		}, codes.Unauthenticated},
+		{"no messages", func() error {
+			_, err := client.Chat(withKey(ctx), &reaiv1.ChatRequest{})
+			return err
+		}, codes.InvalidArgument},
+		{"streamed, no messages", func() error {
+			stream, err := client.StreamChat(withKey(ctx), &reaiv1.ChatRequest{})
+			if err != nil {
+				return err
+			}
+			_, err = stream.Recv()
+			return err
+		}, codes.InvalidArgument},
+		{"out of range", func() error {
+			topP := 2.0
+			_, err := client.Complete(withKey(ctx), &reaiv1.CompletionRequest{Prompt: "x", TopP: &topP})
+			return err
+		}, codes.InvalidArgument},
+	}
+	for _, tt := range tests {
+		err := tt.call()
+		st, ok := status.FromError(err)
+		if !ok || st.Code() != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.name, err, tt.want)
+			continue
+		}
+		if st.Message() == "" {
+			t.Errorf("%s: status without message", tt.name)
+		}
+	}
+}
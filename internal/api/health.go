@@ -0,0 +1,121 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// Health check statuses
+const (
+	checkOK      = "ok"
+	checkFail    = "fail"
+	checkSkipped = "skipped"
+)
+
+// HealthCheck is the result of one readiness check
+type HealthCheck struct {
+	Name       string  `json:"name"`
+	Status     string  `json:"status"`
+	Detail     string  `json:"detail,omitempty"`
+	DurationMS float64 `json:"duration_ms"`
+}
+
+// handleLive reports that the process is up and serving HTTP. It does not
+// look at the backend, so a broken login never gets the pod restarted.
+func (s *Server) handleLive(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":    checkOK,
+		"timestamp": time.Now().Unix(),
+	})
+}
+
+// handleReady reports whether requests can be served: the session token is
+// valid or can be refreshed without user interaction and, with
+// HEALTH_CHECK_UPSTREAM, the Copilot API accepts it. With load shedding the
+// load check reports what is shed, and with AUDIT_LOG the audit check whether
+// records are written. Any failed check makes the response a 503.
+func (s *Server) handleReady(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var checks []HealthCheck
+	switch {
+	case s.Draining():
+		// Take the instance out of rotation without probing anything
+		checks = []HealthCheck{{Name: "shutdown", Status: checkFail, Detail: "draining in-flight requests"}}
+	case s.config.Offline():
+		detail := "UPSTREAM_MODE is " + s.config.UpstreamMode
+		checks = []HealthCheck{
+			{Name: "auth", Status: checkSkipped, Detail: detail},
+			{Name: "upstream", Status: checkSkipped, Detail: detail},
+		}
+	default:
+		checks = []HealthCheck{s.runCheck(r.Context(), "auth", s.copilotClient.CheckAuth)}
+		if !s.config.HealthCheckUpstream {
+			checks = append(checks, HealthCheck{Name: "upstream", Status: checkSkipped, Detail: "HEALTH_CHECK_UPSTREAM is off"})
+		} else if checks[0].Status != checkOK {
+			checks = append(checks, HealthCheck{Name: "upstream", Status: checkSkipped, Detail: "not authenticated"})
+		} else {
+			checks = append(checks, s.runCheck(r.Context(), "upstream", s.copilotClient.Ping))
+		}
+	}
+
+	if s.shedder != nil && !s.Draining() {
+		checks = append(checks, s.shedder.check(time.Now()))
+	}
+	if s.audit != nil && !s.Draining() {
+		checks = append(checks, s.auditCheck())
+	}
+
+	status, code := checkOK, http.StatusOK
+	for _, check := range checks {
+		if check.Status == checkFail {
+			status, code = checkFail, http.StatusServiceUnavailable
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":    status,
+		"timestamp": time.Now().Unix(),
+		"checks":    checks,
+	})
+}
+
+// auditCheck fails while audit records cannot be written, so an instance
+// serving without an audit trail leaves rotation
+func (s *Server) auditCheck() HealthCheck {
+	if err := s.audit.Err(); err != nil {
+		return HealthCheck{Name: "audit", Status: checkFail, Detail: "audit log write failed: " + err.Error()}
+	}
+	return HealthCheck{Name: "audit", Status: checkOK}
+}
+
+// runCheck runs one check bounded by HEALTH_CHECK_TIMEOUT
+func (s *Server) runCheck(ctx context.Context, name string, check func(context.Context) error) HealthCheck {
+	if s.config.HealthCheckTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.config.HealthCheckTimeout)
+		defer cancel()
+	}
+
+	start := time.Now()
+	err := check(ctx)
+	result := HealthCheck{Name: name, Status: checkOK, DurationMS: float64(time.Since(start).Microseconds()) / 1000}
+	if err != nil {
+		result.Status, result.Detail = checkFail, err.Error()
+	}
+	return result
+}
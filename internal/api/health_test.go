@@ -0,0 +1,62 @@
+package api
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"regexp"
+	"testing"
+)
+
+func TestReadyAuditCheck(t *testing.T) {
+	// Writes to /dev/full fail like on a full disk
+	if _, err := os.Stat("/dev/full"); err != nil {
+		t.Skip("no /dev/full:", err)
+	}
+	ts := httptest.NewServer(newMockServer(t, map[string]string{"AUDIT_LOG": "/dev/full"}).Router())
+	t.Cleanup(ts.Close)
+
+	ready := func() (int, map[string]HealthCheck) {
+		t.Helper()
+		resp, err := http.Get(ts.URL + "/health/ready")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var body struct {
+			Checks []HealthCheck `json:"checks"`
+		}
+		json.NewDecoder(resp.Body).Decode(&body)
+		checks := make(map[string]HealthCheck)
+		for _, check := range body.Checks {
+			checks[check.Name] = check
+		}
+		return resp.StatusCode, checks
+	}
+
+	if status, checks := ready(); status != http.StatusOK || checks["audit"].Status != checkOK {
+		t.Fatalf("before any record: %d %+v", status, checks)
+	}
+
+	var completion map[string]interface{}
+	postJSON(t, ts.URL+"/v1/chat/completions", map[string]interface{}{
+		"model":    "gpt-4o",
+		"messages": []map[string]string{{"role": "user", "content": "hello"}},
+	}, &completion)
+	eventually(t, "the audit check to fail", func() bool {
+		status, checks := ready()
+		return status == http.StatusServiceUnavailable && checks["audit"].Status == checkFail
+	})
+
+	resp, err := http.Get(ts.URL + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	metrics, _ := io.ReadAll(resp.Body)
+	if !regexp.MustCompile(`(?m)^reai_audit_write_errors_total [1-9]`).Match(metrics) {
+		t.Error("write error not counted")
+	}
+}
@@ -0,0 +1,288 @@
+package api
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/json"
+	stderrors "errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/devstroop/reai/internal/keys"
+	"github.com/devstroop/reai/internal/store"
+	"github.com/devstroop/reai/pkg/errors"
+)
+
+const (
+	idempotencyHeader = "Idempotency-Key"
+	// replayedHeader marks a response served from the idempotency store
+	replayedHeader = "Idempotent-Replayed"
+	// maxIdempotencyKeyLength bounds the header value
+	maxIdempotencyKeyLength = 255
+	// maxIdempotentEntries bounds the store; requests beyond it are served
+	// without being stored
+	maxIdempotentEntries = 10000
+	// maxIdempotentBodyBytes bounds a stored response, streams included
+	maxIdempotentBodyBytes = 1 << 20
+	// idempotencySweepInterval bounds how long expired responses linger
+	idempotencySweepInterval = time.Hour
+)
+
+// idempotencyStore keeps the responses of requests sent with an
+// Idempotency-Key for a while, so a resent request gets the same response
+// instead of running (and being charged) again. Requests in flight are
+// tracked in memory; stored responses are also saved to the database, so
+// they are replayed after a restart.
+type idempotencyStore struct {
+	ttl     time.Duration
+	db      *store.DB
+	mutex   sync.Mutex
+	entries map[string]*idempotentEntry
+	swept   time.Time
+}
+
+// idempotentEntry is a request in flight (done open) or its stored response
+type idempotentEntry struct {
+	// fingerprint identifies the request the key was first used with
+	fingerprint [sha256.Size]byte
+	done        chan struct{}
+	status      int
+	header      http.Header
+	body        []byte
+	expires     time.Time
+}
+
+// newIdempotencyStore returns a store keeping responses for ttl, nil when
+// ttl is 0 and idempotency keys are ignored. A nil db keeps them in memory.
+func newIdempotencyStore(ttl time.Duration, db *store.DB) *idempotencyStore {
+	if ttl <= 0 {
+		return nil
+	}
+	return &idempotencyStore{ttl: ttl, db: db, entries: make(map[string]*idempotentEntry)}
+}
+
+// reserve returns the entry stored under key, or reserves key for a new
+// request and returns nil. ok is false when the store is full.
+func (st *idempotencyStore) reserve(key string, fingerprint [sha256.Size]byte, now time.Time) (entry *idempotentEntry, ok bool) {
+	st.mutex.Lock()
+	defer st.mutex.Unlock()
+
+	if now.Sub(st.swept) >= idempotencySweepInterval {
+		st.sweep(now)
+	}
+	if entry, found := st.entries[key]; found {
+		if !entry.isDone() || now.Before(entry.expires) {
+			return entry, true
+		}
+		delete(st.entries, key)
+	} else if entry := st.load(key, now); entry != nil {
+		st.entries[key] = entry
+		return entry, true
+	}
+	if len(st.entries) >= maxIdempotentEntries {
+		st.sweep(now)
+		if len(st.entries) >= maxIdempotentEntries {
+			return nil, false
+		}
+	}
+	st.entries[key] = &idempotentEntry{fingerprint: fingerprint, done: make(chan struct{})}
+	return nil, true
+}
+
+// load returns the unexpired response saved in the database under key, nil
+// when there is none. The caller holds the mutex.
+func (st *idempotencyStore) load(key string, now time.Time) *idempotentEntry {
+	if st.db == nil {
+		return nil
+	}
+	var fingerprint, header []byte
+	var expires int64
+	entry := &idempotentEntry{done: make(chan struct{})}
+	err := st.db.QueryRow("SELECT fingerprint, status, header, body, expires FROM idempotent_responses WHERE key = ? AND expires > ?", key, now.Unix()).
+		Scan(&fingerprint, &entry.status, &header, &entry.body, &expires)
+	if err != nil {
+		if !stderrors.Is(err, sql.ErrNoRows) {
+			slog.Warn("Idempotent response not loaded", "error", err)
+		}
+		return nil
+	}
+	if err := json.Unmarshal(header, &entry.header); err != nil || len(fingerprint) != sha256.Size {
+		slog.Warn("Idempotent response not loaded", "error", "invalid record")
+		return nil
+	}
+	copy(entry.fingerprint[:], fingerprint)
+	entry.expires = time.Unix(expires, 0)
+	close(entry.done)
+	return entry
+}
+
+// save writes a stored response to the database. The caller holds the mutex.
+func (st *idempotencyStore) save(key string, entry *idempotentEntry) {
+	if st.db == nil {
+		return
+	}
+	header, err := json.Marshal(entry.header)
+	if err == nil {
+		_, err = st.db.Exec("INSERT OR REPLACE INTO idempotent_responses (key, fingerprint, status, header, body, expires) VALUES (?, ?, ?, ?, ?, ?)",
+			key, entry.fingerprint[:], entry.status, string(header), entry.body, entry.expires.Unix())
+	}
+	if err != nil {
+		slog.Warn("Idempotent response not saved", "error", err)
+	}
+}
+
+// sweep forgets expired responses. The caller holds the mutex.
+func (st *idempotencyStore) sweep(now time.Time) {
+	st.swept = now
+	for key, entry := range st.entries {
+		if entry.isDone() && !now.Before(entry.expires) {
+			delete(st.entries, key)
+		}
+	}
+	if st.db != nil {
+		if _, err := st.db.Exec("DELETE FROM idempotent_responses WHERE expires <= ?", now.Unix()); err != nil {
+			slog.Warn("Expired idempotent responses not deleted", "error", err)
+		}
+	}
+}
+
+// complete stores the response of the request that reserved key, or
+// releases key when the response is not to be kept
+func (st *idempotencyStore) complete(key string, rec *idempotencyRecorder, keep bool, now time.Time) {
+	st.mutex.Lock()
+	defer st.mutex.Unlock()
+
+	entry := st.entries[key]
+	if keep {
+		entry.status, entry.header, entry.body = rec.status, rec.header, rec.body.Bytes()
+		entry.expires = now.Add(st.ttl)
+		st.save(key, entry)
+	} else {
+		delete(st.entries, key)
+	}
+	close(entry.done)
+}
+
+func (e *idempotentEntry) isDone() bool {
+	select {
+	case <-e.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// idempotencyMiddleware replays the stored response of a request resent with
+// the same Idempotency-Key. Keys are scoped to the caller's API key. A key
+// reused for a different request is rejected, as is a resend while the first
+// request is still running. Failures worth retrying (429 and 5xx) and
+// responses cut short are not stored.
+func (s *Server) idempotencyMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		value := r.Header.Get(idempotencyHeader)
+		if s.idempotency == nil || value == "" || r.Method != http.MethodPost {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if len(value) > maxIdempotencyKeyLength {
+			errors.WriteErrorResponse(w, errors.NewInvalidRequestError("Idempotency-Key must be at most 255 characters"))
+			return
+		}
+
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			errors.WriteErrorResponse(w, bodyReadError(err))
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		key := value
+		if apiKey := keys.FromContext(r.Context()); apiKey != nil {
+			key = apiKey.ID + "\x00" + value
+		}
+		fingerprint := sha256.Sum256([]byte(r.URL.Path + "\x00" + string(body)))
+
+		entry, ok := s.idempotency.reserve(key, fingerprint, time.Now())
+		switch {
+		case !ok:
+			// Store full: serve the request without the guarantee
+			next.ServeHTTP(w, r)
+			return
+		case entry == nil:
+		case entry.fingerprint != fingerprint:
+			errors.WriteErrorResponse(w, &errors.APIError{Type: "invalid_request_error", Message: "Idempotency-Key was already used with a different request", Code: http.StatusUnprocessableEntity})
+			return
+		case !entry.isDone():
+			errors.WriteErrorResponse(w, &errors.APIError{Type: "conflict", Message: "A request with this Idempotency-Key is still in progress", Code: http.StatusConflict})
+			return
+		default:
+			for name, values := range entry.header {
+				w.Header()[name] = values
+			}
+			w.Header().Set(replayedHeader, "true")
+			w.WriteHeader(entry.status)
+			w.Write(entry.body)
+			return
+		}
+
+		rec := &idempotencyRecorder{ResponseWriter: w, status: http.StatusOK}
+		defer func() {
+			keep := rec.status < 500 && rec.status != http.StatusTooManyRequests &&
+				!rec.overflow && r.Context().Err() == nil
+			if rec.header == nil {
+				rec.header = w.Header().Clone()
+			}
+			s.idempotency.complete(key, rec, keep, time.Now())
+		}()
+		next.ServeHTTP(rec, r)
+	})
+}
+
+// idempotencyRecorder passes a response on while keeping a copy of it
+type idempotencyRecorder struct {
+	http.ResponseWriter
+	status int
+	// header is the response header as sent
+	header   http.Header
+	body     bytes.Buffer
+	overflow bool
+}
+
+func (rec *idempotencyRecorder) WriteHeader(code int) {
+	if rec.header == nil {
+		rec.status = code
+		rec.header = rec.ResponseWriter.Header().Clone()
+	}
+	rec.ResponseWriter.WriteHeader(code)
+}
+
+func (rec *idempotencyRecorder) Write(p []byte) (int, error) {
+	if rec.header == nil {
+		rec.WriteHeader(http.StatusOK)
+	}
+	if !rec.overflow {
+		if rec.body.Len()+len(p) > maxIdempotentBodyBytes {
+			rec.overflow = true
+			rec.body.Reset()
+		} else {
+			rec.body.Write(p)
+		}
+	}
+	return rec.ResponseWriter.Write(p)
+}
+
+// Flush lets streamed responses through the recorder
+func (rec *idempotencyRecorder) Flush() {
+	if flusher, ok := rec.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap exposes the underlying writer to http.ResponseController
+func (rec *idempotencyRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
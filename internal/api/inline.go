@@ -0,0 +1,217 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/devstroop/reai/internal/lsp"
+	"github.com/devstroop/reai/pkg/errors"
+)
+
+// Inline completion limits: a completion in the middle of a line ends with
+// the line, one at the end of a line may fill a block
+const (
+	inlineLineMaxTokens  = 100
+	inlineBlockMaxTokens = 500
+)
+
+// inlineRequest is the getCompletions request of the Copilot editor plugins
+// (copilot.vim, copilot.lua). It is accepted in its JSON-RPC envelope, as the
+// plugins send it to their agent, or as the bare params.
+type inlineRequest struct {
+	JSONRPC string          `json:"jsonrpc,omitempty"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method,omitempty"`
+	Params  *inlineParams   `json:"params,omitempty"`
+	inlineParams
+}
+
+type inlineParams struct {
+	Doc inlineDocument `json:"doc"`
+}
+
+// inlineDocument is the document being edited and the cursor position in it
+type inlineDocument struct {
+	Source       string         `json:"source"`
+	URI          string         `json:"uri,omitempty"`
+	Path         string         `json:"path,omitempty"`
+	RelativePath string         `json:"relativePath,omitempty"`
+	LanguageID   string         `json:"languageId,omitempty"`
+	Position     inlinePosition `json:"position"`
+	Version      int            `json:"version,omitempty"`
+}
+
+// inlinePosition is an LSP position: a zero-based line and a character
+// offset in UTF-16 code units
+type inlinePosition struct {
+	Line      int `json:"line"`
+	Character int `json:"character"`
+}
+
+type inlineRange struct {
+	Start inlinePosition `json:"start"`
+	End   inlinePosition `json:"end"`
+}
+
+// inlineCompletion is a ghost text suggestion. Text replaces Range, which
+// runs from the start of the line to the cursor; DisplayText is what the
+// editor shows after the cursor.
+type inlineCompletion struct {
+	UUID        string         `json:"uuid"`
+	Text        string         `json:"text"`
+	DisplayText string         `json:"displayText"`
+	Position    inlinePosition `json:"position"`
+	Range       inlineRange    `json:"range"`
+	DocVersion  int            `json:"docVersion"`
+}
+
+type inlineResult struct {
+	Completions []inlineCompletion `json:"completions"`
+}
+
+// handleInlineCompletions serves the ghost text completions of the Copilot
+// editor plugins, so they can use ReAI in place of the Copilot agent
+func (s *Server) handleInlineCompletions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req inlineRequest
+	if err := s.decodeJSON(r, &req); err != nil {
+		writeError(w, err)
+		return
+	}
+	params := req.Params
+	if params == nil {
+		params = &req.inlineParams
+	}
+
+	var result *inlineResult
+	var err error
+	// rpcCode is the JSON-RPC error code, derived from err when 0
+	rpcCode := 0
+	switch req.Method {
+	case "", "getCompletions", "getCompletionsCycling":
+		result, err = s.inlineCompletions(w, r, &params.Doc)
+	default:
+		err, rpcCode = errors.NewValidationError("unsupported method: "+req.Method), -32601
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if req.JSONRPC == "" {
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		json.NewEncoder(w).Encode(result)
+		return
+	}
+
+	// JSON-RPC callers get errors in the envelope
+	response := map[string]interface{}{"jsonrpc": "2.0", "id": req.ID}
+	if err != nil {
+		apiErr := errors.WrapError(err)
+		switch {
+		case rpcCode != 0:
+		case apiErr.Code == http.StatusBadRequest:
+			rpcCode = -32602
+		default:
+			rpcCode = -32603
+		}
+		response["error"] = map[string]interface{}{"code": rpcCode, "message": apiErr.Message, "data": map[string]string{"type": apiErr.Type}}
+	} else {
+		response["result"] = result
+	}
+	json.NewEncoder(w).Encode(response)
+}
+
+// inlineCompletions completes doc at its cursor
+func (s *Server) inlineCompletions(w http.ResponseWriter, r *http.Request, doc *inlineDocument) (*inlineResult, error) {
+	result := &inlineResult{Completions: []inlineCompletion{}}
+	prefix, suffix, err := splitAtPosition(doc.Source, doc.Position)
+	if err != nil {
+		return nil, err
+	}
+	if strings.TrimSpace(prefix) == "" {
+		// Nothing to go on yet
+		return result, nil
+	}
+
+	linePrefix := prefix[strings.LastIndex(prefix, "\n")+1:]
+	multiline := lsp.Multiline(suffix)
+
+	req := CompletionRequest{
+		Prompt:    prefix,
+		Language:  doc.LanguageID,
+		MaxTokens: inlineLineMaxTokens,
+		FilePath:  doc.filePath(),
+	}
+	if multiline {
+		req.MaxTokens = inlineBlockMaxTokens
+	}
+	copilotReq, err := s.prepareCompletion(r.Context(), &req)
+	if err != nil {
+		return nil, err
+	}
+	if copilotReq.Suffix, err = s.filterPrompt(suffix); err != nil {
+		return nil, err
+	}
+	if multiline {
+		// A blank line ends the block
+		copilotReq.Stop = []string{"\n\n"}
+	}
+
+	id := generateID()
+	ex := s.startExchange(w, r, id, copilotReq.Model, copilotReq, false)
+	completion, err := s.providers.GetCompletion(r.Context(), copilotReq)
+	if err != nil {
+		ex.finish("", err)
+		return nil, err
+	}
+	ex.served(copilotReq.Backend)
+	s.filterCompletion(ex, completion)
+	ex.completion.Write(completion.Text)
+	ex.finish(completion.FinishReason, nil)
+
+	text := strings.TrimRight(completion.Text, " \t\r\n")
+	if strings.TrimSpace(text) == "" {
+		return result, nil
+	}
+	lineStart := inlinePosition{Line: doc.Position.Line}
+	result.Completions = append(result.Completions, inlineCompletion{
+		UUID:        id,
+		Text:        linePrefix + text,
+		DisplayText: text,
+		Position:    doc.Position,
+		Range:       inlineRange{Start: lineStart, End: doc.Position},
+		DocVersion:  doc.Version,
+	})
+	return result, nil
+}
+
+// filePath is the path Copilot is told the document has
+func (d *inlineDocument) filePath() string {
+	switch {
+	case d.RelativePath != "":
+		return d.RelativePath
+	case d.Path != "":
+		return d.Path
+	}
+	if u, err := url.Parse(d.URI); err == nil && u.Scheme == "file" {
+		return path.Clean(u.Path)
+	}
+	return ""
+}
+
+// splitAtPosition splits source at an LSP position
+func splitAtPosition(source string, pos inlinePosition) (string, string, error) {
+	prefix, suffix, err := lsp.Split(source, lsp.Position(pos))
+	if err != nil {
+		return "", "", errors.NewValidationError("doc.position: " + err.Error())
+	}
+	return prefix, suffix, nil
+}
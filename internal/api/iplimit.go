@@ -0,0 +1,116 @@
+package api
+
+import (
+	"fmt"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/devstroop/reai/internal/metrics"
+	"github.com/devstroop/reai/pkg/errors"
+)
+
+// ipLimiterSweep is how often buckets that refilled completely are forgotten
+const ipLimiterSweep = time.Minute
+
+var ipRateLimited = metrics.NewCounter("reai_ip_rate_limited_total", "Requests rejected by the per source IP rate limit")
+
+// ipLimiter is a token bucket per client IP: each IP may send burst requests
+// at once and then rate requests per minute
+type ipLimiter struct {
+	rate  float64 // tokens per second
+	burst float64
+
+	mutex     sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// newIPLimiter returns nil when perMinute is not positive
+func newIPLimiter(perMinute, burst int) *ipLimiter {
+	if perMinute <= 0 {
+		return nil
+	}
+	return &ipLimiter{
+		rate:      float64(perMinute) / 60,
+		burst:     float64(max(burst, 1)),
+		buckets:   make(map[string]*tokenBucket),
+		lastSweep: time.Now(),
+	}
+}
+
+// allow takes a token from the bucket of ip. When it is empty it returns how
+// long until the next token.
+func (l *ipLimiter) allow(ip string, now time.Time) (bool, time.Duration) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if now.Sub(l.lastSweep) >= ipLimiterSweep {
+		l.sweep(now)
+	}
+
+	bucket, ok := l.buckets[ip]
+	if !ok {
+		bucket = &tokenBucket{tokens: l.burst, updated: now}
+		l.buckets[ip] = bucket
+	}
+	bucket.tokens = l.refill(bucket, now)
+	bucket.updated = now
+	if bucket.tokens < 1 {
+		return false, time.Duration((1 - bucket.tokens) / l.rate * float64(time.Second))
+	}
+	bucket.tokens--
+	return true, 0
+}
+
+// refill returns the tokens of bucket at now
+func (l *ipLimiter) refill(bucket *tokenBucket, now time.Time) float64 {
+	return math.Min(l.burst, bucket.tokens+now.Sub(bucket.updated).Seconds()*l.rate)
+}
+
+// sweep forgets full buckets, which behave like new ones. The caller holds
+// the mutex.
+func (l *ipLimiter) sweep(now time.Time) {
+	for ip, bucket := range l.buckets {
+		if l.refill(bucket, now) >= l.burst {
+			delete(l.buckets, ip)
+		}
+	}
+	l.lastSweep = now
+}
+
+// ipLimitMiddleware rate limits each client IP while no API keys are
+// configured, so one busy client can't starve the others. With keys, the
+// per key quotas apply instead.
+func (s *Server) ipLimitMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.ipLimiter == nil || s.keys.Load().Enabled() {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ip := clientIP(r)
+		if net.ParseIP(ip) == nil {
+			// Unix socket clients are local
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ok, wait := s.ipLimiter.allow(ip, time.Now())
+		if !ok {
+			ipRateLimited.Inc()
+			slog.Debug("Request rejected by the IP rate limit", "path", r.URL.Path, "remote_addr", r.RemoteAddr)
+			w.Header().Set("Retry-After", fmt.Sprintf("%d", max(1, int(math.Ceil(wait.Seconds())))))
+			errors.WriteErrorResponse(w, errors.NewRateLimitError("too many requests from this address"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
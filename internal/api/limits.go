@@ -0,0 +1,246 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/devstroop/reai/internal/keys"
+	"github.com/devstroop/reai/internal/queue"
+	"github.com/devstroop/reai/internal/quota"
+	"github.com/devstroop/reai/internal/webhook"
+	"github.com/devstroop/reai/pkg/errors"
+)
+
+// LimitsResponse is the caller's headroom returned by GET /v1/limits
+type LimitsResponse struct {
+	Object string `json:"object"`
+	KeyID  string `json:"key_id,omitempty"`
+	// Requests and Tokens are the per-key limits, null when unlimited
+	Requests *quota.Headroom `json:"requests"`
+	Tokens   *quota.Headroom `json:"tokens"`
+	// Rolling day and month quotas, left out when unlimited
+	DayRequests   *quota.Headroom     `json:"day_requests,omitempty"`
+	DayTokens     *quota.Headroom     `json:"day_tokens,omitempty"`
+	MonthRequests *quota.Headroom     `json:"month_requests,omitempty"`
+	MonthTokens   *quota.Headroom     `json:"month_tokens,omitempty"`
+	Concurrency   ConcurrencyHeadroom `json:"concurrency"`
+	// MaxTokens and MaxDuration are the guardrails of the key, left out
+	// when not set
+	MaxTokens   int    `json:"max_tokens,omitempty"`
+	MaxDuration string `json:"max_duration,omitempty"`
+}
+
+// ConcurrencyHeadroom is the server wide concurrency limit shared by all callers
+type ConcurrencyHeadroom struct {
+	// Limit is 0 when concurrency is not limited
+	Limit      int `json:"limit"`
+	InFlight   int `json:"in_flight"`
+	Available  int `json:"available"`
+	QueueLimit int `json:"queue_limit"`
+	Queued     int `json:"queued"`
+	// Priority is the caller's queue class and PriorityLimit the slots it may hold
+	Priority      queue.Priority `json:"priority"`
+	PriorityLimit int            `json:"priority_limit"`
+}
+
+// handleLimits reports the caller's rate limit headroom so clients can pace
+// themselves instead of waiting for a 429
+func (s *Server) handleLimits(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	opts := s.queue.Options()
+	priority := priorityOf(r.Context())
+	response := LimitsResponse{
+		Object: "limits",
+		Concurrency: ConcurrencyHeadroom{
+			Limit:         max(opts.MaxConcurrent, 0),
+			InFlight:      s.queue.InFlight(),
+			QueueLimit:    opts.MaxDepth,
+			Queued:        s.queue.Depth(),
+			Priority:      priority,
+			PriorityLimit: s.queue.Share(priority),
+		},
+	}
+	if opts.MaxConcurrent > 0 {
+		response.Concurrency.Available = max(opts.MaxConcurrent-response.Concurrency.InFlight, 0)
+	}
+	if key := keys.FromContext(r.Context()); key != nil {
+		response.KeyID = key.ID
+		response.MaxTokens, response.MaxDuration = key.MaxTokens, key.MaxDuration
+		if key.Limits != nil {
+			status := s.quota.Status(key.ID, *key.Limits, time.Now())
+			response.Requests, response.Tokens = status.Requests, status.Tokens
+			response.DayRequests, response.DayTokens = status.DayRequests, status.DayTokens
+			response.MonthRequests, response.MonthTokens = status.MonthRequests, status.MonthTokens
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// UsageResponse is the caller's usage returned by GET /v1/usage
+type UsageResponse struct {
+	Object string `json:"object"`
+	KeyID  string `json:"key_id"`
+	quota.Usage
+	// Currency of the cost estimates, omitted while no price is configured
+	Currency string `json:"currency,omitempty"`
+}
+
+// handleUsage reports the rolling day and month usage of the caller's key,
+// with its estimated cost
+func (s *Server) handleUsage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	key := keys.FromContext(r.Context())
+	if key == nil {
+		errors.WriteErrorResponse(w, &errors.APIError{Type: "not_found", Message: "Usage is only tracked for API keys, and none are configured", Code: http.StatusNotFound})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(UsageResponse{
+		Object:   "usage",
+		KeyID:    key.ID,
+		Usage:    s.quota.Usage(key.ID, time.Now()),
+		Currency: s.pricing.Currency(),
+	})
+}
+
+// quotaMiddleware enforces the per-key request and token limits and reports
+// the remaining headroom in X-RateLimit-* response headers
+func (s *Server) quotaMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		status, err := s.checkQuota(r.Context())
+		if status != nil {
+			setRateLimitHeaders(w.Header(), status)
+		}
+		if err != nil {
+			if retry := retryAfter(status); retry > 0 {
+				w.Header().Set("Retry-After", strconv.Itoa(retry))
+			}
+			errors.WriteErrorResponse(w, err)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// checkQuota counts a request against the caller's key limits. It returns the
+// headroom (nil when the key has no limits) and a rate limit error once a
+// per-minute limit, or a quota_exceeded error once a day or month quota, is
+// used up. Requests of keys without limits are counted too, for the admin
+// usage report.
+func (s *Server) checkQuota(ctx context.Context) (*quota.Status, *errors.APIError) {
+	return s.checkKeyQuota(ctx, true)
+}
+
+// checkBatchQuota counts a batch request against the day and month quotas
+// of the key that created the batch. Batches skip the per-minute limits.
+func (s *Server) checkBatchQuota(ctx context.Context) *errors.APIError {
+	_, err := s.checkKeyQuota(ctx, false)
+	return err
+}
+
+// checkKeyQuota is checkQuota, leaving out the per-minute limits unless
+// perMinute is set
+func (s *Server) checkKeyQuota(ctx context.Context, perMinute bool) (*quota.Status, *errors.APIError) {
+	key := keys.FromContext(ctx)
+	if key == nil {
+		return nil, nil
+	}
+	var limits quota.Limits
+	if key.Limits != nil {
+		limits = *key.Limits
+	}
+	if !perMinute {
+		limits.RequestsPerMinute, limits.TokensPerMinute = 0, 0
+	}
+	if !limits.Enabled() {
+		s.quota.Allow(key.ID, quota.Limits{}, time.Now())
+		return nil, nil
+	}
+
+	status, ok := s.quota.Allow(key.ID, limits, time.Now())
+	if ok {
+		return &status, nil
+	}
+
+	// The longest exhausted window is reported, since it is the one the
+	// client has to wait for
+	for _, exhausted := range []struct {
+		headroom *quota.Headroom
+		limit    string
+	}{
+		{status.MonthTokens, "monthly token quota"},
+		{status.MonthRequests, "monthly request quota"},
+		{status.DayTokens, "daily token quota"},
+		{status.DayRequests, "daily request quota"},
+	} {
+		if exhausted.headroom != nil && exhausted.headroom.Remaining == 0 {
+			slog.Warn("Request rejected by key quota", "key_id", key.ID, "limit", exhausted.limit)
+			s.webhooks.Publish(webhook.EventQuotaExceeded, key.ID+"\x00"+exhausted.limit, map[string]interface{}{
+				"key_id":   key.ID,
+				"limit":    exhausted.limit,
+				"reset_at": exhausted.headroom.ResetAt,
+			})
+			return &status, errors.NewQuotaExceededError(fmt.Sprintf("You exceeded the %s of this API key; it frees up at %s",
+				exhausted.limit, exhausted.headroom.ResetAt.Format(time.RFC3339)))
+		}
+	}
+
+	limit := "requests per minute"
+	if status.Tokens != nil && status.Tokens.Remaining == 0 {
+		limit = "tokens per minute"
+	}
+	slog.Warn("Request rejected by key limits", "key_id", key.ID, "limit", limit)
+	return &status, errors.NewRateLimitError(fmt.Sprintf("%s for this API key used up", limit))
+}
+
+// chargeTokens counts the tokens of a finished request, and their estimated
+// cost, against its key
+func (s *Server) chargeTokens(ctx context.Context, tokens int, cost float64) {
+	if key := keys.FromContext(ctx); key != nil {
+		s.quota.AddTokens(key.ID, tokens, cost, time.Now())
+	}
+}
+
+// setRateLimitHeaders sets the OpenAI style X-RateLimit-* headers
+func setRateLimitHeaders(h http.Header, status *quota.Status) {
+	for name, headroom := range map[string]*quota.Headroom{"requests": status.Requests, "tokens": status.Tokens} {
+		if headroom == nil {
+			continue
+		}
+		h.Set("X-RateLimit-Limit-"+name, strconv.Itoa(headroom.Limit))
+		h.Set("X-RateLimit-Remaining-"+name, strconv.Itoa(headroom.Remaining))
+		h.Set("X-RateLimit-Reset-"+name, fmt.Sprintf("%.0fs", max(time.Until(headroom.ResetAt).Seconds(), 0)))
+	}
+}
+
+// retryAfter returns the seconds until the exhausted limit resets
+func retryAfter(status *quota.Status) int {
+	if status == nil {
+		return 0
+	}
+	var reset time.Time
+	for _, headroom := range []*quota.Headroom{status.Requests, status.Tokens, status.DayRequests, status.DayTokens, status.MonthRequests, status.MonthTokens} {
+		if headroom != nil && headroom.Remaining == 0 && headroom.ResetAt.After(reset) {
+			reset = headroom.ResetAt
+		}
+	}
+	if reset.IsZero() {
+		return 0
+	}
+	return max(1, int(time.Until(reset).Seconds()+0.5))
+}
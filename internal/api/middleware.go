@@ -1,9 +1,21 @@
 package api
 
 import (
+	"context"
+	stderrors "errors"
+	"fmt"
 	"log/slog"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
+
+	"github.com/devstroop/reai/internal/copilot"
+	"github.com/devstroop/reai/internal/keys"
+	"github.com/devstroop/reai/internal/logging"
+	"github.com/devstroop/reai/internal/metrics"
+	"github.com/devstroop/reai/internal/queue"
+	"github.com/devstroop/reai/pkg/errors"
 )
 
 // loggingMiddleware logs HTTP requests
@@ -34,7 +46,8 @@ func (s *Server) corsMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Access-Control-Allow-Origin", "*")
 		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		w.Header().Set("Access-Control-Expose-Headers", "X-ReAI-Warning, X-ReAI-Watermark, X-ReAI-Experiment, Retry-After, X-ReAI-Upstream-RateLimit-Limit, X-ReAI-Upstream-RateLimit-Remaining, X-ReAI-Upstream-RateLimit-Reset, Idempotent-Replayed")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, Api-Key, X-Request-Deadline, X-Request-Max-Age, X-Request-Start, X-ReAI-Editor, X-ReAI-Debug, X-ReAI-Admin-Token, Idempotency-Key")
 		
 		if r.Method == "OPTIONS" {
 			w.WriteHeader(http.StatusOK)
@@ -45,6 +58,189 @@ func (s *Server) corsMiddleware(next http.Handler) http.Handler {
 	})
 }
 
+// decoyKeyHits counts uses of decoy keys
+var decoyKeyHits = metrics.NewCounterVec("reai_decoy_key_hits_total", "Requests made with a decoy API key", "key_id")
+
+// authMiddleware requires a valid API key when keys are configured and
+// attaches the authenticated key to the request context
+func (s *Server) authMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !s.keys.Load().Enabled() {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ip := clientIP(r)
+		if s.blocklist.Blocked(ip) {
+			errors.WriteErrorResponse(w, &errors.APIError{Type: "permission_denied", Message: "Access denied", Code: http.StatusForbidden})
+			return
+		}
+
+		secret := requestAPIKey(r)
+		if secret == "" && s.config.Dev {
+			// Development mode: anonymous requests are allowed, keys still apply when sent
+			next.ServeHTTP(w, r)
+			return
+		}
+		if secret == "" {
+			errors.WriteErrorResponse(w, errors.NewAuthenticationError("missing API key"))
+			return
+		}
+
+		key, ok := s.keys.Load().Lookup(secret)
+		if ok && key.Decoy {
+			s.handleDecoyKey(r, key, ip)
+			errors.WriteErrorResponse(w, errors.NewAuthenticationError("invalid API key"))
+			return
+		}
+		if !ok {
+			slog.Warn("Rejected invalid API key", "path", r.URL.Path, "remote_addr", r.RemoteAddr, "key_id", keys.Fingerprint(secret))
+			errors.WriteErrorResponse(w, errors.NewAuthenticationError("invalid API key"))
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(keys.WithKey(r.Context(), key)))
+	})
+}
+
+// handleDecoyKey raises an alert for a decoy key and optionally blocks the caller
+func (s *Server) handleDecoyKey(r *http.Request, key *keys.Key, ip string) {
+	decoyKeyHits.With(key.ID).Inc()
+	slog.Error("🚨 ALERT: decoy API key used - key list may have leaked",
+		"alert", true,
+		"key_id", key.ID,
+		"key_name", key.Name,
+		"remote_addr", r.RemoteAddr,
+		"user_agent", r.UserAgent(),
+		"path", r.URL.Path,
+		"blocked", s.config.DecoyBlockIP,
+	)
+
+	if s.config.DecoyBlockIP {
+		s.blocklist.Block(ip, s.config.DecoyBlockDuration)
+	}
+}
+
+// requestAPIKey extracts the API key from the Authorization or api-key headers
+func requestAPIKey(r *http.Request) string {
+	if auth := r.Header.Get("Authorization"); auth != "" {
+		if token, ok := strings.CutPrefix(auth, "Bearer "); ok {
+			return strings.TrimSpace(token)
+		}
+	}
+	return strings.TrimSpace(r.Header.Get("Api-Key"))
+}
+
+// maintenanceMiddleware rejects API requests with 503 while maintenance is active
+func (s *Server) maintenanceMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		status := s.maintenance.Status(time.Now())
+		if !status.Active {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if status.Until != nil {
+			w.Header().Set("Retry-After", fmt.Sprintf("%d", max(1, int(time.Until(*status.Until).Seconds()))))
+		}
+		errors.WriteErrorResponse(w, errors.NewServiceUnavailableError(status.Message))
+	})
+}
+
+// editorHeader picks the editor profile of a request's upstream calls
+const editorHeader = "X-ReAI-Editor"
+
+// editorMiddleware applies the editor profile requested with X-ReAI-Editor.
+// Unknown profiles are rejected rather than silently replaced by the default.
+func (s *Server) editorMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimSpace(r.Header.Get(editorHeader))
+		if name == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if !s.copilotClient.HasEditor(name) {
+			errors.WriteErrorResponse(w, errors.NewInvalidRequestError(fmt.Sprintf("Unknown editor profile %q in %s (available: %s)",
+				name, editorHeader, strings.Join(s.copilotClient.Editors(), ", "))))
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(copilot.WithEditor(r.Context(), name)))
+	})
+}
+
+// X-ReAI-Debug turns on debug logging for one request. Only admins may set it,
+// by sending the admin token in X-ReAI-Admin-Token.
+const (
+	debugHeader      = "X-ReAI-Debug"
+	adminTokenHeader = "X-ReAI-Admin-Token"
+)
+
+// debugMiddleware lifts the log level of requests sent with X-ReAI-Debug, so
+// their upstream payloads and stream chunks are logged whatever LOG_LEVEL says
+func (s *Server) debugMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if debug, err := strconv.ParseBool(strings.TrimSpace(r.Header.Get(debugHeader))); err != nil || !debug {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if !s.isAdmin(r, strings.TrimSpace(r.Header.Get(adminTokenHeader))) {
+			slog.Warn("Rejected debug request", "path", r.URL.Path, "remote_addr", r.RemoteAddr)
+			errors.WriteErrorResponse(w, &errors.APIError{Type: "permission_denied", Message: debugHeader + " requires the admin token in " + adminTokenHeader, Code: http.StatusForbidden})
+			return
+		}
+
+		ctx := logging.WithDebug(r.Context())
+		slog.InfoContext(ctx, "🐞 Debug logging enabled for request", "method", r.Method, "path", r.URL.Path, "remote_addr", r.RemoteAddr)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// statusClientClosedRequest is the nginx-style status recorded for requests
+// whose client went away before they were answered. It never reaches the
+// client, but shows up in the access log and reai_http_requests_total.
+const statusClientClosedRequest = 499
+
+// queueMiddleware admits requests through the concurrency queue, waiting for a
+// slot when the server is saturated instead of rejecting immediately
+func (s *Server) queueMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		priority := priorityOf(r.Context())
+		if apiErr := s.shed(priority); apiErr != nil {
+			errors.WriteErrorResponse(w, apiErr)
+			return
+		}
+		start := time.Now()
+		release, err := s.queue.Acquire(r.Context(), priority)
+		if err != nil {
+			switch {
+			case stderrors.Is(err, context.DeadlineExceeded):
+				errors.WriteErrorResponse(w, errors.NewDeadlineExceededError("request expired while queued"))
+			case stderrors.Is(err, context.Canceled):
+				slog.Info("Client went away while queued", "path", r.URL.Path, "priority", priority, "waited", time.Since(start), "remote_addr", r.RemoteAddr)
+				w.WriteHeader(statusClientClosedRequest)
+			default:
+				slog.Warn("Request rejected by queue", "path", r.URL.Path, "reason", err, "depth", s.queue.Depth())
+				w.Header().Set("Retry-After", fmt.Sprintf("%d", max(1, int(s.config.QueueMaxWait.Seconds()))))
+				errors.WriteErrorResponse(w, errors.NewRateLimitError(err.Error()))
+			}
+			return
+		}
+		defer release()
+
+		ctx, cancel := withKeyMaxDuration(r.Context(), keys.FromContext(r.Context()))
+		defer cancel()
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// priorityOf returns the queue class of the caller's key, Normal without one
+func priorityOf(ctx context.Context) queue.Priority {
+	if key := keys.FromContext(ctx); key != nil && key.Priority != "" {
+		return key.Priority
+	}
+	return queue.Normal
+}
+
 // responseWriter wraps http.ResponseWriter to capture the status code
 type responseWriter struct {
 	http.ResponseWriter
@@ -55,3 +251,15 @@ func (rw *responseWriter) WriteHeader(code int) {
 	rw.statusCode = code
 	rw.ResponseWriter.WriteHeader(code)
 }
+
+// Flush lets streamed responses through the wrapper
+func (rw *responseWriter) Flush() {
+	if flusher, ok := rw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap exposes the underlying writer to http.ResponseController (used to hijack WebSockets)
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
@@ -0,0 +1,146 @@
+package api
+
+import (
+	"net/url"
+	"strings"
+
+	"github.com/devstroop/reai/internal/copilot"
+)
+
+// capabilityAliases maps friendly capability names to Copilot "supports" keys
+var capabilityAliases = map[string]string{
+	"tools":      "tool_calls",
+	"functions":  "tool_calls",
+	"parallel":   "parallel_tool_calls",
+	"json":       "structured_outputs",
+	"structured": "structured_outputs",
+}
+
+// modelFilter holds the /v1/models query filters. Comma separated values
+// match any of the listed values, except capabilities which must all match.
+type modelFilter struct {
+	capabilities []string
+	families     []string
+	ownedBy      []string
+	vendors      []string
+	endpoints    []string
+	types        []string
+	search       string
+}
+
+// parseModelFilter reads the filter parameters from a query string
+func parseModelFilter(query url.Values) modelFilter {
+	return modelFilter{
+		capabilities: splitQueryList(query, "capability"),
+		families:     splitQueryList(query, "family"),
+		ownedBy:      splitQueryList(query, "owned_by"),
+		vendors:      splitQueryList(query, "vendor"),
+		endpoints:    splitQueryList(query, "endpoint"),
+		types:        splitQueryList(query, "type"),
+		search:       strings.ToLower(strings.TrimSpace(query.Get("search"))),
+	}
+}
+
+// empty reports whether no filter was requested
+func (f modelFilter) empty() bool {
+	return len(f.capabilities) == 0 && len(f.families) == 0 && len(f.ownedBy) == 0 &&
+		len(f.vendors) == 0 && len(f.endpoints) == 0 && len(f.types) == 0 && f.search == ""
+}
+
+// apply returns the models matching every filter
+func (f modelFilter) apply(models []copilot.ModelInfo) []copilot.ModelInfo {
+	if f.empty() {
+		return models
+	}
+
+	result := make([]copilot.ModelInfo, 0, len(models))
+	for _, model := range models {
+		if f.matches(model) {
+			result = append(result, model)
+		}
+	}
+	return result
+}
+
+func (f modelFilter) matches(model copilot.ModelInfo) bool {
+	caps := model.Capabilities
+	if caps == nil {
+		caps = &copilot.ModelCapabilities{}
+	}
+
+	for _, capability := range f.capabilities {
+		if !supports(caps, capability) {
+			return false
+		}
+	}
+
+	if !matchesAny(f.families, caps.Family) || !matchesAny(f.ownedBy, model.OwnedBy) ||
+		!matchesAny(f.vendors, model.Vendor) || !matchesAny(f.types, caps.Type) {
+		return false
+	}
+
+	if len(f.endpoints) > 0 {
+		found := false
+		for _, endpoint := range model.Endpoints {
+			if matchesAny(f.endpoints, endpoint) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	if f.search != "" {
+		haystack := strings.ToLower(strings.Join([]string{model.ID, model.Name, model.Vendor, model.OwnedBy, caps.Family}, " "))
+		if !strings.Contains(haystack, f.search) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// supports checks a capability against the model's "supports" flags and type
+func supports(caps *copilot.ModelCapabilities, capability string) bool {
+	if strings.EqualFold(caps.Type, capability) {
+		return true
+	}
+	if alias, ok := capabilityAliases[capability]; ok {
+		capability = alias
+	}
+	value, ok := caps.Supports[capability]
+	if !ok {
+		return false
+	}
+	if b, isBool := value.(bool); isBool {
+		return b
+	}
+	// Non boolean values (e.g. token budgets) indicate support
+	return value != nil
+}
+
+func matchesAny(values []string, value string) bool {
+	if len(values) == 0 {
+		return true
+	}
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
+
+func splitQueryList(query url.Values, key string) []string {
+	var result []string
+	for _, raw := range query[key] {
+		for _, value := range strings.Split(raw, ",") {
+			if value = strings.ToLower(strings.TrimSpace(value)); value != "" {
+				result = append(result, value)
+			}
+		}
+	}
+	return result
+}
@@ -0,0 +1,111 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/devstroop/reai/internal/moderation"
+	"github.com/devstroop/reai/pkg/errors"
+)
+
+// maxModerationInputs bounds the inputs of one moderation request
+const maxModerationInputs = 32
+
+// ModerationRequest is an OpenAI moderation request. Input is a string, an
+// array of strings or an array of text and image_url content parts.
+type ModerationRequest struct {
+	Input json.RawMessage `json:"input"`
+	Model string          `json:"model,omitempty"`
+}
+
+// ModerationResponse is an OpenAI moderation response
+type ModerationResponse struct {
+	ID      string              `json:"id"`
+	Model   string              `json:"model"`
+	Results []moderation.Result `json:"results"`
+}
+
+// moderationPart is a content part of a multi-modal moderation input
+type moderationPart struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+// moderationInputs returns the texts of a moderation input. Content parts
+// make up a single input, of which only the text is classified.
+func moderationInputs(raw json.RawMessage) ([]string, error) {
+	var single string
+	if err := json.Unmarshal(raw, &single); err == nil {
+		return []string{single}, nil
+	}
+	var list []string
+	if err := json.Unmarshal(raw, &list); err == nil {
+		if len(list) == 0 {
+			return nil, errors.NewValidationError("input must not be empty")
+		}
+		if len(list) > maxModerationInputs {
+			return nil, errors.NewValidationError("input has more than 32 entries")
+		}
+		return list, nil
+	}
+	var parts []moderationPart
+	if err := json.Unmarshal(raw, &parts); err == nil && len(parts) > 0 {
+		var texts []string
+		for _, part := range parts {
+			switch part.Type {
+			case "text":
+				texts = append(texts, part.Text)
+			case "image_url":
+			default:
+				return nil, errors.NewValidationError("input parts must be text or image_url")
+			}
+		}
+		return []string{strings.Join(texts, "\n")}, nil
+	}
+	return nil, errors.NewValidationError("input must be a string, an array of strings or an array of content parts")
+}
+
+// handleModerations classifies inputs with the classifier of MODERATION_FILE,
+// which allows everything unless configured otherwise
+func (s *Server) handleModerations(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req ModerationRequest
+	if err := s.decodeRequest(r, moderationRequestFields, &req); err != nil {
+		writeError(w, err)
+		return
+	}
+	if len(req.Input) == 0 {
+		writeError(w, errors.NewValidationError("input is required"))
+		return
+	}
+	inputs, err := moderationInputs(req.Input)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	for _, input := range inputs {
+		if len(input) > s.config.MaxPromptLength {
+			writeError(w, errors.NewValidationError(fmt.Sprintf("Input too long: %d characters (max: %d)", len(input), s.config.MaxPromptLength)))
+			return
+		}
+	}
+
+	results, err := moderation.Moderate(r.Context(), s.moderation, req.Model, inputs)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	model := getDefaultOrString(req.Model, moderation.DefaultModel)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ModerationResponse{
+		ID:      "modr-" + strings.TrimPrefix(generateID(), "reai-"),
+		Model:   model,
+		Results: results,
+	})
+}
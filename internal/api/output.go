@@ -0,0 +1,42 @@
+package api
+
+import (
+	"fmt"
+	"log/slog"
+
+	"github.com/devstroop/reai/internal/filter"
+	"github.com/devstroop/reai/internal/keys"
+	"github.com/devstroop/reai/internal/transform"
+)
+
+// OutputHook transforms the text of completions, see Server.AddOutputHook
+type OutputHook = transform.Hook
+
+// AddOutputHook adds a hook that sees the text of every completion after the
+// completion filters, chunk by chunk when streamed, and may rewrite it.
+// Hooks run in the order they were added. A hook returning an error
+// withholds the rest of the completion, which then ends with the
+// content_filter finish reason. Call it before Router.
+func (s *Server) AddOutputHook(hook OutputHook) {
+	s.outputHooks = append(s.outputHooks, hook)
+}
+
+// startOutputHooks starts the output hooks for the exchange of a completion
+// of upstream, nil when none of them applies
+func (s *Server) startOutputHooks(e *exchange, chat bool, language string) *transform.Chain {
+	if len(s.outputHooks) == 0 {
+		return nil
+	}
+	info := transform.Info{ID: e.id, Model: e.model, Chat: chat, Prompt: e.prompt, Language: language}
+	if key := keys.FromContext(e.request.Context()); key != nil {
+		info.KeyID = key.ID
+	}
+	return transform.Start(s.outputHooks, info)
+}
+
+// outputHookError blocks the rest of a completion the way a blocking filter
+// does
+func outputHookError(err error) error {
+	slog.Warn("🚫 Completion withheld by output hook", "error", err)
+	return fmt.Errorf("%w: output hook: %w", filter.ErrBlocked, err)
+}
@@ -0,0 +1,97 @@
+package api
+
+import (
+	"context"
+
+	"github.com/devstroop/reai/internal/keys"
+)
+
+// keyMaxTokens caps max_tokens at the ceiling of the authenticated key,
+// which also applies when the client asked for none (0)
+func keyMaxTokens(ctx context.Context, maxTokens int) int {
+	key := keys.FromContext(ctx)
+	if key == nil || key.MaxTokens == 0 {
+		return maxTokens
+	}
+	if maxTokens == 0 || maxTokens > key.MaxTokens {
+		return key.MaxTokens
+	}
+	return maxTokens
+}
+
+// withKeyMaxDuration bounds ctx by the maximum completion duration of key.
+// Call it once the request leaves the queue, so waiting doesn't count.
+func withKeyMaxDuration(ctx context.Context, key *keys.Key) (context.Context, context.CancelFunc) {
+	if key == nil || key.MaxDurationLimit() == 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, key.MaxDurationLimit())
+}
+
+// applyChatKeyParameters applies the authenticated key's defaults (for
+// parameters the client left unset) and overrides (always) to a chat request
+func applyChatKeyParameters(ctx context.Context, req *ChatCompletionRequest) {
+	key := keys.FromContext(ctx)
+	if key == nil {
+		return
+	}
+
+	if d := key.Defaults; d != nil {
+		if req.Model == "" && d.Model != "" {
+			req.Model = d.Model
+		}
+		if req.Temperature == nil && d.Temperature != nil {
+			req.Temperature = d.Temperature
+		}
+		if d.SystemPrompt != "" && !hasSystemMessage(req.Messages) {
+			req.Messages = append([]ChatMessage{{Role: "system", Content: d.SystemPrompt}}, req.Messages...)
+		}
+	}
+
+	req.MaxTokens = keyMaxTokens(ctx, req.MaxTokens)
+
+	if o := key.Overrides; o != nil {
+		if o.Model != "" {
+			req.Model = o.Model
+		}
+		if o.Temperature != nil {
+			req.Temperature = o.Temperature
+		}
+		if o.SystemPrompt != "" {
+			messages := []ChatMessage{{Role: "system", Content: o.SystemPrompt}}
+			for _, msg := range req.Messages {
+				if msg.Role != "system" {
+					messages = append(messages, msg)
+				}
+			}
+			req.Messages = messages
+		}
+	}
+}
+
+// applyCompletionKeyParameters applies the authenticated key's defaults and
+// overrides to a completion request. Only the temperature applies to
+// completions, besides the max_tokens ceiling.
+func applyCompletionKeyParameters(ctx context.Context, req *CompletionRequest) {
+	key := keys.FromContext(ctx)
+	if key == nil {
+		return
+	}
+	req.MaxTokens = keyMaxTokens(ctx, req.MaxTokens)
+
+	if d := key.Defaults; d != nil && req.Temperature == nil && d.Temperature != nil {
+		req.Temperature = d.Temperature
+	}
+	if o := key.Overrides; o != nil && o.Temperature != nil {
+		req.Temperature = o.Temperature
+	}
+}
+
+func hasSystemMessage(messages []ChatMessage) bool {
+	for _, msg := range messages {
+		if msg.Role == "system" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,53 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/devstroop/reai/internal/copilot"
+	"github.com/devstroop/reai/internal/probe"
+)
+
+// RunProbes runs the configured synthetic probes until ctx is done. It
+// returns immediately when no PROBES_FILE is set.
+func (s *Server) RunProbes(ctx context.Context) {
+	if s.probes == nil {
+		return
+	}
+	s.probes.Run(ctx)
+}
+
+// runProbe sends a probe straight to the provider of its model, bypassing the
+// key checks, filters and queue that apply to client requests
+func (s *Server) runProbe(ctx context.Context, p probe.Probe) (string, error) {
+	completion, err := s.providers.GetCompletion(ctx, &copilot.CompletionRequest{
+		Model:     p.Model,
+		Prompt:    p.Prompt,
+		Language:  "text",
+		MaxTokens: p.MaxTokens,
+	})
+	if err != nil {
+		return "", err
+	}
+	return completion.Text, nil
+}
+
+// handleAdminProbes reports the synthetic probes and their recent results
+func (s *Server) handleAdminProbes(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	statuses := []probe.Status{}
+	if s.probes != nil {
+		statuses = s.probes.Status()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"object": "list",
+		"data":   statuses,
+	})
+}
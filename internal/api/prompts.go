@@ -0,0 +1,133 @@
+package api
+
+import (
+	"encoding/json"
+	stderrors "errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/devstroop/reai/internal/prompts"
+	"github.com/devstroop/reai/pkg/errors"
+)
+
+// handlePrompts lists the prompt templates (GET) or creates one (POST)
+func (s *Server) handlePrompts(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"object": "list",
+			"data":   s.prompts.List(),
+		})
+	case http.MethodPost:
+		var t prompts.Template
+		if err := s.decodeJSON(r, &t); err != nil {
+			writeError(w, err)
+			return
+		}
+		stored, err := s.prompts.Put(t, true)
+		if err != nil {
+			writePromptError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(stored)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handlePrompt returns (GET), creates or replaces (PUT) or deletes (DELETE)
+// a single prompt template
+func (s *Server) handlePrompt(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/v1/prompts/")
+
+	switch r.Method {
+	case http.MethodGet:
+		t, err := s.prompts.Get(name)
+		if err != nil {
+			writePromptError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(t)
+	case http.MethodPut:
+		var t prompts.Template
+		if err := s.decodeJSON(r, &t); err != nil {
+			writeError(w, err)
+			return
+		}
+		if t.Name != "" && t.Name != name {
+			errors.WriteErrorResponse(w, errors.NewValidationError("name in the body does not match the path"))
+			return
+		}
+		t.Name = name
+		stored, err := s.prompts.Put(t, false)
+		if err != nil {
+			writePromptError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(stored)
+	case http.MethodDelete:
+		if err := s.prompts.Delete(name); err != nil {
+			writePromptError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"name": name, "deleted": true})
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// applyPromptTemplate renders the template named by the request with its
+// variables and puts the result in front of the request messages
+func (s *Server) applyPromptTemplate(req *ChatCompletionRequest) error {
+	if req.Template == "" {
+		if req.Variables != nil {
+			return errors.NewValidationError("variables require template")
+		}
+		return nil
+	}
+	if s.prompts == nil {
+		return errors.NewValidationError("template requires PROMPTS_ENABLED")
+	}
+
+	t, err := s.prompts.Get(req.Template)
+	if err != nil {
+		if stderrors.Is(err, prompts.ErrNotFound) {
+			return errors.NewValidationError(fmt.Sprintf("prompt template %s not found", req.Template))
+		}
+		return errors.NewInternalError(err.Error())
+	}
+	rendered, err := t.Render(req.Variables, s.config.MaxPromptLength)
+	if err != nil {
+		if stderrors.Is(err, prompts.ErrTooLarge) {
+			return errors.NewValidationError(fmt.Sprintf("Prompt template %s renders more than %d characters", req.Template, s.config.MaxPromptLength))
+		}
+		return errors.NewValidationError(err.Error())
+	}
+
+	messages := make([]ChatMessage, 0, len(rendered)+len(req.Messages))
+	for _, msg := range rendered {
+		messages = append(messages, ChatMessage{Role: msg.Role, Content: msg.Content})
+	}
+	req.Messages = append(messages, req.Messages...)
+	return nil
+}
+
+func writePromptError(w http.ResponseWriter, err error) {
+	switch {
+	case stderrors.Is(err, prompts.ErrNotFound):
+		errors.WriteErrorResponse(w, &errors.APIError{Type: "not_found", Message: err.Error(), Code: http.StatusNotFound})
+	case stderrors.Is(err, prompts.ErrExists):
+		errors.WriteErrorResponse(w, &errors.APIError{Type: "conflict", Message: err.Error(), Code: http.StatusConflict})
+	case stderrors.Is(err, prompts.ErrInvalid):
+		errors.WriteErrorResponse(w, errors.NewValidationError(err.Error()))
+	default:
+		writeError(w, errors.NewInternalError(err.Error()))
+	}
+}
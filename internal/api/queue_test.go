@@ -0,0 +1,82 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// syncBuffer is a log destination safe for concurrent handlers
+type syncBuffer struct {
+	mutex sync.Mutex
+	buf   bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.buf.String()
+}
+
+func TestQueueClientCancelled(t *testing.T) {
+	server := newMockServer(t, map[string]string{
+		"RATE_LIMIT":       "1",
+		"MOCK_TOKEN_DELAY": "100ms",
+	})
+	logs := &syncBuffer{}
+	slog.SetDefault(slog.New(slog.NewTextHandler(logs, nil)))
+	ts := httptest.NewServer(server.Router())
+	t.Cleanup(ts.Close)
+
+	body := `{"model": "gpt-4o", "stream": true, "messages": [{"role": "user", "content": "hello there"}]}`
+	// The first stream holds the only slot while the second request waits
+	holder, err := http.Post(ts.URL+"/v1/chat/completions", "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer holder.Body.Close()
+	eventually(t, "the slot to be taken", func() bool { return server.queue.InFlight() == 1 })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(body)).WithContext(ctx)
+	rec := httptest.NewRecorder()
+	done := make(chan struct{})
+	go func() {
+		server.Router().ServeHTTP(rec, req)
+		close(done)
+	}()
+	eventually(t, "the request to queue", func() bool { return server.queue.Depth() == 1 })
+	cancel()
+	<-done
+	if rec.Code != statusClientClosedRequest {
+		t.Errorf("status %d, want %d", rec.Code, statusClientClosedRequest)
+	}
+	io.Copy(io.Discard, holder.Body)
+
+	eventually(t, "the cancellation to be logged", func() bool {
+		return strings.Contains(logs.String(), "Client went away while queued") &&
+			strings.Contains(logs.String(), "status=499")
+	})
+	resp, err := http.Get(ts.URL + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	metrics, _ := io.ReadAll(resp.Body)
+	if !regexp.MustCompile(`(?m)^reai_http_requests_total\{[^}]*code="499"`).Match(metrics) {
+		t.Error("499 not counted")
+	}
+}
@@ -0,0 +1,52 @@
+package api
+
+import "github.com/devstroop/reai/internal/filter"
+
+// reasoningStream passes the reasoning text of a streamed completion on to
+// the client. It goes through the completion filters like the answer, with a
+// line buffer of its own.
+type reasoningStream struct {
+	send   func(text string) error
+	filter *filter.StreamFilter
+}
+
+// newReasoningStream returns a reasoning stream sending to send, nil when
+// send is nil and reasoning is dropped
+func (s *Server) newReasoningStream(send func(text string) error) *reasoningStream {
+	if send == nil {
+		return nil
+	}
+	return &reasoningStream{send: send, filter: s.newCompletionStreamFilter()}
+}
+
+// Write sends the part of text the filters let through so far
+func (rs *reasoningStream) Write(text string) error {
+	if rs == nil || text == "" {
+		return nil
+	}
+	if rs.filter != nil {
+		var err error
+		if text, err = rs.filter.Write(text); err != nil || text == "" {
+			return err
+		}
+	}
+	return rs.send(text)
+}
+
+// Flush sends the text the filters held back. It is called when the answer
+// starts, so reasoning comes first, and again at the end.
+func (rs *reasoningStream) Flush() error {
+	if rs == nil || rs.filter == nil {
+		return nil
+	}
+	tail, err := rs.filter.Flush()
+	if err != nil || tail == "" {
+		return err
+	}
+	return rs.send(tail)
+}
+
+// Redacted reports whether the filters changed any reasoning text
+func (rs *reasoningStream) Redacted() bool {
+	return rs != nil && rs.filter != nil && rs.filter.Redacted()
+}
@@ -0,0 +1,136 @@
+package api
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/devstroop/reai/internal/filter"
+	"github.com/devstroop/reai/internal/keys"
+)
+
+// ReloadFilters reloads FILTERS_FILE. The current filters stay in place when
+// the file is invalid.
+func (s *Server) ReloadFilters() error {
+	filters, err := filter.Load(s.config.FiltersFile)
+	if err != nil {
+		return err
+	}
+	s.filters.Store(filters)
+	return nil
+}
+
+// ReloadKeys reloads API_KEYS_FILE, API_KEYS and the stored keys. The
+// current keys stay in place when the file is invalid.
+func (s *Server) ReloadKeys() error {
+	keyStore, err := keys.Load(s.config.APIKeysFile, s.config.APIKeys, s.db)
+	if err != nil {
+		return err
+	}
+	s.keys.Store(keyStore)
+	return nil
+}
+
+// ReloadPrompts reads the prompt templates of PROMPTS_ENABLED again. The
+// current templates stay in place when one is invalid.
+func (s *Server) ReloadPrompts() error {
+	if s.prompts == nil {
+		return nil
+	}
+	return s.prompts.Reload()
+}
+
+// ReloadMockResponses reloads MOCK_RESPONSES_FILE in the mock upstream mode.
+// The current responses stay in place when the file is invalid.
+func (s *Server) ReloadMockResponses() error {
+	if s.mock == nil {
+		return nil
+	}
+	return s.mock.Reload(s.config.MockResponsesFile)
+}
+
+// WatchConfigFiles polls the filter, API key and mock response files and the
+// prompt templates, and reloads them when they change, until ctx is done. It
+// is used in development mode, where editing a file should not require a
+// restart.
+func (s *Server) WatchConfigFiles(ctx context.Context, interval time.Duration) {
+	watched := map[string]func() error{}
+	if s.config.FiltersFile != "" {
+		watched[s.config.FiltersFile] = s.ReloadFilters
+	}
+	if s.config.APIKeysFile != "" {
+		watched[s.config.APIKeysFile] = s.ReloadKeys
+	}
+	if s.mock != nil && s.config.MockResponsesFile != "" {
+		watched[s.config.MockResponsesFile] = s.ReloadMockResponses
+	}
+	if s.prompts != nil {
+		watched[s.config.PromptsDir()] = s.ReloadPrompts
+	}
+	if len(watched) == 0 {
+		return
+	}
+
+	versions := make(map[string]fileVersion, len(watched))
+	for path := range watched {
+		versions[path] = statFile(path)
+		slog.Debug("Watching config file", "path", path)
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		for path, reload := range watched {
+			version := statFile(path)
+			if version == versions[path] {
+				continue
+			}
+			versions[path] = version
+			if err := reload(); err != nil {
+				slog.Error("❌ Config file reload failed - keeping the previous version", "path", path, "error", err)
+				continue
+			}
+			slog.Info("🔄 Config file reloaded", "path", path)
+		}
+	}
+}
+
+// fileVersion identifies a revision of a file by size and modification
+// time, or of a directory by the total size and latest modification time of
+// its files
+type fileVersion struct {
+	size    int64
+	modTime time.Time
+}
+
+func statFile(path string) fileVersion {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fileVersion{}
+	}
+	version := fileVersion{size: info.Size(), modTime: info.ModTime()}
+	if !info.IsDir() {
+		return version
+	}
+
+	// The directory itself changes when files are added or removed
+	version.size = 0
+	entries, _ := os.ReadDir(path)
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		version.size += info.Size()
+		if info.ModTime().After(version.modTime) {
+			version.modTime = info.ModTime()
+		}
+	}
+	return version
+}
@@ -0,0 +1,74 @@
+package api
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/devstroop/reai/internal/copilot"
+)
+
+// eventually polls check until it holds or a second has passed
+func eventually(t *testing.T, what string, check func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !check() {
+		if time.Now().After(deadline) {
+			t.Fatalf("%s: not reloaded", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestWatchConfigFiles(t *testing.T) {
+	mockFile := filepath.Join(t.TempDir(), "mock.json")
+	if err := os.WriteFile(mockFile, []byte(`{"default": "first"}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	server := newMockServer(t, map[string]string{
+		"DEV_MODE":            "true",
+		"MOCK_RESPONSES_FILE": mockFile,
+		"PROMPTS_ENABLED":     "true",
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go server.WatchConfigFiles(ctx, 10*time.Millisecond)
+
+	complete := func() string {
+		completion, err := server.providers.GetCompletion(ctx, &copilot.CompletionRequest{Prompt: "hi"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return completion.Text
+	}
+	if got := complete(); got != "first" {
+		t.Fatalf("got %q before the edit", got)
+	}
+
+	// Let the watcher record the first version
+	time.Sleep(50 * time.Millisecond)
+	if err := os.WriteFile(mockFile, []byte(`{"default": "second response"}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	eventually(t, "mock responses", func() bool { return complete() == "second response" })
+
+	// An invalid edit keeps the previous responses
+	if err := os.WriteFile(mockFile, []byte(`{"default": "{{"}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if got := complete(); got != "second response" {
+		t.Fatalf("got %q after an invalid edit", got)
+	}
+
+	template := `{"name": "greet", "messages": [{"role": "user", "content": "Hello {{.name}}"}]}`
+	if err := os.WriteFile(filepath.Join(server.config.PromptsDir(), "greet.json"), []byte(template), 0600); err != nil {
+		t.Fatal(err)
+	}
+	eventually(t, "prompt templates", func() bool {
+		_, err := server.prompts.Get("greet")
+		return err == nil
+	})
+}
@@ -1,48 +1,536 @@
 package api
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
+	"fmt"
 	"log/slog"
 	"net/http"
+	"net/http/httputil"
+	"strings"
+	"sync/atomic"
 	"time"
 
+	"github.com/devstroop/reai/internal/audit"
+	"github.com/devstroop/reai/internal/batch"
+	"github.com/devstroop/reai/internal/config"
+	"github.com/devstroop/reai/internal/conversations"
 	"github.com/devstroop/reai/internal/copilot"
+	"github.com/devstroop/reai/internal/files"
+	"github.com/devstroop/reai/internal/prompts"
+	"github.com/devstroop/reai/internal/experiment"
+	"github.com/devstroop/reai/internal/filter"
+	"github.com/devstroop/reai/internal/keys"
+	"github.com/devstroop/reai/internal/maintenance"
+	"github.com/devstroop/reai/internal/metrics"
+	"github.com/devstroop/reai/internal/moderation"
+	"github.com/devstroop/reai/internal/pricing"
+	"github.com/devstroop/reai/internal/probe"
+	"github.com/devstroop/reai/internal/provider"
+	"github.com/devstroop/reai/internal/queue"
+	"github.com/devstroop/reai/internal/quota"
+	"github.com/devstroop/reai/internal/sink"
+	"github.com/devstroop/reai/internal/store"
+	"github.com/devstroop/reai/internal/transform"
+	"github.com/devstroop/reai/internal/usage"
+	"github.com/devstroop/reai/internal/watermark"
+	"github.com/devstroop/reai/internal/webhook"
 	"github.com/devstroop/reai/pkg/errors"
 )
 
 // Server represents the API server
 type Server struct {
+	config        *config.Config
 	copilotClient *copilot.Client
+	// db holds the state that outlives a restart
+	db *store.DB
+	// providers routes completions to Copilot or the provider of the model
+	providers *provider.Router
+	// mock is the UPSTREAM_MODE=mock provider, nil in the other modes
+	mock *provider.Mock
+	queue         *queue.Queue
+	quota         *quota.Tracker
+	keys          atomic.Pointer[keys.Store]
+	blocklist     *ipBlocklist
+	maintenance   *maintenance.Mode
+	drain         *drainState
+	shedder       *loadShedder
+	// ipLimiter is nil when IP_RATE_LIMIT is 0
+	ipLimiter     *ipLimiter
+	filters       atomic.Pointer[filter.Pipeline]
+	watermark     *watermark.Signer
+	audit         *audit.Logger
+	auditPolicy   audit.Policy
+	// sinks is nil unless SINKS_FILE is set
+	sinks *sink.Dispatcher
+	// webhooks is nil unless WEBHOOKS_FILE is set
+	webhooks *webhook.Dispatcher
+	// conversations is nil unless CONVERSATIONS_ENABLED is set
+	conversations *conversations.Store
+	// probes is nil unless PROBES_FILE is set
+	probes *probe.Runner
+	// moderation classifies /v1/moderations inputs (allow-all by default)
+	moderation moderation.Classifier
+	// experiments is nil unless EXPERIMENTS_FILE is set
+	experiments *experiment.Set
+	// usage is the daily usage history per key and model
+	usage *usage.History
+	// records keeps the usage record of each watermarked response
+	records *usage.Records
+	// pricing prices token usage, nil while no price is configured
+	pricing *pricing.Table
+	// audioProxy is nil unless AUDIO_UPSTREAM_URL is set
+	audioProxy *httputil.ReverseProxy
+	// prompts is nil unless PROMPTS_ENABLED is set
+	prompts *prompts.Store
+	// files is nil unless FILES_ENABLED or BATCHES_ENABLED is set
+	files *files.Store
+	// batches and batchRunner are nil unless BATCHES_ENABLED is set
+	batches     *batch.Store
+	batchRunner *batch.Runner
+	// warmModels is nil unless WARM_MODELS is set
+	warmModels *warmModels
+	// idempotency is nil unless IDEMPOTENCY_TTL is set
+	idempotency *idempotencyStore
+	// azureDeployments maps Azure deployment names to models (empty when
+	// every deployment is named after its model)
+	azureDeployments map[string]string
+	// middlewares are added with Use to the chain around every endpoint
+	middlewares []namedMiddleware
+	// outputHooks transform completion text, see AddOutputHook
+	outputHooks []OutputHook
 }
 
 // NewServer creates a new API server
-func NewServer(client *copilot.Client) *Server {
-	return &Server{
+func NewServer(cfg *config.Config, client *copilot.Client) (*Server, error) {
+	return NewServerWithUpstream(cfg, client, nil)
+}
+
+// NewServerWithUpstream creates an API server whose models without a
+// PROVIDERS_FILE route are served by upstream instead of Copilot. A nil
+// upstream picks Copilot, or the recorder, replayer or mock of UPSTREAM_MODE.
+// client still handles authentication, health checks and admin endpoints.
+func NewServerWithUpstream(cfg *config.Config, client *copilot.Client, upstream provider.Provider) (*Server, error) {
+	var mock *provider.Mock
+	if upstream == nil {
+		var err error
+		if upstream, mock, err = upstreamProvider(cfg, client); err != nil {
+			return nil, err
+		}
+	}
+	if conflicts := cfg.ReadOnlyConflicts(); cfg.ReadOnly && len(conflicts) > 0 {
+		return nil, fmt.Errorf("READ_ONLY writes no files, unset %s", strings.Join(conflicts, ", "))
+	}
+	if err := checkMiddlewareNames(cfg); err != nil {
+		return nil, err
+	}
+	var db *store.DB
+	var err error
+	if cfg.ReadOnly {
+		db, err = store.OpenMemory()
+	} else {
+		db, err = store.Open(cfg.DatabasePath())
+	}
+	if err != nil {
+		return nil, err
+	}
+	slog.Debug("Database opened", "path", db.Path())
+	keyStore, err := keys.Load(cfg.APIKeysFile, cfg.APIKeys, db)
+	if err != nil {
+		return nil, err
+	}
+	windows, err := maintenance.ParseWindows(cfg.MaintenanceWindows)
+	if err != nil {
+		return nil, err
+	}
+	maintenanceLocation, err := maintenance.ParseLocation(cfg.MaintenanceTimezone)
+	if err != nil {
+		return nil, err
+	}
+	shares, err := queue.ParseShares(cfg.PriorityShares)
+	if err != nil {
+		return nil, err
+	}
+	filters, err := filter.Load(cfg.FiltersFile)
+	if err != nil {
+		return nil, err
+	}
+	azureDeployments, err := parseAzureDeployments(cfg.AzureDeployments)
+	if err != nil {
+		return nil, err
+	}
+
+	if keyStore.Enabled() {
+		slog.Info("API key authentication enabled", "keys", keyStore.Len())
+	} else if !cfg.Dev {
+		slog.Warn("No API keys configured - the API is open to anyone who can reach it")
+	}
+	if !filters.Empty() {
+		slog.Info("Content filters enabled", "file", cfg.FiltersFile)
+	}
+
+	auditPolicy := audit.Policy{
+		CapturePrompts:     cfg.AuditCapturePrompts,
+		CaptureCompletions: cfg.AuditCaptureCompletions,
+		MaxTranscriptBytes: cfg.AuditMaxTranscriptBytes,
+	}
+	auditLog, err := audit.Open(cfg.AuditLog, auditPolicy)
+	if err != nil {
+		return nil, err
+	}
+	if auditLog != nil {
+		slog.Info("Audit log enabled", "path", cfg.AuditLog)
+	}
+	sinks, err := sink.Load(cfg.SinksFile, auditPolicy)
+	if err != nil {
+		auditLog.Close()
+		return nil, err
+	}
+	if sinks != nil {
+		slog.Info("Analytics sinks enabled", "file", cfg.SinksFile, "sinks", sinks.Len())
+	}
+
+	webhooks, err := webhook.Load(cfg.WebhooksFile, authAlerts(cfg)...)
+	if err != nil {
+		return nil, err
+	}
+	if webhooks != nil {
+		slog.Info("Webhooks enabled", "file", cfg.WebhooksFile, "webhooks", webhooks.Len())
+		client.SetNotifier(webhooks)
+	}
+
+	providers, err := provider.Load(cfg.ProvidersFile, upstream)
+	if err != nil {
+		return nil, err
+	}
+	if providers.Len() > 0 {
+		slog.Info("Backend providers enabled", "file", cfg.ProvidersFile, "providers", providers.Len())
+	}
+	if failover := providers.Failover(); failover != "" {
+		slog.Info("Copilot failover enabled", "provider", failover)
+	}
+	if shadow := providers.Shadow(); shadow != "" {
+		slog.Info("Shadowing Copilot requests", "provider", shadow)
+	}
+
+	experiments, err := experiment.Load(cfg.ExperimentsFile)
+	if err != nil {
+		return nil, err
+	}
+	if experiments.Len() > 0 {
+		slog.Info("Model experiments enabled", "file", cfg.ExperimentsFile, "experiments", experiments.Len())
+	}
+
+	moderator, err := moderation.Load(cfg.ModerationFile)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.ModerationFile != "" {
+		slog.Info("Moderation enabled", "file", cfg.ModerationFile, "classifier", moderator.Name())
+	}
+
+	prices, err := pricing.Load(cfg.PricingFile, pricing.Price{Prompt: cfg.CostPer1KPromptTokens, Completion: cfg.CostPer1KCompletionTokens}, cfg.CostCurrency)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.PricingFile != "" {
+		slog.Info("Pricing table loaded", "file", cfg.PricingFile, "models", prices.Len())
+	}
+
+	audioProxy, err := newAudioProxy(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if audioProxy != nil {
+		slog.Info("Audio requests forwarded", "upstream", cfg.AudioUpstreamURL)
+	}
+
+	// Files older versions left behind are moved into the database and
+	// removed, which read-only mode can't do
+	legacyQuota, legacyConversations := cfg.QuotaFilePath(), cfg.ConversationsDir()
+	if cfg.ReadOnly {
+		legacyQuota, legacyConversations = "", ""
+	}
+	quotaTracker, err := quota.Open(db, legacyQuota)
+	if err != nil {
+		return nil, err
+	}
+
+	var conversationStore *conversations.Store
+	if cfg.ConversationsEnabled {
+		if conversationStore, err = conversations.Open(db, legacyConversations); err != nil {
+			return nil, err
+		}
+		slog.Info("Conversation store enabled", "conversations", conversationStore.Len())
+	}
+
+	server := &Server{
+		config:        cfg,
 		copilotClient: client,
+		db:            db,
+		providers:     providers,
+		mock:          mock,
+		queue: queue.New(queue.Options{
+			MaxConcurrent: cfg.RateLimit,
+			MaxDepth:      cfg.QueueDepth,
+			MaxWait:       cfg.QueueMaxWait,
+			MinRemaining:  cfg.QueueMinRemaining,
+			Shares:        shares,
+		}),
+		quota:       quotaTracker,
+		usage:       usage.Open(db),
+		records:     usage.OpenRecords(db, cfg.WatermarkRetention),
+		blocklist:   newIPBlocklist(),
+		maintenance: maintenance.New(windows, cfg.MaintenanceMessage, maintenanceLocation),
+		drain:       newDrainState(),
+		shedder:     newLoadShedder(cfg.ShedHeapBytes, cfg.ShedGoroutines),
+		ipLimiter:   newIPLimiter(cfg.IPRateLimit, cfg.IPRateBurst),
+		watermark:   watermark.New(cfg.WatermarkSecret),
+		audit:       auditLog,
+		auditPolicy: auditPolicy,
+		sinks:       sinks,
+		webhooks:    webhooks,
+		moderation:  moderator,
+		experiments: experiments,
+		pricing:     prices,
+		audioProxy:  audioProxy,
+
+		conversations: conversationStore,
+		warmModels:    newWarmModels(cfg.WarmModels),
+
+		idempotency:      newIdempotencyStore(cfg.IdempotencyTTL, db),
+		azureDeployments: azureDeployments,
+	}
+	server.keys.Store(keyStore)
+	server.filters.Store(filters)
+	if cfg.StripCodeFences {
+		server.AddOutputHook(transform.StripCodeFences())
+	}
+	postprocessors, err := transform.Postprocessors(cfg.CompletionPostprocess)
+	if err != nil {
+		return nil, err
+	}
+	for _, hook := range postprocessors {
+		server.AddOutputHook(hook)
+	}
+	if !validTrimStrategy(cfg.ContextTrimStrategy) {
+		return nil, fmt.Errorf("unknown CONTEXT_TRIM_STRATEGY %q", cfg.ContextTrimStrategy)
+	}
+
+	if server.probes, err = probe.Load(cfg.ProbesFile, server.runProbe); err != nil {
+		return nil, err
+	}
+	if server.probes != nil {
+		slog.Info("Synthetic probes enabled", "file", cfg.ProbesFile, "probes", server.probes.Len())
+	}
+
+	if cfg.PromptsEnabled {
+		if server.prompts, err = prompts.Open(cfg.PromptsDir()); err != nil {
+			return nil, err
+		}
+		slog.Info("Prompt templates enabled", "dir", cfg.PromptsDir(), "templates", server.prompts.Len())
+	}
+	if cfg.FilesEnabled {
+		if server.files, err = files.Open(cfg.FilesDir(), cfg.FilesMaxBytes); err != nil {
+			return nil, err
+		}
+		slog.Info("Files API enabled", "dir", cfg.FilesDir(), "max_bytes", cfg.FilesMaxBytes)
+	}
+	if cfg.BatchesEnabled {
+		if server.batches, err = batch.Open(db, cfg.BatchesDir()); err != nil {
+			return nil, err
+		}
+		server.batchRunner = batch.NewRunner(server.batches, server.files, server.executeBatchRequest, cfg.BatchRequestsPerMinute)
+		slog.Info("Batch API enabled", "dir", cfg.BatchesDir(), "requests_per_minute", cfg.BatchRequestsPerMinute)
+	}
+	return server, nil
+}
+
+// upstreamProvider returns the provider of UPSTREAM_MODE serving the models
+// no other provider is routed to, and the mock when it is that provider
+func upstreamProvider(cfg *config.Config, client *copilot.Client) (provider.Provider, *provider.Mock, error) {
+	var fallback provider.Provider = client
+	var mock *provider.Mock
+	var err error
+	switch cfg.UpstreamMode {
+	case "":
+	case provider.ModeRecord:
+		if fallback, err = provider.Record(client, cfg.RecordingsPath()); err != nil {
+			return nil, nil, err
+		}
+		slog.Info("🎙️ Recording Copilot responses", "dir", cfg.RecordingsPath())
+	case provider.ModeReplay:
+		if fallback, err = provider.Replay(cfg.RecordingsPath()); err != nil {
+			return nil, nil, err
+		}
+		slog.Warn("📼 Replaying recorded responses - GitHub is not contacted", "dir", cfg.RecordingsPath())
+	case provider.ModeMock:
+		if mock, err = provider.LoadMock(cfg.MockResponsesFile, cfg.MockTokenDelay); err != nil {
+			return nil, nil, err
+		}
+		fallback = mock
+		slog.Warn("🎭 Serving mock completions - GitHub is not contacted", "responses", cfg.MockResponsesFile)
+	default:
+		return nil, nil, fmt.Errorf("unknown UPSTREAM_MODE %q (use record, replay or mock)", cfg.UpstreamMode)
+	}
+	if cfg.CoalesceRequests {
+		fallback = provider.Coalesce(fallback)
+	}
+	return fallback, mock, nil
+}
+
+// authAlerts returns the chat webhooks of SLACK_WEBHOOK_URL and
+// DISCORD_WEBHOOK_URL, which hear about lost authentication
+func authAlerts(cfg *config.Config) []webhook.Spec {
+	events := []string{webhook.EventAuthRequired, webhook.EventTokenRefreshFailed}
+	var specs []webhook.Spec
+	if cfg.SlackWebhookURL != "" {
+		specs = append(specs, webhook.Spec{Name: "slack", URL: cfg.SlackWebhookURL, Format: webhook.FormatSlack, Events: events})
+	}
+	if cfg.DiscordWebhookURL != "" {
+		specs = append(specs, webhook.Spec{Name: "discord", URL: cfg.DiscordWebhookURL, Format: webhook.FormatDiscord, Events: events})
+	}
+	return specs
+}
+
+// Close releases the resources held by the server, giving the analytics
+// sinks until ctx is done to deliver queued records
+func (s *Server) Close(ctx context.Context) error {
+	s.sinks.Close(ctx)
+	s.webhooks.Close(ctx)
+	if err := s.quota.Save(); err != nil {
+		slog.Warn("Quota usage not saved", "error", err)
+	}
+	if err := s.usage.Flush(); err != nil {
+		slog.Warn("Usage history not saved", "error", err)
 	}
+	if err := s.db.Close(); err != nil {
+		slog.Warn("Database not closed cleanly", "error", err)
+	}
+	return s.audit.Close()
+}
+
+// newTranscript captures completion text when the audit log or a sink keeps it
+func (s *Server) newTranscript() *audit.Transcript {
+	if s.audit == nil && !s.sinks.IncludesText() {
+		return audit.NewTranscript(0)
+	}
+	return s.auditPolicy.NewTranscript()
 }
 
-// Router returns the HTTP router for the server
+// Router returns the HTTP router for the server. The management endpoints
+// are left to InternalRouter when INTERNAL_ADDR is set.
 func (s *Server) Router() http.Handler {
 	mux := http.NewServeMux()
+	if s.config.InternalAddr == "" {
+		s.managementRoutes(mux)
+	}
 
-	// Health check endpoint
-	mux.HandleFunc("/health", s.handleHealth)
-	
-	// Debug endpoint to get token (for testing only)
-	mux.HandleFunc("/debug/token", s.handleDebugToken)
-	
 	// Models endpoint
-	mux.HandleFunc("/v1/models", s.handleModels)
+	mux.Handle("/v1/models", s.apiHandler(http.HandlerFunc(s.handleModels)))
+
+	// Rate limit headroom of the caller
+	mux.Handle("/v1/limits", s.apiHandler(http.HandlerFunc(s.handleLimits)))
+	// Usage and estimated cost of the caller
+	mux.Handle("/v1/usage", s.apiHandler(http.HandlerFunc(s.handleUsage)))
 	
 	// Completions endpoint
-	mux.HandleFunc("/v1/completions", s.handleCompletions)
+	mux.Handle("/v1/completions", s.apiHandler(s.limitBody(s.idempotencyMiddleware(s.quotaMiddleware(s.queueMiddleware(http.HandlerFunc(s.handleCompletions)))))))
 	
 	// Chat completions endpoint (basic implementation)
-	mux.HandleFunc("/v1/chat/completions", s.handleChatCompletions)
+	mux.Handle("/v1/chat/completions", s.apiHandler(s.limitBody(s.idempotencyMiddleware(s.quotaMiddleware(s.queueMiddleware(http.HandlerFunc(s.handleChatCompletions)))))))
+
+	// Chat completions over a WebSocket; each request is queued individually
+	mux.Handle("/v1/chat/ws", s.apiHandler(http.HandlerFunc(s.handleChatWebSocket)))
+
+	// Ghost text completions for the Copilot editor plugins
+	mux.Handle("/v1/inline/completions", s.apiHandler(s.limitBody(s.quotaMiddleware(s.queueMiddleware(http.HandlerFunc(s.handleInlineCompletions))))))
+
+	// Azure OpenAI route shape for chat and completions
+	mux.Handle(azurePrefix, s.apiHandler(s.limitBody(s.idempotencyMiddleware(s.quotaMiddleware(s.queueMiddleware(http.HandlerFunc(s.handleAzure)))))))
+
+	// Stored conversations
+	if s.conversations != nil {
+		mux.Handle("/v1/conversations", s.apiHandler(s.limitBody(http.HandlerFunc(s.handleConversations))))
+		mux.Handle("/v1/conversations/", s.apiHandler(s.limitBody(http.HandlerFunc(s.handleConversation))))
+		mux.Handle("/v1/conversations/export", s.apiHandler(http.HandlerFunc(s.handleConversationsExport)))
+		mux.Handle("/v1/conversations/import", s.apiHandler(http.HandlerFunc(s.handleConversationsImport)))
+	}
+
+	// Moderation, allow-all unless MODERATION_FILE configures a classifier
+	mux.Handle("/v1/moderations", s.apiHandler(s.limitBody(http.HandlerFunc(s.handleModerations))))
+
+	// Audio, forwarded to AUDIO_UPSTREAM_URL or refused with model_not_supported
+	mux.Handle(audioPrefix, s.apiHandler(http.HandlerFunc(s.handleAudio)))
+
+	// Shared prompt templates
+	if s.prompts != nil {
+		mux.Handle("/v1/prompts", s.apiHandler(s.limitBody(http.HandlerFunc(s.handlePrompts))))
+		mux.Handle("/v1/prompts/", s.apiHandler(s.limitBody(http.HandlerFunc(s.handlePrompt))))
+	}
+
+	// Uploaded and generated files
+	if s.files != nil {
+		mux.Handle("/v1/files", s.apiHandler(http.HandlerFunc(s.handleFiles)))
+		mux.Handle("/v1/files/", s.apiHandler(http.HandlerFunc(s.handleFile)))
+	}
+
+	// Batches processed in the background
+	if s.batches != nil {
+		mux.Handle("/v1/batches", s.apiHandler(s.limitBody(http.HandlerFunc(s.handleBatches))))
+		mux.Handle("/v1/batches/", s.apiHandler(s.limitBody(http.HandlerFunc(s.handleBatch))))
+	}
 
 	// Add middleware
-	return s.loggingMiddleware(s.corsMiddleware(mux))
+	return s.wrapMiddlewares(mux)
+}
+
+// InternalRouter returns the router of the INTERNAL_ADDR listener: health,
+// metrics, admin and debug endpoints
+func (s *Server) InternalRouter() http.Handler {
+	mux := http.NewServeMux()
+	s.managementRoutes(mux)
+	return s.wrapMiddlewares(mux)
+}
+
+// managementRoutes registers the endpoints meant for operators rather than
+// API clients
+func (s *Server) managementRoutes(mux *http.ServeMux) {
+	// Health check endpoint
+	mux.HandleFunc("/health", s.handleHealth)
+	// Kubernetes style liveness and readiness probes
+	mux.HandleFunc("/health/live", s.handleLive)
+	mux.HandleFunc("/health/ready", s.handleReady)
+
+	// Prometheus metrics endpoint
+	mux.Handle("/metrics", metrics.Handler())
+
+	// Debug endpoint to get token (for testing only); it hands out the live
+	// session token, so it is an admin endpoint like the others
+	mux.Handle("/debug/token", s.adminMiddleware(http.HandlerFunc(s.handleDebugToken)))
+
+	// Admin endpoints
+	mux.Handle("/admin/maintenance", s.adminMiddleware(http.HandlerFunc(s.handleAdminMaintenance)))
+	mux.Handle("/admin/watermark", s.adminMiddleware(http.HandlerFunc(s.handleAdminWatermark)))
+	mux.Handle("/admin/probes", s.adminMiddleware(http.HandlerFunc(s.handleAdminProbes)))
+	mux.Handle("/admin/auth", s.adminMiddleware(http.HandlerFunc(s.handleAdminAuth)))
+	mux.HandleFunc("/admin/auth/ui", s.handleAdminAuthPage)
+	mux.Handle("/admin/quota", s.adminMiddleware(http.HandlerFunc(s.handleAdminQuota)))
+	mux.Handle("/admin/usage", s.adminMiddleware(http.HandlerFunc(s.handleAdminUsage)))
+	mux.Handle("/admin/usage/export", s.adminMiddleware(http.HandlerFunc(s.handleAdminUsageExport)))
+	mux.Handle("/admin/usage/records", s.adminMiddleware(http.HandlerFunc(s.handleAdminUsageRecords)))
+	mux.Handle("/admin/policy", s.adminMiddleware(http.HandlerFunc(s.handleAdminPolicy)))
+	mux.Handle("/admin/keys", s.adminMiddleware(http.HandlerFunc(s.handleAdminKeys)))
+	mux.Handle("/admin/keys/", s.adminMiddleware(http.HandlerFunc(s.handleAdminKey)))
+	mux.Handle("/admin/runtime", s.adminMiddleware(http.HandlerFunc(s.handleAdminRuntime)))
+	mux.Handle("/admin/debug/", s.adminMiddleware(debugHandler()))
+}
+
+// apiHandler applies the middleware shared by all public API endpoints
+func (s *Server) apiHandler(next http.Handler) http.Handler {
+	return s.drainMiddleware(s.maintenanceMiddleware(s.authMiddleware(s.ipLimitMiddleware(s.debugMiddleware(s.editorMiddleware(next))))))
 }
 
 // handleHealth handles health check requests
@@ -80,6 +568,7 @@ func (s *Server) handleDebugToken(w http.ResponseWriter, r *http.Request) {
 	// Return the token for manual testing
 	response := map[string]interface{}{
 		"session_token": s.copilotClient.GetCurrentSessionToken(),
+		"claims":        s.copilotClient.TokenClaims(),
 		"warning": "This is for testing only - do not expose in production",
 	}
 
@@ -96,7 +585,7 @@ func (s *Server) handleModels(w http.ResponseWriter, r *http.Request) {
 
 	ctx := r.Context()
 	
-	models, err := s.copilotClient.GetAvailableModels(ctx)
+	models, err := s.providers.GetAvailableModels(ctx)
 	if err != nil {
 		slog.Error("Failed to fetch models", "error", err)
 		errors.WriteErrorResponse(w, errors.NewInternalError("Unable to fetch models"))
@@ -105,226 +594,18 @@ func (s *Server) handleModels(w http.ResponseWriter, r *http.Request) {
 
 	slog.Info("Retrieved models from server", "count", len(models))
 
+	models = parseModelFilter(r.URL.Query()).apply(models)
+
 	response := map[string]interface{}{
 		"object": "list",
 		"data":   models, // Empty list if no models found
 	}
-
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
-}
-
-// CompletionRequest represents a completion request
-type CompletionRequest struct {
-	Prompt      string  `json:"prompt"`
-	Language    string  `json:"language,omitempty"`
-	MaxTokens   int     `json:"max_tokens,omitempty"`
-	Temperature float64 `json:"temperature,omitempty"`
-	Stream      bool    `json:"stream,omitempty"`
-}
-
-// CompletionResponse represents a completion response
-type CompletionResponse struct {
-	ID      string `json:"id"`
-	Object  string `json:"object"`
-	Created int64  `json:"created"`
-	Model   string `json:"model"`
-	Choices []struct {
-		Text         string      `json:"text"`
-		Index        int         `json:"index"`
-		FinishReason string      `json:"finish_reason"`
-		Logprobs     interface{} `json:"logprobs"`
-	} `json:"choices"`
-	Usage struct {
-		PromptTokens     int `json:"prompt_tokens"`
-		CompletionTokens int `json:"completion_tokens"`
-		TotalTokens      int `json:"total_tokens"`
-	} `json:"usage"`
-}
-
-// handleCompletions handles completion requests
-func (s *Server) handleCompletions(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
-
-	var req CompletionRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		errors.WriteErrorResponse(w, errors.NewValidationError("Invalid JSON format"))
-		return
-	}
-
-	if req.Prompt == "" {
-		errors.WriteErrorResponse(w, errors.NewValidationError("Prompt is required"))
-		return
-	}
-
-	ctx := r.Context()
-	completion, err := s.copilotClient.GetCompletion(ctx, &copilot.CompletionRequest{
-		Prompt:      req.Prompt,
-		Language:    req.Language,
-		MaxTokens:   req.MaxTokens,
-		Temperature: req.Temperature,
-		Stream:      req.Stream,
-	})
-	if err != nil {
-		if apiErr, ok := err.(*errors.APIError); ok {
-			errors.WriteErrorResponse(w, apiErr)
-		} else {
-			errors.WriteErrorResponse(w, errors.NewInternalError(err.Error()))
-		}
-		return
-	}
-
-	// Create OpenAI-compatible response
-	response := CompletionResponse{
-		ID:      generateID(),
-		Object:  "text_completion",
-		Created: time.Now().Unix(),
-		Model:   "copilot-codex",
-		Choices: []struct {
-			Text         string      `json:"text"`
-			Index        int         `json:"index"`
-			FinishReason string      `json:"finish_reason"`
-			Logprobs     interface{} `json:"logprobs"`
-		}{
-			{
-				Text:         completion,
-				Index:        0,
-				FinishReason: "stop",
-				Logprobs:     nil,
-			},
-		},
-		Usage: struct {
-			PromptTokens     int `json:"prompt_tokens"`
-			CompletionTokens int `json:"completion_tokens"`
-			TotalTokens      int `json:"total_tokens"`
-		}{
-			PromptTokens:     estimateTokens(req.Prompt),
-			CompletionTokens: estimateTokens(completion),
-			TotalTokens:      estimateTokens(req.Prompt) + estimateTokens(completion),
-		},
-	}
-
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
-}
-
-// ChatMessage represents a chat message
-type ChatMessage struct {
-	Role    string `json:"role"`
-	Content string `json:"content"`
-}
-
-// ChatCompletionRequest represents a chat completion request
-type ChatCompletionRequest struct {
-	Model       string        `json:"model,omitempty"`
-	Messages    []ChatMessage `json:"messages"`
-	MaxTokens   int           `json:"max_tokens,omitempty"`
-	Temperature float64       `json:"temperature,omitempty"`
-	Stream      bool          `json:"stream,omitempty"`
-}
-
-// ChatCompletionResponse represents a chat completion response
-type ChatCompletionResponse struct {
-	ID      string `json:"id"`
-	Object  string `json:"object"`
-	Created int64  `json:"created"`
-	Model   string `json:"model"`
-	Choices []struct {
-		Index   int `json:"index"`
-		Message struct {
-			Role    string `json:"role"`
-			Content string `json:"content"`
-		} `json:"message"`
-		FinishReason string `json:"finish_reason"`
-	} `json:"choices"`
-	Usage struct {
-		PromptTokens     int `json:"prompt_tokens"`
-		CompletionTokens int `json:"completion_tokens"`
-		TotalTokens      int `json:"total_tokens"`
-	} `json:"usage"`
-}
-
-// handleChatCompletions handles chat completion requests
-func (s *Server) handleChatCompletions(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
-
-	var req ChatCompletionRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		errors.WriteErrorResponse(w, errors.NewValidationError("Invalid JSON format"))
-		return
-	}
-
-	if len(req.Messages) == 0 {
-		errors.WriteErrorResponse(w, errors.NewValidationError("Messages are required"))
-		return
-	}
-
-	// Convert chat messages to a simple prompt
-	var prompt string
-	for _, msg := range req.Messages {
-		if msg.Role == "user" {
-			prompt += msg.Content + "\n"
-		}
-	}
-
-	ctx := r.Context()
-	completion, err := s.copilotClient.GetCompletion(ctx, &copilot.CompletionRequest{
-		Prompt:      prompt,
-		Language:    "text",
-		MaxTokens:   req.MaxTokens,
-		Temperature: req.Temperature,
-		Stream:      req.Stream,
-	})
-	if err != nil {
-		if apiErr, ok := err.(*errors.APIError); ok {
-			errors.WriteErrorResponse(w, apiErr)
-		} else {
-			errors.WriteErrorResponse(w, errors.NewInternalError(err.Error()))
+	if s.config.StrictCompat {
+		response["data"] = strictModels(models)
+		if err := s.checkStrict(response); err != nil {
+			writeError(w, err)
+			return
 		}
-		return
-	}
-
-	// Create OpenAI-compatible response
-	response := ChatCompletionResponse{
-		ID:      generateID(),
-		Object:  "chat.completion",
-		Created: time.Now().Unix(),
-		Model:   getDefaultOrString(req.Model, "gpt-4"),
-		Choices: []struct {
-			Index   int `json:"index"`
-			Message struct {
-				Role    string `json:"role"`
-				Content string `json:"content"`
-			} `json:"message"`
-			FinishReason string `json:"finish_reason"`
-		}{
-			{
-				Index: 0,
-				Message: struct {
-					Role    string `json:"role"`
-					Content string `json:"content"`
-				}{
-					Role:    "assistant",
-					Content: completion,
-				},
-				FinishReason: "stop",
-			},
-		},
-		Usage: struct {
-			PromptTokens     int `json:"prompt_tokens"`
-			CompletionTokens int `json:"completion_tokens"`
-			TotalTokens      int `json:"total_tokens"`
-		}{
-			PromptTokens:     estimateTokens(prompt),
-			CompletionTokens: estimateTokens(completion),
-			TotalTokens:      estimateTokens(prompt) + estimateTokens(completion),
-		},
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -333,12 +614,21 @@ func (s *Server) handleChatCompletions(w http.ResponseWriter, r *http.Request) {
 
 // Helper functions
 func generateID() string {
-	return "reai-" + string(rune(time.Now().UnixNano()))
+	// IDs are unique so watermarks can be traced back to a single response
+	buf := make([]byte, 12)
+	if _, err := rand.Read(buf); err != nil {
+		return fmt.Sprintf("reai-%x", time.Now().UnixNano())
+	}
+	return "reai-" + hex.EncodeToString(buf)
 }
 
 func estimateTokens(text string) int {
+	return estimateTokenCount(len(text))
+}
+
+func estimateTokenCount(length int) int {
 	// Simple token estimation (roughly 4 characters per token)
-	return len(text) / 4
+	return length / 4
 }
 
 func getDefaultOrString(value, defaultValue string) string {
@@ -347,3 +637,16 @@ func getDefaultOrString(value, defaultValue string) string {
 	}
 	return value
 }
+
+func stringPtr(value string) *string {
+	return &value
+}
+
+// writeError writes err as an API error response
+func writeError(w http.ResponseWriter, err error) {
+	if apiErr, ok := err.(*errors.APIError); ok {
+		errors.WriteErrorResponse(w, apiErr)
+	} else {
+		errors.WriteErrorResponse(w, errors.NewInternalError(err.Error()))
+	}
+}
@@ -0,0 +1,161 @@
+package api
+
+import (
+	"fmt"
+	"log/slog"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/devstroop/reai/internal/metrics"
+	"github.com/devstroop/reai/internal/queue"
+	"github.com/devstroop/reai/pkg/errors"
+)
+
+const (
+	// shedSampleInterval bounds how often the heap and goroutines are sampled
+	shedSampleInterval = time.Second
+	// shedSevereFactor is the multiple of a threshold past which normal
+	// priority requests are shed too
+	shedSevereFactor = 1.5
+	// shedRecoverFactor is the fraction of a threshold usage must fall below
+	// before a shedding level ends, so it doesn't flap around the threshold
+	shedRecoverFactor = 0.9
+	// shedRetryAfter is what shed requests are told to wait
+	shedRetryAfter = 5 * time.Second
+)
+
+var (
+	shedRequests = metrics.NewCounterVec("reai_shed_requests_total", "Requests turned away under memory or goroutine pressure", "priority")
+	shedLevel    = metrics.NewGauge("reai_shed_level", "Load shedding level: 0 none, 1 low priority requests shed, 2 normal priority ones too")
+)
+
+// loadShedder turns requests away by priority while the process is under
+// memory or goroutine pressure, so a flood of background requests degrades
+// the service instead of running it out of memory. High priority requests
+// are never shed.
+type loadShedder struct {
+	maxHeap       uint64
+	maxGoroutines int
+
+	mutex      sync.Mutex
+	sampled    time.Time
+	level      int
+	heap       uint64
+	goroutines int
+}
+
+// newLoadShedder returns a shedder for the SHED_* thresholds, nil when none
+// is set
+func newLoadShedder(maxHeap int64, maxGoroutines int) *loadShedder {
+	if maxHeap <= 0 && maxGoroutines <= 0 {
+		return nil
+	}
+	return &loadShedder{maxHeap: uint64(max(maxHeap, 0)), maxGoroutines: max(maxGoroutines, 0)}
+}
+
+// current returns the shedding level, sampling the process at most once per
+// shedSampleInterval
+func (l *loadShedder) current(now time.Time) int {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if now.Sub(l.sampled) < shedSampleInterval {
+		return l.level
+	}
+	l.sampled = now
+
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	l.heap, l.goroutines = stats.HeapAlloc, runtime.NumGoroutine()
+
+	level := l.levelFor(l.pressure())
+	switch {
+	case level > l.level:
+		slog.Warn("Shedding requests under load", "priorities", strings.Join(shedPriorities(level), ","), "heap_bytes", l.heap, "goroutines", l.goroutines)
+	case level < l.level:
+		slog.Info("Load eased, shedding fewer requests", "priorities", strings.Join(shedPriorities(level), ","), "heap_bytes", l.heap, "goroutines", l.goroutines)
+	}
+	l.level = level
+	shedLevel.Set(float64(level))
+	return level
+}
+
+// pressure returns the highest sampled usage as a fraction of its threshold.
+// The caller holds the mutex.
+func (l *loadShedder) pressure() float64 {
+	pressure := 0.0
+	if l.maxHeap > 0 {
+		pressure = max(pressure, float64(l.heap)/float64(l.maxHeap))
+	}
+	if l.maxGoroutines > 0 {
+		pressure = max(pressure, float64(l.goroutines)/float64(l.maxGoroutines))
+	}
+	return pressure
+}
+
+// levelFor maps pressure to a shedding level. A level is entered at its
+// threshold and left only below shedRecoverFactor of it. The caller holds
+// the mutex.
+func (l *loadShedder) levelFor(pressure float64) int {
+	level := 0
+	for i, threshold := range []float64{1, shedSevereFactor} {
+		if pressure >= threshold || (l.level > i && pressure >= threshold*shedRecoverFactor) {
+			level = i + 1
+		}
+	}
+	return level
+}
+
+// shedPriorities lists the priority classes shed at level
+func shedPriorities(level int) []string {
+	shed := []string{}
+	if level >= 1 {
+		shed = append(shed, string(queue.Low))
+	}
+	if level >= 2 {
+		shed = append(shed, string(queue.Normal))
+	}
+	return shed
+}
+
+// sheds reports whether requests of priority are shed at level
+func sheds(level int, priority queue.Priority) bool {
+	switch priority {
+	case queue.Low:
+		return level >= 1
+	case queue.Normal:
+		return level >= 2
+	}
+	return false
+}
+
+// check reports the shedding state for /health/ready. It fails once normal
+// priority requests are shed, so load balancers send traffic elsewhere.
+func (l *loadShedder) check(now time.Time) HealthCheck {
+	level := l.current(now)
+	l.mutex.Lock()
+	detail := fmt.Sprintf("heap %d bytes, %d goroutines", l.heap, l.goroutines)
+	l.mutex.Unlock()
+
+	result := HealthCheck{Name: "load", Status: checkOK, Detail: detail}
+	if level > 0 {
+		result.Detail = "shedding " + strings.Join(shedPriorities(level), " and ") + " priority requests: " + detail
+	}
+	if level >= 2 {
+		result.Status = checkFail
+	}
+	return result
+}
+
+// shed returns the error turning away a request of priority, nil when it may
+// be served
+func (s *Server) shed(priority queue.Priority) *errors.APIError {
+	if s.shedder == nil || !sheds(s.shedder.current(time.Now()), priority) {
+		return nil
+	}
+	shedRequests.With(priority.String()).Inc()
+	apiErr := errors.NewOverloadedError(fmt.Sprintf("ReAI is overloaded and sheds %s priority requests, please retry later", priority))
+	apiErr.RetryAfter = shedRetryAfter
+	return apiErr
+}
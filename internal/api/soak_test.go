@@ -0,0 +1,162 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestStreamSoak streams chat completions from the mock backend, abandoning
+// some mid-stream, and checks that the goroutine and file descriptor counts
+// return to their baseline. It runs 200 requests by default and
+// SOAK_REQUESTS for a longer soak; -short skips it.
+func TestStreamSoak(t *testing.T) {
+	if testing.Short() {
+		t.Skip("soak test skipped in short mode")
+	}
+	requests, concurrency, tolerance := 200, 32, 4
+	if n, err := strconv.Atoi(os.Getenv("SOAK_REQUESTS")); err == nil && n > 0 {
+		requests = n
+	}
+
+	server := newMockServer(t, map[string]string{"DEV_MODE": "true"})
+	ts := httptest.NewServer(server.Router())
+	defer ts.Close()
+
+	httpClient := &http.Client{Transport: &http.Transport{MaxIdleConnsPerHost: concurrency}}
+	// A first round starts whatever the server starts lazily before the
+	// baseline is taken
+	for n := 0; n < concurrency; n++ {
+		if err := soakStream(httpClient, ts.URL, n, false); err != nil {
+			t.Fatalf("warm-up request: %v", err)
+		}
+	}
+	httpClient.CloseIdleConnections()
+	baseline := settledCounts(t, soakCounts{}, 0, 5*time.Second)
+
+	jobs := make(chan int)
+	errs := make(chan error, requests)
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := range jobs {
+				// Every fourth stream is abandoned after the first chunk
+				if err := soakStream(httpClient, ts.URL, n, n%4 == 0); err != nil {
+					errs <- fmt.Errorf("request %d: %w", n, err)
+				}
+			}
+		}()
+	}
+	for n := 0; n < requests; n++ {
+		jobs <- n
+	}
+	close(jobs)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+
+	// Our own keep-alive connections would count against the server
+	httpClient.CloseIdleConnections()
+	current := settledCounts(t, baseline, tolerance, 30*time.Second)
+	t.Logf("%d requests: %d goroutines and %d file descriptors, %d and %d before", requests, current.goroutines, current.fds, baseline.goroutines, baseline.fds)
+	if current.goroutines > baseline.goroutines+tolerance {
+		buf := make([]byte, 1<<20)
+		t.Errorf("goroutines went from %d to %d\n%s", baseline.goroutines, current.goroutines, buf[:runtime.Stack(buf, true)])
+	}
+	if current.fds > baseline.fds+tolerance {
+		t.Errorf("open file descriptors went from %d to %d", baseline.fds, current.fds)
+	}
+}
+
+// soakCounts are the values expected to return to baseline
+type soakCounts struct {
+	goroutines int
+	fds        int
+}
+
+// settledCounts waits up to timeout for the counts to be within tolerance
+// of target, or to stop changing when target is zero, and returns the last
+// counts read
+func settledCounts(t *testing.T, target soakCounts, tolerance int, timeout time.Duration) soakCounts {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	var previous soakCounts
+	for {
+		current := soakCounts{goroutines: runtime.NumGoroutine(), fds: openFDCount(t)}
+		settled := current == previous
+		if target != (soakCounts{}) {
+			settled = current.goroutines <= target.goroutines+tolerance && current.fds <= target.fds+tolerance
+		}
+		if settled || time.Now().After(deadline) {
+			return current
+		}
+		previous = current
+		time.Sleep(250 * time.Millisecond)
+	}
+}
+
+// openFDCount counts the entries of /proc/self/fd
+func openFDCount(t *testing.T) int {
+	t.Helper()
+	entries, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		t.Skip("/proc/self/fd is not available")
+	}
+	return len(entries)
+}
+
+// soakStream sends a streamed chat completion and reads it to the end, or
+// abandons it after the first chunk
+func soakStream(client *http.Client, url string, n int, abandon bool) error {
+	body, _ := json.Marshal(map[string]interface{}{
+		"messages": []map[string]string{{"role": "user", "content": fmt.Sprintf("Count from 1 to 20 (soak request %d)", n)}},
+		"stream":   true,
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url+"/v1/chat/completions", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("status %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if abandon && strings.HasPrefix(line, "data: ") {
+			return nil
+		}
+		if line == "data: [DONE]" {
+			return nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return fmt.Errorf("stream ended without [DONE]")
+}
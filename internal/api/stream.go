@@ -0,0 +1,281 @@
+package api
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	stderrors "errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/devstroop/reai/pkg/errors"
+	"github.com/klauspost/compress/zstd"
+)
+
+// errClientGone is returned once the client stops reading the stream. It wraps
+// context.Canceled so the upstream request is abandoned and the exchange is
+// recorded as cancelled by the client.
+var errClientGone = fmt.Errorf("client disconnected: %w", context.Canceled)
+
+// heartbeatComment is the SSE comment sent to keep idle streams open
+const heartbeatComment = ": ping\n\n"
+
+// sseWriter writes server-sent events. Headers are sent by Open, or lazily
+// with the first event when the stream isn't opened, so errors raised before
+// any output can still be returned as plain JSON.
+type sseWriter struct {
+	// mutex serializes the heartbeat with the events
+	mutex   sync.Mutex
+	w       http.ResponseWriter
+	flusher http.Flusher
+	started bool
+	closed  bool
+
+	// heartbeat is the keep-alive interval (0 disables it); lastWrite is when
+	// anything was last written
+	heartbeat time.Duration
+	lastWrite time.Time
+	stop      chan struct{}
+
+	// encoding is the compression the client accepted, if any; encoder is
+	// created with the headers and flushed after every event so nothing is
+	// held back
+	encoding string
+	encoder  streamEncoder
+
+	// check vets every event before it is sent (strict mode)
+	check func(interface{}) error
+}
+
+// streamEncoder is a compressor that can emit what it was given so far as a
+// complete block, like gzip.Writer and zstd.Encoder
+type streamEncoder interface {
+	io.Writer
+	Flush() error
+	Close() error
+}
+
+// newStreamEncoder creates the compressor of an event stream. The zstd window
+// is kept small to bound the memory of each open stream, since events are
+// small and flushed one by one anyway.
+func newStreamEncoder(w io.Writer, encoding string) streamEncoder {
+	if encoding == "zstd" {
+		encoder, _ := zstd.NewWriter(w, zstd.WithEncoderLevel(zstd.SpeedFastest), zstd.WithEncoderConcurrency(1),
+			zstd.WithWindowSize(1<<17), zstd.WithLowerEncoderMem(true))
+		return encoder
+	}
+	return gzip.NewWriter(w)
+}
+
+// newSSEWriter creates an event stream writer for the response to r. With
+// STREAM_COMPRESSION the stream is compressed with zstd or gzip, in that
+// order of preference, if the client accepts it.
+func (s *Server) newSSEWriter(w http.ResponseWriter, r *http.Request) *sseWriter {
+	flusher, _ := w.(http.Flusher)
+	stream := &sseWriter{w: w, flusher: flusher, check: s.checkStrict, heartbeat: s.config.StreamHeartbeat}
+	if !s.config.StreamCompression {
+		return stream
+	}
+	accepted := r.Header.Get("Accept-Encoding")
+	switch {
+	case acceptsEncoding(accepted, "zstd"):
+		stream.encoding = "zstd"
+	case acceptsEncoding(accepted, "gzip"):
+		stream.encoding = "gzip"
+	}
+	return stream
+}
+
+// acceptsEncoding reports whether an Accept-Encoding header allows a content
+// coding (gzip also matches x-gzip). An explicit entry takes precedence over
+// "*"; q=0 rules a coding out.
+func acceptsEncoding(header, coding string) bool {
+	anyOK := false
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(part, ";")
+		acceptable := true
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			q, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+			acceptable = err == nil && q > 0
+		}
+		name = strings.ToLower(strings.TrimSpace(name))
+		switch {
+		case name == coding, coding == "gzip" && name == "x-gzip":
+			return acceptable
+		case name == "*":
+			anyOK = acceptable
+		}
+	}
+	return anyOK
+}
+
+// Open starts the stream once the response headers are set. The headers are
+// flushed right away, so the client sees 200 before the first token, and the
+// server's write timeout is lifted, since a long generation outlives it.
+// Errors after that are reported as error events. With heartbeats enabled a
+// comment is sent whenever the stream has been idle for the heartbeat
+// interval until it ends or ctx is done.
+func (s *sseWriter) Open(ctx context.Context) {
+	http.NewResponseController(s.w).SetWriteDeadline(time.Time{})
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.start()
+	s.lastWrite = time.Now()
+	if s.flusher != nil {
+		s.flusher.Flush()
+	}
+	if s.heartbeat <= 0 {
+		return
+	}
+	s.stop = make(chan struct{})
+	go s.keepAlive(ctx)
+}
+
+// keepAlive sends heartbeat comments while the stream is idle
+func (s *sseWriter) keepAlive(ctx context.Context) {
+	ticker := time.NewTicker(s.heartbeat)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.stop:
+			return
+		case now := <-ticker.C:
+			s.mutex.Lock()
+			if !s.closed && now.Sub(s.lastWrite) >= s.heartbeat {
+				s.writeRaw(heartbeatComment)
+			}
+			s.mutex.Unlock()
+		}
+	}
+}
+
+// start sends the event stream headers
+func (s *sseWriter) start() {
+	if s.started {
+		return
+	}
+	s.started = true
+
+	s.w.Header().Set("Content-Type", "text/event-stream")
+	s.w.Header().Set("Cache-Control", "no-cache")
+	s.w.Header().Set("Connection", "keep-alive")
+	s.w.Header().Set("X-Accel-Buffering", "no")
+	if s.encoding != "" {
+		s.encoder = newStreamEncoder(s.w, s.encoding)
+		s.w.Header().Set("Content-Encoding", s.encoding)
+		s.w.Header().Add("Vary", "Accept-Encoding")
+		s.w.Header().Del("Content-Length")
+	}
+	s.w.WriteHeader(http.StatusOK)
+}
+
+// Send writes a JSON encoded data event and flushes it to the client
+func (s *sseWriter) Send(v interface{}) error {
+	if s.check != nil {
+		if err := s.check(v); err != nil {
+			return err
+		}
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.write(data)
+}
+
+// Done terminates the stream the way OpenAI clients expect
+func (s *sseWriter) Done() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.write([]byte("[DONE]"))
+	s.close()
+}
+
+// Fail reports an error. Before the stream started this is a regular JSON error
+// response; afterwards it is sent as a final error event. Nothing is written
+// when the client cancelled the request.
+func (s *sseWriter) Fail(err error) {
+	if stderrors.Is(err, context.Canceled) {
+		slog.Debug("Stream cancelled by client", "error", err)
+		s.mutex.Lock()
+		s.close()
+		s.mutex.Unlock()
+		return
+	}
+	apiErr := errors.WrapError(err)
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if !s.started {
+		errors.WriteErrorResponse(s.w, apiErr)
+		s.close()
+		return
+	}
+
+	slog.Warn("Stream aborted", "error", err)
+	if data, err := json.Marshal(map[string]interface{}{"error": apiErr}); err == nil {
+		s.write(data)
+	}
+	s.close()
+}
+
+// write sends a data event. The caller holds the mutex.
+func (s *sseWriter) write(data []byte) error {
+	return s.writeRaw(fmt.Sprintf("data: %s\n\n", data))
+}
+
+// writeRaw sends text as is and flushes it. The caller holds the mutex.
+func (s *sseWriter) writeRaw(text string) error {
+	if s.closed {
+		return errClientGone
+	}
+	s.start()
+	if s.encoder != nil {
+		// Flush emits a complete deflate or zstd block, so the client can
+		// decode the event without waiting for more data
+		if _, err := s.encoder.Write([]byte(text)); err != nil {
+			return errClientGone
+		}
+		if err := s.encoder.Flush(); err != nil {
+			return errClientGone
+		}
+	} else if _, err := s.w.Write([]byte(text)); err != nil {
+		return errClientGone
+	}
+	if s.flusher != nil {
+		s.flusher.Flush()
+	}
+	s.lastWrite = time.Now()
+	return nil
+}
+
+// close stops the heartbeat and ends a compressed stream with its trailer.
+// The caller holds the mutex.
+func (s *sseWriter) close() {
+	if s.closed {
+		return
+	}
+	s.closed = true
+	if s.stop != nil {
+		close(s.stop)
+	}
+	if s.encoder == nil {
+		return
+	}
+	s.encoder.Close()
+	if s.flusher != nil {
+		s.flusher.Flush()
+	}
+}
@@ -0,0 +1,55 @@
+package api
+
+import (
+	"sync"
+	"time"
+
+	"github.com/devstroop/reai/internal/metrics"
+)
+
+// maxLatencyModels bounds the model label values of the stream latency
+// histograms, since the model is named by the client; later models are
+// recorded as "other"
+const maxLatencyModels = 64
+
+var (
+	streamFirstToken = metrics.NewHistogramVec("reai_stream_time_to_first_token_seconds", "Time from the start of a streamed completion to its first content, by model",
+		[]float64{0.05, 0.1, 0.25, 0.5, 0.75, 1, 1.5, 2, 3, 5, 10, 30}, "model")
+	streamInterChunk = metrics.NewHistogramVec("reai_stream_inter_chunk_seconds", "Time between the content chunks of streamed completions, by model",
+		[]float64{0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5}, "model")
+)
+
+// latencyModels are the model label values in use
+var latencyModels = struct {
+	sync.Mutex
+	seen map[string]bool
+}{seen: make(map[string]bool)}
+
+// latencyModel returns the label value of a model
+func latencyModel(model string) string {
+	if model == "" {
+		return "default"
+	}
+	latencyModels.Lock()
+	defer latencyModels.Unlock()
+	if !latencyModels.seen[model] {
+		if len(latencyModels.seen) >= maxLatencyModels {
+			return "other"
+		}
+		latencyModels.seen[model] = true
+	}
+	return model
+}
+
+// chunkSent records the pace of a stream once content, or reasoning, has
+// been sent to the client: the time to the first chunk, then the gaps
+// between chunks
+func (e *exchange) chunkSent() {
+	now := time.Now()
+	if e.lastChunk.IsZero() {
+		streamFirstToken.With(latencyModel(e.model)).Observe(now.Sub(e.start).Seconds())
+	} else {
+		streamInterChunk.With(latencyModel(e.model)).Observe(now.Sub(e.lastChunk).Seconds())
+	}
+	e.lastChunk = now
+}
@@ -0,0 +1,185 @@
+package api
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/devstroop/reai/internal/config"
+	"github.com/klauspost/compress/zstd"
+)
+
+// readEvent reads one server-sent event the way SDK stream parsers do:
+// lines up to a blank one, with the data of "data:" lines joined and
+// comments skipped
+func readEvent(r *bufio.Reader) (string, error) {
+	var data []string
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return "", err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		switch {
+		case line == "" && len(data) > 0:
+			return strings.Join(data, "\n"), nil
+		case line == "", strings.HasPrefix(line, ":"):
+		case strings.HasPrefix(line, "data:"):
+			data = append(data, strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " "))
+		}
+	}
+}
+
+// TestCompressedStreamEvents checks that every event of a compressed stream
+// can be decoded as soon as it is sent, before the next one is written, and
+// that the stream ends cleanly
+func TestCompressedStreamEvents(t *testing.T) {
+	decoders := map[string]func(io.Reader) (io.Reader, error){
+		"": func(r io.Reader) (io.Reader, error) { return r, nil },
+		"gzip": func(r io.Reader) (io.Reader, error) {
+			return gzip.NewReader(r)
+		},
+		"zstd": func(r io.Reader) (io.Reader, error) {
+			d, err := zstd.NewReader(r)
+			if err != nil {
+				return nil, err
+			}
+			return d.IOReadCloser(), nil
+		},
+	}
+	const events = 20
+
+	for encoding, decode := range decoders {
+		t.Run("encoding="+encoding, func(t *testing.T) {
+			s := &Server{config: &config.Config{StreamCompression: true}}
+			// The handler sends an event only once the client read the previous one
+			ack := make(chan struct{})
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				stream := s.newSSEWriter(w, r)
+				for i := 0; i < events; i++ {
+					if err := stream.Send(map[string]int{"n": i}); err != nil {
+						return
+					}
+					select {
+					case <-ack:
+					case <-time.After(5 * time.Second):
+						return
+					}
+				}
+				stream.Done()
+			}))
+			defer ts.Close()
+
+			req, _ := http.NewRequest(http.MethodPost, ts.URL, nil)
+			if encoding != "" {
+				req.Header.Set("Accept-Encoding", encoding)
+			}
+			client := &http.Client{Transport: &http.Transport{DisableCompression: true}, Timeout: 10 * time.Second}
+			resp, err := client.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			if got := resp.Header.Get("Content-Encoding"); got != encoding {
+				t.Fatalf("Content-Encoding %q, want %q", got, encoding)
+			}
+			if got := resp.Header.Get("Content-Type"); got != "text/event-stream" {
+				t.Fatalf("Content-Type %q", got)
+			}
+
+			body, err := decode(resp.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			reader := bufio.NewReader(body)
+			for i := 0; i < events; i++ {
+				data, err := readEvent(reader)
+				if err != nil {
+					t.Fatalf("event %d: %v", i, err)
+				}
+				if want := fmt.Sprintf(`{"n":%d}`, i); data != want {
+					t.Fatalf("event %d: got %s, want %s", i, data, want)
+				}
+				ack <- struct{}{}
+			}
+			if data, err := readEvent(reader); err != nil || data != "[DONE]" {
+				t.Fatalf("got %q, %v, want [DONE]", data, err)
+			}
+			// The compressed stream is terminated properly, without trailing data
+			if rest, err := io.ReadAll(reader); err != nil || len(rest) > 0 {
+				t.Fatalf("after [DONE]: %q, %v", rest, err)
+			}
+		})
+	}
+}
+
+func TestStreamEncodingNegotiation(t *testing.T) {
+	tests := []struct {
+		accept string
+		want   string
+	}{
+		{"", ""},
+		{"gzip", "gzip"},
+		{"gzip, deflate, br, zstd", "zstd"},
+		{"zstd;q=0, gzip", "gzip"},
+		{"x-gzip", "gzip"},
+		{"identity", ""},
+		{"*", "zstd"},
+		{"*, zstd;q=0", "gzip"},
+	}
+	s := &Server{config: &config.Config{StreamCompression: true}}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodPost, "/", nil)
+		r.Header.Set("Accept-Encoding", tt.accept)
+		if got := s.newSSEWriter(httptest.NewRecorder(), r).encoding; got != tt.want {
+			t.Errorf("Accept-Encoding %q: got %q, want %q", tt.accept, got, tt.want)
+		}
+	}
+}
+
+// TestStreamOutlivesWriteTimeout checks that an opened stream has its
+// headers flushed before the first event and isn't cut off by the server's
+// write timeout
+func TestStreamOutlivesWriteTimeout(t *testing.T) {
+	s := &Server{config: &config.Config{}}
+	opened := make(chan struct{})
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		stream := s.newSSEWriter(w, r)
+		stream.Open(r.Context())
+		<-opened
+		for i := 0; i < 5; i++ {
+			time.Sleep(100 * time.Millisecond)
+			if err := stream.Send(map[string]int{"n": i}); err != nil {
+				return
+			}
+		}
+		stream.Done()
+	}))
+	ts.Config.WriteTimeout = 200 * time.Millisecond
+	ts.Start()
+	defer ts.Close()
+
+	resp, err := http.Post(ts.URL, "application/json", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	// The headers arrived before any event was sent
+	close(opened)
+
+	reader := bufio.NewReader(resp.Body)
+	for i := 0; i < 5; i++ {
+		if _, err := readEvent(reader); err != nil {
+			t.Fatalf("event %d: %v", i, err)
+		}
+	}
+	if data, err := readEvent(reader); err != nil || data != "[DONE]" {
+		t.Fatalf("got %q, %v, want [DONE]", data, err)
+	}
+}
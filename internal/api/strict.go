@@ -0,0 +1,284 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/devstroop/reai/internal/copilot"
+	"github.com/devstroop/reai/internal/metrics"
+	"github.com/devstroop/reai/pkg/errors"
+)
+
+var strictViolations = metrics.NewCounterVec("reai_strict_violations_total", "Responses that did not match the OpenAI schema in strict mode, by object", "object")
+
+// Request fields of the OpenAI API. Fields ReAI does not support upstream are
+// listed too: strict mode only rejects what OpenAI itself would reject.
+var (
+	chatRequestFields = fieldSet(
+		"model", "messages", "audio", "frequency_penalty", "function_call", "functions",
+		"logit_bias", "logprobs", "max_completion_tokens", "max_tokens", "metadata",
+		"modalities", "n", "parallel_tool_calls", "prediction", "presence_penalty",
+		"prompt_cache_key", "reasoning_effort", "response_format", "safety_identifier",
+		"seed", "service_tier", "stop", "store", "stream", "stream_options", "temperature",
+		"tool_choice", "tools", "top_logprobs", "top_p", "user", "verbosity", "web_search_options",
+	)
+	completionRequestFields = fieldSet(
+		"model", "prompt", "best_of", "echo", "frequency_penalty", "logit_bias", "logprobs",
+		"max_tokens", "n", "presence_penalty", "seed", "stop", "stream", "stream_options",
+		"suffix", "temperature", "top_p", "user",
+	)
+	moderationRequestFields = fieldSet("input", "model")
+)
+
+func fieldSet(names ...string) map[string]bool {
+	set := make(map[string]bool, len(names))
+	for _, name := range names {
+		set[name] = true
+	}
+	return set
+}
+
+// decodeRequest decodes a JSON request body into v. In strict mode fields
+// outside the OpenAI API are rejected the way OpenAI rejects them.
+func (s *Server) decodeRequest(r *http.Request, fields map[string]bool, v interface{}) error {
+	if !s.config.StrictCompat {
+		return s.decodeJSON(r, v)
+	}
+
+	body, err := s.readJSON(r)
+	if err != nil {
+		return err
+	}
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(body, &raw); err != nil {
+		return errors.NewValidationError("Invalid JSON format")
+	}
+	var unknown []string
+	for name := range raw {
+		if !fields[name] {
+			unknown = append(unknown, name)
+		}
+	}
+	switch len(unknown) {
+	case 0:
+	case 1:
+		return errors.NewValidationError("Unrecognized request argument supplied: " + unknown[0])
+	default:
+		sort.Strings(unknown)
+		return errors.NewValidationError("Unrecognized request arguments supplied: " + strings.Join(unknown, ", "))
+	}
+	if err := json.Unmarshal(body, v); err != nil {
+		return errors.NewValidationError("Invalid JSON format")
+	}
+	return nil
+}
+
+// schema lists the fields an OpenAI object may have
+type schema map[string]schemaField
+
+type schemaField struct {
+	required bool
+	// fields describes a nested object, or the elements of an array of objects
+	fields schema
+}
+
+func requiredField(fields schema) schemaField { return schemaField{required: true, fields: fields} }
+func optionalField(fields schema) schemaField { return schemaField{fields: fields} }
+
+var (
+	usageSchema = schema{
+		"prompt_tokens":             requiredField(nil),
+		"completion_tokens":         requiredField(nil),
+		"total_tokens":              requiredField(nil),
+		"prompt_tokens_details":     optionalField(nil),
+		"completion_tokens_details": optionalField(nil),
+	}
+	chatLogprobsSchema = schema{
+		"content": requiredField(schema{
+			"token":   requiredField(nil),
+			"logprob": requiredField(nil),
+			"bytes":   requiredField(nil),
+			"top_logprobs": requiredField(schema{
+				"token":   requiredField(nil),
+				"logprob": requiredField(nil),
+				"bytes":   requiredField(nil),
+			}),
+		}),
+		"refusal": optionalField(nil),
+	}
+
+	// responseSchemas are keyed by the object field of the response
+	responseSchemas = map[string]schema{
+		"chat.completion": {
+			"id":      requiredField(nil),
+			"object":  requiredField(nil),
+			"created": requiredField(nil),
+			"model":   requiredField(nil),
+			"choices": requiredField(schema{
+				"index": requiredField(nil),
+				"message": requiredField(schema{
+					"role":          requiredField(nil),
+					"content":       requiredField(nil),
+					"refusal":       optionalField(nil),
+					"tool_calls":    optionalField(nil),
+					"function_call": optionalField(nil),
+					"annotations":   optionalField(nil),
+					"audio":         optionalField(nil),
+				}),
+				"logprobs":      requiredField(chatLogprobsSchema),
+				"finish_reason": requiredField(nil),
+			}),
+			"usage":              optionalField(usageSchema),
+			"system_fingerprint": optionalField(nil),
+			"service_tier":       optionalField(nil),
+		},
+		"chat.completion.chunk": {
+			"id":      requiredField(nil),
+			"object":  requiredField(nil),
+			"created": requiredField(nil),
+			"model":   requiredField(nil),
+			"choices": requiredField(schema{
+				"index": requiredField(nil),
+				"delta": requiredField(schema{
+					"role":          optionalField(nil),
+					"content":       optionalField(nil),
+					"refusal":       optionalField(nil),
+					"tool_calls":    optionalField(nil),
+					"function_call": optionalField(nil),
+				}),
+				"logprobs":      optionalField(chatLogprobsSchema),
+				"finish_reason": requiredField(nil),
+			}),
+			"usage":              optionalField(usageSchema),
+			"system_fingerprint": optionalField(nil),
+			"service_tier":       optionalField(nil),
+		},
+		"text_completion": {
+			"id":      requiredField(nil),
+			"object":  requiredField(nil),
+			"created": requiredField(nil),
+			"model":   requiredField(nil),
+			"choices": requiredField(schema{
+				"text":  requiredField(nil),
+				"index": requiredField(nil),
+				"logprobs": requiredField(schema{
+					"tokens":         requiredField(nil),
+					"token_logprobs": requiredField(nil),
+					"top_logprobs":   requiredField(nil),
+					"text_offset":    requiredField(nil),
+				}),
+				"finish_reason": requiredField(nil),
+			}),
+			"usage":              optionalField(usageSchema),
+			"system_fingerprint": optionalField(nil),
+		},
+		"list": {
+			"object": requiredField(nil),
+			"data": requiredField(schema{
+				"id":       requiredField(nil),
+				"object":   requiredField(nil),
+				"created":  requiredField(nil),
+				"owned_by": requiredField(nil),
+			}),
+		},
+	}
+)
+
+// checkStrict verifies in strict mode that a response matches the OpenAI
+// schema for its object, so divergence fails loudly instead of slipping past
+// lenient clients. Objects without a known schema are not checked.
+func (s *Server) checkStrict(v interface{}) error {
+	if !s.config.StrictCompat {
+		return nil
+	}
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	var response map[string]interface{}
+	if err := json.Unmarshal(data, &response); err != nil {
+		return nil
+	}
+	object, _ := response["object"].(string)
+	fields, ok := responseSchemas[object]
+	if !ok {
+		return nil
+	}
+
+	if problem := fields.check("", response); problem != "" {
+		strictViolations.With(object).Inc()
+		slog.Error("Response does not match the OpenAI schema", "object", object, "problem", problem)
+		return errors.NewInternalError("response does not match the OpenAI " + object + " schema: " + problem)
+	}
+	return nil
+}
+
+// check returns the first difference between value and the schema
+func (fields schema) check(path string, value map[string]interface{}) string {
+	names := make([]string, 0, len(value))
+	for name := range value {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if _, ok := fields[name]; !ok {
+			return "unexpected field " + path + name
+		}
+	}
+
+	names = names[:0]
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		field := fields[name]
+		nested, present := value[name]
+		if !present {
+			if field.required {
+				return "missing field " + path + name
+			}
+			continue
+		}
+		if field.fields == nil || nested == nil {
+			continue
+		}
+		switch nested := nested.(type) {
+		case map[string]interface{}:
+			if problem := field.fields.check(path+name+".", nested); problem != "" {
+				return problem
+			}
+		case []interface{}:
+			for i, element := range nested {
+				if object, ok := element.(map[string]interface{}); ok {
+					if problem := field.fields.check(fmt.Sprintf("%s%s[%d].", path, name, i), object); problem != "" {
+						return problem
+					}
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// strictModel is a model as listed by the OpenAI API
+type strictModel struct {
+	ID      string `json:"id"`
+	Object  string `json:"object"`
+	Created int64  `json:"created"`
+	OwnedBy string `json:"owned_by"`
+}
+
+// strictModels drops the Copilot metadata from a model list
+func strictModels(models []copilot.ModelInfo) []strictModel {
+	list := make([]strictModel, 0, len(models))
+	for _, m := range models {
+		list = append(list, strictModel{ID: m.ID, Object: getDefaultOrString(m.Object, "model"), Created: m.Created, OwnedBy: m.OwnedBy})
+	}
+	return list
+}
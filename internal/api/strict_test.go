@@ -0,0 +1,96 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestStrictRequestFields(t *testing.T) {
+	router := newMockServer(t, map[string]string{"STRICT_COMPAT": "true"}).Router()
+	tests := []struct {
+		name    string
+		path    string
+		body    string
+		status  int
+		message string
+	}{
+		{"chat", "/v1/chat/completions", `{"model": "gpt-4o", "messages": [{"role": "user", "content": "hi"}], "reasoning_effort": "low", "store": false}`, http.StatusOK, ""},
+		{"chat unknown field", "/v1/chat/completions", `{"model": "gpt-4o", "messages": [{"role": "user", "content": "hi"}], "temprature": 0.5}`, http.StatusBadRequest, "Unrecognized request argument supplied: temprature"},
+		{"chat unknown fields", "/v1/chat/completions", `{"model": "gpt-4o", "messages": [{"role": "user", "content": "hi"}], "top_k": 5, "repetition_penalty": 1.1}`, http.StatusBadRequest, "Unrecognized request arguments supplied: repetition_penalty, top_k"},
+		{"completion", "/v1/completions", `{"model": "gpt-4o", "prompt": "def", "suffix": "pass", "echo": false}`, http.StatusOK, ""},
+		// Chat only fields are unknown to text completions
+		{"completion chat field", "/v1/completions", `{"model": "gpt-4o", "prompt": "def", "messages": []}`, http.StatusBadRequest, "Unrecognized request argument supplied: messages"},
+		{"invalid JSON", "/v1/chat/completions", `{"model": `, http.StatusBadRequest, "Invalid JSON format"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPost, tt.path, strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			router.ServeHTTP(rec, req)
+			if rec.Code != tt.status {
+				t.Fatalf("status %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+			if tt.message == "" {
+				return
+			}
+			var body struct {
+				Error struct {
+					Message string `json:"message"`
+					Type    string `json:"type"`
+				} `json:"error"`
+			}
+			json.Unmarshal(rec.Body.Bytes(), &body)
+			if !strings.HasSuffix(body.Error.Message, ": "+tt.message) || body.Error.Type != "validation_error" {
+				t.Errorf("error %+v, want %q", body.Error, tt.message)
+			}
+		})
+	}
+
+	// Without strict mode unknown fields are ignored
+	lenient := newMockServer(t, map[string]string{"STRICT_COMPAT": "false"}).Router()
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", bytes.NewReader([]byte(tests[1].body)))
+	req.Header.Set("Content-Type", "application/json")
+	lenient.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Errorf("lenient mode: %d %s", rec.Code, rec.Body)
+	}
+}
+
+func TestStrictResponseSchema(t *testing.T) {
+	server := newMockServer(t, map[string]string{"STRICT_COMPAT": "true"})
+	tests := []struct {
+		name     string
+		response map[string]interface{}
+		problem  string
+	}{
+		{"valid", map[string]interface{}{
+			"id": "x", "object": "chat.completion", "created": 1, "model": "gpt-4o",
+			"choices": []interface{}{map[string]interface{}{
+				"index": 0, "message": map[string]interface{}{"role": "assistant", "content": "hi"}, "logprobs": nil, "finish_reason": "stop",
+			}},
+		}, ""},
+		{"extra field", map[string]interface{}{
+			"id": "x", "object": "chat.completion", "created": 1, "model": "gpt-4o", "choices": []interface{}{}, "x_reai": map[string]interface{}{},
+		}, "unexpected field x_reai"},
+		{"missing nested field", map[string]interface{}{
+			"id": "x", "object": "chat.completion", "created": 1, "model": "gpt-4o",
+			"choices": []interface{}{map[string]interface{}{"index": 0, "message": map[string]interface{}{"role": "assistant"}, "logprobs": nil, "finish_reason": "stop"}},
+		}, "missing field choices[0].message.content"},
+		{"unknown object", map[string]interface{}{"object": "thing", "anything": true}, ""},
+	}
+	for _, tt := range tests {
+		err := server.checkStrict(tt.response)
+		switch {
+		case tt.problem == "" && err != nil:
+			t.Errorf("%s: %v", tt.name, err)
+		case tt.problem != "" && (err == nil || !strings.Contains(err.Error(), tt.problem)):
+			t.Errorf("%s: got %v, want %q", tt.name, err, tt.problem)
+		}
+	}
+}
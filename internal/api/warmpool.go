@@ -0,0 +1,70 @@
+package api
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/devstroop/reai/internal/copilot"
+)
+
+// warmModels tracks when the models listed in WARM_MODELS were last requested
+type warmModels struct {
+	models   map[string]bool
+	names    []string
+	lastUsed atomic.Int64
+}
+
+// newWarmModels parses a comma separated model list, returning nil when empty
+func newWarmModels(list string) *warmModels {
+	w := &warmModels{models: make(map[string]bool)}
+	for _, model := range strings.Split(list, ",") {
+		model = strings.TrimSpace(model)
+		if model != "" && !w.models[strings.ToLower(model)] {
+			w.models[strings.ToLower(model)] = true
+			w.names = append(w.names, model)
+		}
+	}
+	if len(w.names) == 0 {
+		return nil
+	}
+	return w
+}
+
+// touch records a request for model if it is a warm model
+func (w *warmModels) touch(model string) {
+	if w != nil && w.models[strings.ToLower(model)] {
+		w.lastUsed.Store(time.Now().UnixNano())
+	}
+}
+
+// usedWithin reports whether a warm model was requested in the last d
+func (w *warmModels) usedWithin(d time.Duration) bool {
+	return time.Since(time.Unix(0, w.lastUsed.Load())) < d
+}
+
+// RunWarmPool keeps upstream connections warm for the models in WARM_MODELS
+// until ctx is done. It returns immediately when no models are listed. With
+// WARM_POOL_IDLE set, the pool is only refreshed while one of the models was
+// requested recently, so it costs nothing during quiet hours.
+func (s *Server) RunWarmPool(ctx context.Context) {
+	if s.warmModels == nil || s.config.Offline() {
+		return
+	}
+
+	opts := copilot.WarmOptions{
+		Models:      s.warmModels.names,
+		Connections: s.config.WarmPoolSize,
+		Interval:    s.config.WarmPoolInterval,
+		Prime:       s.config.WarmPoolPrime,
+	}
+	if idle := s.config.WarmPoolIdle; idle > 0 {
+		opts.Active = func() bool { return s.warmModels.usedWithin(idle) }
+		// Start warm, as if the models had just been used
+		s.warmModels.lastUsed.Store(time.Now().UnixNano())
+	}
+	slog.Info("🔥 Warm pool enabled", "models", s.config.WarmModels, "connections", opts.Connections, "interval", opts.Interval, "prime", opts.Prime)
+	s.copilotClient.KeepWarm(ctx, opts)
+}
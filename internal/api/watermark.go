@@ -0,0 +1,180 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/devstroop/reai/internal/audit"
+	"github.com/devstroop/reai/internal/keys"
+	"github.com/devstroop/reai/internal/usage"
+	"github.com/devstroop/reai/internal/watermark"
+	"github.com/devstroop/reai/pkg/errors"
+)
+
+// usageRecord is the server-side half of a watermark. It is logged and saved
+// when the response completes so generated content can later be attributed.
+type usageRecord struct {
+	value string
+	mark  watermark.Mark
+	path  string
+}
+
+// newUsageRecord creates the watermark and usage record for the response with
+// the given ID, or returns nil when watermarking is disabled
+func (s *Server) newUsageRecord(r *http.Request, id string) *usageRecord {
+	if s.watermark == nil {
+		return nil
+	}
+
+	var keyID string
+	if key := keys.FromContext(r.Context()); key != nil {
+		keyID = key.ID
+	}
+	mark := watermark.Mark{
+		RequestID: id,
+		KeyHash:   s.watermark.KeyHash(keyID),
+		Timestamp: time.Now().UTC(),
+	}
+	return &usageRecord{value: s.watermark.Sign(mark), mark: mark, path: r.URL.Path}
+}
+
+// signed returns the signed watermark, or empty when watermarking is disabled
+func (u *usageRecord) signed() string {
+	if u == nil {
+		return ""
+	}
+	return u.value
+}
+
+// save logs the usage record for the completed response and adds it to records
+func (u *usageRecord) save(records *usage.Records, model, prompt string, completion *audit.Transcript, finishReason string) {
+	if u == nil {
+		return
+	}
+	record := usage.ResponseRecord{
+		RequestID:        u.mark.RequestID,
+		Watermark:        u.value,
+		KeyHash:          u.mark.KeyHash,
+		Time:             u.mark.Timestamp,
+		Path:             u.path,
+		Model:            model,
+		PromptTokens:     estimateTokens(prompt),
+		CompletionTokens: estimateTokenCount(completion.Len()),
+		CompletionSHA256: completion.Sum(),
+		FinishReason:     finishReason,
+	}
+	slog.Info("📎 Usage record",
+		"watermark", record.Watermark,
+		"request_id", record.RequestID,
+		"key_hash", record.KeyHash,
+		"timestamp", record.Time,
+		"path", record.Path,
+		"model", record.Model,
+		"prompt_tokens", record.PromptTokens,
+		"completion_tokens", record.CompletionTokens,
+		"completion_sha256", record.CompletionSHA256,
+		"finish_reason", record.FinishReason,
+	)
+	if err := records.Add(record); err != nil {
+		slog.Warn("Usage record not saved", "request_id", record.RequestID, "error", err)
+	}
+}
+
+// watermarkVerifyRequest asks the server to check a watermark
+type watermarkVerifyRequest struct {
+	Watermark string `json:"watermark"`
+}
+
+// watermarkVerifyResponse reports whether a watermark is authentic and what it contains
+type watermarkVerifyResponse struct {
+	Valid bool            `json:"valid"`
+	Mark  *watermark.Mark `json:"mark,omitempty"`
+}
+
+// handleAdminWatermark verifies a watermark found on generated content
+func (s *Server) handleAdminWatermark(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.watermark == nil {
+		errors.WriteErrorResponse(w, errors.NewValidationError("watermarking is not enabled"))
+		return
+	}
+
+	var req watermarkVerifyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		errors.WriteErrorResponse(w, errors.NewValidationError("Invalid JSON format"))
+		return
+	}
+
+	response := watermarkVerifyResponse{}
+	if mark, err := s.watermark.Verify(req.Watermark); err == nil {
+		response.Valid = true
+		response.Mark = &mark
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// handleAdminUsageRecords looks up the usage records of watermarked
+// responses by request_id, key_hash, completion_sha256 or watermark, and
+// between the from and to times (RFC 3339), newest first
+func (s *Server) handleAdminUsageRecords(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	filter := usage.RecordFilter{
+		RequestID:        query.Get("request_id"),
+		KeyHash:          query.Get("key_hash"),
+		CompletionSHA256: query.Get("completion_sha256"),
+	}
+	if value := query.Get("watermark"); value != "" {
+		if s.watermark == nil {
+			errors.WriteErrorResponse(w, errors.NewValidationError("watermarking is not enabled"))
+			return
+		}
+		mark, err := s.watermark.Verify(value)
+		if err != nil {
+			errors.WriteErrorResponse(w, errors.NewValidationError("watermark is not valid"))
+			return
+		}
+		filter.RequestID = mark.RequestID
+	}
+	for name, bound := range map[string]*time.Time{"from": &filter.From, "to": &filter.To} {
+		value := query.Get(name)
+		if value == "" {
+			continue
+		}
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			errors.WriteErrorResponse(w, errors.NewValidationError(name+" must be a time like 2025-01-31T12:00:00Z"))
+			return
+		}
+		*bound = parsed
+	}
+	if value := query.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > usage.MaxRecordResults {
+			errors.WriteErrorResponse(w, errors.NewValidationError(fmt.Sprintf("limit must be between 1 and %d", usage.MaxRecordResults)))
+			return
+		}
+		filter.Limit = limit
+	}
+
+	records, err := s.records.Query(filter)
+	if err != nil {
+		errors.WriteErrorResponse(w, errors.NewInternalError(err.Error()))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"object": "list", "data": records})
+}
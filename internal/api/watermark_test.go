@@ -0,0 +1,85 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/devstroop/reai/internal/usage"
+	"github.com/devstroop/reai/internal/watermark"
+)
+
+func TestUsageRecords(t *testing.T) {
+	ts := httptest.NewServer(newMockServer(t, map[string]string{
+		"WATERMARK_SECRET": "secret",
+		"ADMIN_TOKEN":      "admin",
+	}).Router())
+	t.Cleanup(ts.Close)
+
+	var completion struct {
+		ID      string `json:"id"`
+		Choices []struct {
+			Message struct {
+				Content string `json:"content"`
+			} `json:"message"`
+		} `json:"choices"`
+	}
+	header := postJSON(t, ts.URL+"/v1/chat/completions", map[string]interface{}{
+		"model":    "gpt-4o",
+		"messages": []map[string]string{{"role": "user", "content": "hello"}},
+	}, &completion)
+	mark := header.Get(watermark.Header)
+	if mark == "" || len(completion.Choices) != 1 {
+		t.Fatalf("watermark %q, response %+v", mark, completion)
+	}
+	sum := sha256.Sum256([]byte(completion.Choices[0].Message.Content))
+
+	query := func(params url.Values) (int, []usage.ResponseRecord) {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodGet, ts.URL+"/admin/usage/records?"+params.Encode(), nil)
+		req.Header.Set("Authorization", "Bearer admin")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var list struct {
+			Data []usage.ResponseRecord `json:"data"`
+		}
+		json.NewDecoder(resp.Body).Decode(&list)
+		return resp.StatusCode, list.Data
+	}
+
+	// The generated text is found by its hash, and by its watermark
+	for _, params := range []url.Values{
+		{"completion_sha256": {hex.EncodeToString(sum[:])}},
+		{"watermark": {mark}},
+		{"request_id": {completion.ID}, "from": {"2000-01-01T00:00:00Z"}},
+	} {
+		status, records := query(params)
+		if status != http.StatusOK || len(records) != 1 {
+			t.Fatalf("%v: status %d, %d records", params, status, len(records))
+		}
+		if r := records[0]; r.RequestID != completion.ID || r.Watermark != mark || r.Model != "gpt-4o" || r.KeyHash != "none" ||
+			r.Path != "/v1/chat/completions" || r.CompletionTokens == 0 || r.FinishReason != "stop" {
+			t.Errorf("record %+v", r)
+		}
+	}
+
+	if _, records := query(url.Values{"completion_sha256": {"0000"}}); len(records) != 0 {
+		t.Errorf("unrelated hash matched %+v", records)
+	}
+	for _, params := range []url.Values{
+		{"watermark": {"v1;rid=forged;kh=none;ts=0;sig=00"}},
+		{"from": {"yesterday"}},
+		{"limit": {"0"}},
+	} {
+		if status, _ := query(params); status != http.StatusBadRequest {
+			t.Errorf("%v: status %d", params, status)
+		}
+	}
+}
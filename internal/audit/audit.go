@@ -0,0 +1,240 @@
+package audit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/devstroop/reai/internal/metrics"
+)
+
+// writeErrors counts audit records lost to encoding or write errors
+var writeErrors = metrics.NewCounter("reai_audit_write_errors_total", "Audit records that could not be encoded or written")
+
+// Statuses of audited requests
+const (
+	StatusOK        = "ok"
+	StatusError     = "error"
+	StatusCancelled = "cancelled"
+)
+
+// Policy controls what the audit log captures
+type Policy struct {
+	CapturePrompts     bool
+	CaptureCompletions bool
+	// MaxTranscriptBytes bounds the captured prompt and completion text (0 = unlimited)
+	MaxTranscriptBytes int
+}
+
+// Record is one audited completion
+type Record struct {
+	Time       time.Time `json:"time"`
+	RequestID  string    `json:"request_id"`
+	KeyID      string    `json:"key_id,omitempty"`
+	RemoteAddr string    `json:"remote_addr,omitempty"`
+	Path       string    `json:"path"`
+	Model      string    `json:"model,omitempty"`
+	Stream     bool      `json:"stream"`
+
+	Status       string  `json:"status"`
+	Error        string  `json:"error,omitempty"`
+	FinishReason string  `json:"finish_reason,omitempty"`
+	DurationMS   float64 `json:"duration_ms"`
+
+	Prompt              string `json:"prompt,omitempty"`
+	PromptTruncated     bool   `json:"prompt_truncated,omitempty"`
+	Completion          string `json:"completion,omitempty"`
+	CompletionTruncated bool   `json:"completion_truncated,omitempty"`
+	CompletionSHA256    string `json:"completion_sha256,omitempty"`
+	// Chunks is the number of streamed pieces the completion arrived in
+	Chunks int `json:"chunks,omitempty"`
+
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	// Cost is the estimated cost of the tokens (0 while no price is configured)
+	Cost float64 `json:"cost,omitempty"`
+}
+
+// Apply drops the text of a record the policy does not capture
+func (p Policy) Apply(record Record) Record {
+	if !p.CapturePrompts {
+		record.Prompt, record.PromptTruncated = "", false
+	} else if max := p.MaxTranscriptBytes; max > 0 && len(record.Prompt) > max {
+		record.Prompt, record.PromptTruncated = truncate(record.Prompt, max), true
+	}
+	if !p.CaptureCompletions {
+		record.Completion, record.CompletionTruncated = "", false
+	}
+	return record
+}
+
+// NewTranscript returns a transcript bounded by the policy
+func (p Policy) NewTranscript() *Transcript {
+	if !p.CaptureCompletions {
+		return NewTranscript(0)
+	}
+	if p.MaxTranscriptBytes == 0 {
+		return NewTranscript(-1)
+	}
+	return NewTranscript(p.MaxTranscriptBytes)
+}
+
+// Logger writes audit records as JSON lines
+type Logger struct {
+	mutex  sync.Mutex
+	w      io.Writer
+	closer io.Closer
+	policy Policy
+	// err is the last write error, nil once a record is written again
+	err error
+}
+
+// Open opens the audit log at path ("-" for stdout). An empty path disables
+// auditing and returns a nil logger, which is safe to use.
+func Open(path string, policy Policy) (*Logger, error) {
+	switch path {
+	case "":
+		return nil, nil
+	case "-":
+		return &Logger{w: os.Stdout, policy: policy}, nil
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log: %w", err)
+	}
+	return &Logger{w: file, closer: file, policy: policy}, nil
+}
+
+// NewTranscript returns a transcript bounded by the capture policy. Without a
+// logger, or when completions are not captured, only the size and hash are kept.
+func (l *Logger) NewTranscript() *Transcript {
+	if l == nil {
+		return NewTranscript(0)
+	}
+	return l.policy.NewTranscript()
+}
+
+// Log writes a record, dropping the text the policy does not capture. A
+// record that cannot be written is counted, and the first failure after a
+// success is logged, so a full disk doesn't flood the log.
+func (l *Logger) Log(record Record) {
+	if l == nil {
+		return
+	}
+
+	data, err := json.Marshal(l.policy.Apply(record))
+	if err != nil {
+		writeErrors.Inc()
+		slog.Error("Audit record not encoded", "request_id", record.RequestID, "error", err)
+		return
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if _, err := l.w.Write(append(data, '\n')); err != nil {
+		writeErrors.Inc()
+		if l.err == nil {
+			slog.Error("Audit log write failed, records are lost until it recovers", "error", err)
+		}
+		l.err = err
+		return
+	}
+	if l.err != nil {
+		slog.Info("Audit log writes recovered")
+		l.err = nil
+	}
+}
+
+// Err returns the error of the last write, nil when it succeeded
+func (l *Logger) Err() error {
+	if l == nil {
+		return nil
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.err
+}
+
+// Close closes the audit log file
+func (l *Logger) Close() error {
+	if l == nil || l.closer == nil {
+		return nil
+	}
+	return l.closer.Close()
+}
+
+// Transcript reassembles a completion from streamed pieces. It keeps at most
+// limit bytes of text (all of it when limit < 0) but always tracks the full
+// size, piece count and SHA-256, so usage and hashes match buffered responses.
+type Transcript struct {
+	limit  int
+	text   strings.Builder
+	size   int
+	chunks int
+	hash   hash.Hash
+}
+
+// NewTranscript creates a transcript keeping at most limit bytes of text
+func NewTranscript(limit int) *Transcript {
+	return &Transcript{limit: limit, hash: sha256.New()}
+}
+
+// Write appends a piece of the completion
+func (t *Transcript) Write(text string) {
+	if text == "" {
+		return
+	}
+	t.size += len(text)
+	t.chunks++
+	t.hash.Write([]byte(text))
+
+	if t.limit < 0 {
+		t.text.WriteString(text)
+	} else if room := t.limit - t.text.Len(); room > 0 {
+		t.text.WriteString(truncate(text, room))
+	}
+}
+
+// String returns the captured text
+func (t *Transcript) String() string {
+	return t.text.String()
+}
+
+// Len returns the full size of the completion in bytes
+func (t *Transcript) Len() int {
+	return t.size
+}
+
+// Chunks returns the number of pieces written
+func (t *Transcript) Chunks() int {
+	return t.chunks
+}
+
+// Truncated reports whether text was dropped
+func (t *Transcript) Truncated() bool {
+	return t.text.Len() < t.size
+}
+
+// Sum returns the hex SHA-256 of the full completion
+func (t *Transcript) Sum() string {
+	return hex.EncodeToString(t.hash.Sum(nil))
+}
+
+// truncate cuts s to at most max bytes without splitting a UTF-8 sequence
+func truncate(s string, max int) string {
+	if len(s) <= max {
+		return s
+	}
+	for max > 0 && s[max]&0xC0 == 0x80 {
+		max--
+	}
+	return s[:max]
+}
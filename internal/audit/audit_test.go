@@ -0,0 +1,50 @@
+package audit
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// flakyWriter fails writes while err is set
+type flakyWriter struct {
+	bytes.Buffer
+	err error
+}
+
+func (w *flakyWriter) Write(p []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	return w.Buffer.Write(p)
+}
+
+func TestLoggerWriteErrors(t *testing.T) {
+	w := &flakyWriter{}
+	logger := &Logger{w: w, policy: Policy{CapturePrompts: true}}
+
+	logger.Log(Record{RequestID: "reai-1"})
+	if logger.Err() != nil || !strings.Contains(w.String(), `"request_id":"reai-1"`) {
+		t.Fatalf("first record: %v, %q", logger.Err(), w.String())
+	}
+
+	w.err = errors.New("no space left on device")
+	logger.Log(Record{RequestID: "reai-2"})
+	logger.Log(Record{RequestID: "reai-3"})
+	if err := logger.Err(); err == nil || !strings.Contains(err.Error(), "no space") {
+		t.Errorf("error after failed writes: %v", err)
+	}
+
+	w.err = nil
+	logger.Log(Record{RequestID: "reai-4"})
+	if logger.Err() != nil || !strings.Contains(w.String(), "reai-4") {
+		t.Errorf("after recovery: %v", logger.Err())
+	}
+
+	var nilLogger *Logger
+	nilLogger.Log(Record{})
+	if nilLogger.Err() != nil {
+		t.Error("nil logger reported an error")
+	}
+}
@@ -0,0 +1,341 @@
+package batch
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/devstroop/reai/internal/store"
+)
+
+// ErrNotFound is returned for unknown batch IDs
+var ErrNotFound = errors.New("batch not found")
+
+// Batch statuses, as in the OpenAI Batch API
+const (
+	StatusValidating = "validating"
+	StatusFailed     = "failed"
+	StatusInProgress = "in_progress"
+	StatusFinalizing = "finalizing"
+	StatusCompleted  = "completed"
+	StatusExpired    = "expired"
+	StatusCancelling = "cancelling"
+	StatusCancelled  = "cancelled"
+)
+
+// CompletionWindow is the only completion window supported
+const CompletionWindow = "24h"
+
+// Endpoints that batches can target
+var Endpoints = []string{"/v1/chat/completions", "/v1/completions"}
+
+// Batch is a set of requests processed in the background
+type Batch struct {
+	ID               string            `json:"id"`
+	Object           string            `json:"object"`
+	Endpoint         string            `json:"endpoint"`
+	Errors           *Errors           `json:"errors"`
+	InputFileID      string            `json:"input_file_id"`
+	CompletionWindow string            `json:"completion_window"`
+	Status           string            `json:"status"`
+	OutputFileID     *string           `json:"output_file_id"`
+	ErrorFileID      *string           `json:"error_file_id"`
+	CreatedAt        int64             `json:"created_at"`
+	InProgressAt     *int64            `json:"in_progress_at"`
+	ExpiresAt        int64             `json:"expires_at"`
+	FinalizingAt     *int64            `json:"finalizing_at"`
+	CompletedAt      *int64            `json:"completed_at"`
+	FailedAt         *int64            `json:"failed_at"`
+	ExpiredAt        *int64            `json:"expired_at"`
+	CancellingAt     *int64            `json:"cancelling_at"`
+	CancelledAt      *int64            `json:"cancelled_at"`
+	RequestCounts    RequestCounts     `json:"request_counts"`
+	Metadata         map[string]string `json:"metadata"`
+
+	Owner string `json:"owner,omitempty"`
+	// Next is the index of the next input line to process
+	Next int `json:"next,omitempty"`
+}
+
+// Errors lists the validation errors of a failed batch
+type Errors struct {
+	Object string  `json:"object"`
+	Data   []Error `json:"data"`
+}
+
+// Error is one validation error
+type Error struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Line    *int   `json:"line,omitempty"`
+}
+
+// RequestCounts tracks the progress of a batch
+type RequestCounts struct {
+	Total     int `json:"total"`
+	Completed int `json:"completed"`
+	Failed    int `json:"failed"`
+}
+
+// Terminal reports whether the batch will not change anymore
+func (b *Batch) Terminal() bool {
+	switch b.Status {
+	case StatusFailed, StatusCompleted, StatusExpired, StatusCancelled:
+		return true
+	}
+	return false
+}
+
+// Store keeps batches in memory and persists them in the database. The
+// partial results of batches being processed collect in a directory.
+type Store struct {
+	mutex   sync.RWMutex
+	db      *store.DB
+	dir     string
+	batches map[string]*Batch
+}
+
+// Open loads the batches stored in db, keeping partial results in dir and
+// first moving in the batches an older version kept there as JSON files
+func Open(db *store.DB, dir string) (*Store, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create batches directory: %w", err)
+	}
+
+	s := &Store{db: db, dir: dir, batches: make(map[string]*Batch)}
+	rows, err := db.Query("SELECT data FROM batches")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read batches: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var data []byte
+		if err := rows.Scan(&data); err != nil {
+			return nil, fmt.Errorf("failed to read batches: %w", err)
+		}
+		var b Batch
+		if err := json.Unmarshal(data, &b); err != nil {
+			return nil, fmt.Errorf("failed to parse batch: %w", err)
+		}
+		s.batches[b.ID] = &b
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read batches: %w", err)
+	}
+
+	if err := s.importFiles(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// importFiles stores the batches an older version kept as JSON files next
+// to their results and removes the files
+func (s *Store) importFiles() error {
+	paths, err := filepath.Glob(filepath.Join(s.dir, "batch_*.json"))
+	if err != nil || len(paths) == 0 {
+		return err
+	}
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("failed to read batch: %w", err)
+		}
+		var b Batch
+		if err := json.Unmarshal(data, &b); err != nil {
+			return fmt.Errorf("failed to parse batch %s: %w", filepath.Base(path), err)
+		}
+		if _, ok := s.batches[b.ID]; !ok {
+			if err := s.put(&b); err != nil {
+				return err
+			}
+		}
+		if err := os.Remove(path); err != nil {
+			return fmt.Errorf("failed to remove imported batch: %w", err)
+		}
+	}
+	slog.Info("Batches moved to the database", "dir", s.dir, "batches", len(paths))
+	return nil
+}
+
+// NewID returns a new random batch ID
+func NewID() string {
+	return newID("batch_")
+}
+
+// Create stores a new batch for the input file
+func (s *Store) Create(owner, endpoint, inputFileID string, metadata map[string]string) (*Batch, error) {
+	now := time.Now()
+	b := &Batch{
+		ID:               NewID(),
+		Object:           "batch",
+		Endpoint:         endpoint,
+		InputFileID:      inputFileID,
+		CompletionWindow: CompletionWindow,
+		Status:           StatusValidating,
+		CreatedAt:        now.Unix(),
+		ExpiresAt:        now.Add(24 * time.Hour).Unix(),
+		Metadata:         metadata,
+		Owner:            owner,
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if err := s.put(b); err != nil {
+		return nil, err
+	}
+	return b.clone(), nil
+}
+
+// Get returns a copy of a batch belonging to owner
+func (s *Store) Get(owner, id string) (*Batch, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	b, ok := s.batches[id]
+	if !ok || b.Owner != owner {
+		return nil, ErrNotFound
+	}
+	return b.clone(), nil
+}
+
+// List returns the batches belonging to owner, newest first
+func (s *Store) List(owner string) []*Batch {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	var list []*Batch
+	for _, b := range s.batches {
+		if b.Owner == owner {
+			list = append(list, b.clone())
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].CreatedAt != list[j].CreatedAt {
+			return list[i].CreatedAt > list[j].CreatedAt
+		}
+		return list[i].ID > list[j].ID
+	})
+	return list
+}
+
+// Cancel asks for a batch belonging to owner to be cancelled. Batches that
+// already finished are returned unchanged.
+func (s *Store) Cancel(owner, id string) (*Batch, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	b, ok := s.batches[id]
+	if !ok || b.Owner != owner {
+		return nil, ErrNotFound
+	}
+	if b.Terminal() || b.Status == StatusCancelling {
+		return b.clone(), nil
+	}
+	updated := b.clone()
+	updated.Status = StatusCancelling
+	updated.CancellingAt = timestamp(time.Now())
+	if err := s.put(updated); err != nil {
+		return nil, err
+	}
+	return updated.clone(), nil
+}
+
+// UsesFile reports whether a batch that is still running reads fileID
+func (s *Store) UsesFile(fileID string) bool {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	for _, b := range s.batches {
+		if b.InputFileID == fileID && !b.Terminal() {
+			return true
+		}
+	}
+	return false
+}
+
+// next returns the oldest batch that still has work to do
+func (s *Store) next() *Batch {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	var oldest *Batch
+	for _, b := range s.batches {
+		if b.Terminal() {
+			continue
+		}
+		if oldest == nil || b.CreatedAt < oldest.CreatedAt || (b.CreatedAt == oldest.CreatedAt && b.ID < oldest.ID) {
+			oldest = b
+		}
+	}
+	if oldest == nil {
+		return nil
+	}
+	return oldest.clone()
+}
+
+// status returns the current status of a batch
+func (s *Store) status(id string) string {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	if b, ok := s.batches[id]; ok {
+		return b.Status
+	}
+	return ""
+}
+
+// update applies change to the stored batch and persists it. A cancellation
+// requested in the meantime is kept unless change ends the batch.
+func (s *Store) update(id string, change func(b *Batch)) (*Batch, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	b, ok := s.batches[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	updated := b.clone()
+	change(updated)
+	if b.Status == StatusCancelling && !updated.Terminal() {
+		updated.Status = StatusCancelling
+	}
+	if err := s.put(updated); err != nil {
+		return nil, err
+	}
+	return updated.clone(), nil
+}
+
+// put stores b; the caller holds the write lock
+func (s *Store) put(b *Batch) error {
+	data, err := json.Marshal(b)
+	if err != nil {
+		return err
+	}
+	if _, err := s.db.Exec("INSERT INTO batches (id, owner, created, data) VALUES (?, ?, ?, ?) "+
+		"ON CONFLICT (id) DO UPDATE SET data = excluded.data",
+		b.ID, b.Owner, b.CreatedAt, string(data)); err != nil {
+		return fmt.Errorf("failed to write batch: %w", err)
+	}
+	s.batches[b.ID] = b
+	return nil
+}
+
+// resultsPath is where the results of a batch collect while it runs
+func (s *Store) resultsPath(id, kind string) string {
+	return filepath.Join(s.dir, id+"."+kind+".jsonl")
+}
+
+func (b *Batch) clone() *Batch {
+	copied := *b
+	if b.Errors != nil {
+		errs := *b.Errors
+		errs.Data = append([]Error(nil), b.Errors.Data...)
+		copied.Errors = &errs
+	}
+	return &copied
+}
+
+func timestamp(t time.Time) *int64 {
+	unix := t.Unix()
+	return &unix
+}
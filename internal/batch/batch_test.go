@@ -0,0 +1,291 @@
+package batch
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/devstroop/reai/internal/files"
+	"github.com/devstroop/reai/internal/store"
+)
+
+const chatEndpoint = "/v1/chat/completions"
+
+// chatLine is an input line asking for a chat completion of content
+func chatLine(customID, content string) string {
+	return fmt.Sprintf(`{"custom_id":%q,"method":"POST","url":%q,"body":{"messages":[{"role":"user","content":%q}]}}`, customID, chatEndpoint, content)
+}
+
+func TestParseInput(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		ids   []string
+		codes []string
+	}{
+		{
+			name:  "valid",
+			input: chatLine("a", "hi") + "\n\n" + chatLine("b", "there") + "\n",
+			ids:   []string{"a", "b"},
+		},
+		{
+			name:  "empty",
+			input: "\n  \n",
+			codes: []string{"empty_file"},
+		},
+		{
+			name: "invalid lines",
+			input: strings.Join([]string{
+				`not json`,
+				`{"method":"POST","url":"/v1/chat/completions","body":{}}`,
+				chatLine("a", "hi"),
+				chatLine("a", "again"),
+				`{"custom_id":"get","method":"GET","url":"/v1/chat/completions","body":{}}`,
+				`{"custom_id":"url","method":"POST","url":"/v1/completions","body":{}}`,
+				`{"custom_id":"body","method":"POST","url":"/v1/chat/completions","body":"hi"}`,
+				`{"custom_id":"stream","method":"POST","url":"/v1/chat/completions","body":{"stream":true}}`,
+			}, "\n"),
+			ids:   []string{"a"},
+			codes: []string{"invalid_json_line", "missing_required_parameter", "duplicate_custom_id", "invalid_method", "mismatched_endpoint", "invalid_request", "invalid_request"},
+		},
+	}
+	for _, tt := range tests {
+		requests, errs, err := ParseInput(strings.NewReader(tt.input), chatEndpoint)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		var ids, codes []string
+		for _, req := range requests {
+			ids = append(ids, req.CustomID)
+		}
+		for _, e := range errs {
+			codes = append(codes, e.Code)
+		}
+		if strings.Join(ids, ",") != strings.Join(tt.ids, ",") || strings.Join(codes, ",") != strings.Join(tt.codes, ",") {
+			t.Errorf("%s: requests %v errors %v, want %v and %v", tt.name, ids, codes, tt.ids, tt.codes)
+		}
+	}
+
+	// Errors carry their line and are capped
+	_, errs, _ := ParseInput(strings.NewReader("x\ny\n"), chatEndpoint)
+	if len(errs) != 2 || *errs[1].Line != 2 {
+		t.Errorf("errors %+v", errs)
+	}
+	_, errs, _ = ParseInput(strings.NewReader(strings.Repeat("x\n", maxValidationErrors+5)), chatEndpoint)
+	if len(errs) != maxValidationErrors {
+		t.Errorf("%d errors, want %d", len(errs), maxValidationErrors)
+	}
+}
+
+// testRunner holds a runner over fresh stores
+type testRunner struct {
+	*Runner
+	batches *Store
+	files   *files.Store
+}
+
+func newTestRunner(t *testing.T, execute Executor) *testRunner {
+	t.Helper()
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	db, err := store.OpenMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	batches, err := Open(db, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	fileStore, err := files.Open(t.TempDir(), 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testRunner{Runner: NewRunner(batches, fileStore, execute, 0), batches: batches, files: fileStore}
+}
+
+// submit uploads lines as an input file and creates a batch reading it
+func (r *testRunner) submit(t *testing.T, lines ...string) *Batch {
+	t.Helper()
+	input, err := r.files.Create("alice", "input.jsonl", files.PurposeBatch, strings.NewReader(strings.Join(lines, "\n")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := r.batches.Create("alice", chatEndpoint, input.ID, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.Status != StatusValidating || !r.batches.UsesFile(input.ID) {
+		t.Fatalf("created batch %+v", b)
+	}
+	return b
+}
+
+// run processes the oldest batch with work left and returns b as it ended
+func (r *testRunner) run(t *testing.T, b *Batch) *Batch {
+	t.Helper()
+	if err := r.process(context.Background(), r.batches.next()); err != nil {
+		t.Fatal(err)
+	}
+	b, err := r.batches.Get("alice", b.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// results returns the custom IDs of a result file, nil when there is none
+func (r *testRunner) results(t *testing.T, id *string) []string {
+	t.Helper()
+	if id == nil {
+		return nil
+	}
+	content, err := r.files.Open("alice", *id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer content.Close()
+	data, _ := io.ReadAll(content)
+	var ids []string
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		var result Result
+		if err := json.Unmarshal([]byte(line), &result); err != nil {
+			t.Fatalf("%v: %s", err, line)
+		}
+		ids = append(ids, result.CustomID)
+	}
+	return ids
+}
+
+func TestRunnerCompletes(t *testing.T) {
+	var r *testRunner
+	var statuses []string
+	r = newTestRunner(t, func(ctx context.Context, owner, endpoint string, body json.RawMessage) (int, json.RawMessage) {
+		var req struct {
+			Messages []struct{ Content string } `json:"messages"`
+		}
+		json.Unmarshal(body, &req)
+		statuses = append(statuses, r.batches.status(r.batches.next().ID))
+		if owner != "alice" || endpoint != chatEndpoint {
+			t.Errorf("executed %s for %s", endpoint, owner)
+		}
+		if req.Messages[0].Content == "fail" {
+			return http.StatusBadRequest, json.RawMessage(`{"error":{}}`)
+		}
+		return http.StatusOK, json.RawMessage(`{"choices":[]}`)
+	})
+	b := r.submit(t, chatLine("a", "hi"), chatLine("b", "fail"), chatLine("c", "bye"))
+
+	b = r.run(t, b)
+	if b.Status != StatusCompleted || b.InProgressAt == nil || b.FinalizingAt == nil || b.CompletedAt == nil {
+		t.Fatalf("batch %+v", b)
+	}
+	if strings.Join(statuses, ",") != "in_progress,in_progress,in_progress" {
+		t.Errorf("statuses while running %v", statuses)
+	}
+	if b.RequestCounts != (RequestCounts{Total: 3, Completed: 2, Failed: 1}) {
+		t.Errorf("counts %+v", b.RequestCounts)
+	}
+	if got := r.results(t, b.OutputFileID); strings.Join(got, ",") != "a,c" {
+		t.Errorf("output %v", got)
+	}
+	if got := r.results(t, b.ErrorFileID); strings.Join(got, ",") != "b" {
+		t.Errorf("errors %v", got)
+	}
+	if r.batches.UsesFile(b.InputFileID) || r.batches.next() != nil {
+		t.Error("finished batch still running")
+	}
+}
+
+func TestRunnerFailsInvalidInput(t *testing.T) {
+	r := newTestRunner(t, func(ctx context.Context, owner, endpoint string, body json.RawMessage) (int, json.RawMessage) {
+		t.Error("invalid batch executed")
+		return http.StatusOK, nil
+	})
+	b := r.run(t, r.submit(t, chatLine("a", "hi"), `{"custom_id":"b","method":"GET"}`))
+	if b.Status != StatusFailed || b.FailedAt == nil || b.Errors == nil || len(b.Errors.Data) != 1 || b.Errors.Data[0].Code != "invalid_method" {
+		t.Errorf("batch %+v", b)
+	}
+	if b.OutputFileID != nil || b.RequestCounts.Total != 0 {
+		t.Errorf("failed batch has results: %+v", b)
+	}
+}
+
+func TestRunnerCancels(t *testing.T) {
+	var r *testRunner
+	var b *Batch
+	r = newTestRunner(t, func(ctx context.Context, owner, endpoint string, body json.RawMessage) (int, json.RawMessage) {
+		// Cancelled while the first request runs, which still completes
+		cancelled, err := r.batches.Cancel("alice", b.ID)
+		if err != nil || cancelled.Status != StatusCancelling || cancelled.CancellingAt == nil {
+			t.Errorf("cancel: %+v, %v", cancelled, err)
+		}
+		return http.StatusOK, json.RawMessage(`{}`)
+	})
+	b = r.submit(t, chatLine("a", "hi"), chatLine("b", "there"))
+
+	b = r.run(t, b)
+	if b.Status != StatusCancelled || b.CancelledAt == nil {
+		t.Fatalf("batch %+v", b)
+	}
+	if b.RequestCounts != (RequestCounts{Total: 2, Completed: 1}) {
+		t.Errorf("counts %+v", b.RequestCounts)
+	}
+	if got := r.results(t, b.OutputFileID); strings.Join(got, ",") != "a" {
+		t.Errorf("output %v", got)
+	}
+
+	// Finished batches stay as they are
+	if again, err := r.batches.Cancel("alice", b.ID); err != nil || again.Status != StatusCancelled {
+		t.Errorf("cancelling again: %+v, %v", again, err)
+	}
+	if _, err := r.batches.Cancel("bob", b.ID); err != ErrNotFound {
+		t.Errorf("other owner cancelled: %v", err)
+	}
+}
+
+func TestRunnerExpires(t *testing.T) {
+	executed := 0
+	r := newTestRunner(t, func(ctx context.Context, owner, endpoint string, body json.RawMessage) (int, json.RawMessage) {
+		executed++
+		return http.StatusOK, json.RawMessage(`{}`)
+	})
+	b := r.submit(t, chatLine("a", "hi"), chatLine("b", "there"))
+	r.batches.update(b.ID, func(b *Batch) { b.ExpiresAt = time.Now().Add(-time.Second).Unix() })
+
+	b = r.run(t, b)
+	if b.Status != StatusExpired || b.ExpiredAt == nil || executed != 0 {
+		t.Errorf("batch %+v after %d requests", b, executed)
+	}
+	if b.OutputFileID != nil || b.ErrorFileID != nil {
+		t.Errorf("expired batch without results has files: %+v", b)
+	}
+}
+
+func TestRunnerResumes(t *testing.T) {
+	var seen []string
+	r := newTestRunner(t, func(ctx context.Context, owner, endpoint string, body json.RawMessage) (int, json.RawMessage) {
+		seen = append(seen, string(body))
+		return http.StatusOK, json.RawMessage(`{}`)
+	})
+	b := r.submit(t, chatLine("a", "first"), chatLine("b", "second"))
+	// A previous run got through the first request before stopping
+	r.batches.update(b.ID, func(b *Batch) {
+		b.Status = StatusInProgress
+		b.RequestCounts = RequestCounts{Total: 2, Completed: 1}
+		b.Next = 1
+	})
+
+	b = r.run(t, b)
+	if len(seen) != 1 || !strings.Contains(seen[0], "second") {
+		t.Errorf("executed %v, want only the second request", seen)
+	}
+	if b.Status != StatusCompleted || b.RequestCounts != (RequestCounts{Total: 2, Completed: 2}) {
+		t.Errorf("batch %+v", b)
+	}
+}
@@ -0,0 +1,376 @@
+package batch
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/devstroop/reai/internal/files"
+	"github.com/devstroop/reai/internal/metrics"
+)
+
+// maxValidationErrors caps the errors reported for an invalid input file
+const maxValidationErrors = 20
+
+// idleInterval is how often the runner looks for work when it was not woken
+const idleInterval = time.Minute
+
+var batchRequests = metrics.NewCounterVec("reai_batch_requests_total", "Batch requests processed by result (completed, failed)", "result")
+
+// Executor runs one request of a batch on behalf of owner and returns the
+// HTTP status and body of the response
+type Executor func(ctx context.Context, owner, endpoint string, body json.RawMessage) (int, json.RawMessage)
+
+// Request is one line of a batch input file
+type Request struct {
+	CustomID string          `json:"custom_id"`
+	Method   string          `json:"method"`
+	URL      string          `json:"url"`
+	Body     json.RawMessage `json:"body"`
+}
+
+// Result is one line of a batch output or error file
+type Result struct {
+	ID       string       `json:"id"`
+	CustomID string       `json:"custom_id"`
+	Response *Response    `json:"response"`
+	Error    *ResultError `json:"error"`
+}
+
+// Response is the response to a batch request
+type Response struct {
+	StatusCode int             `json:"status_code"`
+	RequestID  string          `json:"request_id"`
+	Body       json.RawMessage `json:"body"`
+}
+
+// ResultError describes a request that could not be run
+type ResultError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// Runner processes batches one request at a time, oldest batch first, at no
+// more than the configured rate. Progress is saved after every request so a
+// restarted server resumes where it stopped. Stopping the runner lets the
+// request in flight finish first; one that was running when the process died
+// is run again.
+type Runner struct {
+	store    *Store
+	files    *files.Store
+	execute  Executor
+	interval time.Duration
+	wake     chan struct{}
+}
+
+// NewRunner returns a runner that sends at most requestsPerMinute requests
+func NewRunner(store *Store, fileStore *files.Store, execute Executor, requestsPerMinute int) *Runner {
+	interval := time.Duration(0)
+	if requestsPerMinute > 0 {
+		interval = time.Minute / time.Duration(requestsPerMinute)
+	}
+	return &Runner{
+		store:    store,
+		files:    fileStore,
+		execute:  execute,
+		interval: interval,
+		wake:     make(chan struct{}, 1),
+	}
+}
+
+// Wake tells the runner that a batch was created or cancelled
+func (r *Runner) Wake() {
+	select {
+	case r.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run processes batches until ctx is done. It returns once the request in
+// flight has been recorded.
+func (r *Runner) Run(ctx context.Context) {
+	for {
+		if b := r.store.next(); b != nil {
+			if err := r.process(ctx, b); err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				slog.Error("Batch processing failed", "batch", b.ID, "error", err)
+				r.fail(b.ID, "processing_error", err.Error())
+			}
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-r.wake:
+		case <-time.After(idleInterval):
+		}
+	}
+}
+
+// process takes a batch as far as it can go: validation, the remaining
+// requests and finalization
+func (r *Runner) process(ctx context.Context, b *Batch) error {
+	requests, validationErrors, err := r.readInput(b)
+	if err != nil {
+		return err
+	}
+	if b.Status == StatusValidating {
+		if len(validationErrors) > 0 {
+			slog.Warn("Batch input is invalid", "batch", b.ID, "errors", len(validationErrors))
+			_, err := r.store.update(b.ID, func(b *Batch) {
+				b.Status = StatusFailed
+				b.FailedAt = timestamp(time.Now())
+				b.Errors = &Errors{Object: "list", Data: validationErrors}
+			})
+			return err
+		}
+		if b, err = r.store.update(b.ID, func(b *Batch) {
+			b.Status = StatusInProgress
+			b.InProgressAt = timestamp(time.Now())
+			b.RequestCounts.Total = len(requests)
+		}); err != nil {
+			return err
+		}
+		slog.Info("📦 Batch started", "batch", b.ID, "requests", len(requests))
+	}
+
+	var last time.Time
+	for b.Next < len(requests) {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if b.Status == StatusCancelling {
+			return r.finalize(b, StatusCancelled)
+		}
+		if time.Now().Unix() >= b.ExpiresAt {
+			return r.finalize(b, StatusExpired)
+		}
+
+		if wait := r.interval - time.Since(last); wait > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(wait):
+			}
+			if r.store.status(b.ID) == StatusCancelling {
+				b.Status = StatusCancelling
+				continue
+			}
+		}
+		last = time.Now()
+
+		// A request already sent is seen through so that stopping the
+		// runner doesn't waste it; the process exiting still cuts it short
+		result := r.run(context.WithoutCancel(ctx), b, requests[b.Next])
+		if err := r.record(b.ID, result); err != nil {
+			return err
+		}
+		if b, err = r.store.update(b.ID, func(b *Batch) {
+			b.Next++
+			if result.Error == nil && result.Response.StatusCode < 400 {
+				b.RequestCounts.Completed++
+			} else {
+				b.RequestCounts.Failed++
+			}
+		}); err != nil {
+			return err
+		}
+	}
+
+	if b.Status == StatusCancelling {
+		return r.finalize(b, StatusCancelled)
+	}
+	return r.finalize(b, StatusCompleted)
+}
+
+// run executes one request of a batch
+func (r *Runner) run(ctx context.Context, b *Batch, req Request) Result {
+	result := Result{ID: newID("batch_req_"), CustomID: req.CustomID}
+	status, body := r.execute(ctx, b.Owner, b.Endpoint, req.Body)
+	result.Response = &Response{StatusCode: status, RequestID: newID("req_"), Body: body}
+	if status < 400 {
+		batchRequests.With("completed").Inc()
+	} else {
+		batchRequests.With("failed").Inc()
+	}
+	return result
+}
+
+// record appends a result to the output or error results of a batch
+func (r *Runner) record(id string, result Result) error {
+	kind := "output"
+	if result.Error != nil || result.Response.StatusCode >= 400 {
+		kind = "error"
+	}
+	line, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(r.store.resultsPath(id, kind), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to write batch results: %w", err)
+	}
+	_, err = f.Write(append(line, '\n'))
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// finalize turns the results collected so far into output and error files
+// and ends the batch with status
+func (r *Runner) finalize(b *Batch, status string) error {
+	if _, err := r.store.update(b.ID, func(b *Batch) {
+		if status == StatusCompleted {
+			b.Status = StatusFinalizing
+		}
+		b.FinalizingAt = timestamp(time.Now())
+	}); err != nil {
+		return err
+	}
+
+	outputID, err := r.storeResults(b, "output")
+	if err != nil {
+		return err
+	}
+	errorID, err := r.storeResults(b, "error")
+	if err != nil {
+		return err
+	}
+
+	b, err = r.store.update(b.ID, func(b *Batch) {
+		now := timestamp(time.Now())
+		b.Status = status
+		b.OutputFileID, b.ErrorFileID = outputID, errorID
+		switch status {
+		case StatusCompleted:
+			b.CompletedAt = now
+		case StatusCancelled:
+			b.CancelledAt = now
+		case StatusExpired:
+			b.ExpiredAt = now
+		}
+	})
+	if err != nil {
+		return err
+	}
+	slog.Info("📦 Batch finished", "batch", b.ID, "status", status,
+		"completed", b.RequestCounts.Completed, "failed", b.RequestCounts.Failed)
+	return nil
+}
+
+// storeResults moves the collected results of one kind into a file owned by
+// the batch owner, returning nil when there are none
+func (r *Runner) storeResults(b *Batch, kind string) (*string, error) {
+	path := r.store.resultsPath(b.ID, kind)
+	results, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	f, err := r.files.Create(b.Owner, b.ID+"_"+kind+".jsonl", files.PurposeBatchOutput, results)
+	results.Close()
+	if err != nil {
+		return nil, fmt.Errorf("failed to store batch %s file: %w", kind, err)
+	}
+	os.Remove(path)
+	return &f.ID, nil
+}
+
+// fail ends a batch that cannot be processed
+func (r *Runner) fail(id, code, message string) {
+	_, err := r.store.update(id, func(b *Batch) {
+		b.Status = StatusFailed
+		b.FailedAt = timestamp(time.Now())
+		b.Errors = &Errors{Object: "list", Data: []Error{{Code: code, Message: message}}}
+	})
+	if err != nil {
+		slog.Error("Failed to mark batch as failed", "batch", id, "error", err)
+	}
+}
+
+// readInput parses the input file of a batch. Lines that don't describe a
+// valid request for the batch endpoint are reported as validation errors.
+func (r *Runner) readInput(b *Batch) ([]Request, []Error, error) {
+	content, err := r.files.Open(b.Owner, b.InputFileID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open input file %s: %w", b.InputFileID, err)
+	}
+	defer content.Close()
+	return ParseInput(content, b.Endpoint)
+}
+
+// ParseInput reads a JSONL batch input file for endpoint
+func ParseInput(in io.Reader, endpoint string) ([]Request, []Error, error) {
+	var requests []Request
+	var errs []Error
+	addError := func(line int, code, format string, args ...interface{}) {
+		if len(errs) < maxValidationErrors {
+			errs = append(errs, Error{Code: code, Message: fmt.Sprintf(format, args...), Line: &line})
+		}
+	}
+
+	seen := make(map[string]bool)
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 64*1024), 16<<20)
+	for line := 1; scanner.Scan(); line++ {
+		data := bytes.TrimSpace(scanner.Bytes())
+		if len(data) == 0 {
+			continue
+		}
+
+		var req Request
+		if err := json.Unmarshal(data, &req); err != nil {
+			addError(line, "invalid_json_line", "line is not valid JSON: %v", err)
+			continue
+		}
+		var body struct {
+			Stream bool `json:"stream"`
+		}
+		switch {
+		case req.CustomID == "":
+			addError(line, "missing_required_parameter", "custom_id is required")
+		case seen[req.CustomID]:
+			addError(line, "duplicate_custom_id", "custom_id %q is used more than once", req.CustomID)
+		case req.Method != "POST":
+			addError(line, "invalid_method", "method must be POST")
+		case req.URL != endpoint:
+			addError(line, "mismatched_endpoint", "url %q does not match the batch endpoint %s", req.URL, endpoint)
+		case len(req.Body) == 0 || json.Unmarshal(req.Body, &body) != nil:
+			addError(line, "invalid_request", "body must be a JSON object")
+		case body.Stream:
+			addError(line, "invalid_request", "streaming is not supported in batches")
+		default:
+			seen[req.CustomID] = true
+			requests = append(requests, req)
+			continue
+		}
+		seen[req.CustomID] = true
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, fmt.Errorf("failed to read input file: %w", err)
+	}
+	if len(requests) == 0 && len(errs) == 0 {
+		errs = append(errs, Error{Code: "empty_file", Message: "the input file has no requests"})
+	}
+	return requests, errs, nil
+}
+
+func newID(prefix string) string {
+	buf := make([]byte, 12)
+	rand.Read(buf)
+	return prefix + hex.EncodeToString(buf)
+}
@@ -0,0 +1,288 @@
+// Package bench generates concurrent completion load against a ReAI server
+// and summarizes the latencies, time to first token and errors, to size
+// deployments and catch regressions in the streaming path.
+package bench
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Endpoints the load can be sent to
+const (
+	EndpointChat        = "chat"
+	EndpointCompletions = "completions"
+)
+
+// Runner sends the load
+type Runner struct {
+	// Client sends the requests
+	Client *http.Client
+	// BaseURL is the server root, e.g. http://localhost:8080
+	BaseURL string
+	// APIKey is sent as a bearer token when set
+	APIKey string
+
+	// Endpoint is chat or completions, Model, Prompt and MaxTokens make up
+	// each request and Stream asks for streamed responses
+	Endpoint  string
+	Model     string
+	Prompt    string
+	MaxTokens int
+	Stream    bool
+
+	// Concurrency is the number of requests in flight at once (1 when not
+	// positive)
+	Concurrency int
+	// Requests stops the run after this many requests, Duration after this
+	// long; whichever comes first when both are set
+	Requests int
+	Duration time.Duration
+	// Rate caps the requests started per second (no cap when zero)
+	Rate float64
+	// Timeout bounds each request (no limit when zero)
+	Timeout time.Duration
+}
+
+// sample is the outcome of a request
+type sample struct {
+	latency time.Duration
+	// ttft is the time to the first content of a streamed response
+	ttft time.Duration
+	// tokens are the completion tokens, from the usage when the server
+	// reports it and the content chunks otherwise
+	tokens int
+	// failure classifies a failed request, e.g. "429" or "timeout"
+	failure string
+}
+
+// Run sends the load until Requests or Duration is reached, or ctx is done,
+// and reports on it
+func (r *Runner) Run(ctx context.Context) *Report {
+	if r.Duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.Duration)
+		defer cancel()
+	}
+
+	var (
+		mu      sync.Mutex
+		samples []sample
+		wg      sync.WaitGroup
+	)
+	jobs := make(chan int)
+	for i := 0; i < max(r.Concurrency, 1); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := range jobs {
+				s := r.send(ctx, n)
+				// Requests cut short by the end of the run aren't counted
+				if s.failure != "" && ctx.Err() != nil {
+					continue
+				}
+				mu.Lock()
+				samples = append(samples, s)
+				mu.Unlock()
+			}
+		}()
+	}
+
+	var tick <-chan time.Time
+	if r.Rate > 0 {
+		ticker := time.NewTicker(time.Duration(float64(time.Second) / r.Rate))
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	start := time.Now()
+dispatch:
+	for n := 0; r.Requests <= 0 || n < r.Requests; n++ {
+		if tick != nil && n > 0 {
+			select {
+			case <-tick:
+			case <-ctx.Done():
+				break dispatch
+			}
+		}
+		select {
+		case jobs <- n:
+		case <-ctx.Done():
+			break dispatch
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	return newReport(r, samples, time.Since(start))
+}
+
+// send sends one request and reads the response to the end
+func (r *Runner) send(ctx context.Context, n int) sample {
+	if r.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.Timeout)
+		defer cancel()
+	}
+
+	body := map[string]interface{}{}
+	if r.Model != "" {
+		body["model"] = r.Model
+	}
+	if r.MaxTokens > 0 {
+		body["max_tokens"] = r.MaxTokens
+	}
+	if r.Stream {
+		body["stream"] = true
+		body["stream_options"] = map[string]bool{"include_usage": true}
+	}
+	// The request number keeps the prompts apart so no cache answers them
+	prompt := fmt.Sprintf("%s (bench request %d)", r.Prompt, n)
+	path := "/v1/chat/completions"
+	if r.Endpoint == EndpointCompletions {
+		path = "/v1/completions"
+		body["prompt"] = prompt
+	} else {
+		body["messages"] = []map[string]string{{"role": "user", "content": prompt}}
+	}
+	data, _ := json.Marshal(body)
+
+	start := time.Now()
+	s := sample{}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(r.BaseURL, "/")+path, bytes.NewReader(data))
+	if err != nil {
+		s.failure = "request"
+		return s
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "reai-bench")
+	if r.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+r.APIKey)
+	}
+
+	resp, err := r.Client.Do(req)
+	if err == nil {
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+			s.latency = time.Since(start)
+			s.failure = fmt.Sprint(resp.StatusCode)
+			return s
+		}
+		if r.Stream {
+			err = readStream(resp.Body, start, &s)
+		} else {
+			err = readCompletion(resp.Body, &s)
+		}
+	}
+	s.latency = time.Since(start)
+	if err != nil {
+		s.failure = classify(ctx, err)
+	}
+	return s
+}
+
+// readStream reads an event stream, noting when the first content arrives
+func readStream(body io.Reader, start time.Time, s *sample) error {
+	chunks := 0
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 64<<10), 1<<20)
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+		if !ok {
+			continue
+		}
+		if data == "[DONE]" {
+			if s.tokens == 0 {
+				s.tokens = chunks
+			}
+			return nil
+		}
+		var chunk struct {
+			Choices []struct {
+				Text  string `json:"text"`
+				Delta struct {
+					Content string `json:"content"`
+				} `json:"delta"`
+			} `json:"choices"`
+			Usage *struct {
+				CompletionTokens int `json:"completion_tokens"`
+			} `json:"usage"`
+			Error *struct {
+				Type string `json:"type"`
+			} `json:"error"`
+		}
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			return errMalformed
+		}
+		if chunk.Error != nil {
+			return streamError(chunk.Error.Type)
+		}
+		if chunk.Usage != nil {
+			s.tokens = chunk.Usage.CompletionTokens
+		}
+		for _, choice := range chunk.Choices {
+			if choice.Text == "" && choice.Delta.Content == "" {
+				continue
+			}
+			if chunks == 0 {
+				s.ttft = time.Since(start)
+			}
+			chunks++
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return errTruncated
+}
+
+// readCompletion reads a JSON response for its usage
+func readCompletion(body io.Reader, s *sample) error {
+	var decoded struct {
+		Choices []json.RawMessage `json:"choices"`
+		Usage   struct {
+			CompletionTokens int `json:"completion_tokens"`
+		} `json:"usage"`
+	}
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, &decoded); err != nil || len(decoded.Choices) == 0 {
+		return errMalformed
+	}
+	s.tokens = decoded.Usage.CompletionTokens
+	return nil
+}
+
+var (
+	errMalformed = errors.New("malformed")
+	errTruncated = errors.New("truncated")
+)
+
+// streamError is an error event sent in place of the rest of a stream
+type streamError string
+
+func (e streamError) Error() string { return "stream " + string(e) }
+
+// classify names the failure of a request that got no complete response
+func classify(ctx context.Context, err error) string {
+	var se streamError
+	switch {
+	case errors.Is(ctx.Err(), context.DeadlineExceeded), errors.Is(err, context.DeadlineExceeded):
+		return "timeout"
+	case errors.As(err, &se):
+		return se.Error()
+	case errors.Is(err, errMalformed), errors.Is(err, errTruncated):
+		return err.Error()
+	}
+	return "transport"
+}
@@ -0,0 +1,178 @@
+package bench
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Report summarizes a run
+type Report struct {
+	Endpoint    string  `json:"endpoint"`
+	Model       string  `json:"model,omitempty"`
+	Stream      bool    `json:"stream"`
+	Concurrency int     `json:"concurrency"`
+	Rate        float64 `json:"rate,omitempty"`
+
+	Requests  int     `json:"requests"`
+	Succeeded int     `json:"succeeded"`
+	Failed    int     `json:"failed"`
+	ErrorRate float64 `json:"error_rate"`
+	// Errors counts the failures by status code or kind: timeout,
+	// transport, malformed, truncated or "stream <type>" for an error event
+	Errors map[string]int `json:"errors,omitempty"`
+
+	Duration   time.Duration `json:"-"`
+	DurationMS float64       `json:"duration_ms"`
+	// Throughput is in requests per second, TokenThroughput in completion
+	// tokens per second, of the successful requests
+	Throughput      float64 `json:"throughput"`
+	TokenThroughput float64 `json:"token_throughput"`
+
+	// Latency is the time to the whole response of the successful requests,
+	// TTFT the time to the first content of the successful streams
+	Latency Distribution  `json:"latency"`
+	TTFT    *Distribution `json:"ttft,omitempty"`
+}
+
+// Distribution summarizes durations, in milliseconds in JSON
+type Distribution struct {
+	Mean time.Duration
+	P50  time.Duration
+	P95  time.Duration
+	P99  time.Duration
+	Max  time.Duration
+}
+
+// MarshalJSON writes the durations in milliseconds
+func (d Distribution) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Mean float64 `json:"mean_ms"`
+		P50  float64 `json:"p50_ms"`
+		P95  float64 `json:"p95_ms"`
+		P99  float64 `json:"p99_ms"`
+		Max  float64 `json:"max_ms"`
+	}{milliseconds(d.Mean), milliseconds(d.P50), milliseconds(d.P95), milliseconds(d.P99), milliseconds(d.Max)})
+}
+
+// newReport summarizes the samples of a run
+func newReport(r *Runner, samples []sample, duration time.Duration) *Report {
+	report := &Report{
+		Endpoint:    r.Endpoint,
+		Model:       r.Model,
+		Stream:      r.Stream,
+		Concurrency: max(r.Concurrency, 1),
+		Rate:        r.Rate,
+		Requests:    len(samples),
+		Duration:    duration,
+		DurationMS:  milliseconds(duration),
+	}
+
+	var latencies, ttfts []time.Duration
+	tokens := 0
+	for _, s := range samples {
+		if s.failure != "" {
+			if report.Errors == nil {
+				report.Errors = make(map[string]int)
+			}
+			report.Errors[s.failure]++
+			report.Failed++
+			continue
+		}
+		report.Succeeded++
+		latencies = append(latencies, s.latency)
+		if r.Stream && s.ttft > 0 {
+			ttfts = append(ttfts, s.ttft)
+		}
+		tokens += s.tokens
+	}
+	if report.Requests > 0 {
+		report.ErrorRate = float64(report.Failed) / float64(report.Requests)
+	}
+	if seconds := duration.Seconds(); seconds > 0 {
+		report.Throughput = float64(report.Succeeded) / seconds
+		report.TokenThroughput = float64(tokens) / seconds
+	}
+	report.Latency = distribution(latencies)
+	if r.Stream {
+		ttft := distribution(ttfts)
+		report.TTFT = &ttft
+	}
+	return report
+}
+
+// distribution computes the mean and nearest-rank percentiles of durations
+func distribution(durations []time.Duration) Distribution {
+	if len(durations) == 0 {
+		return Distribution{}
+	}
+	sort.Slice(durations, func(i, j int) bool { return durations[i] < durations[j] })
+	var total time.Duration
+	for _, d := range durations {
+		total += d
+	}
+	percentile := func(p float64) time.Duration {
+		rank := int(math.Ceil(p/100*float64(len(durations)))) - 1
+		return durations[max(rank, 0)]
+	}
+	return Distribution{
+		Mean: total / time.Duration(len(durations)),
+		P50:  percentile(50),
+		P95:  percentile(95),
+		P99:  percentile(99),
+		Max:  durations[len(durations)-1],
+	}
+}
+
+// milliseconds converts d for the JSON report
+func milliseconds(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+// WriteText writes the report for people
+func (r *Report) WriteText(w io.Writer) {
+	mode := "non-streamed"
+	if r.Stream {
+		mode = "streamed"
+	}
+	model := r.Model
+	if model == "" {
+		model = "default model"
+	}
+	fmt.Fprintf(w, "%d %s %s requests to %s in %s, concurrency %d\n\n", r.Requests, mode, r.Endpoint, model, r.Duration.Round(time.Millisecond), r.Concurrency)
+	fmt.Fprintf(w, "Succeeded    %d (%.1f req/s, %.1f tokens/s)\n", r.Succeeded, r.Throughput, r.TokenThroughput)
+	fmt.Fprintf(w, "Failed       %d (%.2f%%)\n", r.Failed, 100*r.ErrorRate)
+	kinds := make([]string, 0, len(r.Errors))
+	for kind := range r.Errors {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	for _, kind := range kinds {
+		fmt.Fprintf(w, "  %-10s %d\n", kind, r.Errors[kind])
+	}
+
+	fmt.Fprintf(w, "\n%-12s %10s %10s %10s %10s %10s\n", "", "mean", "p50", "p95", "p99", "max")
+	writeDistribution(w, "Latency", r.Latency)
+	if r.TTFT != nil {
+		writeDistribution(w, "TTFT", *r.TTFT)
+	}
+}
+
+func writeDistribution(w io.Writer, name string, d Distribution) {
+	values := []string{}
+	for _, v := range []time.Duration{d.Mean, d.P50, d.P95, d.P99, d.Max} {
+		values = append(values, fmt.Sprintf("%10s", v.Round(100*time.Microsecond)))
+	}
+	fmt.Fprintf(w, "%-12s %s\n", name, strings.Join(values, " "))
+}
+
+// WriteJSON writes the report as JSON
+func (r *Report) WriteJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(r)
+}
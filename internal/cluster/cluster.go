@@ -0,0 +1,48 @@
+// Package cluster coordinates the replicas of a deployment: one of them at a
+// time refreshes the GitHub tokens and the others pick the result up from a
+// store they share.
+package cluster
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"time"
+)
+
+// Store is the state shared by the replicas: a value, the token record, and
+// a lease naming the replica allowed to replace it
+type Store interface {
+	// Get returns the stored value, nil when none was stored yet
+	Get(ctx context.Context) ([]byte, error)
+	// Put replaces the stored value
+	Put(ctx context.Context, value []byte) error
+	// Acquire takes the lease for holder for ttl, or extends it when holder
+	// holds it already. It reports false while another holder has it.
+	Acquire(ctx context.Context, holder string, ttl time.Duration) (bool, error)
+	// Release gives up the lease if holder holds it
+	Release(ctx context.Context, holder string) error
+	// String describes the store for logs, without credentials
+	String() string
+}
+
+// Open returns the store at rawURL:
+//
+//	redis://[:password@]host[:port][/db][?key=reai:token]  (rediss:// for TLS)
+//	kubernetes://[namespace]/secret
+//
+// The Kubernetes store keeps the value in a Secret and the lease in a
+// coordination.k8s.io Lease of the same name, using the pod's service account.
+func Open(rawURL string) (Store, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid shared token store URL: %w", err)
+	}
+	switch u.Scheme {
+	case "redis", "rediss":
+		return newRedisStore(u)
+	case "kubernetes", "k8s":
+		return newKubernetesStore(u)
+	}
+	return nil, fmt.Errorf("unsupported shared token store %q (use redis://, rediss:// or kubernetes://)", u.Scheme)
+}
@@ -0,0 +1,173 @@
+package cluster
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+const (
+	// namespaceFile holds the namespace of the pod
+	namespaceFile = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+	// secretKey is the key of the Secret data holding the value
+	secretKey = "token"
+)
+
+// kubernetesStore keeps the value in a Secret and the lease in a Lease of
+// the same name, managed with client-go's leader election lock. The service
+// account needs get, create and update on both, and patch on the Secret.
+type kubernetesStore struct {
+	client    kubernetes.Interface
+	namespace string
+	name      string
+	now       func() time.Time
+}
+
+func newKubernetesStore(u *url.URL) (*kubernetesStore, error) {
+	name := strings.Trim(u.Path, "/")
+	if name == "" || strings.Contains(name, "/") {
+		return nil, fmt.Errorf("kubernetes shared token store needs kubernetes://[namespace]/<secret>")
+	}
+	cfg, err := rest.InClusterConfig()
+	if err != nil {
+		return nil, fmt.Errorf("kubernetes shared token store only works inside a pod: %w", err)
+	}
+	cfg.Timeout = 10 * time.Second
+	client, err := kubernetes.NewForConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	namespace := u.Host
+	if namespace == "" {
+		data, err := os.ReadFile(namespaceFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the pod namespace: %w", err)
+		}
+		namespace = strings.TrimSpace(string(data))
+	}
+	return &kubernetesStore{client: client, namespace: namespace, name: name, now: time.Now}, nil
+}
+
+func (s *kubernetesStore) String() string {
+	return fmt.Sprintf("kubernetes://%s/%s", s.namespace, s.name)
+}
+
+func (s *kubernetesStore) Get(ctx context.Context) ([]byte, error) {
+	secret, err := s.client.CoreV1().Secrets(s.namespace).Get(ctx, s.name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return secret.Data[secretKey], nil
+}
+
+func (s *kubernetesStore) Put(ctx context.Context, value []byte) error {
+	secrets := s.client.CoreV1().Secrets(s.namespace)
+	patch, err := json.Marshal(map[string]interface{}{"data": map[string][]byte{secretKey: value}})
+	if err != nil {
+		return err
+	}
+	_, err = secrets.Patch(ctx, s.name, types.MergePatchType, patch, metav1.PatchOptions{})
+	if !apierrors.IsNotFound(err) {
+		return err
+	}
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: s.name, Namespace: s.namespace},
+		Data:       map[string][]byte{secretKey: value},
+	}
+	_, err = secrets.Create(ctx, secret, metav1.CreateOptions{})
+	return err
+}
+
+// lock returns the leader election lock of the Lease for holder
+func (s *kubernetesStore) lock(holder string) *resourcelock.LeaseLock {
+	return &resourcelock.LeaseLock{
+		LeaseMeta:  metav1.ObjectMeta{Name: s.name, Namespace: s.namespace},
+		Client:     s.client.CoordinationV1(),
+		LockConfig: resourcelock.ResourceLockConfig{Identity: holder},
+	}
+}
+
+func (s *kubernetesStore) Acquire(ctx context.Context, holder string, ttl time.Duration) (bool, error) {
+	now := metav1.NewTime(s.now())
+	record := resourcelock.LeaderElectionRecord{
+		HolderIdentity:       holder,
+		LeaseDurationSeconds: max(int(ttl.Seconds()), 1),
+		AcquireTime:          now,
+		RenewTime:            now,
+	}
+
+	lock := s.lock(holder)
+	current, _, err := lock.Get(ctx)
+	if apierrors.IsNotFound(err) {
+		err = lock.Create(ctx, record)
+		if apierrors.IsAlreadyExists(err) {
+			// Another replica created it first
+			return false, nil
+		}
+		return err == nil, err
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if current.HolderIdentity != "" && current.HolderIdentity != holder && !s.leaseExpired(current) {
+		return false, nil
+	}
+	if current.HolderIdentity == holder {
+		record.AcquireTime = current.AcquireTime
+		record.LeaderTransitions = current.LeaderTransitions
+	} else {
+		record.LeaderTransitions = current.LeaderTransitions + 1
+	}
+	// The lock updates the Lease it got, so the update fails if another
+	// replica got there first
+	err = lock.Update(ctx, record)
+	if apierrors.IsConflict(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (s *kubernetesStore) Release(ctx context.Context, holder string) error {
+	lock := s.lock(holder)
+	current, _, err := lock.Get(ctx)
+	if err != nil {
+		return err
+	}
+	if current.HolderIdentity != holder {
+		return nil
+	}
+	// Released the way client-go's leader election does it
+	now := metav1.NewTime(s.now())
+	err = lock.Update(ctx, resourcelock.LeaderElectionRecord{
+		LeaseDurationSeconds: 1,
+		AcquireTime:          now,
+		RenewTime:            now,
+		LeaderTransitions:    current.LeaderTransitions,
+	})
+	if apierrors.IsConflict(err) {
+		return nil
+	}
+	return err
+}
+
+// leaseExpired reports whether the holder of record failed to renew it in time
+func (s *kubernetesStore) leaseExpired(record *resourcelock.LeaderElectionRecord) bool {
+	return s.now().After(record.RenewTime.Add(time.Duration(record.LeaseDurationSeconds) * time.Second))
+}
@@ -0,0 +1,40 @@
+package cluster
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestKubernetesStore(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	store := &kubernetesStore{client: client, namespace: "reai", name: "copilot", now: func() time.Time { return now }}
+	ctx := context.Background()
+
+	if value, err := store.Get(ctx); value != nil || err != nil {
+		t.Errorf("empty store: %q, %v", value, err)
+	}
+	// The first Put creates the Secret, the next ones patch it
+	for _, value := range []string{`{"token":"a"}`, `{"token":"b"}`} {
+		if err := store.Put(ctx, []byte(value)); err != nil {
+			t.Fatal(err)
+		}
+		if got, err := store.Get(ctx); string(got) != value || err != nil {
+			t.Errorf("stored %q, %v, want %s", got, err, value)
+		}
+	}
+
+	testLease(t, store, func(d time.Duration) { now = now.Add(d) })
+
+	lease, err := client.CoordinationV1().Leases("reai").Get(ctx, "copilot", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *lease.Spec.HolderIdentity != "a" || *lease.Spec.LeaseDurationSeconds != 10 || *lease.Spec.LeaseTransitions != 2 {
+		t.Errorf("lease %+v", lease.Spec)
+	}
+}
@@ -0,0 +1,86 @@
+package cluster
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// redisDialTimeout bounds connecting to Redis
+const redisDialTimeout = 5 * time.Second
+
+// acquireScript takes or extends the lease in KEYS[1] for ARGV[1] for
+// ARGV[2] milliseconds
+var acquireScript = redis.NewScript(`local holder = redis.call('GET', KEYS[1])
+if not holder or holder == ARGV[1] then
+  redis.call('SET', KEYS[1], ARGV[1], 'PX', ARGV[2])
+  return 1
+end
+return 0`)
+
+// releaseScript deletes the lease in KEYS[1] if ARGV[1] holds it
+var releaseScript = redis.NewScript(`if redis.call('GET', KEYS[1]) == ARGV[1] then
+  return redis.call('DEL', KEYS[1])
+end
+return 0`)
+
+// redisStore keeps the value under <key> and the lease under <key>:leader
+type redisStore struct {
+	client *redis.Client
+	addr   string
+	db     int
+	key    string
+}
+
+func newRedisStore(u *url.URL) (*redisStore, error) {
+	key := "reai:token"
+	query := u.Query()
+	if k := query.Get("key"); k != "" {
+		key = k
+	}
+	// The key is ours; the other query parameters are go-redis options
+	query.Del("key")
+	withoutKey := *u
+	withoutKey.RawQuery = query.Encode()
+
+	opts, err := redis.ParseURL(withoutKey.String())
+	if err != nil {
+		return nil, fmt.Errorf("invalid Redis URL: %w", err)
+	}
+	if query.Get("dial_timeout") == "" {
+		opts.DialTimeout = redisDialTimeout
+	}
+	return &redisStore{client: redis.NewClient(opts), addr: opts.Addr, db: opts.DB, key: key}, nil
+}
+
+func (s *redisStore) String() string {
+	return fmt.Sprintf("redis://%s/%d (%s)", s.addr, s.db, s.key)
+}
+
+func (s *redisStore) Get(ctx context.Context) ([]byte, error) {
+	data, err := s.client.Get(ctx, s.key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	return data, err
+}
+
+func (s *redisStore) Put(ctx context.Context, value []byte) error {
+	return s.client.Set(ctx, s.key, value, 0).Err()
+}
+
+func (s *redisStore) Acquire(ctx context.Context, holder string, ttl time.Duration) (bool, error) {
+	held, err := acquireScript.Run(ctx, s.client, []string{s.key + ":leader"}, holder, ttl.Milliseconds()).Int()
+	if err != nil {
+		return false, err
+	}
+	return held == 1, nil
+}
+
+func (s *redisStore) Release(ctx context.Context, holder string) error {
+	return releaseScript.Run(ctx, s.client, []string{s.key + ":leader"}, holder).Err()
+}
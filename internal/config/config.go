@@ -4,29 +4,18 @@ import (
 	"os"
 	"path/filepath"
 	"strconv"
+	"time"
 )
 
 // GitHub OAuth constants
 const (
 	ClientID               = "Iv1.b507a08c87ecfe98"
-	UserAgent              = "GitHubCopilot/1.228.0"
-	EditorVersion          = "vscode/1.87.0"
-	EditorPluginVersion    = "copilot/1.228.0"
-)
-
-// API endpoints
-const (
-	DeviceCodeURL    = "https://github.com/login/device/code"
-	AccessTokenURL   = "https://github.com/login/oauth/access_token"
-	SessionTokenURL  = "https://api.github.com/copilot_internal/v2/token"
-	CompletionsURL   = "https://copilot-proxy.githubusercontent.com/v1/engines/copilot-codex/completions"
-	ModelsURL        = "https://api.enterprise.githubcopilot.com/models"
-	ModelsURLAlt     = "https://api.githubcopilot.com/models"
 )
 
 // Token refresh settings
 const (
-	TokenRefreshBufferSeconds    = 60      // Refresh 60 seconds before expiry
+	TokenRefreshBufferSeconds    = 60      // Stop using a token 60 seconds before expiry
+	TokenRefreshAheadSeconds     = 5 * 60  // Refresh in the background 5 minutes before expiry
 	DefaultTokenLifetimeSeconds  = 25 * 60 // 25 minutes fallback
 )
 
@@ -34,6 +23,7 @@ const (
 const (
 	MaxConcurrentRequests = 100
 	MaxPromptLength      = 8192
+	MaxQueuedRequests    = 100
 )
 
 // Config holds the application configuration
@@ -42,8 +32,296 @@ type Config struct {
 	ClientID         string `json:"client_id"`
 	DataDir          string `json:"data_dir"`
 	LogLevel         string `json:"log_level"`
+	LogSensitive     bool   `json:"log_sensitive"`
 	RateLimit        int    `json:"rate_limit"`
 	MaxPromptLength  int    `json:"max_prompt_length"`
+	ListenSocket     string `json:"listen_socket"`
+
+	// API key authentication (disabled when neither is set)
+	APIKeysFile string `json:"api_keys_file"`
+	APIKeys     string `json:"-"`
+
+	// AdminToken protects the /admin endpoints (disabled when empty)
+	AdminToken string `json:"-"`
+
+	// Maintenance windows ("<cron> <duration>" entries separated by ';') and client message
+	MaintenanceWindows string `json:"maintenance_windows"`
+	MaintenanceMessage string `json:"maintenance_message"`
+	// MaintenanceTimezone is the IANA time zone the windows are scheduled in
+	// (server local time when empty)
+	MaintenanceTimezone string `json:"maintenance_timezone"`
+
+	// Optional gRPC listener (disabled when GRPCPort is 0). gRPC needs HTTP/2,
+	// which net/http only serves over TLS, so a certificate is required.
+	GRPCPort    int    `json:"grpc_port"`
+	GRPCTLSCert string `json:"grpc_tls_cert"`
+	GRPCTLSKey  string `json:"grpc_tls_key"`
+
+	// InternalAddr moves the health, metrics, admin and debug endpoints off
+	// the public listener to a second one (e.g. "localhost:9090")
+	InternalAddr string `json:"internal_addr"`
+
+	// Dev enables development mode (DEV_MODE or --dev): API keys become optional,
+	// the server only listens on localhost, completions come from the mock
+	// unless UPSTREAM_MODE is set and config files are reloaded on change
+	Dev bool `json:"dev"`
+
+	// ConversationsEnabled turns on the server-side conversation store under DataDir
+	ConversationsEnabled bool `json:"conversations_enabled"`
+	// PromptsEnabled turns on the shared prompt template library under DataDir
+	PromptsEnabled bool `json:"prompts_enabled"`
+
+	// ClampMaxTokens lowers max_tokens to fit the model context window instead
+	// of rejecting the request
+	ClampMaxTokens bool `json:"clamp_max_tokens"`
+	// ContextTrimStrategy shortens chat histories that don't fit the model
+	// context window: drop_oldest, sliding_window or summarize. Empty rejects
+	// them, except stored conversations, which drop their oldest turns.
+	ContextTrimStrategy string `json:"context_trim_strategy"`
+	// ContextTrimWindow is the number of recent messages sliding_window keeps
+	ContextTrimWindow int `json:"context_trim_window"`
+	// ContextSummaryModel writes the summaries of the summarize strategy
+	ContextSummaryModel string `json:"context_summary_model"`
+
+	// StripCodeFences removes the markdown fence code completions now and
+	// then end with
+	StripCodeFences bool `json:"strip_code_fences"`
+	// CompletionPostprocess lists the post-processors run over code
+	// completions (comma separated, none when empty)
+	CompletionPostprocess string `json:"completion_postprocess"`
+
+	// ResponseExtensions adds the x_reai object to responses; turn it off for
+	// clients that reject unknown fields
+	ResponseExtensions bool `json:"response_extensions"`
+
+	// StreamCompression compresses event streams with zstd or gzip for
+	// clients that accept it, flushing the compressor after every event
+	StreamCompression bool `json:"stream_compression"`
+
+	// StreamHeartbeat is the interval of keep-alive comments on idle event
+	// streams (disabled when 0)
+	StreamHeartbeat time.Duration `json:"stream_heartbeat"`
+
+	// ResponseCompression gzips or deflates buffered JSON and text responses
+	// for clients that accept it
+	ResponseCompression bool `json:"response_compression"`
+
+	// MaxRequestBodyBytes bounds JSON request bodies and MaxJSONDepth their
+	// nesting; file uploads and conversation imports have their own limits
+	MaxRequestBodyBytes int64 `json:"max_request_body_bytes"`
+	MaxJSONDepth        int   `json:"max_json_depth"`
+
+	// StrictCompat rejects request fields outside the OpenAI API, omits the
+	// ReAI extensions and checks responses against the OpenAI schemas
+	StrictCompat bool `json:"strict_compat"`
+
+	// AzureDeployments maps the deployments of the Azure OpenAI routes to
+	// models as "deployment=model,..." (deployments are models when empty)
+	AzureDeployments string `json:"azure_deployments"`
+
+	// IdempotencyTTL is how long responses to requests with an
+	// Idempotency-Key are kept for replay (ignored when 0)
+	IdempotencyTTL time.Duration `json:"idempotency_ttl"`
+
+	// Default prices per 1K tokens of the cost estimates (nothing is priced
+	// while both are 0 and PricingFile prices no model)
+	CostPer1KPromptTokens     float64 `json:"cost_per_1k_prompt_tokens"`
+	CostPer1KCompletionTokens float64 `json:"cost_per_1k_completion_tokens"`
+	CostCurrency              string  `json:"cost_currency"`
+	// PricingFile prices models one by one, falling back to the prices above
+	PricingFile string `json:"pricing_file"`
+
+	// WatermarkSecret signs the response watermark header (disabled when empty)
+	WatermarkSecret string `json:"-"`
+	// WatermarkRetention is how long the usage records paired with watermarks
+	// are kept (forever when 0)
+	WatermarkRetention time.Duration `json:"watermark_retention"`
+
+	// Audit log of completions ("-" for stdout, disabled when empty) and what it captures
+	AuditLog                string `json:"audit_log"`
+	AuditCapturePrompts     bool   `json:"audit_capture_prompts"`
+	AuditCaptureCompletions bool   `json:"audit_capture_completions"`
+	AuditMaxTranscriptBytes int    `json:"audit_max_transcript_bytes"`
+
+	// FiltersFile configures the prompt/completion content filters (disabled when empty)
+	FiltersFile string `json:"filters_file"`
+
+	// ProbesFile configures synthetic monitoring probes (disabled when empty)
+	ProbesFile string `json:"probes_file"`
+
+	// SinksFile configures external analytics sinks for audit records
+	// (disabled when empty)
+	SinksFile string `json:"sinks_file"`
+
+	// WebhooksFile configures webhooks notified of events such as lost
+	// authentication (disabled when empty)
+	WebhooksFile string `json:"webhooks_file"`
+
+	// SlackWebhookURL and DiscordWebhookURL receive a chat message when
+	// GitHub authentication is needed or the session token can't be renewed
+	SlackWebhookURL   string `json:"-"`
+	DiscordWebhookURL string `json:"-"`
+
+	// ModerationFile configures the classifier behind /v1/moderations
+	// (everything is allowed when empty)
+	ModerationFile string `json:"moderation_file"`
+	// ExperimentsFile configures A/B experiments that serve a share of the
+	// requests for a model with another one
+	ExperimentsFile string `json:"experiments_file"`
+	// AudioUpstreamURL is the OpenAI compatible API audio requests are
+	// forwarded to with AudioUpstreamAPIKey (refused when empty)
+	AudioUpstreamURL    string `json:"audio_upstream_url"`
+	AudioUpstreamAPIKey string `json:"-"`
+
+	// ProvidersFile routes model prefixes to backend providers other than
+	// Copilot (every model goes to Copilot when empty)
+	ProvidersFile string `json:"providers_file"`
+	// UpstreamMode "record" saves Copilot responses to RecordingsDir,
+	// "replay" serves them back and "mock" answers with canned completions,
+	// the last two without contacting GitHub (off when empty)
+	UpstreamMode  string `json:"upstream_mode"`
+	RecordingsDir string `json:"recordings_dir"`
+	// Canned responses of the mock mode (echo when empty) and its streaming pace
+	MockResponsesFile string        `json:"mock_responses_file"`
+	MockTokenDelay    time.Duration `json:"mock_token_delay"`
+	// CoalesceRequests sends identical completions in flight at the same
+	// time upstream once, sharing the result
+	CoalesceRequests bool `json:"coalesce_requests"`
+
+	// Callers using a decoy key are blocked for DecoyBlockDuration when DecoyBlockIP is set
+	DecoyBlockIP       bool          `json:"decoy_block_ip"`
+	DecoyBlockDuration time.Duration `json:"decoy_block_duration"`
+
+	// Request queueing when RateLimit concurrent requests are in flight
+	QueueDepth        int           `json:"queue_depth"`
+	QueueMaxWait      time.Duration `json:"queue_max_wait"`
+	QueueMinRemaining time.Duration `json:"queue_min_remaining"`
+	// Concurrency shares of the key priority classes in percent of RateLimit,
+	// e.g. "high=100,normal=80,low=25"
+	PriorityShares string `json:"priority_shares"`
+	// Load shedding: low priority requests are turned away while the Go heap
+	// or the goroutine count is past its threshold (0 = no threshold)
+	ShedHeapBytes  int64 `json:"shed_heap_bytes"`
+	ShedGoroutines int   `json:"shed_goroutines"`
+	// Token bucket per client IP while no API keys are configured: IPRateBurst
+	// requests at once, then IPRateLimit per minute (0 = no limit)
+	IPRateLimit int `json:"ip_rate_limit"`
+	IPRateBurst int `json:"ip_rate_burst"`
+
+	// Middleware chain around every endpoint: the names in MiddlewareOrder,
+	// outermost first (built-in order when empty), less MiddlewareDisable
+	MiddlewareOrder   string `json:"middleware_order"`
+	MiddlewareDisable string `json:"middleware_disable"`
+
+	// Outbound proxy for upstream requests (http, https, socks5 or socks5h URL).
+	// Empty means fall back to HTTP_PROXY/HTTPS_PROXY/NO_PROXY.
+	UpstreamProxy         string `json:"upstream_proxy"`
+	UpstreamProxyUsername string `json:"upstream_proxy_username"`
+	UpstreamProxyPassword string `json:"-"`
+	UpstreamCABundle      string `json:"upstream_ca_bundle"`
+
+	// Upstream TLS options
+	UpstreamClientCert         string `json:"upstream_client_cert"`
+	UpstreamClientKey          string `json:"upstream_client_key"`
+	UpstreamInsecureSkipVerify bool   `json:"upstream_insecure_skip_verify"`
+
+	// Upstream connection pool tuning
+	UpstreamTimeout             time.Duration `json:"upstream_timeout"`
+	UpstreamDialTimeout         time.Duration `json:"upstream_dial_timeout"`
+	UpstreamTLSHandshakeTimeout time.Duration `json:"upstream_tls_handshake_timeout"`
+	UpstreamKeepAlive           time.Duration `json:"upstream_keep_alive"`
+	UpstreamIdleConnTimeout     time.Duration `json:"upstream_idle_conn_timeout"`
+	UpstreamMaxIdleConns        int           `json:"upstream_max_idle_conns"`
+	UpstreamMaxIdleConnsPerHost int           `json:"upstream_max_idle_conns_per_host"`
+	UpstreamMaxConnsPerHost     int           `json:"upstream_max_conns_per_host"`
+	UpstreamHTTP2               bool          `json:"upstream_http2"`
+	// UpstreamLogSampleRate is the share of successful upstream requests
+	// logged at info level; failures are always logged
+	UpstreamLogSampleRate float64 `json:"upstream_log_sample_rate"`
+
+	// GitHub instance used for authentication (GitHubAPIURL is derived from
+	// GitHubBaseURL when empty)
+	GitHubBaseURL string `json:"github_base_url"`
+	GitHubAPIURL  string `json:"github_api_url"`
+
+	// UpstreamProfile selects the Copilot hosts: auto, individual, business or enterprise
+	UpstreamProfile string `json:"upstream_profile"`
+	// CopilotAPIURL (models, chat) and CopilotProxyURL (completions) replace
+	// the hosts of the profile and stop them following the session token
+	CopilotAPIURL   string `json:"copilot_api_url"`
+	CopilotProxyURL string `json:"copilot_proxy_url"`
+	// EditorProfile names the editor upstream requests claim to come from;
+	// EditorProfilesFile adds profiles to the built-in ones
+	EditorProfile      string `json:"editor_profile"`
+	EditorProfilesFile string `json:"editor_profiles_file"`
+	// EditorVersionsURL serves current editor profiles, fetched at startup
+	// and every EditorVersionsInterval (disabled when empty)
+	EditorVersionsURL      string        `json:"editor_versions_url"`
+	EditorVersionsInterval time.Duration `json:"editor_versions_interval"`
+
+	// Upstream name resolution: static "host=ip,ip; ..." overrides and a DNS
+	// cache (disabled when the TTL is 0)
+	UpstreamHosts       string        `json:"upstream_hosts"`
+	UpstreamDNSCacheTTL time.Duration `json:"upstream_dns_cache_ttl"`
+
+	// Readiness checks: HealthCheckUpstream pings the Copilot API, each check
+	// is bounded by HealthCheckTimeout
+	HealthCheckUpstream bool          `json:"health_check_upstream"`
+	// PolicyCheck stops startup when the account's policy disables Copilot
+	// completions or chat
+	PolicyCheck bool `json:"policy_check"`
+	HealthCheckTimeout  time.Duration `json:"health_check_timeout"`
+	// ShutdownTimeout bounds how long in-flight requests and batch jobs may
+	// take to finish once a shutdown signal arrives
+	ShutdownTimeout time.Duration `json:"shutdown_timeout"`
+
+	// FilesEnabled turns on the files API under DataDir; uploads are capped
+	// at FilesMaxBytes
+	FilesEnabled  bool  `json:"files_enabled"`
+	FilesMaxBytes int64 `json:"files_max_bytes"`
+
+	// BatchesEnabled turns on the batch API (and the files API it needs);
+	// batch requests run in the background at BatchRequestsPerMinute
+	BatchesEnabled         bool `json:"batches_enabled"`
+	BatchRequestsPerMinute int  `json:"batch_requests_per_minute"`
+
+	// WarmModels lists slow models that get a warm upstream connection pool
+	// (disabled when empty). The pool is refreshed every WarmPoolInterval
+	// while one of the models was used within WarmPoolIdle.
+	WarmModels       string        `json:"warm_models"`
+	WarmPoolSize     int           `json:"warm_pool_size"`
+	WarmPoolInterval time.Duration `json:"warm_pool_interval"`
+	WarmPoolIdle     time.Duration `json:"warm_pool_idle"`
+	WarmPoolPrime    bool          `json:"warm_pool_prime"`
+
+	// UpstreamMonthlyRequests is the monthly request allowance of the Copilot
+	// account, used for the quota forecast when Copilot sends no quota
+	// headers (0 = unknown). QuotaAlertThreshold is the share of it at
+	// which the forecast raises an alert.
+	UpstreamMonthlyRequests int     `json:"upstream_monthly_requests"`
+	QuotaAlertThreshold     float64 `json:"quota_alert_threshold"`
+
+	// DatabaseFile is the SQLite database holding usage, stored API keys,
+	// idempotent responses, batches and conversations (DATA_DIR/reai.db
+	// when empty)
+	DatabaseFile string `json:"database_file"`
+
+	// ReadOnly writes no files at all, for containers without a writable
+	// volume: the database is kept in memory and features storing files
+	// can't be enabled
+	ReadOnly bool `json:"read_only"`
+	// GitHub access token given by the environment or a mounted secret
+	// instead of DATA_DIR/token
+	GitHubAccessToken     string `json:"-"`
+	GitHubAccessTokenFile string `json:"github_access_token_file"`
+
+	// SharedTokenStore is where replicas share the GitHub tokens (redis://
+	// or kubernetes:// URL, disabled when empty). One replica at a time,
+	// holding the lease for SharedTokenLease, refreshes them.
+	SharedTokenStore string        `json:"-"`
+	SharedTokenLease time.Duration `json:"shared_token_lease"`
+	// ReplicaID names this replica in the lease (the hostname by default)
+	ReplicaID string `json:"replica_id"`
 }
 
 // LoadFromEnv creates a new Config from environment variables
@@ -68,16 +346,304 @@ func LoadFromEnv() *Config {
 	}
 
 	logLevel := getEnvString("LOG_LEVEL", "info")
+	logSensitive := getEnvBool("LOG_SENSITIVE", false)
 	rateLimit := getEnvInt("RATE_LIMIT", MaxConcurrentRequests)
 	maxPromptLength := getEnvInt("MAX_PROMPT_LENGTH", MaxPromptLength)
+	listenSocket := getEnvString("LISTEN_SOCKET", "")
+	apiKeysFile := getEnvString("API_KEYS_FILE", "")
+	apiKeys := getEnvString("API_KEYS", "")
+	adminToken := getEnvString("ADMIN_TOKEN", "")
+	maintenanceWindows := getEnvString("MAINTENANCE_WINDOWS", "")
+	maintenanceMessage := getEnvString("MAINTENANCE_MESSAGE", "")
+	maintenanceTimezone := getEnvString("MAINTENANCE_TIMEZONE", "")
+	grpcPort := getEnvInt("GRPC_PORT", 0)
+	grpcTLSCert := getEnvString("GRPC_TLS_CERT", "")
+	grpcTLSKey := getEnvString("GRPC_TLS_KEY", "")
+	internalAddr := getEnvString("INTERNAL_ADDR", "")
+	dev := getEnvBool("DEV_MODE", false)
+	conversationsEnabled := getEnvBool("CONVERSATIONS_ENABLED", false)
+	promptsEnabled := getEnvBool("PROMPTS_ENABLED", false)
+	clampMaxTokens := getEnvBool("CLAMP_MAX_TOKENS", true)
+	contextTrimStrategy := getEnvString("CONTEXT_TRIM_STRATEGY", "")
+	contextTrimWindow := getEnvInt("CONTEXT_TRIM_WINDOW", 20)
+	contextSummaryModel := getEnvString("CONTEXT_SUMMARY_MODEL", "gpt-4o-mini")
+	stripCodeFences := getEnvBool("STRIP_CODE_FENCES", true)
+	completionPostprocess := getEnvString("COMPLETION_POSTPROCESS", "")
+	responseExtensions := getEnvBool("RESPONSE_EXTENSIONS", true)
+	streamCompression := getEnvBool("STREAM_COMPRESSION", false)
+	streamHeartbeat := getEnvDuration("STREAM_HEARTBEAT", 0)
+	responseCompression := getEnvBool("RESPONSE_COMPRESSION", true)
+	maxRequestBodyBytes := getEnvInt("MAX_REQUEST_BODY_BYTES", 10<<20)
+	maxJSONDepth := getEnvInt("MAX_JSON_DEPTH", 64)
+	strictCompat := getEnvBool("STRICT_COMPAT", false)
+	azureDeployments := getEnvString("AZURE_DEPLOYMENTS", "")
+	idempotencyTTL := getEnvDuration("IDEMPOTENCY_TTL", 24*time.Hour)
+	costPer1KPromptTokens := getEnvFloat("COST_PER_1K_PROMPT_TOKENS", 0)
+	costPer1KCompletionTokens := getEnvFloat("COST_PER_1K_COMPLETION_TOKENS", 0)
+	costCurrency := getEnvString("COST_CURRENCY", "USD")
+	pricingFile := getEnvString("PRICING_FILE", "")
+	watermarkSecret := getEnvString("WATERMARK_SECRET", "")
+	watermarkRetention := getEnvDuration("WATERMARK_RETENTION", 90*24*time.Hour)
+	auditLog := getEnvString("AUDIT_LOG", "")
+	auditCapturePrompts := getEnvBool("AUDIT_CAPTURE_PROMPTS", true)
+	auditCaptureCompletions := getEnvBool("AUDIT_CAPTURE_COMPLETIONS", true)
+	auditMaxTranscriptBytes := getEnvInt("AUDIT_MAX_TRANSCRIPT_BYTES", 65536)
+	filtersFile := getEnvString("FILTERS_FILE", "")
+	probesFile := getEnvString("PROBES_FILE", "")
+	sinksFile := getEnvString("SINKS_FILE", "")
+	webhooksFile := getEnvString("WEBHOOKS_FILE", "")
+	slackWebhookURL := getEnvString("SLACK_WEBHOOK_URL", "")
+	discordWebhookURL := getEnvString("DISCORD_WEBHOOK_URL", "")
+	moderationFile := getEnvString("MODERATION_FILE", "")
+	experimentsFile := getEnvString("EXPERIMENTS_FILE", "")
+	audioUpstreamURL := getEnvString("AUDIO_UPSTREAM_URL", "")
+	audioUpstreamAPIKey := getEnvString("AUDIO_UPSTREAM_API_KEY", "")
+	providersFile := getEnvString("PROVIDERS_FILE", "")
+	upstreamMode := getEnvString("UPSTREAM_MODE", "")
+	recordingsDir := getEnvString("RECORDINGS_DIR", "")
+	mockResponsesFile := getEnvString("MOCK_RESPONSES_FILE", "")
+	mockTokenDelay := getEnvDuration("MOCK_TOKEN_DELAY", 20*time.Millisecond)
+	coalesceRequests := getEnvBool("COALESCE_REQUESTS", true)
+	decoyBlockIP := getEnvBool("DECOY_BLOCK_IP", false)
+	decoyBlockDuration := getEnvDuration("DECOY_BLOCK_DURATION", 24*time.Hour)
+	queueDepth := getEnvInt("QUEUE_DEPTH", MaxQueuedRequests)
+	queueMaxWait := getEnvDuration("QUEUE_MAX_WAIT", 10*time.Second)
+	queueMinRemaining := getEnvDuration("QUEUE_MIN_REMAINING", 500*time.Millisecond)
+	priorityShares := getEnvString("PRIORITY_SHARES", "")
+	shedHeapBytes := getEnvInt("SHED_HEAP_BYTES", 0)
+	shedGoroutines := getEnvInt("SHED_GOROUTINES", 0)
+	ipRateLimit := getEnvInt("IP_RATE_LIMIT", 600)
+	ipRateBurst := getEnvInt("IP_RATE_BURST", 60)
+	middlewareOrder := getEnvString("MIDDLEWARE_ORDER", "")
+	middlewareDisable := getEnvString("MIDDLEWARE_DISABLE", "")
+	upstreamProxy := getEnvString("UPSTREAM_PROXY", "")
+	upstreamProxyUsername := getEnvString("UPSTREAM_PROXY_USERNAME", "")
+	upstreamProxyPassword := getEnvString("UPSTREAM_PROXY_PASSWORD", "")
+	upstreamCABundle := getEnvString("UPSTREAM_CA_BUNDLE", "")
+	upstreamClientCert := getEnvString("UPSTREAM_CLIENT_CERT", "")
+	upstreamClientKey := getEnvString("UPSTREAM_CLIENT_KEY", "")
+	upstreamInsecureSkipVerify := getEnvBool("UPSTREAM_TLS_INSECURE_SKIP_VERIFY", false)
+	upstreamTimeout := getEnvDuration("UPSTREAM_TIMEOUT", 30*time.Second)
+	upstreamDialTimeout := getEnvDuration("UPSTREAM_DIAL_TIMEOUT", 10*time.Second)
+	upstreamTLSHandshakeTimeout := getEnvDuration("UPSTREAM_TLS_HANDSHAKE_TIMEOUT", 10*time.Second)
+	upstreamKeepAlive := getEnvDuration("UPSTREAM_KEEP_ALIVE", 30*time.Second)
+	upstreamIdleConnTimeout := getEnvDuration("UPSTREAM_IDLE_CONN_TIMEOUT", 90*time.Second)
+	upstreamMaxIdleConns := getEnvInt("UPSTREAM_MAX_IDLE_CONNS", 100)
+	upstreamMaxIdleConnsPerHost := getEnvInt("UPSTREAM_MAX_IDLE_CONNS_PER_HOST", 32)
+	upstreamMaxConnsPerHost := getEnvInt("UPSTREAM_MAX_CONNS_PER_HOST", 0)
+	upstreamHTTP2 := getEnvBool("UPSTREAM_HTTP2", true)
+	upstreamLogSampleRate := getEnvFloat("UPSTREAM_LOG_SAMPLE_RATE", 0.01)
+	githubBaseURL := getEnvString("GITHUB_BASE_URL", DefaultGitHubBaseURL)
+	githubAPIURL := getEnvString("GITHUB_API_URL", "")
+	upstreamProfile := getEnvString("UPSTREAM_PROFILE", "auto")
+	copilotAPIURL := getEnvString("COPILOT_API_URL", "")
+	copilotProxyURL := getEnvString("COPILOT_PROXY_URL", "")
+	editorProfile := getEnvString("EDITOR_PROFILE", "vscode")
+	editorProfilesFile := getEnvString("EDITOR_PROFILES_FILE", "")
+	editorVersionsURL := getEnvString("EDITOR_VERSIONS_URL", "")
+	editorVersionsInterval := getEnvDuration("EDITOR_VERSIONS_INTERVAL", 24*time.Hour)
+	upstreamHosts := getEnvString("UPSTREAM_HOSTS", "")
+	upstreamDNSCacheTTL := getEnvDuration("UPSTREAM_DNS_CACHE_TTL", 0)
+	healthCheckUpstream := getEnvBool("HEALTH_CHECK_UPSTREAM", false)
+	policyCheck := getEnvBool("POLICY_CHECK", true)
+	healthCheckTimeout := getEnvDuration("HEALTH_CHECK_TIMEOUT", 5*time.Second)
+	shutdownTimeout := getEnvDuration("SHUTDOWN_TIMEOUT", 30*time.Second)
+	filesEnabled := getEnvBool("FILES_ENABLED", false)
+	filesMaxBytes := getEnvInt("FILES_MAX_BYTES", 200<<20)
+	batchesEnabled := getEnvBool("BATCHES_ENABLED", false)
+	batchRequestsPerMinute := getEnvInt("BATCH_REQUESTS_PER_MINUTE", 60)
+	warmModels := getEnvString("WARM_MODELS", "")
+	warmPoolSize := getEnvInt("WARM_POOL_SIZE", 2)
+	warmPoolInterval := getEnvDuration("WARM_POOL_INTERVAL", 30*time.Second)
+	warmPoolIdle := getEnvDuration("WARM_POOL_IDLE", 15*time.Minute)
+	warmPoolPrime := getEnvBool("WARM_POOL_PRIME", false)
+	upstreamMonthlyRequests := getEnvInt("UPSTREAM_MONTHLY_REQUESTS", 0)
+	quotaAlertThreshold := getEnvFloat("QUOTA_ALERT_THRESHOLD", 0.9)
+	databaseFile := getEnvString("DATABASE_FILE", "")
+	readOnly := getEnvBool("READ_ONLY", false)
+	githubAccessToken := getEnvString("GITHUB_ACCESS_TOKEN", "")
+	githubAccessTokenFile := getEnvString("GITHUB_ACCESS_TOKEN_FILE", "")
+	sharedTokenStore := getEnvString("SHARED_TOKEN_STORE", "")
+	sharedTokenLease := getEnvDuration("SHARED_TOKEN_LEASE", 30*time.Second)
+	hostname, _ := os.Hostname()
+	replicaID := getEnvString("REPLICA_ID", hostname)
 
-	return &Config{
+	cfg := &Config{
 		Port:             port,
 		ClientID:         clientID,
 		DataDir:          dataDir,
 		LogLevel:         logLevel,
+		LogSensitive:     logSensitive,
 		RateLimit:        rateLimit,
 		MaxPromptLength:  maxPromptLength,
+		ListenSocket:     listenSocket,
+
+		APIKeysFile: apiKeysFile,
+		APIKeys:     apiKeys,
+
+		AdminToken: adminToken,
+
+		MaintenanceWindows:  maintenanceWindows,
+		MaintenanceMessage:  maintenanceMessage,
+		MaintenanceTimezone: maintenanceTimezone,
+
+		GRPCPort:    grpcPort,
+		GRPCTLSCert: grpcTLSCert,
+		GRPCTLSKey:  grpcTLSKey,
+
+		InternalAddr: internalAddr,
+
+		Dev: dev,
+
+		ConversationsEnabled: conversationsEnabled,
+		PromptsEnabled:       promptsEnabled,
+
+		ClampMaxTokens:  clampMaxTokens,
+		StripCodeFences: stripCodeFences,
+
+		CompletionPostprocess: completionPostprocess,
+
+		ContextTrimStrategy: contextTrimStrategy,
+		ContextTrimWindow:   contextTrimWindow,
+		ContextSummaryModel: contextSummaryModel,
+
+		ResponseExtensions: responseExtensions,
+		StreamCompression:  streamCompression,
+		StreamHeartbeat:    streamHeartbeat,
+		StrictCompat:       strictCompat,
+		AzureDeployments:   azureDeployments,
+		IdempotencyTTL:     idempotencyTTL,
+
+		ResponseCompression: responseCompression,
+		MaxRequestBodyBytes: int64(maxRequestBodyBytes),
+		MaxJSONDepth:        maxJSONDepth,
+
+		CostPer1KPromptTokens:     costPer1KPromptTokens,
+		CostPer1KCompletionTokens: costPer1KCompletionTokens,
+		CostCurrency:              costCurrency,
+		PricingFile:               pricingFile,
+
+		WatermarkSecret:    watermarkSecret,
+		WatermarkRetention: watermarkRetention,
+
+		AuditLog:                auditLog,
+		AuditCapturePrompts:     auditCapturePrompts,
+		AuditCaptureCompletions: auditCaptureCompletions,
+		AuditMaxTranscriptBytes: auditMaxTranscriptBytes,
+
+		FiltersFile: filtersFile,
+		ProbesFile:  probesFile,
+		SinksFile:   sinksFile,
+
+		WebhooksFile:      webhooksFile,
+		SlackWebhookURL:   slackWebhookURL,
+		DiscordWebhookURL: discordWebhookURL,
+
+		ModerationFile:      moderationFile,
+		ExperimentsFile:     experimentsFile,
+		AudioUpstreamURL:    audioUpstreamURL,
+		AudioUpstreamAPIKey: audioUpstreamAPIKey,
+
+		ProvidersFile: providersFile,
+		UpstreamMode:  upstreamMode,
+		RecordingsDir: recordingsDir,
+
+		MockResponsesFile: mockResponsesFile,
+		MockTokenDelay:    mockTokenDelay,
+		CoalesceRequests:  coalesceRequests,
+
+		DecoyBlockIP:       decoyBlockIP,
+		DecoyBlockDuration: decoyBlockDuration,
+
+		QueueDepth:        queueDepth,
+		QueueMaxWait:      queueMaxWait,
+		QueueMinRemaining: queueMinRemaining,
+		PriorityShares:    priorityShares,
+		ShedHeapBytes:     int64(shedHeapBytes),
+		ShedGoroutines:    shedGoroutines,
+		IPRateLimit:       ipRateLimit,
+		IPRateBurst:       ipRateBurst,
+		MiddlewareOrder:   middlewareOrder,
+		MiddlewareDisable: middlewareDisable,
+
+		UpstreamProxy:         upstreamProxy,
+		UpstreamProxyUsername: upstreamProxyUsername,
+		UpstreamProxyPassword: upstreamProxyPassword,
+		UpstreamCABundle:      upstreamCABundle,
+
+		UpstreamClientCert:         upstreamClientCert,
+		UpstreamClientKey:          upstreamClientKey,
+		UpstreamInsecureSkipVerify: upstreamInsecureSkipVerify,
+
+		UpstreamTimeout:             upstreamTimeout,
+		UpstreamDialTimeout:         upstreamDialTimeout,
+		UpstreamTLSHandshakeTimeout: upstreamTLSHandshakeTimeout,
+		UpstreamKeepAlive:           upstreamKeepAlive,
+		UpstreamIdleConnTimeout:     upstreamIdleConnTimeout,
+		UpstreamMaxIdleConns:        upstreamMaxIdleConns,
+		UpstreamMaxIdleConnsPerHost: upstreamMaxIdleConnsPerHost,
+		UpstreamMaxConnsPerHost:     upstreamMaxConnsPerHost,
+		UpstreamHTTP2:               upstreamHTTP2,
+		UpstreamLogSampleRate:       upstreamLogSampleRate,
+
+		GitHubBaseURL: githubBaseURL,
+		GitHubAPIURL:  githubAPIURL,
+
+		UpstreamProfile:    upstreamProfile,
+		CopilotAPIURL:      copilotAPIURL,
+		CopilotProxyURL:    copilotProxyURL,
+		EditorProfile:      editorProfile,
+		EditorProfilesFile: editorProfilesFile,
+
+		EditorVersionsURL:      editorVersionsURL,
+		EditorVersionsInterval: editorVersionsInterval,
+
+		UpstreamHosts:       upstreamHosts,
+		UpstreamDNSCacheTTL: upstreamDNSCacheTTL,
+
+		HealthCheckUpstream: healthCheckUpstream,
+		PolicyCheck:         policyCheck,
+		HealthCheckTimeout:  healthCheckTimeout,
+		ShutdownTimeout:     shutdownTimeout,
+
+		FilesEnabled:  filesEnabled || batchesEnabled,
+		FilesMaxBytes: int64(filesMaxBytes),
+
+		BatchesEnabled:         batchesEnabled,
+		BatchRequestsPerMinute: batchRequestsPerMinute,
+
+		WarmModels:       warmModels,
+		WarmPoolSize:     warmPoolSize,
+		WarmPoolInterval: warmPoolInterval,
+		WarmPoolIdle:     warmPoolIdle,
+		WarmPoolPrime:    warmPoolPrime,
+
+		UpstreamMonthlyRequests: upstreamMonthlyRequests,
+		QuotaAlertThreshold:     quotaAlertThreshold,
+
+		DatabaseFile: databaseFile,
+
+		ReadOnly:              readOnly,
+		GitHubAccessToken:     githubAccessToken,
+		GitHubAccessTokenFile: githubAccessTokenFile,
+
+		SharedTokenStore: sharedTokenStore,
+		SharedTokenLease: sharedTokenLease,
+		ReplicaID:        replicaID,
+	}
+	if dev {
+		cfg.EnableDev()
+	}
+	return cfg
+}
+
+// EnableDev turns on development mode. Completions come from the mock
+// upstream unless UPSTREAM_MODE is set, even to an empty value, so no GitHub
+// login is needed.
+func (c *Config) EnableDev() {
+	c.Dev = true
+	if _, ok := os.LookupEnv("UPSTREAM_MODE"); !ok {
+		c.UpstreamMode = "mock"
 	}
 }
 
@@ -86,6 +652,94 @@ func (c *Config) TokenFilePath() string {
 	return filepath.Join(c.DataDir, "token")
 }
 
+// AccessTokenPath returns the file the GitHub access token is read from,
+// GITHUB_ACCESS_TOKEN_FILE or the token file
+func (c *Config) AccessTokenPath() string {
+	if c.GitHubAccessTokenFile != "" {
+		return c.GitHubAccessTokenFile
+	}
+	return c.TokenFilePath()
+}
+
+// ReadOnlyConflicts lists the settings that write files, which READ_ONLY
+// doesn't allow
+func (c *Config) ReadOnlyConflicts() []string {
+	var conflicts []string
+	if c.DatabaseFile != "" {
+		conflicts = append(conflicts, "DATABASE_FILE")
+	}
+	if c.AuditLog != "" && c.AuditLog != "-" {
+		conflicts = append(conflicts, "AUDIT_LOG (use - for stdout)")
+	}
+	if c.UpstreamMode == "record" {
+		conflicts = append(conflicts, "UPSTREAM_MODE=record")
+	}
+	if c.PromptsEnabled {
+		conflicts = append(conflicts, "PROMPTS_ENABLED")
+	}
+	if c.BatchesEnabled {
+		conflicts = append(conflicts, "BATCHES_ENABLED")
+	} else if c.FilesEnabled {
+		conflicts = append(conflicts, "FILES_ENABLED")
+	}
+	return conflicts
+}
+
+// DatabasePath returns the SQLite database, DATABASE_FILE or DATA_DIR/reai.db
+func (c *Config) DatabasePath() string {
+	if c.DatabaseFile != "" {
+		return c.DatabaseFile
+	}
+	return filepath.Join(c.DataDir, "reai.db")
+}
+
+// QuotaFilePath returns the path to the per-key day and month usage saved by
+// versions before the database, moved into it on start
+func (c *Config) QuotaFilePath() string {
+	return filepath.Join(c.DataDir, "quota.json")
+}
+
+// ConsumptionFilePath returns the path to the upstream consumption record
+func (c *Config) ConsumptionFilePath() string {
+	return filepath.Join(c.DataDir, "consumption.json")
+}
+
+// RecordingsPath returns the directory of recorded upstream responses,
+// RECORDINGS_DIR or DATA_DIR/recordings
+func (c *Config) RecordingsPath() string {
+	if c.RecordingsDir != "" {
+		return c.RecordingsDir
+	}
+	return filepath.Join(c.DataDir, "recordings")
+}
+
+// Offline reports whether completions are served without GitHub, from
+// recordings or the mock
+func (c *Config) Offline() bool {
+	return c.UpstreamMode == "replay" || c.UpstreamMode == "mock"
+}
+
+// ConversationsDir returns the directory of the conversations stored by
+// versions before the database, moved into it on start
+func (c *Config) ConversationsDir() string {
+	return filepath.Join(c.DataDir, "conversations")
+}
+
+// PromptsDir returns the directory holding prompt templates
+func (c *Config) PromptsDir() string {
+	return filepath.Join(c.DataDir, "prompts")
+}
+
+// FilesDir returns the directory holding uploaded and generated files
+func (c *Config) FilesDir() string {
+	return filepath.Join(c.DataDir, "files")
+}
+
+// BatchesDir returns the directory holding the partial results of batches
+func (c *Config) BatchesDir() string {
+	return filepath.Join(c.DataDir, "batches")
+}
+
 // Helper functions for environment variable handling
 func getEnvString(key, defaultValue string) string {
 	if value := os.Getenv(key); value != "" {
@@ -111,3 +765,21 @@ func getEnvBool(key string, defaultValue bool) bool {
 	}
 	return defaultValue
 }
+
+func getEnvFloat(key string, defaultValue float64) float64 {
+	if value := os.Getenv(key); value != "" {
+		if parsed, err := strconv.ParseFloat(value, 64); err == nil {
+			return parsed
+		}
+	}
+	return defaultValue
+}
+
+func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	if value := os.Getenv(key); value != "" {
+		if parsed, err := time.ParseDuration(value); err == nil {
+			return parsed
+		}
+	}
+	return defaultValue
+}
@@ -0,0 +1,39 @@
+package config
+
+import (
+	"os"
+	"testing"
+)
+
+func TestDevModeUpstream(t *testing.T) {
+	tests := []struct {
+		name     string
+		env      map[string]string
+		flag     bool
+		wantMode string
+	}{
+		{"off", map[string]string{}, false, ""},
+		{"DEV_MODE", map[string]string{"DEV_MODE": "true"}, false, "mock"},
+		{"--dev", map[string]string{}, true, "mock"},
+		{"replay", map[string]string{"DEV_MODE": "true", "UPSTREAM_MODE": "replay"}, false, "replay"},
+		{"copilot", map[string]string{"UPSTREAM_MODE": ""}, true, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("DEV_MODE", "")
+			// t.Setenv restores the variable, which may be unset meanwhile
+			t.Setenv("UPSTREAM_MODE", "")
+			os.Unsetenv("UPSTREAM_MODE")
+			for name, value := range tt.env {
+				t.Setenv(name, value)
+			}
+			cfg := LoadFromEnv()
+			if tt.flag {
+				cfg.EnableDev()
+			}
+			if cfg.UpstreamMode != tt.wantMode {
+				t.Errorf("UpstreamMode %q, want %q", cfg.UpstreamMode, tt.wantMode)
+			}
+		})
+	}
+}
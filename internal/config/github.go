@@ -0,0 +1,65 @@
+package config
+
+import (
+	"net/url"
+	"strings"
+)
+
+// DefaultGitHubBaseURL is the GitHub instance used unless GITHUB_BASE_URL is set
+const DefaultGitHubBaseURL = "https://github.com"
+
+// DeviceCodeURL returns the OAuth device code endpoint
+func (c *Config) DeviceCodeURL() string {
+	return c.githubBaseURL() + "/login/device/code"
+}
+
+// AccessTokenURL returns the OAuth access token endpoint
+func (c *Config) AccessTokenURL() string {
+	return c.githubBaseURL() + "/login/oauth/access_token"
+}
+
+// SessionTokenURL returns the endpoint exchanging the access token for a Copilot session token
+func (c *Config) SessionTokenURL() string {
+	return c.GitHubAPI() + "/copilot_internal/v2/token"
+}
+
+// GitHubAPI returns the REST API base URL of the GitHub instance. github.com
+// and data residency tenants on *.ghe.com serve it from an api. subdomain,
+// GitHub Enterprise Server under /api/v3.
+func (c *Config) GitHubAPI() string {
+	if c.GitHubAPIURL != "" {
+		return strings.TrimSuffix(c.GitHubAPIURL, "/")
+	}
+	base := c.githubBaseURL()
+	u, err := url.Parse(base)
+	if err != nil || u.Host == "" {
+		return base + "/api/v3"
+	}
+	if u.Host == "github.com" || strings.HasSuffix(u.Host, ".ghe.com") {
+		return u.Scheme + "://api." + u.Host
+	}
+	return base + "/api/v3"
+}
+
+// IsGitHubDotCom reports whether the public github.com is used
+func (c *Config) IsGitHubDotCom() bool {
+	return c.githubBaseURL() == DefaultGitHubBaseURL
+}
+
+// TenantCopilotURLs returns the Copilot API and completions proxy base URLs of
+// a *.ghe.com tenant. ok is false for other instances, which rely on the hosts
+// published with the session token.
+func (c *Config) TenantCopilotURLs() (api, proxy string, ok bool) {
+	u, err := url.Parse(c.githubBaseURL())
+	if err != nil || !strings.HasSuffix(u.Host, ".ghe.com") {
+		return "", "", false
+	}
+	return u.Scheme + "://copilot-api." + u.Host, u.Scheme + "://copilot-proxy." + u.Host, true
+}
+
+func (c *Config) githubBaseURL() string {
+	if c.GitHubBaseURL == "" {
+		return DefaultGitHubBaseURL
+	}
+	return strings.TrimSuffix(c.GitHubBaseURL, "/")
+}
@@ -0,0 +1,436 @@
+package conversations
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/devstroop/reai/internal/store"
+)
+
+// ErrNotFound is returned for unknown conversation IDs
+var ErrNotFound = errors.New("conversation not found")
+
+// ErrConflict is returned when a conversation changed while it was compacted
+var ErrConflict = errors.New("conversation changed while it was compacted")
+
+// maxLineSize bounds a single JSONL record on import
+const maxLineSize = 16 * 1024 * 1024
+
+var validID = regexp.MustCompile(`^[A-Za-z0-9_-]{1,128}$`)
+
+// Message is one turn of a conversation
+type Message struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+	// ToolCalls and ToolCallID keep the tool calls of an assistant message
+	// and the call a tool message answers, as the client sent them
+	ToolCalls  json.RawMessage `json:"tool_calls,omitempty"`
+	ToolCallID string          `json:"tool_call_id,omitempty"`
+	Name       string          `json:"name,omitempty"`
+	// ResponseID is the completion response that produced an assistant message
+	ResponseID string    `json:"response_id,omitempty"`
+	Created    time.Time `json:"created"`
+}
+
+// Conversation is a stored chat history
+type Conversation struct {
+	ID       string    `json:"id"`
+	Owner    string    `json:"owner,omitempty"`
+	Model    string    `json:"model,omitempty"`
+	Created  time.Time `json:"created"`
+	Updated  time.Time `json:"updated"`
+	Messages []Message `json:"messages"`
+}
+
+// Summary describes a conversation without its messages
+type Summary struct {
+	ID       string    `json:"id"`
+	Model    string    `json:"model,omitempty"`
+	Created  time.Time `json:"created"`
+	Updated  time.Time `json:"updated"`
+	Messages int       `json:"messages"`
+}
+
+// ImportResult reports the outcome of an import
+type ImportResult struct {
+	Imported int      `json:"imported"`
+	Skipped  int      `json:"skipped"`
+	Errors   []string `json:"errors,omitempty"`
+}
+
+// Store keeps conversations in memory and persists them in the database
+type Store struct {
+	mutex         sync.RWMutex
+	db            *store.DB
+	conversations map[string]*Conversation
+	// responses maps the response ID of every assistant message to its conversation
+	responses map[string]string
+}
+
+// Open loads the conversations stored in db, first moving in those an older
+// version kept as JSON files in legacyDir
+func Open(db *store.DB, legacyDir string) (*Store, error) {
+	s := &Store{db: db, conversations: make(map[string]*Conversation), responses: make(map[string]string)}
+	rows, err := db.Query("SELECT data FROM conversations")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read conversations: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var data []byte
+		if err := rows.Scan(&data); err != nil {
+			return nil, fmt.Errorf("failed to read conversations: %w", err)
+		}
+		var c Conversation
+		if err := json.Unmarshal(data, &c); err != nil {
+			return nil, fmt.Errorf("failed to parse conversation: %w", err)
+		}
+		s.conversations[c.ID] = &c
+		s.index(&c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read conversations: %w", err)
+	}
+
+	if err := s.importDir(legacyDir); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// importDir stores the conversations an older version kept as JSON files in
+// dir and removes the files (none when dir is empty)
+func (s *Store) importDir(dir string) error {
+	if dir == "" {
+		return nil
+	}
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil || len(paths) == 0 {
+		return err
+	}
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("failed to read conversation: %w", err)
+		}
+		var c Conversation
+		if err := json.Unmarshal(data, &c); err != nil {
+			return fmt.Errorf("failed to parse conversation %s: %w", filepath.Base(path), err)
+		}
+		if _, ok := s.conversations[c.ID]; !ok {
+			if err := s.put(&c); err != nil {
+				return err
+			}
+		}
+		if err := os.Remove(path); err != nil {
+			return fmt.Errorf("failed to remove imported conversation: %w", err)
+		}
+	}
+	os.Remove(dir)
+	slog.Info("Conversations moved to the database", "dir", dir, "conversations", len(paths))
+	return nil
+}
+
+// NewID returns a new random conversation ID
+func NewID() string {
+	buf := make([]byte, 12)
+	rand.Read(buf)
+	return "conv_" + hex.EncodeToString(buf)
+}
+
+// Len returns the number of stored conversations
+func (s *Store) Len() int {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return len(s.conversations)
+}
+
+// Get returns a copy of the conversation if it belongs to owner
+func (s *Store) Get(owner, id string) (*Conversation, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	c, ok := s.conversations[id]
+	if !ok || c.Owner != owner {
+		return nil, ErrNotFound
+	}
+	return c.clone(), nil
+}
+
+// Put creates or replaces a conversation
+func (s *Store) Put(c *Conversation) error {
+	if !validID.MatchString(c.ID) {
+		return fmt.Errorf("invalid conversation id %q", c.ID)
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.put(c.clone())
+}
+
+// Delete removes a conversation belonging to owner
+func (s *Store) Delete(owner, id string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	c, ok := s.conversations[id]
+	if !ok || c.Owner != owner {
+		return ErrNotFound
+	}
+	if _, err := s.db.Exec("DELETE FROM conversations WHERE id = ?", id); err != nil {
+		return fmt.Errorf("failed to delete conversation: %w", err)
+	}
+	s.unindex(c)
+	delete(s.conversations, id)
+	return nil
+}
+
+// FindResponse returns a copy of the conversation belonging to owner that holds
+// the assistant message produced by responseID, and that message's index
+func (s *Store) FindResponse(owner, responseID string) (*Conversation, int, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	c, ok := s.conversations[s.responses[responseID]]
+	if !ok || c.Owner != owner {
+		return nil, 0, ErrNotFound
+	}
+	for i, msg := range c.Messages {
+		if msg.ResponseID == responseID {
+			return c.clone(), i, nil
+		}
+	}
+	return nil, 0, ErrNotFound
+}
+
+// Append adds messages to the conversation, creating it for owner if it
+// doesn't exist yet. Conversations owned by someone else are not found.
+func (s *Store) Append(owner, id, model string, messages []Message) error {
+	if !validID.MatchString(id) {
+		return fmt.Errorf("invalid conversation id %q", id)
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := time.Now().UTC()
+	c := &Conversation{ID: id, Owner: owner, Created: now}
+	if existing, ok := s.conversations[id]; ok {
+		if existing.Owner != owner {
+			return ErrNotFound
+		}
+		c = existing.clone()
+	}
+	if model != "" {
+		c.Model = model
+	}
+	for _, msg := range messages {
+		if msg.Created.IsZero() {
+			msg.Created = now
+		}
+		c.Messages = append(c.Messages, msg)
+	}
+	c.Updated = now
+	return s.put(c)
+}
+
+// Compact replaces the first len(old) messages of the conversation with head,
+// provided they are still old. Messages appended since are kept.
+func (s *Store) Compact(owner, id string, old, head []Message) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	existing, ok := s.conversations[id]
+	if !ok || existing.Owner != owner {
+		return ErrNotFound
+	}
+	if len(existing.Messages) < len(old) || !reflect.DeepEqual(existing.Messages[:len(old)], old) {
+		return ErrConflict
+	}
+
+	now := time.Now().UTC()
+	c := existing.clone()
+	c.Messages = make([]Message, 0, len(head)+len(existing.Messages)-len(old))
+	for _, msg := range head {
+		if msg.Created.IsZero() {
+			msg.Created = now
+		}
+		c.Messages = append(c.Messages, msg)
+	}
+	c.Messages = append(c.Messages, existing.Messages[len(old):]...)
+	c.Updated = now
+	return s.put(c)
+}
+
+// List returns summaries of the conversations belonging to owner, newest first
+func (s *Store) List(owner string) []Summary {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	var summaries []Summary
+	for _, c := range s.conversations {
+		if c.Owner == owner {
+			summaries = append(summaries, Summary{ID: c.ID, Model: c.Model, Created: c.Created, Updated: c.Updated, Messages: len(c.Messages)})
+		}
+	}
+	sort.Slice(summaries, func(i, j int) bool {
+		return summaries[i].Updated.After(summaries[j].Updated)
+	})
+	return summaries
+}
+
+// Export writes the conversations belonging to owner as JSONL, one conversation per line.
+// The owner is left out so exports can be imported under a different key or instance.
+func (s *Store) Export(w io.Writer, owner string) (int, error) {
+	s.mutex.RLock()
+	var export []*Conversation
+	for _, c := range s.conversations {
+		if c.Owner == owner {
+			export = append(export, c.clone())
+		}
+	}
+	s.mutex.RUnlock()
+
+	sort.Slice(export, func(i, j int) bool {
+		return export[i].Created.Before(export[j].Created)
+	})
+	encoder := json.NewEncoder(w)
+	for _, c := range export {
+		c.Owner = ""
+		if err := encoder.Encode(c); err != nil {
+			return 0, err
+		}
+	}
+	return len(export), nil
+}
+
+// Import reads JSONL conversations and stores them under owner. Existing
+// conversations with the same ID are kept unless replace is set; IDs owned by
+// someone else are always skipped. Invalid lines are reported and skipped.
+func (s *Store) Import(r io.Reader, owner string, replace bool) (*ImportResult, error) {
+	result := &ImportResult{}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxLineSize)
+
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+
+		var c Conversation
+		if err := json.Unmarshal([]byte(text), &c); err != nil {
+			result.Errors = append(result.Errors, fmt.Sprintf("line %d: %s", line, err))
+			continue
+		}
+		if err := c.prepare(owner); err != nil {
+			result.Errors = append(result.Errors, fmt.Sprintf("line %d: %s", line, err))
+			continue
+		}
+
+		imported, err := s.importOne(&c, replace)
+		if err != nil {
+			return result, err
+		}
+		if imported {
+			result.Imported++
+		} else {
+			result.Skipped++
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return result, fmt.Errorf("failed to read import: %w", err)
+	}
+	return result, nil
+}
+
+func (s *Store) importOne(c *Conversation, replace bool) (bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if existing, ok := s.conversations[c.ID]; ok && (!replace || existing.Owner != c.Owner) {
+		return false, nil
+	}
+	return true, s.put(c)
+}
+
+// prepare validates an imported conversation and fills in missing fields
+func (c *Conversation) prepare(owner string) error {
+	if c.ID == "" {
+		c.ID = NewID()
+	}
+	if !validID.MatchString(c.ID) {
+		return fmt.Errorf("invalid conversation id %q", c.ID)
+	}
+	for i, msg := range c.Messages {
+		switch msg.Role {
+		case "system", "user", "assistant", "tool":
+		default:
+			return fmt.Errorf("message %d has invalid role %q", i, msg.Role)
+		}
+	}
+
+	now := time.Now().UTC()
+	if c.Created.IsZero() {
+		c.Created = now
+	}
+	if c.Updated.IsZero() {
+		c.Updated = c.Created
+	}
+	for i := range c.Messages {
+		if c.Messages[i].Created.IsZero() {
+			c.Messages[i].Created = c.Created
+		}
+	}
+	c.Owner = owner
+	return nil
+}
+
+// put stores c; the caller holds the write lock
+func (s *Store) put(c *Conversation) error {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	if _, err := s.db.Exec("INSERT INTO conversations (id, owner, updated, data) VALUES (?, ?, ?, ?) "+
+		"ON CONFLICT (id) DO UPDATE SET owner = excluded.owner, updated = excluded.updated, data = excluded.data",
+		c.ID, c.Owner, c.Updated.Unix(), string(data)); err != nil {
+		return fmt.Errorf("failed to write conversation: %w", err)
+	}
+	if existing, ok := s.conversations[c.ID]; ok {
+		s.unindex(existing)
+	}
+	s.conversations[c.ID] = c
+	s.index(c)
+	return nil
+}
+
+// index records the response IDs of c; the caller holds the write lock
+func (s *Store) index(c *Conversation) {
+	for _, msg := range c.Messages {
+		if msg.ResponseID != "" {
+			s.responses[msg.ResponseID] = c.ID
+		}
+	}
+}
+
+// unindex forgets the response IDs of c; the caller holds the write lock
+func (s *Store) unindex(c *Conversation) {
+	for _, msg := range c.Messages {
+		if s.responses[msg.ResponseID] == c.ID {
+			delete(s.responses, msg.ResponseID)
+		}
+	}
+}
+
+func (c *Conversation) clone() *Conversation {
+	copied := *c
+	copied.Messages = append([]Message(nil), c.Messages...)
+	return &copied
+}
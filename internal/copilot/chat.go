@@ -0,0 +1,186 @@
+package copilot
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+
+	"github.com/devstroop/reai/internal/logging"
+	"github.com/devstroop/reai/pkg/errors"
+)
+
+// DefaultChatModel is the model chat requests naming none are sent to
+const DefaultChatModel = "gpt-4o"
+
+// chatIntegrationID is sent as Copilot-Integration-Id with chat requests when
+// the editor profile names none; the chat endpoint refuses requests without
+const chatIntegrationID = "vscode-chat"
+
+// chatBody builds the payload of the chat completions endpoint. The messages
+// are sent as they are, so the model sees the whole conversation, its own
+// earlier replies included.
+func chatBody(req *CompletionRequest) map[string]interface{} {
+	model := req.Model
+	if model == "" {
+		model = DefaultChatModel
+	}
+	body := map[string]interface{}{
+		"model":    model,
+		"messages": req.Messages,
+		"n":        1,
+		"stream":   true,
+	}
+	if req.MaxTokens > 0 {
+		// Reasoning models reject max_tokens, which excludes reasoning tokens
+		if IsReasoningModel(model) {
+			body["max_completion_tokens"] = req.MaxTokens
+		} else {
+			body["max_tokens"] = req.MaxTokens
+		}
+	}
+	if req.ReasoningEffort != "" {
+		body["reasoning_effort"] = req.ReasoningEffort
+	}
+	if req.Temperature != nil {
+		body["temperature"] = *req.Temperature
+	}
+	if req.TopP != nil {
+		body["top_p"] = *req.TopP
+	}
+	if req.PresencePenalty != nil {
+		body["presence_penalty"] = *req.PresencePenalty
+	}
+	if req.FrequencyPenalty != nil {
+		body["frequency_penalty"] = *req.FrequencyPenalty
+	}
+	if len(req.Stop) > 0 {
+		body["stop"] = req.Stop
+	}
+	if len(req.Tools) > 0 {
+		body["tools"] = req.Tools
+		if len(req.ToolChoice) > 0 {
+			body["tool_choice"] = req.ToolChoice
+		}
+		if req.ParallelToolCalls != nil {
+			body["parallel_tool_calls"] = *req.ParallelToolCalls
+		}
+	}
+	if req.Logprobs != nil {
+		body["logprobs"] = true
+		if *req.Logprobs > 0 {
+			body["top_logprobs"] = *req.Logprobs
+		}
+	}
+	return body
+}
+
+// chatStreamChunk is the JSON payload of a chat completions streaming event
+type chatStreamChunk struct {
+	Choices []struct {
+		Delta struct {
+			Content string `json:"content"`
+			// Models that think stream it as reasoning_text
+			ReasoningText string `json:"reasoning_text"`
+			ToolCalls     []struct {
+				Index    int    `json:"index"`
+				ID       string `json:"id"`
+				Function struct {
+					Name      string `json:"name"`
+					Arguments string `json:"arguments"`
+				} `json:"function"`
+			} `json:"tool_calls"`
+		} `json:"delta"`
+		Logprobs *struct {
+			Content []struct {
+				Token       string  `json:"token"`
+				Logprob     float64 `json:"logprob"`
+				TopLogprobs []struct {
+					Token   string  `json:"token"`
+					Logprob float64 `json:"logprob"`
+				} `json:"top_logprobs"`
+			} `json:"content"`
+		} `json:"logprobs"`
+		FinishReason *string `json:"finish_reason"`
+	} `json:"choices"`
+}
+
+// parseChatStream parses the event stream of the chat completions endpoint.
+// Like parseStreamingResponse it stops as soon as ctx is done.
+func (c *Client) parseChatStream(ctx context.Context, body io.Reader, onChunk func(CompletionChunk) error) error {
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	debug := logging.Debugging(ctx)
+	offset := 0
+	for scanner.Scan() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		line := scanner.Text()
+		if debug && line != "" {
+			slog.DebugContext(ctx, "Upstream stream chunk", "body", line)
+		}
+		data, ok := strings.CutPrefix(line, "data:")
+		if !ok {
+			continue
+		}
+		data = strings.TrimSpace(data)
+		if data == "[DONE]" {
+			return nil
+		}
+
+		var event chatStreamChunk
+		if err := json.Unmarshal([]byte(data), &event); err != nil {
+			slog.DebugContext(ctx, "Failed to parse streaming chunk", "error", err, "body", data)
+			continue
+		}
+		// Copilot opens the stream with the prompt filter results, which
+		// have no choices
+		if len(event.Choices) == 0 {
+			continue
+		}
+
+		choice := event.Choices[0]
+		chunk := CompletionChunk{Text: choice.Delta.Content, Reasoning: choice.Delta.ReasoningText}
+		if choice.FinishReason != nil {
+			chunk.FinishReason = *choice.FinishReason
+		}
+		for _, call := range choice.Delta.ToolCalls {
+			chunk.ToolCalls = append(chunk.ToolCalls, ToolCallDelta{
+				Index:     call.Index,
+				ID:        call.ID,
+				Name:      call.Function.Name,
+				Arguments: call.Function.Arguments,
+			})
+		}
+		if choice.Logprobs != nil && len(choice.Logprobs.Content) > 0 {
+			chunk.Logprobs = &Logprobs{}
+			for _, token := range choice.Logprobs.Content {
+				top := make(map[string]float64, len(token.TopLogprobs))
+				for _, alt := range token.TopLogprobs {
+					top[alt.Token] = alt.Logprob
+				}
+				chunk.Logprobs.Tokens = append(chunk.Logprobs.Tokens, token.Token)
+				chunk.Logprobs.TokenLogprobs = append(chunk.Logprobs.TokenLogprobs, token.Logprob)
+				chunk.Logprobs.TopLogprobs = append(chunk.Logprobs.TopLogprobs, top)
+				chunk.Logprobs.TextOffset = append(chunk.Logprobs.TextOffset, offset)
+				offset += len(token.Token)
+			}
+		}
+		if chunk.Text == "" && chunk.Reasoning == "" && chunk.Logprobs == nil && chunk.FinishReason == "" && len(chunk.ToolCalls) == 0 {
+			continue
+		}
+		if err := onChunk(chunk); err != nil {
+			return err
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return errors.NewCopilotAPIError(fmt.Sprintf("Failed to read completion stream: %s", err.Error()))
+	}
+	return nil
+}
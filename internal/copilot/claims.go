@@ -0,0 +1,116 @@
+package copilot
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TokenClaims are the claims carried by a Copilot session token
+type TokenClaims struct {
+	// ExpiresAt is zero when the token names no expiry
+	ExpiresAt   time.Time `json:"expires_at"`
+	SKU         string    `json:"sku,omitempty"`
+	ChatEnabled bool      `json:"chat_enabled"`
+	// LimitedUser is set for plans with a capped allowance, like Copilot Free
+	LimitedUser bool   `json:"limited_user"`
+	TrackingID  string `json:"tracking_id,omitempty"`
+	// Endpoints are the hosts serving the account's plan (empty when the
+	// token names none)
+	Endpoints TokenEndpoints `json:"endpoints"`
+}
+
+// TokenEndpoints are the base URLs of the Copilot API and completions proxy
+type TokenEndpoints struct {
+	API   string `json:"api,omitempty"`
+	Proxy string `json:"proxy,omitempty"`
+}
+
+// jwtClaims is the payload of a session token in JWT form
+type jwtClaims struct {
+	Exp         int64          `json:"exp"`
+	SKU         string         `json:"sku"`
+	ChatEnabled *bool          `json:"chat_enabled"`
+	LimitedUser bool           `json:"limited_user"`
+	TrackingID  string         `json:"tracking_id"`
+	Endpoints   TokenEndpoints `json:"endpoints"`
+}
+
+// parseTokenClaims reads the claims of a session token, a JWT or the
+// "tid=...;exp=...;sku=...;proxy-ep=...:<signature>" form GitHub hands out
+func parseTokenClaims(token string) (*TokenClaims, error) {
+	if parts := strings.Split(token, "."); len(parts) == 3 {
+		payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+		if err == nil {
+			var jwt jwtClaims
+			if err := json.Unmarshal(payload, &jwt); err != nil {
+				return nil, fmt.Errorf("invalid JWT claims: %w", err)
+			}
+			claims := &TokenClaims{
+				SKU:         jwt.SKU,
+				ChatEnabled: jwt.ChatEnabled == nil || *jwt.ChatEnabled,
+				LimitedUser: jwt.LimitedUser || strings.Contains(jwt.SKU, "limited"),
+				TrackingID:  jwt.TrackingID,
+				Endpoints:   jwt.Endpoints,
+			}
+			if jwt.Exp > 0 {
+				claims.ExpiresAt = time.Unix(jwt.Exp, 0)
+			}
+			return claims, nil
+		}
+	}
+	return parseLegacyClaims(token)
+}
+
+// parseLegacyClaims reads a "key=value;key=value:<signature>" session token
+func parseLegacyClaims(token string) (*TokenClaims, error) {
+	if i := strings.LastIndex(token, ":"); i >= 0 {
+		token = token[:i]
+	}
+	fields := make(map[string]string)
+	for _, pair := range strings.Split(token, ";") {
+		if key, value, ok := strings.Cut(pair, "="); ok {
+			fields[strings.TrimSpace(key)] = strings.TrimSpace(value)
+		}
+	}
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("session token carries no claims")
+	}
+
+	claims := &TokenClaims{
+		SKU:         fields["sku"],
+		ChatEnabled: fields["chat"] != "0",
+		LimitedUser: strings.Contains(fields["sku"], "limited"),
+		TrackingID:  fields["tid"],
+	}
+	if exp := fields["exp"]; exp != "" {
+		if unix, err := strconv.ParseInt(exp, 10, 64); err == nil {
+			claims.ExpiresAt = time.Unix(unix, 0)
+		} else if t, err := time.Parse(time.RFC3339, exp); err == nil {
+			claims.ExpiresAt = t
+		}
+	}
+	// Only the proxy host is named; the API host sits next to it
+	if host := fields["proxy-ep"]; host != "" {
+		claims.Endpoints.Proxy = "https://" + host
+		if rest, ok := strings.CutPrefix(host, "proxy."); ok {
+			claims.Endpoints.API = "https://api." + rest
+		}
+	}
+	return claims, nil
+}
+
+// TokenClaims returns the claims of the current session token, nil before
+// one was received or when it could not be read
+func (c *Client) TokenClaims() *TokenClaims {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	if c.claims == nil {
+		return nil
+	}
+	claims := *c.claims
+	return &claims
+}
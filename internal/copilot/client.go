@@ -3,8 +3,8 @@ package copilot
 import (
 	"bytes"
 	"context"
-	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
@@ -13,9 +13,14 @@ import (
 	"path/filepath"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/devstroop/reai/internal/cluster"
 	"github.com/devstroop/reai/internal/config"
+	"github.com/devstroop/reai/internal/logging"
+	"github.com/devstroop/reai/internal/metrics"
+	"github.com/devstroop/reai/internal/webhook"
 )
 
 // ModelInfo represents information about an available model
@@ -27,6 +32,32 @@ type ModelInfo struct {
 	Permission []interface{}          `json:"permission"`
 	Root       string                 `json:"root"`
 	Parent     *string                `json:"parent"`
+
+	// Copilot specific metadata
+	Name         string             `json:"name,omitempty"`
+	Vendor       string             `json:"vendor,omitempty"`
+	Version      string             `json:"version,omitempty"`
+	Preview      bool               `json:"preview,omitempty"`
+	Capabilities *ModelCapabilities `json:"capabilities,omitempty"`
+
+	// Endpoints lists the Copilot endpoints serving this model
+	Endpoints []string `json:"endpoints,omitempty"`
+}
+
+// ModelCapabilities describes what a model supports
+type ModelCapabilities struct {
+	Family    string                 `json:"family,omitempty"`
+	Type      string                 `json:"type,omitempty"`
+	Tokenizer string                 `json:"tokenizer,omitempty"`
+	Limits    *ModelLimits           `json:"limits,omitempty"`
+	Supports  map[string]interface{} `json:"supports,omitempty"`
+}
+
+// ModelLimits describes the token limits of a model
+type ModelLimits struct {
+	MaxContextWindowTokens int `json:"max_context_window_tokens,omitempty"`
+	MaxOutputTokens        int `json:"max_output_tokens,omitempty"`
+	MaxPromptTokens        int `json:"max_prompt_tokens,omitempty"`
 }
 
 // DeviceCodeResponse represents the response from the device code endpoint
@@ -42,18 +73,26 @@ type DeviceCodeResponse struct {
 type AccessTokenResponse struct {
 	AccessToken *string `json:"access_token,omitempty"`
 	Error       *string `json:"error,omitempty"`
+	// Interval is the poll interval in seconds GitHub asks for with slow_down
+	Interval int `json:"interval,omitempty"`
 }
 
 // SessionTokenResponse represents the response from the session token endpoint
 type SessionTokenResponse struct {
 	Token     string `json:"token"`
 	ExpiresAt *int64 `json:"expires_at,omitempty"`
-}
-
-// JWTClaims represents JWT token claims
-type JWTClaims struct {
-	Exp int64                  `json:"exp"`
-	//Other map[string]interface{} `json:"-"`
+	RefreshIn int64  `json:"refresh_in,omitempty"`
+	SKU       string `json:"sku,omitempty"`
+
+	// Policy flags of the account's plan and organization
+	ChatEnabled       *bool  `json:"chat_enabled,omitempty"`
+	PublicSuggestions string `json:"public_suggestions,omitempty"`
+
+	// Endpoints are the hosts GitHub assigns to the account's plan
+	Endpoints struct {
+		API   string `json:"api,omitempty"`
+		Proxy string `json:"proxy,omitempty"`
+	} `json:"endpoints"`
 }
 
 // Client represents the GitHub Copilot client
@@ -63,16 +102,120 @@ type Client struct {
 	accessToken  string
 	sessionToken string
 	expiresAt    *time.Time
-	mutex        sync.RWMutex
+	refreshAt    time.Time
+	// tokenData is the response that brought the session token, claims
+	// what the token itself carries (nil when unreadable)
+	tokenData SessionTokenResponse
+	claims    *TokenClaims
+	mutex     sync.RWMutex
+
+	// flow is the device flow in progress or last run, guarded by flowMutex
+	flow       *deviceFlow
+	flowStatus DeviceFlowStatus
+	flowMutex  sync.Mutex
+	// flowChanged is closed when flowStatus changes (nil until watched)
+	flowChanged chan struct{}
+
+	// refreshing is the session token fetch in progress, guarded by c.mutex.
+	// The fetch itself runs without c.mutex held.
+	refreshing *tokenRefresh
+
+	// profile selects the upstream hosts. profileFixed is set when it comes
+	// from UPSTREAM_PROFILE, profileKnown once it was detected or fixed.
+	profile      Profile
+	profileFixed bool
+	profileKnown bool
+
+	// editor is the default editor profile of upstream requests, editors
+	// every profile a request may pick. Both are replaced by UpdateEditors.
+	editor       Editor
+	editors      map[string]Editor
+	editorsMutex sync.RWMutex
+
+	// Cached result of the last upstream ping
+	pingErr     error
+	pingExpires time.Time
+	pingMutex   sync.Mutex
+
+	// Cached model catalog used for limit lookups. catalogRefresh is closed
+	// when the refresh in flight, if any, is done.
+	catalog        map[string]ModelInfo
+	catalogExpires time.Time
+	catalogRefresh chan struct{}
+	catalogMutex   sync.Mutex
+
+	// consumption tracks the account's monthly use for the quota forecast
+	consumption *consumption
+	// backoffUntil holds completions back after a 429 (Unix nanoseconds)
+	backoffUntil atomic.Int64
+
+	// notifier receives the events worth alerting on (none when nil)
+	notifier atomic.Pointer[Notifier]
+
+	// shared is the token store of the replicas (nil unless SHARED_TOKEN_STORE is set)
+	shared cluster.Store
+}
+
+// Notifier receives events worth alerting an operator on, such as a device
+// flow waiting for the user. Events about the same subject may be merged.
+type Notifier interface {
+	Publish(eventType, subject string, data map[string]interface{})
+}
+
+// SetNotifier sends the client's events to n
+func (c *Client) SetNotifier(n Notifier) {
+	c.notifier.Store(&n)
+}
+
+// notify publishes an event to the notifier, if any
+func (c *Client) notify(eventType, subject string, data map[string]interface{}) {
+	if n := c.notifier.Load(); n != nil {
+		(*n).Publish(eventType, subject, data)
+	}
 }
 
 // NewClient creates a new Copilot client
 func NewClient(cfg *config.Config) (*Client, error) {
+	httpClient, err := newHTTPClient(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure upstream HTTP client: %w", err)
+	}
+
+	profile, fixed, known, err := lookupProfile(cfg)
+	if err != nil {
+		return nil, err
+	}
+	editors, editor, err := loadEditors(nil, cfg.EditorProfilesFile, cfg.EditorProfile)
+	if err != nil {
+		return nil, err
+	}
+
 	client := &Client{
-		config: cfg,
-		httpClient: &http.Client{
-			Timeout: 30 * time.Second,
-		},
+		config:       cfg,
+		httpClient:   httpClient,
+		accessToken:  strings.TrimSpace(cfg.GitHubAccessToken),
+		profile:      profile,
+		profileFixed: fixed,
+		profileKnown: known,
+		editor:       editor,
+		editors:      editors,
+	}
+
+	if cfg.SharedTokenStore != "" {
+		if cfg.ReplicaID == "" {
+			return nil, fmt.Errorf("SHARED_TOKEN_STORE needs REPLICA_ID when the hostname is unknown")
+		}
+		if client.shared, err = cluster.Open(cfg.SharedTokenStore); err != nil {
+			return nil, err
+		}
+		slog.Info("Sharing GitHub tokens between replicas", "store", client.shared.String(), "replica", cfg.ReplicaID)
+	}
+
+	// Nothing is written in read-only mode, the session token and the
+	// consumption record are kept in memory
+	if cfg.ReadOnly {
+		client.consumption = loadConsumption("", cfg.UpstreamMonthlyRequests, cfg.QuotaAlertThreshold)
+		return client, nil
 	}
 
 	// Ensure data directory exists
@@ -85,6 +228,7 @@ func NewClient(cfg *config.Config) (*Client, error) {
 			return nil, fmt.Errorf("failed to create data directory: %w", err)
 		}
 	}
+	client.consumption = loadConsumption(cfg.ConsumptionFilePath(), cfg.UpstreamMonthlyRequests, cfg.QuotaAlertThreshold)
 
 	return client, nil
 }
@@ -104,192 +248,305 @@ func (c *Client) ensureDataDir() error {
 	return nil
 }
 
-// Setup performs the GitHub OAuth device flow authentication
-func (c *Client) Setup(ctx context.Context) error {
-	slog.Info("Starting Copilot authentication setup...")
+// saveAccessToken saves the access token to a file, unless in read-only mode
+func (c *Client) saveAccessToken(token string) error {
+	if c.config.ReadOnly {
+		return fmt.Errorf("READ_ONLY is set")
+	}
+	tokenPath := c.config.TokenFilePath()
+	if err := os.WriteFile(tokenPath, []byte(token), 0600); err != nil {
+		return err
+	}
+	return nil
+}
+
+// GetSessionToken fetches a new session token using the access token. The
+// current token keeps being served until the new one has been received, so
+// requests are never blocked by a refresh while the old token is still valid.
+func (c *Client) GetSessionToken(ctx context.Context) error {
+	return c.sharedRefresh(ctx)
+}
+
+// tokenRefresh is a session token fetch shared by every caller that needs it
+type tokenRefresh struct {
+	done chan struct{}
+	err  error
+}
 
-	// Step 1: Get device code
-	deviceReq := map[string]string{
-		"client_id": c.config.ClientID,
-		"scope":     "read:user",
+// sharedRefresh fetches a session token, joining the fetch already in
+// progress if there is one, and reports its result. A caller whose ctx ends
+// stops waiting; the fetch carries on for the others.
+func (c *Client) sharedRefresh(ctx context.Context) error {
+	c.mutex.Lock()
+	call := c.refreshing
+	if call == nil {
+		call = &tokenRefresh{done: make(chan struct{})}
+		c.refreshing = call
+		go func() {
+			call.err = c.refreshSessionToken(context.WithoutCancel(ctx))
+			c.mutex.Lock()
+			c.refreshing = nil
+			c.mutex.Unlock()
+			close(call.done)
+		}()
 	}
+	c.mutex.Unlock()
 
-	deviceResp, err := c.makeRequest(ctx, "POST", config.DeviceCodeURL, deviceReq, nil)
-	if err != nil {
-		return fmt.Errorf("device code request failed: %w", err)
+	select {
+	case <-call.done:
+		return call.err
+	case <-ctx.Done():
+		return ctx.Err()
 	}
+}
 
-	var deviceData DeviceCodeResponse
-	if err := json.Unmarshal(deviceResp, &deviceData); err != nil {
-		return fmt.Errorf("failed to parse device code response: %w", err)
+// currentSessionToken returns a usable session token, fetching one only when
+// there is none or it has expired. Concurrent callers share a single fetch.
+func (c *Client) currentSessionToken(ctx context.Context) (string, error) {
+	if token, ok := c.validSessionToken(); ok {
+		return token, nil
 	}
+	if err := c.sharedRefresh(ctx); err != nil {
+		return "", err
+	}
+	token, ok := c.validSessionToken()
+	if !ok {
+		return "", fmt.Errorf("no session token available")
+	}
+	return token, nil
+}
 
-	fmt.Printf("Please visit %s and enter code %s to authenticate.\n", 
-		deviceData.VerificationURI, deviceData.UserCode)
+// sessionTokenRenewals counts refreshes forced by Copilot rejecting the session token
+var sessionTokenRenewals = metrics.NewCounter("reai_session_token_renewals_total", "Session token refreshes forced by Copilot rejecting the token")
 
-	// Step 2: Poll for access token
-	ticker := time.NewTicker(time.Duration(deviceData.Interval) * time.Second)
-	defer ticker.Stop()
+// renewSessionToken replaces a session token that Copilot rejected. Requests
+// that fail together share one refresh, and requests finding the rejected
+// token already replaced reuse the new one.
+func (c *Client) renewSessionToken(ctx context.Context, rejected string) (string, error) {
+	if token, ok := c.validSessionToken(); ok && token != rejected {
+		return token, nil
+	}
 
-	for {
-		select {
-		case <-ctx.Done():
-			return ctx.Err()
-		case <-ticker.C:
-			tokenReq := map[string]string{
-				"client_id":    c.config.ClientID,
-				"device_code":  deviceData.DeviceCode,
-				"grant_type":   "urn:ietf:params:oauth:grant-type:device_code",
-			}
+	c.mutex.Lock()
+	if c.refreshing == nil {
+		sessionTokenRenewals.Inc()
+	}
+	c.mutex.Unlock()
 
-			tokenResp, err := c.makeRequest(ctx, "POST", config.AccessTokenURL, tokenReq, nil)
-			if err != nil {
-				slog.Warn("Token request failed", "error", err)
-				continue
-			}
+	if err := c.sharedRefresh(ctx); err != nil {
+		return "", err
+	}
+	token, ok := c.validSessionToken()
+	if !ok {
+		return "", fmt.Errorf("no session token available")
+	}
+	return token, nil
+}
 
-			var tokenData AccessTokenResponse
-			if err := json.Unmarshal(tokenResp, &tokenData); err != nil {
-				slog.Warn("Failed to parse token response", "error", err)
-				continue
-			}
+// ErrUnauthenticated is returned by token refreshes that may not start a
+// device flow when there is no usable GitHub access token
+var ErrUnauthenticated = errors.New("unauthenticated")
 
-			if tokenData.AccessToken != nil {
-				c.accessToken = *tokenData.AccessToken
-				if err := c.saveAccessToken(*tokenData.AccessToken); err != nil {
-					slog.Warn("Failed to save token to file, keeping in memory only", "error", err)
-				}
-				fmt.Println("Authentication success!")
-				return nil
-			}
+type nonInteractiveKey struct{}
 
-			if tokenData.Error != nil {
-				if *tokenData.Error == "authorization_pending" {
-					continue
-				}
-				return fmt.Errorf("authentication error: %s", *tokenData.Error)
+// nonInteractive returns a context whose token refreshes fail with
+// ErrUnauthenticated where they would start a device flow
+func nonInteractive(ctx context.Context) context.Context {
+	return context.WithValue(ctx, nonInteractiveKey{}, true)
+}
+
+// interactive reports whether a refresh under ctx may start a device flow
+func interactive(ctx context.Context) bool {
+	return ctx.Value(nonInteractiveKey{}) == nil
+}
+
+// probeSessionToken returns a usable session token for a probe. It waits for
+// a refresh in progress or fetches the token itself, both bounded by ctx,
+// and never starts a device flow.
+func (c *Client) probeSessionToken(ctx context.Context) (string, error) {
+	if token, ok := c.validSessionToken(); ok {
+		return token, nil
+	}
+	c.mutex.Lock()
+	call := c.refreshing
+	c.mutex.Unlock()
+	if call != nil {
+		select {
+		case <-call.done:
+			if call.err != nil {
+				return "", call.err
 			}
+		case <-ctx.Done():
+			return "", ctx.Err()
 		}
+	} else if err := c.refreshSessionToken(nonInteractive(ctx)); err != nil {
+		return "", err
 	}
+	token, ok := c.validSessionToken()
+	if !ok {
+		return "", fmt.Errorf("no session token available")
+	}
+	return token, nil
 }
 
-// saveAccessToken saves the access token to a file
-func (c *Client) saveAccessToken(token string) error {
-	tokenPath := c.config.TokenFilePath()
-	if err := os.WriteFile(tokenPath, []byte(token), 0600); err != nil {
-		return err
+// refreshSessionToken fetches a session token and swaps it in. Only one runs
+// at a time (see sharedRefresh), probes aside; c.mutex is only taken for the
+// swap. When GitHub rejects the access token, a device flow is started and
+// the fetch waits for it, unless ctx is non-interactive.
+func (c *Client) refreshSessionToken(ctx context.Context) error {
+	if c.shared != nil {
+		return c.refreshShared(ctx)
 	}
-	return nil
+	return c.fetchSessionToken(ctx, false)
 }
 
-// GetSessionToken obtains a session token using the access token
-func (c *Client) GetSessionToken(ctx context.Context) error {
-	c.mutex.Lock()
-	defer c.mutex.Unlock()
-
+// fetchSessionToken is refreshSessionToken; reauthenticated is set once a
+// device flow replaced a rejected access token, so it isn't started again
+func (c *Client) fetchSessionToken(ctx context.Context, reauthenticated bool) error {
 	// Load access token from file if not in memory
-	if c.accessToken == "" {
-		tokenPath := c.config.TokenFilePath()
+	c.mutex.RLock()
+	accessToken := c.accessToken
+	c.mutex.RUnlock()
+	if accessToken == "" {
+		tokenPath := c.config.AccessTokenPath()
 		if data, err := os.ReadFile(tokenPath); err != nil {
+			if !interactive(ctx) {
+				return fmt.Errorf("%w: no GitHub access token", ErrUnauthenticated)
+			}
 			slog.Warn("Failed to load access token from file", "error", err, "path", tokenPath)
-			return c.Setup(ctx)
+			if err := c.Setup(ctx); err != nil {
+				return err
+			}
 		} else {
+			c.mutex.Lock()
 			c.accessToken = strings.TrimSpace(string(data))
+			c.mutex.Unlock()
 			slog.Debug("Loaded access token from file")
 		}
+		c.mutex.RLock()
+		accessToken = c.accessToken
+		c.mutex.RUnlock()
 	}
 
-	// Get session token with retry logic
-	for retries := 3; retries > 0; retries-- {
-		headers := map[string]string{
-			"Authorization": fmt.Sprintf("token %s", c.accessToken),
-		}
-
-		resp, err := c.makeRequest(ctx, "GET", config.SessionTokenURL, nil, headers)
-		if err != nil {
-			return fmt.Errorf("session token request failed: %w", err)
-		}
-
-		var tokenData SessionTokenResponse
-		if err := json.Unmarshal(resp, &tokenData); err != nil {
-			return fmt.Errorf("failed to parse session token response: %w", err)
-		}
+	headers := map[string]string{
+		"Authorization": fmt.Sprintf("token %s", accessToken),
+	}
 
-		// Parse JWT to extract expiration time
-		if exp, err := c.extractExpFromJWT(tokenData.Token); err == nil && exp != nil {
-			c.expiresAt = exp
+	resp, err := c.makeRequest(ctx, "GET", c.config.SessionTokenURL(), nil, headers)
+	if err != nil {
+		var httpErr *HTTPError
+		if !reauthenticated && errors.As(err, &httpErr) && httpErr.StatusCode == http.StatusUnauthorized {
+			if !interactive(ctx) {
+				return fmt.Errorf("%w: GitHub rejected the access token", ErrUnauthenticated)
+			}
+			// The access token was revoked; only the user can issue a new one
+			slog.Error("GitHub rejected the access token - starting device flow authentication", "error", err)
+			c.mutex.Lock()
+			if c.accessToken == accessToken {
+				c.accessToken = ""
+			}
+			c.mutex.Unlock()
+			if err := c.Setup(ctx); err != nil {
+				return err
+			}
+			return c.fetchSessionToken(ctx, true)
 		}
-
-		c.sessionToken = tokenData.Token
-		slog.Debug("Session token acquired", "expires_at", c.expiresAt)
-		return nil
+		return fmt.Errorf("session token request failed: %w", err)
 	}
 
-	return fmt.Errorf("failed to get session token after retries")
-}
-
-// extractExpFromJWT extracts expiration time from JWT token
-func (c *Client) extractExpFromJWT(token string) (*time.Time, error) {
-	parts := strings.Split(token, ".")
-	if len(parts) != 3 {
-		// Not a JWT token, try legacy parsing
-		return c.extractExpValueLegacy(token), nil
+	var tokenData SessionTokenResponse
+	if err := json.Unmarshal(resp, &tokenData); err != nil {
+		return fmt.Errorf("failed to parse session token response: %w", err)
 	}
-
-	// Decode the payload (second part)
-	payload := parts[1]
-	
-	// Add padding if needed for base64 decoding
-	padding := 4 - (len(payload) % 4)
-	if padding != 4 {
-		payload += strings.Repeat("=", padding)
+	if tokenData.Token == "" {
+		return fmt.Errorf("session token response did not contain a token")
 	}
 
-	decoded, err := base64.URLEncoding.DecodeString(payload)
+	// The token's own expiry wins over the one reported with it
+	var expiresAt *time.Time
+	claims, err := parseTokenClaims(tokenData.Token)
 	if err != nil {
-		slog.Warn("Failed to decode JWT payload, falling back to legacy parsing", "error", err)
-		return c.extractExpValueLegacy(token), nil
+		slog.Debug("Failed to read session token claims", "error", err)
+	} else if !claims.ExpiresAt.IsZero() {
+		expiresAt = &claims.ExpiresAt
 	}
-
-	var claims JWTClaims
-	if err := json.Unmarshal(decoded, &claims); err != nil {
-		slog.Warn("Failed to parse JWT claims, falling back to legacy parsing", "error", err)
-		return c.extractExpValueLegacy(token), nil
+	if expiresAt == nil && tokenData.ExpiresAt != nil {
+		exp := time.Unix(*tokenData.ExpiresAt, 0)
+		expiresAt = &exp
+	}
+	if expiresAt == nil {
+		exp := time.Now().Add(config.DefaultTokenLifetimeSeconds * time.Second)
+		expiresAt = &exp
 	}
 
-	expTime := time.Unix(claims.Exp, 0)
-	return &expTime, nil
+	c.setSessionToken(tokenData, claims, *expiresAt, refreshTime(time.Now(), *expiresAt, tokenData.RefreshIn))
+	slog.Debug("Session token acquired", "expires_at", expiresAt)
+	return nil
 }
 
-// extractExpValueLegacy extracts expiration from legacy token format (fallback)
-func (c *Client) extractExpValueLegacy(token string) *time.Time {
-	for _, pair := range strings.Split(token, ";") {
-		if kv := strings.SplitN(pair, "=", 2); len(kv) == 2 {
-			if strings.TrimSpace(kv[0]) == "exp" {
-				if exp, err := time.Parse(time.RFC3339, strings.TrimSpace(kv[1])); err == nil {
-					return &exp
-				}
-			}
+// setSessionToken makes tokenData the session token in use
+func (c *Client) setSessionToken(tokenData SessionTokenResponse, claims *TokenClaims, expiresAt, refreshAt time.Time) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.sessionToken = tokenData.Token
+	c.tokenData = tokenData
+	c.claims = claims
+	c.expiresAt = &expiresAt
+	c.refreshAt = refreshAt
+	c.updateProfile(tokenData, claims)
+}
+
+// refreshTime returns when a token received at now should be replaced: when
+// GitHub's refresh_in says so, and at the latest TokenRefreshAheadSeconds
+// before it expires
+func refreshTime(now, expiresAt time.Time, refreshIn int64) time.Time {
+	at := expiresAt.Add(-config.TokenRefreshAheadSeconds * time.Second)
+	if refreshIn > 0 {
+		if suggested := now.Add(time.Duration(refreshIn) * time.Second); suggested.Before(at) {
+			at = suggested
 		}
 	}
-	return nil
+	if at.Before(now) {
+		// Short lived token: refresh half way through its lifetime
+		at = now.Add(expiresAt.Sub(now) / 2)
+	}
+	return at
 }
 
-// isTokenValid checks if the current session token is valid
-func (c *Client) isTokenValid() bool {
+// validSessionToken returns the session token unless it is missing or about to expire
+func (c *Client) validSessionToken() (string, bool) {
 	c.mutex.RLock()
 	defer c.mutex.RUnlock()
 
 	if c.sessionToken == "" || c.expiresAt == nil {
-		return false
+		return "", false
 	}
 
 	buffer := time.Duration(config.TokenRefreshBufferSeconds) * time.Second
-	return time.Now().Add(buffer).Before(*c.expiresAt)
+	if !time.Now().Add(buffer).Before(*c.expiresAt) {
+		return "", false
+	}
+	return c.sessionToken, true
 }
 
 // makeRequest makes an HTTP request with proper headers
 func (c *Client) makeRequest(ctx context.Context, method, url string, body interface{}, headers map[string]string) ([]byte, error) {
+	resp, err := c.openRequest(ctx, method, url, body, headers)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err == nil && logging.Debugging(ctx) {
+		slog.DebugContext(ctx, "Upstream response payload", "url", url, "body", string(data))
+	}
+	return data, err
+}
+
+// openRequest makes an HTTP request with proper headers and returns the response
+// with its body unread. Error responses are consumed and returned as errors.
+func (c *Client) openRequest(ctx context.Context, method, url string, body interface{}, headers map[string]string) (*http.Response, error) {
 	var reqBody io.Reader
 	
 	if body != nil {
@@ -297,6 +554,9 @@ func (c *Client) makeRequest(ctx context.Context, method, url string, body inter
 		if err != nil {
 			return nil, err
 		}
+		if logging.Debugging(ctx) {
+			slog.DebugContext(ctx, "Upstream request payload", "method", method, "url", url, "body", string(jsonData))
+		}
 		reqBody = bytes.NewBuffer(jsonData)
 	}
 
@@ -306,9 +566,13 @@ func (c *Client) makeRequest(ctx context.Context, method, url string, body inter
 	}
 
 	// Set default headers
-	req.Header.Set("User-Agent", config.UserAgent)
-	req.Header.Set("Editor-Version", config.EditorVersion)
-	req.Header.Set("Editor-Plugin-Version", config.EditorPluginVersion)
+	editor := c.editorFor(ctx)
+	req.Header.Set("User-Agent", editor.UserAgent)
+	req.Header.Set("Editor-Version", editor.EditorVersion)
+	req.Header.Set("Editor-Plugin-Version", editor.EditorPluginVersion)
+	if editor.IntegrationID != "" {
+		req.Header.Set("Copilot-Integration-Id", editor.IntegrationID)
+	}
 	req.Header.Set("Accept", "application/json")
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("X-GitHub-Api-Version", "2025-04-01")
@@ -322,36 +586,83 @@ func (c *Client) makeRequest(ctx context.Context, method, url string, body inter
 	if err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
-
-	respBody, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
-	}
 
 	if resp.StatusCode >= 400 {
-		return nil, fmt.Errorf("HTTP %d: %s", resp.StatusCode, string(respBody))
+		defer resp.Body.Close()
+		respBody, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return nil, err
+		}
+		return nil, &HTTPError{StatusCode: resp.StatusCode, Body: string(respBody), Header: resp.Header}
 	}
 
-	return respBody, nil
+	return resp, nil
 }
 
-// StartTokenRefresh starts a background goroutine to refresh tokens
-func (c *Client) StartTokenRefresh(ctx context.Context) {
-	ticker := time.NewTicker(5 * time.Minute) // Check every 5 minutes
-	defer ticker.Stop()
+// HTTPError is an error response from GitHub or Copilot
+type HTTPError struct {
+	StatusCode int
+	Body       string
+	Header     http.Header
+}
 
+func (e *HTTPError) Error() string {
+	return fmt.Sprintf("HTTP %d: %s", e.StatusCode, e.Body)
+}
+
+// isTokenRejected reports whether err is Copilot refusing the session token,
+// e.g. because it was revoked or the clocks disagree about its expiry
+func isTokenRejected(err error) bool {
+	var httpErr *HTTPError
+	if !errors.As(err, &httpErr) {
+		return false
+	}
+	return httpErr.StatusCode == http.StatusUnauthorized || strings.Contains(httpErr.Body, "token_expired")
+}
+
+// tokenRefreshRetry is how long the refresh loop waits after a failure or while there is no token
+const tokenRefreshRetry = 30 * time.Second
+
+// StartTokenRefresh refreshes the session token in the background ahead of
+// its expiry. Requests keep using the old token until the new one arrives; a
+// failed refresh is retried while the old token is still valid.
+func (c *Client) StartTokenRefresh(ctx context.Context) {
 	for {
+		c.mutex.RLock()
+		refreshAt := c.refreshAt
+		c.mutex.RUnlock()
+
+		wait := time.Until(refreshAt)
+		if refreshAt.IsZero() {
+			// No token yet; requests will fetch one on demand
+			wait = tokenRefreshRetry
+		}
+
+		timer := time.NewTimer(max(wait, 0))
 		select {
 		case <-ctx.Done():
+			timer.Stop()
 			return
-		case <-ticker.C:
-			if !c.isTokenValid() {
-				slog.Debug("Token refresh needed")
-				if err := c.GetSessionToken(ctx); err != nil {
-					slog.Error("Failed to refresh token", "error", err)
-				}
-			}
+		case <-timer.C:
+		}
+		if refreshAt.IsZero() {
+			continue
+		}
+
+		slog.Debug("Refreshing session token ahead of expiry", "expires_at", c.expiry())
+		if err := c.GetSessionToken(ctx); err != nil {
+			slog.Error("Failed to refresh token - still serving the current one", "error", err, "expires_at", c.expiry())
+			c.notify(webhook.EventTokenRefreshFailed, "", map[string]interface{}{"error": err.Error(), "expires_at": c.expiry()})
+			c.mutex.Lock()
+			c.refreshAt = time.Now().Add(tokenRefreshRetry)
+			c.mutex.Unlock()
 		}
 	}
 }
+
+// expiry returns when the current session token expires
+func (c *Client) expiry() *time.Time {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.expiresAt
+}
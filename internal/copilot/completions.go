@@ -1,13 +1,18 @@
 package copilot
 
 import (
+	"bufio"
 	"context"
 	"encoding/json"
+	stderrors "errors"
 	"fmt"
+	"io"
 	"log/slog"
+	"net/http"
 	"strings"
+	"time"
 
-	"github.com/devstroop/reai/internal/config"
+	"github.com/devstroop/reai/internal/logging"
 	"github.com/devstroop/reai/pkg/errors"
 )
 
@@ -16,43 +21,361 @@ type CompletionRequest struct {
 	Prompt      string `json:"prompt"`
 	Language    string `json:"language,omitempty"`
 	MaxTokens   int    `json:"max_tokens,omitempty"`
-	Temperature float64 `json:"temperature,omitempty"`
+	Temperature *float64 `json:"temperature,omitempty"`
 	Stream      bool   `json:"stream,omitempty"`
+	// Logprobs requests log probabilities for the most likely N tokens (nil = none)
+	Logprobs    *int   `json:"logprobs,omitempty"`
+
+	// Sampling parameters, nil when not set by the client
+	TopP             *float64 `json:"top_p,omitempty"`
+	PresencePenalty  *float64 `json:"presence_penalty,omitempty"`
+	FrequencyPenalty *float64 `json:"frequency_penalty,omitempty"`
+	Seed             *int64   `json:"seed,omitempty"`
+	User             string   `json:"user,omitempty"`
+
+	// ReasoningEffort is passed to reasoning models ("low", "medium", ...)
+	ReasoningEffort string `json:"-"`
+	// Dropped lists the parameters removed because the model rejects them
+	Dropped []string `json:"-"`
+	// IncludeReasoning asks providers that only think on request to do so
+	IncludeReasoning bool `json:"-"`
+
+	// RequestedMaxTokens is the max_tokens the client asked for when MaxTokens was clamped
+	RequestedMaxTokens int `json:"-"`
+
+	// Model and Messages make a chat request, which Copilot sends to its chat
+	// endpoint; without Messages, Prompt is completed by the completions
+	// endpoint. Prompt is kept for chat requests too, as a flat rendering of
+	// the messages for providers that only take text.
+	Model    string    `json:"-"`
+	Messages []Message `json:"-"`
+
+	// Tools are the functions the model of a chat request may call.
+	// ToolChoice is the client's tool_choice, passed through as it is.
+	Tools             []Tool          `json:"-"`
+	ToolChoice        json.RawMessage `json:"-"`
+	ParallelToolCalls *bool           `json:"-"`
+
+	// Context describes the file being edited (nil when unknown)
+	Context *PromptContext `json:"-"`
+	// Suffix is the text after the cursor, for fill-in-the-middle completions
+	Suffix string `json:"-"`
+	// Stop ends the completion at any of these strings (a newline when empty)
+	Stop []string `json:"-"`
+
+	// Backend is set by the provider router to the provider serving the
+	// request, before any chunk is delivered
+	Backend string `json:"-"`
+
+	// Experiment and Variant name the A/B experiment variant the request was
+	// assigned to, if any
+	Experiment string `json:"-"`
+	Variant    string `json:"-"`
 }
 
-// GetCompletion gets a code completion from GitHub Copilot
-func (c *Client) GetCompletion(ctx context.Context, req *CompletionRequest) (string, error) {
-	// Validate prompt length
-	if len(req.Prompt) > c.config.MaxPromptLength {
-		return "", errors.NewValidationError(fmt.Sprintf("Prompt too long: %d characters (max: %d)", 
-			len(req.Prompt), c.config.MaxPromptLength))
+// DefaultRepository is the repository reported to Copilot when the client
+// names none
+const DefaultRepository = "github/copilot.vim"
+
+// PromptContext tells Copilot where the completion is requested, which
+// improves its suggestions
+type PromptContext struct {
+	// FilePath is the path of the file being edited
+	FilePath string `json:"file_path,omitempty"`
+	// Repository is the "owner/name" of the repository holding the file
+	Repository string `json:"repository,omitempty"`
+	// Snippets are excerpts of neighboring files
+	Snippets []ContextSnippet `json:"context_snippets,omitempty"`
+}
+
+// ContextSnippet is an excerpt of a file related to the one being edited
+type ContextSnippet struct {
+	FilePath string `json:"file_path,omitempty"`
+	Content  string `json:"content"`
+}
+
+// length is the number of characters the context adds to the prompt
+func (p *PromptContext) length() int {
+	if p == nil {
+		return 0
 	}
+	n := 0
+	for _, snippet := range p.Snippets {
+		n += len(snippet.Content)
+	}
+	return n
+}
 
-	// Ensure we have a valid token
-	if !c.isTokenValid() {
-		if err := c.GetSessionToken(ctx); err != nil {
-			return "", errors.NewAuthenticationError(err.Error())
+// Message is a chat message of a completion request. Assistant messages may
+// carry the tool calls the model made, and tool messages the result of one.
+type Message struct {
+	Role       string     `json:"role"`
+	Content    string     `json:"content"`
+	Name       string     `json:"name,omitempty"`
+	ToolCalls  []ToolCall `json:"tool_calls,omitempty"`
+	ToolCallID string     `json:"tool_call_id,omitempty"`
+}
+
+// ToolCall is a function call made by the model, in the OpenAI format
+type ToolCall struct {
+	ID       string       `json:"id"`
+	Type     string       `json:"type"`
+	Function FunctionCall `json:"function"`
+}
+
+// FunctionCall names the function of a tool call. Arguments is the JSON
+// object the model generated, as a string.
+type FunctionCall struct {
+	Name      string `json:"name"`
+	Arguments string `json:"arguments"`
+}
+
+// Tool is a function the model may call, in the OpenAI format
+type Tool struct {
+	Type     string             `json:"type"`
+	Function FunctionDefinition `json:"function"`
+}
+
+// FunctionDefinition describes a function to the model. Parameters is the
+// JSON schema of its arguments.
+type FunctionDefinition struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description,omitempty"`
+	Parameters  json.RawMessage `json:"parameters,omitempty"`
+	Strict      *bool           `json:"strict,omitempty"`
+}
+
+// ToolCallDelta is a streamed piece of a tool call. The first piece of a
+// call carries its ID and function name; the arguments arrive in pieces
+// appended in order. Index tells the calls of one reply apart.
+type ToolCallDelta struct {
+	Index     int    `json:"index"`
+	ID        string `json:"id,omitempty"`
+	Name      string `json:"name,omitempty"`
+	Arguments string `json:"arguments,omitempty"`
+}
+
+// AppendToolCalls adds streamed tool call pieces to the calls assembled so far
+func AppendToolCalls(calls []ToolCall, deltas []ToolCallDelta) []ToolCall {
+	for _, delta := range deltas {
+		for len(calls) <= delta.Index {
+			calls = append(calls, ToolCall{Type: "function"})
+		}
+		call := &calls[delta.Index]
+		if delta.ID != "" {
+			call.ID = delta.ID
 		}
+		if delta.Name != "" {
+			call.Function.Name = delta.Name
+		}
+		call.Function.Arguments += delta.Arguments
+	}
+	return calls
+}
+
+// UnsupportedParameters lists the parameters set on the request that Copilot
+// does not understand and that are dropped. Only the chat endpoint takes
+// reasoning_effort.
+func (r *CompletionRequest) UnsupportedParameters() []string {
+	var ignored []string
+	if r.Seed != nil {
+		ignored = append(ignored, "seed")
+	}
+	if r.User != "" {
+		ignored = append(ignored, "user")
 	}
+	if r.ReasoningEffort != "" && len(r.Messages) == 0 {
+		ignored = append(ignored, "reasoning_effort")
+	}
+	return ignored
+}
+
+// Logprobs holds token log probabilities in the OpenAI completions format
+type Logprobs struct {
+	Tokens        []string             `json:"tokens"`
+	TokenLogprobs []float64            `json:"token_logprobs"`
+	TopLogprobs   []map[string]float64 `json:"top_logprobs"`
+	TextOffset    []int                `json:"text_offset"`
+}
 
-	sessionToken := c.sessionToken
-	if sessionToken == "" {
-		return "", errors.NewAuthenticationError("No session token available")
+// Append adds the tokens of another logprobs chunk
+func (l *Logprobs) Append(other *Logprobs) {
+	if other == nil {
+		return
+	}
+	l.Tokens = append(l.Tokens, other.Tokens...)
+	l.TokenLogprobs = append(l.TokenLogprobs, other.TokenLogprobs...)
+	l.TopLogprobs = append(l.TopLogprobs, other.TopLogprobs...)
+	l.TextOffset = append(l.TextOffset, other.TextOffset...)
+}
+
+// Finish reasons reported by the completions endpoint
+const (
+	FinishReasonStop          = "stop"
+	FinishReasonLength        = "length"
+	FinishReasonContentFilter = "content_filter"
+	FinishReasonToolCalls     = "tool_calls"
+)
+
+// CompletionChunk is one streamed piece of a completion. FinishReason is only
+// set on the chunk that ends the choice.
+type CompletionChunk struct {
+	Text         string
+	Logprobs     *Logprobs
+	FinishReason string
+	// Reasoning is thinking text of models that stream it apart from the answer
+	Reasoning string
+	// ToolCalls are pieces of the tool calls of chat replies
+	ToolCalls []ToolCallDelta
+}
+
+// CompletionResult is a fully assembled completion
+type CompletionResult struct {
+	Text         string
+	Logprobs     *Logprobs
+	FinishReason string
+	Reasoning    string
+	ToolCalls    []ToolCall
+}
+
+// Name identifies Copilot among the backend providers
+func (c *Client) Name() string {
+	return "copilot"
+}
+
+// UnsupportedParameters lists the parameters of req that Copilot drops
+func (c *Client) UnsupportedParameters(req *CompletionRequest) []string {
+	return req.UnsupportedParameters()
+}
+
+// GetCompletion gets a code completion from GitHub Copilot
+func (c *Client) GetCompletion(ctx context.Context, req *CompletionRequest) (*CompletionResult, error) {
+	return Collect(func(onChunk func(CompletionChunk) error) error {
+		return c.StreamCompletion(ctx, req, onChunk)
+	})
+}
+
+// Collect assembles the chunks of a streamed completion into one result
+func Collect(stream func(onChunk func(CompletionChunk) error) error) (*CompletionResult, error) {
+	result := &CompletionResult{FinishReason: FinishReasonStop}
+	var text, reasoning strings.Builder
+
+	err := stream(func(chunk CompletionChunk) error {
+		text.WriteString(chunk.Text)
+		reasoning.WriteString(chunk.Reasoning)
+		result.ToolCalls = AppendToolCalls(result.ToolCalls, chunk.ToolCalls)
+		if chunk.FinishReason != "" {
+			result.FinishReason = chunk.FinishReason
+		}
+		if chunk.Logprobs != nil {
+			if result.Logprobs == nil {
+				result.Logprobs = &Logprobs{}
+			}
+			result.Logprobs.Append(chunk.Logprobs)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	result.Text = text.String()
+	result.Reasoning = reasoning.String()
+	return result, nil
+}
+
+// StreamCompletion gets a completion from GitHub Copilot, invoking onChunk
+// for every streamed piece as it arrives. Returning an error from onChunk
+// aborts the stream. Chat requests go to the chat endpoint, others to the
+// code completions endpoint.
+func (c *Client) StreamCompletion(ctx context.Context, req *CompletionRequest, onChunk func(CompletionChunk) error) error {
+	// Validate prompt length, context snippets included
+	if length := len(req.Prompt) + req.Context.length(); length > c.config.MaxPromptLength {
+		return errors.NewValidationError(fmt.Sprintf("Prompt too long: %d characters (max: %d)",
+			length, c.config.MaxPromptLength))
+	}
+
+	if err := c.checkBackoff(time.Now()); err != nil {
+		return err
+	}
+
+	// Ensure we have a valid token
+	sessionToken, err := c.currentSessionToken(ctx)
+	if err != nil {
+		return errors.NewAuthenticationError(err.Error())
 	}
 
 	headers := map[string]string{
 		"Authorization": fmt.Sprintf("Bearer %s", sessionToken),
 	}
 
-	// Set defaults
+	url, copilotReq, parse := c.Profile().CompletionsURL, completionBody(req), c.parseStreamingResponse
+	if len(req.Messages) > 0 {
+		url, copilotReq, parse = c.Profile().ChatURL, chatBody(req), c.parseChatStream
+		if c.editorFor(ctx).IntegrationID == "" {
+			headers["Copilot-Integration-Id"] = chatIntegrationID
+		}
+	}
+
+	resp, err := c.openRequest(ctx, "POST", url, copilotReq, headers)
+	if err != nil && isTokenRejected(err) {
+		// Nothing has been streamed yet, so the request can be retried once with a fresh token
+		slog.Warn("🔑 Copilot rejected the session token - refreshing and retrying", "error", err)
+		if sessionToken, err = c.renewSessionToken(ctx, sessionToken); err != nil {
+			return errors.NewAuthenticationError(err.Error())
+		}
+		headers["Authorization"] = fmt.Sprintf("Bearer %s", sessionToken)
+		resp, err = c.openRequest(withAttempt(ctx, 2), "POST", url, copilotReq, headers)
+	}
+	var httpErr *HTTPError
+	if stderrors.As(err, &httpErr) && httpErr.StatusCode == http.StatusTooManyRequests {
+		// A short wait is taken here; otherwise the caller is told when to retry
+		wait := c.rateLimited(httpErr.Header, time.Now())
+		if !waitRateLimit(ctx, wait) {
+			return upstreamRateLimitError(httpErr.Header, wait)
+		}
+		slog.Warn("⏳ Copilot rate limited the request - retrying", "retry_after", wait)
+		resp, err = c.openRequest(withAttempt(ctx, 2), "POST", url, copilotReq, headers)
+		if stderrors.As(err, &httpErr) && httpErr.StatusCode == http.StatusTooManyRequests {
+			return upstreamRateLimitError(httpErr.Header, c.rateLimited(httpErr.Header, time.Now()))
+		}
+	}
+	if err != nil {
+		if ctx.Err() == context.Canceled {
+			return ctx.Err()
+		}
+		if ctx.Err() == context.DeadlineExceeded {
+			return errors.NewDeadlineExceededError("upstream did not respond in time")
+		}
+		return errors.NewCopilotAPIError(fmt.Sprintf("Completion request failed: %s", err.Error()))
+	}
+	defer resp.Body.Close()
+	c.consumption.observe(resp.Header, time.Now())
+	observeRateLimit(resp.Header)
+
+	if err := parse(ctx, resp.Body, onChunk); err != nil {
+		// The caller went away; report that rather than a failed upstream read
+		if ctx.Err() == context.Canceled {
+			return ctx.Err()
+		}
+		if ctx.Err() == context.DeadlineExceeded {
+			return errors.NewDeadlineExceededError("upstream did not finish in time")
+		}
+		return err
+	}
+	return nil
+}
+
+// completionBody builds the payload of the code completions endpoint
+func completionBody(req *CompletionRequest) map[string]interface{} {
 	maxTokens := req.MaxTokens
 	if maxTokens == 0 {
 		maxTokens = 1000
 	}
-	
-	temperature := req.Temperature
-	if temperature == 0 {
-		temperature = 0.0
+
+	temperature := 0.0
+	if req.Temperature != nil {
+		temperature = *req.Temperature
 	}
 
 	language := req.Language
@@ -60,52 +383,107 @@ func (c *Client) GetCompletion(ctx context.Context, req *CompletionRequest) (str
 		language = "text"
 	}
 
+	topP := 1.0
+	if req.TopP != nil {
+		topP = *req.TopP
+	}
+
+	stop := req.Stop
+	if len(stop) == 0 {
+		stop = []string{"\n"}
+	}
+
 	copilotReq := map[string]interface{}{
 		"prompt":      req.Prompt,
-		"suffix":      "",
+		"suffix":      req.Suffix,
 		"max_tokens":  maxTokens,
 		"temperature": temperature,
-		"top_p":       1,
+		"top_p":       topP,
 		"n":          1,
-		"stop":       []string{"\n"},
-		"nwo":        "github/copilot.vim",
+		"stop":       stop,
+		"nwo":        DefaultRepository,
 		"stream":     true,
-		"extra": map[string]interface{}{
-			"language": language,
-		},
 	}
-
-	resp, err := c.makeRequest(ctx, "POST", config.CompletionsURL, copilotReq, headers)
-	if err != nil {
-		return "", errors.NewCopilotAPIError(fmt.Sprintf("Completion request failed: %s", err.Error()))
+	extra := map[string]interface{}{
+		"language": language,
 	}
+	if pc := req.Context; pc != nil {
+		if pc.Repository != "" {
+			copilotReq["nwo"] = pc.Repository
+		}
+		if pc.FilePath != "" {
+			extra["file_path"] = pc.FilePath
+		}
+		if len(pc.Snippets) > 0 {
+			extra["context_snippets"] = pc.Snippets
+		}
+	}
+	copilotReq["extra"] = extra
+	if req.Model != "" {
+		// The engine in the URL picks the model; the body names it too, as the
+		// chat payload does
+		copilotReq["model"] = req.Model
+	}
+	if req.Logprobs != nil {
+		copilotReq["logprobs"] = *req.Logprobs
+	}
+	if req.PresencePenalty != nil {
+		copilotReq["presence_penalty"] = *req.PresencePenalty
+	}
+	if req.FrequencyPenalty != nil {
+		copilotReq["frequency_penalty"] = *req.FrequencyPenalty
+	}
+	return copilotReq
+}
 
-	return c.parseStreamingResponse(string(resp))
+// streamChunk is the JSON payload of a Copilot streaming event
+type streamChunk struct {
+	Choices []struct {
+		Text         string    `json:"text"`
+		Logprobs     *Logprobs `json:"logprobs"`
+		FinishReason *string   `json:"finish_reason"`
+	} `json:"choices"`
 }
 
-// parseStreamingResponse parses the streaming response from Copilot
-func (c *Client) parseStreamingResponse(responseText string) (string, error) {
-	var result strings.Builder
+// parseStreamingResponse parses the streaming response from Copilot. It
+// stops as soon as ctx is done, without handing buffered events to onChunk.
+func (c *Client) parseStreamingResponse(ctx context.Context, body io.Reader, onChunk func(CompletionChunk) error) error {
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
 
-	for _, line := range strings.Split(responseText, "\n") {
+	debug := logging.Debugging(ctx)
+	for scanner.Scan() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		line := scanner.Text()
+		if debug && line != "" {
+			slog.DebugContext(ctx, "Upstream stream chunk", "body", line)
+		}
 		if strings.HasPrefix(line, "data: {") {
 			jsonData := line[6:] // Remove "data: " prefix
-			
-			var data map[string]interface{}
+
+			var data streamChunk
 			if err := json.Unmarshal([]byte(jsonData), &data); err != nil {
-				slog.Debug("Failed to parse streaming chunk", "error", err, "data", jsonData)
+				slog.DebugContext(ctx, "Failed to parse streaming chunk", "error", err, "body", jsonData)
 				continue
 			}
 
-			if choices, ok := data["choices"].([]interface{}); ok && len(choices) > 0 {
-				if choice, ok := choices[0].(map[string]interface{}); ok {
-					if text, ok := choice["text"].(string); ok {
-						result.WriteString(text)
-					}
+			if len(data.Choices) > 0 {
+				choice := data.Choices[0]
+				chunk := CompletionChunk{Text: choice.Text, Logprobs: choice.Logprobs}
+				if choice.FinishReason != nil {
+					chunk.FinishReason = *choice.FinishReason
+				}
+				if err := onChunk(chunk); err != nil {
+					return err
 				}
 			}
 		}
 	}
 
-	return result.String(), nil
+	if err := scanner.Err(); err != nil {
+		return errors.NewCopilotAPIError(fmt.Sprintf("Failed to read completion stream: %s", err.Error()))
+	}
+	return nil
 }
@@ -0,0 +1,334 @@
+package copilot
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/devstroop/reai/internal/metrics"
+)
+
+// Copilot reports the quota of some plans in headers such as
+// X-Quota-Snapshot-Premium_interactions: ent=300&rem=72.5&rst=2025-11-01T00:00:00Z,
+// where ent is the monthly entitlement, rem the percentage left and rst the
+// reset time. The premium interactions snapshot is preferred when several
+// are sent.
+const (
+	quotaHeaderPrefix = "X-Quota-Snapshot-"
+	preferredQuota    = "Premium_interactions"
+)
+
+const (
+	// Rate limited responses within throttleWindow raise an alert once there
+	// are throttleAlertCount of them
+	throttleWindow     = time.Hour
+	throttleAlertCount = 3
+	// minForecastElapsed is how much of the month must have passed before the
+	// pace is extrapolated
+	minForecastElapsed = time.Hour
+	// consumptionSaveInterval bounds how often the record is written to disk
+	consumptionSaveInterval = time.Minute
+)
+
+// Upstream quota metrics
+var (
+	upstreamRateLimited    = metrics.NewCounter("reai_upstream_rate_limited_total", "Copilot completion requests answered with 429")
+	upstreamQuotaUsed      = metrics.NewGauge("reai_upstream_quota_used", "Copilot requests used this month")
+	upstreamQuotaLimit     = metrics.NewGauge("reai_upstream_quota_limit", "Monthly Copilot request allowance, 0 when unknown")
+	upstreamQuotaProjected = metrics.NewGauge("reai_upstream_quota_projected", "Copilot requests expected by the end of the month at the current pace")
+	upstreamQuotaAlert     = metrics.NewGauge("reai_upstream_quota_alert", "1 while the account is likely to run out of quota before it resets")
+)
+
+// QuotaSnapshot is the last quota reported by Copilot in response headers
+type QuotaSnapshot struct {
+	Name        string `json:"name"`
+	Entitlement int    `json:"entitlement"`
+	// PercentRemaining is the share of the entitlement left, 0-100
+	PercentRemaining float64   `json:"percent_remaining"`
+	ResetAt          time.Time `json:"reset_at,omitempty"`
+	Unlimited        bool      `json:"unlimited,omitempty"`
+	ObservedAt       time.Time `json:"observed_at"`
+}
+
+// QuotaForecast extrapolates the consumption of the current month at its
+// pace so far
+type QuotaForecast struct {
+	// Limit is the monthly allowance, from the quota headers or
+	// UPSTREAM_MONTHLY_REQUESTS; 0 when unknown
+	Limit       int     `json:"limit"`
+	LimitSource string  `json:"limit_source,omitempty"`
+	Used        float64 `json:"used"`
+	// Projected is the consumption expected by the end of the month
+	Projected   float64    `json:"projected"`
+	PeriodStart time.Time  `json:"period_start"`
+	ResetAt     time.Time  `json:"reset_at"`
+	ExhaustedAt *time.Time `json:"exhausted_at,omitempty"`
+	Alert       bool       `json:"alert"`
+	Reasons     []string   `json:"reasons,omitempty"`
+}
+
+// ConsumptionStatus reports the use of the Copilot account this month
+type ConsumptionStatus struct {
+	Month         string         `json:"month"`
+	Requests      int            `json:"requests"`
+	RateLimited   int            `json:"rate_limited"`
+	LastThrottled *time.Time     `json:"last_rate_limited,omitempty"`
+	Quota         *QuotaSnapshot `json:"quota,omitempty"`
+	Forecast      QuotaForecast  `json:"forecast"`
+}
+
+// consumption counts the completion requests sent to Copilot per calendar
+// month (UTC) and keeps the latest quota snapshot. It is saved to DATA_DIR
+// so the forecast survives restarts.
+type consumption struct {
+	mutex sync.Mutex
+	path  string
+	// monthlyLimit and alertThreshold come from the configuration
+	monthlyLimit   int
+	alertThreshold float64
+
+	record    consumptionRecord
+	throttled []time.Time
+	saved     time.Time
+	alerting  bool
+}
+
+// consumptionRecord is the on-disk format of the consumption file
+type consumptionRecord struct {
+	Month         string         `json:"month"`
+	Requests      int            `json:"requests"`
+	RateLimited   int            `json:"rate_limited"`
+	LastThrottled *time.Time     `json:"last_rate_limited,omitempty"`
+	Quota         *QuotaSnapshot `json:"quota,omitempty"`
+}
+
+// loadConsumption reads the consumption record at path, starting afresh when
+// there is none or it can't be read
+func loadConsumption(path string, monthlyLimit int, alertThreshold float64) *consumption {
+	c := &consumption{path: path, monthlyLimit: monthlyLimit, alertThreshold: alertThreshold}
+	if data, err := os.ReadFile(path); err != nil {
+		if !os.IsNotExist(err) {
+			slog.Warn("Failed to read consumption record", "error", err, "path", path)
+		}
+	} else if err := json.Unmarshal(data, &c.record); err != nil {
+		slog.Warn("Failed to parse consumption record", "error", err, "path", path)
+		c.record = consumptionRecord{}
+	}
+	c.rollover(time.Now())
+	setQuotaGauges(c.forecast(time.Now()))
+	return c
+}
+
+// month returns the calendar month of t as used in the record
+func month(t time.Time) string {
+	return t.UTC().Format("2006-01")
+}
+
+// rollover starts a new record when the month changed. The caller holds the mutex.
+func (c *consumption) rollover(now time.Time) {
+	if m := month(now); c.record.Month != m {
+		c.record = consumptionRecord{Month: m, Quota: c.record.Quota}
+		c.throttled = nil
+	}
+}
+
+// observe counts a completion request and the quota headers of its response
+func (c *consumption) observe(header http.Header, now time.Time) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.rollover(now)
+	c.record.Requests++
+	if snapshot := parseQuotaHeaders(header, now); snapshot != nil {
+		c.record.Quota = snapshot
+	}
+	c.update(now, false)
+}
+
+// throttle counts a completion request rejected with 429
+func (c *consumption) throttle(header http.Header, now time.Time) {
+	upstreamRateLimited.Inc()
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.rollover(now)
+	c.record.RateLimited++
+	c.record.LastThrottled = &now
+	c.throttled = append(c.throttled, now)
+	if snapshot := parseQuotaHeaders(header, now); snapshot != nil {
+		c.record.Quota = snapshot
+	}
+	c.update(now, true)
+}
+
+// update saves the record when due and logs alerts as they are raised. The
+// caller holds the mutex.
+func (c *consumption) update(now time.Time, force bool) {
+	if force || now.Sub(c.saved) >= consumptionSaveInterval {
+		c.save(now)
+	}
+
+	forecast := c.forecast(now)
+	setQuotaGauges(forecast)
+	if forecast.Alert && !c.alerting {
+		slog.Warn("⚠️ Copilot account is likely to run out of quota - consider adding an account",
+			"used", forecast.Used, "limit", forecast.Limit, "projected", forecast.Projected, "reasons", strings.Join(forecast.Reasons, "; "))
+	}
+	c.alerting = forecast.Alert
+}
+
+// setQuotaGauges exports a forecast
+func setQuotaGauges(f QuotaForecast) {
+	upstreamQuotaUsed.Set(f.Used)
+	upstreamQuotaLimit.Set(float64(f.Limit))
+	upstreamQuotaProjected.Set(f.Projected)
+	alert := 0.0
+	if f.Alert {
+		alert = 1
+	}
+	upstreamQuotaAlert.Set(alert)
+}
+
+// save writes the record to disk. The caller holds the mutex.
+func (c *consumption) save(now time.Time) {
+	c.saved = now
+	if c.path == "" {
+		return
+	}
+	data, err := json.Marshal(c.record)
+	if err != nil {
+		return
+	}
+	tmp := c.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		slog.Warn("Failed to save consumption record", "error", err)
+		return
+	}
+	if err := os.Rename(tmp, c.path); err != nil {
+		slog.Warn("Failed to save consumption record", "error", err)
+	}
+}
+
+// status returns the consumption of the current month and its forecast
+func (c *consumption) status(now time.Time) ConsumptionStatus {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.rollover(now)
+	return ConsumptionStatus{
+		Month:         c.record.Month,
+		Requests:      c.record.Requests,
+		RateLimited:   c.record.RateLimited,
+		LastThrottled: c.record.LastThrottled,
+		Quota:         c.record.Quota,
+		Forecast:      c.forecast(now),
+	}
+}
+
+// forecast projects the consumption at the end of the month. A quota
+// snapshot from Copilot takes precedence over the local request count. The
+// caller holds the mutex.
+func (c *consumption) forecast(now time.Time) QuotaForecast {
+	now = now.UTC()
+	start := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	f := QuotaForecast{
+		Used:        float64(c.record.Requests),
+		PeriodStart: start,
+		ResetAt:     start.AddDate(0, 1, 0),
+	}
+	if c.monthlyLimit > 0 {
+		f.Limit, f.LimitSource = c.monthlyLimit, "config"
+	}
+
+	if q := c.record.Quota; q != nil && !q.Unlimited && q.Entitlement > 0 && q.ResetAt.After(now) {
+		f.Limit, f.LimitSource = q.Entitlement, "headers"
+		f.Used = float64(q.Entitlement) * (100 - q.PercentRemaining) / 100
+		f.ResetAt = q.ResetAt.UTC()
+		f.PeriodStart = f.ResetAt.AddDate(0, -1, 0)
+		// The local count may be ahead of a snapshot taken earlier
+		f.Used = max(f.Used, float64(c.record.Requests))
+	}
+
+	f.Projected = f.Used
+	if elapsed := now.Sub(f.PeriodStart); elapsed >= minForecastElapsed && f.Used > 0 {
+		pace := f.Used / elapsed.Seconds()
+		f.Projected = f.Used + pace*f.ResetAt.Sub(now).Seconds()
+		if f.Limit > 0 && f.Used < float64(f.Limit) {
+			exhausted := now.Add(time.Duration((float64(f.Limit) - f.Used) / pace * float64(time.Second)))
+			if exhausted.Before(f.ResetAt) {
+				f.ExhaustedAt = &exhausted
+			}
+		}
+	}
+
+	if f.Limit > 0 {
+		switch {
+		case f.Used >= float64(f.Limit):
+			f.Reasons = append(f.Reasons, "monthly allowance used up")
+		case f.Used >= float64(f.Limit)*c.alertThreshold:
+			f.Reasons = append(f.Reasons, fmt.Sprintf("%.0f%% of the monthly allowance used", f.Used/float64(f.Limit)*100))
+		case f.ExhaustedAt != nil:
+			f.Reasons = append(f.Reasons, "at the current pace the allowance runs out on "+f.ExhaustedAt.Format("2006-01-02"))
+		}
+	}
+	recent := 0
+	for _, t := range c.throttled {
+		if now.Sub(t) < throttleWindow {
+			recent++
+		}
+	}
+	if recent >= throttleAlertCount {
+		f.Reasons = append(f.Reasons, fmt.Sprintf("rate limited %d times in the last hour", recent))
+	}
+	f.Alert = len(f.Reasons) > 0
+	return f
+}
+
+// parseQuotaHeaders returns the quota snapshot reported in header, or nil
+// when there is none
+func parseQuotaHeaders(header http.Header, now time.Time) *QuotaSnapshot {
+	var snapshot *QuotaSnapshot
+	for name, values := range header {
+		quota, ok := strings.CutPrefix(name, quotaHeaderPrefix)
+		if !ok || len(values) == 0 {
+			continue
+		}
+		if snapshot != nil && !strings.EqualFold(quota, preferredQuota) {
+			continue
+		}
+		fields, err := url.ParseQuery(values[0])
+		if err != nil {
+			continue
+		}
+		s := &QuotaSnapshot{Name: strings.ToLower(quota), ObservedAt: now}
+		if s.Entitlement, err = strconv.Atoi(fields.Get("ent")); err != nil {
+			continue
+		}
+		s.Unlimited = s.Entitlement < 0
+		if s.PercentRemaining, err = strconv.ParseFloat(fields.Get("rem"), 64); err != nil {
+			continue
+		}
+		if reset, err := time.Parse(time.RFC3339, fields.Get("rst")); err == nil {
+			s.ResetAt = reset
+		}
+		snapshot = s
+		if strings.EqualFold(quota, preferredQuota) {
+			break
+		}
+	}
+	return snapshot
+}
+
+// ConsumptionStatus returns the use of the Copilot account this month and
+// the forecast of whether it will last until the quota resets
+func (c *Client) ConsumptionStatus() ConsumptionStatus {
+	return c.consumption.status(time.Now())
+}
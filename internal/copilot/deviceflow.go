@@ -0,0 +1,278 @@
+package copilot
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/devstroop/reai/internal/webhook"
+)
+
+// Device flow states
+const (
+	DeviceFlowIdle       = "idle"
+	DeviceFlowStarting   = "starting"
+	DeviceFlowPending    = "pending"
+	DeviceFlowAuthorized = "authorized"
+	DeviceFlowFailed     = "failed"
+)
+
+// deviceCodeTimeout bounds the request for a device code
+const deviceCodeTimeout = 30 * time.Second
+
+// deviceFlowSecond is the unit of the intervals and lifetimes GitHub gives in
+// device flow responses. Tests shorten it.
+var deviceFlowSecond = time.Second
+
+// DeviceFlowStatus reports the latest GitHub device flow authentication
+type DeviceFlowStatus struct {
+	State           string     `json:"state"`
+	UserCode        string     `json:"user_code,omitempty"`
+	VerificationURI string     `json:"verification_uri,omitempty"`
+	ExpiresAt       *time.Time `json:"expires_at,omitempty"`
+	StartedAt       *time.Time `json:"started_at,omitempty"`
+	FinishedAt      *time.Time `json:"finished_at,omitempty"`
+	Error           string     `json:"error,omitempty"`
+}
+
+// deviceFlow is a device flow in progress. Every caller that needs to
+// authenticate while it runs waits for the same flow.
+type deviceFlow struct {
+	// ready is closed once the user code is known or the flow failed to start
+	ready     chan struct{}
+	markReady func()
+	done      chan struct{}
+	err       error
+}
+
+// Setup performs the GitHub OAuth device flow authentication, joining the
+// flow already waiting for the user if there is one
+func (c *Client) Setup(ctx context.Context) error {
+	flow := c.deviceFlow()
+	select {
+	case <-flow.done:
+		return flow.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// StartDeviceFlow starts device flow authentication, or joins the flow
+// already running, and returns its status once the user code is known. The
+// flow carries on in the background until the user authorizes it or the code
+// expires; the new access token then replaces the current one.
+func (c *Client) StartDeviceFlow(ctx context.Context) (DeviceFlowStatus, error) {
+	flow := c.deviceFlow()
+	select {
+	case <-flow.ready:
+	case <-ctx.Done():
+		return c.DeviceFlowStatus(), ctx.Err()
+	}
+	select {
+	case <-flow.done:
+		return c.DeviceFlowStatus(), flow.err
+	default:
+		return c.DeviceFlowStatus(), nil
+	}
+}
+
+// DeviceFlowStatus returns the status of the running or last device flow
+func (c *Client) DeviceFlowStatus() DeviceFlowStatus {
+	c.flowMutex.Lock()
+	defer c.flowMutex.Unlock()
+	if c.flowStatus.State == "" {
+		return DeviceFlowStatus{State: DeviceFlowIdle}
+	}
+	return c.flowStatus
+}
+
+// WatchDeviceFlow returns the status of the device flow and a channel closed
+// once the status changes
+func (c *Client) WatchDeviceFlow() (DeviceFlowStatus, <-chan struct{}) {
+	c.flowMutex.Lock()
+	defer c.flowMutex.Unlock()
+	if c.flowChanged == nil {
+		c.flowChanged = make(chan struct{})
+	}
+	status := c.flowStatus
+	if status.State == "" {
+		status = DeviceFlowStatus{State: DeviceFlowIdle}
+	}
+	return status, c.flowChanged
+}
+
+// flowUpdated wakes the watchers of the device flow status. The caller holds
+// c.flowMutex.
+func (c *Client) flowUpdated() {
+	if c.flowChanged != nil {
+		close(c.flowChanged)
+		c.flowChanged = nil
+	}
+}
+
+// deviceFlow returns the running device flow, starting one if there is none
+func (c *Client) deviceFlow() *deviceFlow {
+	c.flowMutex.Lock()
+	defer c.flowMutex.Unlock()
+
+	if c.flow != nil {
+		select {
+		case <-c.flow.done:
+		default:
+			slog.Info("Joining device flow already in progress", "state", c.flowStatus.State)
+			return c.flow
+		}
+	}
+
+	ready := make(chan struct{})
+	flow := &deviceFlow{ready: ready, markReady: sync.OnceFunc(func() { close(ready) }), done: make(chan struct{})}
+	now := time.Now().UTC()
+	c.flow = flow
+	c.flowStatus = DeviceFlowStatus{State: DeviceFlowStarting, StartedAt: &now}
+	c.flowUpdated()
+	go c.runDeviceFlow(flow)
+	return flow
+}
+
+// runDeviceFlow runs a device flow to completion and records the outcome. It
+// does not depend on any caller's context, so callers can give up waiting
+// without abandoning the flow for the others.
+func (c *Client) runDeviceFlow(flow *deviceFlow) {
+	err := c.authorizeDevice(flow)
+
+	c.flowMutex.Lock()
+	now := time.Now().UTC()
+	c.flowStatus.FinishedAt = &now
+	if err != nil {
+		c.flowStatus.State = DeviceFlowFailed
+		c.flowStatus.Error = err.Error()
+	} else {
+		c.flowStatus.State = DeviceFlowAuthorized
+	}
+	c.flowUpdated()
+	c.flowMutex.Unlock()
+
+	flow.err = err
+	flow.markReady()
+	close(flow.done)
+
+	if err != nil {
+		slog.Error("Device flow authentication failed", "error", err)
+		return
+	}
+	// Exchange the new access token for a session token. A refresh that is
+	// waiting for this flow is joined rather than repeated.
+	go func() {
+		if err := c.GetSessionToken(context.Background()); err != nil {
+			slog.Warn("Failed to get session token after authentication", "error", err)
+		}
+	}()
+}
+
+// authorizeDevice requests a device code and polls until the user authorizes it
+func (c *Client) authorizeDevice(flow *deviceFlow) error {
+	slog.Info("Starting Copilot authentication setup...")
+
+	// Step 1: Get device code
+	deviceReq := map[string]string{
+		"client_id": c.config.ClientID,
+		"scope":     "read:user",
+	}
+
+	codeCtx, cancel := context.WithTimeout(context.Background(), deviceCodeTimeout)
+	deviceResp, err := c.makeRequest(codeCtx, "POST", c.config.DeviceCodeURL(), deviceReq, nil)
+	cancel()
+	if err != nil {
+		return fmt.Errorf("device code request failed: %w", err)
+	}
+
+	var deviceData DeviceCodeResponse
+	if err := json.Unmarshal(deviceResp, &deviceData); err != nil {
+		return fmt.Errorf("failed to parse device code response: %w", err)
+	}
+
+	expiresAt := time.Now().Add(time.Duration(deviceData.ExpiresIn) * deviceFlowSecond).UTC()
+	if deviceData.ExpiresIn <= 0 {
+		expiresAt = time.Now().Add(900 * deviceFlowSecond).UTC()
+	}
+	c.flowMutex.Lock()
+	c.flowStatus.State = DeviceFlowPending
+	c.flowStatus.UserCode = deviceData.UserCode
+	c.flowStatus.VerificationURI = deviceData.VerificationURI
+	c.flowStatus.ExpiresAt = &expiresAt
+	c.flowUpdated()
+	c.flowMutex.Unlock()
+	flow.markReady()
+
+	fmt.Printf("Please visit %s and enter code %s to authenticate.\n",
+		deviceData.VerificationURI, deviceData.UserCode)
+	slog.Info("🔐 Waiting for device authorization", "verification_uri", deviceData.VerificationURI, "user_code", deviceData.UserCode, "expires_at", expiresAt)
+	c.notify(webhook.EventAuthRequired, deviceData.UserCode, map[string]interface{}{
+		"verification_uri": deviceData.VerificationURI,
+		"user_code":        deviceData.UserCode,
+		"expires_at":       expiresAt,
+	})
+
+	// Step 2: Poll for access token
+	ctx, cancel := context.WithDeadline(context.Background(), expiresAt)
+	defer cancel()
+
+	interval := time.Duration(max(deviceData.Interval, 1)) * deviceFlowSecond
+	for {
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("device code expired before it was authorized")
+		case <-time.After(interval):
+		}
+
+		tokenReq := map[string]string{
+			"client_id":   c.config.ClientID,
+			"device_code": deviceData.DeviceCode,
+			"grant_type":  "urn:ietf:params:oauth:grant-type:device_code",
+		}
+
+		tokenResp, err := c.makeRequest(ctx, "POST", c.config.AccessTokenURL(), tokenReq, nil)
+		if err != nil {
+			slog.Warn("Token request failed", "error", err)
+			continue
+		}
+
+		var tokenData AccessTokenResponse
+		if err := json.Unmarshal(tokenResp, &tokenData); err != nil {
+			slog.Warn("Failed to parse token response", "error", err)
+			continue
+		}
+
+		if tokenData.AccessToken != nil {
+			c.mutex.Lock()
+			c.accessToken = *tokenData.AccessToken
+			c.mutex.Unlock()
+			if err := c.saveAccessToken(*tokenData.AccessToken); err != nil {
+				slog.Warn("Failed to save token to file, keeping in memory only", "error", err)
+			}
+			fmt.Println("Authentication success!")
+			return nil
+		}
+
+		if tokenData.Error != nil {
+			switch *tokenData.Error {
+			case "authorization_pending":
+				continue
+			case "slow_down":
+				// Polls must be 5 seconds further apart from now on, or as far
+				// apart as GitHub says
+				interval = max(interval+5*deviceFlowSecond, time.Duration(tokenData.Interval)*deviceFlowSecond)
+				slog.Debug("Device flow polling slowed down", "interval", interval)
+				continue
+			case "expired_token":
+				return fmt.Errorf("device code expired before it was authorized")
+			case "access_denied":
+				return fmt.Errorf("authorization was denied by the user")
+			}
+			return fmt.Errorf("authentication error: %s", *tokenData.Error)
+		}
+	}
+}
@@ -0,0 +1,188 @@
+package copilot
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// deviceFlowGitHub serves the device flow, answering each access token poll
+// with the next of replies. Once replies run out it answers
+// authorization_pending.
+type deviceFlowGitHub struct {
+	expiresIn int
+	interval  int
+	replies   []map[string]interface{}
+
+	mutex sync.Mutex
+	polls []time.Time
+}
+
+func (g *deviceFlowGitHub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/login/device/code":
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"device_code":      "dc_test",
+			"user_code":        "ABCD-1234",
+			"verification_uri": "https://github.com/login/device",
+			"expires_in":       g.expiresIn,
+			"interval":         g.interval,
+		})
+	case "/login/oauth/access_token":
+		g.mutex.Lock()
+		n := len(g.polls)
+		g.polls = append(g.polls, time.Now())
+		g.mutex.Unlock()
+		reply := map[string]interface{}{"error": "authorization_pending"}
+		if n < len(g.replies) {
+			reply = g.replies[n]
+		}
+		json.NewEncoder(w).Encode(reply)
+	case "/copilot_internal/v2/token":
+		exp := time.Now().Add(time.Hour).Unix()
+		json.NewEncoder(w).Encode(map[string]interface{}{"token": fmt.Sprintf("tid=test;exp=%d:sig", exp), "expires_at": exp})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// gaps returns the time between consecutive polls
+func (g *deviceFlowGitHub) gaps() []time.Duration {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	var gaps []time.Duration
+	for i := 1; i < len(g.polls); i++ {
+		gaps = append(gaps, g.polls[i].Sub(g.polls[i-1]))
+	}
+	return gaps
+}
+
+// shortenDeviceFlow makes a device flow second last unit for the test
+func shortenDeviceFlow(t *testing.T, unit time.Duration) {
+	t.Helper()
+	saved := deviceFlowSecond
+	deviceFlowSecond = unit
+	t.Cleanup(func() { deviceFlowSecond = saved })
+}
+
+// accessToken returns the GitHub access token the client holds
+func accessToken(client *Client) string {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	return client.accessToken
+}
+
+// setupClient runs device flow authentication against github
+func setupClient(t *testing.T, github http.Handler) (*Client, error) {
+	t.Helper()
+	client := newTestClient(t, github, "")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return client, client.Setup(ctx)
+}
+
+func TestDeviceFlowAuthorized(t *testing.T) {
+	shortenDeviceFlow(t, 10*time.Millisecond)
+	github := &deviceFlowGitHub{expiresIn: 900, interval: 1, replies: []map[string]interface{}{
+		{"error": "authorization_pending"},
+		{"error": "authorization_pending"},
+		{"access_token": "gho_new"},
+	}}
+	client, err := setupClient(t, github)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state := client.DeviceFlowStatus().State; state != DeviceFlowAuthorized {
+		t.Errorf("state %s, want %s", state, DeviceFlowAuthorized)
+	}
+	if token := accessToken(client); token != "gho_new" {
+		t.Errorf("access token %q", token)
+	}
+	if n := len(github.gaps()) + 1; n != 3 {
+		t.Errorf("%d polls, want 3", n)
+	}
+}
+
+func TestDeviceFlowSlowDown(t *testing.T) {
+	unit := 10 * time.Millisecond
+	shortenDeviceFlow(t, unit)
+
+	tests := []struct {
+		name     string
+		slowDown map[string]interface{}
+		want     time.Duration
+	}{
+		// Without an interval the polls are 5 seconds further apart
+		{"default", map[string]interface{}{"error": "slow_down"}, 6 * unit},
+		// GitHub's interval wins when it is longer
+		{"interval", map[string]interface{}{"error": "slow_down", "interval": 12}, 12 * unit},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			github := &deviceFlowGitHub{expiresIn: 900, interval: 1, replies: []map[string]interface{}{
+				{"error": "authorization_pending"},
+				tt.slowDown,
+				{"error": "authorization_pending"},
+				{"access_token": "gho_new"},
+			}}
+			if _, err := setupClient(t, github); err != nil {
+				t.Fatal(err)
+			}
+			gaps := github.gaps()
+			if len(gaps) != 3 {
+				t.Fatalf("%d polls, want 4", len(gaps)+1)
+			}
+			if gaps[0] >= tt.want {
+				t.Errorf("gap before slow_down %v, want under %v", gaps[0], tt.want)
+			}
+			for _, gap := range gaps[1:] {
+				if gap < tt.want {
+					t.Errorf("gap after slow_down %v, want at least %v", gap, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestDeviceFlowFails(t *testing.T) {
+	shortenDeviceFlow(t, 10*time.Millisecond)
+
+	tests := []struct {
+		name   string
+		github *deviceFlowGitHub
+		want   string
+	}{
+		{"expired token", &deviceFlowGitHub{expiresIn: 900, interval: 1, replies: []map[string]interface{}{
+			{"error": "authorization_pending"},
+			{"error": "expired_token"},
+		}}, "device code expired"},
+		// The code expires locally while GitHub keeps answering pending
+		{"expired locally", &deviceFlowGitHub{expiresIn: 5, interval: 1}, "device code expired"},
+		{"access denied", &deviceFlowGitHub{expiresIn: 900, interval: 1, replies: []map[string]interface{}{
+			{"error": "access_denied"},
+		}}, "denied"},
+		{"unknown error", &deviceFlowGitHub{expiresIn: 900, interval: 1, replies: []map[string]interface{}{
+			{"error": "incorrect_client_credentials"},
+		}}, "authentication error: incorrect_client_credentials"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, err := setupClient(t, tt.github)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("got %v, want %q", err, tt.want)
+			}
+			status := client.DeviceFlowStatus()
+			if status.State != DeviceFlowFailed || status.Error != err.Error() {
+				t.Errorf("status %+v", status)
+			}
+			if token := accessToken(client); token != "" {
+				t.Errorf("access token %q", token)
+			}
+		})
+	}
+}
@@ -0,0 +1,214 @@
+package copilot
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/devstroop/reai/internal/metrics"
+)
+
+var editorUpdates = metrics.NewCounterVec("reai_editor_version_updates_total", "Editor version fetches by result (changed, unchanged, error)", "result")
+
+// DefaultEditor is the editor Copilot requests claim to come from unless
+// EDITOR_PROFILE says otherwise
+const DefaultEditor = "vscode"
+
+// editorsData holds the built-in editor profiles. Their versions go stale as
+// editors update, so they live in a data file rather than in constants.
+//
+//go:embed editors.json
+var editorsData []byte
+
+// Editor is the set of headers identifying the editor and Copilot plugin a
+// request comes from. Copilot behaves differently per editor.
+type Editor struct {
+	Name                string `json:"-"`
+	UserAgent           string `json:"user_agent"`
+	EditorVersion       string `json:"editor_version"`
+	EditorPluginVersion string `json:"editor_plugin_version"`
+	// IntegrationID is sent as Copilot-Integration-Id when set
+	IntegrationID string `json:"integration_id,omitempty"`
+}
+
+// editorsFile is the format of editors.json and EDITOR_PROFILES_FILE
+type editorsFile struct {
+	Editors map[string]Editor `json:"editors"`
+}
+
+// parseEditors adds the editor profiles in data to editors, replacing the
+// ones with the same name
+func parseEditors(data []byte, editors map[string]Editor) error {
+	var file editorsFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return err
+	}
+	for name, editor := range file.Editors {
+		name = strings.ToLower(name)
+		if editor.UserAgent == "" || editor.EditorVersion == "" {
+			return fmt.Errorf("editor profile %q needs user_agent and editor_version", name)
+		}
+		editor.Name = name
+		editors[name] = editor
+	}
+	return nil
+}
+
+// loadEditors returns the built-in editor profiles updated with the ones
+// fetched from EDITOR_VERSIONS_URL (remote, nil when none) and the ones in
+// path, and the default profile named by name
+func loadEditors(remote []byte, path, name string) (map[string]Editor, Editor, error) {
+	editors := make(map[string]Editor)
+	if err := parseEditors(editorsData, editors); err != nil {
+		return nil, Editor{}, fmt.Errorf("built-in editor profiles: %w", err)
+	}
+	if remote != nil {
+		if err := parseEditors(remote, editors); err != nil {
+			return nil, Editor{}, fmt.Errorf("failed to parse fetched editor profiles: %w", err)
+		}
+	}
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, Editor{}, fmt.Errorf("failed to read editor profiles file: %w", err)
+		}
+		if err := parseEditors(data, editors); err != nil {
+			return nil, Editor{}, fmt.Errorf("failed to parse editor profiles file: %w", err)
+		}
+	}
+
+	if name = strings.ToLower(strings.TrimSpace(name)); name == "" {
+		name = DefaultEditor
+	}
+	editor, ok := editors[name]
+	if !ok {
+		return nil, Editor{}, fmt.Errorf("unknown editor profile %q (want %s)", name, strings.Join(editorNames(editors), ", "))
+	}
+	return editors, editor, nil
+}
+
+func editorNames(editors map[string]Editor) []string {
+	names := make([]string, 0, len(editors))
+	for name := range editors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Editors lists the names of the editor profiles
+func (c *Client) Editors() []string {
+	c.editorsMutex.RLock()
+	defer c.editorsMutex.RUnlock()
+	return editorNames(c.editors)
+}
+
+// HasEditor reports whether an editor profile exists
+func (c *Client) HasEditor(name string) bool {
+	c.editorsMutex.RLock()
+	defer c.editorsMutex.RUnlock()
+	_, ok := c.editors[strings.ToLower(name)]
+	return ok
+}
+
+// editorFor returns the editor profile of the request, the default unless
+// one was chosen with WithEditor
+func (c *Client) editorFor(ctx context.Context) Editor {
+	c.editorsMutex.RLock()
+	defer c.editorsMutex.RUnlock()
+	if name, ok := ctx.Value(editorKey{}).(string); ok {
+		if editor, ok := c.editors[name]; ok {
+			return editor
+		}
+	}
+	return c.editor
+}
+
+// maxEditorsSize bounds the editor profiles fetched from EDITOR_VERSIONS_URL
+const maxEditorsSize = 1 << 20
+
+// UpdateEditors fetches the current editor versions from EDITOR_VERSIONS_URL,
+// a document in the format of editors.json, and applies them to the requests
+// that follow. Profiles in EDITOR_PROFILES_FILE still take precedence. The
+// current profiles are kept when the fetch fails.
+func (c *Client) UpdateEditors(ctx context.Context) error {
+	url := c.config.EditorVersionsURL
+	if url == "" {
+		return nil
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		editorUpdates.With("error").Inc()
+		return err
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		editorUpdates.With("error").Inc()
+		return fmt.Errorf("failed to fetch editor versions: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		editorUpdates.With("error").Inc()
+		return fmt.Errorf("failed to fetch editor versions: %s", resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxEditorsSize))
+	if err != nil {
+		editorUpdates.With("error").Inc()
+		return fmt.Errorf("failed to fetch editor versions: %w", err)
+	}
+
+	editors, editor, err := loadEditors(data, c.config.EditorProfilesFile, c.config.EditorProfile)
+	if err != nil {
+		editorUpdates.With("error").Inc()
+		return err
+	}
+
+	c.editorsMutex.Lock()
+	previous := c.editor
+	c.editors, c.editor = editors, editor
+	c.editorsMutex.Unlock()
+
+	if previous != editor {
+		editorUpdates.With("changed").Inc()
+		slog.Info("Editor versions updated", "editor", editor.Name, "editor_version", editor.EditorVersion, "plugin_version", editor.EditorPluginVersion)
+	} else {
+		editorUpdates.With("unchanged").Inc()
+	}
+	return nil
+}
+
+// RunEditorUpdates refreshes the editor versions every EDITOR_VERSIONS_INTERVAL
+// until ctx is done
+func (c *Client) RunEditorUpdates(ctx context.Context) {
+	if c.config.EditorVersionsURL == "" || c.config.EditorVersionsInterval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(c.config.EditorVersionsInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if err := c.UpdateEditors(ctx); err != nil {
+			slog.Warn("Editor versions not updated - keeping the current ones", "error", err)
+		}
+	}
+}
+
+type editorKey struct{}
+
+// WithEditor returns a context whose upstream requests use the named editor
+// profile instead of the default
+func WithEditor(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, editorKey{}, strings.ToLower(name))
+}
@@ -0,0 +1,80 @@
+package copilot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/devstroop/reai/internal/webhook"
+)
+
+// pingTTL is how long an upstream ping result is reused, so frequent
+// readiness probes don't turn into a stream of Copilot requests
+const pingTTL = 30 * time.Second
+
+// CheckAuth reports whether the client holds a valid session token or can get
+// one without user interaction. It never starts a device flow: a missing or
+// rejected access token is reported as ErrUnauthenticated, and the next
+// request starts the flow. Refreshing the token is bounded by ctx.
+func (c *Client) CheckAuth(ctx context.Context) error {
+	if _, ok := c.validSessionToken(); ok {
+		return nil
+	}
+	if status := c.DeviceFlowStatus(); status.State == DeviceFlowPending {
+		return fmt.Errorf("%w: waiting for device authorization at %s", ErrUnauthenticated, status.VerificationURI)
+	}
+
+	c.mutex.RLock()
+	accessToken := c.accessToken
+	c.mutex.RUnlock()
+	// With a shared store the token may come from another replica
+	if accessToken == "" && c.shared == nil {
+		if _, err := os.Stat(c.config.AccessTokenPath()); err != nil {
+			return fmt.Errorf("%w: no GitHub access token", ErrUnauthenticated)
+		}
+	}
+
+	if _, err := c.probeSessionToken(ctx); err != nil {
+		if errors.Is(err, ErrUnauthenticated) {
+			return err
+		}
+		return fmt.Errorf("session token refresh failed: %w", err)
+	}
+	return nil
+}
+
+// Ping checks that the Copilot API accepts the session token by listing
+// models. Results are cached for pingTTL. The first failure after a success
+// is reported to the notifier as upstream_down.
+func (c *Client) Ping(ctx context.Context) error {
+	c.pingMutex.Lock()
+	defer c.pingMutex.Unlock()
+	if time.Now().Before(c.pingExpires) {
+		return c.pingErr
+	}
+
+	wasUp := c.pingExpires.IsZero() || c.pingErr == nil
+	c.pingErr = c.ping(ctx)
+	c.pingExpires = time.Now().Add(pingTTL)
+	if c.pingErr != nil && wasUp {
+		c.notify(webhook.EventUpstreamDown, "", map[string]interface{}{"error": c.pingErr.Error()})
+	}
+	return c.pingErr
+}
+
+func (c *Client) ping(ctx context.Context) error {
+	sessionToken, err := c.probeSessionToken(ctx)
+	if err != nil {
+		return fmt.Errorf("no session token: %w", err)
+	}
+	resp, err := c.openRequest(ctx, "GET", c.Profile().ModelsURL, nil, map[string]string{
+		"Authorization": fmt.Sprintf("Bearer %s", sessionToken),
+	})
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
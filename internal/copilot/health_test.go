@@ -0,0 +1,107 @@
+package copilot
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/devstroop/reai/internal/config"
+)
+
+// newTestClient returns a client whose GitHub is served by handler
+func newTestClient(t *testing.T, handler http.Handler, accessToken string) *Client {
+	t.Helper()
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	github := httptest.NewServer(handler)
+	t.Cleanup(github.Close)
+
+	t.Setenv("DATA_DIR", t.TempDir())
+	t.Setenv("GITHUB_BASE_URL", github.URL)
+	t.Setenv("GITHUB_API_URL", github.URL)
+	t.Setenv("GITHUB_ACCESS_TOKEN", accessToken)
+	client, err := NewClient(config.LoadFromEnv())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+func TestCheckAuth(t *testing.T) {
+	var deviceCodes, status atomic.Int32
+	status.Store(http.StatusOK)
+	client := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/copilot_internal/v2/token":
+			if code := int(status.Load()); code != http.StatusOK {
+				http.Error(w, "bad credentials", code)
+				return
+			}
+			exp := time.Now().Add(time.Hour).Unix()
+			json.NewEncoder(w).Encode(map[string]interface{}{"token": fmt.Sprintf("tid=test;exp=%d:sig", exp), "expires_at": exp})
+		case "/login/device/code":
+			deviceCodes.Add(1)
+			http.Error(w, "unexpected device flow", http.StatusInternalServerError)
+		default:
+			http.NotFound(w, r)
+		}
+	}), "gho_test")
+
+	// A revoked access token fails the check without a device flow
+	status.Store(http.StatusUnauthorized)
+	if err := client.CheckAuth(context.Background()); !errors.Is(err, ErrUnauthenticated) {
+		t.Fatalf("got %v, want ErrUnauthenticated", err)
+	}
+	if n := deviceCodes.Load(); n != 0 {
+		t.Errorf("%d device codes requested", n)
+	}
+	if state := client.DeviceFlowStatus().State; state == DeviceFlowPending {
+		t.Errorf("device flow %s", state)
+	}
+
+	status.Store(http.StatusOK)
+	if err := client.CheckAuth(context.Background()); err != nil {
+		t.Fatalf("valid access token: %v", err)
+	}
+}
+
+func TestCheckAuthWithoutAccessToken(t *testing.T) {
+	client := newTestClient(t, http.NotFoundHandler(), "")
+	if err := client.CheckAuth(context.Background()); !errors.Is(err, ErrUnauthenticated) {
+		t.Fatalf("got %v, want ErrUnauthenticated", err)
+	}
+}
+
+func TestCheckAuthDeadline(t *testing.T) {
+	cancelled := make(chan struct{})
+	client := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+			close(cancelled)
+		case <-time.After(5 * time.Second):
+		}
+	}), "gho_test")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := client.CheckAuth(ctx); err == nil {
+		t.Fatal("check passed without a session token")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("check took %v past its deadline", elapsed)
+	}
+	// The token request is abandoned with the probe
+	select {
+	case <-cancelled:
+	case <-time.After(2 * time.Second):
+		t.Error("session token request outlived the probe")
+	}
+}
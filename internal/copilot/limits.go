@@ -0,0 +1,74 @@
+package copilot
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// How long the model catalog used for limit lookups is kept, and how soon a
+// failed fetch is retried
+const (
+	catalogTTL      = 10 * time.Minute
+	catalogRetryTTL = time.Minute
+)
+
+// GetModelLimits returns the token limits published for a model, or nil when
+// the model or its limits are unknown. The catalog is fetched once and cached,
+// so this is cheap enough to call for every request. An expired catalog is
+// still served while a single background fetch refreshes it; only the first
+// lookups wait for the catalog, and only until ctx is done.
+func (c *Client) GetModelLimits(ctx context.Context, model string) *ModelLimits {
+	c.catalogMutex.Lock()
+	if time.Now().After(c.catalogExpires) && c.catalogRefresh == nil {
+		c.catalogRefresh = make(chan struct{})
+		// The fetch outlives a cancelled request so the result can be shared
+		go c.refreshCatalog(context.WithoutCancel(ctx), c.catalogRefresh)
+	}
+	catalog, refresh := c.catalog, c.catalogRefresh
+	c.catalogMutex.Unlock()
+
+	if catalog == nil && refresh != nil {
+		select {
+		case <-refresh:
+		case <-ctx.Done():
+			return nil
+		}
+		c.catalogMutex.Lock()
+		catalog = c.catalog
+		c.catalogMutex.Unlock()
+	}
+
+	info, ok := catalog[model]
+	if !ok || info.Capabilities == nil {
+		return nil
+	}
+	return info.Capabilities.Limits
+}
+
+// refreshCatalog reloads the model catalog without holding catalogMutex
+// during the fetch, and closes done when it is finished
+func (c *Client) refreshCatalog(ctx context.Context, done chan struct{}) {
+	models, err := c.GetAvailableModels(ctx)
+
+	c.catalogMutex.Lock()
+	defer c.catalogMutex.Unlock()
+	defer close(done)
+	c.catalogRefresh = nil
+	if err != nil || len(models) == 0 {
+		slog.Warn("Model catalog unavailable - context limits are not enforced", "error", err)
+		c.catalogExpires = time.Now().Add(catalogRetryTTL)
+		if c.catalog == nil {
+			// Later lookups don't wait for the retry
+			c.catalog = map[string]ModelInfo{}
+		}
+		return
+	}
+
+	catalog := make(map[string]ModelInfo, len(models))
+	for _, model := range models {
+		catalog[model.ID] = model
+	}
+	c.catalog = catalog
+	c.catalogExpires = time.Now().Add(catalogTTL)
+}
@@ -7,85 +7,48 @@ import (
 	"log/slog"
 	"strings"
 	"time"
-
-	"github.com/devstroop/reai/internal/config"
 )
 
 // GetAvailableModels fetches available models dynamically from GitHub Copilot API
 func (c *Client) GetAvailableModels(ctx context.Context) ([]ModelInfo, error) {
-	slog.Info("GetAvailableModels called - fetching from server")
-	
 	// Try to fetch models from server
-	if models, err := c.fetchModelsFromMultipleSources(ctx); err == nil && len(models) > 0 {
-		slog.Info("Successfully fetched models from server", "count", len(models))
+	if models, err := c.fetchModels(ctx); err == nil && len(models) > 0 {
+		slog.Debug("Fetched models from server", "count", len(models))
 		return models, nil
 	} else {
-		slog.Warn("Failed to fetch models from server", "error", err)
+		// No models found - return empty list
+		slog.Warn("No models fetched from server - returning empty list", "error", err)
 	}
-
-	// No models found - return empty list
-	slog.Info("No models found from server - returning empty list")
 	return []ModelInfo{}, nil
 }
 
-// fetchModelsFromMultipleSources attempts to fetch models from GitHub Copilot endpoints
-func (c *Client) fetchModelsFromMultipleSources(ctx context.Context) ([]ModelInfo, error) {
-	slog.Info("Starting model fetch from server")
-	
-	// Get session token
-	if !c.isTokenValid() {
-		slog.Info("No valid session token, attempting to get one")
-		if err := c.GetSessionToken(ctx); err != nil {
-			slog.Error("Failed to get session token", "error", err)
-			return nil, fmt.Errorf("authentication failed: %w", err)
-		}
-		slog.Info("Successfully obtained session token")
-	} else {
-		slog.Info("Using existing valid session token")
+// fetchModels fetches the model catalog of the upstream profile. Getting the
+// session token first brings the profile up to date with the endpoints the
+// token was issued for.
+func (c *Client) fetchModels(ctx context.Context) ([]ModelInfo, error) {
+	sessionToken, err := c.currentSessionToken(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("authentication failed: %w", err)
 	}
 
-	sessionToken := c.sessionToken
-	slog.Info("Session token info", "length", len(sessionToken), "prefix", sessionToken[:min(10, len(sessionToken))])
-
-	// Test if our token works with completions endpoint first
-	if err := c.testSessionTokenWithCompletions(ctx, sessionToken); err != nil {
-		slog.Warn("Session token doesn't work with completions API", "error", err)
-		// Don't fail here, just log warning and continue to try models endpoints
-	} else {
-		slog.Info("Session token is valid - completions API accessible")
-	}
-
-	// Try different endpoints that might work
-	endpoints := []struct {
-		name string
-		url  string
-	}{
-		{"GitHub Copilot API", config.ModelsURL}, // https://api.githubcopilot.com/models
-		{"Copilot Proxy Models", config.ModelsURLAlt}, 
-		{"GitHub Copilot Individual", "https://api.githubcopilot.com/models"},
-		{"GitHub Copilot Business", "https://api.business.githubcopilot.com/models"},
-		{"GitHub Copilot Enterprise", "https://api.enterprise.githubcopilot.com/models"},
-	}
-
-	for i, endpoint := range endpoints {
-		slog.Info("Trying models endpoint", "name", endpoint.name, "url", endpoint.url, "attempt", i+1)
-		if models, err := c.tryModelsEndpoint(ctx, sessionToken, endpoint.url); err == nil && len(models) > 0 {
-			slog.Info("Successfully fetched models", "source", endpoint.name, "count", len(models))
-			return c.deduplicateModels(models), nil
-		} else {
-			slog.Error("Models endpoint request failed", "name", endpoint.name, "url", endpoint.url, "error", err)
-		}
+	// The request itself is in the upstream request log
+	profile := c.Profile()
+	models, err := c.tryModelsEndpoint(ctx, sessionToken, profile.ModelsURL)
+	if err != nil {
+		return nil, err
 	}
-
-	slog.Error("No models found from any endpoint - server-side only policy")
-	// No fallbacks - if server doesn't provide models, return empty
-	return []ModelInfo{}, fmt.Errorf("no models available from any server endpoint")
+	if len(models) == 0 {
+		return []ModelInfo{}, fmt.Errorf("no models available from %s", profile.ModelsURL)
+	}
+	for i := range models {
+		models[i].Endpoints = []string{profile.Name}
+	}
+	// Duplicate entries keep the richest metadata
+	return c.mergeModels(models), nil
 }
 
 // tryModelsEndpoint tries to fetch models from a models endpoint
 func (c *Client) tryModelsEndpoint(ctx context.Context, sessionToken, modelsURL string) ([]ModelInfo, error) {
-	slog.Debug("Making request to models endpoint", "url", modelsURL)
-	
 	headers := map[string]string{
 		"Authorization":      fmt.Sprintf("Bearer %s", sessionToken),
 		"Accept":            "application/json",
@@ -95,12 +58,9 @@ func (c *Client) tryModelsEndpoint(ctx context.Context, sessionToken, modelsURL
 
 	resp, err := c.makeRequest(ctx, "GET", modelsURL, nil, headers)
 	if err != nil {
-		slog.Error("Models endpoint request failed", "url", modelsURL, "error", err)
 		return nil, err
 	}
 
-	slog.Debug("Models endpoint response received", "url", modelsURL, "response_length", len(resp))
-	
 	// Log the actual response for debugging
 	if len(resp) < 1000 { // Only log if response is not too large
 		slog.Debug("Models endpoint raw response", "url", modelsURL, "response", string(resp))
@@ -111,28 +71,26 @@ func (c *Client) tryModelsEndpoint(ctx context.Context, sessionToken, modelsURL
 
 // parseModelsResponse attempts to parse model response
 func (c *Client) parseModelsResponse(resp []byte, source string) ([]ModelInfo, error) {
-	slog.Debug("Parsing models response", "source", source, "response_length", len(resp))
-	
 	// Try OpenAI-style response
 	var modelsResponse struct {
 		Data []ModelInfo `json:"data"`
 	}
 	if err := json.Unmarshal(resp, &modelsResponse); err == nil && len(modelsResponse.Data) > 0 {
-		slog.Info("Parsed models using OpenAI format", "source", source, "count", len(modelsResponse.Data))
+		slog.Debug("Parsed models using OpenAI format", "source", source, "count", len(modelsResponse.Data))
 		return modelsResponse.Data, nil
 	}
 
 	// Try direct array
 	var directModels []ModelInfo
 	if err := json.Unmarshal(resp, &directModels); err == nil && len(directModels) > 0 {
-		slog.Info("Parsed models using direct array format", "source", source, "count", len(directModels))
+		slog.Debug("Parsed models using direct array format", "source", source, "count", len(directModels))
 		return directModels, nil
 	}
 
 	// Try simple names
 	var modelNames []string
 	if err := json.Unmarshal(resp, &modelNames); err == nil && len(modelNames) > 0 {
-		slog.Info("Parsed models using simple names format", "source", source, "count", len(modelNames))
+		slog.Debug("Parsed models using simple names format", "source", source, "count", len(modelNames))
 		var models []ModelInfo
 		for _, name := range modelNames {
 			models = append(models, ModelInfo{
@@ -148,63 +106,89 @@ func (c *Client) parseModelsResponse(resp []byte, source string) ([]ModelInfo, e
 		return models, nil
 	}
 
-	// Try to parse any JSON and see what we get
+	// Say what was returned instead
 	var genericResponse interface{}
-	if err := json.Unmarshal(resp, &genericResponse); err == nil {
-		slog.Debug("Successfully parsed as JSON", "source", source, "type", fmt.Sprintf("%T", genericResponse))
-	} else {
-		slog.Error("Failed to parse response as JSON", "source", source, "error", err)
+	if err := json.Unmarshal(resp, &genericResponse); err != nil {
+		return nil, fmt.Errorf("unable to parse response from %s: %w", source, err)
 	}
-
-	return nil, fmt.Errorf("unable to parse response from %s", source)
+	return nil, fmt.Errorf("unable to parse response from %s: unexpected %T", source, genericResponse)
 }
 
-func (c *Client) deduplicateModels(models []ModelInfo) []ModelInfo {
-	seen := make(map[string]bool)
+// mergeModels combines entries with the same ID, filling missing metadata from
+// every occurrence and recording all endpoints that serve the model
+func (c *Client) mergeModels(models []ModelInfo) []ModelInfo {
+	index := make(map[string]int)
 	var result []ModelInfo
-	
+
 	for _, model := range models {
-		if !seen[model.ID] {
-			seen[model.ID] = true
+		i, ok := index[model.ID]
+		if !ok {
+			index[model.ID] = len(result)
 			result = append(result, model)
+			continue
 		}
+		mergeModel(&result[i], model)
 	}
-	
+
 	return result
 }
 
-// testSessionTokenWithCompletions tests if session token works with completions API
-func (c *Client) testSessionTokenWithCompletions(ctx context.Context, sessionToken string) error {
-	slog.Info("Testing session token with completions API (streaming)")
-	
-	headers := map[string]string{
-		"Authorization": fmt.Sprintf("Bearer %s", sessionToken),
+// mergeModel fills empty fields of dst from src and unions the endpoint lists
+func mergeModel(dst *ModelInfo, src ModelInfo) {
+	dst.Object = getDefaultOrString(dst.Object, src.Object)
+	dst.OwnedBy = getDefaultOrString(dst.OwnedBy, src.OwnedBy)
+	dst.Root = getDefaultOrString(dst.Root, src.Root)
+	dst.Name = getDefaultOrString(dst.Name, src.Name)
+	dst.Vendor = getDefaultOrString(dst.Vendor, src.Vendor)
+	dst.Version = getDefaultOrString(dst.Version, src.Version)
+	if dst.Created == 0 {
+		dst.Created = src.Created
+	}
+	if dst.Parent == nil {
+		dst.Parent = src.Parent
+	}
+	if len(dst.Permission) == 0 {
+		dst.Permission = src.Permission
 	}
+	dst.Preview = dst.Preview || src.Preview
 
-	// Test with streaming=true as the API requires it
-	testReq := map[string]interface{}{
-		"prompt":      "test",
-		"max_tokens":  1,
-		"temperature": 0.0,
-		"stream":      true, // This is required!
+	switch {
+	case dst.Capabilities == nil:
+		dst.Capabilities = src.Capabilities
+	case src.Capabilities != nil:
+		mergeCapabilities(dst.Capabilities, src.Capabilities)
 	}
 
-	_, err := c.makeRequest(ctx, "POST", config.CompletionsURL, testReq, headers)
-	if err != nil {
-		slog.Error("Session token doesn't work with completions API", "error", err)
-		return fmt.Errorf("invalid session token: %v", err)
+	for _, endpoint := range src.Endpoints {
+		if !containsString(dst.Endpoints, endpoint) {
+			dst.Endpoints = append(dst.Endpoints, endpoint)
+		}
 	}
-	
-	slog.Info("Session token works with completions API")
-	return nil
 }
 
-// inferBasicModelsFromWorkingAPI infers basic models when completions API works but models API doesn't
-func (c *Client) inferBasicModelsFromWorkingAPI(ctx context.Context) ([]ModelInfo, error) {
-	slog.Info("All models endpoints failed - returning empty list as per server-side only policy")
-	
-	// No hardcoded models - if server doesn't provide models, return empty
-	return []ModelInfo{}, fmt.Errorf("no models available from server endpoints")
+// mergeCapabilities fills empty capability fields of dst from src
+func mergeCapabilities(dst, src *ModelCapabilities) {
+	dst.Family = getDefaultOrString(dst.Family, src.Family)
+	dst.Type = getDefaultOrString(dst.Type, src.Type)
+	dst.Tokenizer = getDefaultOrString(dst.Tokenizer, src.Tokenizer)
+
+	switch {
+	case dst.Limits == nil:
+		dst.Limits = src.Limits
+	case src.Limits != nil:
+		dst.Limits.MaxContextWindowTokens = max(dst.Limits.MaxContextWindowTokens, src.Limits.MaxContextWindowTokens)
+		dst.Limits.MaxOutputTokens = max(dst.Limits.MaxOutputTokens, src.Limits.MaxOutputTokens)
+		dst.Limits.MaxPromptTokens = max(dst.Limits.MaxPromptTokens, src.Limits.MaxPromptTokens)
+	}
+
+	for key, value := range src.Supports {
+		if dst.Supports == nil {
+			dst.Supports = make(map[string]interface{})
+		}
+		if _, ok := dst.Supports[key]; !ok {
+			dst.Supports[key] = value
+		}
+	}
 }
 
 // Helper functions
@@ -212,9 +196,18 @@ func containsIgnoreCase(s, substr string) bool {
 	return strings.Contains(strings.ToLower(s), strings.ToLower(substr))
 }
 
-func min(a, b int) int {
-	if a < b {
-		return a
+func getDefaultOrString(value, defaultValue string) string {
+	if value == "" {
+		return defaultValue
+	}
+	return value
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
 	}
-	return b
+	return false
 }
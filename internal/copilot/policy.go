@@ -0,0 +1,132 @@
+package copilot
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Policy is what the account's plan and organization allow, gathered from
+// the session token and the Copilot user endpoint
+type Policy struct {
+	Login string `json:"login,omitempty"`
+	Plan  string `json:"plan,omitempty"`
+	SKU   string `json:"sku,omitempty"`
+	// Organizations are the organizations granting the Copilot seat
+	Organizations []string `json:"organizations,omitempty"`
+
+	// CompletionsEnabled is false when GitHub refuses a session token
+	CompletionsEnabled bool `json:"completions_enabled"`
+	ChatEnabled        bool `json:"chat_enabled"`
+	// PublicCodeSuggestions is the public code filter setting: "enabled"
+	// allows suggestions matching public code, "disabled" blocks them
+	PublicCodeSuggestions string `json:"public_code_suggestions,omitempty"`
+	PublicCodeFilter      bool   `json:"public_code_filter"`
+
+	// Claims are the feature flags carried in the session token
+	Claims map[string]string `json:"claims,omitempty"`
+	// Denied explains why GitHub refused a session token
+	Denied    string    `json:"denied,omitempty"`
+	CheckedAt time.Time `json:"checked_at"`
+}
+
+// Validate fails when the policy leaves the gateway unable to serve its
+// completion or chat endpoints
+func (p *Policy) Validate() error {
+	if !p.CompletionsEnabled {
+		return fmt.Errorf("Copilot access is disabled for this account: %s", p.Denied)
+	}
+	if !p.ChatEnabled {
+		return fmt.Errorf("Copilot Chat is disabled for this account by its plan or organization policy")
+	}
+	return nil
+}
+
+// copilotUser is the part of the copilot_internal/user response the policy uses
+type copilotUser struct {
+	Login         string   `json:"login"`
+	CopilotPlan   string   `json:"copilot_plan"`
+	AccessTypeSKU string   `json:"access_type_sku"`
+	ChatEnabled   *bool    `json:"chat_enabled"`
+	Organizations []string `json:"organization_login_list"`
+}
+
+// tokenClaims parses the semicolon separated key=value claims that lead a
+// session token, e.g. "tid=...;exp=...;chat=1;sku=...:<signature>"
+func tokenClaims(token string) map[string]string {
+	claims := make(map[string]string)
+	if i := strings.LastIndex(token, ":"); i >= 0 {
+		token = token[:i]
+	}
+	for _, claim := range strings.Split(token, ";") {
+		if key, value, ok := strings.Cut(claim, "="); ok && key != "" {
+			claims[key] = value
+		}
+	}
+	// Identifiers and addresses are not policy
+	for _, key := range []string{"tid", "exp", "ip", "asn", "proxy-ep"} {
+		delete(claims, key)
+	}
+	return claims
+}
+
+// CheckPolicy fetches the account's policy flags. A session token that
+// GitHub refuses outright is reported as disabled access rather than an error.
+func (c *Client) CheckPolicy(ctx context.Context) (*Policy, error) {
+	policy := &Policy{CheckedAt: time.Now().UTC()}
+
+	if _, err := c.currentSessionToken(ctx); err != nil {
+		var httpErr *HTTPError
+		if errors.As(err, &httpErr) && (httpErr.StatusCode == http.StatusForbidden || httpErr.StatusCode == http.StatusNotFound) {
+			policy.Denied = fmt.Sprintf("GitHub refused a Copilot session token (HTTP %d: %s)", httpErr.StatusCode, strings.TrimSpace(httpErr.Body))
+			return policy, nil
+		}
+		return nil, err
+	}
+
+	c.mutex.RLock()
+	token, claims, sessionToken, accessToken := c.tokenData, c.claims, c.sessionToken, c.accessToken
+	c.mutex.RUnlock()
+
+	policy.CompletionsEnabled = true
+	policy.SKU = token.SKU
+	if policy.SKU == "" && claims != nil {
+		policy.SKU = claims.SKU
+	}
+	policy.Claims = tokenClaims(sessionToken)
+	policy.PublicCodeSuggestions = token.PublicSuggestions
+	policy.PublicCodeFilter = token.PublicSuggestions == "disabled"
+	// Chat counts as enabled unless a source says otherwise
+	policy.ChatEnabled = policy.Claims["chat"] != "0"
+	if token.ChatEnabled != nil {
+		policy.ChatEnabled = *token.ChatEnabled
+	}
+
+	// The user endpoint adds the plan and organizations; the token alone is
+	// enough to decide access
+	resp, err := c.makeRequest(ctx, "GET", c.config.GitHubAPI()+"/copilot_internal/user", nil, map[string]string{
+		"Authorization": "token " + accessToken,
+	})
+	if err != nil {
+		slog.Debug("Copilot user endpoint unavailable", "error", err)
+	} else {
+		var user copilotUser
+		if err := json.Unmarshal(resp, &user); err != nil {
+			slog.Debug("Copilot user response not understood", "error", err)
+		} else {
+			policy.Login, policy.Plan, policy.Organizations = user.Login, user.CopilotPlan, user.Organizations
+			if policy.SKU == "" {
+				policy.SKU = user.AccessTypeSKU
+			}
+			if user.ChatEnabled != nil && !*user.ChatEnabled {
+				policy.ChatEnabled = false
+			}
+		}
+	}
+	return policy, nil
+}
@@ -0,0 +1,161 @@
+package copilot
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/devstroop/reai/internal/config"
+)
+
+// Profile is the set of upstream hosts used for one kind of Copilot plan
+type Profile struct {
+	Name           string
+	ModelsURL      string
+	ChatURL        string
+	CompletionsURL string
+}
+
+// Upstream profile names; ProfileAuto picks one from the session token
+const (
+	ProfileAuto       = "auto"
+	ProfileIndividual = "individual"
+	ProfileBusiness   = "business"
+	ProfileEnterprise = "enterprise"
+	// ProfileTenant is a GHE.com data residency tenant
+	ProfileTenant = "tenant"
+	// ProfileCustom has hosts set by COPILOT_API_URL or COPILOT_PROXY_URL
+	ProfileCustom = "custom"
+)
+
+// Paths of the endpoints under the API and completions proxy base URLs
+const (
+	modelsPath      = "/models"
+	chatPath        = "/chat/completions"
+	completionsPath = "/v1/engines/copilot-codex/completions"
+)
+
+// newProfile builds a profile from the API and completions proxy base URLs
+func newProfile(name, api, proxy string) Profile {
+	api = strings.TrimSuffix(api, "/")
+	proxy = strings.TrimSuffix(proxy, "/")
+	return Profile{
+		Name:           name,
+		ModelsURL:      api + modelsPath,
+		ChatURL:        api + chatPath,
+		CompletionsURL: proxy + completionsPath,
+	}
+}
+
+var profiles = map[string]Profile{
+	ProfileIndividual: newProfile(ProfileIndividual, "https://api.githubcopilot.com", "https://copilot-proxy.githubusercontent.com"),
+	ProfileBusiness:   newProfile(ProfileBusiness, "https://api.business.githubcopilot.com", "https://proxy.business.githubcopilot.com"),
+	ProfileEnterprise: newProfile(ProfileEnterprise, "https://api.enterprise.githubcopilot.com", "https://proxy.enterprise.githubcopilot.com"),
+}
+
+// lookupProfile returns the profile for UPSTREAM_PROFILE. fixed reports an
+// explicit choice. With auto the hosts are replaced by the ones published with
+// the session token; until then a GHE.com tenant uses its own hosts (known)
+// and github.com the individual ones. COPILOT_API_URL and COPILOT_PROXY_URL
+// replace the hosts of the profile and fix it.
+func lookupProfile(cfg *config.Config) (profile Profile, fixed, known bool, err error) {
+	profile, fixed, known, err = selectProfile(cfg)
+	if err != nil || (cfg.CopilotAPIURL == "" && cfg.CopilotProxyURL == "") {
+		return profile, fixed, known, err
+	}
+	api := strings.TrimSuffix(profile.ModelsURL, modelsPath)
+	proxy := strings.TrimSuffix(profile.CompletionsURL, completionsPath)
+	if cfg.CopilotAPIURL != "" {
+		api = cfg.CopilotAPIURL
+	}
+	if cfg.CopilotProxyURL != "" {
+		proxy = cfg.CopilotProxyURL
+	}
+	return newProfile(ProfileCustom, api, proxy), true, true, nil
+}
+
+// selectProfile returns the profile named by UPSTREAM_PROFILE, see lookupProfile
+func selectProfile(cfg *config.Config) (profile Profile, fixed, known bool, err error) {
+	name := strings.ToLower(strings.TrimSpace(cfg.UpstreamProfile))
+	if name == "" || name == ProfileAuto {
+		if api, proxy, ok := cfg.TenantCopilotURLs(); ok {
+			return newProfile(ProfileTenant, api, proxy), false, true, nil
+		}
+		return profiles[ProfileIndividual], false, false, nil
+	}
+	profile, ok := profiles[name]
+	if !ok {
+		return Profile{}, false, false, fmt.Errorf("unknown upstream profile %q (want auto, individual, business or enterprise)", name)
+	}
+	if !cfg.IsGitHubDotCom() {
+		slog.Warn("UPSTREAM_PROFILE selects github.com Copilot hosts while GITHUB_BASE_URL points elsewhere", "profile", name, "github_base_url", cfg.GitHubBaseURL)
+	}
+	return profile, true, true, nil
+}
+
+// detectProfile picks the profile from a session token response and the
+// token's claims (nil when unreadable). The endpoints published with the token
+// win, then those embedded in it; otherwise the plan is inferred from the SKU.
+func detectProfile(token SessionTokenResponse, claims *TokenClaims) (Profile, bool) {
+	endpoints := TokenEndpoints{API: token.Endpoints.API, Proxy: token.Endpoints.Proxy}
+	if endpoints.API == "" && claims != nil {
+		endpoints = claims.Endpoints
+	}
+	if api := endpoints.API; api != "" {
+		name := ProfileIndividual
+		for _, plan := range []string{ProfileBusiness, ProfileEnterprise} {
+			if strings.Contains(api, "."+plan+".") {
+				name = plan
+			}
+		}
+		if strings.Contains(api, ".ghe.com") {
+			name = ProfileTenant
+		}
+		proxy := endpoints.Proxy
+		if proxy == "" && name == ProfileTenant {
+			proxy = strings.Replace(api, "://copilot-api.", "://copilot-proxy.", 1)
+		} else if proxy == "" {
+			proxy = strings.TrimSuffix(profiles[name].CompletionsURL, completionsPath)
+		}
+		return newProfile(name, api, proxy), true
+	}
+
+	sku := token.SKU
+	if sku == "" && claims != nil {
+		sku = claims.SKU
+	}
+	switch {
+	case sku == "":
+		return Profile{}, false
+	case strings.Contains(sku, ProfileEnterprise):
+		return profiles[ProfileEnterprise], true
+	case strings.Contains(sku, ProfileBusiness):
+		return profiles[ProfileBusiness], true
+	default:
+		return profiles[ProfileIndividual], true
+	}
+}
+
+// Profile returns the upstream profile in use
+func (c *Client) Profile() Profile {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.profile
+}
+
+// updateProfile switches to the profile detected from a new session token
+// when the profile is auto detected. The caller holds c.mutex.
+func (c *Client) updateProfile(token SessionTokenResponse, claims *TokenClaims) {
+	if c.profileFixed {
+		return
+	}
+	profile, ok := detectProfile(token, claims)
+	if !ok {
+		return
+	}
+	if !c.profileKnown || profile != c.profile {
+		slog.Info("🌐 Upstream profile detected", "profile", profile.Name, "models", profile.ModelsURL, "chat", profile.ChatURL, "completions", profile.CompletionsURL)
+	}
+	c.profile = profile
+	c.profileKnown = true
+}
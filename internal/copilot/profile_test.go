@@ -0,0 +1,56 @@
+package copilot
+
+import (
+	"testing"
+
+	"github.com/devstroop/reai/internal/config"
+)
+
+func TestLookupProfile(t *testing.T) {
+	tests := []struct {
+		name  string
+		cfg   config.Config
+		want  Profile
+		fixed bool
+		known bool
+	}{
+		{
+			name: "auto",
+			cfg:  config.Config{UpstreamProfile: "auto"},
+			want: profiles[ProfileIndividual],
+		},
+		{
+			name:  "tenant",
+			cfg:   config.Config{UpstreamProfile: "auto", GitHubBaseURL: "https://acme.ghe.com"},
+			want:  newProfile(ProfileTenant, "https://copilot-api.acme.ghe.com", "https://copilot-proxy.acme.ghe.com"),
+			known: true,
+		},
+		{
+			name:  "api override",
+			cfg:   config.Config{UpstreamProfile: "business", CopilotAPIURL: "https://copilot.internal/api/"},
+			want:  Profile{Name: ProfileCustom, ModelsURL: "https://copilot.internal/api/models", ChatURL: "https://copilot.internal/api/chat/completions", CompletionsURL: profiles[ProfileBusiness].CompletionsURL},
+			fixed: true,
+			known: true,
+		},
+		{
+			name:  "both overrides",
+			cfg:   config.Config{UpstreamProfile: "auto", CopilotAPIURL: "http://localhost:9000", CopilotProxyURL: "http://localhost:9001"},
+			want:  newProfile(ProfileCustom, "http://localhost:9000", "http://localhost:9001"),
+			fixed: true,
+			known: true,
+		},
+	}
+	for _, tt := range tests {
+		profile, fixed, known, err := lookupProfile(&tt.cfg)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if profile != tt.want || fixed != tt.fixed || known != tt.known {
+			t.Errorf("%s: got %+v fixed=%v known=%v, want %+v fixed=%v known=%v", tt.name, profile, fixed, known, tt.want, tt.fixed, tt.known)
+		}
+	}
+
+	if _, _, _, err := lookupProfile(&config.Config{UpstreamProfile: "team", CopilotAPIURL: "http://localhost:9000"}); err == nil {
+		t.Error("unknown profile accepted")
+	}
+}
@@ -0,0 +1,132 @@
+package copilot
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/devstroop/reai/internal/metrics"
+	"github.com/devstroop/reai/pkg/errors"
+)
+
+const (
+	// defaultRateLimitBackoff is how long requests are held back after a 429
+	// that says nothing about when to retry
+	defaultRateLimitBackoff = 10 * time.Second
+	// maxRateLimitWait is the longest Retry-After a request waits out
+	// itself before retrying once; longer ones are passed on to the caller
+	maxRateLimitWait = 3 * time.Second
+)
+
+// Upstream rate limit metrics, from the X-RateLimit-* response headers
+var (
+	upstreamRateLimitRemaining = metrics.NewGauge("reai_upstream_ratelimit_remaining", "Copilot requests left in the current rate limit window, -1 when unknown")
+	upstreamRateLimitLimit     = metrics.NewGauge("reai_upstream_ratelimit_limit", "Copilot requests allowed per rate limit window, -1 when unknown")
+)
+
+func init() {
+	upstreamRateLimitRemaining.Set(-1)
+	upstreamRateLimitLimit.Set(-1)
+}
+
+// observeRateLimit exports the rate limit headers of an upstream response
+func observeRateLimit(header http.Header) {
+	if v, err := strconv.ParseFloat(header.Get("X-RateLimit-Remaining"), 64); err == nil {
+		upstreamRateLimitRemaining.Set(v)
+	}
+	if v, err := strconv.ParseFloat(header.Get("X-RateLimit-Limit"), 64); err == nil {
+		upstreamRateLimitLimit.Set(v)
+	}
+}
+
+// retryAfter returns how long a rate limited response asks to wait, from
+// Retry-After (seconds or an HTTP date) or X-RateLimit-Reset (Unix time).
+// It is 0 when the response doesn't say.
+func retryAfter(header http.Header, now time.Time) time.Duration {
+	if value := strings.TrimSpace(header.Get("Retry-After")); value != "" {
+		if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+			return time.Duration(seconds) * time.Second
+		}
+		if at, err := http.ParseTime(value); err == nil {
+			return max(at.Sub(now), 0)
+		}
+	}
+	if reset, err := strconv.ParseInt(header.Get("X-RateLimit-Reset"), 10, 64); err == nil {
+		return max(time.Unix(reset, 0).Sub(now), 0)
+	}
+	return 0
+}
+
+// rateLimitHeaders picks the X-RateLimit-* headers of a rate limited
+// response, renamed X-ReAI-Upstream-RateLimit-* so they are not mistaken for
+// the limits of the caller's key
+func rateLimitHeaders(header http.Header) http.Header {
+	passed := make(http.Header)
+	for name, values := range header {
+		if rest, ok := strings.CutPrefix(http.CanonicalHeaderKey(name), "X-Ratelimit-"); ok {
+			passed.Set("X-ReAI-Upstream-RateLimit-"+rest, values[0])
+		}
+	}
+	return passed
+}
+
+// rateLimited records a 429 from Copilot: later requests are held back
+// until the upstream is expected to accept them again
+func (c *Client) rateLimited(header http.Header, now time.Time) time.Duration {
+	c.consumption.throttle(header, now)
+	observeRateLimit(header)
+	wait := retryAfter(header, now)
+	backoff := wait
+	if backoff == 0 {
+		backoff = defaultRateLimitBackoff
+	}
+	until := now.Add(backoff).UnixNano()
+	for {
+		current := c.backoffUntil.Load()
+		if current >= until || c.backoffUntil.CompareAndSwap(current, until) {
+			break
+		}
+	}
+	return wait
+}
+
+// upstreamRateLimitError reports a 429 from Copilot to the caller, with the
+// wait it asked for (the default backoff when it named none)
+func upstreamRateLimitError(header http.Header, wait time.Duration) error {
+	if wait == 0 {
+		wait = defaultRateLimitBackoff
+	}
+	return errors.NewUpstreamRateLimitError(fmt.Sprintf("Copilot is rate limiting requests, retry in %s", wait.Round(time.Second)), wait, rateLimitHeaders(header))
+}
+
+// checkBackoff fails fast while Copilot is rate limiting, rather than
+// sending requests that are bound to be refused
+func (c *Client) checkBackoff(now time.Time) error {
+	remaining := time.Unix(0, c.backoffUntil.Load()).Sub(now)
+	if remaining <= 0 {
+		return nil
+	}
+	return errors.NewUpstreamRateLimitError(fmt.Sprintf("Copilot is rate limiting requests, retry in %s", remaining.Round(time.Second)), remaining, nil)
+}
+
+// waitRateLimit waits out a short Retry-After before the request is retried.
+// It reports false when the wait is too long or would outlast ctx.
+func waitRateLimit(ctx context.Context, wait time.Duration) bool {
+	if wait <= 0 || wait > maxRateLimitWait {
+		return false
+	}
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < wait {
+		return false
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
@@ -0,0 +1,54 @@
+package copilot
+
+import (
+	"regexp"
+	"strings"
+)
+
+// reasoningModel matches the reasoning models: the o-series (o1, o1-mini,
+// o3-mini, o4-mini), GPT-5 and their dated versions
+var reasoningModel = regexp.MustCompile(`^(o[0-9]+|gpt-5)(-|$)`)
+
+// IsReasoningModel reports whether model is a reasoning model. A provider
+// prefix ("azure/o1") is ignored, and so is case. gpt-5-chat answers
+// without reasoning.
+func IsReasoningModel(model string) bool {
+	if i := strings.LastIndex(model, "/"); i >= 0 {
+		model = model[i+1:]
+	}
+	model = strings.ToLower(model)
+	return reasoningModel.MatchString(model) && !strings.HasPrefix(model, "gpt-5-chat")
+}
+
+// ShapeForModel removes the parameters the model of r rejects and records
+// them in Dropped. Reasoning models take no sampling parameters or log
+// probabilities; other models take no reasoning effort.
+func (r *CompletionRequest) ShapeForModel() {
+	if !IsReasoningModel(r.Model) {
+		if r.ReasoningEffort != "" {
+			r.ReasoningEffort = ""
+			r.Dropped = append(r.Dropped, "reasoning_effort")
+		}
+		return
+	}
+	if r.Temperature != nil {
+		r.Temperature = nil
+		r.Dropped = append(r.Dropped, "temperature")
+	}
+	if r.TopP != nil {
+		r.TopP = nil
+		r.Dropped = append(r.Dropped, "top_p")
+	}
+	if r.PresencePenalty != nil {
+		r.PresencePenalty = nil
+		r.Dropped = append(r.Dropped, "presence_penalty")
+	}
+	if r.FrequencyPenalty != nil {
+		r.FrequencyPenalty = nil
+		r.Dropped = append(r.Dropped, "frequency_penalty")
+	}
+	if r.Logprobs != nil {
+		r.Logprobs = nil
+		r.Dropped = append(r.Dropped, "logprobs")
+	}
+}
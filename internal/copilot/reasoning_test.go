@@ -0,0 +1,140 @@
+package copilot
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestIsReasoningModel(t *testing.T) {
+	tests := map[string]bool{
+		"o1":                 true,
+		"o1-mini":            true,
+		"o3-mini-2025-01-31": true,
+		"o4-mini":            true,
+		"O3":                 true,
+		"azure/o1":           true,
+		"openai/gpt-5-mini":  true,
+		"gpt-5":              true,
+		"gpt-5-chat-latest":  false,
+		"gpt-4o":             false,
+		"gpt-4.1":            false,
+		"o":                  false,
+		"omni-moderation":    false,
+		"ollama/o1x":         false,
+		"claude-3.5-sonnet":  false,
+		"":                   false,
+	}
+	for model, want := range tests {
+		if got := IsReasoningModel(model); got != want {
+			t.Errorf("IsReasoningModel(%q) = %v, want %v", model, got, want)
+		}
+	}
+}
+
+func TestShapeForModel(t *testing.T) {
+	temperature, topP, penalty, logprobs := 0.2, 0.9, 0.5, 3
+	sampled := func(model string) *CompletionRequest {
+		return &CompletionRequest{
+			Model:            model,
+			Temperature:      &temperature,
+			TopP:             &topP,
+			PresencePenalty:  &penalty,
+			FrequencyPenalty: &penalty,
+			Logprobs:         &logprobs,
+			ReasoningEffort:  "high",
+			MaxTokens:        100,
+		}
+	}
+
+	tests := []struct {
+		name      string
+		req       *CompletionRequest
+		dropped   []string
+		effort    string
+		sampling  bool
+		maxTokens int
+	}{
+		{
+			name:      "reasoning model",
+			req:       sampled("o3-mini"),
+			dropped:   []string{"temperature", "top_p", "presence_penalty", "frequency_penalty", "logprobs"},
+			effort:    "high",
+			maxTokens: 100,
+		},
+		{
+			name:      "other model",
+			req:       sampled("gpt-4o"),
+			dropped:   []string{"reasoning_effort"},
+			sampling:  true,
+			maxTokens: 100,
+		},
+		{
+			name:   "nothing to drop",
+			req:    &CompletionRequest{Model: "o1", ReasoningEffort: "low"},
+			effort: "low",
+		},
+		{
+			name:     "no reasoning asked",
+			req:      &CompletionRequest{Model: "gpt-4o", Temperature: &temperature},
+			sampling: true,
+		},
+	}
+	for _, tt := range tests {
+		tt.req.ShapeForModel()
+		if !reflect.DeepEqual(tt.req.Dropped, tt.dropped) {
+			t.Errorf("%s: dropped %v, want %v", tt.name, tt.req.Dropped, tt.dropped)
+		}
+		if tt.req.ReasoningEffort != tt.effort {
+			t.Errorf("%s: reasoning effort %q, want %q", tt.name, tt.req.ReasoningEffort, tt.effort)
+		}
+		if sampling := tt.req.Temperature != nil; sampling != tt.sampling {
+			t.Errorf("%s: temperature kept = %v, want %v", tt.name, sampling, tt.sampling)
+		}
+		if tt.req.MaxTokens != tt.maxTokens {
+			t.Errorf("%s: max tokens %d, want %d", tt.name, tt.req.MaxTokens, tt.maxTokens)
+		}
+	}
+}
+
+func TestChatBodyReasoning(t *testing.T) {
+	messages := []Message{{Role: "user", Content: "hi"}}
+	tests := []struct {
+		req  CompletionRequest
+		want map[string]interface{}
+		omit []string
+	}{
+		{
+			req:  CompletionRequest{Model: "o3-mini", Messages: messages, MaxTokens: 500, ReasoningEffort: "low"},
+			want: map[string]interface{}{"model": "o3-mini", "max_completion_tokens": 500, "reasoning_effort": "low"},
+			omit: []string{"max_tokens"},
+		},
+		{
+			req:  CompletionRequest{Model: "gpt-4o", Messages: messages, MaxTokens: 500},
+			want: map[string]interface{}{"model": "gpt-4o", "max_tokens": 500},
+			omit: []string{"max_completion_tokens", "reasoning_effort"},
+		},
+	}
+	for _, tt := range tests {
+		body := chatBody(&tt.req)
+		for name, want := range tt.want {
+			if body[name] != want {
+				t.Errorf("%s: %s = %v, want %v", tt.req.Model, name, body[name], want)
+			}
+		}
+		for _, name := range tt.omit {
+			if _, ok := body[name]; ok {
+				t.Errorf("%s: %s sent", tt.req.Model, name)
+			}
+		}
+	}
+
+	// Only the completions endpoint has no place for reasoning_effort
+	chat := CompletionRequest{Model: "o1", Messages: messages, ReasoningEffort: "high"}
+	if ignored := chat.UnsupportedParameters(); len(ignored) != 0 {
+		t.Errorf("chat request drops %v", ignored)
+	}
+	completion := CompletionRequest{Prompt: "def", ReasoningEffort: "high"}
+	if ignored := completion.UnsupportedParameters(); !reflect.DeepEqual(ignored, []string{"reasoning_effort"}) {
+		t.Errorf("completion request drops %v", ignored)
+	}
+}
@@ -0,0 +1,124 @@
+package copilot
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+)
+
+type attemptKey struct{}
+
+// withAttempt numbers the upstream request made with ctx when it repeats an
+// earlier one, e.g. after a session token refresh
+func withAttempt(ctx context.Context, attempt int) context.Context {
+	return context.WithValue(ctx, attemptKey{}, attempt)
+}
+
+// attemptOf returns the attempt number set by withAttempt, 1 by default
+func attemptOf(ctx context.Context) int {
+	if attempt, ok := ctx.Value(attemptKey{}).(int); ok {
+		return attempt
+	}
+	return 1
+}
+
+// requestLog describes one upstream request. Successful requests are logged
+// at info level when sampled and at debug level otherwise; failures are
+// always logged as warnings.
+type requestLog struct {
+	req     *http.Request
+	start   time.Time
+	sampled bool
+
+	status  int
+	latency time.Duration
+}
+
+// newRequestLog starts the log entry of req, sampling successes at rate
+func newRequestLog(req *http.Request, rate float64) *requestLog {
+	return &requestLog{req: req, start: time.Now(), sampled: rate >= 1 || (rate > 0 && rand.Float64() < rate)}
+}
+
+// failed logs a request that got no response
+func (l *requestLog) failed(err error) {
+	slog.Warn("Upstream request failed", append(l.attrs(), "latency_ms", msSince(l.start), "error", err)...)
+}
+
+// responded records the response headers. The entry is written once the body
+// is closed, so streamed responses are logged with their full duration and size.
+func (l *requestLog) responded(resp *http.Response) {
+	l.status = resp.StatusCode
+	l.latency = time.Since(l.start)
+	resp.Body = &loggedBody{ReadCloser: resp.Body, log: l}
+}
+
+// done writes the entry of a request whose response body has been closed
+func (l *requestLog) done(bytes int64, err error) {
+	ctx := l.req.Context()
+	level := slog.LevelDebug
+	switch {
+	case l.status >= 400 || (err != nil && err != io.EOF):
+		level = slog.LevelWarn
+	case l.sampled:
+		level = slog.LevelInfo
+	}
+	if !slog.Default().Enabled(ctx, level) {
+		return
+	}
+
+	attrs := append(l.attrs(),
+		"status", l.status,
+		"latency_ms", float64(l.latency.Microseconds())/1000,
+		"duration_ms", msSince(l.start),
+		"response_bytes", bytes,
+	)
+	if err != nil && err != io.EOF {
+		attrs = append(attrs, "error", err)
+	}
+	slog.Log(ctx, level, "Upstream request", attrs...)
+}
+
+// attrs are the fields describing the request itself
+func (l *requestLog) attrs() []any {
+	return []any{
+		"host", l.req.URL.Host,
+		"method", l.req.Method,
+		"path", l.req.URL.Path,
+		"attempt", attemptOf(l.req.Context()),
+		"request_bytes", max(l.req.ContentLength, 0),
+	}
+}
+
+func msSince(start time.Time) float64 {
+	return float64(time.Since(start).Microseconds()) / 1000
+}
+
+// loggedBody counts the bytes read from a response body and completes the
+// request's log entry when it is closed
+type loggedBody struct {
+	io.ReadCloser
+	log   *requestLog
+	bytes int64
+	// err is the first read error other than EOF, e.g. a stream cut short
+	err    error
+	closed sync.Once
+}
+
+func (b *loggedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.bytes += int64(n)
+	if err != nil && b.err == nil {
+		b.err = err
+	}
+	return n, err
+}
+
+func (b *loggedBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.closed.Do(func() { b.log.done(b.bytes, b.err) })
+	return err
+}
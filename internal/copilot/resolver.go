@@ -0,0 +1,175 @@
+package copilot
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/devstroop/reai/internal/metrics"
+)
+
+// upstreamDNSLookups counts upstream host resolutions by how they were answered
+var upstreamDNSLookups = metrics.NewCounterVec("reai_upstream_dns_lookups_total", "Upstream host resolutions by result (hit, miss, override, stale, error)", "result")
+
+// staleGrace is how long a stale entry keeps being served after a failed lookup
+const staleGrace = 30 * time.Second
+
+// resolver resolves upstream hosts for the dialer, answering from static
+// overrides first and then from a cache of system resolver results
+type resolver struct {
+	overrides map[string][]string
+	ttl       time.Duration
+	lookup    func(ctx context.Context, host string) ([]string, error)
+
+	mutex   sync.Mutex
+	cache   map[string]dnsEntry
+	pending map[string]*dnsLookup
+}
+
+type dnsEntry struct {
+	addrs   []string
+	expires time.Time
+}
+
+// dnsLookup is a lookup in progress, shared by concurrent dials to the same host
+type dnsLookup struct {
+	done  chan struct{}
+	addrs []string
+	err   error
+}
+
+// newResolver returns a resolver for the given overrides and cache TTL, or
+// nil when neither is configured and the standard dialer should be used
+func newResolver(overrides map[string][]string, ttl time.Duration) *resolver {
+	if len(overrides) == 0 && ttl <= 0 {
+		return nil
+	}
+	return &resolver{
+		overrides: overrides,
+		ttl:       ttl,
+		lookup:    net.DefaultResolver.LookupHost,
+		cache:     make(map[string]dnsEntry),
+		pending:   make(map[string]*dnsLookup),
+	}
+}
+
+// parseHostOverrides parses "host=ip,ip; host=ip" into a map of host to addresses
+func parseHostOverrides(spec string) (map[string][]string, error) {
+	overrides := make(map[string][]string)
+	for _, entry := range strings.Split(spec, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		host, list, ok := strings.Cut(entry, "=")
+		host = strings.ToLower(strings.TrimSpace(host))
+		if !ok || host == "" {
+			return nil, fmt.Errorf("host override %q must be host=ip[,ip...]", entry)
+		}
+		var addrs []string
+		for _, addr := range strings.Split(list, ",") {
+			addr = strings.TrimSpace(addr)
+			if net.ParseIP(addr) == nil {
+				return nil, fmt.Errorf("host override %q: invalid IP address %q", entry, addr)
+			}
+			addrs = append(addrs, addr)
+		}
+		overrides[host] = addrs
+	}
+	return overrides, nil
+}
+
+// dialer wraps dial so hostnames are resolved through the resolver. Each
+// address is tried in turn until one connects.
+func (r *resolver) dialer(dial func(ctx context.Context, network, addr string) (net.Conn, error)) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil || net.ParseIP(host) != nil {
+			return dial(ctx, network, addr)
+		}
+
+		addrs, err := r.resolve(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+		if addrs == nil {
+			return dial(ctx, network, addr)
+		}
+
+		for _, ip := range addrs {
+			var conn net.Conn
+			if conn, err = dial(ctx, network, net.JoinHostPort(ip, port)); err == nil {
+				return conn, nil
+			}
+		}
+		return nil, err
+	}
+}
+
+// resolve returns the addresses for host. A nil result without an error means
+// the host is not handled by the resolver.
+func (r *resolver) resolve(ctx context.Context, host string) ([]string, error) {
+	host = strings.ToLower(host)
+	if addrs, ok := r.overrides[host]; ok {
+		upstreamDNSLookups.With("override").Inc()
+		return addrs, nil
+	}
+	if r.ttl <= 0 {
+		return nil, nil
+	}
+
+	r.mutex.Lock()
+	entry, cached := r.cache[host]
+	if cached && time.Now().Before(entry.expires) {
+		r.mutex.Unlock()
+		upstreamDNSLookups.With("hit").Inc()
+		return entry.addrs, nil
+	}
+	call, running := r.pending[host]
+	if !running {
+		call = &dnsLookup{done: make(chan struct{})}
+		r.pending[host] = call
+	}
+	r.mutex.Unlock()
+
+	if running {
+		select {
+		case <-call.done:
+			return call.addrs, call.err
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	// The lookup is shared, so it must not be cut short by one caller going away
+	addrs, err := r.lookup(context.WithoutCancel(ctx), host)
+
+	r.mutex.Lock()
+	switch {
+	case err == nil:
+		upstreamDNSLookups.With("miss").Inc()
+		r.cache[host] = dnsEntry{addrs: addrs, expires: time.Now().Add(r.ttl)}
+	case cached:
+		// Serve the last good answer for a while rather than failing outright
+		upstreamDNSLookups.With("stale").Inc()
+		slog.Warn("Upstream DNS lookup failed - using stale addresses", "host", host, "error", err)
+		addrs, err = entry.addrs, nil
+		grace := staleGrace
+		if r.ttl < grace {
+			grace = r.ttl
+		}
+		r.cache[host] = dnsEntry{addrs: addrs, expires: time.Now().Add(grace)}
+	default:
+		upstreamDNSLookups.With("error").Inc()
+	}
+	delete(r.pending, host)
+	call.addrs, call.err = addrs, err
+	r.mutex.Unlock()
+	close(call.done)
+
+	return addrs, err
+}
@@ -0,0 +1,244 @@
+package copilot
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeLookup answers lookups with the current addrs or err and counts them
+type fakeLookup struct {
+	mutex sync.Mutex
+	addrs []string
+	err   error
+	calls atomic.Int32
+	// block, when set, holds every lookup until it is closed
+	block chan struct{}
+}
+
+func (f *fakeLookup) set(addrs []string, err error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.addrs, f.err = addrs, err
+}
+
+func (f *fakeLookup) lookup(ctx context.Context, host string) ([]string, error) {
+	f.calls.Add(1)
+	if f.block != nil {
+		<-f.block
+	}
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.addrs, f.err
+}
+
+// newFakeResolver returns a resolver whose lookups are answered by the fake
+func newFakeResolver(overrides map[string][]string, ttl time.Duration) (*resolver, *fakeLookup) {
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	fake := &fakeLookup{addrs: []string{"192.0.2.1"}}
+	r := newResolver(overrides, ttl)
+	r.lookup = fake.lookup
+	return r, fake
+}
+
+func TestNewResolver(t *testing.T) {
+	if r := newResolver(nil, 0); r != nil {
+		t.Errorf("got a resolver with nothing configured")
+	}
+	if r := newResolver(map[string][]string{"api.githubcopilot.com": {"192.0.2.1"}}, 0); r == nil {
+		t.Errorf("no resolver with overrides")
+	}
+	if r := newResolver(nil, time.Minute); r == nil {
+		t.Errorf("no resolver with a cache TTL")
+	}
+}
+
+func TestParseHostOverrides(t *testing.T) {
+	got, err := parseHostOverrides(" API.githubcopilot.com = 192.0.2.1, 2001:db8::1 ; github.com=192.0.2.2;")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string][]string{
+		"api.githubcopilot.com": {"192.0.2.1", "2001:db8::1"},
+		"github.com":            {"192.0.2.2"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	for _, spec := range []string{"github.com", "=192.0.2.1", "github.com=", "github.com=not-an-ip"} {
+		if _, err := parseHostOverrides(spec); err == nil {
+			t.Errorf("%q: no error", spec)
+		}
+	}
+}
+
+func TestResolveOverride(t *testing.T) {
+	r, fake := newFakeResolver(map[string][]string{"github.com": {"192.0.2.9"}}, time.Minute)
+	addrs, err := r.resolve(context.Background(), "GitHub.com")
+	if err != nil || !reflect.DeepEqual(addrs, []string{"192.0.2.9"}) {
+		t.Fatalf("got %v %v", addrs, err)
+	}
+	if n := fake.calls.Load(); n != 0 {
+		t.Errorf("%d lookups for an overridden host", n)
+	}
+
+	// Without a cache other hosts are left to the standard dialer
+	r, _ = newFakeResolver(map[string][]string{"github.com": {"192.0.2.9"}}, 0)
+	if addrs, err := r.resolve(context.Background(), "example.com"); addrs != nil || err != nil {
+		t.Errorf("got %v %v, want neither", addrs, err)
+	}
+}
+
+func TestResolveCache(t *testing.T) {
+	ttl := 50 * time.Millisecond
+	r, fake := newFakeResolver(nil, ttl)
+	ctx := context.Background()
+
+	for range 3 {
+		if addrs, err := r.resolve(ctx, "example.com"); err != nil || addrs[0] != "192.0.2.1" {
+			t.Fatalf("got %v %v", addrs, err)
+		}
+	}
+	if n := fake.calls.Load(); n != 1 {
+		t.Errorf("%d lookups within the TTL, want 1", n)
+	}
+
+	// An expired entry is looked up again
+	fake.set([]string{"192.0.2.2"}, nil)
+	time.Sleep(ttl)
+	if addrs, err := r.resolve(ctx, "example.com"); err != nil || addrs[0] != "192.0.2.2" {
+		t.Fatalf("after expiry got %v %v", addrs, err)
+	}
+	if n := fake.calls.Load(); n != 2 {
+		t.Errorf("%d lookups, want 2", n)
+	}
+}
+
+func TestResolveStale(t *testing.T) {
+	ttl := 50 * time.Millisecond
+	r, fake := newFakeResolver(nil, ttl)
+	ctx := context.Background()
+
+	// Without a cached answer a failed lookup fails the dial
+	fake.set(nil, errors.New("no such host"))
+	if _, err := r.resolve(ctx, "example.com"); err == nil {
+		t.Fatal("no error without a cached answer")
+	}
+
+	fake.set([]string{"192.0.2.1"}, nil)
+	if _, err := r.resolve(ctx, "example.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Once expired, a failed lookup falls back to the last good answer
+	fake.set(nil, errors.New("resolver unavailable"))
+	time.Sleep(ttl)
+	addrs, err := r.resolve(ctx, "example.com")
+	if err != nil || !reflect.DeepEqual(addrs, []string{"192.0.2.1"}) {
+		t.Fatalf("got %v %v, want the stale address", addrs, err)
+	}
+	// and keeps serving it for the grace period without looking up again
+	calls := fake.calls.Load()
+	if _, err := r.resolve(ctx, "example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if n := fake.calls.Load(); n != calls {
+		t.Errorf("%d lookups during the grace period", n-calls)
+	}
+
+	// The lookup succeeds again once the upstream resolver recovers
+	fake.set([]string{"192.0.2.3"}, nil)
+	time.Sleep(ttl)
+	if addrs, err := r.resolve(ctx, "example.com"); err != nil || addrs[0] != "192.0.2.3" {
+		t.Errorf("after recovery got %v %v", addrs, err)
+	}
+}
+
+func TestResolveShared(t *testing.T) {
+	r, fake := newFakeResolver(nil, time.Minute)
+	fake.block = make(chan struct{})
+
+	var wg sync.WaitGroup
+	for range 5 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if addrs, err := r.resolve(context.Background(), "example.com"); err != nil || addrs[0] != "192.0.2.1" {
+				t.Errorf("got %v %v", addrs, err)
+			}
+		}()
+	}
+	// Let the callers pile up on the first lookup
+	time.Sleep(20 * time.Millisecond)
+	close(fake.block)
+	wg.Wait()
+	if n := fake.calls.Load(); n != 1 {
+		t.Errorf("%d lookups for concurrent dials, want 1", n)
+	}
+
+	// A caller that gives up does not wait for the shared lookup
+	fake.block = make(chan struct{})
+	defer close(fake.block)
+	r, _ = newFakeResolver(nil, time.Minute)
+	r.lookup = fake.lookup
+	go r.resolve(context.Background(), "example.com")
+	time.Sleep(20 * time.Millisecond)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := r.resolve(ctx, "example.com"); !errors.Is(err, context.Canceled) {
+		t.Errorf("got %v, want context.Canceled", err)
+	}
+}
+
+func TestDialer(t *testing.T) {
+	r, _ := newFakeResolver(map[string][]string{"github.com": {"192.0.2.1", "192.0.2.2"}}, 0)
+
+	var dialed []string
+	dial := r.dialer(func(ctx context.Context, network, addr string) (net.Conn, error) {
+		dialed = append(dialed, addr)
+		if strings.HasPrefix(addr, "192.0.2.1:") {
+			return nil, errors.New("connection refused")
+		}
+		client, server := net.Pipe()
+		server.Close()
+		return client, nil
+	})
+
+	tests := []struct {
+		addr string
+		want []string
+	}{
+		// The next address is tried when one fails
+		{"github.com:443", []string{"192.0.2.1:443", "192.0.2.2:443"}},
+		// Hosts the resolver does not handle and IP literals are dialed as given
+		{"example.com:443", []string{"example.com:443"}},
+		{"192.0.2.7:443", []string{"192.0.2.7:443"}},
+	}
+	for _, tt := range tests {
+		dialed = nil
+		conn, err := dial(context.Background(), "tcp", tt.addr)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.addr, err)
+		}
+		conn.Close()
+		if !reflect.DeepEqual(dialed, tt.want) {
+			t.Errorf("%s: dialed %v, want %v", tt.addr, dialed, tt.want)
+		}
+	}
+
+	// Every address failing fails the dial
+	dialed = nil
+	r.overrides["github.com"] = []string{"192.0.2.1"}
+	if _, err := dial(context.Background(), "tcp", "github.com:443"); err == nil {
+		t.Errorf("no error when every address failed")
+	}
+}
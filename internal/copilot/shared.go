@@ -0,0 +1,196 @@
+package copilot
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/devstroop/reai/internal/metrics"
+)
+
+const (
+	// sharedTokenPoll is how often a replica waiting for the leader checks
+	// the store
+	sharedTokenPoll = time.Second
+	// sharedTokenWait bounds the wait for the leader, long enough for a
+	// device flow to complete
+	sharedTokenWait = 15 * time.Minute
+	// sharedStoreTimeout bounds each call to the store
+	sharedStoreTimeout = 10 * time.Second
+)
+
+var sharedTokenRefreshes = metrics.NewCounterVec("reai_shared_token_refreshes_total", "Session token refreshes with a shared token store, by how the token was obtained: fetched as the leader, adopted from another replica or fetched locally because the store failed", "result")
+
+// sharedTokens is the record the replicas share in SHARED_TOKEN_STORE
+type sharedTokens struct {
+	AccessToken string               `json:"access_token,omitempty"`
+	Session     SessionTokenResponse `json:"session"`
+	ExpiresAt   time.Time            `json:"expires_at"`
+	RefreshAt   time.Time            `json:"refresh_at"`
+	// Replica is the replica that fetched the session token
+	Replica string    `json:"replica"`
+	Updated time.Time `json:"updated"`
+}
+
+// replaces reports whether the record holds a session token other than
+// current that doesn't need refreshing yet
+func (t *sharedTokens) replaces(current string, now time.Time) bool {
+	return t.Session.Token != "" && t.Session.Token != current && now.Before(t.RefreshAt)
+}
+
+// refreshShared replaces the session token through the shared store. A token
+// another replica stored meanwhile is adopted. Otherwise the replica taking
+// the lease fetches one from GitHub, running the device flow if needed, and
+// stores it, while the others wait for it. When the store fails, the token
+// is fetched locally.
+func (c *Client) refreshShared(ctx context.Context) error {
+	c.mutex.RLock()
+	current := c.sessionToken
+	c.mutex.RUnlock()
+
+	deadline := time.Now().Add(sharedTokenWait)
+	for {
+		record, err := c.loadShared(ctx)
+		if err == nil && record.replaces(current, time.Now()) {
+			c.adoptShared(record)
+			sharedTokenRefreshes.With("adopted").Inc()
+			slog.Debug("Session token adopted from the shared store", "replica", record.Replica, "expires_at", record.ExpiresAt)
+			return nil
+		}
+
+		held := false
+		if err == nil {
+			storeCtx, cancel := context.WithTimeout(ctx, sharedStoreTimeout)
+			held, err = c.shared.Acquire(storeCtx, c.config.ReplicaID, c.config.SharedTokenLease)
+			cancel()
+		}
+		if err != nil {
+			slog.Warn("Shared token store failed, refreshing the session token locally", "store", c.shared.String(), "error", err)
+			sharedTokenRefreshes.With("local").Inc()
+			return c.fetchSessionToken(ctx, false)
+		}
+		if held {
+			return c.leadRefresh(ctx, record)
+		}
+
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timed out waiting for another replica to refresh the session token")
+		}
+		timer := time.NewTimer(sharedTokenPoll)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// leadRefresh fetches a session token while holding the lease and stores it
+// for the other replicas. The lease is renewed while the fetch runs, since a
+// device flow can outlast it.
+func (c *Client) leadRefresh(ctx context.Context, record *sharedTokens) error {
+	renewing, stopRenewing := context.WithCancel(ctx)
+	go c.renewLease(renewing)
+	defer func() {
+		stopRenewing()
+		releaseCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), sharedStoreTimeout)
+		defer cancel()
+		if err := c.shared.Release(releaseCtx, c.config.ReplicaID); err != nil {
+			slog.Warn("Failed to release the shared token lease", "error", err)
+		}
+	}()
+
+	// An access token another replica obtained saves a device flow
+	c.mutex.Lock()
+	if c.accessToken == "" && record.AccessToken != "" {
+		c.accessToken = record.AccessToken
+	}
+	c.mutex.Unlock()
+
+	if err := c.fetchSessionToken(ctx, false); err != nil {
+		return err
+	}
+	sharedTokenRefreshes.With("fetched").Inc()
+
+	c.mutex.RLock()
+	stored := sharedTokens{
+		AccessToken: c.accessToken,
+		Session:     c.tokenData,
+		ExpiresAt:   *c.expiresAt,
+		RefreshAt:   c.refreshAt,
+		Replica:     c.config.ReplicaID,
+		Updated:     time.Now().UTC(),
+	}
+	c.mutex.RUnlock()
+	data, err := json.Marshal(stored)
+	if err == nil {
+		storeCtx, cancel := context.WithTimeout(ctx, sharedStoreTimeout)
+		err = c.shared.Put(storeCtx, data)
+		cancel()
+	}
+	if err != nil {
+		// The token still serves this replica; the others fetch their own
+		slog.Warn("Failed to share the session token", "store", c.shared.String(), "error", err)
+		return nil
+	}
+	slog.Info("Session token refreshed and shared", "replica", c.config.ReplicaID, "expires_at", stored.ExpiresAt)
+	return nil
+}
+
+// renewLease keeps the lease until ctx is done
+func (c *Client) renewLease(ctx context.Context) {
+	ticker := time.NewTicker(max(c.config.SharedTokenLease/3, time.Second))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		storeCtx, cancel := context.WithTimeout(ctx, sharedStoreTimeout)
+		held, err := c.shared.Acquire(storeCtx, c.config.ReplicaID, c.config.SharedTokenLease)
+		cancel()
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil || !held {
+			slog.Warn("Lost the shared token lease, another replica may refresh the token too", "error", err)
+			return
+		}
+	}
+}
+
+// loadShared reads the record of the shared store, empty when none was stored
+func (c *Client) loadShared(ctx context.Context) (*sharedTokens, error) {
+	ctx, cancel := context.WithTimeout(ctx, sharedStoreTimeout)
+	defer cancel()
+	data, err := c.shared.Get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	record := &sharedTokens{}
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, record); err != nil {
+			return nil, fmt.Errorf("invalid shared token record: %w", err)
+		}
+	}
+	return record, nil
+}
+
+// adoptShared makes the session token of record, and its access token, the
+// ones in use
+func (c *Client) adoptShared(record *sharedTokens) {
+	claims, err := parseTokenClaims(record.Session.Token)
+	if err != nil {
+		slog.Debug("Failed to read session token claims", "error", err)
+	}
+	if record.AccessToken != "" {
+		c.mutex.Lock()
+		c.accessToken = record.AccessToken
+		c.mutex.Unlock()
+	}
+	c.setSessionToken(record.Session, claims, record.ExpiresAt, record.RefreshAt)
+}
@@ -0,0 +1,210 @@
+package copilot
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/devstroop/reai/internal/config"
+	"github.com/devstroop/reai/internal/metrics"
+)
+
+// Upstream connection pool metrics
+var (
+	upstreamConnsOpen     = metrics.NewGauge("reai_upstream_connections_open", "Open TCP connections to upstream hosts")
+	upstreamConnsDialed   = metrics.NewCounterVec("reai_upstream_connections_dialed_total", "Upstream dial attempts by result", "result")
+	upstreamConnsAcquired = metrics.NewCounterVec("reai_upstream_connections_acquired_total", "Connections handed to upstream requests, by whether they were reused from the pool", "reused")
+	upstreamConnIdleTime  = metrics.NewHistogram("reai_upstream_connection_idle_seconds", "Time reused connections spent idle in the pool", nil)
+)
+
+// newHTTPClient builds the HTTP client used for all upstream requests
+func newHTTPClient(cfg *config.Config) (*http.Client, error) {
+	dialer := &net.Dialer{
+		Timeout:   cfg.UpstreamDialTimeout,
+		KeepAlive: cfg.UpstreamKeepAlive,
+	}
+
+	overrides, err := parseHostOverrides(cfg.UpstreamHosts)
+	if err != nil {
+		return nil, err
+	}
+	dial := dialer.DialContext
+	if r := newResolver(overrides, cfg.UpstreamDNSCacheTTL); r != nil {
+		slog.Info("Using upstream resolver", "overrides", len(overrides), "cache_ttl", cfg.UpstreamDNSCacheTTL)
+		dial = r.dialer(dial)
+	}
+
+	transport := &http.Transport{
+		DialContext:           countingDialer(dial),
+		ForceAttemptHTTP2:     cfg.UpstreamHTTP2,
+		MaxIdleConns:          cfg.UpstreamMaxIdleConns,
+		MaxIdleConnsPerHost:   cfg.UpstreamMaxIdleConnsPerHost,
+		MaxConnsPerHost:       cfg.UpstreamMaxConnsPerHost,
+		IdleConnTimeout:       cfg.UpstreamIdleConnTimeout,
+		TLSHandshakeTimeout:   cfg.UpstreamTLSHandshakeTimeout,
+		ExpectContinueTimeout: 1 * time.Second,
+	}
+	if !cfg.UpstreamHTTP2 {
+		// A non-nil empty map disables the automatic HTTP/2 upgrade
+		transport.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+	}
+
+	proxy, err := proxyFunc(cfg)
+	if err != nil {
+		return nil, err
+	}
+	transport.Proxy = proxy
+
+	tlsConfig, err := upstreamTLSConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	transport.TLSClientConfig = tlsConfig
+
+	return &http.Client{
+		Transport: &instrumentedTransport{base: transport, logSampleRate: cfg.UpstreamLogSampleRate},
+		Timeout:   cfg.UpstreamTimeout,
+	}, nil
+}
+
+// OpenUpstreamConnections returns the number of open TCP connections to
+// upstream hosts
+func OpenUpstreamConnections() int {
+	return int(upstreamConnsOpen.Value())
+}
+
+// countingDialer wraps a dial function so open upstream connections are tracked
+func countingDialer(dial func(ctx context.Context, network, addr string) (net.Conn, error)) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dial(ctx, network, addr)
+		if err != nil {
+			upstreamConnsDialed.With("error").Inc()
+			return nil, err
+		}
+		upstreamConnsDialed.With("success").Inc()
+		upstreamConnsOpen.Inc()
+		return &countedConn{Conn: conn}, nil
+	}
+}
+
+// countedConn decrements the open connection gauge exactly once on close
+type countedConn struct {
+	net.Conn
+	closed sync.Once
+}
+
+func (c *countedConn) Close() error {
+	c.closed.Do(upstreamConnsOpen.Dec)
+	return c.Conn.Close()
+}
+
+// instrumentedTransport records connection reuse for every upstream request
+// and logs it (see requestLog)
+type instrumentedTransport struct {
+	base          http.RoundTripper
+	logSampleRate float64
+}
+
+func (t *instrumentedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			upstreamConnsAcquired.With(strconv.FormatBool(info.Reused)).Inc()
+			if info.WasIdle {
+				upstreamConnIdleTime.Observe(info.IdleTime.Seconds())
+			}
+		},
+	}
+	log := newRequestLog(req, t.logSampleRate)
+	resp, err := t.base.RoundTrip(req.WithContext(httptrace.WithClientTrace(req.Context(), trace)))
+	if err != nil {
+		log.failed(err)
+		return nil, err
+	}
+	log.responded(resp)
+	return resp, nil
+}
+
+// proxyFunc returns the proxy selector for upstream requests. An explicit
+// UPSTREAM_PROXY wins over the standard proxy environment variables.
+func proxyFunc(cfg *config.Config) (func(*http.Request) (*url.URL, error), error) {
+	if cfg.UpstreamProxy == "" {
+		return http.ProxyFromEnvironment, nil
+	}
+
+	proxyURL, err := url.Parse(cfg.UpstreamProxy)
+	if err != nil {
+		return nil, fmt.Errorf("invalid upstream proxy URL: %w", err)
+	}
+
+	switch proxyURL.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return nil, fmt.Errorf("unsupported upstream proxy scheme %q", proxyURL.Scheme)
+	}
+
+	if cfg.UpstreamProxyUsername != "" {
+		proxyURL.User = url.UserPassword(cfg.UpstreamProxyUsername, cfg.UpstreamProxyPassword)
+	}
+
+	slog.Info("Using upstream proxy", "scheme", proxyURL.Scheme, "host", proxyURL.Host, "authenticated", proxyURL.User != nil)
+	return http.ProxyURL(proxyURL), nil
+}
+
+// upstreamTLSConfig builds the TLS configuration for upstream and proxy connections
+func upstreamTLSConfig(cfg *config.Config) (*tls.Config, error) {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if cfg.UpstreamCABundle != "" {
+		pool, err := loadCABundle(cfg.UpstreamCABundle)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if cfg.UpstreamClientCert != "" || cfg.UpstreamClientKey != "" {
+		if cfg.UpstreamClientCert == "" || cfg.UpstreamClientKey == "" {
+			return nil, fmt.Errorf("both UPSTREAM_CLIENT_CERT and UPSTREAM_CLIENT_KEY must be set")
+		}
+		cert, err := tls.LoadX509KeyPair(cfg.UpstreamClientCert, cfg.UpstreamClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load upstream client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	if cfg.UpstreamInsecureSkipVerify {
+		slog.Warn("⚠️  INSECURE: upstream TLS certificate verification is disabled (UPSTREAM_TLS_INSECURE_SKIP_VERIFY). Tokens and prompts can be intercepted - never use this in production")
+		tlsConfig.InsecureSkipVerify = true
+	}
+
+	return tlsConfig, nil
+}
+
+// loadCABundle returns the system roots extended with the certificates in the given PEM file
+func loadCABundle(path string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA bundle: %w", err)
+	}
+
+	pool, err := x509.SystemCertPool()
+	if err != nil || pool == nil {
+		pool = x509.NewCertPool()
+	}
+
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in CA bundle %s", path)
+	}
+	return pool, nil
+}
@@ -0,0 +1,163 @@
+package copilot
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/devstroop/reai/internal/metrics"
+)
+
+// warmPrimePrompt is sent with a one token limit to prime the upstream session
+const warmPrimePrompt = "// warm-up\n"
+
+// CodexModel is the model of the code completions endpoint; every other
+// model is served by the chat endpoint
+const CodexModel = "copilot-codex"
+
+var warmPoolRuns = metrics.NewCounterVec("reai_warm_pool_runs_total", "Warm pool refreshes by kind (connect, prime) and result (ok, error, skipped)", "kind", "result")
+
+// WarmOptions configures KeepWarm
+type WarmOptions struct {
+	// Models are the models to keep warm. The connections go to the hosts
+	// their requests are sent to, the completions host when there are none.
+	Models []string
+	// Connections is the number of connections opened at once on every refresh
+	Connections int
+	// Interval between refreshes; it should stay below UPSTREAM_IDLE_CONN_TIMEOUT
+	Interval time.Duration
+	// Prime also sends a one token request to every model on every refresh
+	Prime bool
+	// Active reports whether the pool is needed right now (always when nil)
+	Active func() bool
+}
+
+// KeepWarm keeps connections to the hosts serving the models established, and
+// optionally the upstream sessions primed, until ctx is done. Refreshes are
+// skipped while there is no valid session token so they never start a device
+// flow or a token refresh of their own.
+func (c *Client) KeepWarm(ctx context.Context, opts WarmOptions) {
+	if opts.Interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(opts.Interval)
+	defer ticker.Stop()
+	for {
+		c.warm(ctx, opts)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// warm runs one refresh of the warm pool
+func (c *Client) warm(ctx context.Context, opts WarmOptions) {
+	if opts.Active != nil && !opts.Active() {
+		return
+	}
+	if _, ok := c.validSessionToken(); !ok {
+		warmPoolRuns.With("connect", "skipped").Inc()
+		return
+	}
+
+	// Requests in flight at the same time cannot share an HTTP/1.1 connection,
+	// so each one leaves a separate connection in the idle pool. HTTP/2
+	// multiplexes them onto a single connection.
+	var wg sync.WaitGroup
+	for _, host := range c.warmHosts(opts.Models) {
+		for i := 0; i < max(opts.Connections, 1); i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if err := c.connect(ctx, host); err != nil {
+					warmPoolRuns.With("connect", "error").Inc()
+					slog.Debug("Warm pool connection failed", "host", host, "error", err)
+					return
+				}
+				warmPoolRuns.With("connect", "ok").Inc()
+			}()
+		}
+	}
+	wg.Wait()
+
+	if opts.Prime {
+		primeCtx, cancel := context.WithTimeout(ctx, opts.Interval)
+		defer cancel()
+		models := opts.Models
+		if len(models) == 0 {
+			models = []string{CodexModel}
+		}
+		for _, model := range models {
+			if _, err := c.GetCompletion(primeCtx, primeRequest(model)); err != nil {
+				warmPoolRuns.With("prime", "error").Inc()
+				slog.Debug("Warm pool priming failed", "model", model, "error", err)
+				continue
+			}
+			warmPoolRuns.With("prime", "ok").Inc()
+		}
+	}
+}
+
+// warmHosts returns the hosts the requests of models are sent to: the
+// completions host for the codex model, the API host for chat models
+func (c *Client) warmHosts(models []string) []string {
+	profile := c.Profile()
+	if len(models) == 0 {
+		return []string{hostOf(profile.CompletionsURL)}
+	}
+	var hosts []string
+	for _, model := range models {
+		host := hostOf(profile.ChatURL)
+		if isCodexModel(model) {
+			host = hostOf(profile.CompletionsURL)
+		}
+		if !slices.Contains(hosts, host) {
+			hosts = append(hosts, host)
+		}
+	}
+	return hosts
+}
+
+// hostOf returns the root URL of the host of endpoint
+func hostOf(endpoint string) string {
+	if u, err := url.Parse(endpoint); err == nil {
+		return u.Scheme + "://" + u.Host + "/"
+	}
+	return endpoint
+}
+
+func isCodexModel(model string) bool {
+	return strings.EqualFold(model, CodexModel)
+}
+
+// primeRequest is a one token request to model, sent to the endpoint its
+// requests go to
+func primeRequest(model string) *CompletionRequest {
+	if isCodexModel(model) {
+		return &CompletionRequest{Model: model, Prompt: warmPrimePrompt, MaxTokens: 1}
+	}
+	return &CompletionRequest{Model: model, Messages: []Message{{Role: "user", Content: warmPrimePrompt}}, MaxTokens: 1}
+}
+
+// connect sends a bodyless request to the host so its connection goes back
+// to the idle pool. The status does not matter, only the connection.
+func (c *Client) connect(ctx context.Context, target string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, target, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	io.Copy(io.Discard, resp.Body)
+	return resp.Body.Close()
+}
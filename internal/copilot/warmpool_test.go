@@ -0,0 +1,73 @@
+package copilot
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// warmHost records the requests a Copilot host receives
+type warmHost struct {
+	mutex    sync.Mutex
+	connects int
+	primed   []string
+}
+
+func (h *warmHost) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if r.Method == http.MethodHead {
+		h.connects++
+		return
+	}
+	var body struct {
+		Model string `json:"model"`
+	}
+	json.NewDecoder(r.Body).Decode(&body)
+	h.primed = append(h.primed, r.URL.Path+" "+body.Model)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Write([]byte("data: [DONE]\n\n"))
+}
+
+func (h *warmHost) snapshot() (int, []string) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return h.connects, append([]string(nil), h.primed...)
+}
+
+func TestWarm(t *testing.T) {
+	api, proxy := &warmHost{}, &warmHost{}
+	apiServer, proxyServer := httptest.NewServer(api), httptest.NewServer(proxy)
+	defer apiServer.Close()
+	defer proxyServer.Close()
+	t.Setenv("COPILOT_API_URL", apiServer.URL)
+	t.Setenv("COPILOT_PROXY_URL", proxyServer.URL)
+	client := newTestClient(t, http.NotFoundHandler(), "gho_test")
+	expires := time.Now().Add(time.Hour)
+	client.sessionToken, client.expiresAt = "tid=test", &expires
+
+	opts := WarmOptions{Models: []string{"gpt-4", "claude-3.5-sonnet"}, Connections: 2, Interval: 5 * time.Second, Prime: true}
+	client.warm(context.Background(), opts)
+	connects, primed := api.snapshot()
+	if connects != 2 {
+		t.Errorf("%d connections to the API host, want 2", connects)
+	}
+	want := []string{"/chat/completions gpt-4", "/chat/completions claude-3.5-sonnet"}
+	if len(primed) != 2 || primed[0] != want[0] || primed[1] != want[1] {
+		t.Errorf("API host primed %q, want %q", primed, want)
+	}
+	if connects, primed := proxy.snapshot(); connects != 0 || len(primed) != 0 {
+		t.Errorf("completions host warmed for chat models: %d connections, primed %q", connects, primed)
+	}
+
+	// The codex model is warmed on the completions host
+	client.warm(context.Background(), WarmOptions{Models: []string{CodexModel}, Connections: 1, Interval: 5 * time.Second, Prime: true})
+	connects, primed = proxy.snapshot()
+	if connects != 1 || len(primed) != 1 || primed[0] != completionsPath+" "+CodexModel {
+		t.Errorf("completions host: %d connections, primed %q", connects, primed)
+	}
+}
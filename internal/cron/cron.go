@@ -0,0 +1,244 @@
+package cron
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule is a parsed standard 5-field cron expression
+// (minute hour day-of-month month day-of-week)
+type Schedule struct {
+	expr    string
+	minute  uint64
+	hour    uint64
+	dom     uint64
+	month   uint64
+	dow     uint64
+	domStar bool
+	dowStar bool
+}
+
+var monthNames = map[string]int{
+	"JAN": 1, "FEB": 2, "MAR": 3, "APR": 4, "MAY": 5, "JUN": 6,
+	"JUL": 7, "AUG": 8, "SEP": 9, "OCT": 10, "NOV": 11, "DEC": 12,
+}
+
+var dowNames = map[string]int{
+	"SUN": 0, "MON": 1, "TUE": 2, "WED": 3, "THU": 4, "FRI": 5, "SAT": 6,
+}
+
+// Parse parses a cron expression. Fields support *, lists (1,2), ranges (1-5),
+// steps (*/15, 1-30/5) and month/weekday names. Day-of-week 7 is Sunday.
+func Parse(expr string) (*Schedule, error) {
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("cron expression %q must have 5 fields", expr)
+	}
+
+	s := &Schedule{expr: expr}
+	var err error
+	if s.minute, err = parseField(fields[0], 0, 59, nil); err != nil {
+		return nil, fmt.Errorf("minute: %w", err)
+	}
+	if s.hour, err = parseField(fields[1], 0, 23, nil); err != nil {
+		return nil, fmt.Errorf("hour: %w", err)
+	}
+	if s.dom, err = parseField(fields[2], 1, 31, nil); err != nil {
+		return nil, fmt.Errorf("day of month: %w", err)
+	}
+	if s.month, err = parseField(fields[3], 1, 12, monthNames); err != nil {
+		return nil, fmt.Errorf("month: %w", err)
+	}
+	if s.dow, err = parseField(fields[4], 0, 7, dowNames); err != nil {
+		return nil, fmt.Errorf("day of week: %w", err)
+	}
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1 << 0
+	}
+	s.domStar = fields[2] == "*" || fields[2] == "?"
+	s.dowStar = fields[4] == "*" || fields[4] == "?"
+
+	return s, nil
+}
+
+// String returns the original expression
+func (s *Schedule) String() string {
+	return s.expr
+}
+
+// Matches reports whether the schedule fires at the minute containing t
+func (s *Schedule) Matches(t time.Time) bool {
+	if s.minute&(1<<uint(t.Minute())) == 0 || s.hour&(1<<uint(t.Hour())) == 0 || s.month&(1<<uint(t.Month())) == 0 {
+		return false
+	}
+
+	domMatch := s.dom&(1<<uint(t.Day())) != 0
+	dowMatch := s.dow&(1<<uint(t.Weekday())) != 0
+
+	// Like classic cron, a restricted day-of-month and day-of-week match if either does
+	switch {
+	case s.domStar && s.dowStar:
+		return true
+	case s.domStar:
+		return dowMatch
+	case s.dowStar:
+		return domMatch
+	default:
+		return domMatch || dowMatch
+	}
+}
+
+// Next returns the first time after t at which the schedule fires, or the
+// zero time if none is found within five years. The fields are matched
+// against the wall clock in t's location, so zones whose offset is not a
+// whole hour fire at the expected local time.
+func (s *Schedule) Next(t time.Time) time.Time {
+	t = startOfMinute(t).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		if s.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !s.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if s.hour&(1<<uint(t.Hour())) == 0 {
+			t = t.Add(time.Duration(60-t.Minute()) * time.Minute)
+			continue
+		}
+		minute := nextBit(s.minute, t.Minute())
+		if minute < 0 {
+			t = t.Add(time.Duration(60-t.Minute()) * time.Minute)
+			continue
+		}
+		return t.Add(time.Duration(minute-t.Minute()) * time.Minute)
+	}
+	return time.Time{}
+}
+
+// Prev returns the latest time at or before t (and after since) at which the
+// schedule fired, or the zero time if it did not fire in that range
+func (s *Schedule) Prev(t, since time.Time) time.Time {
+	for t = startOfMinute(t); t.After(since); {
+		if s.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, t.Location()).Add(-time.Minute)
+			continue
+		}
+		if !s.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location()).Add(-time.Minute)
+			continue
+		}
+		if s.hour&(1<<uint(t.Hour())) == 0 {
+			t = t.Add(-time.Duration(t.Minute()+1) * time.Minute)
+			continue
+		}
+		minute := prevBit(s.minute, t.Minute())
+		if minute < 0 {
+			t = t.Add(-time.Duration(t.Minute()+1) * time.Minute)
+			continue
+		}
+		if t = t.Add(-time.Duration(t.Minute()-minute) * time.Minute); t.After(since) {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// startOfMinute drops the seconds of t's wall clock
+func startOfMinute(t time.Time) time.Time {
+	return t.Add(-time.Duration(t.Second())*time.Second - time.Duration(t.Nanosecond()))
+}
+
+// nextBit returns the lowest set bit at or above from, -1 if there is none
+func nextBit(bits uint64, from int) int {
+	for i := from; i < 64; i++ {
+		if bits&(1<<uint(i)) != 0 {
+			return i
+		}
+	}
+	return -1
+}
+
+// prevBit returns the highest set bit at or below from, -1 if there is none
+func prevBit(bits uint64, from int) int {
+	for i := from; i >= 0; i-- {
+		if bits&(1<<uint(i)) != 0 {
+			return i
+		}
+	}
+	return -1
+}
+
+func (s *Schedule) dayMatches(t time.Time) bool {
+	return s.Matches(time.Date(t.Year(), t.Month(), t.Day(), firstBit(s.hour), firstBit(s.minute), 0, 0, t.Location()))
+}
+
+func firstBit(bits uint64) int {
+	for i := 0; i < 64; i++ {
+		if bits&(1<<uint(i)) != 0 {
+			return i
+		}
+	}
+	return 0
+}
+
+// parseField parses one comma separated cron field into a bit set
+func parseField(field string, min, max int, names map[string]int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if base, stepStr, ok := strings.Cut(part, "/"); ok {
+			n, err := strconv.Atoi(stepStr)
+			if err != nil || n <= 0 {
+				return 0, fmt.Errorf("invalid step %q", stepStr)
+			}
+			part, step = base, n
+		}
+
+		lo, hi := min, max
+		switch {
+		case part == "*" || part == "?":
+		case strings.Contains(part, "-"):
+			loStr, hiStr, _ := strings.Cut(part, "-")
+			var err error
+			if lo, err = parseValue(loStr, names); err != nil {
+				return 0, err
+			}
+			if hi, err = parseValue(hiStr, names); err != nil {
+				return 0, err
+			}
+		default:
+			v, err := parseValue(part, names)
+			if err != nil {
+				return 0, err
+			}
+			lo = v
+			if step == 1 {
+				hi = v
+			}
+		}
+
+		if lo < min || hi > max || lo > hi {
+			return 0, fmt.Errorf("value out of range %d-%d in %q", min, max, field)
+		}
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+func parseValue(value string, names map[string]int) (int, error) {
+	if v, ok := names[strings.ToUpper(value)]; ok {
+		return v, nil
+	}
+	v, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value %q", value)
+	}
+	return v, nil
+}
@@ -0,0 +1,113 @@
+package cron
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNext(t *testing.T) {
+	india := time.FixedZone("IST", 5*3600+1800)
+	nepal := time.FixedZone("NPT", 5*3600+2700)
+	tests := []struct {
+		expr string
+		from time.Time
+		want time.Time
+	}{
+		{"*/15 * * * *", time.Date(2024, 3, 1, 10, 7, 30, 0, time.UTC), time.Date(2024, 3, 1, 10, 15, 0, 0, time.UTC)},
+		{"0 3 * * SUN", time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC), time.Date(2024, 3, 3, 3, 0, 0, 0, time.UTC)},
+		{"30 22 1 * *", time.Date(2024, 12, 1, 22, 30, 0, 0, time.UTC), time.Date(2025, 1, 1, 22, 30, 0, 0, time.UTC)},
+		{"0 0 29 FEB *", time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC), time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"5 9-17 * * MON-FRI", time.Date(2024, 3, 1, 17, 6, 0, 0, time.UTC), time.Date(2024, 3, 4, 9, 5, 0, 0, time.UTC)},
+		// Zones half or three quarters of an hour off UTC
+		{"0 3 * * *", time.Date(2024, 3, 1, 2, 59, 0, 0, india), time.Date(2024, 3, 1, 3, 0, 0, 0, india)},
+		{"0 3 * * *", time.Date(2024, 3, 1, 1, 40, 0, 0, india), time.Date(2024, 3, 1, 3, 0, 0, 0, india)},
+		{"30 * * * *", time.Date(2024, 3, 1, 1, 40, 0, 0, india), time.Date(2024, 3, 1, 2, 30, 0, 0, india)},
+		{"0 2 * * *", time.Date(2024, 3, 1, 0, 50, 0, 0, nepal), time.Date(2024, 3, 1, 2, 0, 0, 0, nepal)},
+		{"0 0 31 2 *", time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC), time.Time{}},
+	}
+	for _, tt := range tests {
+		schedule, err := Parse(tt.expr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := schedule.Next(tt.from); !got.Equal(tt.want) {
+			t.Errorf("%q after %v: got %v, want %v", tt.expr, tt.from, got, tt.want)
+		}
+	}
+}
+
+func TestPrev(t *testing.T) {
+	india := time.FixedZone("IST", 5*3600+1800)
+	tests := []struct {
+		expr  string
+		at    time.Time
+		since time.Time
+		want  time.Time
+	}{
+		{"0 3 * * SUN", time.Date(2024, 3, 3, 4, 0, 0, 0, time.UTC), time.Date(2024, 3, 3, 2, 30, 0, 0, time.UTC), time.Date(2024, 3, 3, 3, 0, 0, 0, time.UTC)},
+		{"0 3 * * SUN", time.Date(2024, 3, 3, 3, 0, 59, 0, time.UTC), time.Date(2024, 3, 3, 2, 0, 0, 0, time.UTC), time.Date(2024, 3, 3, 3, 0, 0, 0, time.UTC)},
+		{"0 3 * * SUN", time.Date(2024, 3, 3, 2, 59, 0, 0, time.UTC), time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 2, 25, 3, 0, 0, 0, time.UTC)},
+		// since is excluded
+		{"0 3 * * *", time.Date(2024, 3, 3, 4, 0, 0, 0, time.UTC), time.Date(2024, 3, 3, 3, 0, 0, 0, time.UTC), time.Time{}},
+		{"45 23 31 12 *", time.Date(2024, 3, 3, 4, 0, 0, 0, time.UTC), time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2023, 12, 31, 23, 45, 0, 0, time.UTC)},
+		{"0 3 * * *", time.Date(2024, 3, 1, 3, 20, 0, 0, india), time.Date(2024, 3, 1, 2, 0, 0, 0, india), time.Date(2024, 3, 1, 3, 0, 0, 0, india)},
+		{"*/20 * * * *", time.Date(2024, 3, 1, 3, 19, 0, 0, india), time.Date(2024, 3, 1, 2, 0, 0, 0, india), time.Date(2024, 3, 1, 3, 0, 0, 0, india)},
+	}
+	for _, tt := range tests {
+		schedule, err := Parse(tt.expr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := schedule.Prev(tt.at, tt.since); !got.Equal(tt.want) {
+			t.Errorf("%q at %v since %v: got %v, want %v", tt.expr, tt.at, tt.since, got, tt.want)
+		}
+	}
+}
+
+func TestNextDaylightSaving(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip("no time zone database:", err)
+	}
+	schedule, err := Parse("30 2 * * *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 02:30 does not exist on 10 March 2024; the next run is the day after
+	got := schedule.Next(time.Date(2024, 3, 9, 12, 0, 0, 0, newYork))
+	if want := time.Date(2024, 3, 11, 2, 30, 0, 0, newYork); !got.Equal(want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	schedule, err = Parse("0 * * * *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Hourly runs fire on the hour through the repeated hour in November
+	from := time.Date(2024, 11, 3, 0, 30, 0, 0, newYork)
+	for i := 0; i < 3; i++ {
+		next := schedule.Next(from)
+		if next.Minute() != 0 || next.Sub(from) > time.Hour {
+			t.Fatalf("after %v: %v", from, next)
+		}
+		from = next
+	}
+	if want := time.Date(2024, 11, 3, 2, 0, 0, 0, newYork); !from.Equal(want) {
+		t.Errorf("third run %v, want %v", from, want)
+	}
+}
+
+func TestParse(t *testing.T) {
+	for _, expr := range []string{"* * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "* * * 13 *", "*/0 * * * *", "5-1 * * * *", "* * * * FUNDAY"} {
+		if _, err := Parse(expr); err == nil {
+			t.Errorf("%q accepted", expr)
+		}
+	}
+	schedule, err := Parse("0 0 * * 7")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !schedule.Matches(time.Date(2024, 3, 3, 0, 0, 0, 0, time.UTC)) {
+		t.Error("day of week 7 is not Sunday")
+	}
+}
@@ -0,0 +1,284 @@
+package eval
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxOutputShown bounds the completion text kept in a report
+const maxOutputShown = 2000
+
+// Runner sends the cases of a suite to a server
+type Runner struct {
+	// Client sends the requests; it may serve them in process
+	Client *http.Client
+	// BaseURL is the server root, e.g. http://localhost:8080
+	BaseURL string
+	// APIKey is sent as a bearer token when set
+	APIKey string
+	// Concurrency is the number of cases run at once (1 when not positive)
+	Concurrency int
+	// Timeout bounds each case (no limit when zero)
+	Timeout time.Duration
+}
+
+// Report is the outcome of a suite run
+type Report struct {
+	Suite    string        `json:"suite"`
+	Passed   int           `json:"passed"`
+	Failed   int           `json:"failed"`
+	Duration time.Duration `json:"-"`
+	// DurationMS is Duration in milliseconds
+	DurationMS float64  `json:"duration_ms"`
+	Results    []Result `json:"results"`
+}
+
+// Result is the outcome of a case
+type Result struct {
+	Name         string        `json:"name"`
+	Endpoint     string        `json:"endpoint"`
+	Model        string        `json:"model"`
+	Passed       bool          `json:"passed"`
+	Latency      time.Duration `json:"-"`
+	LatencyMS    float64       `json:"latency_ms"`
+	FinishReason string        `json:"finish_reason,omitempty"`
+	// Failures are the checks that didn't pass
+	Failures []string `json:"failures,omitempty"`
+	// Error is set when the request itself failed
+	Error  string `json:"error,omitempty"`
+	Output string `json:"output,omitempty"`
+}
+
+// Run runs every case of suite and reports the results in suite order
+func (r *Runner) Run(ctx context.Context, suite *Suite) *Report {
+	start := time.Now()
+	results := make([]Result, len(suite.Cases))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < max(r.Concurrency, 1); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := range jobs {
+				results[n] = r.runCase(ctx, &suite.Cases[n])
+			}
+		}()
+	}
+	for n := range suite.Cases {
+		jobs <- n
+	}
+	close(jobs)
+	wg.Wait()
+
+	duration := time.Since(start)
+	report := &Report{Suite: suite.Name, Duration: duration, DurationMS: milliseconds(duration), Results: results}
+	for _, result := range results {
+		if result.Passed {
+			report.Passed++
+		} else {
+			report.Failed++
+		}
+	}
+	return report
+}
+
+// runCase sends a case and checks its completion
+func (r *Runner) runCase(ctx context.Context, c *Case) Result {
+	result := Result{Name: c.Name, Endpoint: c.Endpoint, Model: c.Model}
+	if r.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.Timeout)
+		defer cancel()
+	}
+
+	start := time.Now()
+	text, finishReason, err := r.complete(ctx, c)
+	result.Latency = time.Since(start)
+	result.LatencyMS = milliseconds(result.Latency)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	result.FinishReason = finishReason
+	result.Output = text
+	if len(text) > maxOutputShown {
+		result.Output = text[:maxOutputShown] + "…"
+	}
+	result.Failures = c.Expect.check(text, finishReason, result.Latency)
+	result.Passed = len(result.Failures) == 0
+	return result
+}
+
+// complete sends a case to its endpoint and returns the completion text
+func (r *Runner) complete(ctx context.Context, c *Case) (string, string, error) {
+	body := map[string]interface{}{"temperature": c.Temperature}
+	if c.Model != "" {
+		body["model"] = c.Model
+	}
+	if c.MaxTokens > 0 {
+		body["max_tokens"] = c.MaxTokens
+	}
+	path := "/v1/chat/completions"
+	if c.Endpoint == EndpointCompletions {
+		path = "/v1/completions"
+		body["prompt"] = c.Prompt
+	} else {
+		messages := c.Messages
+		if len(messages) == 0 {
+			if c.System != "" {
+				messages = append(messages, Message{Role: "system", Content: c.System})
+			}
+			messages = append(messages, Message{Role: "user", Content: c.Prompt})
+		}
+		body["messages"] = messages
+	}
+
+	data, err := json.Marshal(body)
+	if err != nil {
+		return "", "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(r.BaseURL, "/")+path, bytes.NewReader(data))
+	if err != nil {
+		return "", "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "reai-eval")
+	if r.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+r.APIKey)
+	}
+
+	resp, err := r.Client.Do(req)
+	if err != nil {
+		return "", "", err
+	}
+	defer resp.Body.Close()
+	data, err = io.ReadAll(resp.Body)
+	if err != nil {
+		return "", "", err
+	}
+
+	var decoded struct {
+		Choices []struct {
+			Text    string `json:"text"`
+			Message struct {
+				Content string `json:"content"`
+			} `json:"message"`
+			FinishReason string `json:"finish_reason"`
+		} `json:"choices"`
+		Error *struct {
+			Type    string `json:"type"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return "", "", fmt.Errorf("%s: unexpected response: %s", resp.Status, strings.TrimSpace(string(data)))
+	}
+	if decoded.Error != nil {
+		return "", "", fmt.Errorf("%s: %s: %s", resp.Status, decoded.Error.Type, decoded.Error.Message)
+	}
+	if resp.StatusCode != http.StatusOK || len(decoded.Choices) == 0 {
+		return "", "", fmt.Errorf("%s: no completion in the response", resp.Status)
+	}
+	choice := decoded.Choices[0]
+	if c.Endpoint == EndpointCompletions {
+		return choice.Text, choice.FinishReason, nil
+	}
+	return choice.Message.Content, choice.FinishReason, nil
+}
+
+// milliseconds converts d for the JSON report
+func milliseconds(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+// check returns the expectations a completion fails
+func (e *Expect) check(text, finishReason string, latency time.Duration) []string {
+	var failures []string
+	if e.Exact != nil && strings.TrimSpace(text) != strings.TrimSpace(*e.Exact) {
+		failures = append(failures, fmt.Sprintf("exact: got %q, want %q", strings.TrimSpace(text), strings.TrimSpace(*e.Exact)))
+	}
+	for _, s := range e.Contains {
+		if !strings.Contains(text, s) {
+			failures = append(failures, fmt.Sprintf("contains: %q not found", s))
+		}
+	}
+	for _, re := range e.regex {
+		if !re.MatchString(text) {
+			failures = append(failures, fmt.Sprintf("regex: no match for %s", re))
+		}
+	}
+	for _, re := range e.notRegex {
+		if re.MatchString(text) {
+			failures = append(failures, fmt.Sprintf("not_regex: %s matched %q", re, re.FindString(text)))
+		}
+	}
+	if e.schema != nil {
+		var document interface{}
+		if err := json.Unmarshal([]byte(stripFence(text)), &document); err != nil {
+			failures = append(failures, fmt.Sprintf("json_schema: not JSON: %v", err))
+		} else {
+			for _, problem := range validateSchema(document, e.schema) {
+				failures = append(failures, "json_schema: "+problem)
+			}
+		}
+	}
+	if e.FinishReason != "" && finishReason != e.FinishReason {
+		failures = append(failures, fmt.Sprintf("finish_reason: got %q, want %q", finishReason, e.FinishReason))
+	}
+	if e.maxLatency > 0 && latency > e.maxLatency {
+		failures = append(failures, fmt.Sprintf("max_latency: took %s, want at most %s", latency.Round(time.Millisecond), e.maxLatency))
+	}
+	return failures
+}
+
+// stripFence removes a Markdown code fence around text
+func stripFence(text string) string {
+	text = strings.TrimSpace(text)
+	if !strings.HasPrefix(text, "```") || !strings.HasSuffix(text, "```") || len(text) < 6 {
+		return text
+	}
+	text = strings.TrimSuffix(text, "```")
+	if i := strings.IndexByte(text, '\n'); i >= 0 {
+		return text[i+1:]
+	}
+	return ""
+}
+
+// WriteText writes a report for people: a line per case, the failures of
+// the failed ones, and a summary
+func (r *Report) WriteText(w io.Writer) {
+	if r.Suite != "" {
+		fmt.Fprintf(w, "Suite %s\n\n", r.Suite)
+	}
+	for _, result := range r.Results {
+		mark := "PASS"
+		if !result.Passed {
+			mark = "FAIL"
+		}
+		fmt.Fprintf(w, "%s  %-40s %-12s %8s\n", mark, result.Name, result.Model, result.Latency.Round(time.Millisecond))
+		if result.Error != "" {
+			fmt.Fprintf(w, "      error: %s\n", result.Error)
+		}
+		for _, failure := range result.Failures {
+			fmt.Fprintf(w, "      %s\n", failure)
+		}
+		if !result.Passed && result.Output != "" {
+			fmt.Fprintf(w, "      output: %q\n", result.Output)
+		}
+	}
+	fmt.Fprintf(w, "\n%d passed, %d failed in %s\n", r.Passed, r.Failed, r.Duration.Round(time.Millisecond))
+}
+
+// WriteJSON writes the report as JSON
+func (r *Report) WriteJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(r)
+}
@@ -0,0 +1,51 @@
+package eval
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/santhosh-tekuri/jsonschema/v5"
+)
+
+// schemaURL names the schema of a case for references within it
+const schemaURL = "json_schema.json"
+
+// compileSchema compiles a JSON Schema. Schemas without $schema follow draft
+// 2020-12.
+func compileSchema(schema []byte) (*jsonschema.Schema, error) {
+	compiler := jsonschema.NewCompiler()
+	if err := compiler.AddResource(schemaURL, bytes.NewReader(schema)); err != nil {
+		return nil, err
+	}
+	return compiler.Compile(schemaURL)
+}
+
+// validateSchema checks a decoded JSON value against a schema and returns
+// the violations, each prefixed with the JSON pointer of the offending value
+func validateSchema(value interface{}, schema *jsonschema.Schema) []string {
+	err := schema.Validate(value)
+	if err == nil {
+		return nil
+	}
+	var validation *jsonschema.ValidationError
+	if !errors.As(err, &validation) {
+		return []string{err.Error()}
+	}
+	var problems []string
+	var collect func(*jsonschema.ValidationError)
+	collect = func(e *jsonschema.ValidationError) {
+		if len(e.Causes) == 0 {
+			location := e.InstanceLocation
+			if location == "" {
+				location = "/"
+			}
+			problems = append(problems, fmt.Sprintf("%s: %s", location, e.Message))
+		}
+		for _, cause := range e.Causes {
+			collect(cause)
+		}
+	}
+	collect(validation)
+	return problems
+}
@@ -0,0 +1,215 @@
+// Package eval runs suites of prompts against a ReAI server and checks the
+// completions against expectations, so configuration and model changes can
+// be validated before they are rolled out.
+package eval
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"time"
+
+	"github.com/santhosh-tekuri/jsonschema/v5"
+	"gopkg.in/yaml.v3"
+)
+
+// Endpoints a case can be sent to
+const (
+	EndpointChat        = "chat"
+	EndpointCompletions = "completions"
+)
+
+// Suite is the format of a suite file
+type Suite struct {
+	Name string `json:"name"`
+	// Model, Endpoint, Temperature and MaxTokens apply to the cases that
+	// don't set their own. Temperature defaults to 0 for repeatable results.
+	Model       string   `json:"model"`
+	Endpoint    string   `json:"endpoint"`
+	Temperature *float64 `json:"temperature"`
+	MaxTokens   int      `json:"max_tokens"`
+	Cases       []Case   `json:"cases"`
+}
+
+// Case is one prompt and the expectations its completion must meet
+type Case struct {
+	Name     string `json:"name"`
+	Endpoint string `json:"endpoint"`
+	Model    string `json:"model"`
+	// System and Prompt make up a chat; Messages replace them for longer
+	// conversations. Completion cases take Prompt only.
+	System      string    `json:"system"`
+	Prompt      string    `json:"prompt"`
+	Messages    []Message `json:"messages"`
+	Temperature *float64  `json:"temperature"`
+	MaxTokens   int       `json:"max_tokens"`
+	Expect      Expect    `json:"expect"`
+}
+
+// Message is a chat message of a case
+type Message struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+// Expect lists the checks of a completion. All of them must pass.
+type Expect struct {
+	// Exact is the whole completion, ignoring surrounding whitespace
+	Exact *string `json:"exact"`
+	// Contains are substrings of the completion
+	Contains stringList `json:"contains"`
+	// Regex are regular expressions the completion matches, NotRegex ones
+	// it must not match
+	Regex    stringList `json:"regex"`
+	NotRegex stringList `json:"not_regex"`
+	// JSONSchema validates the completion as a JSON document, given inline
+	// or as a JSON string. A surrounding code fence is ignored.
+	JSONSchema json.RawMessage `json:"json_schema"`
+	// MaxLatency bounds the time the completion took, e.g. "5s"
+	MaxLatency string `json:"max_latency"`
+	// FinishReason is the expected finish reason, e.g. "stop"
+	FinishReason string `json:"finish_reason"`
+
+	regex      []*regexp.Regexp
+	notRegex   []*regexp.Regexp
+	schema     *jsonschema.Schema
+	maxLatency time.Duration
+}
+
+// stringList accepts a single string as well as a list
+type stringList []string
+
+func (l *stringList) UnmarshalJSON(data []byte) error {
+	var one string
+	if err := json.Unmarshal(data, &one); err == nil {
+		*l = stringList{one}
+		return nil
+	}
+	return json.Unmarshal(data, (*[]string)(l))
+}
+
+// Load reads a suite file written in YAML or JSON
+func Load(path string) (*Suite, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read suite: %w", err)
+	}
+	var tree interface{}
+	if err := yaml.Unmarshal(data, &tree); err != nil {
+		return nil, fmt.Errorf("failed to parse suite %s: %w", path, err)
+	}
+	// The tree is decoded through JSON, which checks the field names and types
+	encoded, err := json.Marshal(tree)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse suite %s: %w", path, err)
+	}
+	decoder := json.NewDecoder(bytes.NewReader(encoded))
+	decoder.DisallowUnknownFields()
+	suite := &Suite{}
+	if err := decoder.Decode(suite); err != nil {
+		return nil, fmt.Errorf("invalid suite %s: %w", path, err)
+	}
+	if err := suite.prepare(); err != nil {
+		return nil, fmt.Errorf("invalid suite %s: %w", path, err)
+	}
+	return suite, nil
+}
+
+// prepare applies the suite defaults to the cases and compiles their checks
+func (s *Suite) prepare() error {
+	if len(s.Cases) == 0 {
+		return fmt.Errorf("no cases")
+	}
+	if s.Temperature == nil {
+		zero := 0.0
+		s.Temperature = &zero
+	}
+	names := make(map[string]bool, len(s.Cases))
+	for i := range s.Cases {
+		c := &s.Cases[i]
+		if c.Name == "" {
+			c.Name = fmt.Sprintf("case %d", i+1)
+		}
+		if names[c.Name] {
+			return fmt.Errorf("duplicate case name %q", c.Name)
+		}
+		names[c.Name] = true
+
+		if c.Endpoint == "" {
+			c.Endpoint = s.Endpoint
+		}
+		if c.Endpoint == "" {
+			c.Endpoint = EndpointChat
+		}
+		if c.Model == "" {
+			c.Model = s.Model
+		}
+		if c.Temperature == nil {
+			c.Temperature = s.Temperature
+		}
+		if c.MaxTokens == 0 {
+			c.MaxTokens = s.MaxTokens
+		}
+		switch c.Endpoint {
+		case EndpointChat:
+			if c.Prompt == "" && len(c.Messages) == 0 {
+				return fmt.Errorf("%s: prompt or messages are required", c.Name)
+			}
+			if len(c.Messages) > 0 && (c.Prompt != "" || c.System != "") {
+				return fmt.Errorf("%s: messages replace system and prompt", c.Name)
+			}
+		case EndpointCompletions:
+			if c.Prompt == "" || len(c.Messages) > 0 || c.System != "" {
+				return fmt.Errorf("%s: completion cases take a prompt only", c.Name)
+			}
+		default:
+			return fmt.Errorf("%s: unknown endpoint %q (want chat or completions)", c.Name, c.Endpoint)
+		}
+		if err := c.Expect.compile(); err != nil {
+			return fmt.Errorf("%s: %w", c.Name, err)
+		}
+	}
+	return nil
+}
+
+// compile parses the regular expressions, schema and latency of the checks
+func (e *Expect) compile() error {
+	for _, pattern := range e.Regex {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return fmt.Errorf("invalid regex: %w", err)
+		}
+		e.regex = append(e.regex, re)
+	}
+	for _, pattern := range e.NotRegex {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return fmt.Errorf("invalid not_regex: %w", err)
+		}
+		e.notRegex = append(e.notRegex, re)
+	}
+
+	if len(e.JSONSchema) > 0 && string(e.JSONSchema) != "null" {
+		schema := []byte(e.JSONSchema)
+		var text string
+		if json.Unmarshal(schema, &text) == nil {
+			schema = []byte(text)
+		}
+		compiled, err := compileSchema(schema)
+		if err != nil {
+			return fmt.Errorf("invalid json_schema: %w", err)
+		}
+		e.schema = compiled
+	}
+
+	if e.MaxLatency != "" {
+		d, err := time.ParseDuration(e.MaxLatency)
+		if err != nil || d <= 0 {
+			return fmt.Errorf("invalid max_latency %q", e.MaxLatency)
+		}
+		e.maxLatency = d
+	}
+	return nil
+}
@@ -0,0 +1,168 @@
+package eval
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// loadSuite writes a suite to a file and loads it
+func loadSuite(t *testing.T, text string) (*Suite, error) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "suite.yaml")
+	if err := os.WriteFile(path, []byte(text), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return Load(path)
+}
+
+func TestLoadYAML(t *testing.T) {
+	tests := []struct {
+		name   string
+		suite  string
+		prompt string
+		expect Expect
+	}{
+		{
+			name:   "quoting",
+			suite:  "cases:\n  - prompt: 'it''s # not a comment'\n    expect:\n      contains: \"say \\\"hi\\\"\\n\"\n",
+			prompt: "it's # not a comment",
+			expect: Expect{Contains: stringList{"say \"hi\"\n"}},
+		},
+		{
+			name:   "flow collections",
+			suite:  "cases: [{prompt: \"a, b\", expect: {regex: [x, 'y, z'], not_regex: \"[0-9]+\"}}]\n",
+			prompt: "a, b",
+			expect: Expect{Regex: stringList{"x", "y, z"}, NotRegex: stringList{"[0-9]+"}},
+		},
+		{
+			name:   "literal block kept",
+			suite:  "cases:\n  - prompt: |+\n      line one\n        indented\n\n    expect:\n      contains: x\n",
+			prompt: "line one\n  indented\n\n",
+			expect: Expect{Contains: stringList{"x"}},
+		},
+		{
+			name:   "literal block stripped",
+			suite:  "cases:\n  - prompt: |-\n      line one\n      line two\n\n    expect:\n      contains: x\n",
+			prompt: "line one\nline two",
+			expect: Expect{Contains: stringList{"x"}},
+		},
+		{
+			name:   "folded block clipped",
+			suite:  "cases:\n  - prompt: >\n      one\n      two\n\n      three\n\n\n    expect:\n      contains: x\n",
+			prompt: "one two\nthree\n",
+			expect: Expect{Contains: stringList{"x"}},
+		},
+		{
+			name:   "comments",
+			suite:  "# suite\ncases: # the cases\n  - prompt: a#b # trailing\n    expect:\n      contains: \"# kept\"  # dropped\n      regex: |\n        x # part of the block\n",
+			prompt: "a#b",
+			expect: Expect{Contains: stringList{"# kept"}, Regex: stringList{"x # part of the block\n"}},
+		},
+		{
+			name:   "JSON",
+			suite:  `{"cases": [{"prompt": "hi", "expect": {"contains": ["a", "b"]}}]}`,
+			prompt: "hi",
+			expect: Expect{Contains: stringList{"a", "b"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			suite, err := loadSuite(t, tt.suite)
+			if err != nil {
+				t.Fatal(err)
+			}
+			c := suite.Cases[0]
+			if c.Prompt != tt.prompt {
+				t.Errorf("prompt %q, want %q", c.Prompt, tt.prompt)
+			}
+			got := Expect{Contains: c.Expect.Contains, Regex: c.Expect.Regex, NotRegex: c.Expect.NotRegex}
+			if !reflect.DeepEqual(got, tt.expect) {
+				t.Errorf("expect %+v, want %+v", got, tt.expect)
+			}
+		})
+	}
+}
+
+func TestLoadInvalid(t *testing.T) {
+	tests := map[string]string{
+		"syntax":        "cases:\n  - prompt: [unclosed\n",
+		"tabs":          "cases:\n\t- prompt: hi\n",
+		"unknown field": "cases:\n  - prompt: hi\n    expected: {contains: x}\n",
+		"no cases":      "name: empty\n",
+		"bad schema":    "cases:\n  - prompt: hi\n    expect:\n      json_schema: {type: 5}\n",
+		"bad regex":     "cases:\n  - prompt: hi\n    expect:\n      regex: \"(\"\n",
+	}
+	for name, text := range tests {
+		if _, err := loadSuite(t, text); err == nil {
+			t.Errorf("%s: no error", name)
+		}
+	}
+}
+
+func TestJSONSchema(t *testing.T) {
+	suite, err := loadSuite(t, `
+cases:
+  - prompt: hi
+    expect:
+      json_schema:
+        type: object
+        required: [name, born]
+        additionalProperties: false
+        properties:
+          name: {type: string, minLength: 1}
+          born: {type: integer, minimum: 1800, maximum: 1900}
+          tags: {type: array, items: {$ref: "#/$defs/tag"}}
+        $defs:
+          tag: {enum: [math, poetry]}
+  - prompt: hi
+    expect:
+      json_schema: '{"type": "array", "maxItems": 1}'
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	person, list := suite.Cases[0].Expect, suite.Cases[1].Expect
+
+	tests := []struct {
+		expect Expect
+		text   string
+		want   []string
+	}{
+		{person, `{"name": "Ada", "born": 1815, "tags": ["math"]}`, nil},
+		{person, "```json\n{\"name\": \"Ada\", \"born\": 1815}\n```", nil},
+		{person, `{"name": "", "born": 1815.5, "tags": ["math", "chess"]}`, []string{"/born", "/name", "/tags/1"}},
+		{person, `{"name": "Ada"}`, []string{"/: missing properties: 'born'"}},
+		{person, `{"name": "Ada", "born": 1815, "extra": 1}`, []string{"/: additionalProperties 'extra' not allowed"}},
+		{person, `not json`, []string{"not JSON"}},
+		{list, `[1]`, nil},
+		{list, `[1, 2]`, []string{"/: maximum 1 items"}},
+	}
+	for _, tt := range tests {
+		failures := tt.expect.check(tt.text, "stop", 0)
+		if len(failures) != len(tt.want) {
+			t.Errorf("%s: got %q, want %d failures", tt.text, failures, len(tt.want))
+			continue
+		}
+		joined := strings.Join(failures, "\n")
+		for _, want := range tt.want {
+			if !strings.Contains(joined, want) {
+				t.Errorf("%s: %q doesn't mention %q", tt.text, failures, want)
+			}
+		}
+		for _, failure := range failures {
+			if !strings.HasPrefix(failure, "json_schema: ") {
+				t.Errorf("%s: failure %q not attributed to json_schema", tt.text, failure)
+			}
+		}
+	}
+
+	// The inline schema survives the round trip through the suite encoding
+	var inline map[string]interface{}
+	if err := json.Unmarshal(suite.Cases[0].Expect.JSONSchema, &inline); err != nil || inline["type"] != "object" {
+		t.Errorf("inline schema %s", suite.Cases[0].Expect.JSONSchema)
+	}
+}
@@ -0,0 +1,149 @@
+// Package experiment runs A/B experiments on models: a share of the requests
+// for a model is served by another one, and the responses are tagged with
+// the variant so the two can be compared.
+package experiment
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"math/rand/v2"
+	"os"
+)
+
+// Control is the variant of requests left on the model they asked for
+const Control = "control"
+
+// Config is the on-disk format of EXPERIMENTS_FILE
+type Config struct {
+	Experiments []Spec `json:"experiments"`
+}
+
+// Spec configures an experiment on the requests for Model
+type Spec struct {
+	Name string `json:"name"`
+	// Model is the model name clients ask for
+	Model string `json:"model"`
+	// Variants take their percent of the requests, the rest is the control
+	Variants []Variant `json:"variants"`
+	// Sticky keeps each API key on one variant, so a user sees consistent
+	// behaviour; otherwise every request is assigned anew
+	Sticky bool `json:"sticky,omitempty"`
+}
+
+// Variant serves Percent of the requests of an experiment with Model
+type Variant struct {
+	Name    string  `json:"name"`
+	Model   string  `json:"model"`
+	Percent float64 `json:"percent"`
+}
+
+// Assignment is the variant a request was assigned to
+type Assignment struct {
+	Experiment string `json:"experiment"`
+	Variant    string `json:"variant"`
+	// Model serves the request
+	Model string `json:"-"`
+}
+
+// Set holds the experiments by model. A nil Set runs none.
+type Set struct {
+	byModel map[string]Spec
+}
+
+// Load reads the experiments file at path. With an empty path it returns nil.
+func Load(path string) (*Set, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read experiments file: %w", err)
+	}
+	var cfg Config
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse experiments file: %w", err)
+	}
+	return New(cfg)
+}
+
+// New validates a configuration
+func New(cfg Config) (*Set, error) {
+	s := &Set{byModel: make(map[string]Spec, len(cfg.Experiments))}
+	names := make(map[string]bool)
+	for i, spec := range cfg.Experiments {
+		if spec.Name == "" {
+			return nil, fmt.Errorf("experiment %d: name is required", i+1)
+		}
+		if names[spec.Name] {
+			return nil, fmt.Errorf("duplicate experiment name %q", spec.Name)
+		}
+		if spec.Model == "" {
+			return nil, fmt.Errorf("experiment %s: model is required", spec.Name)
+		}
+		if other, ok := s.byModel[spec.Model]; ok {
+			return nil, fmt.Errorf("experiment %s: model %q is already in experiment %s", spec.Name, spec.Model, other.Name)
+		}
+		if len(spec.Variants) == 0 {
+			return nil, fmt.Errorf("experiment %s: variants are required", spec.Name)
+		}
+
+		total := 0.0
+		variants := make(map[string]bool)
+		for _, v := range spec.Variants {
+			switch {
+			case v.Name == "" || v.Model == "":
+				return nil, fmt.Errorf("experiment %s: variants need a name and a model", spec.Name)
+			case v.Name == Control || variants[v.Name]:
+				return nil, fmt.Errorf("experiment %s: variant name %q is taken", spec.Name, v.Name)
+			case v.Percent <= 0:
+				return nil, fmt.Errorf("experiment %s: variant %s needs a percent above 0", spec.Name, v.Name)
+			}
+			variants[v.Name] = true
+			total += v.Percent
+		}
+		if total > 100 {
+			return nil, fmt.Errorf("experiment %s: variants take %v%% of the requests, more than 100", spec.Name, total)
+		}
+
+		names[spec.Name] = true
+		s.byModel[spec.Model] = spec
+	}
+	return s, nil
+}
+
+// Len returns the number of experiments
+func (s *Set) Len() int {
+	if s == nil {
+		return 0
+	}
+	return len(s.byModel)
+}
+
+// Assign picks the variant of a request for model. subject identifies the
+// caller for sticky experiments; without one the request is assigned at
+// random. ok is false when model is in no experiment.
+func (s *Set) Assign(model, subject string) (Assignment, bool) {
+	if s == nil {
+		return Assignment{}, false
+	}
+	spec, ok := s.byModel[model]
+	if !ok {
+		return Assignment{}, false
+	}
+
+	point := rand.Float64() * 100
+	if spec.Sticky && subject != "" {
+		h := fnv.New64a()
+		h.Write([]byte(spec.Name + "\x00" + subject))
+		point = float64(h.Sum64()%10000) / 100
+	}
+	for _, v := range spec.Variants {
+		if point < v.Percent {
+			return Assignment{Experiment: spec.Name, Variant: v.Name, Model: v.Model}, true
+		}
+		point -= v.Percent
+	}
+	return Assignment{Experiment: spec.Name, Variant: Control, Model: model}, true
+}
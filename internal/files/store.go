@@ -0,0 +1,254 @@
+package files
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrNotFound is returned for unknown file IDs
+var ErrNotFound = errors.New("file not found")
+
+// ErrTooLarge is returned when an upload exceeds the size limit
+var ErrTooLarge = errors.New("file is too large")
+
+// File purposes
+const (
+	PurposeBatch       = "batch"
+	PurposeBatchOutput = "batch_output"
+	PurposeFineTune    = "fine-tune"
+	PurposeAssistants  = "assistants"
+	PurposeVision      = "vision"
+	PurposeUserData    = "user_data"
+	PurposeEvals       = "evals"
+)
+
+// UploadPurposes are the purposes clients can upload files for. Other
+// purposes, like batch_output, are only used for files the server creates.
+var UploadPurposes = []string{PurposeBatch, PurposeFineTune, PurposeAssistants, PurposeVision, PurposeUserData, PurposeEvals}
+
+// ValidUploadPurpose reports whether clients can upload files for purpose
+func ValidUploadPurpose(purpose string) bool {
+	for _, p := range UploadPurposes {
+		if p == purpose {
+			return true
+		}
+	}
+	return false
+}
+
+// ListOptions selects and pages the files returned by List
+type ListOptions struct {
+	// Purpose only returns files with this purpose (all when empty)
+	Purpose string
+	// After is the ID of the last file of the previous page
+	After string
+	// Limit caps the files returned (all when 0)
+	Limit int
+	// Ascending sorts oldest first instead of newest first
+	Ascending bool
+}
+
+// File describes a stored file in the OpenAI files format
+type File struct {
+	ID        string `json:"id"`
+	Object    string `json:"object"`
+	Bytes     int64  `json:"bytes"`
+	CreatedAt int64  `json:"created_at"`
+	Filename  string `json:"filename"`
+	Purpose   string `json:"purpose"`
+	Owner     string `json:"owner,omitempty"`
+}
+
+// Store keeps file metadata in memory and the files under a directory, each
+// as a data file next to a JSON metadata file
+type Store struct {
+	mutex   sync.RWMutex
+	dir     string
+	maxSize int64
+	files   map[string]*File
+}
+
+// Open loads the files stored in dir, creating it if needed. Uploads larger
+// than maxSize bytes are rejected.
+func Open(dir string, maxSize int64) (*Store, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create files directory: %w", err)
+	}
+
+	s := &Store{dir: dir, maxSize: maxSize, files: make(map[string]*File)}
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read file metadata: %w", err)
+		}
+		var f File
+		if err := json.Unmarshal(data, &f); err != nil {
+			return nil, fmt.Errorf("failed to parse file metadata %s: %w", filepath.Base(path), err)
+		}
+		s.files[f.ID] = &f
+	}
+	return s, nil
+}
+
+// NewID returns a new random file ID
+func NewID() string {
+	buf := make([]byte, 12)
+	rand.Read(buf)
+	return "file-" + hex.EncodeToString(buf)
+}
+
+// Create stores the content of r as a new file belonging to owner
+func (s *Store) Create(owner, filename, purpose string, r io.Reader) (*File, error) {
+	f := &File{
+		ID:        NewID(),
+		Object:    "file",
+		CreatedAt: time.Now().Unix(),
+		Filename:  filepath.Base(filename),
+		Purpose:   purpose,
+		Owner:     owner,
+	}
+
+	tmp := s.dataPath(f.ID) + ".tmp"
+	out, err := os.OpenFile(tmp, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create file: %w", err)
+	}
+	f.Bytes, err = io.Copy(out, io.LimitReader(r, s.maxSize+1))
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && f.Bytes > s.maxSize {
+		err = ErrTooLarge
+	}
+	if err == nil {
+		err = os.Rename(tmp, s.dataPath(f.ID))
+	}
+	if err != nil {
+		os.Remove(tmp)
+		if errors.Is(err, ErrTooLarge) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to write file: %w", err)
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if err := s.writeMetadata(f); err != nil {
+		os.Remove(s.dataPath(f.ID))
+		return nil, err
+	}
+	s.files[f.ID] = f
+	copied := *f
+	return &copied, nil
+}
+
+// Get returns the metadata of a file belonging to owner
+func (s *Store) Get(owner, id string) (*File, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	f, ok := s.files[id]
+	if !ok || f.Owner != owner {
+		return nil, ErrNotFound
+	}
+	copied := *f
+	return &copied, nil
+}
+
+// Open returns the content of a file belonging to owner
+func (s *Store) Open(owner, id string) (io.ReadCloser, error) {
+	if _, err := s.Get(owner, id); err != nil {
+		return nil, err
+	}
+	content, err := os.Open(s.dataPath(id))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	return content, err
+}
+
+// List returns a page of the files belonging to owner and whether more
+// files follow it
+func (s *Store) List(owner string, opts ListOptions) ([]File, bool) {
+	s.mutex.RLock()
+	var list []File
+	for _, f := range s.files {
+		if f.Owner == owner && (opts.Purpose == "" || f.Purpose == opts.Purpose) {
+			list = append(list, *f)
+		}
+	}
+	s.mutex.RUnlock()
+
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].CreatedAt != list[j].CreatedAt {
+			return (list[i].CreatedAt < list[j].CreatedAt) == opts.Ascending
+		}
+		return (list[i].ID < list[j].ID) == opts.Ascending
+	})
+	if opts.After != "" {
+		for i, f := range list {
+			if f.ID == opts.After {
+				list = list[i+1:]
+				break
+			}
+		}
+	}
+	if opts.Limit > 0 && len(list) > opts.Limit {
+		return list[:opts.Limit], true
+	}
+	return list, false
+}
+
+// Delete removes a file belonging to owner
+func (s *Store) Delete(owner, id string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	f, ok := s.files[id]
+	if !ok || f.Owner != owner {
+		return ErrNotFound
+	}
+	for _, path := range []string{s.metadataPath(id), s.dataPath(id)} {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	delete(s.files, id)
+	return nil
+}
+
+// writeMetadata persists the metadata of f; the caller holds the write lock
+func (s *Store) writeMetadata(f *File) error {
+	data, err := json.Marshal(f)
+	if err != nil {
+		return err
+	}
+	tmp := s.metadataPath(f.ID) + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("failed to write file metadata: %w", err)
+	}
+	if err := os.Rename(tmp, s.metadataPath(f.ID)); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write file metadata: %w", err)
+	}
+	return nil
+}
+
+func (s *Store) metadataPath(id string) string {
+	return filepath.Join(s.dir, id+".json")
+}
+
+func (s *Store) dataPath(id string) string {
+	return filepath.Join(s.dir, id+".data")
+}
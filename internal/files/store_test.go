@@ -0,0 +1,125 @@
+package files
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestStore(t *testing.T) {
+	dir := t.TempDir()
+	store, err := Open(dir, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := store.Create("alice", "dir/input.jsonl", PurposeBatch, strings.NewReader(`{"a":1}`+"\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Filename != "input.jsonl" || f.Bytes != 8 || f.Purpose != PurposeBatch || f.Object != "file" {
+		t.Errorf("created %+v", f)
+	}
+
+	content, err := store.Open("alice", f.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(content)
+	content.Close()
+	if string(data) != `{"a":1}`+"\n" {
+		t.Errorf("content %q", data)
+	}
+
+	// Other owners don't see the file
+	if _, err := store.Get("bob", f.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("other owner: %v", err)
+	}
+	if _, err := store.Open("bob", f.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("other owner content: %v", err)
+	}
+	if err := store.Delete("bob", f.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("other owner delete: %v", err)
+	}
+
+	if _, err := store.Create("alice", "big.jsonl", PurposeBatch, strings.NewReader(strings.Repeat("x", 65))); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("oversized upload: %v", err)
+	}
+	if list, _ := store.List("alice", ListOptions{}); len(list) != 1 {
+		t.Errorf("oversized upload kept: %+v", list)
+	}
+
+	// The files survive a restart
+	reopened, err := Open(dir, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := reopened.Get("alice", f.ID); err != nil || *got != *f {
+		t.Errorf("after reopening: %+v, %v", got, err)
+	}
+
+	if err := reopened.Delete("alice", f.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := reopened.Open("alice", f.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("deleted file: %v", err)
+	}
+	if again, _ := Open(dir, 64); len(again.files) != 0 {
+		t.Errorf("deleted file loaded again: %v", again.files)
+	}
+}
+
+func TestStoreList(t *testing.T) {
+	store, err := Open(t.TempDir(), 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for i, purpose := range []string{PurposeBatch, PurposeEvals, PurposeBatch, PurposeBatch} {
+		f, err := store.Create("alice", "f", purpose, strings.NewReader("x"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Files created within the same second are ordered by ID
+		f.CreatedAt = int64(100 + i)
+		store.files[f.ID].CreatedAt = f.CreatedAt
+		ids = append(ids, f.ID)
+	}
+	store.Create("bob", "f", PurposeBatch, strings.NewReader("x"))
+
+	listIDs := func(list []File) []string {
+		var got []string
+		for _, f := range list {
+			got = append(got, f.ID)
+		}
+		return got
+	}
+	tests := []struct {
+		name string
+		opts ListOptions
+		want []string
+		more bool
+	}{
+		{"newest first", ListOptions{}, []string{ids[3], ids[2], ids[1], ids[0]}, false},
+		{"oldest first", ListOptions{Ascending: true}, ids, false},
+		{"purpose", ListOptions{Purpose: PurposeBatch}, []string{ids[3], ids[2], ids[0]}, false},
+		{"first page", ListOptions{Limit: 2}, []string{ids[3], ids[2]}, true},
+		{"next page", ListOptions{Limit: 2, After: ids[2]}, []string{ids[1], ids[0]}, false},
+		{"ascending page", ListOptions{Limit: 1, After: ids[1], Ascending: true}, []string{ids[2]}, true},
+	}
+	for _, tt := range tests {
+		list, more := store.List("alice", tt.opts)
+		if got := listIDs(list); strings.Join(got, ",") != strings.Join(tt.want, ",") || more != tt.more {
+			t.Errorf("%s: got %v more=%v, want %v more=%v", tt.name, got, more, tt.want, tt.more)
+		}
+	}
+}
+
+func TestValidUploadPurpose(t *testing.T) {
+	for purpose, want := range map[string]bool{PurposeBatch: true, PurposeFineTune: true, PurposeBatchOutput: false, "": false} {
+		if got := ValidUploadPurpose(purpose); got != want {
+			t.Errorf("%q: got %v, want %v", purpose, got, want)
+		}
+	}
+}
@@ -0,0 +1,317 @@
+package filter
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/devstroop/reai/internal/metrics"
+)
+
+// Directions a filter runs in
+const (
+	DirectionPrompt     = "prompt"
+	DirectionCompletion = "completion"
+)
+
+// Actions taken when a filter matches
+const (
+	ActionBlock  = "block"
+	ActionRedact = "redact"
+)
+
+// DefaultReplacement replaces redacted matches
+const DefaultReplacement = "[REDACTED]"
+
+// ErrBlocked is returned when a blocking filter matches
+var ErrBlocked = errors.New("content blocked by filter")
+
+// BlockedError identifies the filter that blocked content
+type BlockedError struct {
+	Filter    string
+	Direction string
+}
+
+func (e *BlockedError) Error() string {
+	return fmt.Sprintf("%s blocked by %s filter", e.Direction, e.Filter)
+}
+
+func (e *BlockedError) Unwrap() error {
+	return ErrBlocked
+}
+
+var filterMatches = metrics.NewCounterVec("reai_filter_matches_total", "Content filter matches", "filter", "direction", "action")
+
+// Filter inspects and rewrites text
+type Filter struct {
+	name        string
+	action      string
+	replacement string
+	patterns    []*regexp.Regexp
+	validate    func(match string) bool
+	// multiline are the patterns that span lines, with their opening markers
+	multiline []multilinePattern
+}
+
+// Name returns the filter name used in logs and metrics
+func (f *Filter) Name() string {
+	return f.name
+}
+
+// multilinePattern is a pattern spanning lines and the marker it starts with
+type multilinePattern struct {
+	pattern *regexp.Regexp
+	open    *regexp.Regexp
+}
+
+// unclosed returns the offset of the first opening marker before end in
+// text whose pattern doesn't match from it up to end, or -1
+func (f *Filter) unclosed(text string, end int) int {
+	for _, m := range f.multiline {
+		for _, loc := range m.open.FindAllStringIndex(text[:end], -1) {
+			match := m.pattern.FindStringIndex(text[loc[0]:])
+			if match == nil || match[0] != 0 || loc[0]+match[1] > end {
+				return loc[0]
+			}
+		}
+	}
+	return -1
+}
+
+// apply redacts matches or reports whether a blocking filter matched
+func (f *Filter) apply(text string) (string, bool) {
+	matched := false
+	for _, pattern := range f.patterns {
+		text = pattern.ReplaceAllStringFunc(text, func(match string) string {
+			if f.validate != nil && !f.validate(match) {
+				return match
+			}
+			matched = true
+			if f.action == ActionRedact {
+				return f.replacement
+			}
+			return match
+		})
+	}
+	return text, matched
+}
+
+// Pipeline runs filters over prompts and completions
+type Pipeline struct {
+	prompt     []*Filter
+	completion []*Filter
+}
+
+// Config is the on-disk format of FILTERS_FILE
+type Config struct {
+	Prompt     []Spec `json:"prompt"`
+	Completion []Spec `json:"completion"`
+}
+
+// Spec configures a single filter
+type Spec struct {
+	// Type is one of "regex", "secrets" or "pii"
+	Type        string   `json:"type"`
+	Name        string   `json:"name,omitempty"`
+	Action      string   `json:"action,omitempty"`
+	Replacement string   `json:"replacement,omitempty"`
+	Patterns    []string `json:"patterns,omitempty"`
+}
+
+// Load builds a pipeline from a JSON filters file. An empty path yields an empty pipeline.
+func Load(path string) (*Pipeline, error) {
+	if path == "" {
+		return &Pipeline{}, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read filters file: %w", err)
+	}
+	var cfg Config
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse filters file: %w", err)
+	}
+	return New(cfg)
+}
+
+// New builds a pipeline from a configuration
+func New(cfg Config) (*Pipeline, error) {
+	p := &Pipeline{}
+	for _, spec := range cfg.Prompt {
+		f, err := newFilter(spec)
+		if err != nil {
+			return nil, fmt.Errorf("prompt filter: %w", err)
+		}
+		p.prompt = append(p.prompt, f)
+	}
+	for _, spec := range cfg.Completion {
+		f, err := newFilter(spec)
+		if err != nil {
+			return nil, fmt.Errorf("completion filter: %w", err)
+		}
+		p.completion = append(p.completion, f)
+	}
+	return p, nil
+}
+
+func newFilter(spec Spec) (*Filter, error) {
+	f := &Filter{
+		name:        spec.Name,
+		action:      spec.Action,
+		replacement: spec.Replacement,
+	}
+	if f.action == "" {
+		f.action = ActionRedact
+	}
+	if f.action != ActionRedact && f.action != ActionBlock {
+		return nil, fmt.Errorf("unknown action %q", spec.Action)
+	}
+	if f.replacement == "" {
+		f.replacement = DefaultReplacement
+	}
+
+	var patterns []string
+	switch spec.Type {
+	case "regex":
+		if len(spec.Patterns) == 0 {
+			return nil, fmt.Errorf("regex filter requires patterns")
+		}
+		patterns = spec.Patterns
+	case "secrets":
+		patterns = append(append([]string{}, secretPatterns...), spec.Patterns...)
+	case "pii":
+		patterns = append(append([]string{}, piiPatterns...), spec.Patterns...)
+		f.validate = validatePII
+	default:
+		return nil, fmt.Errorf("unknown filter type %q", spec.Type)
+	}
+	if f.name == "" {
+		f.name = spec.Type
+	}
+
+	for _, pattern := range patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+		f.patterns = append(f.patterns, re)
+		if open, ok := multilineOpeners[pattern]; ok {
+			f.multiline = append(f.multiline, multilinePattern{pattern: re, open: regexp.MustCompile(open)})
+		}
+	}
+	return f, nil
+}
+
+// Empty reports whether the pipeline has no filters
+func (p *Pipeline) Empty() bool {
+	return len(p.prompt) == 0 && len(p.completion) == 0
+}
+
+// HasCompletionFilters reports whether completions are filtered
+func (p *Pipeline) HasCompletionFilters() bool {
+	return len(p.completion) > 0
+}
+
+// FilterPrompt runs the prompt filters. A *BlockedError is returned when a blocking filter matches.
+func (p *Pipeline) FilterPrompt(text string) (string, error) {
+	return run(p.prompt, DirectionPrompt, text)
+}
+
+// FilterCompletion runs the completion filters. A *BlockedError is returned when a blocking filter matches.
+func (p *Pipeline) FilterCompletion(text string) (string, error) {
+	return run(p.completion, DirectionCompletion, text)
+}
+
+func run(filters []*Filter, direction, text string) (string, error) {
+	for _, f := range filters {
+		filtered, matched := f.apply(text)
+		if !matched {
+			continue
+		}
+		filterMatches.With(f.name, direction, f.action).Inc()
+		if f.action == ActionBlock {
+			return "", &BlockedError{Filter: f.name, Direction: direction}
+		}
+		text = filtered
+	}
+	return text, nil
+}
+
+// maxHeldBack bounds the text held back for a multiline pattern, like a PEM
+// private key, whose end doesn't arrive
+const maxHeldBack = 64 << 10
+
+// StreamFilter filters streamed completion text line by line, so patterns
+// split across chunks are still caught. Text is held back until a newline,
+// and from the opening marker of a pattern spanning lines, like a PEM
+// private key, until the pattern matches (or maxHeldBack is reached).
+type StreamFilter struct {
+	pipeline *Pipeline
+	pending  strings.Builder
+	redacted bool
+}
+
+// NewStreamFilter creates a line buffered completion filter
+func (p *Pipeline) NewStreamFilter() *StreamFilter {
+	return &StreamFilter{pipeline: p}
+}
+
+// Write adds streamed text and returns the filtered complete lines, if any
+func (s *StreamFilter) Write(text string) (string, error) {
+	s.pending.WriteString(text)
+	buffered := s.pending.String()
+
+	cut := strings.LastIndexByte(buffered, '\n')
+	if cut < 0 {
+		return "", nil
+	}
+	if open := s.unclosed(buffered, cut+1); open >= 0 && len(buffered) <= maxHeldBack {
+		// Only the lines before the one with the marker are released
+		if cut = strings.LastIndexByte(buffered[:open], '\n'); cut < 0 {
+			return "", nil
+		}
+	}
+
+	s.pending.Reset()
+	s.pending.WriteString(buffered[cut+1:])
+	return s.filter(buffered[:cut+1])
+}
+
+// unclosed returns the offset of the first opening marker of a multiline
+// completion filter pattern that isn't matched by the lines up to end, or -1
+func (s *StreamFilter) unclosed(text string, end int) int {
+	first := -1
+	for _, f := range s.pipeline.completion {
+		if open := f.unclosed(text, end); open >= 0 && (first < 0 || open < first) {
+			first = open
+		}
+	}
+	return first
+}
+
+// Flush filters and returns any remaining buffered text
+func (s *StreamFilter) Flush() (string, error) {
+	buffered := s.pending.String()
+	s.pending.Reset()
+	if buffered == "" {
+		return "", nil
+	}
+	return s.filter(buffered)
+}
+
+// Redacted reports whether any of the text passed through was changed
+func (s *StreamFilter) Redacted() bool {
+	return s.redacted
+}
+
+func (s *StreamFilter) filter(text string) (string, error) {
+	filtered, err := s.pipeline.FilterCompletion(text)
+	if err == nil && filtered != text {
+		s.redacted = true
+	}
+	return filtered, err
+}
@@ -0,0 +1,80 @@
+package filter
+
+import (
+	"regexp"
+	"strings"
+)
+
+// pemPrivateKey matches a PEM private key, which spans several lines
+const pemPrivateKey = `-----BEGIN (?:[A-Z]+ )?PRIVATE KEY-----[\s\S]*?-----END (?:[A-Z]+ )?PRIVATE KEY-----`
+
+// multilineOpeners are the opening markers of the patterns that span lines.
+// Streamed text is held back from an opening marker until the pattern
+// matches, since filtering line by line would never see the whole match.
+var multilineOpeners = map[string]string{
+	pemPrivateKey: `-----BEGIN (?:[A-Z]+ )?PRIVATE KEY-----`,
+}
+
+// secretPatterns match common credential formats
+var secretPatterns = []string{
+	// GitHub tokens
+	`\b(?:ghp|gho|ghu|ghs|ghr)_[A-Za-z0-9]{36,}\b`,
+	`\bgithub_pat_[A-Za-z0-9_]{22,}\b`,
+	// AWS access key IDs
+	`\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`,
+	// Slack tokens
+	`\bxox[abprs]-[A-Za-z0-9-]{10,}\b`,
+	// OpenAI / Anthropic style API keys
+	`\bsk-[A-Za-z0-9_-]{20,}\b`,
+	// Google API keys
+	`\bAIza[0-9A-Za-z_-]{35}\b`,
+	// JSON Web Tokens
+	`\beyJ[A-Za-z0-9_-]{10,}\.eyJ[A-Za-z0-9_-]{10,}\.[A-Za-z0-9_-]{10,}\b`,
+	// PEM private keys
+	pemPrivateKey,
+	// Generic assignments such as password = "..." or api_key: ...
+	`(?i)\b(?:password|passwd|secret|api[_-]?key|access[_-]?token|auth[_-]?token)\b\s*[:=]\s*["']?[^\s"']{8,}`,
+}
+
+// piiPatterns match personal data
+var piiPatterns = []string{
+	// Email addresses
+	`\b[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}\b`,
+	// Payment card numbers (validated with the Luhn check)
+	`\b\d(?:[ -]?\d){12,18}\b`,
+	// US social security numbers
+	`\b\d{3}-\d{2}-\d{4}\b`,
+	// International phone numbers
+	`\+\d{1,3}[ -]?\(?\d{1,4}\)?(?:[ -]?\d{2,4}){2,4}\b`,
+}
+
+var nonDigits = regexp.MustCompile(`\D`)
+
+// validatePII rejects digit runs that look like card numbers but fail the Luhn check
+func validatePII(match string) bool {
+	if strings.ContainsAny(match, "@+") || strings.Count(match, "-") == 2 && len(match) == 11 {
+		return true
+	}
+	digits := nonDigits.ReplaceAllString(match, "")
+	if len(digits) < 13 || len(digits) > 19 {
+		return true
+	}
+	return luhn(digits)
+}
+
+func luhn(digits string) bool {
+	sum := 0
+	double := false
+	for i := len(digits) - 1; i >= 0; i-- {
+		d := int(digits[i] - '0')
+		if double {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		double = !double
+	}
+	return sum%10 == 0
+}
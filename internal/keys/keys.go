@@ -0,0 +1,268 @@
+package keys
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/devstroop/reai/internal/queue"
+	"github.com/devstroop/reai/internal/quota"
+	"github.com/devstroop/reai/internal/store"
+)
+
+// Parameters are request parameters an operator can bind to a key
+type Parameters struct {
+	Model        string   `json:"model,omitempty"`
+	Temperature  *float64 `json:"temperature,omitempty"`
+	SystemPrompt string   `json:"system_prompt,omitempty"`
+}
+
+// Key is an API key accepted by the server
+type Key struct {
+	// ID identifies the key in logs and metrics without revealing the secret
+	ID     string `json:"id"`
+	Secret string `json:"key"`
+	Name   string `json:"name,omitempty"`
+
+	// Defaults apply when the client does not set a parameter
+	Defaults *Parameters `json:"defaults,omitempty"`
+	// Overrides always replace what the client sent
+	Overrides *Parameters `json:"overrides,omitempty"`
+	// Limits caps requests and tokens per minute (unlimited when nil)
+	Limits *quota.Limits `json:"limits,omitempty"`
+	// Priority is the queue class of the key's requests: high, normal (default) or low
+	Priority queue.Priority `json:"priority,omitempty"`
+
+	// MaxTokens caps max_tokens of the key's completions, and applies when
+	// the client sets none (no cap when 0)
+	MaxTokens int `json:"max_tokens,omitempty"`
+	// MaxDuration bounds how long a completion of the key runs, as a Go
+	// duration (unbounded when empty)
+	MaxDuration string `json:"max_duration,omitempty"`
+	maxDuration time.Duration
+
+	// Decoy keys are never valid; any use means the key list leaked and raises an alert
+	Decoy bool `json:"decoy,omitempty"`
+
+	// Created is set on keys created through the admin API, which are kept
+	// in the database rather than the keys file
+	Created *time.Time `json:"created,omitempty"`
+}
+
+// ErrNotFound is returned for unknown stored key IDs
+var ErrNotFound = errors.New("API key not found")
+
+// Store holds the configured API keys
+type Store struct {
+	keys []*Key
+}
+
+// keysFile is the on-disk format of API_KEYS_FILE
+type keysFile struct {
+	Keys []*Key `json:"keys"`
+}
+
+// Load builds the key store from a JSON keys file, a comma separated list of
+// plain keys and the keys created through the admin API, kept in db. An
+// empty store disables authentication.
+func Load(path, list string, db *store.DB) (*Store, error) {
+	store := &Store{}
+
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read keys file: %w", err)
+		}
+		var file keysFile
+		if err := json.Unmarshal(data, &file); err != nil {
+			return nil, fmt.Errorf("failed to parse keys file: %w", err)
+		}
+		for i, key := range file.Keys {
+			if key.Secret == "" {
+				return nil, fmt.Errorf("key %d in %s has no secret", i, path)
+			}
+			if err := key.Validate(); err != nil {
+				return nil, fmt.Errorf("key %d in %s: %w", i, path, err)
+			}
+			store.add(key)
+		}
+	}
+
+	for _, secret := range strings.Split(list, ",") {
+		if secret = strings.TrimSpace(secret); secret != "" {
+			store.add(&Key{Secret: secret, Priority: queue.Normal})
+		}
+	}
+
+	if db != nil {
+		stored, err := loadStored(db)
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range stored {
+			store.add(key)
+		}
+	}
+
+	return store, nil
+}
+
+// loadStored reads the keys created through the admin API
+func loadStored(db *store.DB) ([]*Key, error) {
+	rows, err := db.Query("SELECT data FROM api_keys ORDER BY created, id")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read stored keys: %w", err)
+	}
+	defer rows.Close()
+	var stored []*Key
+	for rows.Next() {
+		var data []byte
+		if err := rows.Scan(&data); err != nil {
+			return nil, fmt.Errorf("failed to read stored keys: %w", err)
+		}
+		var key Key
+		if err := json.Unmarshal(data, &key); err != nil {
+			return nil, fmt.Errorf("failed to parse stored key: %w", err)
+		}
+		if err := key.Validate(); err != nil {
+			return nil, fmt.Errorf("stored key %s: %w", key.ID, err)
+		}
+		stored = append(stored, &key)
+	}
+	return stored, rows.Err()
+}
+
+// Create generates the secret of key and stores it in db. The key takes
+// effect once the key store is loaded again.
+func Create(db *store.DB, key Key) (*Key, error) {
+	secret := make([]byte, 24)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+	key.Secret = "sk-reai-" + hex.EncodeToString(secret)
+	if key.ID == "" {
+		key.ID = Fingerprint(key.Secret)
+	}
+	if err := key.Validate(); err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC().Truncate(time.Second)
+	key.Created = &now
+
+	data, err := json.Marshal(&key)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.Exec("INSERT INTO api_keys (id, created, data) VALUES (?, ?, ?)", key.ID, now.Unix(), string(data)); err != nil {
+		return nil, fmt.Errorf("failed to store key: %w", err)
+	}
+	return &key, nil
+}
+
+// Delete removes the key created through the admin API with id from db
+func Delete(db *store.DB, id string) error {
+	result, err := db.Exec("DELETE FROM api_keys WHERE id = ?", id)
+	if err != nil {
+		return fmt.Errorf("failed to delete key: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Validate checks the settings of k and parses its priority and maximum
+// duration
+func (k *Key) Validate() error {
+	priority, err := queue.ParsePriority(string(k.Priority))
+	if err != nil {
+		return err
+	}
+	k.Priority = priority
+	if k.MaxTokens < 0 {
+		return fmt.Errorf("max_tokens must not be negative")
+	}
+	k.maxDuration = 0
+	if k.MaxDuration != "" {
+		if k.maxDuration, err = time.ParseDuration(k.MaxDuration); err != nil || k.maxDuration <= 0 {
+			return fmt.Errorf("invalid max_duration %q", k.MaxDuration)
+		}
+	}
+	return nil
+}
+
+// MaxDurationLimit returns how long a completion of the key may run, 0 when
+// unbounded
+func (k *Key) MaxDurationLimit() time.Duration {
+	return k.maxDuration
+}
+
+func (s *Store) add(key *Key) {
+	if key.ID == "" {
+		key.ID = Fingerprint(key.Secret)
+	}
+	s.keys = append(s.keys, key)
+}
+
+// Enabled reports whether any keys are configured
+func (s *Store) Enabled() bool {
+	return len(s.keys) > 0
+}
+
+// Len returns the number of configured keys
+func (s *Store) Len() int {
+	return len(s.keys)
+}
+
+// Lookup finds the key matching secret using constant time comparisons
+func (s *Store) Lookup(secret string) (*Key, bool) {
+	var found *Key
+	for _, key := range s.keys {
+		if subtle.ConstantTimeCompare([]byte(key.Secret), []byte(secret)) == 1 {
+			found = key
+		}
+	}
+	return found, found != nil
+}
+
+// All returns the configured keys
+func (s *Store) All() []*Key {
+	return s.keys
+}
+
+// ByID finds a key by its ID
+func (s *Store) ByID(id string) (*Key, bool) {
+	for _, key := range s.keys {
+		if key.ID == id {
+			return key, true
+		}
+	}
+	return nil, false
+}
+
+// Fingerprint returns a short non-reversible identifier for a secret
+func Fingerprint(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return "key_" + hex.EncodeToString(sum[:6])
+}
+
+type contextKey struct{}
+
+// WithKey returns a context carrying the authenticated key
+func WithKey(ctx context.Context, key *Key) context.Context {
+	return context.WithValue(ctx, contextKey{}, key)
+}
+
+// FromContext returns the authenticated key, if any
+func FromContext(ctx context.Context) *Key {
+	key, _ := ctx.Value(contextKey{}).(*Key)
+	return key
+}
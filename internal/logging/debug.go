@@ -0,0 +1,47 @@
+package logging
+
+import (
+	"context"
+	"log/slog"
+)
+
+type debugKey struct{}
+
+// WithDebug turns on debug logging for everything logged with ctx
+func WithDebug(ctx context.Context) context.Context {
+	return context.WithValue(ctx, debugKey{}, true)
+}
+
+// Debugging reports whether ctx carries per-request debug logging
+func Debugging(ctx context.Context) bool {
+	debug, _ := ctx.Value(debugKey{}).(bool)
+	return debug
+}
+
+// Handler filters records below level, except those logged with a context
+// from WithDebug. The handler it wraps must let debug records through.
+type Handler struct {
+	slog.Handler
+	level slog.Leveler
+}
+
+// NewHandler wraps h so that records below level are dropped unless their
+// request is being debugged
+func NewHandler(h slog.Handler, level slog.Leveler) *Handler {
+	return &Handler{Handler: h, level: level}
+}
+
+func (h *Handler) Enabled(ctx context.Context, level slog.Level) bool {
+	if level < h.level.Level() && (ctx == nil || !Debugging(ctx)) {
+		return false
+	}
+	return h.Handler.Enabled(ctx, level)
+}
+
+func (h *Handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &Handler{Handler: h.Handler.WithAttrs(attrs), level: h.level}
+}
+
+func (h *Handler) WithGroup(name string) slog.Handler {
+	return &Handler{Handler: h.Handler.WithGroup(name), level: h.level}
+}
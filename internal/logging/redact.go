@@ -0,0 +1,95 @@
+// Package logging masks secrets and user content in log records, so logs can
+// be shared or shipped without leaking credentials or prompts.
+package logging
+
+import (
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strings"
+)
+
+// Redacted replaces masked values
+const Redacted = "[REDACTED]"
+
+// secretKeys are attributes whose value is always masked
+var secretKeys = map[string]bool{
+	"token":          true,
+	"access_token":   true,
+	"session_token":  true,
+	"refresh_token":  true,
+	"authorization":  true,
+	"api_key":        true,
+	"secret":         true,
+	"client_secret":  true,
+	"password":       true,
+	"webhook_secret": true,
+}
+
+// contentKeys are attributes carrying prompts or model output, masked unless
+// LOG_SENSITIVE is set
+var contentKeys = map[string]bool{
+	"prompt":     true,
+	"messages":   true,
+	"content":    true,
+	"completion": true,
+	"response":   true,
+	"text":       true,
+	"body":       true,
+}
+
+// secretPatterns find credentials inside any logged string, e.g. an error
+// quoting a request or response
+var secretPatterns = []*regexp.Regexp{
+	// GitHub OAuth, app and personal access tokens
+	regexp.MustCompile(`\b(gh[oprsu]_[A-Za-z0-9]{20,}|github_pat_[A-Za-z0-9_]{20,})`),
+	// Copilot session tokens: "tid=...;exp=...;...:signature"
+	regexp.MustCompile(`\btid=[^\s",]+`),
+	// Authorization header values
+	regexp.MustCompile(`(?i)\bbearer\s+[^\s",]+`),
+}
+
+// Redact returns a slog ReplaceAttr function that masks secrets, and prompt
+// and response content unless sensitive is true. Log messages and errors are
+// searched for credentials too.
+func Redact(sensitive bool) func(groups []string, a slog.Attr) slog.Attr {
+	return func(groups []string, a slog.Attr) slog.Attr {
+		key := strings.ToLower(a.Key)
+		switch {
+		case secretKeys[key]:
+			return slog.String(a.Key, Redacted)
+		case contentKeys[key] && !sensitive:
+			if a.Value.Kind() == slog.KindString {
+				return slog.String(a.Key, fmt.Sprintf("[REDACTED %d bytes]", len(a.Value.String())))
+			}
+			return slog.String(a.Key, Redacted)
+		}
+
+		switch a.Value.Kind() {
+		case slog.KindString:
+			if masked, ok := maskSecrets(a.Value.String()); ok {
+				return slog.String(a.Key, masked)
+			}
+		case slog.KindAny:
+			// Errors often quote upstream responses
+			if err, isErr := a.Value.Any().(error); isErr {
+				if masked, ok := maskSecrets(err.Error()); ok {
+					return slog.String(a.Key, masked)
+				}
+			}
+		}
+		return a
+	}
+}
+
+// maskSecrets replaces the credentials found in s, reporting whether any were
+func maskSecrets(s string) (string, bool) {
+	masked := false
+	for _, pattern := range secretPatterns {
+		if pattern.MatchString(s) {
+			s = pattern.ReplaceAllString(s, Redacted)
+			masked = true
+		}
+	}
+	return s, masked
+}
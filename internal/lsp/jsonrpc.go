@@ -0,0 +1,115 @@
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/textproto"
+	"strconv"
+	"sync"
+)
+
+// JSON-RPC error codes
+const (
+	codeParseError     = -32700
+	codeInvalidParams  = -32602
+	codeMethodNotFound = -32601
+	codeInternalError  = -32603
+	// codeRequestCancelled is the LSP code for a request cancelled by the client
+	codeRequestCancelled = -32800
+)
+
+// message is a JSON-RPC request, notification (no ID) or response
+type message struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method,omitempty"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	Result  interface{}     `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *rpcError) Error() string {
+	return e.Message
+}
+
+// conn reads and writes messages framed by Content-Length headers
+type conn struct {
+	in *textproto.Reader
+	// mutex keeps concurrent responses from interleaving
+	mutex sync.Mutex
+	out   io.Writer
+}
+
+func newConn(in io.Reader, out io.Writer) *conn {
+	return &conn{in: textproto.NewReader(bufio.NewReader(in)), out: out}
+}
+
+// read returns the next message
+func (c *conn) read() (*message, error) {
+	header, err := c.in.ReadMIMEHeader()
+	if err != nil {
+		return nil, err
+	}
+	length, err := strconv.Atoi(header.Get("Content-Length"))
+	if err != nil || length < 0 {
+		return nil, fmt.Errorf("invalid Content-Length %q", header.Get("Content-Length"))
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(c.in.R, body); err != nil {
+		return nil, err
+	}
+	var msg message
+	if err := json.Unmarshal(body, &msg); err != nil {
+		return nil, &rpcError{Code: codeParseError, Message: err.Error()}
+	}
+	return &msg, nil
+}
+
+// write sends a message
+func (c *conn) write(msg *message) error {
+	msg.JSONRPC = "2.0"
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if _, err := fmt.Fprintf(c.out, "Content-Length: %d\r\n\r\n", len(body)); err != nil {
+		return err
+	}
+	_, err = c.out.Write(body)
+	return err
+}
+
+// reply answers the request with id. A nil result is sent as null.
+func (c *conn) reply(id json.RawMessage, result interface{}, err error) error {
+	msg := &message{ID: id}
+	switch e := err.(type) {
+	case nil:
+		if result == nil {
+			result = json.RawMessage("null")
+		}
+		msg.Result = result
+	case *rpcError:
+		msg.Error = e
+	default:
+		msg.Error = &rpcError{Code: codeInternalError, Message: err.Error()}
+	}
+	return c.write(msg)
+}
+
+// notify sends a notification
+func (c *conn) notify(method string, params interface{}) error {
+	data, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	return c.write(&message{Method: method, Params: data})
+}
@@ -0,0 +1,60 @@
+package lsp
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf16"
+)
+
+// Position is a zero-based line and a character offset in UTF-16 code units
+type Position struct {
+	Line      int `json:"line"`
+	Character int `json:"character"`
+}
+
+// Range is the text between two positions
+type Range struct {
+	Start Position `json:"start"`
+	End   Position `json:"end"`
+}
+
+// Offset returns the byte offset of pos in text. A character offset past the
+// end of its line is taken as the end of the line, as editors do.
+func Offset(text string, pos Position) (int, error) {
+	if pos.Line < 0 || pos.Character < 0 {
+		return 0, fmt.Errorf("position %d:%d is negative", pos.Line, pos.Character)
+	}
+	offset := 0
+	for line := 0; line < pos.Line; line++ {
+		i := strings.IndexByte(text[offset:], '\n')
+		if i < 0 {
+			return 0, fmt.Errorf("line %d is past the end of the document", pos.Line)
+		}
+		offset += i + 1
+	}
+
+	units := 0
+	for i, c := range text[offset:] {
+		if c == '\n' || units >= pos.Character {
+			return offset + i, nil
+		}
+		units += len(utf16.Encode([]rune{c}))
+	}
+	return len(text), nil
+}
+
+// Split splits text at pos into the text before and after it
+func Split(text string, pos Position) (string, string, error) {
+	offset, err := Offset(text, pos)
+	if err != nil {
+		return "", "", err
+	}
+	return text[:offset], text[offset:], nil
+}
+
+// Multiline reports whether a completion before suffix may span several
+// lines: when the rest of the line is blank. Otherwise it ends with the line.
+func Multiline(suffix string) bool {
+	restOfLine, _, _ := strings.Cut(suffix, "\n")
+	return strings.TrimSpace(restOfLine) == ""
+}
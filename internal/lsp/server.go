@@ -0,0 +1,362 @@
+// Package lsp serves inline completions to editors over the Language Server
+// Protocol, so any LSP-capable editor can get ghost text from ReAI
+package lsp
+
+import (
+	"context"
+	"encoding/json"
+	stderrors "errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/devstroop/reai/internal/copilot"
+)
+
+// Inline completion limits: a completion in the middle of a line ends with
+// the line, one at the end of a line may fill a block
+const (
+	lineMaxTokens  = 100
+	blockMaxTokens = 500
+)
+
+// Completer gets code completions, as the Copilot client does
+type Completer interface {
+	GetCompletion(ctx context.Context, req *copilot.CompletionRequest) (*copilot.CompletionResult, error)
+}
+
+// Server is a language server offering textDocument/inlineCompletion
+type Server struct {
+	completer Completer
+
+	// mutex guards documents and requests
+	mutex     sync.Mutex
+	documents map[string]*document
+	// requests cancels the running requests by ID
+	requests map[string]context.CancelFunc
+
+	shutdown bool
+}
+
+// document is an open text document
+type document struct {
+	uri        string
+	languageID string
+	version    int
+	text       string
+}
+
+// NewServer creates a language server completing with completer
+func NewServer(completer Completer) *Server {
+	return &Server{
+		completer: completer,
+		documents: make(map[string]*document),
+		requests:  make(map[string]context.CancelFunc),
+	}
+}
+
+// Serve answers the messages read from in on out until the client exits or
+// in is closed. It returns nil after an orderly shutdown and exit.
+func (s *Server) Serve(ctx context.Context, in io.Reader, out io.Writer) error {
+	c := newConn(in, out)
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	for {
+		msg, err := c.read()
+		if err != nil {
+			var rpcErr *rpcError
+			if stderrors.As(err, &rpcErr) {
+				// The frame was read, only its body is broken
+				c.reply(json.RawMessage("null"), nil, rpcErr)
+				continue
+			}
+			if err == io.EOF {
+				return fmt.Errorf("client closed the connection without exit")
+			}
+			return err
+		}
+
+		switch {
+		case msg.Method == "":
+			// A response to a request we never send
+		case msg.Method == "exit":
+			s.cancelAll()
+			if !s.isShutdown() {
+				return fmt.Errorf("client exited without shutdown")
+			}
+			return nil
+		case len(msg.ID) == 0:
+			s.handleNotification(msg)
+		case msg.Method == "textDocument/inlineCompletion":
+			// Completions take a while; keep reading so they can be cancelled
+			reqCtx := s.track(ctx, msg.ID)
+			wg.Add(1)
+			go func(msg *message) {
+				defer wg.Done()
+				defer s.untrack(msg.ID)
+				result, err := s.inlineCompletion(reqCtx, msg.Params)
+				if reqCtx.Err() != nil && ctx.Err() == nil {
+					result, err = nil, &rpcError{Code: codeRequestCancelled, Message: "request cancelled"}
+				}
+				if err := c.reply(msg.ID, result, err); err != nil {
+					slog.Warn("Failed to write LSP response", "error", err)
+				}
+			}(msg)
+		default:
+			result, err := s.handleRequest(msg)
+			if err := c.reply(msg.ID, result, err); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// handleRequest answers the requests that complete right away
+func (s *Server) handleRequest(msg *message) (interface{}, error) {
+	switch msg.Method {
+	case "initialize":
+		return map[string]interface{}{
+			"capabilities": map[string]interface{}{
+				"textDocumentSync": map[string]interface{}{
+					"openClose": true,
+					// Incremental
+					"change": 2,
+				},
+				"inlineCompletionProvider": true,
+			},
+			"serverInfo": map[string]string{"name": "reai"},
+		}, nil
+	case "shutdown":
+		s.mutex.Lock()
+		s.shutdown = true
+		s.mutex.Unlock()
+		return nil, nil
+	}
+	return nil, &rpcError{Code: codeMethodNotFound, Message: "unsupported method: " + msg.Method}
+}
+
+// handleNotification keeps track of the open documents and cancellations.
+// Notifications are never answered, so errors are only logged.
+func (s *Server) handleNotification(msg *message) {
+	var err error
+	switch msg.Method {
+	case "textDocument/didOpen":
+		err = s.didOpen(msg.Params)
+	case "textDocument/didChange":
+		err = s.didChange(msg.Params)
+	case "textDocument/didClose":
+		err = s.didClose(msg.Params)
+	case "$/cancelRequest":
+		var params struct {
+			ID json.RawMessage `json:"id"`
+		}
+		if err = json.Unmarshal(msg.Params, &params); err == nil {
+			s.cancel(params.ID)
+		}
+	}
+	if err != nil {
+		slog.Warn("LSP notification failed", "method", msg.Method, "error", err)
+	}
+}
+
+type textDocumentIdentifier struct {
+	URI     string `json:"uri"`
+	Version int    `json:"version,omitempty"`
+}
+
+func (s *Server) didOpen(data json.RawMessage) error {
+	var params struct {
+		TextDocument struct {
+			URI        string `json:"uri"`
+			LanguageID string `json:"languageId"`
+			Version    int    `json:"version"`
+			Text       string `json:"text"`
+		} `json:"textDocument"`
+	}
+	if err := json.Unmarshal(data, &params); err != nil {
+		return err
+	}
+	td := params.TextDocument
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.documents[td.URI] = &document{uri: td.URI, languageID: td.LanguageID, version: td.Version, text: td.Text}
+	return nil
+}
+
+// didChange applies full or incremental changes to an open document
+func (s *Server) didChange(data json.RawMessage) error {
+	var params struct {
+		TextDocument   textDocumentIdentifier `json:"textDocument"`
+		ContentChanges []struct {
+			Range *Range `json:"range"`
+			Text  string `json:"text"`
+		} `json:"contentChanges"`
+	}
+	if err := json.Unmarshal(data, &params); err != nil {
+		return err
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	doc, ok := s.documents[params.TextDocument.URI]
+	if !ok {
+		return fmt.Errorf("document %s is not open", params.TextDocument.URI)
+	}
+	for _, change := range params.ContentChanges {
+		if change.Range == nil {
+			doc.text = change.Text
+			continue
+		}
+		start, err := Offset(doc.text, change.Range.Start)
+		if err != nil {
+			return err
+		}
+		end, err := Offset(doc.text, change.Range.End)
+		if err != nil {
+			return err
+		}
+		if end < start {
+			return fmt.Errorf("change range ends before it starts")
+		}
+		doc.text = doc.text[:start] + change.Text + doc.text[end:]
+	}
+	doc.version = params.TextDocument.Version
+	return nil
+}
+
+func (s *Server) didClose(data json.RawMessage) error {
+	var params struct {
+		TextDocument textDocumentIdentifier `json:"textDocument"`
+	}
+	if err := json.Unmarshal(data, &params); err != nil {
+		return err
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.documents, params.TextDocument.URI)
+	return nil
+}
+
+// inlineCompletionItem is a ghost text suggestion: InsertText replaces Range
+type inlineCompletionItem struct {
+	InsertText string `json:"insertText"`
+	Range      Range  `json:"range"`
+}
+
+type inlineCompletionList struct {
+	Items []inlineCompletionItem `json:"items"`
+}
+
+// inlineCompletion completes an open document at the requested position
+func (s *Server) inlineCompletion(ctx context.Context, data json.RawMessage) (*inlineCompletionList, error) {
+	var params struct {
+		TextDocument textDocumentIdentifier `json:"textDocument"`
+		Position     Position               `json:"position"`
+	}
+	if err := json.Unmarshal(data, &params); err != nil {
+		return nil, &rpcError{Code: codeInvalidParams, Message: err.Error()}
+	}
+
+	// Snapshot the document so edits arriving meanwhile don't race
+	s.mutex.Lock()
+	doc, ok := s.documents[params.TextDocument.URI]
+	var text, languageID string
+	if ok {
+		text, languageID = doc.text, doc.languageID
+	}
+	s.mutex.Unlock()
+	if !ok {
+		return nil, &rpcError{Code: codeInvalidParams, Message: "document is not open: " + params.TextDocument.URI}
+	}
+
+	list := &inlineCompletionList{Items: []inlineCompletionItem{}}
+	prefix, suffix, err := Split(text, params.Position)
+	if err != nil {
+		return nil, &rpcError{Code: codeInvalidParams, Message: err.Error()}
+	}
+	if strings.TrimSpace(prefix) == "" {
+		// Nothing to go on yet
+		return list, nil
+	}
+
+	req := &copilot.CompletionRequest{
+		Prompt:    prefix,
+		Suffix:    suffix,
+		Language:  languageID,
+		MaxTokens: lineMaxTokens,
+	}
+	if filePath := uriPath(params.TextDocument.URI); filePath != "" {
+		req.Context = &copilot.PromptContext{FilePath: filePath}
+	}
+	if Multiline(suffix) {
+		req.MaxTokens = blockMaxTokens
+		// A blank line ends the block
+		req.Stop = []string{"\n\n"}
+	}
+
+	completion, err := s.completer.GetCompletion(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	insert := strings.TrimRight(completion.Text, " \t\r\n")
+	if strings.TrimSpace(insert) == "" {
+		return list, nil
+	}
+	list.Items = append(list.Items, inlineCompletionItem{
+		InsertText: insert,
+		Range:      Range{Start: params.Position, End: params.Position},
+	})
+	return list, nil
+}
+
+// uriPath is the path of a file URI, empty for other schemes
+func uriPath(uri string) string {
+	if u, err := url.Parse(uri); err == nil && u.Scheme == "file" {
+		return path.Clean(u.Path)
+	}
+	return ""
+}
+
+// track returns the context of a request that $/cancelRequest can cancel
+func (s *Server) track(ctx context.Context, id json.RawMessage) context.Context {
+	ctx, cancel := context.WithCancel(ctx)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.requests[string(id)] = cancel
+	return ctx
+}
+
+func (s *Server) untrack(id json.RawMessage) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if cancel, ok := s.requests[string(id)]; ok {
+		cancel()
+		delete(s.requests, string(id))
+	}
+}
+
+func (s *Server) cancel(id json.RawMessage) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if cancel, ok := s.requests[string(id)]; ok {
+		cancel()
+	}
+}
+
+func (s *Server) cancelAll() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, cancel := range s.requests {
+		cancel()
+	}
+}
+
+func (s *Server) isShutdown() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.shutdown
+}
@@ -0,0 +1,155 @@
+package maintenance
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/devstroop/reai/internal/cron"
+)
+
+// DefaultMessage is returned to clients when no custom message is set
+const DefaultMessage = "ReAI is undergoing maintenance, please retry later"
+
+// Window is a recurring maintenance window starting at each cron match
+type Window struct {
+	Schedule *cron.Schedule
+	Duration time.Duration
+}
+
+// Status describes whether maintenance is in effect
+type Status struct {
+	Active  bool       `json:"active"`
+	Manual  bool       `json:"manual"`
+	Message string     `json:"message,omitempty"`
+	Until   *time.Time `json:"until,omitempty"`
+	// Windows lists the configured schedules
+	Windows []string `json:"windows,omitempty"`
+	// NextWindow is the start of the next scheduled window
+	NextWindow *time.Time `json:"next_window,omitempty"`
+}
+
+// Mode tracks manual and scheduled maintenance
+type Mode struct {
+	mutex    sync.RWMutex
+	windows  []Window
+	message  string
+	location *time.Location
+
+	manual        bool
+	manualMessage string
+	manualUntil   time.Time
+}
+
+// New creates a maintenance mode with the given scheduled windows and default
+// message. The windows are scheduled in location, local time when nil.
+func New(windows []Window, message string, location *time.Location) *Mode {
+	if message == "" {
+		message = DefaultMessage
+	}
+	if location == nil {
+		location = time.Local
+	}
+	return &Mode{windows: windows, message: message, location: location}
+}
+
+// ParseLocation loads the time zone windows are scheduled in, nil (local
+// time) when name is empty
+func ParseLocation(name string) (*time.Location, error) {
+	if name == "" {
+		return nil, nil
+	}
+	location, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, fmt.Errorf("maintenance time zone %q: %w", name, err)
+	}
+	return location, nil
+}
+
+// ParseWindows parses "<cron expression> <duration>" entries separated by ';',
+// e.g. "0 3 * * SUN 90m; 30 22 1 * * 2h"
+func ParseWindows(spec string) ([]Window, error) {
+	var windows []Window
+	for _, entry := range strings.Split(spec, ";") {
+		fields := strings.Fields(entry)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 6 {
+			return nil, fmt.Errorf("maintenance window %q must be a 5-field cron expression followed by a duration", strings.TrimSpace(entry))
+		}
+		schedule, err := cron.Parse(strings.Join(fields[:5], " "))
+		if err != nil {
+			return nil, fmt.Errorf("maintenance window %q: %w", strings.TrimSpace(entry), err)
+		}
+		duration, err := time.ParseDuration(fields[5])
+		if err != nil || duration <= 0 {
+			return nil, fmt.Errorf("maintenance window %q: invalid duration %q", strings.TrimSpace(entry), fields[5])
+		}
+		windows = append(windows, Window{Schedule: schedule, Duration: duration})
+	}
+	return windows, nil
+}
+
+// Enable turns on manual maintenance. A zero until keeps it on until Disable.
+func (m *Mode) Enable(message string, until time.Time) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.manual = true
+	m.manualMessage = message
+	m.manualUntil = until
+}
+
+// Disable turns off manual maintenance. Scheduled windows still apply.
+func (m *Mode) Disable() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.manual = false
+	m.manualMessage = ""
+	m.manualUntil = time.Time{}
+}
+
+// Status returns the maintenance status at now
+func (m *Mode) Status(now time.Time) Status {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	// Cron fields are wall clock times in the configured zone
+	now = now.In(m.location)
+	status := Status{Message: m.message}
+	for _, window := range m.windows {
+		status.Windows = append(status.Windows, fmt.Sprintf("%s %s", window.Schedule, window.Duration))
+		if next := window.Schedule.Next(now); !next.IsZero() && (status.NextWindow == nil || next.Before(*status.NextWindow)) {
+			status.NextWindow = &next
+		}
+	}
+
+	if m.manual && (m.manualUntil.IsZero() || now.Before(m.manualUntil)) {
+		status.Active = true
+		status.Manual = true
+		if !m.manualUntil.IsZero() {
+			until := m.manualUntil
+			status.Until = &until
+		}
+		if m.manualMessage != "" {
+			status.Message = m.manualMessage
+		}
+		return status
+	}
+
+	for _, window := range m.windows {
+		start := window.Schedule.Prev(now, now.Add(-window.Duration))
+		if start.IsZero() {
+			continue
+		}
+		if end := start.Add(window.Duration); now.Before(end) {
+			status.Active = true
+			if status.Until == nil || end.After(*status.Until) {
+				status.Until = &end
+			}
+		}
+	}
+
+	return status
+}
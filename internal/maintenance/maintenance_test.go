@@ -0,0 +1,69 @@
+package maintenance
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStatusTimezone(t *testing.T) {
+	windows, err := ParseWindows("0 3 * * * 90m")
+	if err != nil {
+		t.Fatal(err)
+	}
+	location, err := ParseLocation("")
+	if err != nil || location != nil {
+		t.Fatalf("empty zone: %v, %v", location, err)
+	}
+	if _, err := ParseLocation("Mars/Olympus_Mons"); err == nil {
+		t.Error("unknown zone accepted")
+	}
+
+	// 03:00 in India is 21:30 UTC the day before
+	india := time.FixedZone("IST", 5*3600+1800)
+	mode := New(windows, "", india)
+	start := time.Date(2024, 3, 1, 21, 30, 0, 0, time.UTC)
+
+	status := mode.Status(start.Add(-time.Minute))
+	if status.Active || status.NextWindow == nil || !status.NextWindow.Equal(start) {
+		t.Errorf("before the window: %+v", status)
+	}
+	status = mode.Status(start.Add(45 * time.Minute))
+	if !status.Active || status.Until == nil || !status.Until.Equal(start.Add(90*time.Minute)) || status.Message != DefaultMessage {
+		t.Errorf("in the window: %+v", status)
+	}
+	if status := mode.Status(start.Add(90 * time.Minute)); status.Active {
+		t.Errorf("after the window: %+v", status)
+	}
+}
+
+func TestManual(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	mode := New(nil, "down for upgrades", time.UTC)
+	mode.Enable("", now.Add(time.Hour))
+	if status := mode.Status(now); !status.Active || !status.Manual || status.Message != "down for upgrades" {
+		t.Errorf("enabled: %+v", status)
+	}
+	if status := mode.Status(now.Add(time.Hour)); status.Active {
+		t.Errorf("expired: %+v", status)
+	}
+	mode.Enable("rotating credentials", time.Time{})
+	if status := mode.Status(now.AddDate(1, 0, 0)); !status.Active || status.Until != nil || status.Message != "rotating credentials" {
+		t.Errorf("open ended: %+v", status)
+	}
+	mode.Disable()
+	if status := mode.Status(now); status.Active {
+		t.Errorf("disabled: %+v", status)
+	}
+}
+
+func TestParseWindows(t *testing.T) {
+	windows, err := ParseWindows("0 3 * * SUN 90m; 30 22 1 * * 2h;")
+	if err != nil || len(windows) != 2 || windows[1].Duration != 2*time.Hour {
+		t.Fatalf("windows %v, %v", windows, err)
+	}
+	for _, spec := range []string{"0 3 * * SUN", "0 3 * * SUN -1h", "0 3 * * SUN soon", "61 3 * * SUN 1h"} {
+		if _, err := ParseWindows(spec); err == nil {
+			t.Errorf("%q accepted", spec)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+// Package metrics registers the server's Prometheus metrics. The package
+// level constructors register on Default, which also holds the Go runtime
+// and process collectors (go_goroutines, process_open_fds, ...), and Handler
+// serves it with promhttp.
+package metrics
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// DefaultBuckets are latency buckets in seconds suitable for upstream calls
+var DefaultBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+
+// Default is the process-wide registry used by the package level constructors
+var Default = newRegistry()
+
+func newRegistry() *prometheus.Registry {
+	r := prometheus.NewRegistry()
+	r.MustRegister(collectors.NewGoCollector(), collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+	return r
+}
+
+// Handler serves the default registry in the Prometheus exposition format
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Default, promhttp.HandlerOpts{})
+}
+
+// register adds c to the default registry, returning the collector already
+// registered under its name if there is one
+func register[T prometheus.Collector](c T) T {
+	err := Default.Register(c)
+	if err == nil {
+		return c
+	}
+	var registered prometheus.AlreadyRegisteredError
+	if errors.As(err, &registered) {
+		if existing, ok := registered.ExistingCollector.(T); ok {
+			return existing
+		}
+	}
+	panic(err)
+}
+
+// Counter is a monotonically increasing value
+type Counter struct {
+	counter prometheus.Counter
+}
+
+// Inc increments the counter by one
+func (c *Counter) Inc() { c.counter.Inc() }
+
+// Add increments the counter by delta, ignoring negative values
+func (c *Counter) Add(delta float64) {
+	if delta > 0 {
+		c.counter.Add(delta)
+	}
+}
+
+// Gauge is a value that can go up and down
+type Gauge struct {
+	gauge prometheus.Gauge
+}
+
+// Set sets the gauge
+func (g *Gauge) Set(v float64) { g.gauge.Set(v) }
+
+// Inc increments the gauge by one
+func (g *Gauge) Inc() { g.gauge.Inc() }
+
+// Dec decrements the gauge by one
+func (g *Gauge) Dec() { g.gauge.Dec() }
+
+// Add adds delta to the gauge
+func (g *Gauge) Add(delta float64) { g.gauge.Add(delta) }
+
+// Value returns the current value
+func (g *Gauge) Value() float64 {
+	var m dto.Metric
+	if err := g.gauge.Write(&m); err != nil {
+		return 0
+	}
+	return m.GetGauge().GetValue()
+}
+
+// Histogram counts observations into cumulative buckets
+type Histogram struct {
+	histogram prometheus.Observer
+}
+
+// Observe records a value
+func (h *Histogram) Observe(v float64) { h.histogram.Observe(v) }
+
+// CounterVec is a counter partitioned by labels
+type CounterVec struct {
+	vec *prometheus.CounterVec
+}
+
+// With returns the counter for the given label values
+func (v *CounterVec) With(values ...string) *Counter {
+	return &Counter{v.vec.WithLabelValues(values...)}
+}
+
+// GaugeVec is a gauge partitioned by labels
+type GaugeVec struct {
+	vec *prometheus.GaugeVec
+}
+
+// With returns the gauge for the given label values
+func (v *GaugeVec) With(values ...string) *Gauge {
+	return &Gauge{v.vec.WithLabelValues(values...)}
+}
+
+// HistogramVec is a histogram partitioned by labels
+type HistogramVec struct {
+	vec *prometheus.HistogramVec
+}
+
+// With returns the histogram for the given label values
+func (v *HistogramVec) With(values ...string) *Histogram {
+	return &Histogram{v.vec.WithLabelValues(values...)}
+}
+
+// NewCounterVec registers a labelled counter on the default registry
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	return &CounterVec{register(prometheus.NewCounterVec(prometheus.CounterOpts{Name: name, Help: help}, labels))}
+}
+
+// NewCounter registers an unlabelled counter on the default registry
+func NewCounter(name, help string) *Counter {
+	return NewCounterVec(name, help).With()
+}
+
+// NewGaugeVec registers a labelled gauge on the default registry
+func NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	return &GaugeVec{register(prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: name, Help: help}, labels))}
+}
+
+// NewGauge registers an unlabelled gauge on the default registry
+func NewGauge(name, help string) *Gauge {
+	return NewGaugeVec(name, help).With()
+}
+
+// NewHistogramVec registers a labelled histogram on the default registry
+func NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	if buckets == nil {
+		buckets = DefaultBuckets
+	}
+	opts := prometheus.HistogramOpts{Name: name, Help: help, Buckets: buckets}
+	return &HistogramVec{register(prometheus.NewHistogramVec(opts, labels))}
+}
+
+// NewHistogram registers an unlabelled histogram on the default registry
+func NewHistogram(name, help string, buckets []float64) *Histogram {
+	return NewHistogramVec(name, help, buckets).With()
+}
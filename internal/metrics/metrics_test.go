@@ -0,0 +1,75 @@
+package metrics
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+)
+
+// scrape returns the metric families the handler serves
+func scrape(t *testing.T) map[string]*dto.MetricFamily {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("content type %q", ct)
+	}
+	var parser expfmt.TextParser
+	families, err := parser.TextToMetricFamilies(rec.Body)
+	if err != nil {
+		t.Fatalf("invalid exposition: %v", err)
+	}
+	return families
+}
+
+func TestLabelEscaping(t *testing.T) {
+	requests := NewCounterVec("test_escaped_total", "Requests by path", "path")
+	// Quotes, backslashes and newlines must be escaped, and nothing else:
+	// Go quoting would also turn the tab and the é into escape sequences
+	path := "/a\"b\\c\nd\té"
+	requests.With(path).Inc()
+
+	family := scrape(t)["test_escaped_total"]
+	if family == nil || len(family.Metric) != 1 {
+		t.Fatalf("family %v", family)
+	}
+	if got := family.Metric[0].Label[0].GetValue(); got != path {
+		t.Errorf("label value %q, want %q", got, path)
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	counter := NewCounter("test_counter_total", "A counter")
+	counter.Add(2)
+	counter.Add(-1)
+	counter.Inc()
+	gauge := NewGauge("test_gauge", "A gauge")
+	gauge.Set(5)
+	gauge.Dec()
+	if gauge.Value() != 4 {
+		t.Errorf("gauge %v", gauge.Value())
+	}
+	histogram := NewHistogramVec("test_seconds", "A histogram", []float64{1, 2}, "kind")
+	histogram.With("a").Observe(1.5)
+	histogram.With("a").Observe(3)
+
+	// Registering a name again returns the metric already registered
+	NewCounter("test_counter_total", "A counter").Inc()
+
+	families := scrape(t)
+	if got := families["test_counter_total"].Metric[0].GetCounter().GetValue(); got != 4 {
+		t.Errorf("counter %v, want 4 (negative deltas ignored)", got)
+	}
+	h := families["test_seconds"].Metric[0].GetHistogram()
+	if h.GetSampleCount() != 2 || h.GetSampleSum() != 4.5 || h.Bucket[0].GetCumulativeCount() != 0 || h.Bucket[1].GetCumulativeCount() != 1 {
+		t.Errorf("histogram %v", h)
+	}
+	for _, name := range []string{"go_goroutines", "process_open_fds"} {
+		if families[name] == nil {
+			t.Errorf("%s missing", name)
+		}
+	}
+}
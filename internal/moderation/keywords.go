@@ -0,0 +1,56 @@
+package moderation
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"slices"
+)
+
+// keywords flags inputs matching the patterns configured for a category
+type keywords struct {
+	patterns map[string][]*regexp.Regexp
+}
+
+func newKeywords(cfg Config) (*keywords, error) {
+	if len(cfg.Categories) == 0 {
+		return nil, fmt.Errorf("keywords moderation needs categories")
+	}
+	k := &keywords{patterns: make(map[string][]*regexp.Regexp, len(cfg.Categories))}
+	for category, patterns := range cfg.Categories {
+		if !slices.Contains(Categories, category) {
+			return nil, fmt.Errorf("unknown moderation category %q", category)
+		}
+		for _, pattern := range patterns {
+			re, err := regexp.Compile("(?i)" + pattern)
+			if err != nil {
+				return nil, fmt.Errorf("category %s: invalid pattern %q: %w", category, pattern, err)
+			}
+			k.patterns[category] = append(k.patterns[category], re)
+		}
+	}
+	return k, nil
+}
+
+func (k *keywords) Name() string {
+	return TypeKeywords
+}
+
+// Classify scores a category 1 when one of its patterns matches, 0 otherwise
+func (k *keywords) Classify(ctx context.Context, model string, inputs []string) ([]Result, error) {
+	results := make([]Result, len(inputs))
+	for i, input := range inputs {
+		results[i] = newResult()
+		for category, patterns := range k.patterns {
+			for _, re := range patterns {
+				if re.MatchString(input) {
+					results[i].Flagged = true
+					results[i].Categories[category] = true
+					results[i].CategoryScores[category] = 1
+					break
+				}
+			}
+		}
+	}
+	return results, nil
+}
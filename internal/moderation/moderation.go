@@ -0,0 +1,139 @@
+package moderation
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/devstroop/reai/internal/metrics"
+)
+
+// Classifier types
+const (
+	TypeAllow    = "allow"
+	TypeKeywords = "keywords"
+	TypeRemote   = "remote"
+)
+
+// DefaultModel is reported when neither the request nor the classifier names one
+const DefaultModel = "omni-moderation-latest"
+
+// Categories are the moderation categories of the OpenAI API. Results list
+// every one of them.
+var Categories = []string{
+	"harassment", "harassment/threatening", "hate", "hate/threatening",
+	"illicit", "illicit/violent", "self-harm", "self-harm/intent",
+	"self-harm/instructions", "sexual", "sexual/minors", "violence",
+	"violence/graphic",
+}
+
+var moderatedInputs = metrics.NewCounterVec("reai_moderation_inputs_total", "Inputs classified by the moderation endpoint, by result (flagged, ok)", "result")
+
+// Result is the classification of one input in the OpenAI moderation format
+type Result struct {
+	Flagged        bool               `json:"flagged"`
+	Categories     map[string]bool    `json:"categories"`
+	CategoryScores map[string]float64 `json:"category_scores"`
+}
+
+// newResult returns a result with no category flagged
+func newResult() Result {
+	r := Result{Categories: make(map[string]bool, len(Categories)), CategoryScores: make(map[string]float64, len(Categories))}
+	for _, category := range Categories {
+		r.Categories[category] = false
+		r.CategoryScores[category] = 0
+	}
+	return r
+}
+
+// Classifier flags inputs that break a content policy
+type Classifier interface {
+	// Name identifies the classifier in logs
+	Name() string
+	// Classify returns one result per input, in order. model is the model
+	// the client asked for, empty when it named none.
+	Classify(ctx context.Context, model string, inputs []string) ([]Result, error)
+}
+
+// Config is the on-disk format of MODERATION_FILE. Only the fields of its
+// type apply.
+type Config struct {
+	Type string `json:"type"`
+
+	// Keywords: regular expressions per category, matched case-insensitively
+	Categories map[string][]string `json:"categories,omitempty"`
+
+	// Remote: an OpenAI compatible /v1/moderations URL. APIKey, or the
+	// environment variable named by APIKeyEnv, is sent as a bearer token.
+	URL       string            `json:"url,omitempty"`
+	APIKey    string            `json:"api_key,omitempty"`
+	APIKeyEnv string            `json:"api_key_env,omitempty"`
+	Headers   map[string]string `json:"headers,omitempty"`
+	// Model is sent upstream when the client names none
+	Model string `json:"model,omitempty"`
+	// Timeout bounds a remote request (default 10s)
+	Timeout string `json:"timeout,omitempty"`
+}
+
+// Load reads the classifier configured in the file at path. With an empty
+// path every input is allowed.
+func Load(path string) (Classifier, error) {
+	if path == "" {
+		return allow{}, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read moderation file: %w", err)
+	}
+	var cfg Config
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse moderation file: %w", err)
+	}
+	return New(cfg)
+}
+
+// New builds the classifier of a configuration
+func New(cfg Config) (Classifier, error) {
+	switch cfg.Type {
+	case TypeAllow, "":
+		return allow{}, nil
+	case TypeKeywords:
+		return newKeywords(cfg)
+	case TypeRemote:
+		return newRemote(cfg)
+	}
+	return nil, fmt.Errorf("unknown moderation type %q (want allow, keywords or remote)", cfg.Type)
+}
+
+// Moderate classifies inputs and counts the results
+func Moderate(ctx context.Context, c Classifier, model string, inputs []string) ([]Result, error) {
+	results, err := c.Classify(ctx, model, inputs)
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range results {
+		if r.Flagged {
+			moderatedInputs.With("flagged").Inc()
+		} else {
+			moderatedInputs.With("ok").Inc()
+		}
+	}
+	return results, nil
+}
+
+// allow flags nothing, so pipelines that moderate before chatting keep working
+type allow struct{}
+
+func (allow) Name() string {
+	return TypeAllow
+}
+
+func (allow) Classify(ctx context.Context, model string, inputs []string) ([]Result, error) {
+	results := make([]Result, len(inputs))
+	for i := range inputs {
+		results[i] = newResult()
+	}
+	return results, nil
+}
@@ -0,0 +1,112 @@
+package moderation
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/devstroop/reai/pkg/errors"
+)
+
+// DefaultRemoteTimeout bounds a request to the remote moderation API
+const DefaultRemoteTimeout = 10 * time.Second
+
+// remote forwards inputs to an OpenAI compatible moderation API
+type remote struct {
+	url     string
+	apiKey  string
+	headers map[string]string
+	model   string
+	client  *http.Client
+}
+
+func newRemote(cfg Config) (*remote, error) {
+	if cfg.URL == "" {
+		return nil, fmt.Errorf("remote moderation needs a url")
+	}
+	timeout := DefaultRemoteTimeout
+	if cfg.Timeout != "" {
+		d, err := time.ParseDuration(cfg.Timeout)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("timeout: invalid duration %q", cfg.Timeout)
+		}
+		timeout = d
+	}
+	apiKey := cfg.APIKey
+	if apiKey == "" && cfg.APIKeyEnv != "" {
+		apiKey = os.Getenv(cfg.APIKeyEnv)
+	}
+	return &remote{url: cfg.URL, apiKey: apiKey, headers: cfg.Headers, model: cfg.Model, client: &http.Client{Timeout: timeout}}, nil
+}
+
+func (r *remote) Name() string {
+	return TypeRemote
+}
+
+// Classify sends inputs upstream in one request. Categories the upstream
+// leaves out are reported unflagged.
+func (r *remote) Classify(ctx context.Context, model string, inputs []string) ([]Result, error) {
+	if model == "" {
+		model = r.model
+	}
+	payload := map[string]interface{}{"input": inputs}
+	if model != "" {
+		payload["model"] = model
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, errors.NewProviderError(fmt.Sprintf("moderation request failed: %s", err))
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if r.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+r.apiKey)
+	}
+	for name, value := range r.headers {
+		req.Header.Set(name, value)
+	}
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, errors.NewProviderError(fmt.Sprintf("moderation request failed: %s", err))
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return nil, errors.NewProviderError(fmt.Sprintf("moderation API returned HTTP %d: %s", resp.StatusCode, strings.TrimSpace(string(message))))
+	}
+
+	var decoded struct {
+		Results []Result `json:"results"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&decoded); err != nil {
+		return nil, errors.NewProviderError(fmt.Sprintf("invalid moderation response: %s", err))
+	}
+	if len(decoded.Results) != len(inputs) {
+		return nil, errors.NewProviderError(fmt.Sprintf("moderation API returned %d results for %d inputs", len(decoded.Results), len(inputs)))
+	}
+	results := make([]Result, len(inputs))
+	for i, upstream := range decoded.Results {
+		results[i] = newResult()
+		results[i].Flagged = upstream.Flagged
+		for category, flagged := range upstream.Categories {
+			results[i].Categories[category] = flagged
+		}
+		for category, score := range upstream.CategoryScores {
+			results[i].CategoryScores[category] = score
+		}
+	}
+	return results, nil
+}
@@ -0,0 +1,161 @@
+package pricing
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Price is what 1K tokens of a model cost
+type Price struct {
+	Prompt     float64 `json:"prompt"`
+	Completion float64 `json:"completion"`
+}
+
+// zero reports whether the price is unset
+func (p Price) zero() bool {
+	return p.Prompt == 0 && p.Completion == 0
+}
+
+// Config is the on-disk format of PRICING_FILE
+type Config struct {
+	// Currency overrides COST_CURRENCY
+	Currency string `json:"currency,omitempty"`
+	// Models prices models by name. A name ending in "*" prices every model
+	// starting with the rest; the longest match wins.
+	Models map[string]Price `json:"models"`
+	// Default prices models that match no entry, overriding the
+	// COST_PER_1K_* prices
+	Default *Price `json:"default,omitempty"`
+}
+
+// Estimate is the cost of a request's estimated token usage
+type Estimate struct {
+	Currency   string  `json:"currency"`
+	Prompt     float64 `json:"prompt"`
+	Completion float64 `json:"completion"`
+	Total      float64 `json:"total"`
+}
+
+// prefixPrice prices the models starting with prefix
+type prefixPrice struct {
+	prefix string
+	price  Price
+}
+
+// Table looks up the price of models. A nil Table prices nothing.
+type Table struct {
+	currency string
+	models   map[string]Price
+	// prefixes are sorted longest first
+	prefixes []prefixPrice
+	fallback Price
+}
+
+// Load reads the pricing table at path. fallback prices the models the file
+// doesn't, or every model when path is empty. It returns nil when nothing
+// is priced.
+func Load(path string, fallback Price, currency string) (*Table, error) {
+	cfg := Config{Default: &fallback}
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read pricing file: %w", err)
+		}
+		if err := json.Unmarshal(data, &cfg); err != nil {
+			return nil, fmt.Errorf("failed to parse pricing file: %w", err)
+		}
+		if cfg.Default == nil {
+			cfg.Default = &fallback
+		}
+	}
+	if cfg.Currency == "" {
+		cfg.Currency = currency
+	}
+	return New(cfg)
+}
+
+// New builds a pricing table. It returns nil when nothing is priced.
+func New(cfg Config) (*Table, error) {
+	t := &Table{currency: cfg.Currency, models: make(map[string]Price)}
+	if cfg.Default != nil {
+		t.fallback = *cfg.Default
+	}
+	if t.fallback.Prompt < 0 || t.fallback.Completion < 0 {
+		return nil, fmt.Errorf("default price must not be negative")
+	}
+	for model, price := range cfg.Models {
+		if price.Prompt < 0 || price.Completion < 0 {
+			return nil, fmt.Errorf("price of %s must not be negative", model)
+		}
+		if prefix, ok := strings.CutSuffix(model, "*"); ok {
+			t.prefixes = append(t.prefixes, prefixPrice{prefix: prefix, price: price})
+		} else {
+			t.models[model] = price
+		}
+	}
+	if len(t.models) == 0 && len(t.prefixes) == 0 && t.fallback.zero() {
+		return nil, nil
+	}
+	sort.Slice(t.prefixes, func(i, j int) bool {
+		return len(t.prefixes[i].prefix) > len(t.prefixes[j].prefix)
+	})
+	return t, nil
+}
+
+// Currency returns the currency prices are in
+func (t *Table) Currency() string {
+	if t == nil {
+		return ""
+	}
+	return t.currency
+}
+
+// Len returns the number of models priced by name or prefix
+func (t *Table) Len() int {
+	if t == nil {
+		return 0
+	}
+	return len(t.models) + len(t.prefixes)
+}
+
+// lookup returns the price of model
+func (t *Table) lookup(model string) (Price, bool) {
+	if price, ok := t.models[model]; ok {
+		return price, true
+	}
+	for _, p := range t.prefixes {
+		if strings.HasPrefix(model, p.prefix) {
+			return p.price, true
+		}
+	}
+	return t.fallback, !t.fallback.zero()
+}
+
+// Estimate prices the tokens of a request to model, nil when the model has
+// no price
+func (t *Table) Estimate(model string, promptTokens, completionTokens int) *Estimate {
+	if t == nil {
+		return nil
+	}
+	price, ok := t.lookup(model)
+	if !ok {
+		return nil
+	}
+	prompt := Round(float64(promptTokens) / 1000 * price.Prompt)
+	completion := Round(float64(completionTokens) / 1000 * price.Completion)
+	return &Estimate{
+		Currency:   t.currency,
+		Prompt:     prompt,
+		Completion: completion,
+		Total:      Round(prompt + completion),
+	}
+}
+
+// Round rounds to a millionth of the currency unit to hide float noise
+func Round(value float64) float64 {
+	return math.Round(value*1e6) / 1e6
+}
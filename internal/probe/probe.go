@@ -0,0 +1,329 @@
+package probe
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/devstroop/reai/internal/metrics"
+)
+
+// Defaults applied to probes that leave the field empty
+const (
+	DefaultInterval  = 5 * time.Minute
+	DefaultTimeout   = 30 * time.Second
+	DefaultMaxTokens = 32
+)
+
+// historySize is the number of results kept per probe
+const historySize = 50
+
+// Failure reasons reported in results and metrics
+const (
+	FailureError    = "error"
+	FailureMismatch = "mismatch"
+	FailureLatency  = "latency"
+)
+
+var (
+	probeRuns    = metrics.NewCounterVec("reai_probe_runs_total", "Synthetic probe runs by result (pass, error, mismatch, latency)", "probe", "result")
+	probeUp      = metrics.NewGaugeVec("reai_probe_success", "Whether the last run of the probe passed (1) or failed (0)", "probe")
+	probeLatency = metrics.NewGaugeVec("reai_probe_latency_seconds", "Latency of the last run of the probe", "probe")
+)
+
+// Config is the on-disk format of PROBES_FILE
+type Config struct {
+	// Interval is the default for probes without their own
+	Interval string `json:"interval,omitempty"`
+	Probes   []Spec `json:"probes"`
+}
+
+// Spec configures a single probe
+type Spec struct {
+	Name   string `json:"name"`
+	Model  string `json:"model,omitempty"`
+	Prompt string `json:"prompt"`
+	// Expect must appear in the completion (case-insensitive); empty accepts any non-empty completion
+	Expect string `json:"expect,omitempty"`
+	// LatencyBudget fails runs slower than this (Go duration, empty = no budget)
+	LatencyBudget string `json:"latency_budget,omitempty"`
+	Interval      string `json:"interval,omitempty"`
+	Timeout       string `json:"timeout,omitempty"`
+	MaxTokens     int    `json:"max_tokens,omitempty"`
+}
+
+// Probe is a validated probe definition
+type Probe struct {
+	Name          string
+	Model         string
+	Prompt        string
+	Expect        string
+	LatencyBudget time.Duration
+	Interval      time.Duration
+	Timeout       time.Duration
+	MaxTokens     int
+}
+
+// Func runs the completion for a probe and returns its text
+type Func func(ctx context.Context, p Probe) (string, error)
+
+// Result is the outcome of one probe run
+type Result struct {
+	Time       time.Time `json:"time"`
+	Passed     bool      `json:"passed"`
+	DurationMS float64   `json:"duration_ms"`
+	// Failure is one of error, mismatch or latency when the run failed
+	Failure string `json:"failure,omitempty"`
+	Detail  string `json:"detail,omitempty"`
+}
+
+// Status summarises a probe and its recent results, newest first
+type Status struct {
+	Name          string   `json:"name"`
+	Model         string   `json:"model,omitempty"`
+	Interval      string   `json:"interval"`
+	LatencyBudget string   `json:"latency_budget,omitempty"`
+	Passing       *bool    `json:"passing"`
+	Passed        int      `json:"passed"`
+	Failed        int      `json:"failed"`
+	History       []Result `json:"history"`
+}
+
+// Runner runs probes periodically and keeps their recent history
+type Runner struct {
+	probes   []Probe
+	complete Func
+
+	mutex   sync.RWMutex
+	history map[string][]Result
+}
+
+// Load builds a runner from a JSON probes file. An empty path yields nil.
+func Load(path string, complete Func) (*Runner, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read probes file: %w", err)
+	}
+	var cfg Config
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse probes file: %w", err)
+	}
+	return New(cfg, complete)
+}
+
+// New builds a runner from a configuration
+func New(cfg Config, complete Func) (*Runner, error) {
+	interval := DefaultInterval
+	if cfg.Interval != "" {
+		d, err := parsePositive(cfg.Interval)
+		if err != nil {
+			return nil, fmt.Errorf("interval: %w", err)
+		}
+		interval = d
+	}
+
+	r := &Runner{complete: complete, history: make(map[string][]Result)}
+	for i, spec := range cfg.Probes {
+		p, err := newProbe(spec, interval)
+		if err != nil {
+			return nil, fmt.Errorf("probe %d: %w", i+1, err)
+		}
+		if _, dup := r.history[p.Name]; dup {
+			return nil, fmt.Errorf("duplicate probe name %q", p.Name)
+		}
+		r.history[p.Name] = nil
+		r.probes = append(r.probes, p)
+	}
+	return r, nil
+}
+
+func newProbe(spec Spec, interval time.Duration) (Probe, error) {
+	p := Probe{
+		Name:      strings.TrimSpace(spec.Name),
+		Model:     spec.Model,
+		Prompt:    spec.Prompt,
+		Expect:    spec.Expect,
+		Interval:  interval,
+		Timeout:   DefaultTimeout,
+		MaxTokens: spec.MaxTokens,
+	}
+	if p.Name == "" {
+		return Probe{}, fmt.Errorf("name is required")
+	}
+	if strings.TrimSpace(p.Prompt) == "" {
+		return Probe{}, fmt.Errorf("%s: prompt is required", p.Name)
+	}
+	if p.MaxTokens <= 0 {
+		p.MaxTokens = DefaultMaxTokens
+	}
+
+	var err error
+	if spec.Interval != "" {
+		if p.Interval, err = parsePositive(spec.Interval); err != nil {
+			return Probe{}, fmt.Errorf("%s: interval: %w", p.Name, err)
+		}
+	}
+	if spec.Timeout != "" {
+		if p.Timeout, err = parsePositive(spec.Timeout); err != nil {
+			return Probe{}, fmt.Errorf("%s: timeout: %w", p.Name, err)
+		}
+	}
+	if spec.LatencyBudget != "" {
+		if p.LatencyBudget, err = parsePositive(spec.LatencyBudget); err != nil {
+			return Probe{}, fmt.Errorf("%s: latency_budget: %w", p.Name, err)
+		}
+	}
+	return p, nil
+}
+
+func parsePositive(value string) (time.Duration, error) {
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, err
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("must be positive")
+	}
+	return d, nil
+}
+
+// Len returns the number of configured probes
+func (r *Runner) Len() int {
+	return len(r.probes)
+}
+
+// Run runs every probe on its interval until ctx is done. Each probe runs
+// once right away.
+func (r *Runner) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, p := range r.probes {
+		wg.Add(1)
+		go func(p Probe) {
+			defer wg.Done()
+			ticker := time.NewTicker(p.Interval)
+			defer ticker.Stop()
+			for {
+				r.RunOnce(ctx, p)
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+				}
+			}
+		}(p)
+	}
+	wg.Wait()
+}
+
+// RunOnce runs a single probe and records the result
+func (r *Runner) RunOnce(ctx context.Context, p Probe) Result {
+	runCtx, cancel := context.WithTimeout(ctx, p.Timeout)
+	defer cancel()
+
+	start := time.Now()
+	text, err := r.complete(runCtx, p)
+	elapsed := time.Since(start)
+
+	result := Result{Time: start.UTC(), Passed: true, DurationMS: float64(elapsed.Microseconds()) / 1000}
+	switch {
+	case err != nil:
+		result.Passed, result.Failure, result.Detail = false, FailureError, err.Error()
+	case !matches(text, p.Expect):
+		result.Passed, result.Failure = false, FailureMismatch
+		result.Detail = fmt.Sprintf("expected %q in %q", p.Expect, truncate(text, 200))
+	case p.LatencyBudget > 0 && elapsed > p.LatencyBudget:
+		result.Passed, result.Failure = false, FailureLatency
+		result.Detail = fmt.Sprintf("took %s, budget %s", elapsed.Round(time.Millisecond), p.LatencyBudget)
+	}
+	if ctx.Err() != nil {
+		// Shutting down; the run says nothing about the upstream
+		return result
+	}
+
+	r.record(p, result, elapsed)
+	return result
+}
+
+func (r *Runner) record(p Probe, result Result, elapsed time.Duration) {
+	outcome := "pass"
+	if !result.Passed {
+		outcome = result.Failure
+		slog.Warn("🔬 Synthetic probe failed", "probe", p.Name, "failure", result.Failure, "detail", result.Detail, "duration", elapsed)
+	} else {
+		slog.Debug("Synthetic probe passed", "probe", p.Name, "duration", elapsed)
+	}
+	probeRuns.With(p.Name, outcome).Inc()
+	probeLatency.With(p.Name).Set(elapsed.Seconds())
+	if result.Passed {
+		probeUp.With(p.Name).Set(1)
+	} else {
+		probeUp.With(p.Name).Set(0)
+	}
+
+	r.mutex.Lock()
+	history := append(r.history[p.Name], result)
+	if len(history) > historySize {
+		history = history[len(history)-historySize:]
+	}
+	r.history[p.Name] = history
+	r.mutex.Unlock()
+}
+
+// Status returns every probe with its recent results
+func (r *Runner) Status() []Status {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	statuses := make([]Status, 0, len(r.probes))
+	for _, p := range r.probes {
+		history := r.history[p.Name]
+		status := Status{
+			Name:     p.Name,
+			Model:    p.Model,
+			Interval: p.Interval.String(),
+			History:  make([]Result, 0, len(history)),
+		}
+		if p.LatencyBudget > 0 {
+			status.LatencyBudget = p.LatencyBudget.String()
+		}
+		for i := len(history) - 1; i >= 0; i-- {
+			if history[i].Passed {
+				status.Passed++
+			} else {
+				status.Failed++
+			}
+			status.History = append(status.History, history[i])
+		}
+		if len(history) > 0 {
+			passing := history[len(history)-1].Passed
+			status.Passing = &passing
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
+
+// matches reports whether text contains expect, ignoring case. Without an
+// expectation any non-empty completion passes.
+func matches(text, expect string) bool {
+	if expect == "" {
+		return strings.TrimSpace(text) != ""
+	}
+	return strings.Contains(strings.ToLower(text), strings.ToLower(expect))
+}
+
+func truncate(text string, n int) string {
+	if len(text) <= n {
+		return text
+	}
+	return text[:n] + "..."
+}
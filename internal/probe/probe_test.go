@@ -0,0 +1,203 @@
+package probe
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/devstroop/reai/internal/metrics"
+)
+
+// outcome is a scripted completion: its text, error and how long it takes
+type outcome struct {
+	text  string
+	err   error
+	delay time.Duration
+}
+
+// newRunner builds a runner whose completions follow outcomes in order
+func newRunner(t *testing.T, spec Spec, outcomes ...outcome) (*Runner, Probe) {
+	t.Helper()
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	runs := 0
+	r, err := New(Config{Probes: []Spec{spec}}, func(ctx context.Context, p Probe) (string, error) {
+		o := outcomes[runs%len(outcomes)]
+		runs++
+		select {
+		case <-time.After(o.delay):
+		case <-ctx.Done():
+			return "", ctx.Err()
+		}
+		return o.text, o.err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r, r.probes[0]
+}
+
+// runs returns reai_probe_runs_total for the probe and result
+func runs(t *testing.T, probe, result string) float64 {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	metrics.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	re := regexp.MustCompile(fmt.Sprintf(`(?m)^reai_probe_runs_total\{probe=%q,result=%q\} (\S+)$`, probe, result))
+	m := re.FindStringSubmatch(rec.Body.String())
+	if m == nil {
+		return 0
+	}
+	v, err := strconv.ParseFloat(m[1], 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func TestRunOnce(t *testing.T) {
+	tests := []struct {
+		name    string
+		spec    Spec
+		outcome outcome
+		failure string
+	}{
+		{"pass", Spec{Expect: "PONG"}, outcome{text: "pong!"}, ""},
+		{"any completion", Spec{}, outcome{text: "hello"}, ""},
+		{"empty completion", Spec{}, outcome{text: "  "}, FailureMismatch},
+		{"mismatch", Spec{Expect: "pong"}, outcome{text: "ping"}, FailureMismatch},
+		{"error", Spec{Expect: "pong"}, outcome{err: errors.New("upstream down")}, FailureError},
+		{"latency", Spec{LatencyBudget: "10ms"}, outcome{text: "pong", delay: 30 * time.Millisecond}, FailureLatency},
+		{"timeout", Spec{Timeout: "10ms"}, outcome{text: "pong", delay: time.Second}, FailureError},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.spec.Name = fmt.Sprintf("run-once-%d", i)
+			tt.spec.Prompt = "ping"
+			r, p := newRunner(t, tt.spec, tt.outcome)
+
+			result := r.RunOnce(context.Background(), p)
+			if result.Passed != (tt.failure == "") || result.Failure != tt.failure {
+				t.Fatalf("got %+v, want failure %q", result, tt.failure)
+			}
+			want := tt.failure
+			if want == "" {
+				want = "pass"
+			}
+			if n := runs(t, p.Name, want); n != 1 {
+				t.Errorf("%s runs %v, want 1", want, n)
+			}
+			up := probeUp.With(p.Name).Value()
+			if (up == 1) != result.Passed {
+				t.Errorf("reai_probe_success %v", up)
+			}
+		})
+	}
+}
+
+func TestStatus(t *testing.T) {
+	r, p := newRunner(t, Spec{Name: "status", Prompt: "ping", Expect: "pong"},
+		outcome{text: "pong"},
+		outcome{text: "pong"},
+		outcome{err: errors.New("upstream down")},
+		outcome{text: "nope"},
+	)
+	if status := r.Status()[0]; status.Passing != nil || len(status.History) != 0 {
+		t.Fatalf("before any run: %+v", status)
+	}
+
+	for range 4 {
+		r.RunOnce(context.Background(), p)
+	}
+	status := r.Status()[0]
+	if status.Passed != 2 || status.Failed != 2 {
+		t.Errorf("passed %d failed %d, want 2 and 2", status.Passed, status.Failed)
+	}
+	if status.Passing == nil || *status.Passing {
+		t.Errorf("passing %v after a failed run", status.Passing)
+	}
+	// Newest first
+	if status.History[0].Failure != FailureMismatch || status.History[1].Failure != FailureError || !status.History[3].Passed {
+		t.Errorf("history %+v", status.History)
+	}
+	if n := runs(t, "status", "pass"); n != 2 {
+		t.Errorf("pass runs %v, want 2", n)
+	}
+
+	// A pass turns the probe green again
+	r.RunOnce(context.Background(), p)
+	if status := r.Status()[0]; status.Passing == nil || !*status.Passing {
+		t.Errorf("passing %v after a passing run", status.Passing)
+	}
+}
+
+func TestStatusHistoryLimit(t *testing.T) {
+	r, p := newRunner(t, Spec{Name: "history", Prompt: "ping"}, outcome{text: "pong"}, outcome{err: errors.New("down")})
+	for range historySize + 10 {
+		r.RunOnce(context.Background(), p)
+	}
+	status := r.Status()[0]
+	if len(status.History) != historySize {
+		t.Fatalf("%d results kept, want %d", len(status.History), historySize)
+	}
+	// The counts cover the kept history only
+	if status.Passed+status.Failed != historySize {
+		t.Errorf("passed %d failed %d", status.Passed, status.Failed)
+	}
+	if n := runs(t, "history", "pass") + runs(t, "history", FailureError); n != historySize+10 {
+		t.Errorf("%v runs counted, want %d", n, historySize+10)
+	}
+}
+
+func TestRunOnceShuttingDown(t *testing.T) {
+	r, p := newRunner(t, Spec{Name: "shutdown", Prompt: "ping"}, outcome{text: "pong", delay: time.Second})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// A run cut short by shutdown is not a failure of the upstream
+	if result := r.RunOnce(ctx, p); result.Passed {
+		t.Fatalf("got %+v", result)
+	}
+	if status := r.Status()[0]; status.Failed != 0 || len(status.History) != 0 {
+		t.Errorf("recorded %+v", status)
+	}
+	if n := runs(t, "shutdown", FailureError); n != 0 {
+		t.Errorf("error runs %v, want 0", n)
+	}
+}
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  Config
+		want string
+	}{
+		{"no name", Config{Probes: []Spec{{Prompt: "ping"}}}, "name is required"},
+		{"no prompt", Config{Probes: []Spec{{Name: "a"}}}, "prompt is required"},
+		{"duplicate", Config{Probes: []Spec{{Name: "a", Prompt: "ping"}, {Name: "a", Prompt: "ping"}}}, "duplicate probe name"},
+		{"bad interval", Config{Interval: "0s", Probes: []Spec{{Name: "a", Prompt: "ping"}}}, "interval: must be positive"},
+		{"bad budget", Config{Probes: []Spec{{Name: "a", Prompt: "ping", LatencyBudget: "soon"}}}, "latency_budget"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := New(tt.cfg, nil)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("got %v, want %q", err, tt.want)
+			}
+		})
+	}
+
+	r, err := New(Config{Interval: "1m", Probes: []Spec{{Name: "a", Prompt: "ping"}, {Name: "b", Prompt: "ping", Interval: "10s"}}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a, b := r.probes[0], r.probes[1]; a.Interval != time.Minute || b.Interval != 10*time.Second || a.Timeout != DefaultTimeout || a.MaxTokens != DefaultMaxTokens {
+		t.Errorf("probes %+v %+v", a, b)
+	}
+}
@@ -0,0 +1,243 @@
+package prompts
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// ErrNotFound is returned for unknown template names
+var ErrNotFound = errors.New("prompt template not found")
+
+// ErrExists is returned when creating a template under a name already taken
+var ErrExists = errors.New("prompt template already exists")
+
+// ErrInvalid is returned for templates that can't be stored
+var ErrInvalid = errors.New("invalid prompt template")
+
+// ErrTooLarge is returned when a template renders more than the limit
+var ErrTooLarge = errors.New("rendered prompt is too large")
+
+var validName = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,128}$`)
+
+// Message is a chat message of a template. Content is a Go template over
+// the variables of the request.
+type Message struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+// Template is a named, stored prompt
+type Template struct {
+	Name        string    `json:"name"`
+	Description string    `json:"description,omitempty"`
+	Messages    []Message `json:"messages"`
+	Created     time.Time `json:"created"`
+	Updated     time.Time `json:"updated"`
+
+	// parsed holds the templates of Messages, in the same order
+	parsed []*template.Template
+}
+
+// Store keeps prompt templates in memory and persists each one as a JSON file.
+// Templates are shared by every caller of the instance.
+type Store struct {
+	mutex     sync.RWMutex
+	dir       string
+	templates map[string]*Template
+}
+
+// Open loads the templates stored in dir, creating it if needed
+func Open(dir string) (*Store, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create prompts directory: %w", err)
+	}
+
+	s := &Store{dir: dir}
+	if err := s.Reload(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Reload reads the templates of the directory again, for files edited by
+// hand. The current templates stay in place when one is invalid.
+func (s *Store) Reload() error {
+	// Held throughout so that templates put meanwhile aren't lost
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	paths, err := filepath.Glob(filepath.Join(s.dir, "*.json"))
+	if err != nil {
+		return err
+	}
+	templates := make(map[string]*Template, len(paths))
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("failed to read prompt template: %w", err)
+		}
+		var t Template
+		if err := json.Unmarshal(data, &t); err != nil {
+			return fmt.Errorf("failed to parse prompt template %s: %w", filepath.Base(path), err)
+		}
+		if err := t.compile(); err != nil {
+			return fmt.Errorf("prompt template %s: %w", filepath.Base(path), err)
+		}
+		templates[t.Name] = &t
+	}
+	s.templates = templates
+	return nil
+}
+
+// Len returns the number of stored templates
+func (s *Store) Len() int {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return len(s.templates)
+}
+
+// Get returns the template stored under name
+func (s *Store) Get(name string) (*Template, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	t, ok := s.templates[name]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return t, nil
+}
+
+// List returns the stored templates sorted by name
+func (s *Store) List() []*Template {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	list := make([]*Template, 0, len(s.templates))
+	for _, t := range s.templates {
+		list = append(list, t)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+	return list
+}
+
+// Put stores t, replacing the template of the same name unless create is
+// set, in which case an existing name fails with ErrExists. It returns the
+// stored template.
+func (s *Store) Put(t Template, create bool) (*Template, error) {
+	if !validName.MatchString(t.Name) {
+		return nil, fmt.Errorf("%w: name %q must be letters, digits, '.', '_' and '-'", ErrInvalid, t.Name)
+	}
+	if err := t.compile(); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalid, err)
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	now := time.Now().UTC()
+	t.Created, t.Updated = now, now
+	if existing, ok := s.templates[t.Name]; ok {
+		if create {
+			return nil, ErrExists
+		}
+		t.Created = existing.Created
+	}
+
+	data, err := json.Marshal(&t)
+	if err != nil {
+		return nil, err
+	}
+	tmp := s.path(t.Name) + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return nil, fmt.Errorf("failed to write prompt template: %w", err)
+	}
+	if err := os.Rename(tmp, s.path(t.Name)); err != nil {
+		os.Remove(tmp)
+		return nil, fmt.Errorf("failed to write prompt template: %w", err)
+	}
+	s.templates[t.Name] = &t
+	return &t, nil
+}
+
+// Delete removes the template stored under name
+func (s *Store) Delete(name string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, ok := s.templates[name]; !ok {
+		return ErrNotFound
+	}
+	if err := os.Remove(s.path(name)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	delete(s.templates, name)
+	return nil
+}
+
+// Render executes the messages of t with variables. A variable the template
+// uses but variables lacks is an error, as is output beyond maxBytes.
+func (t *Template) Render(variables map[string]interface{}, maxBytes int) ([]Message, error) {
+	if variables == nil {
+		variables = map[string]interface{}{}
+	}
+	out := &limitedBuffer{limit: maxBytes}
+	messages := make([]Message, len(t.Messages))
+	for i, tmpl := range t.parsed {
+		start := out.Len()
+		if err := tmpl.Execute(out, variables); err != nil {
+			if errors.Is(err, ErrTooLarge) {
+				return nil, ErrTooLarge
+			}
+			return nil, fmt.Errorf("template %s: %w", t.Name, err)
+		}
+		messages[i] = Message{Role: t.Messages[i].Role, Content: out.String()[start:]}
+	}
+	return messages, nil
+}
+
+// compile validates the messages of t and parses their templates
+func (t *Template) compile() error {
+	if len(t.Messages) == 0 {
+		return fmt.Errorf("template %s has no messages", t.Name)
+	}
+	t.parsed = make([]*template.Template, len(t.Messages))
+	for i, msg := range t.Messages {
+		switch msg.Role {
+		case "system", "user", "assistant":
+		default:
+			return fmt.Errorf("message %d has invalid role %q", i, msg.Role)
+		}
+		parsed, err := template.New(fmt.Sprintf("message %d", i)).Option("missingkey=error").Parse(msg.Content)
+		if err != nil {
+			return fmt.Errorf("message %d: %w", i, err)
+		}
+		t.parsed[i] = parsed
+	}
+	return nil
+}
+
+func (s *Store) path(name string) string {
+	return filepath.Join(s.dir, name+".json")
+}
+
+// limitedBuffer fails writes past limit, so a template can't render
+// unbounded output (no limit when 0)
+type limitedBuffer struct {
+	bytes.Buffer
+	limit int
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if b.limit > 0 && b.Len()+len(p) > b.limit {
+		return 0, ErrTooLarge
+	}
+	return b.Buffer.Write(p)
+}
@@ -0,0 +1,136 @@
+package provider
+
+import (
+	"context"
+	"sync"
+
+	"github.com/devstroop/reai/internal/copilot"
+	"github.com/devstroop/reai/internal/metrics"
+	"github.com/devstroop/reai/pkg/errors"
+)
+
+var coalescedRequests = metrics.NewCounter("reai_coalesced_requests_total", "Completions served by joining an identical request already in flight")
+
+// Coalescer passes requests to a provider, sending identical requests that
+// are in flight at the same time upstream only once. Every caller gets all
+// the chunks of the shared completion, from the first one on.
+type Coalescer struct {
+	Provider
+
+	mutex sync.Mutex
+	calls map[string]*sharedCall
+}
+
+// sharedCall is an upstream completion followed by one or more callers
+type sharedCall struct {
+	// waiters is guarded by the Coalescer mutex
+	waiters int
+	cancel  context.CancelFunc
+
+	mutex  sync.Mutex
+	chunks []copilot.CompletionChunk
+	done   bool
+	err    error
+	// updated is closed and replaced whenever a chunk arrives or the call ends
+	updated chan struct{}
+}
+
+// Coalesce wraps p so that identical concurrent requests share one completion
+func Coalesce(p Provider) *Coalescer {
+	return &Coalescer{Provider: p, calls: make(map[string]*sharedCall)}
+}
+
+// StreamCompletion joins the call in flight for an identical request, or
+// starts one. The upstream call runs until it finishes or every caller
+// following it went away; a caller leaving early doesn't cut it short for
+// the others.
+func (c *Coalescer) StreamCompletion(ctx context.Context, req *copilot.CompletionRequest, onChunk func(copilot.CompletionChunk) error) error {
+	key := newRecordedRequest(req).hash()
+
+	c.mutex.Lock()
+	call, joined := c.calls[key]
+	if !joined {
+		// The call outlives the caller that started it, but keeps its
+		// deadline and request-scoped values
+		upstreamCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+		if deadline, ok := ctx.Deadline(); ok {
+			upstreamCtx, cancel = context.WithDeadline(context.WithoutCancel(ctx), deadline)
+		}
+		call = &sharedCall{cancel: cancel, updated: make(chan struct{})}
+		c.calls[key] = call
+		upstream := *req
+		go c.run(upstreamCtx, key, call, &upstream)
+	}
+	call.waiters++
+	c.mutex.Unlock()
+	defer c.leave(key, call)
+	if joined {
+		coalescedRequests.Inc()
+	}
+
+	next := 0
+	for {
+		call.mutex.Lock()
+		pending := call.chunks[next:]
+		done, err, updated := call.done, call.err, call.updated
+		call.mutex.Unlock()
+
+		for _, chunk := range pending {
+			if err := onChunk(chunk); err != nil {
+				return err
+			}
+		}
+		next += len(pending)
+		if done {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			if ctx.Err() == context.DeadlineExceeded {
+				return errors.NewDeadlineExceededError("upstream did not finish in time")
+			}
+			return ctx.Err()
+		case <-updated:
+		}
+	}
+}
+
+// run sends the shared request upstream and hands its chunks to the callers
+func (c *Coalescer) run(ctx context.Context, key string, call *sharedCall, req *copilot.CompletionRequest) {
+	defer call.cancel()
+	err := c.Provider.StreamCompletion(ctx, req, func(chunk copilot.CompletionChunk) error {
+		call.mutex.Lock()
+		defer call.mutex.Unlock()
+		call.chunks = append(call.chunks, chunk)
+		close(call.updated)
+		call.updated = make(chan struct{})
+		return nil
+	})
+
+	// Requests arriving from now on start a call of their own
+	c.mutex.Lock()
+	if c.calls[key] == call {
+		delete(c.calls, key)
+	}
+	c.mutex.Unlock()
+
+	call.mutex.Lock()
+	call.done, call.err = true, err
+	close(call.updated)
+	call.mutex.Unlock()
+}
+
+// leave stops following call, cancelling it when nobody else does
+func (c *Coalescer) leave(key string, call *sharedCall) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	call.waiters--
+	if call.waiters > 0 {
+		return
+	}
+	if c.calls[key] == call {
+		delete(c.calls, key)
+	}
+	call.cancel()
+}
@@ -0,0 +1,250 @@
+package provider
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/devstroop/reai/internal/copilot"
+	"github.com/devstroop/reai/pkg/errors"
+)
+
+// ModeMock serves canned completions instead of Copilot
+const ModeMock = "mock"
+
+// DefaultMockResponse echoes the prompt when no canned response matches
+const DefaultMockResponse = "This is a mock completion from {{.Model}}. You said: {{.Prompt}}"
+
+// MockConfig is the on-disk format of MOCK_RESPONSES_FILE
+type MockConfig struct {
+	// Responses are tried in order; the first match answers the request
+	Responses []MockResponse `json:"responses"`
+	// Default answers requests no response matches (DefaultMockResponse when empty)
+	Default string `json:"default,omitempty"`
+	// Models are listed by /v1/models (just "mock" when empty); any model is accepted
+	Models []string `json:"models,omitempty"`
+}
+
+// MockResponse is a canned completion. Response is a Go template over Model,
+// Prompt (the prompt or the last message) and Messages.
+type MockResponse struct {
+	// Match is a regular expression on the prompt; empty matches everything
+	Match string `json:"match,omitempty"`
+	// Model limits the response to one model
+	Model    string `json:"model,omitempty"`
+	Response string `json:"response"`
+	// Reasoning is streamed as reasoning text before the response, like a
+	// thinking model does. It is a template too.
+	Reasoning string `json:"reasoning,omitempty"`
+}
+
+// mockTemplateData is what mock response templates see
+type mockTemplateData struct {
+	Model    string
+	Prompt   string
+	Messages []copilot.Message
+}
+
+type mockRule struct {
+	match     *regexp.Regexp
+	model     string
+	response  *template.Template
+	reasoning *template.Template
+}
+
+// Mock is a built-in provider answering with canned or templated completions,
+// streamed word by word, so clients can be developed without a Copilot
+// subscription
+type Mock struct {
+	// mutex guards the responses and models, which Reload replaces
+	mutex      sync.RWMutex
+	rules      []mockRule
+	fallback   *template.Template
+	models     []copilot.ModelInfo
+	tokenDelay time.Duration
+}
+
+// LoadMock reads the mock responses at path; an empty path echoes every
+// prompt. tokenDelay is the pause between streamed words.
+func LoadMock(path string, tokenDelay time.Duration) (*Mock, error) {
+	var cfg MockConfig
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read mock responses file: %w", err)
+		}
+		if err := json.Unmarshal(data, &cfg); err != nil {
+			return nil, fmt.Errorf("failed to parse mock responses file: %w", err)
+		}
+	}
+	return NewMock(cfg, tokenDelay)
+}
+
+// NewMock builds a mock provider from its configuration
+func NewMock(cfg MockConfig, tokenDelay time.Duration) (*Mock, error) {
+	m := &Mock{tokenDelay: tokenDelay}
+	for i, response := range cfg.Responses {
+		var rule mockRule
+		var err error
+		if response.Match != "" {
+			if rule.match, err = regexp.Compile(response.Match); err != nil {
+				return nil, fmt.Errorf("mock response %d: invalid match: %w", i+1, err)
+			}
+		}
+		if rule.response, err = template.New(fmt.Sprintf("response %d", i+1)).Parse(response.Response); err != nil {
+			return nil, fmt.Errorf("mock response %d: %w", i+1, err)
+		}
+		if response.Reasoning != "" {
+			if rule.reasoning, err = template.New(fmt.Sprintf("reasoning %d", i+1)).Parse(response.Reasoning); err != nil {
+				return nil, fmt.Errorf("mock response %d: reasoning: %w", i+1, err)
+			}
+		}
+		rule.model = response.Model
+		m.rules = append(m.rules, rule)
+	}
+
+	fallback := cfg.Default
+	if fallback == "" {
+		fallback = DefaultMockResponse
+	}
+	var err error
+	if m.fallback, err = template.New("default").Parse(fallback); err != nil {
+		return nil, fmt.Errorf("mock default response: %w", err)
+	}
+
+	models := cfg.Models
+	if len(models) == 0 {
+		models = []string{ModeMock}
+	}
+	m.models = specModels(Spec{Name: ModeMock, Models: models})
+	return m, nil
+}
+
+// Reload reads the mock responses at path again. The current responses
+// stay in place when the file is invalid.
+func (m *Mock) Reload(path string) error {
+	loaded, err := LoadMock(path, m.tokenDelay)
+	if err != nil {
+		return err
+	}
+	m.mutex.Lock()
+	m.rules, m.fallback, m.models = loaded.rules, loaded.fallback, loaded.models
+	m.mutex.Unlock()
+	return nil
+}
+
+func (m *Mock) Name() string {
+	return ModeMock
+}
+
+// UnsupportedParameters only reports tools, which the mock never calls; it
+// ignores sampling
+func (m *Mock) UnsupportedParameters(req *copilot.CompletionRequest) []string {
+	if len(req.Tools) > 0 {
+		return []string{"tools"}
+	}
+	return nil
+}
+
+// GetModelLimits is nil so that any prompt fits
+func (m *Mock) GetModelLimits(ctx context.Context, model string) *copilot.ModelLimits {
+	return nil
+}
+
+// GetAvailableModels lists the configured mock models
+func (m *Mock) GetAvailableModels(ctx context.Context) ([]copilot.ModelInfo, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return m.models, nil
+}
+
+// StreamCompletion renders the response of req and streams it a word at a
+// time. MaxTokens cuts it off after as many words, as a real model would.
+func (m *Mock) StreamCompletion(ctx context.Context, req *copilot.CompletionRequest, onChunk func(copilot.CompletionChunk) error) error {
+	reasoning, text, err := m.render(req)
+	if err != nil {
+		return errors.NewProviderError(fmt.Sprintf("mock response: %v", err))
+	}
+
+	// Reasoning is streamed first and doesn't count against max_tokens
+	if reasoning != "" {
+		for _, word := range strings.SplitAfter(reasoning, " ") {
+			if err := m.pause(ctx); err != nil {
+				return err
+			}
+			if err := onChunk(copilot.CompletionChunk{Reasoning: word}); err != nil {
+				return err
+			}
+		}
+	}
+
+	words := strings.SplitAfter(text, " ")
+	finishReason := copilot.FinishReasonStop
+	if req.MaxTokens > 0 && len(words) > req.MaxTokens {
+		words, finishReason = words[:req.MaxTokens], copilot.FinishReasonLength
+	}
+	for i, word := range words {
+		if i > 0 || reasoning != "" {
+			if err := m.pause(ctx); err != nil {
+				return err
+			}
+		}
+		chunk := copilot.CompletionChunk{Text: word}
+		if i == len(words)-1 {
+			chunk.FinishReason = finishReason
+		}
+		if err := onChunk(chunk); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pause waits the token delay between streamed words
+func (m *Mock) pause(ctx context.Context) error {
+	if m.tokenDelay <= 0 {
+		return nil
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(m.tokenDelay):
+		return nil
+	}
+}
+
+// render executes the first response matching req, and its reasoning
+func (m *Mock) render(req *copilot.CompletionRequest) (string, string, error) {
+	data := mockTemplateData{Model: req.Model, Prompt: req.Prompt, Messages: req.Messages}
+	if n := len(req.Messages); n > 0 {
+		data.Prompt = req.Messages[n-1].Content
+	}
+
+	m.mutex.RLock()
+	response, rules := m.fallback, m.rules
+	m.mutex.RUnlock()
+	var reasoning *template.Template
+	for _, rule := range rules {
+		if (rule.model == "" || rule.model == req.Model) && (rule.match == nil || rule.match.MatchString(data.Prompt)) {
+			response, reasoning = rule.response, rule.reasoning
+			break
+		}
+	}
+	var out, thought bytes.Buffer
+	if err := response.Execute(&out, data); err != nil {
+		return "", "", err
+	}
+	if reasoning != nil {
+		if err := reasoning.Execute(&thought, data); err != nil {
+			return "", "", err
+		}
+	}
+	return thought.String(), out.String(), nil
+}
@@ -0,0 +1,271 @@
+package provider
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/devstroop/reai/internal/copilot"
+	"github.com/devstroop/reai/pkg/errors"
+)
+
+// ollamaProvider serves models from a local Ollama server through its native
+// chat API
+type ollamaProvider struct {
+	name    string
+	baseURL string
+	apiKey  string
+	headers map[string]string
+	models  []copilot.ModelInfo
+	limits  *copilot.ModelLimits
+	client  *http.Client
+}
+
+func newOllamaProvider(spec Spec) (*ollamaProvider, error) {
+	baseURL := spec.BaseURL
+	if baseURL == "" {
+		baseURL = "http://localhost:11434"
+	}
+	u, err := url.Parse(baseURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("base_url must be an http or https URL")
+	}
+	p := &ollamaProvider{
+		name:    spec.Name,
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		apiKey:  specAPIKey(spec),
+		headers: spec.Headers,
+		limits:  specLimits(spec),
+		client:  &http.Client{},
+	}
+	if len(spec.Models) > 0 {
+		p.models = specModels(spec)
+	}
+	return p, nil
+}
+
+func (p *ollamaProvider) Name() string {
+	return p.name
+}
+
+// UnsupportedParameters lists what Ollama's chat API has no option for
+func (p *ollamaProvider) UnsupportedParameters(req *copilot.CompletionRequest) []string {
+	var ignored []string
+	if req.Logprobs != nil {
+		ignored = append(ignored, "logprobs")
+	}
+	if req.User != "" {
+		ignored = append(ignored, "user")
+	}
+	if req.Suffix != "" {
+		ignored = append(ignored, "suffix")
+	}
+	if req.ReasoningEffort != "" {
+		ignored = append(ignored, "reasoning_effort")
+	}
+	if len(req.Tools) > 0 {
+		ignored = append(ignored, "tools")
+	}
+	return ignored
+}
+
+func (p *ollamaProvider) GetModelLimits(ctx context.Context, model string) *copilot.ModelLimits {
+	return p.limits
+}
+
+func (p *ollamaProvider) do(ctx context.Context, method, path string, body interface{}) (*http.Response, error) {
+	var data []byte
+	if body != nil {
+		var err error
+		if data, err = json.Marshal(body); err != nil {
+			return nil, err
+		}
+	}
+	req, err := http.NewRequestWithContext(ctx, method, p.baseURL+path, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if p.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+p.apiKey)
+	}
+	for name, value := range p.headers {
+		req.Header.Set(name, value)
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, requestError(ctx, p.name, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		return nil, responseError(p.name, resp)
+	}
+	return resp, nil
+}
+
+// ollamaChunk is one line of a streamed /api/chat response
+type ollamaChunk struct {
+	Message struct {
+		Content  string `json:"content"`
+		Thinking string `json:"thinking"`
+	} `json:"message"`
+	Done       bool   `json:"done"`
+	DoneReason string `json:"done_reason"`
+	Error      string `json:"error"`
+}
+
+// ollamaMessage is a chat message in Ollama's format, which differs from
+// OpenAI's for tool calls: arguments are an object and tool results name the
+// tool rather than the call
+type ollamaMessage struct {
+	Role      string           `json:"role"`
+	Content   string           `json:"content"`
+	ToolCalls []ollamaToolCall `json:"tool_calls,omitempty"`
+	ToolName  string           `json:"tool_name,omitempty"`
+}
+
+type ollamaToolCall struct {
+	Function struct {
+		Name      string          `json:"name"`
+		Arguments json.RawMessage `json:"arguments"`
+	} `json:"function"`
+}
+
+// ollamaMessages converts the messages of req to Ollama's format
+func ollamaMessages(req *copilot.CompletionRequest) []ollamaMessage {
+	names := make(map[string]string)
+	var messages []ollamaMessage
+	for _, msg := range chatMessages(req) {
+		out := ollamaMessage{Role: msg.Role, Content: msg.Content, ToolName: msg.Name}
+		for _, call := range msg.ToolCalls {
+			names[call.ID] = call.Function.Name
+			var tc ollamaToolCall
+			tc.Function.Name = call.Function.Name
+			tc.Function.Arguments = json.RawMessage(call.Function.Arguments)
+			if !json.Valid(tc.Function.Arguments) {
+				tc.Function.Arguments = json.RawMessage("{}")
+			}
+			out.ToolCalls = append(out.ToolCalls, tc)
+		}
+		if msg.ToolCallID != "" && out.ToolName == "" {
+			out.ToolName = names[msg.ToolCallID]
+		}
+		messages = append(messages, out)
+	}
+	return messages
+}
+
+func (p *ollamaProvider) StreamCompletion(ctx context.Context, req *copilot.CompletionRequest, onChunk func(copilot.CompletionChunk) error) error {
+	options := map[string]interface{}{}
+	if req.Temperature != nil {
+		options["temperature"] = *req.Temperature
+	}
+	if req.MaxTokens > 0 {
+		options["num_predict"] = req.MaxTokens
+	}
+	if req.TopP != nil {
+		options["top_p"] = *req.TopP
+	}
+	if req.PresencePenalty != nil {
+		options["presence_penalty"] = *req.PresencePenalty
+	}
+	if req.FrequencyPenalty != nil {
+		options["frequency_penalty"] = *req.FrequencyPenalty
+	}
+	if req.Seed != nil {
+		options["seed"] = *req.Seed
+	}
+
+	body := map[string]interface{}{
+		"model":    req.Model,
+		"messages": ollamaMessages(req),
+		"stream":   true,
+		"options":  options,
+	}
+	if req.IncludeReasoning {
+		// Thinking models only separate their reasoning when asked to
+		body["think"] = true
+	}
+	resp, err := p.do(ctx, http.MethodPost, "/api/chat", body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var event ollamaChunk
+		if err := json.Unmarshal(line, &event); err != nil {
+			continue
+		}
+		if event.Error != "" {
+			return errors.NewProviderError(p.name + ": " + event.Error)
+		}
+
+		chunk := copilot.CompletionChunk{Text: event.Message.Content, Reasoning: event.Message.Thinking}
+		if event.Done {
+			chunk.FinishReason = copilot.FinishReasonStop
+			if event.DoneReason == copilot.FinishReasonLength {
+				chunk.FinishReason = copilot.FinishReasonLength
+			}
+		}
+		if chunk.Text != "" || chunk.FinishReason != "" {
+			if err := onChunk(chunk); err != nil {
+				return err
+			}
+		}
+		if event.Done {
+			return nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return requestError(ctx, p.name, err)
+	}
+	return nil
+}
+
+// GetAvailableModels returns the configured models, or the models pulled
+// into the Ollama server
+func (p *ollamaProvider) GetAvailableModels(ctx context.Context) ([]copilot.ModelInfo, error) {
+	if p.models != nil {
+		return p.models, nil
+	}
+
+	resp, err := p.do(ctx, http.MethodGet, "/api/tags", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var tags struct {
+		Models []struct {
+			Name       string    `json:"name"`
+			ModifiedAt time.Time `json:"modified_at"`
+		} `json:"models"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tags); err != nil {
+		return nil, fmt.Errorf("failed to parse %s models: %w", p.name, err)
+	}
+	models := make([]copilot.ModelInfo, 0, len(tags.Models))
+	for _, m := range tags.Models {
+		models = append(models, copilot.ModelInfo{ID: m.Name, Object: "model", Created: m.ModifiedAt.Unix(), OwnedBy: p.name})
+	}
+	return models, nil
+}
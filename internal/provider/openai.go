@@ -0,0 +1,321 @@
+package provider
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/devstroop/reai/internal/copilot"
+	"github.com/devstroop/reai/pkg/errors"
+)
+
+// defaultAzureAPIVersion is the Azure OpenAI api-version used when none is set
+const defaultAzureAPIVersion = "2024-10-21"
+
+// openAIProvider passes requests through to the chat completions API of
+// OpenAI, an OpenAI compatible server or an Azure OpenAI resource
+type openAIProvider struct {
+	name    string
+	baseURL string
+	apiKey  string
+	headers map[string]string
+	// apiVersion is only set for Azure, which routes by deployment
+	apiVersion string
+	models     []copilot.ModelInfo
+	limits     *copilot.ModelLimits
+	client     *http.Client
+}
+
+func newOpenAIProvider(spec Spec) (*openAIProvider, error) {
+	u, err := url.Parse(spec.BaseURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("base_url must be an http or https URL")
+	}
+	p := &openAIProvider{
+		name:    spec.Name,
+		baseURL: strings.TrimSuffix(spec.BaseURL, "/"),
+		apiKey:  specAPIKey(spec),
+		headers: spec.Headers,
+		limits:  specLimits(spec),
+		client:  &http.Client{},
+	}
+	if len(spec.Models) > 0 {
+		p.models = specModels(spec)
+	}
+	if spec.Type == TypeAzure {
+		p.apiVersion = spec.APIVersion
+		if p.apiVersion == "" {
+			p.apiVersion = defaultAzureAPIVersion
+		}
+		if p.apiKey == "" {
+			return nil, fmt.Errorf("api_key or api_key_env is required")
+		}
+		if len(spec.Models) == 0 {
+			return nil, fmt.Errorf("models must list the deployments to serve")
+		}
+	}
+	return p, nil
+}
+
+func (p *openAIProvider) Name() string {
+	return p.name
+}
+
+// UnsupportedParameters only lists the suffix of fill-in-the-middle requests,
+// which the chat API has no place for
+func (p *openAIProvider) UnsupportedParameters(req *copilot.CompletionRequest) []string {
+	if req.Suffix != "" {
+		return []string{"suffix"}
+	}
+	return nil
+}
+
+func (p *openAIProvider) GetModelLimits(ctx context.Context, model string) *copilot.ModelLimits {
+	return p.limits
+}
+
+// newRequest builds an authenticated request to the API
+func (p *openAIProvider) newRequest(ctx context.Context, method, target string, body interface{}) (*http.Request, error) {
+	var data []byte
+	if body != nil {
+		var err error
+		if data, err = json.Marshal(body); err != nil {
+			return nil, err
+		}
+	}
+	req, err := http.NewRequestWithContext(ctx, method, target, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if p.apiKey != "" {
+		if p.apiVersion != "" {
+			req.Header.Set("api-key", p.apiKey)
+		} else {
+			req.Header.Set("Authorization", "Bearer "+p.apiKey)
+		}
+	}
+	for name, value := range p.headers {
+		req.Header.Set(name, value)
+	}
+	return req, nil
+}
+
+// chatURL returns the chat completions endpoint for model
+func (p *openAIProvider) chatURL(model string) string {
+	if p.apiVersion != "" {
+		return p.baseURL + "/openai/deployments/" + url.PathEscape(model) + "/chat/completions?api-version=" + url.QueryEscape(p.apiVersion)
+	}
+	return p.baseURL + "/chat/completions"
+}
+
+// openAIChunk is the JSON payload of a chat completions streaming event
+type openAIChunk struct {
+	Choices []struct {
+		Delta struct {
+			Content string `json:"content"`
+			// Reasoning text is sent as reasoning_content by DeepSeek, vLLM
+			// and Azure, and as reasoning by OpenRouter
+			ReasoningContent string `json:"reasoning_content"`
+			Reasoning        string `json:"reasoning"`
+			ToolCalls        []struct {
+				Index    int    `json:"index"`
+				ID       string `json:"id"`
+				Function struct {
+					Name      string `json:"name"`
+					Arguments string `json:"arguments"`
+				} `json:"function"`
+			} `json:"tool_calls"`
+		} `json:"delta"`
+		Logprobs *struct {
+			Content []struct {
+				Token       string  `json:"token"`
+				Logprob     float64 `json:"logprob"`
+				TopLogprobs []struct {
+					Token   string  `json:"token"`
+					Logprob float64 `json:"logprob"`
+				} `json:"top_logprobs"`
+			} `json:"content"`
+		} `json:"logprobs"`
+		FinishReason *string `json:"finish_reason"`
+	} `json:"choices"`
+	Error *struct {
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+func (p *openAIProvider) StreamCompletion(ctx context.Context, req *copilot.CompletionRequest, onChunk func(copilot.CompletionChunk) error) error {
+	body := map[string]interface{}{
+		"model":    req.Model,
+		"messages": chatMessages(req),
+		"stream":   true,
+	}
+	if req.Temperature != nil {
+		body["temperature"] = *req.Temperature
+	}
+	if req.MaxTokens > 0 {
+		// Reasoning models reject max_tokens, which excludes reasoning tokens
+		if copilot.IsReasoningModel(req.Model) {
+			body["max_completion_tokens"] = req.MaxTokens
+		} else {
+			body["max_tokens"] = req.MaxTokens
+		}
+	}
+	if req.ReasoningEffort != "" {
+		body["reasoning_effort"] = req.ReasoningEffort
+	}
+	if req.TopP != nil {
+		body["top_p"] = *req.TopP
+	}
+	if req.PresencePenalty != nil {
+		body["presence_penalty"] = *req.PresencePenalty
+	}
+	if req.FrequencyPenalty != nil {
+		body["frequency_penalty"] = *req.FrequencyPenalty
+	}
+	if req.Seed != nil {
+		body["seed"] = *req.Seed
+	}
+	if req.User != "" {
+		body["user"] = req.User
+	}
+	if len(req.Tools) > 0 {
+		body["tools"] = req.Tools
+		if len(req.ToolChoice) > 0 {
+			body["tool_choice"] = req.ToolChoice
+		}
+		if req.ParallelToolCalls != nil {
+			body["parallel_tool_calls"] = *req.ParallelToolCalls
+		}
+	}
+	if req.Logprobs != nil {
+		body["logprobs"] = true
+		if *req.Logprobs > 0 {
+			body["top_logprobs"] = *req.Logprobs
+		}
+	}
+
+	httpReq, err := p.newRequest(ctx, http.MethodPost, p.chatURL(req.Model), body)
+	if err != nil {
+		return err
+	}
+	httpReq.Header.Set("Accept", "text/event-stream")
+	resp, err := p.client.Do(httpReq)
+	if err != nil {
+		return requestError(ctx, p.name, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return responseError(p.name, resp)
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	offset := 0
+	for scanner.Scan() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		data, ok := strings.CutPrefix(scanner.Text(), "data:")
+		if !ok {
+			continue
+		}
+		data = strings.TrimSpace(data)
+		if data == "[DONE]" {
+			return nil
+		}
+
+		var event openAIChunk
+		if err := json.Unmarshal([]byte(data), &event); err != nil {
+			continue
+		}
+		if event.Error != nil {
+			return errors.NewProviderError(p.name + ": " + event.Error.Message)
+		}
+		if len(event.Choices) == 0 {
+			continue
+		}
+
+		choice := event.Choices[0]
+		chunk := copilot.CompletionChunk{Text: choice.Delta.Content, Reasoning: choice.Delta.ReasoningContent}
+		if chunk.Reasoning == "" {
+			chunk.Reasoning = choice.Delta.Reasoning
+		}
+		if choice.FinishReason != nil {
+			chunk.FinishReason = *choice.FinishReason
+		}
+		for _, call := range choice.Delta.ToolCalls {
+			chunk.ToolCalls = append(chunk.ToolCalls, copilot.ToolCallDelta{
+				Index:     call.Index,
+				ID:        call.ID,
+				Name:      call.Function.Name,
+				Arguments: call.Function.Arguments,
+			})
+		}
+		if choice.Logprobs != nil && len(choice.Logprobs.Content) > 0 {
+			chunk.Logprobs = &copilot.Logprobs{}
+			for _, token := range choice.Logprobs.Content {
+				top := make(map[string]float64, len(token.TopLogprobs))
+				for _, alt := range token.TopLogprobs {
+					top[alt.Token] = alt.Logprob
+				}
+				chunk.Logprobs.Tokens = append(chunk.Logprobs.Tokens, token.Token)
+				chunk.Logprobs.TokenLogprobs = append(chunk.Logprobs.TokenLogprobs, token.Logprob)
+				chunk.Logprobs.TopLogprobs = append(chunk.Logprobs.TopLogprobs, top)
+				chunk.Logprobs.TextOffset = append(chunk.Logprobs.TextOffset, offset)
+				offset += len(token.Token)
+			}
+		}
+		if chunk.Text == "" && chunk.Reasoning == "" && chunk.Logprobs == nil && chunk.FinishReason == "" && len(chunk.ToolCalls) == 0 {
+			continue
+		}
+		if err := onChunk(chunk); err != nil {
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return requestError(ctx, p.name, err)
+	}
+	return nil
+}
+
+// GetAvailableModels returns the configured models, or asks the API
+func (p *openAIProvider) GetAvailableModels(ctx context.Context) ([]copilot.ModelInfo, error) {
+	if p.models != nil {
+		return p.models, nil
+	}
+
+	req, err := p.newRequest(ctx, http.MethodGet, p.baseURL+"/models", nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, requestError(ctx, p.name, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, responseError(p.name, resp)
+	}
+
+	var list struct {
+		Data []copilot.ModelInfo `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return nil, fmt.Errorf("failed to parse %s models: %w", p.name, err)
+	}
+	for i := range list.Data {
+		list.Data[i].Object = "model"
+		if list.Data[i].OwnedBy == "" {
+			list.Data[i].OwnedBy = p.name
+		}
+	}
+	return list.Data, nil
+}
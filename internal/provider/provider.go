@@ -0,0 +1,486 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	stderrors "errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/devstroop/reai/internal/copilot"
+	"github.com/devstroop/reai/internal/metrics"
+	"github.com/devstroop/reai/pkg/errors"
+)
+
+// Provider types
+const (
+	TypeOpenAI = "openai"
+	TypeAzure  = "azure"
+	TypeOllama = "ollama"
+)
+
+// modelsTTL is how long the model list of a provider is cached
+const modelsTTL = 5 * time.Minute
+
+// DefaultFailoverCooldown is how long Copilot is bypassed after it failed
+const DefaultFailoverCooldown = 30 * time.Second
+
+var (
+	providerRequests  = metrics.NewCounterVec("reai_provider_requests_total", "Completions sent to each backend provider, by result (ok, error)", "provider", "result")
+	providerFailovers = metrics.NewCounterVec("reai_provider_failovers_total", "Copilot requests served by the failover provider", "provider")
+)
+
+// Provider is an upstream that serves completions. The Copilot client is one.
+type Provider interface {
+	// Name identifies the provider in logs, metrics and the x_reai backend field
+	Name() string
+	StreamCompletion(ctx context.Context, req *copilot.CompletionRequest, onChunk func(copilot.CompletionChunk) error) error
+	GetAvailableModels(ctx context.Context) ([]copilot.ModelInfo, error)
+	// GetModelLimits returns the token limits of a model, or nil when unknown
+	GetModelLimits(ctx context.Context, model string) *copilot.ModelLimits
+	// UnsupportedParameters lists the parameters of req the provider drops
+	UnsupportedParameters(req *copilot.CompletionRequest) []string
+}
+
+// Config is the on-disk format of PROVIDERS_FILE
+type Config struct {
+	Providers []Spec `json:"providers"`
+
+	// Failover names the provider that serves Copilot requests while Copilot
+	// is down or out of quota. It needs no prefix of its own.
+	Failover string `json:"failover,omitempty"`
+	// FailoverModel replaces the model name sent to the failover provider
+	FailoverModel string `json:"failover_model,omitempty"`
+	// FailoverCooldown is how long Copilot is bypassed after a failure
+	FailoverCooldown string `json:"failover_cooldown,omitempty"`
+
+	// Shadow names a provider that Copilot requests are mirrored to, without
+	// serving its responses, to compare it with Copilot. Like the failover
+	// provider it needs no prefix.
+	Shadow string `json:"shadow,omitempty"`
+	// ShadowModel replaces the model name sent to the shadow provider
+	ShadowModel string `json:"shadow_model,omitempty"`
+	// ShadowPercent is the share of Copilot requests mirrored (default 100)
+	ShadowPercent float64 `json:"shadow_percent,omitempty"`
+}
+
+// Spec configures a provider and the model prefix routed to it
+type Spec struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+
+	// Prefix selects the provider for models starting with it. It is removed
+	// from the model name sent upstream unless KeepPrefix is set.
+	Prefix     string `json:"prefix"`
+	KeepPrefix bool   `json:"keep_prefix,omitempty"`
+
+	// BaseURL is the API root: https://api.openai.com/v1 for OpenAI,
+	// https://<resource>.openai.azure.com for Azure, http://localhost:11434
+	// for Ollama
+	BaseURL string `json:"base_url"`
+	// APIKey, or the environment variable named by APIKeyEnv, authenticates
+	// upstream requests
+	APIKey    string            `json:"api_key,omitempty"`
+	APIKeyEnv string            `json:"api_key_env,omitempty"`
+	Headers   map[string]string `json:"headers,omitempty"`
+	// APIVersion is the Azure OpenAI api-version (default 2024-10-21)
+	APIVersion string `json:"api_version,omitempty"`
+
+	// Models are listed instead of asking the provider; Azure deployments
+	// can't be listed, so they must be given here
+	Models []string `json:"models,omitempty"`
+	// Token limits used for context checks on every model of the provider
+	ContextWindow   int `json:"context_window,omitempty"`
+	MaxOutputTokens int `json:"max_output_tokens,omitempty"`
+}
+
+// route sends models starting with prefix to a provider
+type route struct {
+	prefix     string
+	keepPrefix bool
+	provider   Provider
+
+	mutex   sync.Mutex
+	models  []copilot.ModelInfo
+	fetched time.Time
+}
+
+// Router picks the provider of each request by model prefix. Models without a
+// matching prefix go to the fallback, Copilot, which fails over to a
+// secondary provider when one is configured.
+type Router struct {
+	fallback Provider
+	routes   []*route
+
+	failover      Provider
+	failoverModel string
+	cooldown      time.Duration
+	// fallbackDown holds the time (Unix nanoseconds) until which the
+	// fallback is bypassed
+	fallbackDown atomic.Int64
+
+	shadow        Provider
+	shadowModel   string
+	shadowPercent float64
+	// shadowSlots holds a token per shadow request in flight
+	shadowSlots chan struct{}
+}
+
+// Load reads the providers file at path. With an empty path every model is
+// served by the fallback.
+func Load(path string, fallback Provider) (*Router, error) {
+	if path == "" {
+		return New(Config{}, fallback)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read providers file: %w", err)
+	}
+	var cfg Config
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse providers file: %w", err)
+	}
+	return New(cfg, fallback)
+}
+
+// New builds the providers of a configuration
+func New(cfg Config, fallback Provider) (*Router, error) {
+	r := &Router{fallback: fallback, failoverModel: cfg.FailoverModel, cooldown: DefaultFailoverCooldown}
+	if cfg.FailoverCooldown != "" {
+		d, err := time.ParseDuration(cfg.FailoverCooldown)
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("failover_cooldown: invalid duration %q", cfg.FailoverCooldown)
+		}
+		r.cooldown = d
+	}
+	if cfg.Shadow != "" {
+		if cfg.ShadowPercent < 0 || cfg.ShadowPercent > 100 {
+			return nil, fmt.Errorf("shadow_percent: %v is not between 0 and 100", cfg.ShadowPercent)
+		}
+		r.shadowModel, r.shadowPercent = cfg.ShadowModel, cfg.ShadowPercent
+		if r.shadowPercent == 0 {
+			r.shadowPercent = 100
+		}
+		r.shadowSlots = make(chan struct{}, DefaultShadowConcurrency)
+	}
+
+	names := map[string]bool{fallback.Name(): true}
+	prefixes := make(map[string]bool)
+	for i, spec := range cfg.Providers {
+		if spec.Name == "" {
+			return nil, fmt.Errorf("provider %d: name is required", i+1)
+		}
+		if names[spec.Name] {
+			return nil, fmt.Errorf("duplicate provider name %q", spec.Name)
+		}
+		if spec.Prefix == "" && spec.Name != cfg.Failover && spec.Name != cfg.Shadow {
+			return nil, fmt.Errorf("provider %s: prefix is required", spec.Name)
+		}
+		if spec.Prefix != "" && prefixes[spec.Prefix] {
+			return nil, fmt.Errorf("provider %s: prefix %q is already routed", spec.Name, spec.Prefix)
+		}
+
+		var p Provider
+		var err error
+		switch spec.Type {
+		case TypeOpenAI, TypeAzure:
+			p, err = newOpenAIProvider(spec)
+		case TypeOllama:
+			p, err = newOllamaProvider(spec)
+		default:
+			err = fmt.Errorf("unknown type %q (want openai, azure or ollama)", spec.Type)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("provider %s: %w", spec.Name, err)
+		}
+		names[spec.Name] = true
+		if spec.Name == cfg.Failover {
+			r.failover = p
+		}
+		if spec.Name == cfg.Shadow {
+			r.shadow = p
+		}
+		if spec.Prefix != "" {
+			prefixes[spec.Prefix] = true
+			r.routes = append(r.routes, &route{prefix: spec.Prefix, keepPrefix: spec.KeepPrefix, provider: p})
+		}
+	}
+	if cfg.Failover != "" && r.failover == nil {
+		return nil, fmt.Errorf("failover provider %q is not configured", cfg.Failover)
+	}
+	if cfg.Shadow != "" && r.shadow == nil {
+		return nil, fmt.Errorf("shadow provider %q is not configured", cfg.Shadow)
+	}
+
+	// The longest prefix wins
+	sort.SliceStable(r.routes, func(i, j int) bool {
+		return len(r.routes[i].prefix) > len(r.routes[j].prefix)
+	})
+	return r, nil
+}
+
+// Len returns the number of routed providers besides the fallback
+func (r *Router) Len() int {
+	return len(r.routes)
+}
+
+// Failover returns the name of the failover provider, or empty when there is none
+func (r *Router) Failover() string {
+	if r.failover == nil {
+		return ""
+	}
+	return r.failover.Name()
+}
+
+// Resolve returns the provider of model and the model name to send it
+func (r *Router) Resolve(model string) (Provider, string) {
+	for _, rt := range r.routes {
+		if strings.HasPrefix(model, rt.prefix) {
+			if rt.keepPrefix {
+				return rt.provider, model
+			}
+			return rt.provider, strings.TrimPrefix(model, rt.prefix)
+		}
+	}
+	return r.fallback, model
+}
+
+// Backend returns the name of the provider serving model
+func (r *Router) Backend(model string) string {
+	p, _ := r.Resolve(model)
+	return p.Name()
+}
+
+// StreamCompletion sends req to the provider of req.Model. Copilot requests
+// fail over when Copilot is down, as long as nothing was streamed yet, and
+// some are mirrored to the shadow provider.
+func (r *Router) StreamCompletion(ctx context.Context, req *copilot.CompletionRequest, onChunk func(copilot.CompletionChunk) error) error {
+	p, model := r.Resolve(req.Model)
+	if p == r.fallback && r.shadowSampled() {
+		return r.streamShadowed(ctx, p, model, req, onChunk)
+	}
+	return r.stream(ctx, p, model, req, onChunk)
+}
+
+// stream sends req to p, the provider resolved for it, failing over from Copilot
+func (r *Router) stream(ctx context.Context, p Provider, model string, req *copilot.CompletionRequest, onChunk func(copilot.CompletionChunk) error) error {
+	if p != r.fallback || r.failover == nil {
+		return r.send(ctx, p, model, req, onChunk)
+	}
+	if time.Now().UnixNano() < r.fallbackDown.Load() {
+		return r.sendFailover(ctx, model, req, onChunk)
+	}
+
+	delivered := false
+	err := r.send(ctx, p, model, req, func(chunk copilot.CompletionChunk) error {
+		delivered = true
+		return onChunk(chunk)
+	})
+	if err == nil || delivered || ctx.Err() != nil || !isOutage(err) {
+		return err
+	}
+	// A rate limited Copilot is left alone for as long as it asked
+	cooldown := r.cooldown
+	var apiErr *errors.APIError
+	if stderrors.As(err, &apiErr) && apiErr.RetryAfter > cooldown {
+		cooldown = apiErr.RetryAfter
+	}
+	r.fallbackDown.Store(time.Now().Add(cooldown).UnixNano())
+	slog.Warn("⚠️ Copilot failed - failing over", "provider", r.failover.Name(), "cooldown", cooldown, "error", err)
+	return r.sendFailover(ctx, model, req, onChunk)
+}
+
+// sendFailover sends a Copilot request to the failover provider
+func (r *Router) sendFailover(ctx context.Context, model string, req *copilot.CompletionRequest, onChunk func(copilot.CompletionChunk) error) error {
+	providerFailovers.With(r.failover.Name()).Inc()
+	if r.failoverModel != "" {
+		model = r.failoverModel
+	}
+	return r.send(ctx, r.failover, model, req, onChunk)
+}
+
+// send passes req to p under the given model name
+func (r *Router) send(ctx context.Context, p Provider, model string, req *copilot.CompletionRequest, onChunk func(copilot.CompletionChunk) error) error {
+	req.Backend = p.Name()
+	upstream := *req
+	upstream.Model = model
+
+	err := p.StreamCompletion(ctx, &upstream, onChunk)
+	result := "ok"
+	if err != nil {
+		result = "error"
+	}
+	providerRequests.With(p.Name(), result).Inc()
+	return err
+}
+
+// isOutage reports whether err means the upstream is down, unreachable or
+// out of quota, rather than something wrong with the request
+func isOutage(err error) bool {
+	var apiErr *errors.APIError
+	if !stderrors.As(err, &apiErr) {
+		return true
+	}
+	switch apiErr.Type {
+	case "copilot_api_error", "authentication_error", "rate_limit", "service_unavailable", "provider_error":
+		return true
+	}
+	return false
+}
+
+// GetCompletion sends req to the provider of req.Model and assembles the result
+func (r *Router) GetCompletion(ctx context.Context, req *copilot.CompletionRequest) (*copilot.CompletionResult, error) {
+	return copilot.Collect(func(onChunk func(copilot.CompletionChunk) error) error {
+		return r.StreamCompletion(ctx, req, onChunk)
+	})
+}
+
+// GetAvailableModels lists the models of every provider under the names that
+// route to it. Providers that can't be reached are left out; the fallback's
+// error is only returned when no provider answered.
+func (r *Router) GetAvailableModels(ctx context.Context) ([]copilot.ModelInfo, error) {
+	models, fallbackErr := r.fallback.GetAvailableModels(ctx)
+	if fallbackErr != nil {
+		slog.Warn("Failed to list provider models", "provider", r.fallback.Name(), "error", fallbackErr)
+	}
+	answered := fallbackErr == nil
+	for _, rt := range r.routes {
+		list, err := rt.list(ctx)
+		if err != nil {
+			slog.Warn("Failed to list provider models", "provider", rt.provider.Name(), "error", err)
+			continue
+		}
+		answered = true
+		models = append(models, list...)
+	}
+	if !answered {
+		return nil, fallbackErr
+	}
+	return models, nil
+}
+
+// list returns the cached models of the route's provider
+func (rt *route) list(ctx context.Context) ([]copilot.ModelInfo, error) {
+	rt.mutex.Lock()
+	defer rt.mutex.Unlock()
+	if rt.models != nil && time.Since(rt.fetched) < modelsTTL {
+		return rt.models, nil
+	}
+
+	models, err := rt.provider.GetAvailableModels(ctx)
+	if err != nil {
+		return nil, err
+	}
+	list := make([]copilot.ModelInfo, 0, len(models))
+	for _, m := range models {
+		if rt.keepPrefix {
+			if !strings.HasPrefix(m.ID, rt.prefix) {
+				continue
+			}
+		} else {
+			m.ID = rt.prefix + m.ID
+		}
+		list = append(list, m)
+	}
+	rt.models, rt.fetched = list, time.Now()
+	return list, nil
+}
+
+// GetModelLimits returns the limits of model from its provider
+func (r *Router) GetModelLimits(ctx context.Context, model string) *copilot.ModelLimits {
+	p, name := r.Resolve(model)
+	return p.GetModelLimits(ctx, name)
+}
+
+// UnsupportedParameters lists the parameters of req that were removed for
+// its model or that the provider of req.Model drops
+func (r *Router) UnsupportedParameters(req *copilot.CompletionRequest) []string {
+	p, _ := r.Resolve(req.Model)
+	return append(append([]string(nil), req.Dropped...), p.UnsupportedParameters(req)...)
+}
+
+// specAPIKey returns the configured API key
+func specAPIKey(spec Spec) string {
+	if spec.APIKey != "" {
+		return spec.APIKey
+	}
+	if spec.APIKeyEnv != "" {
+		return os.Getenv(spec.APIKeyEnv)
+	}
+	return ""
+}
+
+// specLimits returns the configured token limits, or nil when there are none
+func specLimits(spec Spec) *copilot.ModelLimits {
+	if spec.ContextWindow == 0 && spec.MaxOutputTokens == 0 {
+		return nil
+	}
+	return &copilot.ModelLimits{MaxContextWindowTokens: spec.ContextWindow, MaxOutputTokens: spec.MaxOutputTokens}
+}
+
+// specModels lists the configured models of a provider
+func specModels(spec Spec) []copilot.ModelInfo {
+	models := make([]copilot.ModelInfo, 0, len(spec.Models))
+	for _, id := range spec.Models {
+		models = append(models, copilot.ModelInfo{ID: id, Object: "model", OwnedBy: spec.Name})
+	}
+	return models
+}
+
+// chatMessages returns the messages of req, or its prompt as a user message
+func chatMessages(req *copilot.CompletionRequest) []copilot.Message {
+	if len(req.Messages) > 0 {
+		return req.Messages
+	}
+	return []copilot.Message{{Role: "user", Content: req.Prompt}}
+}
+
+// requestError converts a failed upstream request the way the Copilot client
+// does: a cancelled caller is passed through and a deadline is reported as such
+func requestError(ctx context.Context, name string, err error) error {
+	if ctx.Err() == context.Canceled {
+		return ctx.Err()
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		return errors.NewDeadlineExceededError("upstream did not respond in time")
+	}
+	return errors.NewProviderError(fmt.Sprintf("%s request failed: %s", name, err))
+}
+
+// responseError converts an unsuccessful upstream response. Client errors
+// keep the provider's message so callers can fix their request.
+func responseError(name string, resp *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	message := strings.TrimSpace(string(body))
+	var detail struct {
+		Error json.RawMessage `json:"error"`
+	}
+	if json.Unmarshal(body, &detail) == nil && len(detail.Error) > 0 {
+		var nested struct {
+			Message string `json:"message"`
+		}
+		var plain string
+		if json.Unmarshal(detail.Error, &nested) == nil && nested.Message != "" {
+			message = nested.Message
+		} else if json.Unmarshal(detail.Error, &plain) == nil && plain != "" {
+			message = plain
+		}
+	}
+
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests:
+		return errors.NewRateLimitError(name + ": " + message)
+	case http.StatusBadRequest, http.StatusNotFound, http.StatusRequestEntityTooLarge, http.StatusUnprocessableEntity:
+		return errors.NewValidationError(name + ": " + message)
+	}
+	return errors.NewProviderError(fmt.Sprintf("%s returned HTTP %d: %s", name, resp.StatusCode, message))
+}
@@ -0,0 +1,65 @@
+package provider
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/devstroop/reai/internal/copilot"
+	"github.com/devstroop/reai/pkg/errors"
+)
+
+var errCopilotDown = errors.NewCopilotAPIError("copilot is down")
+
+// downProvider is a fallback whose model list can't be fetched
+type downProvider struct {
+	*Mock
+}
+
+func (downProvider) Name() string { return "copilot" }
+
+func (downProvider) GetAvailableModels(ctx context.Context) ([]copilot.ModelInfo, error) {
+	return nil, errCopilotDown
+}
+
+func TestRouterModelsWithoutFallback(t *testing.T) {
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	mock, err := NewMock(MockConfig{}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "down", http.StatusBadGateway)
+	}))
+	defer broken.Close()
+
+	fallback := downProvider{mock}
+	router, err := New(Config{Providers: []Spec{
+		{Name: "local", Type: TypeOpenAI, Prefix: "local/", BaseURL: "http://localhost:1", Models: []string{"llama"}},
+		{Name: "broken", Type: TypeOpenAI, Prefix: "broken/", BaseURL: broken.URL},
+	}}, fallback)
+	if err != nil {
+		t.Fatal(err)
+	}
+	models, err := router.GetAvailableModels(context.Background())
+	if err != nil {
+		t.Fatalf("failed with a provider answering: %v", err)
+	}
+	if len(models) != 1 || models[0].ID != "local/llama" {
+		t.Errorf("got %+v, want the models of the answering provider", models)
+	}
+
+	// With no provider answering, the fallback's error is returned
+	router, err = New(Config{Providers: []Spec{
+		{Name: "broken", Type: TypeOpenAI, Prefix: "broken/", BaseURL: broken.URL},
+	}}, fallback)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := router.GetAvailableModels(context.Background()); err != errCopilotDown {
+		t.Errorf("got %v, want the fallback's error", err)
+	}
+}
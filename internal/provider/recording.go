@@ -0,0 +1,233 @@
+package provider
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	"github.com/devstroop/reai/internal/copilot"
+	"github.com/devstroop/reai/pkg/errors"
+)
+
+// Upstream modes
+const (
+	ModeRecord = "record"
+	ModeReplay = "replay"
+)
+
+// modelsRecording is the file holding the recorded model list
+const modelsRecording = "models.json"
+
+// recording is a completion captured in record mode, stored as
+// <hash of the request>.json
+type recording struct {
+	Request recordedRequest `json:"request"`
+	Chunks  []recordedChunk `json:"chunks"`
+}
+
+// recordedRequest holds the fields of a request that decide its completion.
+// Requests with the same fields share a recording.
+type recordedRequest struct {
+	Model             string                 `json:"model,omitempty"`
+	Prompt            string                 `json:"prompt,omitempty"`
+	Suffix            string                 `json:"suffix,omitempty"`
+	Messages          []copilot.Message      `json:"messages,omitempty"`
+	Language          string                 `json:"language,omitempty"`
+	MaxTokens         int                    `json:"max_tokens,omitempty"`
+	Temperature       *float64               `json:"temperature,omitempty"`
+	TopP              *float64               `json:"top_p,omitempty"`
+	PresencePenalty   *float64               `json:"presence_penalty,omitempty"`
+	FrequencyPenalty  *float64               `json:"frequency_penalty,omitempty"`
+	Seed              *int64                 `json:"seed,omitempty"`
+	Logprobs          *int                   `json:"logprobs,omitempty"`
+	Context           *copilot.PromptContext `json:"context,omitempty"`
+	Stop              []string               `json:"stop,omitempty"`
+	ReasoningEffort   string                 `json:"reasoning_effort,omitempty"`
+	IncludeReasoning  bool                   `json:"include_reasoning,omitempty"`
+	Tools             []copilot.Tool         `json:"tools,omitempty"`
+	ToolChoice        json.RawMessage        `json:"tool_choice,omitempty"`
+	ParallelToolCalls *bool                  `json:"parallel_tool_calls,omitempty"`
+}
+
+type recordedChunk struct {
+	Text         string                  `json:"text"`
+	Logprobs     *copilot.Logprobs       `json:"logprobs,omitempty"`
+	FinishReason string                  `json:"finish_reason,omitempty"`
+	Reasoning    string                  `json:"reasoning,omitempty"`
+	ToolCalls    []copilot.ToolCallDelta `json:"tool_calls,omitempty"`
+}
+
+func newRecordedRequest(req *copilot.CompletionRequest) recordedRequest {
+	return recordedRequest{
+		Model:             req.Model,
+		Prompt:            req.Prompt,
+		Suffix:            req.Suffix,
+		Messages:          req.Messages,
+		Language:          req.Language,
+		MaxTokens:         req.MaxTokens,
+		Temperature:       req.Temperature,
+		TopP:              req.TopP,
+		PresencePenalty:   req.PresencePenalty,
+		FrequencyPenalty:  req.FrequencyPenalty,
+		Seed:              req.Seed,
+		Logprobs:          req.Logprobs,
+		Context:           req.Context,
+		Stop:              req.Stop,
+		ReasoningEffort:   req.ReasoningEffort,
+		IncludeReasoning:  req.IncludeReasoning,
+		Tools:             req.Tools,
+		ToolChoice:        req.ToolChoice,
+		ParallelToolCalls: req.ParallelToolCalls,
+	}
+}
+
+// hash identifies the request by the fields that decide its completion
+func (r recordedRequest) hash() string {
+	data, _ := json.Marshal(r)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:12])
+}
+
+// file returns the path of the recording of the request in dir
+func (r recordedRequest) file(dir string) string {
+	return filepath.Join(dir, r.hash()+".json")
+}
+
+// writeJSON writes v to path through a temporary file
+func writeJSON(path string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Recorder passes requests to a provider and saves every completed response
+// to a directory, for Replayer to serve later
+type Recorder struct {
+	Provider
+	dir string
+}
+
+// Record wraps p so that its completions and model list are saved to dir
+func Record(p Provider, dir string) (*Recorder, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create recordings directory: %w", err)
+	}
+	return &Recorder{Provider: p, dir: dir}, nil
+}
+
+// StreamCompletion streams the completion of the wrapped provider and records
+// it once it finished successfully
+func (r *Recorder) StreamCompletion(ctx context.Context, req *copilot.CompletionRequest, onChunk func(copilot.CompletionChunk) error) error {
+	rec := recording{Request: newRecordedRequest(req)}
+	err := r.Provider.StreamCompletion(ctx, req, func(chunk copilot.CompletionChunk) error {
+		rec.Chunks = append(rec.Chunks, recordedChunk{Text: chunk.Text, Logprobs: chunk.Logprobs, FinishReason: chunk.FinishReason, Reasoning: chunk.Reasoning, ToolCalls: chunk.ToolCalls})
+		return onChunk(chunk)
+	})
+	if err != nil {
+		return err
+	}
+	path := rec.Request.file(r.dir)
+	if err := writeJSON(path, rec); err != nil {
+		slog.Warn("Recording not saved", "file", path, "error", err)
+	} else {
+		slog.Debug("Completion recorded", "file", path, "chunks", len(rec.Chunks))
+	}
+	return nil
+}
+
+// GetAvailableModels lists the models of the wrapped provider and records them
+func (r *Recorder) GetAvailableModels(ctx context.Context) ([]copilot.ModelInfo, error) {
+	models, err := r.Provider.GetAvailableModels(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := writeJSON(filepath.Join(r.dir, modelsRecording), models); err != nil {
+		slog.Warn("Model list not recorded", "error", err)
+	}
+	return models, nil
+}
+
+// Replayer is a fake provider serving the completions saved by Recorder
+// without contacting any upstream. Requests that were never recorded fail.
+type Replayer struct {
+	dir string
+}
+
+// Replay serves the recordings in dir
+func Replay(dir string) (*Replayer, error) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open recordings directory: %w", err)
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("recordings path %s is not a directory", dir)
+	}
+	return &Replayer{dir: dir}, nil
+}
+
+func (r *Replayer) Name() string {
+	return ModeReplay
+}
+
+// UnsupportedParameters reports nothing; the recorded provider already did
+func (r *Replayer) UnsupportedParameters(req *copilot.CompletionRequest) []string {
+	return nil
+}
+
+// GetModelLimits is unknown for replayed models, so context checks are skipped
+func (r *Replayer) GetModelLimits(ctx context.Context, model string) *copilot.ModelLimits {
+	return nil
+}
+
+// StreamCompletion replays the recorded chunks of req
+func (r *Replayer) StreamCompletion(ctx context.Context, req *copilot.CompletionRequest, onChunk func(copilot.CompletionChunk) error) error {
+	path := newRecordedRequest(req).file(r.dir)
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return errors.NewProviderError(fmt.Sprintf("no recording of this request (%s)", filepath.Base(path)))
+	}
+	if err != nil {
+		return errors.NewProviderError(fmt.Sprintf("failed to read recording: %v", err))
+	}
+	var rec recording
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return errors.NewProviderError(fmt.Sprintf("failed to parse recording %s: %v", filepath.Base(path), err))
+	}
+
+	for _, chunk := range rec.Chunks {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := onChunk(copilot.CompletionChunk{Text: chunk.Text, Logprobs: chunk.Logprobs, FinishReason: chunk.FinishReason, Reasoning: chunk.Reasoning, ToolCalls: chunk.ToolCalls}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetAvailableModels returns the recorded model list
+func (r *Replayer) GetAvailableModels(ctx context.Context) ([]copilot.ModelInfo, error) {
+	data, err := os.ReadFile(filepath.Join(r.dir, modelsRecording))
+	if os.IsNotExist(err) {
+		return []copilot.ModelInfo{}, nil
+	}
+	if err != nil {
+		return nil, errors.NewProviderError(fmt.Sprintf("failed to read recorded models: %v", err))
+	}
+	var models []copilot.ModelInfo
+	if err := json.Unmarshal(data, &models); err != nil {
+		return nil, errors.NewProviderError(fmt.Sprintf("failed to parse recorded models: %v", err))
+	}
+	return models, nil
+}
@@ -0,0 +1,167 @@
+package provider
+
+import (
+	"context"
+	"log/slog"
+	"math/rand/v2"
+	"strings"
+	"time"
+
+	"github.com/devstroop/reai/internal/copilot"
+	"github.com/devstroop/reai/internal/metrics"
+)
+
+const (
+	// shadowTimeout bounds a shadow request, which outlives its client
+	shadowTimeout = 2 * time.Minute
+	// DefaultShadowConcurrency caps the shadow requests in flight; requests
+	// beyond it are not mirrored
+	DefaultShadowConcurrency = 16
+)
+
+var (
+	shadowRequests   = metrics.NewCounterVec("reai_shadow_requests_total", "Copilot requests mirrored to the shadow provider, by result (compared, shadow_error, primary_error, skipped)", "provider", "result")
+	shadowDuration   = metrics.NewHistogramVec("reai_shadow_duration_seconds", "Duration of shadowed completions on the serving backend (primary) and the shadow provider (shadow)", nil, "role")
+	shadowSimilarity = metrics.NewHistogram("reai_shadow_similarity", "Word overlap of shadowed completions with the served ones, from 0 (none) to 1 (same words)", []float64{0.1, 0.2, 0.3, 0.4, 0.5, 0.6, 0.7, 0.8, 0.9, 1})
+)
+
+// shadowRun is the outcome of one side of a shadowed request
+type shadowRun struct {
+	text         string
+	finishReason string
+	ttft         time.Duration
+	duration     time.Duration
+	err          error
+}
+
+// Shadow returns the name of the shadow provider, or empty when there is none
+func (r *Router) Shadow() string {
+	if r.shadow == nil {
+		return ""
+	}
+	return r.shadow.Name()
+}
+
+// shadowSampled picks the Copilot requests mirrored to the shadow provider
+func (r *Router) shadowSampled() bool {
+	return r.shadow != nil && rand.Float64()*100 < r.shadowPercent
+}
+
+// streamShadowed serves req from p like any request while sending a copy to
+// the shadow provider. The shadow response is never served: once both are
+// done, their latency and output are compared in the log and the metrics.
+func (r *Router) streamShadowed(ctx context.Context, p Provider, model string, req *copilot.CompletionRequest, onChunk func(copilot.CompletionChunk) error) error {
+	select {
+	case r.shadowSlots <- struct{}{}:
+	default:
+		shadowRequests.With(r.shadow.Name(), "skipped").Inc()
+		return r.stream(ctx, p, model, req, onChunk)
+	}
+
+	mirrored := *req
+	if r.shadowModel != "" {
+		mirrored.Model = r.shadowModel
+	} else {
+		mirrored.Model = model
+	}
+	shadowed := make(chan shadowRun, 1)
+	go func() {
+		shadowCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), shadowTimeout)
+		defer cancel()
+		shadowed <- runShadowed(func(onChunk func(copilot.CompletionChunk) error) error {
+			return r.send(shadowCtx, r.shadow, mirrored.Model, &mirrored, onChunk)
+		})
+	}()
+
+	primary := runShadowed(func(observe func(copilot.CompletionChunk) error) error {
+		return r.stream(ctx, p, model, req, func(chunk copilot.CompletionChunk) error {
+			observe(chunk)
+			return onChunk(chunk)
+		})
+	})
+
+	// The client doesn't wait for the shadow
+	backend := req.Backend
+	go func() {
+		defer func() { <-r.shadowSlots }()
+		r.compareShadowed(req.Model, backend, mirrored.Model, primary, <-shadowed)
+	}()
+	return primary.err
+}
+
+// runShadowed times a completion and collects its text
+func runShadowed(stream func(onChunk func(copilot.CompletionChunk) error) error) shadowRun {
+	var run shadowRun
+	var text strings.Builder
+	start := time.Now()
+	run.err = stream(func(chunk copilot.CompletionChunk) error {
+		if run.ttft == 0 {
+			run.ttft = time.Since(start)
+		}
+		text.WriteString(chunk.Text)
+		if chunk.FinishReason != "" {
+			run.finishReason = chunk.FinishReason
+		}
+		return nil
+	})
+	run.duration = time.Since(start)
+	run.text = text.String()
+	return run
+}
+
+// compareShadowed logs how the shadow provider did against the served backend
+func (r *Router) compareShadowed(model, backend, shadowModel string, primary, shadow shadowRun) {
+	name := r.shadow.Name()
+	switch {
+	case primary.err != nil:
+		// Nothing to compare with, and the client may just have gone away
+		shadowRequests.With(name, "primary_error").Inc()
+		return
+	case shadow.err != nil:
+		shadowRequests.With(name, "shadow_error").Inc()
+		slog.Warn("Shadow request failed", "provider", name, "model", shadowModel, "duration", shadow.duration, "error", shadow.err)
+		return
+	}
+
+	similarity := wordOverlap(primary.text, shadow.text)
+	shadowRequests.With(name, "compared").Inc()
+	shadowDuration.With("primary").Observe(primary.duration.Seconds())
+	shadowDuration.With("shadow").Observe(shadow.duration.Seconds())
+	shadowSimilarity.Observe(similarity)
+	slog.Info("Shadow comparison",
+		"model", model,
+		"backend", backend,
+		"shadow", name,
+		"shadow_model", shadowModel,
+		"ttft_ms", primary.ttft.Milliseconds(),
+		"shadow_ttft_ms", shadow.ttft.Milliseconds(),
+		"duration_ms", primary.duration.Milliseconds(),
+		"shadow_duration_ms", shadow.duration.Milliseconds(),
+		"chars", len(primary.text),
+		"shadow_chars", len(shadow.text),
+		"finish_reason", primary.finishReason,
+		"shadow_finish_reason", shadow.finishReason,
+		"similarity", float64(int(similarity*1000))/1000,
+	)
+}
+
+// wordOverlap is the Dice coefficient of the words of a and b: 1 when they
+// use the same words as often, 0 when they share none
+func wordOverlap(a, b string) float64 {
+	wordsA, wordsB := strings.Fields(a), strings.Fields(b)
+	if len(wordsA)+len(wordsB) == 0 {
+		return 1
+	}
+	counts := make(map[string]int, len(wordsA))
+	for _, w := range wordsA {
+		counts[w]++
+	}
+	shared := 0
+	for _, w := range wordsB {
+		if counts[w] > 0 {
+			counts[w]--
+			shared++
+		}
+	}
+	return 2 * float64(shared) / float64(len(wordsA)+len(wordsB))
+}
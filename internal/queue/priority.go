@@ -0,0 +1,74 @@
+package queue
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Priority is the class a request is queued in. Waiting requests of a higher
+// class get a free slot before lower ones.
+type Priority string
+
+// Priority classes; the empty priority is Normal
+const (
+	High   Priority = "high"
+	Normal Priority = "normal"
+	Low    Priority = "low"
+)
+
+// priorities lists the classes from highest to lowest
+var priorities = []Priority{High, Normal, Low}
+
+// ParsePriority parses a priority class name, defaulting to Normal when empty
+func ParsePriority(name string) (Priority, error) {
+	switch p := Priority(strings.ToLower(strings.TrimSpace(name))); p {
+	case "":
+		return Normal, nil
+	case High, Normal, Low:
+		return p, nil
+	}
+	return "", fmt.Errorf("unknown priority %q (use high, normal or low)", name)
+}
+
+// rank orders the classes, 0 being the highest
+func (p Priority) rank() int {
+	switch p {
+	case High:
+		return 0
+	case Low:
+		return 2
+	}
+	return 1
+}
+
+// String returns the class name
+func (p Priority) String() string {
+	return string(priorities[p.rank()])
+}
+
+// ParseShares parses per-class concurrency shares in percent of the limit,
+// e.g. "high=100,normal=80,low=25". Classes left out may use every slot.
+func ParseShares(spec string) (map[Priority]int, error) {
+	shares := make(map[Priority]int)
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, fmt.Errorf("priority share %q must be <priority>=<percent>", entry)
+		}
+		priority, err := ParsePriority(name)
+		if err != nil || strings.TrimSpace(name) == "" {
+			return nil, fmt.Errorf("priority share %q: unknown priority %q", entry, name)
+		}
+		percent, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil || percent <= 0 || percent > 100 {
+			return nil, fmt.Errorf("priority share %q: percent must be between 1 and 100", entry)
+		}
+		shares[priority] = percent
+	}
+	return shares, nil
+}
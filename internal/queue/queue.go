@@ -0,0 +1,250 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/devstroop/reai/internal/metrics"
+)
+
+// Errors returned by Acquire when a request cannot be admitted
+var (
+	ErrQueueFull        = errors.New("request queue is full")
+	ErrQueueTimeout     = errors.New("timed out waiting in request queue")
+	ErrDeadlineTooClose = errors.New("request deadline too close to wait in queue")
+)
+
+// Queue metrics
+var (
+	queueDepth    = metrics.NewGauge("reai_queue_depth", "Requests currently waiting for a concurrency slot")
+	queueInflight = metrics.NewGauge("reai_queue_inflight", "Requests currently holding a concurrency slot")
+	queueWait     = metrics.NewHistogram("reai_queue_wait_seconds", "Time requests spent waiting for a concurrency slot", []float64{0.001, 0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30})
+	queueRejected = metrics.NewCounterVec("reai_queue_rejected_total", "Requests rejected by the queue", "reason")
+)
+
+// Options configures a Queue
+type Options struct {
+	// MaxConcurrent is the number of requests processed at once
+	MaxConcurrent int
+	// MaxDepth is the number of requests allowed to wait for a slot (0 rejects immediately)
+	MaxDepth int
+	// MaxWait bounds how long a request waits for a slot
+	MaxWait time.Duration
+	// MinRemaining rejects instead of queueing when the caller's deadline is closer than this
+	MinRemaining time.Duration
+	// Shares caps the slots a priority class may hold, in percent of
+	// MaxConcurrent. Classes without a share may use every slot.
+	Shares map[Priority]int
+}
+
+// Queue limits concurrency and holds excess requests in a bounded waiting
+// line, served by priority class and first come first served within a class
+type Queue struct {
+	opts Options
+
+	mutex    sync.Mutex
+	inFlight int
+	// held and waiters are indexed by priority rank
+	held    [3]int
+	waiters [3][]*waiter
+	waiting int
+}
+
+// waiter is a request waiting for a slot
+type waiter struct {
+	priority Priority
+	// ready is closed once the waiter holds a slot
+	ready    chan struct{}
+	admitted bool
+}
+
+// New creates a queue. A non-positive MaxConcurrent disables limiting.
+func New(opts Options) *Queue {
+	return &Queue{opts: opts}
+}
+
+// Acquire waits for a concurrency slot and returns the function releasing it.
+// It fails fast when the queue is full or the caller's deadline is too close,
+// and returns ctx.Err() if the caller goes away while queued.
+func (q *Queue) Acquire(ctx context.Context, priority Priority) (func(), error) {
+	if q.opts.MaxConcurrent <= 0 {
+		return func() {}, nil
+	}
+	rank := priority.rank()
+
+	q.mutex.Lock()
+	if q.available(rank) && !q.waitingAhead(rank) {
+		q.admit(rank)
+		q.mutex.Unlock()
+		queueWait.Observe(0)
+		return q.releaser(rank), nil
+	}
+	if q.waiting >= q.opts.MaxDepth {
+		q.mutex.Unlock()
+		queueRejected.With("full").Inc()
+		return nil, ErrQueueFull
+	}
+
+	wait := q.opts.MaxWait
+	if deadline, ok := ctx.Deadline(); ok {
+		remaining := time.Until(deadline) - q.opts.MinRemaining
+		if remaining <= 0 {
+			q.mutex.Unlock()
+			queueRejected.With("deadline").Inc()
+			return nil, ErrDeadlineTooClose
+		}
+		if wait <= 0 || remaining < wait {
+			wait = remaining
+		}
+	}
+
+	w := &waiter{priority: priority, ready: make(chan struct{})}
+	q.waiters[rank] = append(q.waiters[rank], w)
+	q.waiting++
+	q.mutex.Unlock()
+	queueDepth.Inc()
+	defer queueDepth.Dec()
+
+	var timeout <-chan time.Time
+	if wait > 0 {
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	start := time.Now()
+	var err error
+	select {
+	case <-w.ready:
+		queueWait.Observe(time.Since(start).Seconds())
+		return q.releaser(rank), nil
+	case <-ctx.Done():
+		queueRejected.With("cancelled").Inc()
+		err = ctx.Err()
+	case <-timeout:
+		queueRejected.With("timeout").Inc()
+		err = ErrQueueTimeout
+	}
+
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	if w.admitted {
+		// The slot was handed over as the wait ended; pass it on
+		q.release(rank)
+	} else {
+		q.remove(w)
+	}
+	return nil, err
+}
+
+// available reports whether a request of the class may take a slot now.
+// Called with the mutex held.
+func (q *Queue) available(rank int) bool {
+	return q.inFlight < q.opts.MaxConcurrent && q.held[rank] < q.share(rank)
+}
+
+// share returns the number of slots the class may hold
+func (q *Queue) share(rank int) int {
+	percent, ok := q.opts.Shares[priorities[rank]]
+	if !ok {
+		return q.opts.MaxConcurrent
+	}
+	return max(1, q.opts.MaxConcurrent*percent/100)
+}
+
+// waitingAhead reports whether requests are already waiting that a newcomer
+// of the class must not overtake: those of the class, and those of a higher
+// class that could take a slot. A higher class held back by its share is
+// not ahead. Called with the mutex held.
+func (q *Queue) waitingAhead(rank int) bool {
+	if len(q.waiters[rank]) > 0 {
+		return true
+	}
+	for r := 0; r < rank; r++ {
+		if len(q.waiters[r]) > 0 && q.available(r) {
+			return true
+		}
+	}
+	return false
+}
+
+// admit counts a slot taken by the class. Called with the mutex held.
+func (q *Queue) admit(rank int) {
+	q.inFlight++
+	q.held[rank]++
+	queueInflight.Inc()
+}
+
+// release frees a slot of the class and hands free slots to the waiters,
+// highest class first. Called with the mutex held.
+func (q *Queue) release(rank int) {
+	q.inFlight--
+	q.held[rank]--
+	queueInflight.Dec()
+
+	for r := range q.waiters {
+		for len(q.waiters[r]) > 0 && q.available(r) {
+			w := q.waiters[r][0]
+			q.waiters[r] = q.waiters[r][1:]
+			q.waiting--
+			q.admit(r)
+			w.admitted = true
+			close(w.ready)
+		}
+	}
+}
+
+// remove drops a waiter that gave up. Called with the mutex held.
+func (q *Queue) remove(w *waiter) {
+	rank := w.priority.rank()
+	for i, other := range q.waiters[rank] {
+		if other == w {
+			q.waiters[rank] = append(q.waiters[rank][:i], q.waiters[rank][i+1:]...)
+			q.waiting--
+			return
+		}
+	}
+}
+
+// Depth returns the number of requests currently waiting
+func (q *Queue) Depth() int {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	return q.waiting
+}
+
+// InFlight returns the number of requests currently holding a slot
+func (q *Queue) InFlight() int {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	return q.inFlight
+}
+
+// Share returns the number of slots a priority class may hold (0 when
+// concurrency is not limited)
+func (q *Queue) Share(priority Priority) int {
+	if q.opts.MaxConcurrent <= 0 {
+		return 0
+	}
+	return q.share(priority.rank())
+}
+
+// Options returns the limits the queue was created with
+func (q *Queue) Options() Options {
+	return q.opts
+}
+
+// releaser returns a release function that frees the slot exactly once
+func (q *Queue) releaser(rank int) func() {
+	var released atomic.Bool
+	return func() {
+		if released.CompareAndSwap(false, true) {
+			q.mutex.Lock()
+			defer q.mutex.Unlock()
+			q.release(rank)
+		}
+	}
+}
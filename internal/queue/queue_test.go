@@ -0,0 +1,267 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// acquireAsync starts Acquire in the background and returns the channel its
+// release function, or nil on error, is sent to
+func acquireAsync(q *Queue, priority Priority) <-chan func() {
+	done := make(chan func(), 1)
+	go func() {
+		release, err := q.Acquire(context.Background(), priority)
+		if err != nil {
+			release = nil
+		}
+		done <- release
+	}()
+	return done
+}
+
+// waitDepth waits until depth requests are queued
+func waitDepth(t *testing.T, q *Queue, depth int) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for q.Depth() != depth {
+		if time.Now().After(deadline) {
+			t.Fatalf("depth %d, want %d", q.Depth(), depth)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func mustAcquire(t *testing.T, q *Queue, priority Priority) func() {
+	t.Helper()
+	release, err := q.Acquire(context.Background(), priority)
+	if err != nil {
+		t.Fatalf("%s: %v", priority, err)
+	}
+	return release
+}
+
+// TestSharesWithPriorities checks that a higher class waiting only because of
+// its share doesn't hold back lower classes while slots are free
+func TestSharesWithPriorities(t *testing.T) {
+	q := New(Options{MaxConcurrent: 4, MaxDepth: 10, MaxWait: time.Second, Shares: map[Priority]int{High: 25}})
+
+	// High may hold one slot; the second high request waits for it
+	releaseHigh := mustAcquire(t, q, High)
+	waitingHigh := acquireAsync(q, High)
+	waitDepth(t, q, 1)
+
+	// Three slots are free, so lower classes are admitted at once
+	releaseNormal := mustAcquire(t, q, Normal)
+	releaseLow := mustAcquire(t, q, Low)
+	if got := q.InFlight(); got != 3 {
+		t.Fatalf("%d in flight, want 3", got)
+	}
+	if q.Depth() != 1 {
+		t.Fatalf("depth %d, want only the high request waiting", q.Depth())
+	}
+
+	// The waiting high request gets the slot its class frees
+	releaseHigh()
+	select {
+	case release := <-waitingHigh:
+		if release == nil {
+			t.Fatal("waiting high request failed")
+		}
+		release()
+	case <-time.After(time.Second):
+		t.Fatal("waiting high request not admitted")
+	}
+	releaseNormal()
+	releaseLow()
+	if got := q.InFlight(); got != 0 {
+		t.Fatalf("%d in flight after every release", got)
+	}
+}
+
+// TestPriorityOrder checks that freed slots go to the highest waiting class
+// able to take them, first come first served within a class
+func TestPriorityOrder(t *testing.T) {
+	q := New(Options{MaxConcurrent: 1, MaxDepth: 10, MaxWait: time.Second})
+	release := mustAcquire(t, q, Normal)
+
+	low := acquireAsync(q, Low)
+	waitDepth(t, q, 1)
+	normal1 := acquireAsync(q, Normal)
+	waitDepth(t, q, 2)
+	normal2 := acquireAsync(q, Normal)
+	waitDepth(t, q, 3)
+	high := acquireAsync(q, High)
+	waitDepth(t, q, 4)
+
+	// A newcomer doesn't overtake the waiting requests
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := q.Acquire(ctx, High); !errors.Is(err, ErrQueueTimeout) {
+		t.Fatalf("newcomer: got %v, want it queued behind the others", err)
+	}
+
+	release()
+	for _, next := range []struct {
+		name string
+		done <-chan func()
+	}{{"high", high}, {"first normal", normal1}, {"second normal", normal2}, {"low", low}} {
+		select {
+		case release := <-next.done:
+			if release == nil {
+				t.Fatalf("%s request failed", next.name)
+			}
+			release()
+		case <-time.After(time.Second):
+			t.Fatalf("%s request not admitted next", next.name)
+		}
+	}
+}
+
+func TestQueueRejections(t *testing.T) {
+	q := New(Options{MaxConcurrent: 1, MaxDepth: 1, MaxWait: 20 * time.Millisecond, MinRemaining: 50 * time.Millisecond})
+	release := mustAcquire(t, q, Normal)
+	defer release()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := q.Acquire(ctx, Normal); !errors.Is(err, ErrDeadlineTooClose) {
+		t.Errorf("close deadline: got %v", err)
+	}
+
+	waiting := acquireAsync(q, Normal)
+	waitDepth(t, q, 1)
+	if _, err := q.Acquire(context.Background(), Normal); !errors.Is(err, ErrQueueFull) {
+		t.Errorf("full queue: got %v", err)
+	}
+	if release := <-waiting; release != nil {
+		t.Error("waiter admitted past MaxWait")
+	}
+	if q.Depth() != 0 {
+		t.Errorf("depth %d after the timeout", q.Depth())
+	}
+}
+
+// tryAcquire reports whether a request of the class is admitted without
+// waiting, returning its release function
+func tryAcquire(q *Queue, priority Priority) (func(), bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	release, err := q.Acquire(ctx, priority)
+	return release, err == nil
+}
+
+// TestShares checks the slots each class may hold: shares cap a class,
+// round up to at least one slot, and classes without a share borrow every
+// free slot
+func TestShares(t *testing.T) {
+	tests := []struct {
+		name     string
+		max      int
+		shares   map[Priority]int
+		held     []Priority
+		next     Priority
+		admitted bool
+	}{
+		{"no shares", 2, nil, []Priority{Low}, Low, true},
+		{"limit reached", 2, nil, []Priority{High, Low}, High, false},
+		{"share cap", 4, map[Priority]int{Low: 25}, []Priority{Low}, Low, false},
+		{"share below cap", 4, map[Priority]int{Low: 50}, []Priority{Low}, Low, true},
+		{"share rounds to one slot", 4, map[Priority]int{Low: 10}, nil, Low, true},
+		{"rounded share cap", 4, map[Priority]int{Low: 10}, []Priority{Low}, Low, false},
+		{"capped class leaves slots to others", 4, map[Priority]int{Low: 25}, []Priority{Low}, Normal, true},
+		{"unshared class borrows every slot", 4, map[Priority]int{Low: 25, High: 50}, []Priority{Normal, Normal, Normal}, Normal, true},
+		{"high is capped too", 4, map[Priority]int{High: 50}, []Priority{High, High}, High, false},
+		{"full share", 2, map[Priority]int{Normal: 100}, []Priority{Normal}, Normal, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := New(Options{MaxConcurrent: tt.max, MaxDepth: 10, MaxWait: time.Second, Shares: tt.shares})
+			for _, priority := range tt.held {
+				defer mustAcquire(t, q, priority)()
+			}
+			release, admitted := tryAcquire(q, tt.next)
+			if admitted != tt.admitted {
+				t.Fatalf("%s admitted: %v, want %v", tt.next, admitted, tt.admitted)
+			}
+			if admitted {
+				release()
+			}
+			if q.Depth() != 0 {
+				t.Errorf("depth %d after the attempt", q.Depth())
+			}
+		})
+	}
+}
+
+func TestShare(t *testing.T) {
+	q := New(Options{MaxConcurrent: 8, Shares: map[Priority]int{High: 50, Low: 10}})
+	for priority, want := range map[Priority]int{High: 4, Normal: 8, Low: 1} {
+		if got := q.Share(priority); got != want {
+			t.Errorf("%s share %d, want %d", priority, got, want)
+		}
+	}
+	if got := New(Options{}).Share(High); got != 0 {
+		t.Errorf("unlimited queue share %d", got)
+	}
+}
+
+// TestShareStarvation checks that a flood of a capped higher class doesn't
+// starve a lower class: slots it may not take go to the lower class waiting
+// behind it
+func TestShareStarvation(t *testing.T) {
+	q := New(Options{MaxConcurrent: 2, MaxDepth: 10, MaxWait: time.Second, Shares: map[Priority]int{High: 50}})
+	releaseHigh := mustAcquire(t, q, High)
+	releaseNormal := mustAcquire(t, q, Normal)
+
+	var highs []<-chan func()
+	for i := 0; i < 3; i++ {
+		highs = append(highs, acquireAsync(q, High))
+		waitDepth(t, q, i+1)
+	}
+	low := acquireAsync(q, Low)
+	waitDepth(t, q, 4)
+
+	// High holds its share, so the freed slot skips the high waiters
+	releaseNormal()
+	select {
+	case release := <-low:
+		if release == nil {
+			t.Fatal("low request failed")
+		}
+		defer release()
+	case <-time.After(time.Second):
+		t.Fatal("low request starved by capped high requests")
+	}
+	if q.Depth() != 3 {
+		t.Fatalf("depth %d, want the high requests still waiting", q.Depth())
+	}
+
+	// Each slot high frees goes to the next high waiter, in order
+	release := releaseHigh
+	for i, done := range highs {
+		release()
+		select {
+		case release = <-done:
+			if release == nil {
+				t.Fatalf("high request %d failed", i)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("high request %d not admitted", i)
+		}
+	}
+	release()
+}
+
+func TestParseShares(t *testing.T) {
+	shares, err := ParseShares(" high=100, low=25 ,")
+	if err != nil || len(shares) != 2 || shares[High] != 100 || shares[Low] != 25 {
+		t.Fatalf("shares %v, %v", shares, err)
+	}
+	for _, spec := range []string{"low", "urgent=50", "=50", "low=0", "low=101", "low=half"} {
+		if _, err := ParseShares(spec); err == nil {
+			t.Errorf("%q accepted", spec)
+		}
+	}
+}
@@ -0,0 +1,262 @@
+package quota
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/devstroop/reai/internal/pricing"
+	"github.com/devstroop/reai/internal/store"
+)
+
+// Rolling windows of the long term quotas
+const (
+	Day   = 24 * time.Hour
+	Month = 30 * Day
+)
+
+// ledgerSaveInterval bounds how often the ledger is written to the database
+const ledgerSaveInterval = 30 * time.Second
+
+// Bucket spans, as stored in the database
+const (
+	spanHour = "hour"
+	spanDay  = "day"
+)
+
+// bucket counts the requests, tokens and estimated cost of one hour or day
+type bucket struct {
+	Start    time.Time `json:"start"`
+	Requests int       `json:"requests"`
+	Tokens   int       `json:"tokens"`
+	Cost     float64   `json:"cost,omitempty"`
+}
+
+// account is the long term usage of one caller: hourly buckets for the
+// rolling day and daily buckets for the rolling month
+type account struct {
+	Hours []bucket `json:"hours"`
+	Days  []bucket `json:"days"`
+}
+
+// ledger keeps the rolling day and month usage of every caller, saved to the
+// database so it survives restarts. Its methods are called with the
+// tracker's mutex held.
+type ledger struct {
+	db       *store.DB
+	accounts map[string]*account
+	// dirty holds the callers whose usage changed since the last save
+	dirty map[string]bool
+	saved time.Time
+}
+
+// openLedger reads the ledger from db, first moving in the usage an older
+// version kept in the file at legacyPath. A nil db keeps it in memory.
+func openLedger(db *store.DB, legacyPath string) (*ledger, error) {
+	l := &ledger{db: db, accounts: make(map[string]*account), dirty: make(map[string]bool)}
+	if db == nil {
+		return l, nil
+	}
+
+	rows, err := db.Query("SELECT caller, span, start, requests, tokens, cost FROM usage_buckets ORDER BY caller, span, start")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read quota usage: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var caller, span string
+		var start int64
+		var b bucket
+		if err := rows.Scan(&caller, &span, &start, &b.Requests, &b.Tokens, &b.Cost); err != nil {
+			return nil, fmt.Errorf("failed to read quota usage: %w", err)
+		}
+		b.Start = time.Unix(start, 0).UTC()
+		a, ok := l.accounts[caller]
+		if !ok {
+			a = &account{}
+			l.accounts[caller] = a
+		}
+		if span == spanHour {
+			a.Hours = append(a.Hours, b)
+		} else {
+			a.Days = append(a.Days, b)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read quota usage: %w", err)
+	}
+
+	if err := l.importFile(legacyPath); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// importFile adds the usage saved in the JSON file at path by older versions
+// and removes the file
+func (l *ledger) importFile(path string) error {
+	if path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read quota usage: %w", err)
+	}
+	var accounts map[string]*account
+	if err := json.Unmarshal(data, &accounts); err != nil {
+		return fmt.Errorf("failed to parse quota usage: %w", err)
+	}
+	for id, imported := range accounts {
+		a, ok := l.accounts[id]
+		if !ok {
+			a = &account{}
+			l.accounts[id] = a
+		}
+		for _, b := range imported.Hours {
+			a.Hours = charge(a.Hours, b.Start.UTC(), b.Requests, b.Tokens, b.Cost)
+		}
+		for _, b := range imported.Days {
+			a.Days = charge(a.Days, b.Start.UTC(), b.Requests, b.Tokens, b.Cost)
+		}
+		l.dirty[id] = true
+	}
+	if err := l.save(time.Now()); err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil {
+		return fmt.Errorf("failed to remove imported quota usage: %w", err)
+	}
+	slog.Info("Quota usage moved to the database", "file", path, "callers", len(accounts))
+	return nil
+}
+
+// account returns the usage of id with buckets outside the windows dropped
+func (l *ledger) account(id string, now time.Time) *account {
+	a, ok := l.accounts[id]
+	if !ok {
+		a = &account{}
+		l.accounts[id] = a
+	}
+	a.Hours = expire(a.Hours, now, Day)
+	a.Days = expire(a.Days, now, Month)
+	return a
+}
+
+// add counts requests, tokens and cost of id at now
+func (l *ledger) add(id string, requests, tokens int, cost float64, now time.Time) {
+	a := l.account(id, now)
+	a.Hours = charge(a.Hours, now.UTC().Truncate(time.Hour), requests, tokens, cost)
+	a.Days = charge(a.Days, now.UTC().Truncate(Day), requests, tokens, cost)
+	l.dirty[id] = true
+	if now.Sub(l.saved) >= ledgerSaveInterval {
+		if err := l.save(now); err != nil {
+			slog.Warn("Quota usage not saved", "error", err)
+		}
+	}
+}
+
+// save writes the usage of the callers that changed to the database, and
+// drops the buckets that left the windows
+func (l *ledger) save(now time.Time) error {
+	l.saved = now
+	if l.db == nil || len(l.dirty) == 0 {
+		return nil
+	}
+	err := l.db.Update(func(tx *sql.Tx) error {
+		for id := range l.dirty {
+			if _, err := tx.Exec("DELETE FROM usage_buckets WHERE caller = ?", id); err != nil {
+				return err
+			}
+			a := l.account(id, now)
+			for span, buckets := range map[string][]bucket{spanHour: a.Hours, spanDay: a.Days} {
+				for _, b := range buckets {
+					if _, err := tx.Exec("INSERT INTO usage_buckets (caller, span, start, requests, tokens, cost) VALUES (?, ?, ?, ?, ?, ?)",
+						id, span, b.Start.Unix(), b.Requests, b.Tokens, b.Cost); err != nil {
+						return err
+					}
+				}
+			}
+			if len(a.Days) == 0 {
+				delete(l.accounts, id)
+			}
+		}
+		_, err := tx.Exec("DELETE FROM usage_buckets WHERE (span = ? AND start <= ?) OR (span = ? AND start <= ?)",
+			spanHour, now.Add(-Day).Unix(), spanDay, now.Add(-Month).Unix())
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to save quota usage: %w", err)
+	}
+	clear(l.dirty)
+	return nil
+}
+
+// expire drops the buckets that started a window or more before now
+func expire(buckets []bucket, now time.Time, window time.Duration) []bucket {
+	i := 0
+	for i < len(buckets) && !now.Before(buckets[i].Start.Add(window)) {
+		i++
+	}
+	return buckets[i:]
+}
+
+// charge adds to the bucket starting at start, appending it when it is new
+func charge(buckets []bucket, start time.Time, requests, tokens int, cost float64) []bucket {
+	if n := len(buckets); n > 0 && buckets[n-1].Start.Equal(start) {
+		buckets[n-1].Requests += requests
+		buckets[n-1].Tokens += tokens
+		buckets[n-1].Cost += cost
+		return buckets
+	}
+	return append(buckets, bucket{Start: start, Requests: requests, Tokens: tokens, Cost: cost})
+}
+
+// totals sums a window of buckets. The window frees up as its oldest bucket
+// expires, which is returned as the reset time.
+func totals(buckets []bucket, window time.Duration, now time.Time) (requests, tokens int, reset time.Time) {
+	for _, b := range buckets {
+		requests += b.Requests
+		tokens += b.Tokens
+	}
+	reset = now.Add(window)
+	if len(buckets) > 0 {
+		reset = buckets[0].Start.Add(window)
+	}
+	return requests, tokens, reset.UTC()
+}
+
+// totalCost sums the estimated cost of a window of buckets
+func totalCost(buckets []bucket) float64 {
+	var cost float64
+	for _, b := range buckets {
+		cost += b.Cost
+	}
+	return pricing.Round(cost)
+}
+
+// Usage is the long term usage of a caller. Costs are estimates, 0 while no
+// price is configured.
+type Usage struct {
+	DayRequests   int     `json:"day_requests"`
+	DayTokens     int     `json:"day_tokens"`
+	DayCost       float64 `json:"day_cost,omitempty"`
+	MonthRequests int     `json:"month_requests"`
+	MonthTokens   int     `json:"month_tokens"`
+	MonthCost     float64 `json:"month_cost,omitempty"`
+}
+
+// usage returns the rolling day and month totals of id
+func (l *ledger) usage(id string, now time.Time) Usage {
+	a := l.account(id, now)
+	var u Usage
+	u.DayRequests, u.DayTokens, _ = totals(a.Hours, Day, now)
+	u.MonthRequests, u.MonthTokens, _ = totals(a.Days, Month, now)
+	u.DayCost, u.MonthCost = totalCost(a.Hours), totalCost(a.Days)
+	return u
+}
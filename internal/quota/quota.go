@@ -0,0 +1,200 @@
+package quota
+
+import (
+	"sync"
+	"time"
+
+	"github.com/devstroop/reai/internal/store"
+)
+
+// Window is the length of a rate limit window
+const Window = time.Minute
+
+// Limits are per-caller allowances per window. Zero means unlimited.
+type Limits struct {
+	RequestsPerMinute int `json:"requests_per_minute,omitempty"`
+	TokensPerMinute   int `json:"tokens_per_minute,omitempty"`
+
+	// Quotas over a rolling day (24 hours) and month (30 days), kept
+	// across restarts
+	RequestsPerDay   int `json:"requests_per_day,omitempty"`
+	TokensPerDay     int `json:"tokens_per_day,omitempty"`
+	RequestsPerMonth int `json:"requests_per_month,omitempty"`
+	TokensPerMonth   int `json:"tokens_per_month,omitempty"`
+}
+
+// Enabled reports whether any limit is set
+func (l Limits) Enabled() bool {
+	return l.RequestsPerMinute > 0 || l.TokensPerMinute > 0 || l.RequestsPerDay > 0 || l.TokensPerDay > 0 ||
+		l.RequestsPerMonth > 0 || l.TokensPerMonth > 0
+}
+
+// Headroom is what is left of one limit in the current window
+type Headroom struct {
+	Limit     int       `json:"limit"`
+	Used      int       `json:"used"`
+	Remaining int       `json:"remaining"`
+	ResetAt   time.Time `json:"reset_at"`
+}
+
+// Status is the headroom of a caller. Unlimited dimensions are nil.
+type Status struct {
+	Requests *Headroom `json:"requests"`
+	Tokens   *Headroom `json:"tokens"`
+
+	DayRequests   *Headroom `json:"day_requests,omitempty"`
+	DayTokens     *Headroom `json:"day_tokens,omitempty"`
+	MonthRequests *Headroom `json:"month_requests,omitempty"`
+	MonthTokens   *Headroom `json:"month_tokens,omitempty"`
+}
+
+// Tracker counts requests and tokens per caller in fixed minute windows that
+// start with the caller's first request, and in the rolling day and month
+// of the ledger
+type Tracker struct {
+	mutex  sync.Mutex
+	usage  map[string]*usage
+	ledger *ledger
+}
+
+type usage struct {
+	start    time.Time
+	requests int
+	tokens   int
+}
+
+// New creates an empty tracker that keeps all usage in memory
+func New() *Tracker {
+	t, _ := Open(nil, "")
+	return t
+}
+
+// Open creates a tracker whose day and month usage is kept in db, so quotas
+// survive restarts. Usage an older version saved in the file at legacyPath
+// is moved into db.
+func Open(db *store.DB, legacyPath string) (*Tracker, error) {
+	l, err := openLedger(db, legacyPath)
+	if err != nil {
+		return nil, err
+	}
+	return &Tracker{usage: make(map[string]*usage), ledger: l}, nil
+}
+
+// Save writes the day and month usage to the database
+func (t *Tracker) Save() error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.ledger.save(time.Now())
+}
+
+// Allow counts a request for id when it is within limits. Tokens are charged
+// afterwards with AddTokens, so the token limits admit requests until they
+// are used up and the last one may overshoot them.
+func (t *Tracker) Allow(id string, limits Limits, now time.Time) (Status, bool) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	u := t.current(id, now)
+	status := t.status(id, u, limits, now)
+	allowed := (limits.RequestsPerMinute <= 0 || u.requests < limits.RequestsPerMinute) &&
+		(limits.TokensPerMinute <= 0 || u.tokens < limits.TokensPerMinute) &&
+		status.DayRequests.available() && status.DayTokens.available() &&
+		status.MonthRequests.available() && status.MonthTokens.available()
+	if allowed {
+		u.requests++
+		t.ledger.add(id, 1, 0, 0, now)
+		status = t.status(id, u, limits, now)
+	}
+	return status, allowed
+}
+
+// AddTokens charges tokens used by a request of id, with their estimated cost
+func (t *Tracker) AddTokens(id string, tokens int, cost float64, now time.Time) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.current(id, now).tokens += tokens
+	t.ledger.add(id, 0, tokens, cost, now)
+}
+
+// Status returns the headroom of id without counting a request
+func (t *Tracker) Status(id string, limits Limits, now time.Time) Status {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.status(id, t.current(id, now), limits, now)
+}
+
+// Usage returns the rolling day and month usage of id
+func (t *Tracker) Usage(id string, now time.Time) Usage {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.ledger.usage(id, now)
+}
+
+// status combines the minute window u with the ledger. The caller holds t.mutex.
+func (t *Tracker) status(id string, u *usage, limits Limits, now time.Time) Status {
+	status := u.status(limits)
+	a := t.ledger.account(id, now)
+	if limits.RequestsPerDay > 0 || limits.TokensPerDay > 0 {
+		requests, tokens, reset := totals(a.Hours, Day, now)
+		if limits.RequestsPerDay > 0 {
+			status.DayRequests = headroom(limits.RequestsPerDay, requests, reset)
+		}
+		if limits.TokensPerDay > 0 {
+			status.DayTokens = headroom(limits.TokensPerDay, tokens, reset)
+		}
+	}
+	if limits.RequestsPerMonth > 0 || limits.TokensPerMonth > 0 {
+		requests, tokens, reset := totals(a.Days, Month, now)
+		if limits.RequestsPerMonth > 0 {
+			status.MonthRequests = headroom(limits.RequestsPerMonth, requests, reset)
+		}
+		if limits.TokensPerMonth > 0 {
+			status.MonthTokens = headroom(limits.TokensPerMonth, tokens, reset)
+		}
+	}
+	return status
+}
+
+// current returns the usage of id in the window containing now, starting a
+// new window when the last one has ended. The caller holds t.mutex.
+func (t *Tracker) current(id string, now time.Time) *usage {
+	u, ok := t.usage[id]
+	if !ok || !now.Before(u.start.Add(Window)) {
+		if len(t.usage) > 1024 {
+			t.prune(now)
+		}
+		u = &usage{start: now}
+		t.usage[id] = u
+	}
+	return u
+}
+
+// prune drops callers whose window has ended
+func (t *Tracker) prune(now time.Time) {
+	for id, u := range t.usage {
+		if !now.Before(u.start.Add(Window)) {
+			delete(t.usage, id)
+		}
+	}
+}
+
+func (u *usage) status(limits Limits) Status {
+	reset := u.start.Add(Window).UTC()
+	var status Status
+	if limits.RequestsPerMinute > 0 {
+		status.Requests = headroom(limits.RequestsPerMinute, u.requests, reset)
+	}
+	if limits.TokensPerMinute > 0 {
+		status.Tokens = headroom(limits.TokensPerMinute, u.tokens, reset)
+	}
+	return status
+}
+
+func headroom(limit, used int, reset time.Time) *Headroom {
+	return &Headroom{Limit: limit, Used: used, Remaining: max(limit-used, 0), ResetAt: reset}
+}
+
+// available reports whether an unlimited or unexhausted headroom admits a request
+func (h *Headroom) available() bool {
+	return h == nil || h.Remaining > 0
+}
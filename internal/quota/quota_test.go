@@ -0,0 +1,114 @@
+package quota
+
+import (
+	"testing"
+	"time"
+
+	"github.com/devstroop/reai/internal/store"
+)
+
+func TestAllow(t *testing.T) {
+	start := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name   string
+		limits Limits
+		// tokens are charged after each admitted request
+		tokens int
+		// at are the request times, as offsets from start
+		at   []time.Duration
+		want []bool
+	}{
+		{"unlimited", Limits{}, 1000, []time.Duration{0, 0, 0}, []bool{true, true, true}},
+		{"requests per minute", Limits{RequestsPerMinute: 2}, 0, []time.Duration{0, time.Second, 2 * time.Second}, []bool{true, true, false}},
+		{"minute window resets", Limits{RequestsPerMinute: 1}, 0, []time.Duration{0, 59 * time.Second, time.Minute}, []bool{true, false, true}},
+		// Tokens are charged afterwards, so the last request may overshoot
+		{"tokens per minute", Limits{TokensPerMinute: 100}, 60, []time.Duration{0, time.Second, 2 * time.Second}, []bool{true, true, false}},
+		{"requests per day", Limits{RequestsPerDay: 2}, 0, []time.Duration{0, time.Hour, 2 * time.Hour}, []bool{true, true, false}},
+		// The day rolls: the first hour's bucket expires a day after it started
+		{"rolling day", Limits{RequestsPerDay: 1}, 0, []time.Duration{0, 23 * time.Hour, Day}, []bool{true, false, true}},
+		{"tokens per day", Limits{TokensPerDay: 100}, 100, []time.Duration{0, time.Hour}, []bool{true, false}},
+		{"requests per month", Limits{RequestsPerMonth: 2}, 0, []time.Duration{0, 10 * Day, 20 * Day, Month}, []bool{true, true, false, true}},
+		{"tokens per month", Limits{TokensPerMonth: 50}, 50, []time.Duration{0, 29 * Day, Month}, []bool{true, false, true}},
+		{"tightest limit wins", Limits{RequestsPerMinute: 10, RequestsPerDay: 1}, 0, []time.Duration{0, time.Second}, []bool{true, false}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tracker := New()
+			for i, offset := range tt.at {
+				now := start.Add(offset)
+				status, ok := tracker.Allow("key", tt.limits, now)
+				if ok != tt.want[i] {
+					t.Fatalf("request %d at +%v: allowed %v, want %v (status %+v)", i, offset, ok, tt.want[i], status)
+				}
+				if ok {
+					tracker.AddTokens("key", tt.tokens, 0, now)
+				}
+			}
+		})
+	}
+}
+
+func TestStatus(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 30, 0, 0, time.UTC)
+	tracker := New()
+	limits := Limits{RequestsPerMinute: 5, TokensPerDay: 1000, RequestsPerMonth: 100}
+	if _, ok := tracker.Allow("key", limits, now); !ok {
+		t.Fatal("first request refused")
+	}
+	tracker.AddTokens("key", 300, 0.5, now)
+
+	status := tracker.Status("key", limits, now.Add(time.Second))
+	if h := status.Requests; h == nil || h.Used != 1 || h.Remaining != 4 || !h.ResetAt.Equal(now.Add(Window)) {
+		t.Errorf("minute requests %+v", h)
+	}
+	if h := status.DayTokens; h == nil || h.Used != 300 || h.Remaining != 700 || !h.ResetAt.Equal(now.Truncate(time.Hour).Add(Day)) {
+		t.Errorf("day tokens %+v", h)
+	}
+	if h := status.MonthRequests; h == nil || h.Used != 1 || !h.ResetAt.Equal(now.Truncate(Day).Add(Month)) {
+		t.Errorf("month requests %+v", h)
+	}
+	if status.Tokens != nil || status.DayRequests != nil || status.MonthTokens != nil {
+		t.Errorf("unlimited dimensions reported: %+v", status)
+	}
+
+	// Other callers are counted separately
+	if u := tracker.Usage("other", now); u != (Usage{}) {
+		t.Errorf("usage of another caller %+v", u)
+	}
+	if u := tracker.Usage("key", now); u.DayRequests != 1 || u.MonthTokens != 300 || u.DayCost != 0.5 {
+		t.Errorf("usage %+v", u)
+	}
+}
+
+// TestLedgerPersists checks that day and month usage survives a restart
+func TestLedgerPersists(t *testing.T) {
+	db, err := store.OpenMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	now := time.Now()
+	tracker, err := Open(db, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	limits := Limits{RequestsPerDay: 2}
+	tracker.Allow("key", limits, now)
+	tracker.AddTokens("key", 42, 0, now)
+	if err := tracker.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	restarted, err := Open(db, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u := restarted.Usage("key", now); u.DayRequests != 1 || u.DayTokens != 42 || u.MonthRequests != 1 {
+		t.Errorf("usage after restart %+v", u)
+	}
+	restarted.Allow("key", limits, now)
+	if _, ok := restarted.Allow("key", limits, now); ok {
+		t.Error("day quota reset by the restart")
+	}
+}
@@ -0,0 +1,63 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+)
+
+// httpWriter POSTs each batch as newline delimited JSON
+type httpWriter struct {
+	url     string
+	headers map[string]string
+	client  *http.Client
+}
+
+func newHTTPWriter(spec Spec) (*httpWriter, error) {
+	u, err := url.Parse(spec.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("url must be an http or https URL")
+	}
+	return &httpWriter{url: spec.URL, headers: spec.Headers, client: &http.Client{}}, nil
+}
+
+func (w *httpWriter) Write(ctx context.Context, events [][]byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(joinLines(events)))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	for name, value := range w.headers {
+		req.Header.Set(name, value)
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("HTTP %d: %s", resp.StatusCode, bytes.TrimSpace(body))
+	}
+	io.Copy(io.Discard, resp.Body)
+	return nil
+}
+
+func (w *httpWriter) Close() error {
+	w.client.CloseIdleConnections()
+	return nil
+}
+
+// joinLines encodes events as JSON lines
+func joinLines(events [][]byte) []byte {
+	var buf bytes.Buffer
+	for _, event := range events {
+		buf.Write(event)
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes()
+}
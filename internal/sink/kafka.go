@@ -0,0 +1,113 @@
+package sink
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/sasl"
+	"github.com/segmentio/kafka-go/sasl/plain"
+	"github.com/segmentio/kafka-go/sasl/scram"
+)
+
+// SASL mechanisms of Kafka sinks
+const (
+	SASLPlain       = "plain"
+	SASLScramSHA256 = "scram-sha-256"
+	SASLScramSHA512 = "scram-sha-512"
+)
+
+// kafkaLinger is how long kafka-go waits to fill a batch. The sink hands
+// over whole batches, so there is nothing to wait for.
+const kafkaLinger = time.Millisecond
+
+// kafkaWriter produces events with kafka-go. Records are keyed by the
+// key_id of the event and assigned to partitions the way the Java client
+// does, so the records of one key stay in order.
+type kafkaWriter struct {
+	writer *kafka.Writer
+}
+
+func newKafkaWriter(spec Spec) (*kafkaWriter, error) {
+	if len(spec.Brokers) == 0 {
+		return nil, fmt.Errorf("brokers are required")
+	}
+	if spec.Topic == "" {
+		return nil, fmt.Errorf("topic is required")
+	}
+
+	transport := &kafka.Transport{ClientID: "reai"}
+	if spec.TLS {
+		transport.TLS = &tls.Config{MinVersion: tls.VersionTLS12}
+	}
+	if spec.SASL != nil {
+		mechanism, err := saslMechanism(*spec.SASL)
+		if err != nil {
+			return nil, err
+		}
+		transport.SASL = mechanism
+	}
+
+	return &kafkaWriter{writer: &kafka.Writer{
+		Addr:      kafka.TCP(spec.Brokers...),
+		Topic:     spec.Topic,
+		Balancer:  kafka.Murmur2Balancer{},
+		Transport: transport,
+		// The sink batches and retries
+		BatchSize:    max(spec.BatchSize, DefaultBatchSize),
+		BatchTimeout: kafkaLinger,
+		MaxAttempts:  1,
+		RequiredAcks: kafka.RequireOne,
+	}}, nil
+}
+
+// saslMechanism returns the SASL mechanism of the configuration
+func saslMechanism(cfg SASL) (sasl.Mechanism, error) {
+	if cfg.Username == "" || cfg.Password == "" {
+		return nil, fmt.Errorf("sasl requires a username and password")
+	}
+	switch strings.ToLower(cfg.Mechanism) {
+	case SASLPlain:
+		return plain.Mechanism{Username: cfg.Username, Password: cfg.Password}, nil
+	case SASLScramSHA256:
+		return scram.Mechanism(scram.SHA256, cfg.Username, cfg.Password)
+	case SASLScramSHA512:
+		return scram.Mechanism(scram.SHA512, cfg.Username, cfg.Password)
+	default:
+		return nil, fmt.Errorf("unknown sasl mechanism %q (want %s, %s or %s)", cfg.Mechanism, SASLPlain, SASLScramSHA256, SASLScramSHA512)
+	}
+}
+
+func (w *kafkaWriter) Write(ctx context.Context, events [][]byte) error {
+	return w.writer.WriteMessages(ctx, kafkaMessages(events)...)
+}
+
+func (w *kafkaWriter) Close() error {
+	return w.writer.Close()
+}
+
+// kafkaMessages turns events into records keyed by their key_id, without a
+// key when the event has none
+func kafkaMessages(events [][]byte) []kafka.Message {
+	messages := make([]kafka.Message, len(events))
+	for i, event := range events {
+		messages[i].Value = event
+		if key := eventKey(event); key != "" {
+			messages[i].Key = []byte(key)
+		}
+	}
+	return messages
+}
+
+// eventKey returns the key_id of a JSON encoded audit record
+func eventKey(event []byte) string {
+	var fields struct {
+		KeyID string `json:"key_id"`
+	}
+	json.Unmarshal(event, &fields)
+	return fields.KeyID
+}
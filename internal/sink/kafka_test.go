@@ -0,0 +1,88 @@
+package sink
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/segmentio/kafka-go"
+)
+
+func TestKafkaMessages(t *testing.T) {
+	events := [][]byte{
+		[]byte(`{"request_id":"a","key_id":"team-a"}`),
+		[]byte(`{"request_id":"b"}`),
+		[]byte(`not json`),
+	}
+	messages := kafkaMessages(events)
+	if len(messages) != len(events) {
+		t.Fatalf("got %d messages for %d events", len(messages), len(events))
+	}
+	if string(messages[0].Key) != "team-a" {
+		t.Errorf("key %q, want the key_id", messages[0].Key)
+	}
+	// Records without a key are spread across the partitions
+	for _, m := range messages[1:] {
+		if m.Key != nil {
+			t.Errorf("key %q for an event without key_id", m.Key)
+		}
+	}
+	for i, m := range messages {
+		if string(m.Value) != string(events[i]) {
+			t.Errorf("value %q, want the event", m.Value)
+		}
+	}
+}
+
+func TestKafkaWriterSASL(t *testing.T) {
+	tests := []struct {
+		sasl    *SASL
+		want    string
+		wantErr string
+	}{
+		{nil, "", ""},
+		{&SASL{Mechanism: "plain", Username: "u", Password: "p"}, "PLAIN", ""},
+		{&SASL{Mechanism: "SCRAM-SHA-256", Username: "u", Password: "p"}, "SCRAM-SHA-256", ""},
+		{&SASL{Mechanism: "scram-sha-512", Username: "u", Password: "p"}, "SCRAM-SHA-512", ""},
+		{&SASL{Mechanism: "gssapi", Username: "u", Password: "p"}, "", "unknown sasl mechanism"},
+		{&SASL{Mechanism: "plain", Username: "u"}, "", "username and password"},
+	}
+	for _, tt := range tests {
+		w, err := newKafkaWriter(Spec{Brokers: []string{"localhost:9092"}, Topic: "reai", SASL: tt.sasl})
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("%+v: got error %v, want %q", tt.sasl, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%+v: %v", tt.sasl, err)
+		}
+		got := ""
+		if mechanism := w.writer.Transport.(*kafka.Transport).SASL; mechanism != nil {
+			got = mechanism.Name()
+		}
+		if got != tt.want {
+			t.Errorf("%+v: mechanism %q, want %q", tt.sasl, got, tt.want)
+		}
+	}
+}
+
+func TestKafkaWriterConfig(t *testing.T) {
+	for _, spec := range []Spec{{Topic: "reai"}, {Brokers: []string{"localhost:9092"}}} {
+		if _, err := newKafkaWriter(spec); err == nil {
+			t.Errorf("%+v: no error", spec)
+		}
+	}
+
+	w, err := newKafkaWriter(Spec{Brokers: []string{"localhost:9092"}, Topic: "reai", TLS: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w.writer.Transport.(*kafka.Transport).TLS == nil {
+		t.Error("TLS not enabled")
+	}
+	// The sink retries failed batches itself
+	if w.writer.MaxAttempts != 1 {
+		t.Errorf("MaxAttempts %d", w.writer.MaxAttempts)
+	}
+}
@@ -0,0 +1,90 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// defaultS3Region is used when neither the spec nor the AWS configuration
+// names a region
+const defaultS3Region = "us-east-1"
+
+// s3Writer uploads each batch as a JSONL object. It works with S3 and S3
+// compatible stores such as MinIO.
+type s3Writer struct {
+	client *s3.Client
+	bucket string
+	prefix string
+}
+
+// newS3Writer creates the writer of spec. Without keys in the spec,
+// credentials come from the AWS default chain: the environment, shared
+// config and SSO profiles, web identity (IRSA), and ECS or EC2 roles.
+func newS3Writer(spec Spec) (*s3Writer, error) {
+	if spec.Bucket == "" {
+		return nil, fmt.Errorf("bucket is required")
+	}
+	if spec.Endpoint != "" {
+		u, err := url.Parse(spec.Endpoint)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("endpoint must be an http or https URL")
+		}
+	}
+
+	var options []func(*config.LoadOptions) error
+	if spec.Region != "" {
+		options = append(options, config.WithRegion(spec.Region))
+	}
+	switch {
+	case spec.AccessKeyID != "" && spec.SecretAccessKey != "":
+		options = append(options, config.WithCredentialsProvider(
+			credentials.NewStaticCredentialsProvider(spec.AccessKeyID, spec.SecretAccessKey, spec.SessionToken)))
+	case spec.AccessKeyID != "" || spec.SecretAccessKey != "":
+		return nil, fmt.Errorf("access_key_id and secret_access_key go together")
+	}
+	// Credentials are only resolved by the first upload
+	cfg, err := config.LoadDefaultConfig(context.Background(), options...)
+	if err != nil {
+		return nil, fmt.Errorf("AWS configuration: %w", err)
+	}
+	if cfg.Region == "" {
+		cfg.Region = defaultS3Region
+	}
+
+	client := s3.NewFromConfig(cfg, func(o *s3.Options) {
+		if spec.Endpoint != "" {
+			o.BaseEndpoint = aws.String(spec.Endpoint)
+		}
+		o.UsePathStyle = spec.PathStyle
+	})
+	return &s3Writer{client: client, bucket: spec.Bucket, prefix: strings.TrimPrefix(spec.Prefix, "/")}, nil
+}
+
+func (w *s3Writer) Write(ctx context.Context, events [][]byte) error {
+	suffix := make([]byte, 6)
+	rand.Read(suffix)
+	key := w.prefix + time.Now().UTC().Format("2006/01/02/15/20060102T150405Z") + "-" + hex.EncodeToString(suffix) + ".jsonl"
+
+	_, err := w.client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(w.bucket),
+		Key:         aws.String(key),
+		Body:        bytes.NewReader(joinLines(events)),
+		ContentType: aws.String("application/x-ndjson"),
+	})
+	return err
+}
+
+func (w *s3Writer) Close() error {
+	return nil
+}
@@ -0,0 +1,365 @@
+package sink
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/devstroop/reai/internal/audit"
+	"github.com/devstroop/reai/internal/metrics"
+)
+
+// Sink types
+const (
+	TypeHTTP  = "http"
+	TypeKafka = "kafka"
+	TypeS3    = "s3"
+)
+
+// Defaults applied to sinks that leave the field empty
+const (
+	DefaultQueueSize     = 10000
+	DefaultBatchSize     = 500
+	DefaultFlushInterval = 5 * time.Second
+	DefaultTimeout       = 30 * time.Second
+	DefaultRetries       = 3
+)
+
+var (
+	sinkEvents  = metrics.NewCounterVec("reai_sink_events_total", "Events handled by analytics sinks, by result (sent, dropped, failed)", "sink", "result")
+	sinkBatches = metrics.NewCounterVec("reai_sink_batches_total", "Batches written by analytics sinks, by result (ok, error)", "sink", "result")
+	sinkQueued  = metrics.NewGaugeVec("reai_sink_queue_depth", "Events waiting to be written by each analytics sink", "sink")
+)
+
+// Config is the on-disk format of SINKS_FILE
+type Config struct {
+	Sinks []Spec `json:"sinks"`
+}
+
+// Spec configures a single sink. Only the fields of its type apply.
+type Spec struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+
+	// IncludeText keeps the prompt and completion text captured by the audit
+	// policy; by default only metadata and token counts are sent
+	IncludeText bool `json:"include_text,omitempty"`
+
+	QueueSize     int    `json:"queue_size,omitempty"`
+	BatchSize     int    `json:"batch_size,omitempty"`
+	FlushInterval string `json:"flush_interval,omitempty"`
+	Timeout       string `json:"timeout,omitempty"`
+	Retries       *int   `json:"retries,omitempty"`
+
+	// HTTP: events are POSTed as NDJSON
+	URL     string            `json:"url,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+
+	// Kafka: events are produced as records keyed by API key ID
+	Brokers []string `json:"brokers,omitempty"`
+	Topic   string   `json:"topic,omitempty"`
+	TLS     bool     `json:"tls,omitempty"`
+	SASL    *SASL    `json:"sasl,omitempty"`
+
+	// S3: every batch becomes one JSONL object under Prefix. Without keys,
+	// credentials and the region come from the AWS default chain.
+	Bucket          string `json:"bucket,omitempty"`
+	Region          string `json:"region,omitempty"`
+	Prefix          string `json:"prefix,omitempty"`
+	Endpoint        string `json:"endpoint,omitempty"`
+	PathStyle       bool   `json:"path_style,omitempty"`
+	AccessKeyID     string `json:"access_key_id,omitempty"`
+	SecretAccessKey string `json:"secret_access_key,omitempty"`
+	SessionToken    string `json:"session_token,omitempty"`
+}
+
+// SASL authenticates a Kafka sink with the PLAIN or SCRAM mechanism
+type SASL struct {
+	Mechanism string `json:"mechanism"`
+	Username  string `json:"username"`
+	Password  string `json:"password"`
+}
+
+// Writer delivers a batch of JSON encoded events to an external system
+type Writer interface {
+	Write(ctx context.Context, events [][]byte) error
+	Close() error
+}
+
+// sink queues events for one writer and writes them in batches
+type sink struct {
+	name          string
+	includeText   bool
+	writer        Writer
+	events        chan []byte
+	batchSize     int
+	flushInterval time.Duration
+	timeout       time.Duration
+	retries       int
+}
+
+// Dispatcher fans events out to the configured sinks without blocking the
+// request path. Events that don't fit in a sink's queue are dropped.
+type Dispatcher struct {
+	policy audit.Policy
+	sinks  []*sink
+	done   chan struct{}
+	wg     sync.WaitGroup
+}
+
+// Load reads the sinks file at path. Records are captured with the same
+// policy as the audit log. An empty path disables the sinks and returns a nil
+// dispatcher, which is safe to use.
+func Load(path string, policy audit.Policy) (*Dispatcher, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read sinks file: %w", err)
+	}
+	var cfg Config
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse sinks file: %w", err)
+	}
+	return New(cfg, policy)
+}
+
+// New builds the sinks of a configuration and starts writing
+func New(cfg Config, policy audit.Policy) (*Dispatcher, error) {
+	d := &Dispatcher{policy: policy, done: make(chan struct{})}
+	names := make(map[string]bool)
+	for i, spec := range cfg.Sinks {
+		s, err := newSink(spec)
+		if err != nil {
+			d.closeWriters()
+			return nil, fmt.Errorf("sink %d: %w", i+1, err)
+		}
+		if names[s.name] {
+			d.closeWriters()
+			return nil, fmt.Errorf("duplicate sink name %q", s.name)
+		}
+		names[s.name] = true
+		d.sinks = append(d.sinks, s)
+	}
+
+	for _, s := range d.sinks {
+		d.wg.Add(1)
+		go func(s *sink) {
+			defer d.wg.Done()
+			s.run(d.done)
+		}(s)
+	}
+	return d, nil
+}
+
+func newSink(spec Spec) (*sink, error) {
+	if spec.Name == "" {
+		return nil, fmt.Errorf("name is required")
+	}
+
+	s := &sink{
+		name:          spec.Name,
+		includeText:   spec.IncludeText,
+		batchSize:     DefaultBatchSize,
+		flushInterval: DefaultFlushInterval,
+		timeout:       DefaultTimeout,
+		retries:       DefaultRetries,
+	}
+	queueSize := DefaultQueueSize
+	if spec.QueueSize > 0 {
+		queueSize = spec.QueueSize
+	}
+	if spec.BatchSize > 0 {
+		s.batchSize = spec.BatchSize
+	}
+	if spec.Retries != nil && *spec.Retries >= 0 {
+		s.retries = *spec.Retries
+	}
+	for _, field := range []struct {
+		name  string
+		value string
+		dst   *time.Duration
+	}{
+		{"flush_interval", spec.FlushInterval, &s.flushInterval},
+		{"timeout", spec.Timeout, &s.timeout},
+	} {
+		if field.value == "" {
+			continue
+		}
+		d, err := time.ParseDuration(field.value)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("%s: invalid duration %q", field.name, field.value)
+		}
+		*field.dst = d
+	}
+
+	var err error
+	switch spec.Type {
+	case TypeHTTP:
+		s.writer, err = newHTTPWriter(spec)
+	case TypeKafka:
+		s.writer, err = newKafkaWriter(spec)
+	case TypeS3:
+		s.writer, err = newS3Writer(spec)
+	default:
+		err = fmt.Errorf("unknown type %q (want http, kafka or s3)", spec.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+	s.events = make(chan []byte, queueSize)
+	return s, nil
+}
+
+// Len returns the number of configured sinks
+func (d *Dispatcher) Len() int {
+	if d == nil {
+		return 0
+	}
+	return len(d.sinks)
+}
+
+// IncludesText reports whether any sink sends prompt and completion text
+func (d *Dispatcher) IncludesText() bool {
+	if d == nil {
+		return false
+	}
+	for _, s := range d.sinks {
+		if s.includeText {
+			return true
+		}
+	}
+	return false
+}
+
+// Publish queues an audit record for every sink. It never blocks.
+func (d *Dispatcher) Publish(record audit.Record) {
+	if d == nil {
+		return
+	}
+
+	record = d.policy.Apply(record)
+	var full, metadata []byte
+	for _, s := range d.sinks {
+		var event []byte
+		if s.includeText {
+			if full == nil {
+				full, _ = json.Marshal(record)
+			}
+			event = full
+		} else {
+			if metadata == nil {
+				stripped := record
+				stripped.Prompt, stripped.PromptTruncated = "", false
+				stripped.Completion, stripped.CompletionTruncated = "", false
+				metadata, _ = json.Marshal(stripped)
+			}
+			event = metadata
+		}
+
+		select {
+		case s.events <- event:
+			sinkQueued.With(s.name).Inc()
+		default:
+			sinkEvents.With(s.name, "dropped").Inc()
+		}
+	}
+}
+
+// Close flushes the queued events, waiting at most until ctx is done, and
+// closes the sinks
+func (d *Dispatcher) Close(ctx context.Context) error {
+	if d == nil {
+		return nil
+	}
+	close(d.done)
+
+	flushed := make(chan struct{})
+	go func() {
+		d.wg.Wait()
+		close(flushed)
+	}()
+	select {
+	case <-flushed:
+	case <-ctx.Done():
+		slog.Warn("Analytics sinks did not flush in time, dropping queued events")
+	}
+	d.closeWriters()
+	return nil
+}
+
+func (d *Dispatcher) closeWriters() {
+	for _, s := range d.sinks {
+		if err := s.writer.Close(); err != nil {
+			slog.Warn("Failed to close analytics sink", "sink", s.name, "error", err)
+		}
+	}
+}
+
+// run batches events until done is closed, then writes what is left
+func (s *sink) run(done <-chan struct{}) {
+	ticker := time.NewTicker(s.flushInterval)
+	defer ticker.Stop()
+
+	var batch [][]byte
+	for {
+		select {
+		case event := <-s.events:
+			sinkQueued.With(s.name).Dec()
+			batch = append(batch, event)
+			if len(batch) < s.batchSize {
+				continue
+			}
+		case <-ticker.C:
+		case <-done:
+			for {
+				select {
+				case event := <-s.events:
+					sinkQueued.With(s.name).Dec()
+					batch = append(batch, event)
+					if len(batch) >= s.batchSize {
+						s.flush(batch)
+						batch = nil
+					}
+				default:
+					s.flush(batch)
+					return
+				}
+			}
+		}
+		s.flush(batch)
+		batch = nil
+	}
+}
+
+// flush writes a batch, retrying with backoff before giving up on it
+func (s *sink) flush(batch [][]byte) {
+	if len(batch) == 0 {
+		return
+	}
+
+	var err error
+	for attempt := 0; attempt <= s.retries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(time.Duration(attempt*attempt) * time.Second)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+		err = s.writer.Write(ctx, batch)
+		cancel()
+		if err == nil {
+			sinkBatches.With(s.name, "ok").Inc()
+			sinkEvents.With(s.name, "sent").Add(float64(len(batch)))
+			return
+		}
+		slog.Warn("Analytics sink write failed", "sink", s.name, "events", len(batch), "attempt", attempt+1, "error", err)
+	}
+	sinkBatches.With(s.name, "error").Inc()
+	sinkEvents.With(s.name, "failed").Add(float64(len(batch)))
+	slog.Error("Analytics sink dropped a batch", "sink", s.name, "events", len(batch), "error", err)
+}
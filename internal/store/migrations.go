@@ -0,0 +1,79 @@
+package store
+
+// migrations build the schema, one version each. Released migrations are
+// never edited; changes go in a new one at the end.
+var migrations = []string{
+	// 1: usage, keys, idempotent responses, batches and conversations
+	`
+CREATE TABLE usage_buckets (
+	caller   TEXT    NOT NULL,
+	span     TEXT    NOT NULL, -- hour or day
+	start    INTEGER NOT NULL, -- unix seconds
+	requests INTEGER NOT NULL DEFAULT 0,
+	tokens   INTEGER NOT NULL DEFAULT 0,
+	cost     REAL    NOT NULL DEFAULT 0,
+	PRIMARY KEY (caller, span, start)
+);
+
+CREATE TABLE api_keys (
+	id      TEXT    PRIMARY KEY,
+	created INTEGER NOT NULL,
+	data    TEXT    NOT NULL -- JSON keys.Key
+);
+
+CREATE TABLE idempotent_responses (
+	key         TEXT    PRIMARY KEY,
+	fingerprint BLOB    NOT NULL,
+	status      INTEGER NOT NULL,
+	header      TEXT    NOT NULL, -- JSON http.Header
+	body        BLOB    NOT NULL,
+	expires     INTEGER NOT NULL
+);
+CREATE INDEX idempotent_responses_expires ON idempotent_responses (expires);
+
+CREATE TABLE batches (
+	id      TEXT    PRIMARY KEY,
+	owner   TEXT    NOT NULL,
+	created INTEGER NOT NULL,
+	data    TEXT    NOT NULL -- JSON batch.Batch
+);
+
+CREATE TABLE conversations (
+	id      TEXT    PRIMARY KEY,
+	owner   TEXT    NOT NULL,
+	updated INTEGER NOT NULL,
+	data    TEXT    NOT NULL -- JSON conversations.Conversation
+);
+`,
+	// 2: daily usage history per key and model, kept for reporting
+	`
+CREATE TABLE usage_daily (
+	day               TEXT    NOT NULL, -- YYYY-MM-DD, UTC
+	key_id            TEXT    NOT NULL, -- empty without an API key
+	model             TEXT    NOT NULL,
+	requests          INTEGER NOT NULL DEFAULT 0,
+	errors            INTEGER NOT NULL DEFAULT 0,
+	prompt_tokens     INTEGER NOT NULL DEFAULT 0,
+	completion_tokens INTEGER NOT NULL DEFAULT 0,
+	cost              REAL    NOT NULL DEFAULT 0,
+	PRIMARY KEY (day, key_id, model)
+);
+`,
+	// 3: per-response usage records paired with watermarks
+	`
+CREATE TABLE usage_records (
+	request_id        TEXT    PRIMARY KEY,
+	watermark         TEXT    NOT NULL,
+	key_hash          TEXT    NOT NULL,
+	time              INTEGER NOT NULL, -- unix seconds
+	path              TEXT    NOT NULL,
+	model             TEXT    NOT NULL,
+	prompt_tokens     INTEGER NOT NULL,
+	completion_tokens INTEGER NOT NULL,
+	completion_sha256 TEXT    NOT NULL,
+	finish_reason     TEXT    NOT NULL
+);
+CREATE INDEX usage_records_time ON usage_records (time);
+CREATE INDEX usage_records_completion ON usage_records (completion_sha256);
+`,
+}
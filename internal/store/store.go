@@ -0,0 +1,104 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	// Pure Go SQLite driver, so builds stay CGO_ENABLED=0
+	_ "modernc.org/sqlite"
+)
+
+// DB is the SQLite database under DATA_DIR holding the state that outlives a
+// restart: long term usage, stored API keys, idempotent responses, batches
+// and conversations
+type DB struct {
+	*sql.DB
+	path string
+}
+
+// Open opens the database at path, creating it if needed, and applies the
+// migrations it lacks
+func Open(path string) (*DB, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, fmt.Errorf("failed to create database directory: %w", err)
+	}
+	// Create the file up front so it is private: SQLite would create it
+	// world readable, and gives the WAL files the mode of the database
+	f, err := os.OpenFile(path, os.O_RDONLY|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create database: %w", err)
+	}
+	f.Close()
+	return open(path, path+"?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)&_pragma=synchronous(NORMAL)")
+}
+
+// OpenMemory opens a database kept in memory, for READ_ONLY: everything
+// works as with a file, but nothing outlives the process
+func OpenMemory() (*DB, error) {
+	return open(":memory:", ":memory:")
+}
+
+func open(path, dsn string) (*DB, error) {
+	conn, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+	// A single connection serializes writers, so they never wait on
+	// SQLite's file lock. It also holds an in-memory database, so it is
+	// never closed while idle.
+	conn.SetMaxOpenConns(1)
+	conn.SetConnMaxIdleTime(0)
+	conn.SetConnMaxLifetime(0)
+
+	db := &DB{DB: conn, path: path}
+	if err := db.migrate(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// Path returns the file of the database
+func (db *DB) Path() string {
+	return db.path
+}
+
+// Update runs fn in a transaction, committed when fn returns nil
+func (db *DB) Update(fn func(tx *sql.Tx) error) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// migrate applies the migrations the database lacks. user_version counts
+// the migrations applied.
+func (db *DB) migrate() error {
+	var version int
+	if err := db.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		return fmt.Errorf("failed to read database version: %w", err)
+	}
+	if version > len(migrations) {
+		return fmt.Errorf("database %s has version %d, newer than this build supports (%d)", db.path, version, len(migrations))
+	}
+	for ; version < len(migrations); version++ {
+		err := db.Update(func(tx *sql.Tx) error {
+			if _, err := tx.Exec(migrations[version]); err != nil {
+				return err
+			}
+			_, err := tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", version+1))
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("failed to migrate database to version %d: %w", version+1, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package transform
+
+import "strings"
+
+// StripCodeFences returns a hook removing the markdown fence Copilot now and
+// then ends a code completion with, e.g. "x := 1\n```". Chat completions are
+// left alone; fences are part of their markdown.
+func StripCodeFences() Hook {
+	return HookFunc(func(info Info) Transformer {
+		if info.Chat {
+			return nil
+		}
+		return &fenceStripper{}
+	})
+}
+
+// fenceStripper holds back trailing lines that could turn out to be a
+// closing fence until more text proves otherwise or the completion ends
+type fenceStripper struct {
+	held string
+}
+
+func (f *fenceStripper) Chunk(text string) (string, error) {
+	text = f.held + text
+	cut := fenceCandidate(text)
+	f.held = text[cut:]
+	return text[:cut], nil
+}
+
+func (f *fenceStripper) Finish(string) (string, error) {
+	held := f.held
+	f.held = ""
+	if fence := strings.TrimSpace(held); len(fence) >= 3 && strings.Trim(fence, "`") == "" {
+		return "", nil
+	}
+	return held, nil
+}
+
+// fenceCandidate returns where the trailing part of text that could still
+// become a closing fence starts: a newline followed by nothing but spaces,
+// backticks and line breaks. It returns len(text) when there is none.
+func fenceCandidate(text string) int {
+	start := len(text)
+	for start > 0 && strings.IndexByte(" \t\r\n`", text[start-1]) >= 0 {
+		start--
+	}
+	newline := strings.IndexByte(text[start:], '\n')
+	if newline < 0 {
+		return len(text)
+	}
+	candidate := text[start+newline:]
+	// One run of backticks at most, on its own line
+	if strings.Count(candidate, "`") > 0 && strings.Trim(strings.TrimSpace(candidate), "`") != "" {
+		return len(text)
+	}
+	return start + newline
+}
@@ -0,0 +1,251 @@
+package transform
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Post-processors of code completions, named as in COMPLETION_POSTPROCESS
+const (
+	PostprocessStripEcho          = "strip_echo"
+	PostprocessBalancedBrackets   = "balanced_brackets"
+	PostprocessCollapseBlankLines = "collapse_blank_lines"
+	PostprocessTrimPartialLine    = "trim_partial_line"
+)
+
+// postprocessors builds the post-processors by name, in the order they run
+var postprocessors = []struct {
+	name string
+	hook func() Hook
+}{
+	{PostprocessStripEcho, StripEcho},
+	{PostprocessBalancedBrackets, BalancedBrackets},
+	{PostprocessCollapseBlankLines, CollapseBlankLines},
+	{PostprocessTrimPartialLine, TrimPartialLine},
+}
+
+// Postprocessors returns the hooks of a comma separated list of
+// post-processor names. They run in a fixed order, whatever the order of
+// the list.
+func Postprocessors(names string) ([]Hook, error) {
+	wanted := make(map[string]bool)
+	for _, name := range strings.Split(names, ",") {
+		if name = strings.ToLower(strings.TrimSpace(name)); name != "" {
+			wanted[name] = true
+		}
+	}
+	var hooks []Hook
+	for _, p := range postprocessors {
+		if wanted[p.name] {
+			hooks = append(hooks, p.hook())
+			delete(wanted, p.name)
+		}
+	}
+	for name := range wanted {
+		return nil, fmt.Errorf("unknown completion post-processor %q", name)
+	}
+	return hooks, nil
+}
+
+// codeOnly starts newTransformer for code completions only
+func codeOnly(newTransformer func(info Info) Transformer) Hook {
+	return HookFunc(func(info Info) Transformer {
+		if info.Chat {
+			return nil
+		}
+		return newTransformer(info)
+	})
+}
+
+// StripEcho returns a hook removing the line before the cursor when the
+// completion starts by repeating it
+func StripEcho() Hook {
+	return codeOnly(func(info Info) Transformer {
+		line := info.Prompt[strings.LastIndexByte(info.Prompt, '\n')+1:]
+		if len(strings.TrimSpace(line)) < minEchoLength {
+			return nil
+		}
+		return &echoStripper{line: line}
+	})
+}
+
+// minEchoLength is the shortest line StripEcho looks for, so a lone "}" or
+// "x" isn't mistaken for an echo
+const minEchoLength = 3
+
+// echoStripper holds back the start of the completion until it can tell
+// whether it repeats line
+type echoStripper struct {
+	line    string
+	held    string
+	decided bool
+}
+
+func (e *echoStripper) Chunk(text string) (string, error) {
+	if e.decided {
+		return text, nil
+	}
+	e.held += text
+	trimmed := strings.TrimLeft(e.held, " \t")
+	line := strings.TrimLeft(e.line, " \t")
+	if len(trimmed) < len(line) && strings.HasPrefix(line, trimmed) {
+		return "", nil
+	}
+	e.decided = true
+	text, e.held = e.held, ""
+	if rest, ok := strings.CutPrefix(trimmed, line); ok {
+		return rest, nil
+	}
+	return text, nil
+}
+
+func (e *echoStripper) Finish(string) (string, error) {
+	held := e.held
+	e.held = ""
+	return held, nil
+}
+
+// CollapseBlankLines returns a hook collapsing runs of blank lines into one
+func CollapseBlankLines() Hook {
+	return codeOnly(func(Info) Transformer {
+		return &blankLineCollapser{}
+	})
+}
+
+// blankLineCollapser holds back trailing whitespace until the next text
+// shows how many blank lines it spans
+type blankLineCollapser struct {
+	held string
+}
+
+// whitespace matches the runs of whitespace blankLineCollapser shortens
+var whitespace = regexp.MustCompile(`[ \t\r\n]+`)
+
+func (b *blankLineCollapser) Chunk(text string) (string, error) {
+	text = b.held + text
+	end := len(strings.TrimRight(text, " \t\r\n"))
+	b.held = text[end:]
+	return whitespace.ReplaceAllStringFunc(text[:end], collapseBlankLines), nil
+}
+
+func (b *blankLineCollapser) Finish(string) (string, error) {
+	held := b.held
+	b.held = ""
+	return collapseBlankLines(held), nil
+}
+
+// collapseBlankLines shortens whitespace spanning more than one blank line
+// to one blank line, keeping the indentation of the line after it
+func collapseBlankLines(space string) string {
+	if strings.Count(space, "\n") <= 2 {
+		return space
+	}
+	return "\n\n" + space[strings.LastIndexByte(space, '\n')+1:]
+}
+
+// TrimPartialLine returns a hook dropping the last line of a completion cut
+// off by max_tokens, which is most likely incomplete. Text is held back a
+// line at a time to tell.
+func TrimPartialLine() Hook {
+	return codeOnly(func(Info) Transformer {
+		return &partialLineTrimmer{}
+	})
+}
+
+type partialLineTrimmer struct {
+	held string
+	// lines is set once a full line was passed on
+	lines bool
+}
+
+func (p *partialLineTrimmer) Chunk(text string) (string, error) {
+	text = p.held + text
+	newline := strings.LastIndexByte(text, '\n')
+	if newline < 0 {
+		p.held = text
+		return "", nil
+	}
+	p.lines = true
+	p.held = text[newline:]
+	return text[:newline], nil
+}
+
+func (p *partialLineTrimmer) Finish(finishReason string) (string, error) {
+	held := p.held
+	p.held = ""
+	if finishReason == "length" && p.lines {
+		return "", nil
+	}
+	return held, nil
+}
+
+// BalancedBrackets returns a hook ending a code completion at the end of the
+// line where the brackets it opened are all closed again, or before a
+// bracket closing one opened before the cursor. Brackets in string literals
+// on the same line are ignored.
+func BalancedBrackets() Hook {
+	return codeOnly(func(Info) Transformer {
+		return &bracketBalancer{}
+	})
+}
+
+type bracketBalancer struct {
+	depth int
+	// opened is set once the completion opened a bracket
+	opened bool
+	// quote is the quote of the string literal the text is in (0 outside)
+	quote   byte
+	escaped bool
+	// line is the text of the current line so far
+	line strings.Builder
+}
+
+func (b *bracketBalancer) Chunk(text string) (string, error) {
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		if c == '\n' {
+			if b.opened && b.depth == 0 {
+				return text[:i], ErrStop
+			}
+			// Quotes don't span lines, except for backquoted strings
+			if b.quote != '`' {
+				b.quote, b.escaped = 0, false
+			}
+			b.line.Reset()
+			continue
+		}
+		switch {
+		case b.quote != 0:
+			switch {
+			case b.escaped:
+				b.escaped = false
+			case c == '\\':
+				b.escaped = true
+			case c == b.quote:
+				b.quote = 0
+			}
+		case c == '"' || c == '\'' || c == '`':
+			b.quote = c
+		case c == '(' || c == '[' || c == '{':
+			b.depth++
+			b.opened = true
+		case c == ')' || c == ']' || c == '}':
+			b.depth--
+			if b.depth < 0 {
+				// Closes a bracket of the prompt: stop before it, and
+				// before its line when nothing else is on it
+				if strings.TrimSpace(b.line.String()) == "" {
+					return strings.TrimRight(text[:i-min(i, b.line.Len())], "\r\n"), ErrStop
+				}
+				return text[:i], ErrStop
+			}
+		}
+		b.line.WriteByte(c)
+	}
+	return text, nil
+}
+
+func (b *bracketBalancer) Finish(string) (string, error) {
+	return "", nil
+}
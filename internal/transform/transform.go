@@ -0,0 +1,167 @@
+// Package transform rewrites model output as it is produced. Hooks see every
+// completion, streamed or buffered, and can redact, clean up or observe its
+// text before the client gets it.
+package transform
+
+import "errors"
+
+// ErrStop is returned by Transformer.Chunk, along with the text to keep, to
+// end the completion there
+var ErrStop = errors.New("completion stopped by output hook")
+
+// Info describes the completion a hook is started for
+type Info struct {
+	// ID is the response ID
+	ID    string
+	Model string
+	// KeyID is the API key of the caller (empty without authentication)
+	KeyID string
+	// Chat is set for chat completions; code completions leave it unset
+	Chat bool
+	// Prompt is the text before the cursor of a code completion
+	Prompt string
+	// Language is the language of the file being completed, when known
+	Language string
+}
+
+// Hook transforms the output of completions
+type Hook interface {
+	// Start returns the transformer of one completion, nil to leave it alone
+	Start(info Info) Transformer
+}
+
+// Transformer transforms the text of one completion. A buffered completion
+// is passed to Chunk in one piece.
+type Transformer interface {
+	// Chunk transforms the next piece of text. It may hold text back by
+	// returning less, and release it with a later chunk or Finish. Returning
+	// ErrStop ends the completion after the text returned with it; Finish is
+	// not called then.
+	Chunk(text string) (string, error)
+	// Finish is called once the completion ended without error, with the
+	// finish reason upstream gave, and returns the text still held back
+	Finish(finishReason string) (string, error)
+}
+
+// HookFunc adapts a function to a Hook
+type HookFunc func(info Info) Transformer
+
+// Start calls f
+func (f HookFunc) Start(info Info) Transformer {
+	return f(info)
+}
+
+// Chain runs the transformers of a completion in order, each on the output
+// of the one before
+type Chain struct {
+	transformers []Transformer
+	stopped      bool
+}
+
+// Start starts hooks for a completion. It returns nil when none of them
+// transforms it; a nil Chain passes text through.
+func Start(hooks []Hook, info Info) *Chain {
+	var transformers []Transformer
+	for _, hook := range hooks {
+		if t := hook.Start(info); t != nil {
+			transformers = append(transformers, t)
+		}
+	}
+	if len(transformers) == 0 {
+		return nil
+	}
+	return &Chain{transformers: transformers}
+}
+
+// Chunk transforms the next piece of text. When a transformer stops the
+// completion, the text still to be sent is returned with ErrStop; the
+// transformers after it are finished with the stop finish reason.
+func (c *Chain) Chunk(text string) (string, error) {
+	if c == nil {
+		return text, nil
+	}
+	if c.stopped {
+		return "", ErrStop
+	}
+	for i, t := range c.transformers {
+		var err error
+		text, err = t.Chunk(text)
+		if errors.Is(err, ErrStop) {
+			c.stopped = true
+			tail, err := finish(c.transformers[i+1:], text, "stop")
+			if err != nil {
+				return "", err
+			}
+			return tail, ErrStop
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+	return text, nil
+}
+
+// Finish ends the completion and returns the text the transformers held back
+func (c *Chain) Finish(finishReason string) (string, error) {
+	if c == nil || c.stopped {
+		return "", nil
+	}
+	text, err := finish(c.transformers, "", finishReason)
+	if errors.Is(err, ErrStop) {
+		c.stopped = true
+		err = nil
+	}
+	return text, err
+}
+
+// finish passes text through transformers and finishes them, each after the
+// text held back by the ones before
+func finish(transformers []Transformer, text, finishReason string) (string, error) {
+	for i, t := range transformers {
+		var err error
+		if text != "" {
+			text, err = t.Chunk(text)
+			if errors.Is(err, ErrStop) {
+				tail, err := finish(transformers[i+1:], text, "stop")
+				if err != nil {
+					return "", err
+				}
+				return tail, ErrStop
+			}
+			if err != nil {
+				return "", err
+			}
+		}
+		rest, err := t.Finish(finishReason)
+		if err != nil {
+			return "", err
+		}
+		text += rest
+	}
+	return text, nil
+}
+
+// Stopped reports whether a transformer ended the completion early
+func (c *Chain) Stopped() bool {
+	return c != nil && c.stopped
+}
+
+// Apply transforms a whole completion. A stopped completion returns the
+// text kept and no error; see Stopped.
+func (c *Chain) Apply(text, finishReason string) (string, error) {
+	if c == nil {
+		return text, nil
+	}
+	text, err := c.Chunk(text)
+	if errors.Is(err, ErrStop) {
+		return text, nil
+	}
+	if err != nil {
+		return "", err
+	}
+	tail, err := c.Finish(finishReason)
+	if err != nil {
+		return "", err
+	}
+	return text + tail, nil
+}
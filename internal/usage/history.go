@@ -0,0 +1,143 @@
+package usage
+
+import (
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/devstroop/reai/internal/pricing"
+	"github.com/devstroop/reai/internal/store"
+)
+
+// DayFormat is the layout of the days of the history
+const DayFormat = "2006-01-02"
+
+// flushInterval bounds how often usage is written to the database
+const flushInterval = 30 * time.Second
+
+// Record is the usage of one key and model on one day (UTC). KeyID is
+// empty for requests made without an API key.
+type Record struct {
+	Day              string  `json:"day"`
+	KeyID            string  `json:"key_id"`
+	Model            string  `json:"model"`
+	Requests         int     `json:"requests"`
+	Errors           int     `json:"errors"`
+	PromptTokens     int     `json:"prompt_tokens"`
+	CompletionTokens int     `json:"completion_tokens"`
+	Cost             float64 `json:"cost"`
+}
+
+type recordKey struct {
+	day, keyID, model string
+}
+
+// History keeps the daily usage of every key and model in the database, for
+// reporting. Unlike the quota ledger it never expires. Usage is collected in
+// memory and added to the database every 30 seconds.
+type History struct {
+	db      *store.DB
+	mutex   sync.Mutex
+	pending map[recordKey]*Record
+	flushed time.Time
+}
+
+// Open returns the usage history kept in db
+func Open(db *store.DB) *History {
+	return &History{db: db, pending: make(map[recordKey]*Record), flushed: time.Now()}
+}
+
+// Add counts a finished request of keyID to model at now
+func (h *History) Add(now time.Time, keyID, model string, promptTokens, completionTokens int, cost float64, failed bool) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	day := now.UTC().Format(DayFormat)
+	k := recordKey{day: day, keyID: keyID, model: model}
+	r, ok := h.pending[k]
+	if !ok {
+		r = &Record{Day: day, KeyID: keyID, Model: model}
+		h.pending[k] = r
+	}
+	r.Requests++
+	if failed {
+		r.Errors++
+	}
+	r.PromptTokens += promptTokens
+	r.CompletionTokens += completionTokens
+	r.Cost += cost
+
+	if now.Sub(h.flushed) >= flushInterval {
+		if err := h.flush(now); err != nil {
+			slog.Warn("Usage history not saved", "error", err)
+		}
+	}
+}
+
+// Flush adds the usage collected in memory to the database
+func (h *History) Flush() error {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return h.flush(time.Now())
+}
+
+// flush writes the pending usage. The caller holds the mutex.
+func (h *History) flush(now time.Time) error {
+	h.flushed = now
+	if len(h.pending) == 0 {
+		return nil
+	}
+	err := h.db.Update(func(tx *sql.Tx) error {
+		for _, r := range h.pending {
+			_, err := tx.Exec(`INSERT INTO usage_daily (day, key_id, model, requests, errors, prompt_tokens, completion_tokens, cost)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+ON CONFLICT (day, key_id, model) DO UPDATE SET
+	requests = requests + excluded.requests,
+	errors = errors + excluded.errors,
+	prompt_tokens = prompt_tokens + excluded.prompt_tokens,
+	completion_tokens = completion_tokens + excluded.completion_tokens,
+	cost = cost + excluded.cost`,
+				r.Day, r.KeyID, r.Model, r.Requests, r.Errors, r.PromptTokens, r.CompletionTokens, r.Cost)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to save usage history: %w", err)
+	}
+	clear(h.pending)
+	return nil
+}
+
+// Query returns the usage from day from to day to, both included and in
+// DayFormat, ordered by day, key and model. An empty keyID selects all keys.
+func (h *History) Query(from, to, keyID string) ([]Record, error) {
+	if err := h.Flush(); err != nil {
+		return nil, err
+	}
+	query := "SELECT day, key_id, model, requests, errors, prompt_tokens, completion_tokens, cost FROM usage_daily WHERE day >= ? AND day <= ?"
+	args := []interface{}{from, to}
+	if keyID != "" {
+		query += " AND key_id = ?"
+		args = append(args, keyID)
+	}
+	rows, err := h.db.Query(query+" ORDER BY day, key_id, model", args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read usage history: %w", err)
+	}
+	defer rows.Close()
+	records := []Record{}
+	for rows.Next() {
+		var r Record
+		if err := rows.Scan(&r.Day, &r.KeyID, &r.Model, &r.Requests, &r.Errors, &r.PromptTokens, &r.CompletionTokens, &r.Cost); err != nil {
+			return nil, fmt.Errorf("failed to read usage history: %w", err)
+		}
+		r.Cost = pricing.Round(r.Cost)
+		records = append(records, r)
+	}
+	return records, rows.Err()
+}
@@ -0,0 +1,127 @@
+package usage
+
+import (
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/devstroop/reai/internal/store"
+)
+
+const (
+	// recordSweepInterval bounds how long expired records linger
+	recordSweepInterval = time.Hour
+	// MaxRecordResults bounds the records a query returns
+	MaxRecordResults = 1000
+)
+
+// ResponseRecord is the server-side half of a response watermark: what was
+// generated for whom, so content found elsewhere can be attributed
+type ResponseRecord struct {
+	RequestID        string    `json:"request_id"`
+	Watermark        string    `json:"watermark"`
+	KeyHash          string    `json:"key_hash"`
+	Time             time.Time `json:"time"`
+	Path             string    `json:"path"`
+	Model            string    `json:"model"`
+	PromptTokens     int       `json:"prompt_tokens"`
+	CompletionTokens int       `json:"completion_tokens"`
+	CompletionSHA256 string    `json:"completion_sha256"`
+	FinishReason     string    `json:"finish_reason"`
+}
+
+// RecordFilter selects response records. Empty fields match everything.
+type RecordFilter struct {
+	RequestID        string
+	KeyHash          string
+	CompletionSHA256 string
+	From, To         time.Time
+	Limit            int
+}
+
+// Records keeps the response records in the database for a retention
+// period. Unlike the daily history they are written as each response
+// completes, since a record is only useful if it survives a crash.
+type Records struct {
+	db        *store.DB
+	retention time.Duration
+	now       func() time.Time
+	mutex     sync.Mutex
+	swept     time.Time
+}
+
+// OpenRecords returns the response records kept in db. Records older than
+// retention are deleted; a zero retention keeps them forever.
+func OpenRecords(db *store.DB, retention time.Duration) *Records {
+	return &Records{db: db, retention: retention, now: time.Now}
+}
+
+// Add saves a response record
+func (rs *Records) Add(r ResponseRecord) error {
+	_, err := rs.db.Exec(`INSERT OR REPLACE INTO usage_records
+(request_id, watermark, key_hash, time, path, model, prompt_tokens, completion_tokens, completion_sha256, finish_reason)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		r.RequestID, r.Watermark, r.KeyHash, r.Time.Unix(), r.Path, r.Model, r.PromptTokens, r.CompletionTokens, r.CompletionSHA256, r.FinishReason)
+	if err != nil {
+		return fmt.Errorf("failed to save usage record: %w", err)
+	}
+	if rs.retention > 0 {
+		rs.sweep(rs.now())
+	}
+	return nil
+}
+
+// sweep deletes the records past the retention period, at most once per
+// recordSweepInterval
+func (rs *Records) sweep(now time.Time) {
+	rs.mutex.Lock()
+	defer rs.mutex.Unlock()
+	if now.Sub(rs.swept) < recordSweepInterval {
+		return
+	}
+	rs.swept = now
+	if _, err := rs.db.Exec("DELETE FROM usage_records WHERE time < ?", now.Add(-rs.retention).Unix()); err != nil {
+		slog.Warn("Expired usage records not deleted", "error", err)
+	}
+}
+
+// Query returns the records matching filter, newest first
+func (rs *Records) Query(filter RecordFilter) ([]ResponseRecord, error) {
+	query := "SELECT request_id, watermark, key_hash, time, path, model, prompt_tokens, completion_tokens, completion_sha256, finish_reason FROM usage_records WHERE 1 = 1"
+	var args []interface{}
+	for column, value := range map[string]string{"request_id": filter.RequestID, "key_hash": filter.KeyHash, "completion_sha256": filter.CompletionSHA256} {
+		if value != "" {
+			query += " AND " + column + " = ?"
+			args = append(args, value)
+		}
+	}
+	if !filter.From.IsZero() {
+		query += " AND time >= ?"
+		args = append(args, filter.From.Unix())
+	}
+	if !filter.To.IsZero() {
+		query += " AND time <= ?"
+		args = append(args, filter.To.Unix())
+	}
+	limit := filter.Limit
+	if limit <= 0 || limit > MaxRecordResults {
+		limit = MaxRecordResults
+	}
+	rows, err := rs.db.Query(query+" ORDER BY time DESC, request_id LIMIT ?", append(args, limit)...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read usage records: %w", err)
+	}
+	defer rows.Close()
+	records := []ResponseRecord{}
+	for rows.Next() {
+		var r ResponseRecord
+		var unix int64
+		if err := rows.Scan(&r.RequestID, &r.Watermark, &r.KeyHash, &unix, &r.Path, &r.Model, &r.PromptTokens, &r.CompletionTokens, &r.CompletionSHA256, &r.FinishReason); err != nil {
+			return nil, fmt.Errorf("failed to read usage records: %w", err)
+		}
+		r.Time = time.Unix(unix, 0).UTC()
+		records = append(records, r)
+	}
+	return records, rows.Err()
+}
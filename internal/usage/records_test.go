@@ -0,0 +1,47 @@
+package usage
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/devstroop/reai/internal/store"
+)
+
+func TestRecords(t *testing.T) {
+	db, err := store.OpenMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	records := OpenRecords(db, 24*time.Hour)
+	records.now = func() time.Time { return now }
+	for i := 0; i < 3; i++ {
+		err := records.Add(ResponseRecord{
+			RequestID:        fmt.Sprintf("reai-%d", i),
+			KeyHash:          "kh",
+			Time:             now.Add(time.Duration(i-2) * 20 * time.Hour),
+			CompletionSHA256: "sum",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, err := records.Query(RecordFilter{KeyHash: "kh"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// reai-0 is 40 hours old, past the retention
+	if len(got) != 2 || got[0].RequestID != "reai-2" || got[1].RequestID != "reai-1" {
+		t.Errorf("records %+v", got)
+	}
+	if got, _ := records.Query(RecordFilter{CompletionSHA256: "sum", From: now.Add(-time.Hour), Limit: 5}); len(got) != 1 || !got[0].Time.Equal(now) {
+		t.Errorf("records since an hour ago %+v", got)
+	}
+	if got, _ := records.Query(RecordFilter{KeyHash: "other"}); len(got) != 0 {
+		t.Errorf("records of another key %+v", got)
+	}
+}
@@ -0,0 +1,97 @@
+package watermark
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Header carries the watermark on API responses
+const Header = "X-ReAI-Watermark"
+
+const version = "v1"
+
+// ErrInvalid is returned for malformed or forged watermarks
+var ErrInvalid = errors.New("invalid watermark")
+
+// Mark is the metadata embedded in a watermark
+type Mark struct {
+	RequestID string    `json:"request_id"`
+	KeyHash   string    `json:"key_hash"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// Signer creates and verifies watermarks with a server secret
+type Signer struct {
+	secret []byte
+}
+
+// New creates a signer. An empty secret disables watermarking (nil signer).
+func New(secret string) *Signer {
+	if secret == "" {
+		return nil
+	}
+	return &Signer{secret: []byte(secret)}
+}
+
+// KeyHash returns a keyed hash of an API key ID, so watermarks identify the
+// key to the operator without revealing it to anyone else
+func (s *Signer) KeyHash(keyID string) string {
+	if keyID == "" {
+		return "none"
+	}
+	return hex.EncodeToString(s.mac("key", keyID)[:8])
+}
+
+// Sign encodes a mark as "v1;rid=<request id>;kh=<key hash>;ts=<unix>;sig=<hmac>"
+func (s *Signer) Sign(m Mark) string {
+	payload := fmt.Sprintf("%s;rid=%s;kh=%s;ts=%d", version, m.RequestID, m.KeyHash, m.Timestamp.Unix())
+	return payload + ";sig=" + hex.EncodeToString(s.mac("mark", payload)[:16])
+}
+
+// Verify checks the signature of a watermark and returns its metadata
+func (s *Signer) Verify(value string) (Mark, error) {
+	payload, sig, ok := strings.Cut(strings.TrimSpace(value), ";sig=")
+	if !ok {
+		return Mark{}, ErrInvalid
+	}
+	expected := hex.EncodeToString(s.mac("mark", payload)[:16])
+	if !hmac.Equal([]byte(sig), []byte(expected)) {
+		return Mark{}, ErrInvalid
+	}
+
+	fields := strings.Split(payload, ";")
+	if len(fields) != 4 || fields[0] != version {
+		return Mark{}, ErrInvalid
+	}
+	var m Mark
+	for _, field := range fields[1:] {
+		name, value, _ := strings.Cut(field, "=")
+		switch name {
+		case "rid":
+			m.RequestID = value
+		case "kh":
+			m.KeyHash = value
+		case "ts":
+			unix, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return Mark{}, ErrInvalid
+			}
+			m.Timestamp = time.Unix(unix, 0).UTC()
+		default:
+			return Mark{}, ErrInvalid
+		}
+	}
+	return m, nil
+}
+
+func (s *Signer) mac(purpose, data string) []byte {
+	h := hmac.New(sha256.New, s.secret)
+	h.Write([]byte(purpose + ":" + data))
+	return h.Sum(nil)
+}
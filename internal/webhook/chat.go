@@ -0,0 +1,68 @@
+package webhook
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// Formats of the webhook body
+const (
+	// FormatEvent sends the Event as JSON (the default)
+	FormatEvent = "event"
+	// FormatSlack sends a message to a Slack incoming webhook
+	FormatSlack = "slack"
+	// FormatDiscord sends a message to a Discord webhook
+	FormatDiscord = "discord"
+)
+
+// hostname names the instance in chat messages
+var hostname, _ = os.Hostname()
+
+// chatBody renders event as the JSON body of a chat webhook in format
+func chatBody(format string, event Event) ([]byte, error) {
+	text := Message(event)
+	if hostname != "" {
+		text = fmt.Sprintf("[ReAI on %s] %s", hostname, text)
+	} else {
+		text = "[ReAI] " + text
+	}
+	if format == FormatDiscord {
+		return json.Marshal(map[string]string{"content": text})
+	}
+	return json.Marshal(map[string]string{"text": text})
+}
+
+// Message describes an event in a sentence for people
+func Message(event Event) string {
+	switch event.Type {
+	case EventAuthRequired:
+		return fmt.Sprintf("GitHub authentication required: open %v and enter the code %v before %s to keep serving requests.",
+			event.Data["verification_uri"], event.Data["user_code"], formatTime(event.Data["expires_at"]))
+	case EventTokenRefreshFailed:
+		return fmt.Sprintf("The Copilot session token could not be refreshed (%v). The current token expires at %s.",
+			event.Data["error"], formatTime(event.Data["expires_at"]))
+	case EventQuotaExceeded:
+		return fmt.Sprintf("API key %v used up its %v, which frees up at %s.",
+			event.Data["key_id"], event.Data["limit"], formatTime(event.Data["reset_at"]))
+	case EventUpstreamDown:
+		return fmt.Sprintf("Copilot is unreachable: %v", event.Data["error"])
+	case EventKeyCreated:
+		return fmt.Sprintf("API key %v was created.", event.Data["key_id"])
+	}
+	return event.Type
+}
+
+func formatTime(value interface{}) string {
+	switch t := value.(type) {
+	case time.Time:
+		return t.UTC().Format(time.RFC1123)
+	case *time.Time:
+		if t != nil {
+			return t.UTC().Format(time.RFC1123)
+		}
+		return "an unknown time"
+	}
+	return fmt.Sprint(value)
+}
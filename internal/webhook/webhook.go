@@ -0,0 +1,376 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/devstroop/reai/internal/metrics"
+)
+
+// Event types
+const (
+	// EventAuthRequired: a device flow is waiting for the user to enter a code
+	EventAuthRequired = "auth_required"
+	// EventTokenRefreshFailed: the session token could not be renewed ahead of its expiry
+	EventTokenRefreshFailed = "token_refresh_failed"
+	// EventQuotaExceeded: an API key used up a daily or monthly quota
+	EventQuotaExceeded = "quota_exceeded"
+	// EventUpstreamDown: Copilot stopped answering health checks
+	EventUpstreamDown = "upstream_down"
+	// EventKeyCreated: an API key was created through the admin API
+	EventKeyCreated = "key_created"
+)
+
+// Events lists the event types webhooks can subscribe to
+var Events = []string{EventAuthRequired, EventTokenRefreshFailed, EventQuotaExceeded, EventUpstreamDown, EventKeyCreated}
+
+// Defaults applied to webhooks that leave the field empty
+const (
+	DefaultTimeout   = 10 * time.Second
+	DefaultRetries   = 5
+	DefaultQueueSize = 100
+)
+
+// throttleInterval is how long an event about the same subject is not sent
+// again, so a client hammering a used up quota raises one event, not one per
+// request
+const throttleInterval = 10 * time.Minute
+
+// maxBackoff caps the wait between delivery attempts
+const maxBackoff = time.Minute
+
+var deliveries = metrics.NewCounterVec("reai_webhook_deliveries_total", "Webhook events by result (sent, failed, dropped)", "webhook", "result")
+
+// Config is the on-disk format of WEBHOOKS_FILE
+type Config struct {
+	Webhooks []Spec `json:"webhooks"`
+}
+
+// Spec configures one webhook
+type Spec struct {
+	Name string `json:"name"`
+	URL  string `json:"url"`
+	// Secret signs every delivery with HMAC-SHA256 (unsigned when empty)
+	Secret string `json:"secret,omitempty"`
+	// Format is event (default), or slack or discord to post a chat message
+	Format string `json:"format,omitempty"`
+	// Events the webhook receives (all when empty)
+	Events  []string          `json:"events,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Timeout string            `json:"timeout,omitempty"`
+	Retries *int              `json:"retries,omitempty"`
+}
+
+// Event is the JSON body POSTed to webhooks
+type Event struct {
+	ID      string                 `json:"id"`
+	Type    string                 `json:"type"`
+	Created int64                  `json:"created"`
+	Data    map[string]interface{} `json:"data"`
+}
+
+// hook delivers the events of one webhook in order
+type hook struct {
+	name    string
+	url     string
+	format  string
+	secret  []byte
+	events  map[string]bool
+	headers map[string]string
+	timeout time.Duration
+	retries int
+	queue   chan Event
+	client  *http.Client
+}
+
+// Dispatcher sends events to the configured webhooks without blocking the
+// caller. Events that don't fit in a webhook's queue are dropped.
+type Dispatcher struct {
+	hooks []*hook
+	done  chan struct{}
+	wg    sync.WaitGroup
+
+	mutex sync.Mutex
+	// sent is when an event was last sent per type and subject
+	sent map[string]time.Time
+}
+
+// Load reads the webhooks file at path and adds the extra webhooks to it.
+// Without either it returns a nil dispatcher, which is safe to use.
+func Load(path string, extra ...Spec) (*Dispatcher, error) {
+	var cfg Config
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read webhooks file: %w", err)
+		}
+		if err := json.Unmarshal(data, &cfg); err != nil {
+			return nil, fmt.Errorf("failed to parse webhooks file: %w", err)
+		}
+	}
+	cfg.Webhooks = append(cfg.Webhooks, extra...)
+	if len(cfg.Webhooks) == 0 {
+		return nil, nil
+	}
+	return New(cfg)
+}
+
+// New builds the webhooks of a configuration and starts delivering
+func New(cfg Config) (*Dispatcher, error) {
+	d := &Dispatcher{done: make(chan struct{}), sent: make(map[string]time.Time)}
+	names := make(map[string]bool)
+	for i, spec := range cfg.Webhooks {
+		h, err := newHook(spec)
+		if err != nil {
+			return nil, fmt.Errorf("webhook %d: %w", i+1, err)
+		}
+		if names[h.name] {
+			return nil, fmt.Errorf("duplicate webhook name %q", h.name)
+		}
+		names[h.name] = true
+		d.hooks = append(d.hooks, h)
+	}
+
+	for _, h := range d.hooks {
+		d.wg.Add(1)
+		go func(h *hook) {
+			defer d.wg.Done()
+			h.run(d.done)
+		}(h)
+	}
+	return d, nil
+}
+
+func newHook(spec Spec) (*hook, error) {
+	if spec.Name == "" {
+		return nil, fmt.Errorf("name is required")
+	}
+	u, err := url.Parse(spec.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("url must be an http or https URL")
+	}
+
+	h := &hook{
+		name:    spec.Name,
+		url:     spec.URL,
+		format:  spec.Format,
+		secret:  []byte(spec.Secret),
+		headers: spec.Headers,
+		timeout: DefaultTimeout,
+		retries: DefaultRetries,
+		queue:   make(chan Event, DefaultQueueSize),
+		client:  &http.Client{},
+	}
+	switch h.format {
+	case "":
+		h.format = FormatEvent
+	case FormatEvent, FormatSlack, FormatDiscord:
+	default:
+		return nil, fmt.Errorf("unknown format %q (want event, slack or discord)", h.format)
+	}
+	if spec.Retries != nil && *spec.Retries >= 0 {
+		h.retries = *spec.Retries
+	}
+	if spec.Timeout != "" {
+		d, err := time.ParseDuration(spec.Timeout)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("timeout: invalid duration %q", spec.Timeout)
+		}
+		h.timeout = d
+	}
+	if len(spec.Events) > 0 {
+		h.events = make(map[string]bool)
+		for _, event := range spec.Events {
+			if !known(event) {
+				return nil, fmt.Errorf("unknown event %q", event)
+			}
+			h.events[event] = true
+		}
+	}
+	return h, nil
+}
+
+func known(event string) bool {
+	for _, e := range Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// Len returns the number of configured webhooks
+func (d *Dispatcher) Len() int {
+	if d == nil {
+		return 0
+	}
+	return len(d.hooks)
+}
+
+// Publish queues an event for the webhooks subscribed to its type. An event
+// of the same type and subject sent within the last 10 minutes is dropped.
+// It never blocks.
+func (d *Dispatcher) Publish(eventType, subject string, data map[string]interface{}) {
+	if d == nil {
+		return
+	}
+
+	now := time.Now()
+	d.mutex.Lock()
+	throttleKey := eventType + "\x00" + subject
+	if last, ok := d.sent[throttleKey]; ok && now.Sub(last) < throttleInterval {
+		d.mutex.Unlock()
+		return
+	}
+	d.sent[throttleKey] = now
+	for key, last := range d.sent {
+		if now.Sub(last) >= throttleInterval {
+			delete(d.sent, key)
+		}
+	}
+	d.mutex.Unlock()
+
+	id := make([]byte, 12)
+	rand.Read(id)
+	event := Event{ID: "evt_" + hex.EncodeToString(id), Type: eventType, Created: now.Unix(), Data: data}
+	for _, h := range d.hooks {
+		if h.events != nil && !h.events[eventType] {
+			continue
+		}
+		select {
+		case h.queue <- event:
+		default:
+			deliveries.With(h.name, "dropped").Inc()
+			slog.Warn("Webhook queue full, dropping event", "webhook", h.name, "event", eventType)
+		}
+	}
+}
+
+// Close delivers the queued events, waiting at most until ctx is done
+func (d *Dispatcher) Close(ctx context.Context) error {
+	if d == nil {
+		return nil
+	}
+	close(d.done)
+
+	delivered := make(chan struct{})
+	go func() {
+		d.wg.Wait()
+		close(delivered)
+	}()
+	select {
+	case <-delivered:
+	case <-ctx.Done():
+		slog.Warn("Webhooks did not deliver in time, dropping queued events")
+	}
+	return nil
+}
+
+// run delivers events until done is closed, then delivers what is queued
+func (h *hook) run(done <-chan struct{}) {
+	for {
+		select {
+		case event := <-h.queue:
+			h.deliver(event)
+		case <-done:
+			for {
+				select {
+				case event := <-h.queue:
+					h.deliver(event)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// deliver sends an event, retrying with exponential backoff while the
+// failure may be temporary
+func (h *hook) deliver(event Event) {
+	var body []byte
+	var err error
+	if h.format == FormatEvent {
+		body, err = json.Marshal(event)
+	} else {
+		body, err = chatBody(h.format, event)
+	}
+	if err != nil {
+		deliveries.With(h.name, "failed").Inc()
+		return
+	}
+
+	for attempt := 0; attempt <= h.retries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(min(time.Second<<(attempt-1), maxBackoff))
+		}
+		var retry bool
+		if retry, err = h.send(event, body); err == nil {
+			deliveries.With(h.name, "sent").Inc()
+			return
+		}
+		slog.Warn("Webhook delivery failed", "webhook", h.name, "event", event.Type, "attempt", attempt+1, "error", err)
+		if !retry {
+			break
+		}
+	}
+	deliveries.With(h.name, "failed").Inc()
+	slog.Error("Webhook dropped an event", "webhook", h.name, "event", event.Type, "id", event.ID, "error", err)
+}
+
+// send POSTs an event once. retry reports whether the failure may go away.
+func (h *hook) send(event Event, body []byte) (retry bool, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), h.timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range h.headers {
+		req.Header.Set(name, value)
+	}
+	req.Header.Set("X-ReAI-Event", event.Type)
+	req.Header.Set("X-ReAI-Delivery", event.ID)
+	if len(h.secret) > 0 {
+		req.Header.Set("X-ReAI-Signature", Sign(h.secret, time.Now(), body))
+	}
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		text, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		retry = resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusRequestTimeout
+		return retry, fmt.Errorf("HTTP %d: %s", resp.StatusCode, bytes.TrimSpace(text))
+	}
+	io.Copy(io.Discard, resp.Body)
+	return false, nil
+}
+
+// Sign returns the X-ReAI-Signature of a body sent at t: the unix time and
+// the hex HMAC-SHA256 of "<time>.<body>", as "t=<time>,v1=<hmac>"
+func Sign(secret []byte, t time.Time, body []byte) string {
+	timestamp := strconv.FormatInt(t.Unix(), 10)
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "t=" + timestamp + ",v1=" + hex.EncodeToString(mac.Sum(nil))
+}
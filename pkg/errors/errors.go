@@ -3,7 +3,10 @@ package errors
 import (
 	"encoding/json"
 	"fmt"
+	"math"
 	"net/http"
+	"strconv"
+	"time"
 )
 
 // APIError represents different types of API errors
@@ -11,6 +14,11 @@ type APIError struct {
 	Type    string `json:"type"`
 	Message string `json:"message"`
 	Code    int    `json:"code"`
+
+	// RetryAfter tells the client when to try again (0 when unknown)
+	RetryAfter time.Duration `json:"-"`
+	// Header holds upstream response headers passed on to the client
+	Header http.Header `json:"-"`
 }
 
 // Error implements the error interface
@@ -30,6 +38,7 @@ var (
 	ErrIO             = &APIError{Type: "io_error", Message: "File operation failed", Code: http.StatusInternalServerError}
 	ErrJWT            = &APIError{Type: "jwt_error", Message: "Token validation failed", Code: http.StatusUnauthorized}
 	ErrInternal       = &APIError{Type: "internal_error", Message: "Internal server error", Code: http.StatusInternalServerError}
+	ErrDeadline       = &APIError{Type: "deadline_exceeded", Message: "Request deadline exceeded", Code: http.StatusGatewayTimeout}
 )
 
 // NewAuthenticationError creates a new authentication error with custom message
@@ -59,6 +68,33 @@ func NewCopilotAPIError(message string) *APIError {
 	}
 }
 
+// NewProviderError creates a new backend provider error with custom message
+func NewProviderError(message string) *APIError {
+	return &APIError{
+		Type:    "provider_error",
+		Message: fmt.Sprintf("Upstream provider error: %s", message),
+		Code:    http.StatusBadGateway,
+	}
+}
+
+// NewInvalidRequestError creates a new OpenAI style invalid request error with custom message
+func NewInvalidRequestError(message string) *APIError {
+	return &APIError{
+		Type:    "invalid_request_error",
+		Message: message,
+		Code:    http.StatusBadRequest,
+	}
+}
+
+// NewRequestTooLargeError creates a new error for request bodies over the size limit
+func NewRequestTooLargeError(message string) *APIError {
+	return &APIError{
+		Type:    "invalid_request_error",
+		Message: message,
+		Code:    http.StatusRequestEntityTooLarge,
+	}
+}
+
 // NewInternalError creates a new internal error with custom message
 func NewInternalError(message string) *APIError {
 	return &APIError{
@@ -68,8 +104,98 @@ func NewInternalError(message string) *APIError {
 	}
 }
 
+// NewRateLimitError creates a new rate limit error with custom message
+func NewRateLimitError(message string) *APIError {
+	return &APIError{
+		Type:    "rate_limit",
+		Message: fmt.Sprintf("Rate limit exceeded: %s", message),
+		Code:    http.StatusTooManyRequests,
+	}
+}
+
+// NewUpstreamRateLimitError creates a new error for a request the upstream
+// rate limited, passing on when to retry and its rate limit headers
+func NewUpstreamRateLimitError(message string, retryAfter time.Duration, header http.Header) *APIError {
+	return &APIError{
+		Type:       "rate_limit",
+		Message:    fmt.Sprintf("Rate limit exceeded: %s", message),
+		Code:       http.StatusTooManyRequests,
+		RetryAfter: retryAfter,
+		Header:     header,
+	}
+}
+
+// NewQuotaExceededError creates a new error for a used up daily or monthly quota
+func NewQuotaExceededError(message string) *APIError {
+	return &APIError{
+		Type:    "quota_exceeded",
+		Message: message,
+		Code:    http.StatusTooManyRequests,
+	}
+}
+
+// NewServiceUnavailableError creates a new service unavailable error with custom message
+func NewServiceUnavailableError(message string) *APIError {
+	return &APIError{
+		Type:    "service_unavailable",
+		Message: message,
+		Code:    http.StatusServiceUnavailable,
+	}
+}
+
+// NewOverloadedError creates a new error for requests shed under load
+func NewOverloadedError(message string) *APIError {
+	return &APIError{
+		Type:    "overloaded",
+		Message: message,
+		Code:    http.StatusServiceUnavailable,
+	}
+}
+
+// NewContentFilterError creates a new content filter error with custom message
+func NewContentFilterError(message string) *APIError {
+	return &APIError{
+		Type:    "content_filter",
+		Message: fmt.Sprintf("Content rejected: %s", message),
+		Code:    http.StatusBadRequest,
+	}
+}
+
+// NewContextLengthError creates a new error for requests that don't fit the model context window
+func NewContextLengthError(message string) *APIError {
+	return &APIError{
+		Type:    "context_length_exceeded",
+		Message: message,
+		Code:    http.StatusBadRequest,
+	}
+}
+
+// NewModelNotSupportedError creates a new error for requests no model of ReAI can serve
+func NewModelNotSupportedError(message string) *APIError {
+	return &APIError{
+		Type:    "model_not_supported",
+		Message: message,
+		Code:    http.StatusBadRequest,
+	}
+}
+
+// NewDeadlineExceededError creates a new deadline exceeded error with custom message
+func NewDeadlineExceededError(message string) *APIError {
+	return &APIError{
+		Type:    "deadline_exceeded",
+		Message: fmt.Sprintf("Request deadline exceeded: %s", message),
+		Code:    http.StatusGatewayTimeout,
+	}
+}
+
 // WriteErrorResponse writes an error response to the HTTP response writer
 func WriteErrorResponse(w http.ResponseWriter, err *APIError) {
+	for name, values := range err.Header {
+		w.Header()[name] = values
+	}
+	if err.RetryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(err.RetryAfter.Seconds()))))
+	}
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(err.Code)
 	
@@ -0,0 +1,153 @@
+package reai_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+
+	"github.com/devstroop/reai/pkg/reai"
+)
+
+// echo is a provider repeating the last message back, word by word
+type echo struct{}
+
+func (echo) Name() string {
+	return "echo"
+}
+
+func (echo) StreamCompletion(ctx context.Context, req *reai.CompletionRequest, onChunk func(reai.CompletionChunk) error) error {
+	last := req.Messages[len(req.Messages)-1].Content
+	for _, word := range strings.SplitAfter(last, " ") {
+		if err := onChunk(reai.CompletionChunk{Text: word}); err != nil {
+			return err
+		}
+	}
+	return onChunk(reai.CompletionChunk{FinishReason: reai.FinishReasonStop})
+}
+
+func (echo) Models(ctx context.Context) ([]reai.ModelInfo, error) {
+	return []reai.ModelInfo{{ID: "echo-1", OwnedBy: "example"}}, nil
+}
+
+func (echo) ModelLimits(ctx context.Context, model string) *reai.ModelLimits {
+	return nil
+}
+
+// chat posts a chat completion to the server at url and returns the reply
+func chat(url, content string) string {
+	body := fmt.Sprintf(`{"model":"echo-1","messages":[{"role":"user","content":%q}]}`, content)
+	resp, err := http.Post(url+"/v1/chat/completions", "application/json", strings.NewReader(body))
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var completion struct {
+		Choices []struct {
+			Message struct {
+				Content string `json:"content"`
+			} `json:"message"`
+		} `json:"choices"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&completion); err != nil || len(completion.Choices) == 0 {
+		log.Fatalf("status %d: %v", resp.StatusCode, err)
+	}
+	return completion.Choices[0].Message.Content
+}
+
+// newServer creates a server with echo behind it, keeping its data in a
+// temporary directory
+func newServer() (*reai.Server, func()) {
+	dir, err := os.MkdirTemp("", "reai-example")
+	if err != nil {
+		log.Fatal(err)
+	}
+	os.Setenv("DATA_DIR", dir)
+	cfg := reai.LoadConfig()
+	client, err := reai.NewClient(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	server, err := reai.NewServerWithUpstream(cfg, client, echo{})
+	if err != nil {
+		log.Fatal(err)
+	}
+	return server, func() {
+		server.Close(context.Background())
+		os.RemoveAll(dir)
+	}
+}
+
+func ExampleNewServerWithUpstream() {
+	server, done := newServer()
+	defer done()
+	ts := httptest.NewServer(server.Router())
+	defer ts.Close()
+
+	fmt.Println(chat(ts.URL, "hello from the echo provider"))
+	// Output: hello from the echo provider
+}
+
+func ExampleServer_AddOutputHook() {
+	server, done := newServer()
+	defer done()
+	server.AddOutputHook(reai.OutputHookFunc(func(info reai.OutputInfo) reai.OutputTransformer {
+		if !info.Chat {
+			return nil
+		}
+		return shouting{}
+	}))
+	ts := httptest.NewServer(server.Router())
+	defer ts.Close()
+
+	fmt.Println(chat(ts.URL, "quiet please"))
+	// Output: QUIET PLEASE
+}
+
+// shouting upper-cases the text of completions
+type shouting struct{}
+
+func (shouting) Chunk(text string) (string, error) {
+	return strings.ToUpper(text), nil
+}
+
+func (shouting) Finish(string) (string, error) {
+	return "", nil
+}
+
+func ExampleServer_Use() {
+	server, done := newServer()
+	defer done()
+	server.Use("tenant", func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-Tenant", "example")
+			next.ServeHTTP(w, r)
+		})
+	})
+	ts := httptest.NewServer(server.Router())
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/health/live")
+	if err != nil {
+		log.Fatal(err)
+	}
+	resp.Body.Close()
+	fmt.Println(resp.Header.Get("X-Tenant"))
+	// Output: example
+}
+
+func ExampleCollect() {
+	req := &reai.CompletionRequest{Messages: []reai.Message{{Role: "user", Content: "collect these words"}}}
+	result, err := reai.Collect(func(onChunk func(reai.CompletionChunk) error) error {
+		return echo{}.StreamCompletion(context.Background(), req, onChunk)
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("%q %s\n", result.Text, result.FinishReason)
+	// Output: "collect these words" stop
+}
@@ -0,0 +1,61 @@
+package reai
+
+import "github.com/devstroop/reai/internal/transform"
+
+// ErrOutputStop is returned by OutputTransformer.Chunk, along with the text
+// to keep, to end the completion there
+var ErrOutputStop = transform.ErrStop
+
+// OutputInfo describes the completion an output hook is started for
+type OutputInfo struct {
+	// ID is the response ID
+	ID    string
+	Model string
+	// KeyID is the API key of the caller (empty without authentication)
+	KeyID string
+	// Chat is set for chat completions; code completions leave it unset
+	Chat bool
+	// Prompt is the text before the cursor of a code completion
+	Prompt string
+	// Language is the language of the file being completed, when known
+	Language string
+}
+
+// OutputHook transforms the output of completions, see Server.AddOutputHook
+type OutputHook interface {
+	// Start returns the transformer of one completion, nil to leave it alone
+	Start(info OutputInfo) OutputTransformer
+}
+
+// OutputTransformer transforms the text of one completion. A buffered
+// completion is passed to Chunk in one piece.
+type OutputTransformer interface {
+	// Chunk transforms the next piece of text. It may hold text back by
+	// returning less, and release it with a later chunk or Finish. Returning
+	// ErrOutputStop ends the completion after the text returned with it;
+	// Finish is not called then.
+	Chunk(text string) (string, error)
+	// Finish is called once the completion ended without error, with its
+	// finish reason, and returns the text still held back
+	Finish(finishReason string) (string, error)
+}
+
+// OutputHookFunc adapts a function to an OutputHook
+type OutputHookFunc func(info OutputInfo) OutputTransformer
+
+// Start calls f
+func (f OutputHookFunc) Start(info OutputInfo) OutputTransformer {
+	return f(info)
+}
+
+// outputHook serves an OutputHook to the server
+type outputHook struct {
+	hook OutputHook
+}
+
+func (h outputHook) Start(info transform.Info) transform.Transformer {
+	if t := h.hook.Start(OutputInfo(info)); t != nil {
+		return t
+	}
+	return nil
+}
@@ -0,0 +1,286 @@
+// Package reai embeds ReAI in other Go programs: the Copilot client, the
+// provider interface and the OpenAI compatible API server.
+//
+// A minimal gateway:
+//
+//	cfg := reai.LoadConfig()
+//	client, err := reai.NewClient(cfg)
+//	if err != nil { ... }
+//	server, err := reai.NewServer(cfg, client)
+//	if err != nil { ... }
+//	defer server.Close(context.Background())
+//	http.ListenAndServe(":8080", server.Router())
+//
+// The types of this package are its own and follow the module's version;
+// the server's internal types may change between releases without
+// breaking programs built on them.
+package reai
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/devstroop/reai/internal/api"
+	"github.com/devstroop/reai/internal/config"
+	"github.com/devstroop/reai/internal/copilot"
+	"github.com/devstroop/reai/internal/provider"
+)
+
+// Config holds the server and client settings, read from the environment
+// variables documented in the README by LoadConfig
+type Config struct {
+	config *config.Config
+}
+
+// LoadConfig reads the configuration from the environment
+func LoadConfig() *Config {
+	return &Config{config: config.LoadFromEnv()}
+}
+
+// Port is the port the server is configured to listen on
+func (c *Config) Port() int {
+	return c.config.Port
+}
+
+// Provider is an upstream serving completions. Client is one; implement it
+// to put another backend behind the server (see NewServerWithUpstream).
+// Providers that drop some request parameters can also implement
+// UnsupportedParameters(*CompletionRequest) []string to name them.
+type Provider interface {
+	// Name identifies the provider in logs, metrics and the x_reai backend field
+	Name() string
+	// StreamCompletion calls onChunk for every piece of the completion as it
+	// arrives and stops when onChunk returns an error
+	StreamCompletion(ctx context.Context, req *CompletionRequest, onChunk func(CompletionChunk) error) error
+	// Models lists the models the provider serves
+	Models(ctx context.Context) ([]ModelInfo, error)
+	// ModelLimits returns the token limits of a model, or nil when unknown
+	ModelLimits(ctx context.Context, model string) *ModelLimits
+}
+
+// Client talks to GitHub Copilot: authentication, session tokens, models and
+// completions
+type Client struct {
+	client *copilot.Client
+}
+
+// NewClient creates a Copilot client. It does not authenticate;
+// Client.Authenticate runs the device flow when no token is stored.
+func NewClient(cfg *Config) (*Client, error) {
+	client, err := copilot.NewClient(cfg.config)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{client: client}, nil
+}
+
+// Authenticate makes sure the client holds a Copilot session token, running
+// the device flow when no GitHub token is stored
+func (c *Client) Authenticate(ctx context.Context) error {
+	return c.client.GetSessionToken(ctx)
+}
+
+// CheckAuth reports whether the client is authenticated, without ever
+// starting a device flow
+func (c *Client) CheckAuth(ctx context.Context) error {
+	return c.client.CheckAuth(ctx)
+}
+
+// Name is "copilot"
+func (c *Client) Name() string {
+	return c.client.Name()
+}
+
+// StreamCompletion gets a completion from Copilot, calling onChunk for every
+// streamed piece as it arrives. Returning an error from onChunk aborts the
+// stream.
+func (c *Client) StreamCompletion(ctx context.Context, req *CompletionRequest, onChunk func(CompletionChunk) error) error {
+	return c.client.StreamCompletion(ctx, toCopilotRequest(req), func(chunk copilot.CompletionChunk) error {
+		return onChunk(fromCopilotChunk(chunk))
+	})
+}
+
+// Complete gets a whole completion from Copilot
+func (c *Client) Complete(ctx context.Context, req *CompletionRequest) (*CompletionResult, error) {
+	return Collect(func(onChunk func(CompletionChunk) error) error {
+		return c.StreamCompletion(ctx, req, onChunk)
+	})
+}
+
+// Models lists the models Copilot serves
+func (c *Client) Models(ctx context.Context) ([]ModelInfo, error) {
+	models, err := c.client.GetAvailableModels(ctx)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]ModelInfo, 0, len(models))
+	for _, m := range models {
+		out = append(out, fromCopilotModel(m))
+	}
+	return out, nil
+}
+
+// ModelLimits returns the token limits of a Copilot model, or nil when unknown
+func (c *Client) ModelLimits(ctx context.Context, model string) *ModelLimits {
+	limits := c.client.GetModelLimits(ctx, model)
+	if limits == nil {
+		return nil
+	}
+	out := ModelLimits(*limits)
+	return &out
+}
+
+// UnsupportedParameters names the parameters of req Copilot drops
+func (c *Client) UnsupportedParameters(req *CompletionRequest) []string {
+	return c.client.UnsupportedParameters(toCopilotRequest(req))
+}
+
+// Collect assembles the chunks of a streamed completion
+func Collect(stream func(onChunk func(CompletionChunk) error) error) (*CompletionResult, error) {
+	result, err := copilot.Collect(func(onChunk func(copilot.CompletionChunk) error) error {
+		return stream(func(chunk CompletionChunk) error {
+			return onChunk(toCopilotChunk(chunk))
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return fromCopilotResult(result), nil
+}
+
+// upstream serves a Provider to the server
+type upstream struct {
+	provider Provider
+}
+
+func (u upstream) Name() string {
+	return u.provider.Name()
+}
+
+func (u upstream) StreamCompletion(ctx context.Context, req *copilot.CompletionRequest, onChunk func(copilot.CompletionChunk) error) error {
+	return u.provider.StreamCompletion(ctx, fromCopilotRequest(req), func(chunk CompletionChunk) error {
+		return onChunk(toCopilotChunk(chunk))
+	})
+}
+
+func (u upstream) GetAvailableModels(ctx context.Context) ([]copilot.ModelInfo, error) {
+	models, err := u.provider.Models(ctx)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]copilot.ModelInfo, 0, len(models))
+	for _, m := range models {
+		out = append(out, toCopilotModel(m))
+	}
+	return out, nil
+}
+
+func (u upstream) GetModelLimits(ctx context.Context, model string) *copilot.ModelLimits {
+	limits := u.provider.ModelLimits(ctx, model)
+	if limits == nil {
+		return nil
+	}
+	out := copilot.ModelLimits(*limits)
+	return &out
+}
+
+func (u upstream) UnsupportedParameters(req *copilot.CompletionRequest) []string {
+	if p, ok := u.provider.(interface {
+		UnsupportedParameters(*CompletionRequest) []string
+	}); ok {
+		return p.UnsupportedParameters(fromCopilotRequest(req))
+	}
+	return nil
+}
+
+// Middleware wraps the handlers of every endpoint, see Server.Use
+type Middleware func(http.Handler) http.Handler
+
+// Server is the OpenAI compatible API server
+type Server struct {
+	server *api.Server
+}
+
+// NewServer creates the API server on top of client. Serve Server.Router
+// and start the background work the configuration enables with
+// Server.RunProbes, Server.RunBatches and Server.RunWarmPool; call
+// Server.Drain and Server.Close on shutdown.
+func NewServer(cfg *Config, client *Client) (*Server, error) {
+	return NewServerWithUpstream(cfg, client, nil)
+}
+
+// NewServerWithUpstream creates the API server with upstream serving the
+// models Copilot would. client still handles authentication and the admin
+// endpoints. A nil upstream serves them from client.
+func NewServerWithUpstream(cfg *Config, client *Client, upstream Provider) (*Server, error) {
+	server, err := api.NewServerWithUpstream(cfg.config, client.client, toProvider(upstream))
+	if err != nil {
+		return nil, err
+	}
+	return &Server{server: server}, nil
+}
+
+// toProvider converts upstream for the server, nil for the configured one
+func toProvider(p Provider) provider.Provider {
+	switch p := p.(type) {
+	case nil:
+		return nil
+	case *Client:
+		return p.client
+	default:
+		return upstream{provider: p}
+	}
+}
+
+// Router returns the handler of the API. The management endpoints are left
+// to InternalRouter when INTERNAL_ADDR is set.
+func (s *Server) Router() http.Handler {
+	return s.server.Router()
+}
+
+// InternalRouter returns the handler of the INTERNAL_ADDR listener: health,
+// metrics, admin and debug endpoints
+func (s *Server) InternalRouter() http.Handler {
+	return s.server.InternalRouter()
+}
+
+// Use adds a middleware to the chain every endpoint runs through, after the
+// built-in ones unless MIDDLEWARE_ORDER places it. Call it before Router.
+func (s *Server) Use(name string, middleware Middleware) {
+	s.server.Use(name, api.Middleware(middleware))
+}
+
+// AddOutputHook adds a hook transforming the text of every completion,
+// after the content filters and the hooks added before it
+func (s *Server) AddOutputHook(hook OutputHook) {
+	s.server.AddOutputHook(outputHook{hook: hook})
+}
+
+// RunProbes runs the synthetic probes of PROBES_FILE until ctx is done
+func (s *Server) RunProbes(ctx context.Context) {
+	s.server.RunProbes(ctx)
+}
+
+// RunBatches processes queued batches until ctx is done, when
+// BATCHES_ENABLED is set
+func (s *Server) RunBatches(ctx context.Context) {
+	s.server.RunBatches(ctx)
+}
+
+// RunWarmPool keeps upstream connections warm for the models in WARM_MODELS
+// until ctx is done
+func (s *Server) RunWarmPool(ctx context.Context) {
+	s.server.RunWarmPool(ctx)
+}
+
+// Drain stops accepting API requests and waits until the ones in flight
+// have finished or ctx is done
+func (s *Server) Drain(ctx context.Context) error {
+	return s.server.Drain(ctx)
+}
+
+// Close releases the resources held by the server, giving the analytics
+// sinks until ctx is done to deliver queued records
+func (s *Server) Close(ctx context.Context) error {
+	return s.server.Close(ctx)
+}
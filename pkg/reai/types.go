@@ -0,0 +1,273 @@
+package reai
+
+import (
+	"encoding/json"
+
+	"github.com/devstroop/reai/internal/copilot"
+)
+
+// Finish reasons of a completion
+const (
+	FinishReasonStop          = "stop"
+	FinishReasonLength        = "length"
+	FinishReasonContentFilter = "content_filter"
+	FinishReasonToolCalls     = "tool_calls"
+)
+
+// CompletionRequest asks for a completion. With Messages it is a chat
+// request for Model; without, Prompt is completed as code.
+type CompletionRequest struct {
+	Model    string
+	Messages []Message
+	// Prompt is the text before the cursor of a code completion. The server
+	// also sets it on chat requests, as a flat rendering of the messages for
+	// providers that only take text.
+	Prompt string
+	// Suffix is the text after the cursor, for fill-in-the-middle completions
+	Suffix string
+	// Language is the language of the file being completed, when known
+	Language string
+
+	// MaxTokens limits the length of the completion (0 for the default)
+	MaxTokens int
+	// Stop ends the completion at any of these strings
+	Stop []string
+	// Sampling parameters, nil when not set
+	Temperature      *float64
+	TopP             *float64
+	PresencePenalty  *float64
+	FrequencyPenalty *float64
+	Seed             *int64
+	// Logprobs requests log probabilities for the most likely N tokens
+	Logprobs *int
+	// ReasoningEffort is passed to reasoning models ("low", "medium", ...)
+	ReasoningEffort string
+	// User identifies the end user
+	User string
+
+	// Tools are the functions the model of a chat request may call.
+	// ToolChoice is the client's tool_choice, passed through as it is.
+	Tools             []Tool
+	ToolChoice        json.RawMessage
+	ParallelToolCalls *bool
+}
+
+// Message is a chat message. Assistant messages may carry the tool calls
+// the model made; tool messages answer one of them by ToolCallID.
+type Message struct {
+	Role       string
+	Content    string
+	Name       string
+	ToolCalls  []ToolCall
+	ToolCallID string
+}
+
+// ToolCall is a call the model made to one of the request's tools
+type ToolCall struct {
+	ID        string
+	Name      string
+	Arguments string
+}
+
+// ToolCallDelta is a streamed piece of a tool call. Index tells the calls of
+// a reply apart; ID and Name come with the first piece, Arguments in parts.
+type ToolCallDelta struct {
+	Index     int
+	ID        string
+	Name      string
+	Arguments string
+}
+
+// Tool is a function the model may call, Parameters its JSON schema
+type Tool struct {
+	Name        string
+	Description string
+	Parameters  json.RawMessage
+	Strict      *bool
+}
+
+// Logprobs holds the log probabilities of the tokens of a completion
+type Logprobs struct {
+	Tokens        []string
+	TokenLogprobs []float64
+	TopLogprobs   []map[string]float64
+	TextOffset    []int
+}
+
+// CompletionChunk is one streamed piece of a completion. FinishReason is
+// only set on the chunk that ends it.
+type CompletionChunk struct {
+	Text string
+	// Reasoning is thinking text of models that stream it apart from the answer
+	Reasoning    string
+	ToolCalls    []ToolCallDelta
+	Logprobs     *Logprobs
+	FinishReason string
+}
+
+// CompletionResult is a fully assembled completion
+type CompletionResult struct {
+	Text         string
+	Reasoning    string
+	ToolCalls    []ToolCall
+	Logprobs     *Logprobs
+	FinishReason string
+}
+
+// ModelInfo describes a model a provider serves
+type ModelInfo struct {
+	ID      string
+	Name    string
+	Vendor  string
+	OwnedBy string
+	Preview bool
+}
+
+// ModelLimits are the token limits of a model, zero when unknown
+type ModelLimits struct {
+	MaxContextWindowTokens int
+	MaxOutputTokens        int
+	MaxPromptTokens        int
+}
+
+// toCopilotRequest converts a request for the internal client
+func toCopilotRequest(req *CompletionRequest) *copilot.CompletionRequest {
+	out := &copilot.CompletionRequest{
+		Model:             req.Model,
+		Prompt:            req.Prompt,
+		Suffix:            req.Suffix,
+		Language:          req.Language,
+		MaxTokens:         req.MaxTokens,
+		Stop:              req.Stop,
+		Temperature:       req.Temperature,
+		TopP:              req.TopP,
+		PresencePenalty:   req.PresencePenalty,
+		FrequencyPenalty:  req.FrequencyPenalty,
+		Seed:              req.Seed,
+		Logprobs:          req.Logprobs,
+		ReasoningEffort:   req.ReasoningEffort,
+		User:              req.User,
+		ToolChoice:        req.ToolChoice,
+		ParallelToolCalls: req.ParallelToolCalls,
+	}
+	for _, m := range req.Messages {
+		out.Messages = append(out.Messages, copilot.Message{
+			Role:       m.Role,
+			Content:    m.Content,
+			Name:       m.Name,
+			ToolCalls:  toCopilotToolCalls(m.ToolCalls),
+			ToolCallID: m.ToolCallID,
+		})
+	}
+	for _, t := range req.Tools {
+		out.Tools = append(out.Tools, copilot.Tool{Type: "function", Function: copilot.FunctionDefinition(t)})
+	}
+	return out
+}
+
+// fromCopilotRequest converts a request of the server for a provider
+func fromCopilotRequest(req *copilot.CompletionRequest) *CompletionRequest {
+	out := &CompletionRequest{
+		Model:             req.Model,
+		Prompt:            req.Prompt,
+		Suffix:            req.Suffix,
+		Language:          req.Language,
+		MaxTokens:         req.MaxTokens,
+		Stop:              req.Stop,
+		Temperature:       req.Temperature,
+		TopP:              req.TopP,
+		PresencePenalty:   req.PresencePenalty,
+		FrequencyPenalty:  req.FrequencyPenalty,
+		Seed:              req.Seed,
+		Logprobs:          req.Logprobs,
+		ReasoningEffort:   req.ReasoningEffort,
+		User:              req.User,
+		ToolChoice:        req.ToolChoice,
+		ParallelToolCalls: req.ParallelToolCalls,
+	}
+	for _, m := range req.Messages {
+		out.Messages = append(out.Messages, Message{
+			Role:       m.Role,
+			Content:    m.Content,
+			Name:       m.Name,
+			ToolCalls:  fromCopilotToolCalls(m.ToolCalls),
+			ToolCallID: m.ToolCallID,
+		})
+	}
+	for _, t := range req.Tools {
+		out.Tools = append(out.Tools, Tool(t.Function))
+	}
+	return out
+}
+
+func toCopilotToolCalls(calls []ToolCall) []copilot.ToolCall {
+	var out []copilot.ToolCall
+	for _, c := range calls {
+		out = append(out, copilot.ToolCall{ID: c.ID, Type: "function", Function: copilot.FunctionCall{Name: c.Name, Arguments: c.Arguments}})
+	}
+	return out
+}
+
+func fromCopilotToolCalls(calls []copilot.ToolCall) []ToolCall {
+	var out []ToolCall
+	for _, c := range calls {
+		out = append(out, ToolCall{ID: c.ID, Name: c.Function.Name, Arguments: c.Function.Arguments})
+	}
+	return out
+}
+
+// toCopilotChunk converts a chunk streamed by a provider for the server
+func toCopilotChunk(chunk CompletionChunk) copilot.CompletionChunk {
+	out := copilot.CompletionChunk{
+		Text:         chunk.Text,
+		Reasoning:    chunk.Reasoning,
+		FinishReason: chunk.FinishReason,
+	}
+	for _, d := range chunk.ToolCalls {
+		out.ToolCalls = append(out.ToolCalls, copilot.ToolCallDelta(d))
+	}
+	if chunk.Logprobs != nil {
+		logprobs := copilot.Logprobs(*chunk.Logprobs)
+		out.Logprobs = &logprobs
+	}
+	return out
+}
+
+// fromCopilotChunk converts a chunk streamed by the internal client
+func fromCopilotChunk(chunk copilot.CompletionChunk) CompletionChunk {
+	out := CompletionChunk{
+		Text:         chunk.Text,
+		Reasoning:    chunk.Reasoning,
+		FinishReason: chunk.FinishReason,
+	}
+	for _, d := range chunk.ToolCalls {
+		out.ToolCalls = append(out.ToolCalls, ToolCallDelta(d))
+	}
+	if chunk.Logprobs != nil {
+		logprobs := Logprobs(*chunk.Logprobs)
+		out.Logprobs = &logprobs
+	}
+	return out
+}
+
+func fromCopilotResult(result *copilot.CompletionResult) *CompletionResult {
+	out := &CompletionResult{
+		Text:         result.Text,
+		Reasoning:    result.Reasoning,
+		ToolCalls:    fromCopilotToolCalls(result.ToolCalls),
+		FinishReason: result.FinishReason,
+	}
+	if result.Logprobs != nil {
+		logprobs := Logprobs(*result.Logprobs)
+		out.Logprobs = &logprobs
+	}
+	return out
+}
+
+func toCopilotModel(m ModelInfo) copilot.ModelInfo {
+	return copilot.ModelInfo{ID: m.ID, Object: "model", OwnedBy: m.OwnedBy, Name: m.Name, Vendor: m.Vendor, Preview: m.Preview}
+}
+
+func fromCopilotModel(m copilot.ModelInfo) ModelInfo {
+	return ModelInfo{ID: m.ID, Name: m.Name, Vendor: m.Vendor, OwnedBy: m.OwnedBy, Preview: m.Preview}
+}
@@ -0,0 +1,57 @@
+package reai
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestRequestConversion(t *testing.T) {
+	temperature, seed, strict := 0.2, int64(7), true
+	req := &CompletionRequest{
+		Model: "gpt-4o",
+		Messages: []Message{
+			{Role: "user", Content: "weather?"},
+			{Role: "assistant", ToolCalls: []ToolCall{{ID: "call_1", Name: "weather", Arguments: `{"city":"Oslo"}`}}},
+			{Role: "tool", Content: "sunny", ToolCallID: "call_1"},
+		},
+		MaxTokens:       64,
+		Stop:            []string{"\n"},
+		Temperature:     &temperature,
+		Seed:            &seed,
+		ReasoningEffort: "low",
+		Tools:           []Tool{{Name: "weather", Parameters: json.RawMessage(`{"type":"object"}`), Strict: &strict}},
+		ToolChoice:      json.RawMessage(`"auto"`),
+	}
+	converted := toCopilotRequest(req)
+	if converted.Tools[0].Type != "function" || converted.Messages[1].ToolCalls[0].Type != "function" {
+		t.Errorf("tools not typed as functions: %+v", converted)
+	}
+	if back := fromCopilotRequest(converted); !reflect.DeepEqual(back, req) {
+		t.Errorf("round trip changed the request:\n%+v\n%+v", back, req)
+	}
+}
+
+func TestChunkConversion(t *testing.T) {
+	chunk := CompletionChunk{
+		Text:         "hi",
+		Reasoning:    "thinking",
+		ToolCalls:    []ToolCallDelta{{Index: 1, ID: "call_1", Name: "f", Arguments: "{"}},
+		Logprobs:     &Logprobs{Tokens: []string{"hi"}, TokenLogprobs: []float64{-0.5}},
+		FinishReason: FinishReasonToolCalls,
+	}
+	if back := fromCopilotChunk(toCopilotChunk(chunk)); !reflect.DeepEqual(back, chunk) {
+		t.Errorf("round trip changed the chunk:\n%+v\n%+v", back, chunk)
+	}
+
+	result, err := Collect(func(onChunk func(CompletionChunk) error) error {
+		onChunk(CompletionChunk{ToolCalls: []ToolCallDelta{{ID: "call_1", Name: "f", Arguments: `{"a":`}}})
+		return onChunk(CompletionChunk{ToolCalls: []ToolCallDelta{{Arguments: `1}`}}, FinishReason: FinishReasonToolCalls})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []ToolCall{{ID: "call_1", Name: "f", Arguments: `{"a":1}`}}; !reflect.DeepEqual(result.ToolCalls, want) || result.FinishReason != FinishReasonToolCalls {
+		t.Errorf("collected %+v", result)
+	}
+}